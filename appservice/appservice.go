@@ -59,12 +59,20 @@ func Create() *AppService {
 		SpecVersions: &mautrix.RespVersions{},
 
 		DefaultHTTPRetries: 4,
+
+		IntentSendRetries:      DefaultIntentSendRetries,
+		IntentSendRetryBackoff: DefaultIntentSendRetryBackoff,
 	}
 
 	as.Router.HandleFunc("/_matrix/app/v1/transactions/{txnID}", as.PutTransaction).Methods(http.MethodPut)
 	as.Router.HandleFunc("/_matrix/app/v1/rooms/{roomAlias}", as.GetRoom).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/app/v1/users/{userID}", as.GetUser).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/app/v1/ping", as.PostPing).Methods(http.MethodPost)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/protocol/{protocol}", as.GetThirdPartyProtocol).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/location/{protocol}", as.GetThirdPartyLocationByFields).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/location", as.GetThirdPartyLocationByAlias).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/user/{protocol}", as.GetThirdPartyUserByFields).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/user", as.GetThirdPartyUserByID).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/mau/live", as.GetLive).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/mau/ready", as.GetReady).Methods(http.MethodGet)
 
@@ -159,6 +167,9 @@ type AppService struct {
 	OTKCounts      chan *mautrix.OTKCount
 	QueryHandler   QueryHandler
 	StateStore     StateStore
+	// ThirdPartyHandler handles third-party protocol lookups from the homeserver.
+	// If nil, all third-party lookups will respond with M_NOT_FOUND.
+	ThirdPartyHandler ThirdPartyHandler
 
 	Router       *mux.Router
 	UserAgent    string
@@ -167,8 +178,16 @@ type AppService struct {
 	botClient    *mautrix.Client
 	botIntent    *IntentAPI
 	SpecVersions *mautrix.RespVersions
+	// ResponseCache is an optional cache shared by all clients created by this appservice.
+	ResponseCache *mautrix.ResponseCache
 
 	DefaultHTTPRetries int
+	// IntentSendRetries is the number of times [IntentAPI] methods that send events will retry
+	// after the HTTP-level retries are exhausted, if the error is transient (see [IsTransientSendError]).
+	// Every attempt reuses the same transaction ID, so retries can't cause the same event to be sent twice.
+	IntentSendRetries int
+	// IntentSendRetryBackoff is the initial delay between intent-level retries. It's doubled after each retry.
+	IntentSendRetryBackoff time.Duration
 
 	Live  bool
 	Ready bool
@@ -366,6 +385,7 @@ func (as *AppService) NewMautrixClient(userID id.UserID) *mautrix.Client {
 		Client:              as.HTTPClient,
 		DefaultHTTPRetries:  as.DefaultHTTPRetries,
 		SpecVersions:        as.SpecVersions,
+		ResponseCache:       as.ResponseCache,
 	}
 }
 
@@ -380,6 +400,7 @@ func (as *AppService) NewExternalMautrixClient(userID id.UserID, token string, h
 	client.SetAppServiceUserID = false
 	if homeserverURL != "" {
 		client.Client = &http.Client{Timeout: 180 * time.Second}
+		client.ResponseCache = nil
 		var err error
 		client.HomeserverURL, err = mautrix.ParseAndNormalizeBaseURL(homeserverURL)
 		if err != nil {
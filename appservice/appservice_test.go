@@ -7,9 +7,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestClient_UnixSocket(t *testing.T) {
@@ -40,3 +48,169 @@ func TestClient_UnixSocket(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "@joe:example.org", string(resp.UserID))
 }
+
+func TestIntentAPI_SendMessageEventRetry(t *testing.T) {
+	var lock sync.Mutex
+	var txnIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		txnIDs = append(txnIDs, r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:])
+		if len(txnIDs) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintln(w, `{"event_id": "$event"}`)
+	}))
+	defer ts.Close()
+
+	as := Create()
+	as.Registration = &Registration{}
+	as.HomeserverDomain = "example.org"
+	as.DefaultHTTPRetries = 0
+	as.IntentSendRetryBackoff = time.Millisecond
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	ctx := context.Background()
+	roomID := id.RoomID("!room:example.org")
+	intent := as.Intent("@user:example.org")
+	require.NoError(t, as.StateStore.SetMembership(ctx, roomID, intent.UserID, event.MembershipJoin))
+
+	resp, err := intent.SendText(ctx, roomID, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, id.EventID("$event"), resp.EventID)
+	require.Len(t, txnIDs, 3)
+	assert.Equal(t, txnIDs[0], txnIDs[1])
+	assert.Equal(t, txnIDs[0], txnIDs[2])
+}
+
+func TestIntentAPI_SendMessageEventNoRetryOnPermanentError(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, `{"errcode": "M_FORBIDDEN", "error": "nope"}`)
+	}))
+	defer ts.Close()
+
+	as := Create()
+	as.Registration = &Registration{}
+	as.HomeserverDomain = "example.org"
+	as.DefaultHTTPRetries = 0
+	as.IntentSendRetryBackoff = time.Millisecond
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	ctx := context.Background()
+	roomID := id.RoomID("!room:example.org")
+	intent := as.Intent("@user:example.org")
+	require.NoError(t, as.StateStore.SetMembership(ctx, roomID, intent.UserID, event.MembershipJoin))
+
+	_, err := intent.SendText(ctx, roomID, "hello")
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestAppService_SetupGhostProfiles(t *testing.T) {
+	var lock sync.Mutex
+	registered := map[string]bool{}
+	nameSet := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		userID := r.URL.Query().Get("user_id")
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/register"):
+			registered[userID] = true
+			fmt.Fprintln(w, `{}`)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/displayname"):
+			fmt.Fprintln(w, `{}`)
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/displayname"):
+			nameSet[userID] = true
+			fmt.Fprintln(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"errcode": "M_UNRECOGNIZED"}`)
+		}
+	}))
+	defer ts.Close()
+
+	as := Create()
+	as.Registration = &Registration{}
+	as.HomeserverDomain = "example.org"
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+
+	profiles := []GhostProfile{
+		{UserID: "@ghost1:example.org", DisplayName: "Ghost 1"},
+		{UserID: "@ghost2:example.org", DisplayName: "Ghost 2"},
+		{UserID: "@ghost3:example.org"},
+		{UserID: "@ghost4:other.example", DisplayName: "Ghost 4"},
+	}
+	var progressCalls []BulkProfileProgress
+	errs := as.SetupGhostProfiles(context.Background(), profiles, BulkProfileParams{
+		Concurrency: 2,
+		Interval:    time.Millisecond,
+		Progress: func(progress BulkProfileProgress) {
+			progressCalls = append(progressCalls, progress)
+		},
+	})
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs, id.UserID("@ghost4:other.example"))
+	require.Len(t, progressCalls, 4)
+	assert.Equal(t, BulkProfileProgress{Done: 4, Failed: 1, Total: 4}, progressCalls[3])
+	assert.True(t, registered["@ghost1:example.org"])
+	assert.True(t, registered["@ghost3:example.org"])
+	assert.True(t, nameSet["@ghost2:example.org"])
+	assert.False(t, nameSet["@ghost3:example.org"])
+}
+
+type testThirdPartyHandler struct{}
+
+func (th *testThirdPartyHandler) GetProtocol(ctx context.Context, protocol string) (*mautrix.ThirdPartyProtocol, error) {
+	if protocol != "irc" {
+		return nil, nil
+	}
+	return &mautrix.ThirdPartyProtocol{UserFields: []string{"network", "nickname"}}, nil
+}
+
+func (th *testThirdPartyHandler) QueryLocations(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyLocation, error) {
+	return nil, nil
+}
+
+func (th *testThirdPartyHandler) QueryUsers(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyUser, error) {
+	return []*mautrix.ThirdPartyUser{{
+		UserID:   id.NewUserID("_irc_"+fields["nickname"], "example.com"),
+		Protocol: protocol,
+		Fields:   fields,
+	}}, nil
+}
+
+func (th *testThirdPartyHandler) LookupLocationByAlias(ctx context.Context, alias id.RoomAlias) ([]*mautrix.ThirdPartyLocation, error) {
+	return nil, nil
+}
+
+func (th *testThirdPartyHandler) LookupUserByID(ctx context.Context, userID id.UserID) ([]*mautrix.ThirdPartyUser, error) {
+	return nil, nil
+}
+
+func TestAppService_ThirdPartyLookup(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{ServerToken: "hs_token"}
+	doRequest := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer hs_token")
+		w := httptest.NewRecorder()
+		as.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, doRequest("/_matrix/app/v1/thirdparty/protocol/irc").Code)
+
+	as.ThirdPartyHandler = &testThirdPartyHandler{}
+	w := doRequest("/_matrix/app/v1/thirdparty/protocol/irc")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_fields":["network","nickname"],"location_fields":null,"icon":"","field_types":null,"instances":null}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, doRequest("/_matrix/app/v1/thirdparty/protocol/xmpp").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest("/_matrix/app/v1/thirdparty/location/irc?channel=%23test").Code)
+
+	w = doRequest("/_matrix/app/v1/thirdparty/user/irc?network=libera&nickname=alice")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"userid":"@_irc_alice:example.com","protocol":"irc","fields":{"network":"libera","nickname":"alice"}}]`, w.Body.String())
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	DefaultBulkProfileConcurrency = 8
+	DefaultBulkProfileInterval    = 50 * time.Millisecond
+)
+
+// GhostProfile is the desired profile of a single ghost user for [AppService.SetupGhostProfiles].
+type GhostProfile struct {
+	UserID id.UserID
+	// DisplayName is the displayname to set. If empty, the displayname is not changed.
+	DisplayName string
+	// AvatarURL is the avatar to set. If empty, the avatar is not changed.
+	AvatarURL id.ContentURI
+}
+
+// BulkProfileProgress is passed to [BulkProfileParams.Progress] after each ghost is processed.
+type BulkProfileProgress struct {
+	Done   int
+	Failed int
+	Total  int
+}
+
+type BulkProfileParams struct {
+	// Concurrency is the maximum number of ghosts to set up in parallel.
+	// Defaults to [DefaultBulkProfileConcurrency].
+	Concurrency int
+	// Interval is the minimum delay between starting to set up two ghosts, which limits the request rate
+	// regardless of the concurrency. Defaults to [DefaultBulkProfileInterval]. Negative values disable rate limiting.
+	Interval time.Duration
+	// Progress is called after each ghost has been processed. It may be called from multiple goroutines,
+	// but never concurrently.
+	Progress func(progress BulkProfileProgress)
+}
+
+// SetupGhostProfiles registers the given ghost users and sets their profiles concurrently.
+//
+// This is meant for situations like creating a portal for a large group, where setting up every member
+// one by one would take minutes. Ghosts that are already registered and have the requested profile only
+// cost one request per profile field, as [IntentAPI.SetDisplayName] and [IntentAPI.SetAvatarURL] skip
+// unnecessary updates.
+//
+// The returned map contains the errors for ghosts that couldn't be set up. If the context is canceled,
+// the remaining ghosts are not processed and are not included in the map.
+func (as *AppService) SetupGhostProfiles(ctx context.Context, profiles []GhostProfile, params BulkProfileParams) map[id.UserID]error {
+	if params.Concurrency <= 0 {
+		params.Concurrency = DefaultBulkProfileConcurrency
+	}
+	if params.Interval == 0 {
+		params.Interval = DefaultBulkProfileInterval
+	}
+	var limiter <-chan time.Time
+	if params.Interval > 0 {
+		ticker := time.NewTicker(params.Interval)
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	queue := make(chan *GhostProfile)
+	errs := make(map[id.UserID]error)
+	progress := BulkProfileProgress{Total: len(profiles)}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(params.Concurrency)
+	for i := 0; i < params.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for profile := range queue {
+				err := as.setupGhostProfile(ctx, profile)
+				lock.Lock()
+				progress.Done++
+				if err != nil {
+					progress.Failed++
+					errs[profile.UserID] = err
+				}
+				if params.Progress != nil {
+					params.Progress(progress)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+Loop:
+	for i := range profiles {
+		if limiter != nil && i > 0 {
+			select {
+			case <-limiter:
+			case <-ctx.Done():
+				break Loop
+			}
+		}
+		select {
+		case queue <- &profiles[i]:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+	zerolog.Ctx(ctx).Debug().
+		Int("total", progress.Total).
+		Int("done", progress.Done).
+		Int("failed", progress.Failed).
+		Msg("Finished bulk ghost profile setup")
+	return errs
+}
+
+func (as *AppService) setupGhostProfile(ctx context.Context, profile *GhostProfile) error {
+	intent := as.Intent(profile.UserID)
+	if intent == nil {
+		return fmt.Errorf("invalid ghost user ID %s", profile.UserID)
+	}
+	err := intent.EnsureRegistered(ctx)
+	if err != nil {
+		return err
+	}
+	if profile.DisplayName != "" {
+		err = intent.SetDisplayName(ctx, profile.DisplayName)
+		if err != nil {
+			return fmt.Errorf("failed to set displayname: %w", err)
+		}
+	}
+	if !profile.AvatarURL.IsEmpty() {
+		err = intent.SetAvatarURL(ctx, profile.AvatarURL)
+		if err != nil {
+			return fmt.Errorf("failed to set avatar URL: %w", err)
+		}
+	}
+	return nil
+}
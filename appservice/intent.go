@@ -208,7 +208,10 @@ func (intent *IntentAPI) SendMessageEvent(ctx context.Context, roomID id.RoomID,
 		return nil, err
 	}
 	contentJSON = intent.AddDoublePuppetValue(contentJSON)
-	return intent.Client.SendMessageEvent(ctx, roomID, eventType, contentJSON)
+	req := mautrix.ReqSendEvent{TransactionID: intent.TxnID()}
+	return intent.sendWithRetry(ctx, func() (*mautrix.RespSendEvent, error) {
+		return intent.Client.SendMessageEvent(ctx, roomID, eventType, contentJSON, req)
+	})
 }
 
 func (intent *IntentAPI) SendMassagedMessageEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, contentJSON interface{}, ts int64) (*mautrix.RespSendEvent, error) {
@@ -216,7 +219,10 @@ func (intent *IntentAPI) SendMassagedMessageEvent(ctx context.Context, roomID id
 		return nil, err
 	}
 	contentJSON = intent.AddDoublePuppetValueWithTS(contentJSON, ts)
-	return intent.Client.SendMessageEvent(ctx, roomID, eventType, contentJSON, mautrix.ReqSendEvent{Timestamp: ts})
+	req := mautrix.ReqSendEvent{Timestamp: ts, TransactionID: intent.TxnID()}
+	return intent.sendWithRetry(ctx, func() (*mautrix.RespSendEvent, error) {
+		return intent.Client.SendMessageEvent(ctx, roomID, eventType, contentJSON, req)
+	})
 }
 
 func (intent *IntentAPI) SendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}) (*mautrix.RespSendEvent, error) {
@@ -226,7 +232,9 @@ func (intent *IntentAPI) SendStateEvent(ctx context.Context, roomID id.RoomID, e
 		}
 	}
 	contentJSON = intent.AddDoublePuppetValue(contentJSON)
-	return intent.Client.SendStateEvent(ctx, roomID, eventType, stateKey, contentJSON)
+	return intent.sendWithRetry(ctx, func() (*mautrix.RespSendEvent, error) {
+		return intent.Client.SendStateEvent(ctx, roomID, eventType, stateKey, contentJSON)
+	})
 }
 
 func (intent *IntentAPI) SendMassagedStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}, ts int64) (*mautrix.RespSendEvent, error) {
@@ -234,7 +242,9 @@ func (intent *IntentAPI) SendMassagedStateEvent(ctx context.Context, roomID id.R
 		return nil, err
 	}
 	contentJSON = intent.AddDoublePuppetValueWithTS(contentJSON, ts)
-	return intent.Client.SendMassagedStateEvent(ctx, roomID, eventType, stateKey, contentJSON, ts)
+	return intent.sendWithRetry(ctx, func() (*mautrix.RespSendEvent, error) {
+		return intent.Client.SendMassagedStateEvent(ctx, roomID, eventType, stateKey, contentJSON, ts)
+	})
 }
 
 func (intent *IntentAPI) StateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, outContent interface{}) error {
@@ -393,17 +403,17 @@ func (intent *IntentAPI) SetPowerLevel(ctx context.Context, roomID id.RoomID, us
 }
 
 func (intent *IntentAPI) SendText(ctx context.Context, roomID id.RoomID, text string) (*mautrix.RespSendEvent, error) {
-	if err := intent.EnsureJoined(ctx, roomID); err != nil {
-		return nil, err
-	}
-	return intent.Client.SendText(ctx, roomID, text)
+	return intent.SendMessageEvent(ctx, roomID, event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	})
 }
 
 func (intent *IntentAPI) SendNotice(ctx context.Context, roomID id.RoomID, text string) (*mautrix.RespSendEvent, error) {
-	if err := intent.EnsureJoined(ctx, roomID); err != nil {
-		return nil, err
-	}
-	return intent.Client.SendNotice(ctx, roomID, text)
+	return intent.SendMessageEvent(ctx, roomID, event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    text,
+	})
 }
 
 func (intent *IntentAPI) RedactEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, extra ...mautrix.ReqRedact) (*mautrix.RespSendEvent, error) {
@@ -415,7 +425,12 @@ func (intent *IntentAPI) RedactEvent(ctx context.Context, roomID id.RoomID, even
 		req = extra[0]
 	}
 	intent.AddDoublePuppetValue(&req.Extra)
-	return intent.Client.RedactEvent(ctx, roomID, eventID, req)
+	if req.TxnID == "" {
+		req.TxnID = intent.TxnID()
+	}
+	return intent.sendWithRetry(ctx, func() (*mautrix.RespSendEvent, error) {
+		return intent.Client.RedactEvent(ctx, roomID, eventID, req)
+	})
 }
 
 func (intent *IntentAPI) SetRoomName(ctx context.Context, roomID id.RoomID, roomName string) (*mautrix.RespSendEvent, error) {
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/retryafter"
+
+	"maunium.net/go/mautrix"
+)
+
+const (
+	DefaultIntentSendRetries      = 2
+	DefaultIntentSendRetryBackoff = 5 * time.Second
+)
+
+// IsTransientSendError returns true if the given error from sending an event is likely to be temporary,
+// i.e. the homeserver couldn't be reached at all or a proxy in front of it returned 502, 503 or 504.
+//
+// Rate limits and errors returned by the homeserver itself are not considered transient.
+func IsTransientSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	} else if !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		return httpErr.WrappedError != nil
+	}
+	return retryafter.Should(httpErr.Response.StatusCode, false)
+}
+
+// sendWithRetry calls the given function again if it fails with a transient error.
+//
+// The caller is responsible for ensuring that the function is idempotent:
+//   - Message events and redactions must reuse the same transaction ID for every attempt,
+//     so that the homeserver deduplicates the event if an earlier attempt went through,
+//     but the response was lost. Encrypted events are re-encrypted on each attempt, which is
+//     fine, because the transaction ID is what the homeserver deduplicates by.
+//   - State events are naturally idempotent, as sending the same content with the same state key
+//     again doesn't change the room state.
+//
+// Non-idempotent requests (e.g. joins with third party signed data or room creation) must not be retried.
+func (intent *IntentAPI) sendWithRetry(ctx context.Context, fn func() (*mautrix.RespSendEvent, error)) (*mautrix.RespSendEvent, error) {
+	backoff := intent.as.IntentSendRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultIntentSendRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := fn()
+		if err == nil || attempt >= intent.as.IntentSendRetries || !IsTransientSendError(err) {
+			return resp, err
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Int("attempt", attempt+1).
+			Int("retry_in_seconds", int(backoff.Seconds())).
+			Msg("Sending event failed with transient error, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
@@ -144,6 +144,7 @@ const (
 	ErrBadJSON      ErrorCode = "M_BAD_JSON"
 	ErrNotJSON      ErrorCode = "M_NOT_JSON"
 	ErrUnknown      ErrorCode = "M_UNKNOWN"
+	ErrNotFound     ErrorCode = "M_NOT_FOUND"
 )
 
 // Custom ErrorCodes
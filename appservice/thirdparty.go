@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// ThirdPartyHandler handles third-party network lookups from the homeserver.
+//
+// Methods should return nil if nothing was found, which will be sent to the homeserver as M_NOT_FOUND.
+type ThirdPartyHandler interface {
+	GetProtocol(ctx context.Context, protocol string) (*mautrix.ThirdPartyProtocol, error)
+	QueryLocations(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyLocation, error)
+	QueryUsers(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyUser, error)
+	LookupLocationByAlias(ctx context.Context, alias id.RoomAlias) ([]*mautrix.ThirdPartyLocation, error)
+	LookupUserByID(ctx context.Context, userID id.UserID) ([]*mautrix.ThirdPartyUser, error)
+}
+
+// GetThirdPartyProtocol handles a /thirdparty/protocol GET call from the homeserver.
+func (as *AppService) GetThirdPartyProtocol(w http.ResponseWriter, r *http.Request) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	protocol := mux.Vars(r)["protocol"]
+	handleThirdPartyLookup(as, w, r, func(ctx context.Context, th ThirdPartyHandler) (*mautrix.ThirdPartyProtocol, bool, error) {
+		resp, err := th.GetProtocol(ctx, protocol)
+		return resp, resp != nil, err
+	})
+}
+
+// GetThirdPartyLocationByFields handles a /thirdparty/location/{protocol} GET call from the homeserver.
+func (as *AppService) GetThirdPartyLocationByFields(w http.ResponseWriter, r *http.Request) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	protocol := mux.Vars(r)["protocol"]
+	fields := getThirdPartyFields(r)
+	handleThirdPartyLookup(as, w, r, func(ctx context.Context, th ThirdPartyHandler) ([]*mautrix.ThirdPartyLocation, bool, error) {
+		resp, err := th.QueryLocations(ctx, protocol, fields)
+		return resp, len(resp) > 0, err
+	})
+}
+
+// GetThirdPartyUserByFields handles a /thirdparty/user/{protocol} GET call from the homeserver.
+func (as *AppService) GetThirdPartyUserByFields(w http.ResponseWriter, r *http.Request) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	protocol := mux.Vars(r)["protocol"]
+	fields := getThirdPartyFields(r)
+	handleThirdPartyLookup(as, w, r, func(ctx context.Context, th ThirdPartyHandler) ([]*mautrix.ThirdPartyUser, bool, error) {
+		resp, err := th.QueryUsers(ctx, protocol, fields)
+		return resp, len(resp) > 0, err
+	})
+}
+
+// GetThirdPartyLocationByAlias handles a /thirdparty/location GET call from the homeserver.
+func (as *AppService) GetThirdPartyLocationByAlias(w http.ResponseWriter, r *http.Request) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	alias := id.RoomAlias(r.URL.Query().Get("alias"))
+	handleThirdPartyLookup(as, w, r, func(ctx context.Context, th ThirdPartyHandler) ([]*mautrix.ThirdPartyLocation, bool, error) {
+		resp, err := th.LookupLocationByAlias(ctx, alias)
+		return resp, len(resp) > 0, err
+	})
+}
+
+// GetThirdPartyUserByID handles a /thirdparty/user GET call from the homeserver.
+func (as *AppService) GetThirdPartyUserByID(w http.ResponseWriter, r *http.Request) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	userID := id.UserID(r.URL.Query().Get("userid"))
+	handleThirdPartyLookup(as, w, r, func(ctx context.Context, th ThirdPartyHandler) ([]*mautrix.ThirdPartyUser, bool, error) {
+		resp, err := th.LookupUserByID(ctx, userID)
+		return resp, len(resp) > 0, err
+	})
+}
+
+func getThirdPartyFields(r *http.Request) map[string]string {
+	query := r.URL.Query()
+	fields := make(map[string]string, len(query))
+	for key := range query {
+		fields[key] = query.Get(key)
+	}
+	return fields
+}
+
+func handleThirdPartyLookup[T any](as *AppService, w http.ResponseWriter, r *http.Request, fn func(context.Context, ThirdPartyHandler) (T, bool, error)) {
+	if as.ThirdPartyHandler == nil {
+		Error{
+			ErrorCode:  ErrNotFound,
+			HTTPStatus: http.StatusNotFound,
+		}.Write(w)
+		return
+	}
+	ctx := as.Log.WithContext(r.Context())
+	resp, found, err := fn(ctx, as.ThirdPartyHandler)
+	if err != nil {
+		as.Log.Err(err).Str("path", r.URL.Path).Msg("Failed to handle third-party lookup")
+		Error{
+			ErrorCode:  ErrUnknown,
+			HTTPStatus: http.StatusInternalServerError,
+			Message:    "Failed to handle third-party lookup",
+		}.Write(w)
+	} else if !found {
+		Error{
+			ErrorCode:  ErrNotFound,
+			HTTPStatus: http.StatusNotFound,
+		}.Write(w)
+	} else {
+		_ = Respond(w, resp)
+	}
+}
@@ -8,8 +8,10 @@ package bridgev2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -28,6 +30,8 @@ func (br *Bridge) WakeupBackfillQueue() {
 	}
 }
 
+// RunBackfillQueue runs the backfill queue until the bridge is stopped.
+// Backfill tasks that are already running are waited for before returning.
 func (br *Bridge) RunBackfillQueue() {
 	if !br.Config.Backfill.Queue.Enabled || !br.Config.Backfill.Enabled {
 		return
@@ -43,44 +47,108 @@ func (br *Bridge) RunBackfillQueue() {
 		cancel()
 	}()
 	batchDelay := time.Duration(br.Config.Backfill.Queue.BatchDelay) * time.Second
-	log.Info().Stringer("batch_delay", batchDelay).Msg("Backfill queue starting")
+	concurrency := max(br.Config.Backfill.Queue.Concurrency, 1)
+	minDispatchInterval := br.getBackfillMinDispatchInterval()
+	log.Info().
+		Stringer("batch_delay", batchDelay).
+		Int("concurrency", concurrency).
+		Stringer("min_dispatch_interval", minDispatchInterval).
+		Msg("Backfill queue starting")
+	workerSlots := make(chan struct{}, concurrency)
+	var workers sync.WaitGroup
+	defer func() {
+		cancel()
+		workers.Wait()
+		log.Info().Msg("Backfill queue stopped")
+	}()
+	var lastDispatch time.Time
 	noTasksFoundCount := 0
+	dispatchedPrevious := false
 	for {
-		nextDelay := batchDelay
-		if noTasksFoundCount > 0 {
-			extraDelay := batchDelay * time.Duration(noTasksFoundCount)
-			nextDelay += min(BackfillQueueMaxEmptyBackoff, extraDelay)
-		}
-		timer := time.NewTimer(nextDelay)
-		select {
-		case <-br.wakeupBackfillQueue:
-			if !timer.Stop() {
-				select {
-				case <-timer.C:
-				default:
-				}
+		// The batch delay of each portal is handled by the next dispatch timestamp of the task,
+		// so there's no need to wait between dispatches unless the queue was empty.
+		var nextDelay time.Duration
+		if !dispatchedPrevious {
+			nextDelay = batchDelay
+			if noTasksFoundCount > 0 {
+				extraDelay := batchDelay * time.Duration(noTasksFoundCount)
+				nextDelay += min(BackfillQueueMaxEmptyBackoff, extraDelay)
 			}
-			noTasksFoundCount = 0
-		case <-br.stopBackfillQueue:
-			if !timer.Stop() {
-				select {
-				case <-timer.C:
-				default:
-				}
+		}
+		if rateLimitDelay := time.Until(lastDispatch.Add(minDispatchInterval)); rateLimitDelay > nextDelay {
+			nextDelay = rateLimitDelay
+		}
+		if nextDelay > 0 {
+			timer := time.NewTimer(nextDelay)
+			select {
+			case <-br.wakeupBackfillQueue:
+				timer.Stop()
+				noTasksFoundCount = 0
+			case <-ctx.Done():
+				timer.Stop()
+				log.Info().Msg("Stopping backfill queue")
+				return
+			case <-timer.C:
 			}
+		}
+		select {
+		case workerSlots <- struct{}{}:
+		case <-ctx.Done():
 			log.Info().Msg("Stopping backfill queue")
 			return
-		case <-timer.C:
 		}
+		dispatchedPrevious = false
 		backfillTask, err := br.DB.BackfillTask.GetNext(ctx)
 		if err != nil {
+			<-workerSlots
 			log.Err(err).Msg("Failed to get next backfill queue entry")
-			time.Sleep(BackfillQueueErrorBackoff)
+			sleepBackfillErrorBackoff(ctx)
+			continue
+		} else if backfillTask == nil {
+			<-workerSlots
+			noTasksFoundCount++
 			continue
-		} else if backfillTask != nil {
-			br.doBackfillTask(ctx, backfillTask)
-			noTasksFoundCount = 0
 		}
+		// The task must be marked as dispatched before looking for the next one,
+		// so that other workers don't pick up the same task.
+		err = br.DB.BackfillTask.MarkDispatched(ctx, backfillTask)
+		if err != nil {
+			<-workerSlots
+			log.Err(err).Object("portal_key", backfillTask.PortalKey).Msg("Failed to mark backfill task as dispatched")
+			sleepBackfillErrorBackoff(ctx)
+			continue
+		}
+		lastDispatch = time.Now()
+		noTasksFoundCount = 0
+		dispatchedPrevious = true
+		workers.Add(1)
+		go func() {
+			defer func() {
+				<-workerSlots
+				workers.Done()
+			}()
+			br.doBackfillTask(ctx, backfillTask)
+		}()
+	}
+}
+
+// getBackfillMinDispatchInterval returns the minimum time between dispatching backfill tasks
+// based on the max_batches_per_minute config option or the default of the network connector.
+func (br *Bridge) getBackfillMinDispatchInterval() time.Duration {
+	maxBatchesPerMinute := br.Config.Backfill.Queue.MaxBatchesPerMinute
+	if rateLimitingNetwork, ok := br.Network.(BackfillRateLimitingNetwork); ok && maxBatchesPerMinute == 0 {
+		maxBatchesPerMinute = rateLimitingNetwork.GetBackfillMaxBatchesPerMinute()
+	}
+	if maxBatchesPerMinute <= 0 {
+		return 0
+	}
+	return time.Minute / time.Duration(maxBatchesPerMinute)
+}
+
+func sleepBackfillErrorBackoff(ctx context.Context) {
+	select {
+	case <-time.After(BackfillQueueErrorBackoff):
+	case <-ctx.Done():
 	}
 }
 
@@ -103,16 +171,10 @@ func (br *Bridge) doBackfillTask(ctx context.Context, task *database.BackfillTas
 		}
 	}()
 	ctx = log.WithContext(ctx)
-	err := br.DB.BackfillTask.MarkDispatched(ctx, task)
-	if err != nil {
-		log.Err(err).Msg("Failed to mark backfill task as dispatched")
-		time.Sleep(BackfillQueueErrorBackoff)
-		return
-	}
 	completed, err := br.actuallyDoBackfillTask(ctx, task)
 	if err != nil {
 		log.Err(err).Msg("Failed to do backfill task")
-		time.Sleep(BackfillQueueErrorBackoff)
+		sleepBackfillErrorBackoff(ctx)
 		return
 	} else if completed {
 		log.Info().
@@ -128,7 +190,7 @@ func (br *Bridge) doBackfillTask(ctx context.Context, task *database.BackfillTas
 	err = br.DB.BackfillTask.Update(ctx, task)
 	if err != nil {
 		log.Err(err).Msg("Failed to update backfill task")
-		time.Sleep(BackfillQueueErrorBackoff)
+		sleepBackfillErrorBackoff(ctx)
 	}
 }
 
@@ -157,7 +219,7 @@ func (br *Bridge) actuallyDoBackfillTask(ctx context.Context, task *database.Bac
 		err = br.DB.BackfillTask.Delete(ctx, task.PortalKey)
 		if err != nil {
 			log.Err(err).Msg("Failed to delete backfill task after portal wasn't found")
-			time.Sleep(BackfillQueueErrorBackoff)
+			sleepBackfillErrorBackoff(ctx)
 		}
 		return false, nil
 	} else if portal.MXID == "" {
@@ -244,3 +306,55 @@ func (br *Bridge) actuallyDoBackfillTask(ctx context.Context, task *database.Bac
 	task.NextDispatchMinTS = task.CompletedAt.Add(batchDelay)
 	return true, nil
 }
+
+var ErrBackfillNotEnabled = errors.New("backfill is not enabled")
+
+// DoManualBackfill immediately fetches up to the given number of batches of history in the portal,
+// regardless of the backfill queue's batch limit. The backfill queue task for the portal is updated,
+// so the queue will continue from where the manual backfill stopped.
+//
+// The returned int is the number of batches that were actually backfilled.
+func (portal *Portal) DoManualBackfill(ctx context.Context, source *UserLogin, batches int) (int, error) {
+	if !portal.Bridge.Config.Backfill.Enabled {
+		return 0, ErrBackfillNotEnabled
+	} else if portal.MXID == "" {
+		return 0, fmt.Errorf("portal doesn't have a room")
+	} else if _, ok := source.Client.(BackfillingNetworkAPI); !ok {
+		return 0, fmt.Errorf("network API does not support backfilling")
+	}
+	err := portal.Bridge.DB.BackfillTask.EnsureExists(ctx, portal.PortalKey, source.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to ensure backfill task exists: %w", err)
+	}
+	task, err := portal.Bridge.DB.BackfillTask.GetByPortal(ctx, portal.PortalKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get backfill task: %w", err)
+	} else if task == nil {
+		return 0, fmt.Errorf("backfill task not found")
+	}
+	// Mark the task as dispatched so that the queue doesn't pick it up at the same time
+	err = portal.Bridge.DB.BackfillTask.MarkDispatched(ctx, task)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark backfill task as dispatched: %w", err)
+	}
+	// The task may have only been marked as done because of the batch limit, so let the network decide
+	task.IsDone = false
+	var done int
+	for done < batches && !task.IsDone {
+		err = portal.DoBackwardsBackfill(ctx, source, task)
+		if err != nil {
+			break
+		}
+		done++
+		// A negative batch count means the queue hasn't counted existing messages yet, so leave it for the queue
+		if task.BatchCount >= 0 {
+			task.BatchCount++
+		}
+	}
+	task.CompletedAt = time.Now()
+	task.NextDispatchMinTS = task.CompletedAt.Add(time.Duration(portal.Bridge.Config.Backfill.Queue.BatchDelay) * time.Second)
+	if updateErr := portal.Bridge.DB.BackfillTask.Update(ctx, task); updateErr != nil {
+		zerolog.Ctx(ctx).Err(updateErr).Msg("Failed to update backfill task after manual backfill")
+	}
+	return done, err
+}
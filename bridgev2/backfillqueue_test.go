@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+type testBackfillNetworkAPI struct {
+	testNetworkAPI
+	fetchCount int
+	maxFetches int
+}
+
+var _ BackfillingNetworkAPI = (*testBackfillNetworkAPI)(nil)
+
+func (tbna *testBackfillNetworkAPI) FetchMessages(ctx context.Context, params FetchMessagesParams) (*FetchMessagesResponse, error) {
+	tbna.fetchCount++
+	return &FetchMessagesResponse{
+		Cursor:  networkid.PaginationCursor(fmt.Sprintf("cursor%d", tbna.fetchCount)),
+		HasMore: tbna.fetchCount < tbna.maxFetches,
+	}, nil
+}
+
+func TestPortal_DoManualBackfill(t *testing.T) {
+	ctx := context.Background()
+	br, login, _ := newTestBridgeWithLogin(t)
+	br.Config.Backfill.Enabled = true
+	br.Config.Backfill.Queue.MaxBatches = 1
+	client := &testBackfillNetworkAPI{maxFetches: 3}
+	login.Client = client
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	require.NoError(t, br.DB.BackfillTask.Upsert(ctx, &database.BackfillTask{
+		PortalKey:   portal.PortalKey,
+		UserLoginID: login.ID,
+		BatchCount:  1,
+		IsDone:      true,
+	}))
+
+	done, err := portal.DoManualBackfill(ctx, login, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, done, "manual backfill should ignore the queue's batch limit")
+	task, err := br.DB.BackfillTask.GetByPortal(ctx, portal.PortalKey)
+	require.NoError(t, err)
+	assert.Equal(t, 2, task.BatchCount)
+	assert.False(t, task.IsDone)
+	assert.Equal(t, networkid.PaginationCursor("cursor1"), task.Cursor)
+
+	done, err = portal.DoManualBackfill(ctx, login, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 2, done, "manual backfill should stop when there are no more messages")
+	task, err = br.DB.BackfillTask.GetByPortal(ctx, portal.PortalKey)
+	require.NoError(t, err)
+	assert.True(t, task.IsDone)
+	assert.Equal(t, 3, client.fetchCount)
+}
+
+func TestPortal_DoManualBackfill_Disabled(t *testing.T) {
+	br, login, _ := newTestBridgeWithLogin(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	_, err := portal.DoManualBackfill(context.Background(), login, 1)
+	assert.ErrorIs(t, err, ErrBackfillNotEnabled)
+}
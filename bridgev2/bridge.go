@@ -36,7 +36,12 @@ type Bridge struct {
 	Commands CommandProcessor
 	Config   *bridgeconfig.BridgeConfig
 
-	DisappearLoop *DisappearLoop
+	DisappearLoop  *DisappearLoop
+	OrphanReaper   *OrphanReaper
+	MessageRetry   *MessageRetryLoop
+	InstanceLock   *InstanceLock
+	GhostRefresher *GhostInfoRefresher
+	LiveLocations  *LiveLocationManager
 
 	usersByMXID    map[id.UserID]*User
 	userLoginsByID map[networkid.UserLoginID]*UserLogin
@@ -47,6 +52,7 @@ type Bridge struct {
 
 	wakeupBackfillQueue chan struct{}
 	stopBackfillQueue   chan struct{}
+	backfillQueueDone   sync.WaitGroup
 }
 
 func NewBridge(
@@ -84,6 +90,11 @@ func NewBridge(
 	br.Bot = br.Matrix.BotIntent()
 	br.Network.Init(br)
 	br.DisappearLoop = &DisappearLoop{br: br}
+	br.OrphanReaper = &OrphanReaper{br: br}
+	br.MessageRetry = &MessageRetryLoop{br: br}
+	br.InstanceLock = newInstanceLock(br)
+	br.GhostRefresher = newGhostInfoRefresher(br)
+	br.LiveLocations = newLiveLocationManager(br)
 	return br
 }
 
@@ -116,10 +127,22 @@ func (br *Bridge) StartConnectors() error {
 	br.Log.Info().Msg("Starting bridge")
 	ctx := br.Log.WithContext(context.Background())
 
+	if br.Config.LoginMetadataEncryption.Enabled && br.DB.UserLogin.Crypter == nil {
+		crypter, err := database.NewAESMetadataCrypter([]byte(br.Config.LoginMetadataEncryption.Key))
+		if err != nil {
+			return fmt.Errorf("failed to initialize login metadata encryption: %w", err)
+		}
+		br.DB.UserLogin.Crypter = crypter
+	}
 	err := br.DB.Upgrade(ctx)
 	if err != nil {
 		return DBUpgradeError{Err: err, Section: "main"}
 	}
+	err = br.InstanceLock.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire instance lock: %w", err)
+	}
+	br.InstanceLock.Start()
 	didSplitPortals := br.MigrateToSplitPortals(ctx)
 	br.Log.Info().Msg("Starting Matrix connector")
 	err = br.Matrix.Start(ctx)
@@ -198,7 +221,14 @@ func (br *Bridge) StartLogins() error {
 		br.Log.Info().Msg("No user logins found")
 		br.SendGlobalBridgeState(status.BridgeState{StateEvent: status.StateUnconfigured})
 	}
-	go br.RunBackfillQueue()
+	br.backfillQueueDone.Add(1)
+	go func() {
+		defer br.backfillQueueDone.Done()
+		br.RunBackfillQueue()
+	}()
+	br.OrphanReaper.Start()
+	br.MessageRetry.Start()
+	br.GhostRefresher.Start()
 
 	br.Log.Info().Msg("Bridge started")
 	return nil
@@ -207,6 +237,11 @@ func (br *Bridge) StartLogins() error {
 func (br *Bridge) Stop() {
 	br.Log.Info().Msg("Shutting down bridge")
 	close(br.stopBackfillQueue)
+	br.backfillQueueDone.Wait()
+	br.OrphanReaper.Stop()
+	br.MessageRetry.Stop()
+	br.GhostRefresher.Stop()
+	br.LiveLocations.Stop()
 	br.Matrix.Stop()
 	br.cacheLock.Lock()
 	var wg sync.WaitGroup
@@ -219,6 +254,7 @@ func (br *Bridge) Stop() {
 	if stopNet, ok := br.Network.(StoppableNetwork); ok {
 		stopNet.Stop()
 	}
+	br.InstanceLock.Stop()
 	err := br.DB.Close()
 	if err != nil {
 		br.Log.Warn().Err(err).Msg("Failed to close database")
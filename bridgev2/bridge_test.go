@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/configupgrade"
+	"go.mau.fi/util/dbutil"
+	"gopkg.in/yaml.v3"
+
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// testMatrix is a MatrixConnector for tests. Methods that aren't overridden panic if called.
+type testMatrix struct {
+	MatrixConnector
+}
+
+func (tm *testMatrix) GetMemberInfo(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	return nil, nil
+}
+
+func (tm *testMatrix) SendMessageStatus(ctx context.Context, status *MessageStatus, evt *MessageStatusEventInfo) {
+}
+
+type testNetwork struct{}
+
+var _ NetworkConnector = (*testNetwork)(nil)
+
+func (tn *testNetwork) Init(*Bridge)                    {}
+func (tn *testNetwork) Start(ctx context.Context) error { return nil }
+func (tn *testNetwork) GetName() BridgeName {
+	return BridgeName{DisplayName: "Test", NetworkID: "test"}
+}
+func (tn *testNetwork) GetDBMetaTypes() database.MetaTypes {
+	return database.MetaTypes{}
+}
+func (tn *testNetwork) GetCapabilities() *NetworkGeneralCapabilities {
+	return &NetworkGeneralCapabilities{}
+}
+func (tn *testNetwork) GetConfig() (string, any, configupgrade.Upgrader) { return "", nil, nil }
+func (tn *testNetwork) GetLoginFlows() []LoginFlow                       { return nil }
+func (tn *testNetwork) CreateLogin(ctx context.Context, user *User, flowID string) (LoginProcess, error) {
+	return nil, nil
+}
+
+func (tn *testNetwork) LoadUserLogin(ctx context.Context, login *UserLogin) error {
+	login.Client = &testNetworkAPI{}
+	return nil
+}
+
+type testNetworkAPI struct {
+	markedUnread []*MatrixMarkedUnread
+
+	lock       sync.Mutex
+	messageErr error
+	messages   []*MatrixMessage
+}
+
+var _ MarkedUnreadHandlingNetworkAPI = (*testNetworkAPI)(nil)
+
+func (tna *testNetworkAPI) Connect(ctx context.Context) error { return nil }
+func (tna *testNetworkAPI) Disconnect()                       {}
+func (tna *testNetworkAPI) IsLoggedIn() bool                  { return true }
+func (tna *testNetworkAPI) LogoutRemote(ctx context.Context)  {}
+func (tna *testNetworkAPI) IsThisUser(ctx context.Context, userID networkid.UserID) bool {
+	return false
+}
+func (tna *testNetworkAPI) GetChatInfo(ctx context.Context, portal *Portal) (*ChatInfo, error) {
+	return nil, nil
+}
+func (tna *testNetworkAPI) GetUserInfo(ctx context.Context, ghost *Ghost) (*UserInfo, error) {
+	return nil, nil
+}
+func (tna *testNetworkAPI) GetCapabilities(ctx context.Context, portal *Portal) *NetworkRoomCapabilities {
+	return &NetworkRoomCapabilities{}
+}
+func (tna *testNetworkAPI) HandleMatrixMessage(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error) {
+	tna.lock.Lock()
+	defer tna.lock.Unlock()
+	tna.messages = append(tna.messages, msg)
+	if tna.messageErr != nil {
+		return nil, tna.messageErr
+	}
+	return &MatrixMessageResponse{DB: &database.Message{ID: networkid.MessageID(msg.Event.ID)}}, nil
+}
+
+func (tna *testNetworkAPI) setMessageErr(err error) {
+	tna.lock.Lock()
+	tna.messageErr = err
+	tna.lock.Unlock()
+}
+
+func (tna *testNetworkAPI) messageCount() int {
+	tna.lock.Lock()
+	defer tna.lock.Unlock()
+	return len(tna.messages)
+}
+func (tna *testNetworkAPI) HandleMarkedUnread(ctx context.Context, msg *MatrixMarkedUnread) error {
+	tna.markedUnread = append(tna.markedUnread, msg)
+	return nil
+}
+
+// newTestBridge creates a bridge with an in-memory SQLite database and stub connectors.
+func newTestBridge(t *testing.T) *Bridge {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000")
+	require.NoError(t, err)
+	// Every connection to :memory: gets its own database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	var cfg bridgeconfig.BridgeConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`{relay: {displayname_format: "{{ .DisambiguatedName }}"}}`), &cfg))
+	network := &testNetwork{}
+	br := &Bridge{
+		ID:      "test",
+		DB:      database.New("test", network.GetDBMetaTypes(), db),
+		Log:     zerolog.Nop(),
+		Matrix:  &testMatrix{},
+		Network: network,
+		Config:  &cfg,
+
+		usersByMXID:    make(map[id.UserID]*User),
+		userLoginsByID: make(map[networkid.UserLoginID]*UserLogin),
+		portalsByKey:   make(map[networkid.PortalKey]*Portal),
+		portalsByMXID:  make(map[id.RoomID]*Portal),
+		ghostsByID:     make(map[networkid.UserID]*Ghost),
+	}
+	require.NoError(t, br.DB.Upgrade(context.Background()))
+	return br
+}
+
+func newTestLogin(t *testing.T, br *Bridge, userID id.UserID, loginID networkid.UserLoginID) *UserLogin {
+	t.Helper()
+	ctx := context.Background()
+	user, err := br.GetUserByMXID(ctx, userID)
+	require.NoError(t, err)
+	login, err := user.NewLogin(ctx, &database.UserLogin{ID: loginID}, nil)
+	require.NoError(t, err)
+	return login
+}
+
+// newTestBridgeWithLogin creates a test bridge with a user login for @alice:example.com, which most tests build on.
+// Connectors, config and the login's client can be replaced on the returned values before they're used.
+func newTestBridgeWithLogin(t *testing.T) (*Bridge, *UserLogin, *testNetworkAPI) {
+	t.Helper()
+	br := newTestBridge(t)
+	login := newTestLogin(t, br, "@alice:example.com", "alice")
+	return br, login, login.Client.(*testNetworkAPI)
+}
+
+func newTestPortal(t *testing.T, br *Bridge, key networkid.PortalKey, roomID id.RoomID) *Portal {
+	t.Helper()
+	ctx := context.Background()
+	portal, err := br.GetPortalByKey(ctx, key)
+	require.NoError(t, err)
+	portal.MXID = roomID
+	require.NoError(t, portal.Save(ctx))
+	br.cacheLock.Lock()
+	br.portalsByMXID[roomID] = portal
+	br.cacheLock.Unlock()
+	return portal
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
 
 	"go.mau.fi/util/exerrors"
@@ -37,17 +38,68 @@ type AppserviceConfig struct {
 
 	UsernameTemplate string             `yaml:"username_template"`
 	usernameTemplate *template.Template `yaml:"-"`
+	usernamePrefix   string             `yaml:"-"`
+	usernameSuffix   string             `yaml:"-"`
+	usernameHasParts bool               `yaml:"-"`
+	usernameInit     sync.Once          `yaml:"-"`
+}
+
+// initUsernameTemplate parses the username template and finds the static prefix and suffix around the username placeholder.
+func (asc *AppserviceConfig) initUsernameTemplate() {
+	asc.usernameTemplate = exerrors.Must(template.New("username").Parse(asc.UsernameTemplate))
+	placeholder := strings.ToLower(random.String(16))
+	var buf strings.Builder
+	_ = asc.usernameTemplate.Execute(&buf, placeholder)
+	asc.usernamePrefix, asc.usernameSuffix, asc.usernameHasParts = strings.Cut(buf.String(), placeholder)
 }
 
 func (asc *AppserviceConfig) FormatUsername(username string) string {
-	if asc.usernameTemplate == nil {
-		asc.usernameTemplate = exerrors.Must(template.New("username").Parse(asc.UsernameTemplate))
-	}
+	asc.usernameInit.Do(asc.initUsernameTemplate)
 	var buf strings.Builder
 	_ = asc.usernameTemplate.Execute(&buf, username)
 	return buf.String()
 }
 
+// ParseUsername is the inverse of FormatUsername: it extracts the username from a localpart generated by the template.
+// The returned bool is false if the localpart doesn't match the template.
+func (asc *AppserviceConfig) ParseUsername(localpart string) (string, bool) {
+	asc.usernameInit.Do(asc.initUsernameTemplate)
+	prefix, suffix := asc.usernamePrefix, asc.usernameSuffix
+	if !asc.usernameHasParts || len(localpart) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(localpart, prefix) || !strings.HasSuffix(localpart, suffix) {
+		return "", false
+	}
+	return localpart[len(prefix) : len(localpart)-len(suffix)], true
+}
+
+// ValidateUsernameTemplate checks that the username template is reversible, i.e. that ParseUsername
+// can extract the original username from any localpart generated using FormatUsername.
+//
+// This must be called when loading the config, before the template is used for anything else.
+func (asc *AppserviceConfig) ValidateUsernameTemplate() error {
+	_, err := template.New("username").Parse(asc.UsernameTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse username template: %w", err)
+	}
+	asc.usernameInit.Do(asc.initUsernameTemplate)
+	if !asc.usernameHasParts {
+		return fmt.Errorf("username template is missing user ID placeholder")
+	} else if strings.Count(asc.UsernameTemplate, "{{") != 1 {
+		return fmt.Errorf("username template must contain exactly one placeholder")
+	} else if static := asc.usernamePrefix + asc.usernameSuffix; static != "" && !id.ValidLocalpartRegex.MatchString(static) {
+		return fmt.Errorf("username template contains characters that aren't allowed in user IDs")
+	} else if _, isGhost := asc.ParseUsername(asc.Bot.Username); isGhost {
+		return fmt.Errorf("username template conflicts with bot username")
+	}
+	for _, testValue := range []string{"1234567890", "_meow=2f", "a"} {
+		formatted := asc.FormatUsername(testValue)
+		if parsed, ok := asc.ParseUsername(formatted); !ok || parsed != testValue {
+			return fmt.Errorf("username template is not reversible (%q formatted to %q)", testValue, formatted)
+		}
+	}
+	return nil
+}
+
 func (config *Config) MakeUserIDRegex(matcher string) *regexp.Regexp {
 	usernamePlaceholder := strings.ToLower(random.String(16))
 	usernameTemplate := fmt.Sprintf("@%s:%s",
@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgeconfig
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppserviceConfig_ParseUsername(t *testing.T) {
+	asc := &AppserviceConfig{UsernameTemplate: "meow_{{.}}_bridge", Bot: BotUserConfig{Username: "meowbot"}}
+	assert.NoError(t, asc.ValidateUsernameTemplate())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username, ok := asc.ParseUsername(asc.FormatUsername("1234"))
+			assert.True(t, ok)
+			assert.Equal(t, "1234", username)
+		}()
+	}
+	wg.Wait()
+
+	_, ok := asc.ParseUsername("meow__bridge")
+	assert.False(t, ok)
+	_, ok = asc.ParseUsername("meowbot")
+	assert.False(t, ok)
+}
+
+func TestAppserviceConfig_ParseUsername_Concurrent(t *testing.T) {
+	// Without validating first, the first concurrent calls must initialize the template safely
+	asc := &AppserviceConfig{UsernameTemplate: "meow_{{.}}"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username, ok := asc.ParseUsername("meow_1234")
+			assert.True(t, ok)
+			assert.Equal(t, "1234", username)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAppserviceConfig_ValidateUsernameTemplate(t *testing.T) {
+	for template, expectedErr := range map[string]string{
+		"meow_{{.}":         "failed to parse username template",
+		"meow":              "username template is missing user ID placeholder",
+		"meow_{{.}}_{{.}}":  "username template must contain exactly one placeholder",
+		"meow!{{.}}":        "username template contains characters that aren't allowed in user IDs",
+		"meow{{.}}":         "username template conflicts with bot username",
+		"meow_{{.}}_bridge": "",
+	} {
+		asc := &AppserviceConfig{UsernameTemplate: template, Bot: BotUserConfig{Username: "meowbot"}}
+		err := asc.ValidateUsernameTemplate()
+		if expectedErr == "" {
+			assert.NoError(t, err, template)
+		} else {
+			assert.ErrorContains(t, err, expectedErr, template)
+		}
+	}
+}
@@ -11,6 +11,7 @@ type BackfillConfig struct {
 	MaxInitialMessages   int  `yaml:"max_initial_messages"`
 	MaxCatchupMessages   int  `yaml:"max_catchup_messages"`
 	UnreadHoursThreshold int  `yaml:"unread_hours_threshold"`
+	DeferMedia           bool `yaml:"defer_media"`
 
 	Threads BackfillThreadsConfig `yaml:"threads"`
 	Queue   BackfillQueueConfig   `yaml:"queue"`
@@ -26,6 +27,9 @@ type BackfillQueueConfig struct {
 	BatchDelay int  `yaml:"batch_delay"`
 	MaxBatches int  `yaml:"max_batches"`
 
+	Concurrency         int `yaml:"concurrency"`
+	MaxBatchesPerMinute int `yaml:"max_batches_per_minute"`
+
 	MaxBatchesOverride map[string]int `yaml:"max_batches_override"`
 }
 
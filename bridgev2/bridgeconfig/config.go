@@ -7,6 +7,8 @@
 package bridgeconfig
 
 import (
+	"fmt"
+
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/zeroconfig"
 	"gopkg.in/yaml.v3"
@@ -17,7 +19,7 @@ import (
 type Config struct {
 	Network      yaml.Node          `yaml:"network"`
 	Bridge       BridgeConfig       `yaml:"bridge"`
-	Database     dbutil.Config      `yaml:"database"`
+	Database     DatabaseConfig     `yaml:"database"`
 	Homeserver   HomeserverConfig   `yaml:"homeserver"`
 	AppService   AppserviceConfig   `yaml:"appservice"`
 	Matrix       MatrixConfig       `yaml:"matrix"`
@@ -56,21 +58,82 @@ type CleanupOnLogouts struct {
 	BadCredentials CleanupOnLogout `yaml:"bad_credentials"`
 }
 
+type ChatDeleteAction string
+
+const (
+	ChatDeleteActionNull     ChatDeleteAction = ""
+	ChatDeleteActionNothing  ChatDeleteAction = "nothing"
+	ChatDeleteActionDelete   ChatDeleteAction = "delete"
+	ChatDeleteActionArchive  ChatDeleteAction = "archive"
+	ChatDeleteActionReadOnly ChatDeleteAction = "read_only"
+)
+
+func (cda *ChatDeleteAction) UnmarshalYAML(node *yaml.Node) error {
+	var val string
+	err := node.Decode(&val)
+	if err != nil {
+		return err
+	}
+	switch action := ChatDeleteAction(val); action {
+	case ChatDeleteActionNull, ChatDeleteActionNothing, ChatDeleteActionDelete, ChatDeleteActionArchive, ChatDeleteActionReadOnly:
+		*cda = action
+		return nil
+	default:
+		return fmt.Errorf("invalid deleted chat handling action %q", val)
+	}
+}
+
+type DeletedChatHandling struct {
+	Deleted ChatDeleteAction `yaml:"deleted"`
+	Left    ChatDeleteAction `yaml:"left"`
+}
+
+type CleanupOrphanedPortals struct {
+	Enabled  bool `yaml:"enabled"`
+	Interval int  `yaml:"interval"`
+	DryRun   bool `yaml:"dry_run"`
+}
+
+type MultiInstanceConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	InstanceID string `yaml:"instance_id"`
+	LockTTL    int    `yaml:"lock_ttl"`
+}
+
+type MessageRetryConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	MaxAttempts  int  `yaml:"max_attempts"`
+	InitialDelay int  `yaml:"initial_delay"`
+}
+
+type LoginMetadataEncryptionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Key     string `yaml:"key"`
+}
+
 type BridgeConfig struct {
-	CommandPrefix           string           `yaml:"command_prefix"`
-	PersonalFilteringSpaces bool             `yaml:"personal_filtering_spaces"`
-	PrivateChatPortalMeta   bool             `yaml:"private_chat_portal_meta"`
-	AsyncEvents             bool             `yaml:"async_events"`
-	SplitPortals            bool             `yaml:"split_portals"`
-	ResendBridgeInfo        bool             `yaml:"resend_bridge_info"`
-	BridgeMatrixLeave       bool             `yaml:"bridge_matrix_leave"`
-	TagOnlyOnCreate         bool             `yaml:"tag_only_on_create"`
-	MuteOnlyOnCreate        bool             `yaml:"mute_only_on_create"`
-	OutgoingMessageReID     bool             `yaml:"outgoing_message_re_id"`
-	CleanupOnLogout         CleanupOnLogouts `yaml:"cleanup_on_logout"`
-	Relay                   RelayConfig      `yaml:"relay"`
-	Permissions             PermissionConfig `yaml:"permissions"`
-	Backfill                BackfillConfig   `yaml:"backfill"`
+	CommandPrefix                string                        `yaml:"command_prefix"`
+	PersonalFilteringSpaces      bool                          `yaml:"personal_filtering_spaces"`
+	PrivateChatPortalMeta        bool                          `yaml:"private_chat_portal_meta"`
+	AsyncEvents                  bool                          `yaml:"async_events"`
+	SplitPortals                 bool                          `yaml:"split_portals"`
+	ResendBridgeInfo             bool                          `yaml:"resend_bridge_info"`
+	BridgeMatrixLeave            bool                          `yaml:"bridge_matrix_leave"`
+	TagOnlyOnCreate              bool                          `yaml:"tag_only_on_create"`
+	MuteOnlyOnCreate             bool                          `yaml:"mute_only_on_create"`
+	SyncMuteToNotificationPolicy bool                          `yaml:"sync_mute_to_notification_policy"`
+	CreationProgressThreshold    int                           `yaml:"creation_progress_threshold"`
+	OutgoingMessageReID          bool                          `yaml:"outgoing_message_re_id"`
+	CleanupOnLogout              CleanupOnLogouts              `yaml:"cleanup_on_logout"`
+	CleanupOrphanedPortals       CleanupOrphanedPortals        `yaml:"cleanup_orphaned_portals"`
+	DeletedChatHandling          DeletedChatHandling           `yaml:"deleted_chat_handling"`
+	MultiInstance                MultiInstanceConfig           `yaml:"multi_instance"`
+	LoginMetadataEncryption      LoginMetadataEncryptionConfig `yaml:"login_metadata_encryption"`
+	MessageRetry                 MessageRetryConfig            `yaml:"message_retry"`
+	Relay                        RelayConfig                   `yaml:"relay"`
+	Roles                        map[string]*Permissions       `yaml:"roles"`
+	Permissions                  PermissionConfig              `yaml:"permissions"`
+	Backfill                     BackfillConfig                `yaml:"backfill"`
 }
 
 type MatrixConfig struct {
@@ -82,6 +145,13 @@ type MatrixConfig struct {
 	UploadFileThreshold int64 `yaml:"upload_file_threshold"`
 }
 
+type DatabaseConfig struct {
+	dbutil.Config `yaml:",inline"`
+
+	SlowQueryThreshold string `yaml:"slow_query_threshold"`
+	QueryMetrics       bool   `yaml:"query_metrics"`
+}
+
 type AnalyticsConfig struct {
 	Token  string `yaml:"token"`
 	URL    string `yaml:"url"`
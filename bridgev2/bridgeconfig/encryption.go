@@ -7,6 +7,7 @@
 package bridgeconfig
 
 import (
+	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -18,7 +19,8 @@ type EncryptionConfig struct {
 
 	PlaintextMentions bool `yaml:"plaintext_mentions"`
 
-	PickleKey string `yaml:"pickle_key"`
+	PickleKey  string      `yaml:"pickle_key"`
+	OlmBackend olm.Backend `yaml:"olm_backend"`
 
 	DeleteKeys struct {
 		DeleteOutboundOnAck       bool `yaml:"delete_outbound_on_ack"`
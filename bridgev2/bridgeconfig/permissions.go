@@ -9,6 +9,8 @@ package bridgeconfig
 import (
 	"fmt"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -18,14 +20,68 @@ import (
 )
 
 type Permissions struct {
-	SendEvents   bool `yaml:"send_events"`
-	Commands     bool `yaml:"commands"`
-	Login        bool `yaml:"login"`
-	DoublePuppet bool `yaml:"double_puppet"`
-	Admin        bool `yaml:"admin"`
-	ManageRelay  bool `yaml:"manage_relay"`
+	SendEvents    bool `yaml:"send_events"`
+	UseRelay      bool `yaml:"use_relay"`
+	Commands      bool `yaml:"commands"`
+	Login         bool `yaml:"login"`
+	DoublePuppet  bool `yaml:"double_puppet"`
+	Admin         bool `yaml:"admin"`
+	ManageRelay   bool `yaml:"manage_relay"`
+	ManagePortals bool `yaml:"manage_portals"`
+	Backfill      bool `yaml:"backfill"`
+	ManageLogins  bool `yaml:"manage_logins"`
+
+	// Role is the name of a custom role from the bridge.roles config section.
+	// It's only set until the role is resolved using [PermissionConfig.ResolveRoles].
+	Role string `yaml:"-"`
+}
+
+// Capability is a single granular permission that can be checked with [Permissions.Has].
+type Capability string
+
+const (
+	CapabilitySendEvents    Capability = "send_events"
+	CapabilityUseRelay      Capability = "use_relay"
+	CapabilityCommands      Capability = "commands"
+	CapabilityLogin         Capability = "login"
+	CapabilityDoublePuppet  Capability = "double_puppet"
+	CapabilityManageRelay   Capability = "manage_relay"
+	CapabilityManagePortals Capability = "manage_portals"
+	CapabilityBackfill      Capability = "backfill"
+	CapabilityManageLogins  Capability = "manage_logins"
+)
+
+// Has checks if the permissions include the given capability. Admins have every capability.
+func (p Permissions) Has(capability Capability) bool {
+	if p.Admin {
+		return true
+	}
+	switch capability {
+	case CapabilitySendEvents:
+		return p.SendEvents
+	case CapabilityUseRelay:
+		return p.UseRelay
+	case CapabilityCommands:
+		return p.Commands
+	case CapabilityLogin:
+		return p.Login
+	case CapabilityDoublePuppet:
+		return p.DoublePuppet
+	case CapabilityManageRelay:
+		return p.ManageRelay
+	case CapabilityManagePortals:
+		return p.ManagePortals
+	case CapabilityBackfill:
+		return p.Backfill
+	case CapabilityManageLogins:
+		return p.ManageLogins
+	default:
+		return false
+	}
 }
 
+// PermissionConfig maps users to permissions. The keys can be user IDs, server names or patterns
+// containing `*` wildcards (e.g. `@*:example.com` or `*.example.com`). A single `*` matches all users.
 type PermissionConfig map[string]*Permissions
 
 func boolToInt(val bool) int {
@@ -51,6 +107,8 @@ func (pc PermissionConfig) Get(userID id.UserID) Permissions {
 		return *level
 	} else if level, ok = pc[userID.Homeserver()]; len(userID.Homeserver()) > 0 && ok {
 		return *level
+	} else if level = pc.getByPattern(userID); level != nil {
+		return *level
 	} else if level, ok = pc["*"]; ok {
 		return *level
 	} else {
@@ -58,12 +116,67 @@ func (pc PermissionConfig) Get(userID id.UserID) Permissions {
 	}
 }
 
+// getByPattern finds the most specific wildcard pattern that matches the user ID or its server name.
+// Longer patterns are considered more specific, so e.g. `@admin-*:example.com` takes priority over `@*:example.com`.
+func (pc PermissionConfig) getByPattern(userID id.UserID) *Permissions {
+	var bestPattern string
+	var bestLevel *Permissions
+	for pattern, level := range pc {
+		if pattern == "*" || !strings.Contains(pattern, "*") {
+			continue
+		} else if len(pattern) < len(bestPattern) || (len(pattern) == len(bestPattern) && pattern > bestPattern) {
+			continue
+		}
+		target := string(userID)
+		if !strings.HasPrefix(pattern, "@") {
+			target = userID.Homeserver()
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			bestPattern = pattern
+			bestLevel = level
+		}
+	}
+	return bestLevel
+}
+
+// ResolveRoles replaces references to custom roles in the permission config with the permissions of the role.
+func (pc PermissionConfig) ResolveRoles(roles map[string]*Permissions) error {
+	for name, role := range roles {
+		if _, isBuiltin := namesToLevels[name]; isBuiltin {
+			return fmt.Errorf("custom role %q conflicts with built-in permission level", name)
+		} else if role == nil || role.Role != "" {
+			return fmt.Errorf("custom role %q must be a built-in permission level or a map of permissions", name)
+		}
+	}
+	keys := make([]string, 0, len(pc))
+	for key := range pc {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		level := pc[key]
+		if level == nil || level.Role == "" {
+			continue
+		}
+		role, ok := roles[level.Role]
+		if !ok {
+			return fmt.Errorf("unknown permission level or role %q for %s", level.Role, key)
+		}
+		resolved := *role
+		pc[key] = &resolved
+	}
+	return nil
+}
+
 var (
 	PermissionLevelBlock    = Permissions{}
-	PermissionLevelRelay    = Permissions{SendEvents: true}
-	PermissionLevelCommands = Permissions{SendEvents: true, Commands: true, ManageRelay: true}
-	PermissionLevelUser     = Permissions{SendEvents: true, Commands: true, ManageRelay: true, Login: true, DoublePuppet: true}
-	PermissionLevelAdmin    = Permissions{SendEvents: true, Commands: true, ManageRelay: true, Login: true, DoublePuppet: true, Admin: true}
+	PermissionLevelRelay    = Permissions{SendEvents: true, UseRelay: true}
+	PermissionLevelCommands = Permissions{SendEvents: true, UseRelay: true, Commands: true, ManageRelay: true}
+	PermissionLevelUser     = Permissions{SendEvents: true, UseRelay: true, Commands: true, ManageRelay: true, Login: true, DoublePuppet: true}
+	PermissionLevelAdmin    = Permissions{
+		SendEvents: true, UseRelay: true, Commands: true, ManageRelay: true, Login: true, DoublePuppet: true, Admin: true,
+		ManagePortals: true, Backfill: true, ManageLogins: true,
+	}
 )
 
 var namesToLevels = map[string]Permissions{
@@ -90,12 +203,20 @@ func (p *Permissions) UnmarshalYAML(perm *yaml.Node) error {
 		var ok bool
 		*p, ok = namesToLevels[strings.ToLower(perm.Value)]
 		if !ok {
-			return fmt.Errorf("invalid permissions level %s", perm.Value)
+			// Not a built-in level, assume it's a custom role which will be resolved later
+			*p = Permissions{Role: perm.Value}
 		}
 		return nil
 	case "!!map":
 		err := perm.Decode((*umPerm)(p))
-		return err
+		if err != nil {
+			return err
+		}
+		// Relaying used to be allowed for anyone who can send events, so keep that as the default
+		if !hasMapKey(perm, "use_relay") {
+			p.UseRelay = p.SendEvents
+		}
+		return nil
 	case "!!int":
 		val, err := strconv.Atoi(perm.Value)
 		if err != nil {
@@ -118,8 +239,19 @@ func (p *Permissions) UnmarshalYAML(perm *yaml.Node) error {
 	}
 }
 
+func hasMapKey(node *yaml.Node, key string) bool {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Permissions) MarshalYAML() (any, error) {
-	if level, ok := levelsToNames[*p]; ok {
+	if p.Role != "" {
+		return p.Role, nil
+	} else if level, ok := levelsToNames[*p]; ok {
 		return level, nil
 	}
 	return umPerm(*p), nil
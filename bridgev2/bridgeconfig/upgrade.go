@@ -31,6 +31,8 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Bool, "bridge", "bridge_matrix_leave")
 	helper.Copy(up.Bool, "bridge", "tag_only_on_create")
 	helper.Copy(up.Bool, "bridge", "mute_only_on_create")
+	helper.Copy(up.Bool, "bridge", "sync_mute_to_notification_policy")
+	helper.Copy(up.Int, "bridge", "creation_progress_threshold")
 	helper.Copy(up.Bool, "bridge", "cleanup_on_logout", "enabled")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "manual", "private")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "manual", "relayed")
@@ -40,11 +42,29 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "bad_credentials", "relayed")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "bad_credentials", "shared_no_users")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "bad_credentials", "shared_has_users")
+	helper.Copy(up.Bool, "bridge", "cleanup_orphaned_portals", "enabled")
+	helper.Copy(up.Int, "bridge", "cleanup_orphaned_portals", "interval")
+	helper.Copy(up.Bool, "bridge", "cleanup_orphaned_portals", "dry_run")
+	helper.Copy(up.Str, "bridge", "deleted_chat_handling", "deleted")
+	helper.Copy(up.Str, "bridge", "deleted_chat_handling", "left")
+	helper.Copy(up.Bool, "bridge", "multi_instance", "enabled")
+	helper.Copy(up.Str, "bridge", "multi_instance", "instance_id")
+	helper.Copy(up.Int, "bridge", "multi_instance", "lock_ttl")
+	helper.Copy(up.Bool, "bridge", "login_metadata_encryption", "enabled")
+	if key, ok := helper.Get(up.Str, "bridge", "login_metadata_encryption", "key"); !ok || key == "generate" {
+		helper.Set(up.Str, random.String(64), "bridge", "login_metadata_encryption", "key")
+	} else {
+		helper.Copy(up.Str, "bridge", "login_metadata_encryption", "key")
+	}
+	helper.Copy(up.Bool, "bridge", "message_retry", "enabled")
+	helper.Copy(up.Int, "bridge", "message_retry", "max_attempts")
+	helper.Copy(up.Int, "bridge", "message_retry", "initial_delay")
 	helper.Copy(up.Bool, "bridge", "relay", "enabled")
 	helper.Copy(up.Bool, "bridge", "relay", "admin_only")
 	helper.Copy(up.List, "bridge", "relay", "default_relays")
 	helper.Copy(up.Map, "bridge", "relay", "message_formats")
 	helper.Copy(up.Str, "bridge", "relay", "displayname_format")
+	helper.Copy(up.Map, "bridge", "roles")
 	helper.Copy(up.Map, "bridge", "permissions")
 
 	if dbType, ok := helper.Get(up.Str, "database", "type"); ok && dbType == "sqlite3" {
@@ -57,6 +77,8 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Int, "database", "max_idle_conns")
 	helper.Copy(up.Str|up.Null, "database", "max_conn_idle_time")
 	helper.Copy(up.Str|up.Null, "database", "max_conn_lifetime")
+	helper.Copy(up.Str|up.Null, "database", "slow_query_threshold")
+	helper.Copy(up.Bool, "database", "query_metrics")
 
 	helper.Copy(up.Str, "homeserver", "address")
 	helper.Copy(up.Str, "homeserver", "domain")
@@ -127,12 +149,15 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Int, "backfill", "max_initial_messages")
 	helper.Copy(up.Int, "backfill", "max_catchup_messages")
 	helper.Copy(up.Int, "backfill", "unread_hours_threshold")
+	helper.Copy(up.Bool, "backfill", "defer_media")
 	helper.Copy(up.Int, "backfill", "threads", "max_initial_messages")
 	helper.Copy(up.Bool, "backfill", "queue", "enabled")
 	helper.Copy(up.Int, "backfill", "queue", "batch_size")
 	helper.Copy(up.Int, "backfill", "queue", "batch_delay")
 	helper.Copy(up.Int, "backfill", "queue", "max_batches")
 	helper.Copy(up.Map, "backfill", "queue", "max_batches_override")
+	helper.Copy(up.Int, "backfill", "queue", "concurrency")
+	helper.Copy(up.Int, "backfill", "queue", "max_batches_per_minute")
 
 	helper.Copy(up.Map, "double_puppet", "servers")
 	helper.Copy(up.Bool, "double_puppet", "allow_discovery")
@@ -148,6 +173,7 @@ func doUpgrade(helper up.Helper) {
 	} else {
 		helper.Copy(up.Str, "encryption", "pickle_key")
 	}
+	helper.Copy(up.Str|up.Null, "encryption", "olm_backend")
 	helper.Copy(up.Bool, "encryption", "delete_keys", "delete_outbound_on_ack")
 	helper.Copy(up.Bool, "encryption", "delete_keys", "dont_store_outbound")
 	helper.Copy(up.Bool, "encryption", "delete_keys", "ratchet_on_decrypt")
@@ -172,6 +198,7 @@ var SpacedBlocks = [][]string{
 	{"bridge", "bridge_matrix_leave"},
 	{"bridge", "cleanup_on_logout"},
 	{"bridge", "relay"},
+	{"bridge", "roles"},
 	{"bridge", "permissions"},
 	{"database"},
 	{"homeserver"},
@@ -0,0 +1,213 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ChunkedUploader is implemented by network connectors for remote networks that require media to be uploaded
+// in chunks. It's used with [UploadMediaInChunks], which streams the media from Matrix into the uploader.
+type ChunkedUploader interface {
+	// NegotiateChunkSize is called with the total size of the file (or -1 if unknown) before anything is uploaded.
+	// It must return the size of chunks to upload. If the file can't be sent, e.g. because it's too large for
+	// the remote network, an error like [ErrMediaTooLarge] should be returned.
+	NegotiateChunkSize(ctx context.Context, totalSize int64) (int, error)
+	// UploadChunk uploads a single chunk. The same chunk may be passed again if uploading it failed.
+	// The chunk data must not be retained after the method returns.
+	UploadChunk(ctx context.Context, chunk *MediaChunk) error
+	// Finish is called after all chunks have been uploaded successfully and the downloaded data has been verified.
+	Finish(ctx context.Context, totalSize int64) error
+	// Abort is called if downloading or uploading fails after the chunk size was negotiated.
+	// It's never called after Finish.
+	Abort(ctx context.Context, err error)
+}
+
+// MediaChunk is a part of a file passed to [ChunkedUploader.UploadChunk].
+type MediaChunk struct {
+	Index  int
+	Offset int64
+	Data   []byte
+	// Last is true if this is the final chunk of the file.
+	Last bool
+}
+
+// ErrChunkNotRetryable can be wrapped by errors returned from [ChunkedUploader.UploadChunk]
+// to prevent [UploadMediaInChunks] from retrying the chunk.
+var ErrChunkNotRetryable = errors.New("chunk upload is not retryable")
+
+type ChunkedUploadParams struct {
+	// MaxRetries is the number of times a single chunk is retried before giving up. Defaults to 3.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, which is doubled for each subsequent retry. Defaults to 1 second.
+	RetryDelay time.Duration
+	// Progress is an optional callback that is called after each chunk. The total is -1 if the size isn't known.
+	Progress func(uploaded, total int64)
+}
+
+// UploadMediaInChunks downloads the given Matrix media and passes it to the uploader one chunk at a time.
+//
+// If the Matrix connector implements [StreamingDownloadMatrixAPI], the download is streamed directly into
+// the uploader with only two chunks kept in memory. Otherwise, the file is downloaded to disk first.
+// The size parameter is the size specified in the Matrix event, which is used if the server doesn't report it.
+func UploadMediaInChunks(
+	ctx context.Context,
+	intent MatrixAPI,
+	uri id.ContentURIString,
+	file *event.EncryptedFileInfo,
+	size int64,
+	uploader ChunkedUploader,
+	params *ChunkedUploadParams,
+) error {
+	if params == nil {
+		params = &ChunkedUploadParams{}
+	}
+	streamer, ok := intent.(StreamingDownloadMatrixAPI)
+	if !ok {
+		return intent.DownloadMediaToFile(ctx, uri, file, false, func(f *os.File) error {
+			info, err := f.Stat()
+			if err != nil {
+				return fmt.Errorf("failed to stat downloaded file: %w", err)
+			}
+			return uploadReaderInChunks(ctx, f, info.Size(), uploader, params, nil)
+		})
+	}
+	reader, streamSize, err := streamer.DownloadMediaStream(ctx, uri, file)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDownloadFailed, err)
+	}
+	if streamSize < 0 && size > 0 {
+		streamSize = size
+	}
+	var closed bool
+	err = uploadReaderInChunks(ctx, reader, streamSize, uploader, params, func() error {
+		closed = true
+		// Encrypted streams verify the hash when closing, so this must be done before finishing the upload.
+		closeErr := reader.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %w", ErrMediaDownloadFailed, closeErr)
+		}
+		return nil
+	})
+	if !closed {
+		_ = reader.Close()
+	}
+	return err
+}
+
+// uploadReaderInChunks uploads all chunks from the reader. The beforeFinish function is called after
+// all chunks have been uploaded, and the upload is aborted instead of finished if it returns an error.
+func uploadReaderInChunks(
+	ctx context.Context,
+	reader io.Reader,
+	totalSize int64,
+	uploader ChunkedUploader,
+	params *ChunkedUploadParams,
+	beforeFinish func() error,
+) error {
+	chunkSize, err := uploader.NegotiateChunkSize(ctx, totalSize)
+	if err != nil {
+		return err
+	} else if chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	uploadedSize, err := uploadChunks(ctx, reader, totalSize, chunkSize, uploader, params)
+	if err == nil && beforeFinish != nil {
+		err = beforeFinish()
+	}
+	if err != nil {
+		uploader.Abort(ctx, err)
+		return err
+	}
+	// Abort must not be called after Finish, even if Finish fails
+	return uploader.Finish(ctx, uploadedSize)
+}
+
+func readChunk(reader io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(reader, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("%w: %w", ErrMediaDownloadFailed, err)
+	}
+	return n, err
+}
+
+func uploadChunks(ctx context.Context, reader io.Reader, totalSize int64, chunkSize int, uploader ChunkedUploader, params *ChunkedUploadParams) (int64, error) {
+	buf := make([]byte, chunkSize)
+	n, err := readChunk(reader, buf)
+	if err != nil {
+		return 0, err
+	}
+	// The next chunk is read ahead to know whether the current chunk is the last one
+	next := make([]byte, chunkSize)
+	var offset int64
+	for index := 0; ; index++ {
+		var nextN int
+		if n == chunkSize {
+			nextN, err = readChunk(reader, next)
+			if err != nil {
+				return offset, err
+			}
+		}
+		chunk := &MediaChunk{Index: index, Offset: offset, Data: buf[:n], Last: nextN == 0}
+		err = uploadChunkWithRetries(ctx, uploader, chunk, params)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+		if params.Progress != nil {
+			params.Progress(offset, totalSize)
+		}
+		if chunk.Last {
+			break
+		}
+		buf, next = next, buf
+		n = nextN
+	}
+	return offset, nil
+}
+
+func uploadChunkWithRetries(ctx context.Context, uploader ChunkedUploader, chunk *MediaChunk, params *ChunkedUploadParams) error {
+	maxRetries := params.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	delay := params.RetryDelay
+	if delay <= 0 {
+		delay = 1 * time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		err := uploader.UploadChunk(ctx, chunk)
+		if err == nil {
+			return nil
+		} else if attempt >= maxRetries || errors.Is(err, ErrChunkNotRetryable) || ctx.Err() != nil {
+			return fmt.Errorf("%w: failed to upload chunk #%d: %w", ErrMediaReuploadFailed, chunk.Index, err)
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Int("chunk_index", chunk.Index).
+			Int("attempt", attempt+1).
+			Stringer("retry_in", delay).
+			Msg("Failed to upload chunk, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"strconv"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+)
+
+var CommandBackfill = &FullHandler{
+	Func: fnBackfill,
+	Name: "backfill",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Backfill older messages in the current portal",
+		Args:        "[_batches_]",
+	},
+	RequiresPortal:     true,
+	RequiresLogin:      true,
+	RequiresCapability: bridgeconfig.CapabilityBackfill,
+}
+
+func fnBackfill(ce *Event) {
+	if !ce.Bridge.Config.Backfill.Enabled {
+		ce.Reply("Backfilling is not enabled on this bridge")
+		return
+	}
+	batches := 1
+	if len(ce.Args) > 0 {
+		var err error
+		batches, err = strconv.Atoi(ce.Args[0])
+		if err != nil || batches <= 0 {
+			ce.Reply("**Usage:** `$cmdprefix backfill [batches]`")
+			return
+		}
+	}
+	login, _, err := ce.Portal.FindPreferredLogin(ce.Ctx, ce.User, false)
+	if errors.Is(err, bridgev2.ErrNotLoggedIn) || (err == nil && login == nil) {
+		ce.Reply("You're not logged in to this portal")
+		return
+	} else if err != nil {
+		ce.Log.Err(err).Msg("Failed to find login for backfill")
+		ce.Reply("Failed to find login: %v", err)
+		return
+	}
+	done, err := ce.Portal.DoManualBackfill(ce.Ctx, login, batches)
+	if err != nil {
+		ce.Log.Err(err).Int("batches_done", done).Msg("Failed to backfill portal")
+		ce.Reply("Failed to backfill after %d batches: %v", done, err)
+		return
+	}
+	ce.Reply("Backfilled %d batches", done)
+}
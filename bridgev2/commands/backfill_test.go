@@ -0,0 +1,22 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+)
+
+func TestFnBackfill_RequiresCapability(t *testing.T) {
+	ce, matrix := newTestEvent(t, "backfill")
+	ce.User.Permissions = bridgeconfig.Permissions{Commands: true}
+	CommandBackfill.Run(ce)
+	assert.Equal(t, "That command requires the `backfill` permission.", getReplyBody(t, matrix.bot.popSent()))
+}
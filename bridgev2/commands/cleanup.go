@@ -7,40 +7,69 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
 )
 
+// parseDeletePortalFlags parses the flags of the delete-portal and delete-all-portals commands.
+func parseDeletePortalFlags(ce *Event) (*bridgev2.DeletePortalOpts, bool) {
+	opts := &bridgev2.DeletePortalOpts{CleanupRoom: true, KickUsers: true, Reason: "Deleting portal"}
+	for _, arg := range ce.Args {
+		switch strings.ToLower(arg) {
+		case "--keep-users":
+			opts.KickUsers = false
+		case "--leave":
+			opts.LeaveRoom = true
+		default:
+			ce.Reply("Unknown flag `%s`. **Usage:** `$cmdprefix %s [--keep-users] [--leave]`", arg, ce.Command)
+			return nil, false
+		}
+	}
+	return opts, true
+}
+
 var CommandDeletePortal = &FullHandler{
 	Func: func(ce *Event) {
-		// TODO clean up child portals?
-		err := ce.Portal.Delete(ce.Ctx)
-		if err != nil {
-			ce.Reply("Failed to delete portal: %v", err)
+		opts, ok := parseDeletePortalFlags(ce)
+		if !ok {
 			return
 		}
-		err = ce.Bot.DeleteRoom(ce.Ctx, ce.Portal.MXID, false)
-		if err != nil {
+		// TODO clean up child portals?
+		err := ce.Portal.DeleteWithOpts(ce.Ctx, opts)
+		if errors.Is(err, bridgev2.ErrPortalRoomCleanupFailed) {
 			ce.Reply("Failed to clean up room: %v", err)
+		} else if err != nil {
+			ce.Reply("Failed to delete portal: %v", err)
+			return
 		}
 		ce.MessageStatus.DisableMSS = true
 	},
 	Name: "delete-portal",
 	Help: HelpMeta{
 		Section:     HelpSectionAdmin,
-		Description: "Delete the current portal room",
+		Description: "Delete the current portal room. By default, all users are kicked and the room is deleted. `--keep-users` only removes ghosts and `--leave` makes the bridge bot leave the room instead of deleting it.",
+		Args:        "[--keep-users] [--leave]",
 	},
-	RequiresAdmin:  true,
-	RequiresPortal: true,
+	RequiresCapability: bridgeconfig.CapabilityManagePortals,
+	RequiresPortal:     true,
 }
 
 var CommandDeleteAllPortals = &FullHandler{
 	Func: func(ce *Event) {
+		opts, ok := parseDeletePortalFlags(ce)
+		if !ok {
+			return
+		}
 		portals, err := ce.Bridge.GetAllPortals(ce.Ctx)
 		if err != nil {
 			ce.Reply("Failed to get portals: %v", err)
 			return
 		}
-		bridgev2.DeleteManyPortals(ce.Ctx, portals, func(portal *bridgev2.Portal, delete bool, err error) {
+		bridgev2.DeleteManyPortalsWithOpts(ce.Ctx, portals, opts, func(portal *bridgev2.Portal, delete bool, err error) {
 			if !delete {
 				ce.Reply("Failed to delete portal %s: %v", portal.MXID, err)
 			} else {
@@ -51,7 +80,67 @@ var CommandDeleteAllPortals = &FullHandler{
 	Name: "delete-all-portals",
 	Help: HelpMeta{
 		Section:     HelpSectionAdmin,
-		Description: "Delete all portals the bridge knows about",
+		Description: "Delete all portals the bridge knows about. Takes the same flags as `delete-portal`.",
+		Args:        "[--keep-users] [--leave]",
+	},
+	RequiresCapability: bridgeconfig.CapabilityManagePortals,
+}
+
+var CommandDeleteOrphanedPortals = &FullHandler{
+	Func: func(ce *Event) {
+		dryRun := len(ce.Args) > 0 && strings.ToLower(ce.Args[0]) == "--dry-run"
+		orphans, err := ce.Bridge.ReapOrphanedPortals(ce.Ctx, dryRun, func(portal *bridgev2.Portal, delete bool, err error) {
+			if !delete {
+				ce.Reply("Failed to delete portal %s: %v", portal.MXID, err)
+			} else {
+				ce.Reply("Failed to clean up room %s: %v", portal.MXID, err)
+			}
+		})
+		if err != nil {
+			ce.Reply("Failed to find orphaned portals: %v", err)
+			return
+		} else if len(orphans) == 0 {
+			ce.Reply("No orphaned portals found")
+			return
+		}
+		lines := make([]string, len(orphans))
+		for i, orphan := range orphans {
+			lines[i] = fmt.Sprintf("* %s (%s)", orphan.Portal.MXID, orphan.Reason)
+		}
+		if dryRun {
+			ce.Reply("Found %d orphaned portals:\n\n%s", len(orphans), strings.Join(lines, "\n"))
+		} else {
+			ce.Reply("Deleted %d orphaned portals:\n\n%s", len(orphans), strings.Join(lines, "\n"))
+		}
+	},
+	Name: "delete-orphaned-portals",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Delete portals whose Matrix room is gone or has no Matrix users",
+		Args:        "[--dry-run]",
+	},
+	RequiresCapability: bridgeconfig.CapabilityManagePortals,
+}
+
+var CommandEncryptLoginMetadata = &FullHandler{
+	Func: func(ce *Event) {
+		if ce.Bridge.DB.UserLogin.Crypter == nil {
+			ce.Reply("Login metadata encryption is not enabled in the config")
+			return
+		}
+		migrated, err := ce.Bridge.DB.UserLogin.EncryptPlaintextMetadata(ce.Ctx)
+		if err != nil {
+			ce.Reply("Failed to encrypt login metadata: %v", err)
+		} else if migrated == 0 {
+			ce.Reply("All logins are already encrypted")
+		} else {
+			ce.Reply("Encrypted metadata of %d logins", migrated)
+		}
+	},
+	Name: "encrypt-login-metadata",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Encrypt the metadata of logins that were stored before login metadata encryption was enabled",
 	},
 	RequiresAdmin: true,
 }
@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/exfmt"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+var CommandDisappearingTimer = &FullHandler{
+	Func:    fnDisappearingTimer,
+	Name:    "disappearing-timer",
+	Aliases: []string{"disappear-timer"},
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "View or change the disappearing message timer of the current portal",
+		Args:        "[_duration_ | `off`] [--after-read]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+// parseDisappearingDuration parses durations like 30s, 12h or 7d. Days and weeks aren't supported by [time.ParseDuration].
+func parseDisappearingDuration(val string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(val, "w"):
+		unit = 7 * 24 * time.Hour
+	case strings.HasSuffix(val, "d"):
+		unit = 24 * time.Hour
+	default:
+		return time.ParseDuration(val)
+	}
+	count, err := strconv.Atoi(val[:len(val)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", val)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+func formatDisappearingSetting(setting database.DisappearingSetting) string {
+	if setting.Type == database.DisappearingTypeNone || setting.Timer == 0 {
+		return "off"
+	}
+	formatted := exfmt.DurationCustom(setting.Timer, nil, exfmt.Day, time.Hour, time.Minute, time.Second)
+	if setting.Type == database.DisappearingTypeAfterRead {
+		return formatted + " after reading"
+	}
+	return formatted + " after sending"
+}
+
+func fnDisappearingTimer(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Disappearing message timer: %s", formatDisappearingSetting(ce.Portal.Disappear))
+		return
+	}
+	setting := database.DisappearingSetting{Type: database.DisappearingTypeAfterSend}
+	var durationArg string
+	for _, arg := range ce.Args {
+		if arg == "--after-read" {
+			setting.Type = database.DisappearingTypeAfterRead
+		} else if durationArg == "" {
+			durationArg = arg
+		} else {
+			ce.Reply("**Usage:** `$cmdprefix disappearing-timer [duration | off] [--after-read]`")
+			return
+		}
+	}
+	if durationArg != "off" && durationArg != "0" {
+		var err error
+		setting.Timer, err = parseDisappearingDuration(durationArg)
+		if err != nil || setting.Timer < 0 {
+			ce.Reply("Invalid duration `%s`. Use e.g. `30s`, `12h` or `7d`, or `off` to disable disappearing messages.", durationArg)
+			return
+		}
+	}
+	changed, err := ce.Portal.SetDisappearingTimer(ce.Ctx, ce.User, setting)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to change disappearing timer")
+		ce.Reply("Failed to change disappearing timer: %v", err)
+	} else if !changed {
+		ce.Reply("Disappearing message timer was not changed (currently %s)", formatDisappearingSetting(ce.Portal.Disappear))
+	} else {
+		ce.React("✅")
+	}
+}
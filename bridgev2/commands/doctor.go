@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandDoctor = &FullHandler{
+	Func: fnDoctor,
+	Name: "doctor",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Check that a portal room is consistent with the remote chat, and optionally fix safe problems",
+		Args:        "[_room ID_] [--fix]",
+	},
+	RequiresCapability: bridgeconfig.CapabilityManagePortals,
+}
+
+func fnDoctor(ce *Event) {
+	fix := slices.Contains(ce.Args, "--fix")
+	args := slices.DeleteFunc(slices.Clone(ce.Args), func(arg string) bool {
+		return arg == "--fix"
+	})
+	portal := ce.Portal
+	if len(args) > 0 {
+		var err error
+		portal, err = ce.Bridge.GetPortalByMXID(ce.Ctx, id.RoomID(args[0]))
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to get portal")
+			ce.Reply("Failed to get portal: %v", err)
+			return
+		}
+	}
+	if portal == nil {
+		ce.Reply("Usage: `$cmdprefix doctor [room ID] [--fix]` (the room ID can be omitted in portal rooms)")
+		return
+	}
+	source, err := getDoctorSourceLogin(ce, portal)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to find login for portal")
+		ce.Reply("Failed to find login for portal: %v", err)
+		return
+	}
+	problems, err := portal.Diagnose(ce.Ctx, source, fix)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to diagnose portal")
+		ce.Reply("Failed to diagnose portal: %v", err)
+		return
+	}
+	var notes []string
+	if source == nil {
+		notes = append(notes, "No login found in the portal, skipped checking the member list.")
+	}
+	if len(problems) == 0 {
+		notes = append(notes, fmt.Sprintf("No problems found in %s", portal.MXID))
+		ce.Reply(strings.Join(notes, "\n\n"))
+		return
+	}
+	lines := make([]string, len(problems))
+	var fixable int
+	for i, problem := range problems {
+		lines[i] = problem.Description
+		if problem.FixErr != nil {
+			lines[i] += fmt.Sprintf(" (fix failed: %v)", problem.FixErr)
+		} else if problem.Fixed {
+			lines[i] += " (fixed)"
+		} else if problem.Fixable {
+			fixable++
+		}
+	}
+	notes = append(notes, fmt.Sprintf("Found %d problems in %s:\n\n```diff\n%s\n```", len(problems), portal.MXID, strings.Join(lines, "\n")))
+	if fixable > 0 {
+		notes = append(notes, fmt.Sprintf("%d problems can be fixed with `$cmdprefix doctor %s --fix`", fixable, portal.MXID))
+	}
+	ce.Reply(strings.Join(notes, "\n\n"))
+}
+
+func getDoctorSourceLogin(ce *Event, portal *bridgev2.Portal) (*bridgev2.UserLogin, error) {
+	login, _, err := portal.FindPreferredLogin(ce.Ctx, ce.User, false)
+	if err == nil && login != nil {
+		return login, nil
+	}
+	logins, err := ce.Bridge.GetUserLoginsInPortal(ce.Ctx, portal.PortalKey)
+	if err != nil || len(logins) == 0 {
+		return nil, err
+	}
+	return logins[0], nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/configupgrade"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// testBot is a MatrixAPI that records sent messages. Methods that aren't overridden panic if called.
+type testBot struct {
+	bridgev2.MatrixAPI
+
+	lock sync.Mutex
+	sent []*event.Content
+}
+
+func (tb *testBot) SendMessage(ctx context.Context, roomID id.RoomID, eventType event.Type, content *event.Content, extra *bridgev2.MatrixSendExtra) (*mautrix.RespSendEvent, error) {
+	tb.lock.Lock()
+	tb.sent = append(tb.sent, content)
+	tb.lock.Unlock()
+	return &mautrix.RespSendEvent{EventID: "$reply"}, nil
+}
+
+func (tb *testBot) popSent() []*event.Content {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	sent := tb.sent
+	tb.sent = nil
+	return sent
+}
+
+// testMatrix is a MatrixConnector that can fetch events. Methods that aren't overridden panic if called.
+type testMatrix struct {
+	bridgev2.MatrixConnector
+	bot    *testBot
+	events map[id.EventID]*event.Event
+}
+
+var _ bridgev2.MatrixConnectorWithEventFetching = (*testMatrix)(nil)
+
+func (tm *testMatrix) Init(*bridgev2.Bridge)         {}
+func (tm *testMatrix) BotIntent() bridgev2.MatrixAPI { return tm.bot }
+func (tm *testMatrix) GetMemberInfo(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	return nil, nil
+}
+func (tm *testMatrix) SendMessageStatus(ctx context.Context, status *bridgev2.MessageStatus, evt *bridgev2.MessageStatusEventInfo) {
+}
+func (tm *testMatrix) FetchEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	return tm.events[eventID], nil
+}
+
+type testNetwork struct {
+	bridgev2.NetworkConnector
+}
+
+func (tn *testNetwork) Init(*bridgev2.Bridge) {}
+func (tn *testNetwork) GetName() bridgev2.BridgeName {
+	return bridgev2.BridgeName{DisplayName: "Test", NetworkID: "test"}
+}
+func (tn *testNetwork) GetDBMetaTypes() database.MetaTypes {
+	return database.MetaTypes{}
+}
+func (tn *testNetwork) GetConfig() (string, any, configupgrade.Upgrader) { return "", nil, nil }
+
+// newTestEvent creates a command event sent by @alice:example.com in a portal room.
+func newTestEvent(t *testing.T, command string) (*Event, *testMatrix) {
+	t.Helper()
+	ctx := context.Background()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000")
+	require.NoError(t, err)
+	// Every connection to :memory: gets its own database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	matrix := &testMatrix{bot: &testBot{}, events: make(map[id.EventID]*event.Event)}
+	cfg := &bridgeconfig.BridgeConfig{CommandPrefix: "!test"}
+	br := bridgev2.NewBridge("test", db, zerolog.Nop(), cfg, matrix, &testNetwork{}, NewProcessor)
+	require.NoError(t, br.DB.Upgrade(ctx))
+
+	portal, err := br.GetPortalByKey(ctx, networkid.PortalKey{ID: "chat"})
+	require.NoError(t, err)
+	portal.MXID = "!chat:example.com"
+	require.NoError(t, portal.Save(ctx))
+	user, err := br.GetUserByMXID(ctx, "@alice:example.com")
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	return &Event{
+		Bot:        matrix.bot,
+		Bridge:     br,
+		Portal:     portal,
+		RoomID:     portal.MXID,
+		OrigRoomID: portal.MXID,
+		EventID:    "$command",
+		User:       user,
+		Command:    command,
+		Ctx:        ctx,
+		Log:        &log,
+	}, matrix
+}
+
+func getReplyBody(t *testing.T, sent []*event.Content) string {
+	t.Helper()
+	require.Len(t, sent, 1)
+	content, ok := sent[0].Parsed.(*event.MessageEventContent)
+	require.True(t, ok, "expected a message reply")
+	return content.Body
+}
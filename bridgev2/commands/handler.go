@@ -7,6 +7,7 @@
 package commands
 
 import (
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
 	"maunium.net/go/mautrix/event"
 )
 
@@ -49,6 +50,9 @@ type FullHandler struct {
 	RequiresLogin           bool
 	RequiresEventLevel      event.Type
 	RequiresLoginPermission bool
+	// RequiresCapability is a granular permission the user must have to use the command.
+	// Bridge admins have every capability.
+	RequiresCapability bridgeconfig.Capability
 }
 
 func (fh *FullHandler) GetHelp() HelpMeta {
@@ -84,6 +88,8 @@ func (fh *FullHandler) Run(ce *Event) {
 		ce.Reply("That command is limited to bridge administrators.")
 	} else if fh.RequiresLoginPermission && !ce.User.Permissions.Login {
 		ce.Reply("You do not have permissions to log into this bridge.")
+	} else if fh.RequiresCapability != "" && !ce.User.Permissions.Has(fh.RequiresCapability) {
+		ce.Reply("That command requires the `%s` permission.", fh.RequiresCapability)
 	} else if fh.RequiresEventLevel.Type != "" && !ce.User.Permissions.Admin && !fh.userHasRoomPermission(ce) {
 		ce.Reply("That command requires room admin rights.")
 	} else if fh.RequiresPortal && ce.Portal == nil {
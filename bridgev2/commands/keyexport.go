@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandExportRoomKeys = &FullHandler{
+	Func: fnExportRoomKeys,
+	Name: "export-room-keys",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Export the bridge bot's encryption keys for a portal. Only usable in the management room. The command message is redacted to hide the passphrase.",
+		Args:        "<_room ID_> <_passphrase_> [_since YYYY-MM-DD_]",
+	},
+	RequiresAdmin: true,
+}
+
+// requireManagementRoom redacts the command and checks that it was sent in the user's management room,
+// so that passphrases and key exports aren't shared with other room members.
+func requireManagementRoom(ce *Event) bool {
+	if ce.RoomID != ce.User.ManagementRoom {
+		ce.Redact()
+		ce.Reply("This command can only be used in your management room. Consider changing the passphrase you used.")
+		return false
+	}
+	return true
+}
+
+func fnExportRoomKeys(ce *Event) {
+	if !requireManagementRoom(ce) {
+		return
+	} else if len(ce.Args) < 2 || len(ce.Args) > 3 {
+		ce.Reply("**Usage:** `$cmdprefix export-room-keys <room ID> <passphrase> [since YYYY-MM-DD]`")
+		return
+	}
+	exporter, ok := ce.Bridge.Matrix.(bridgev2.MatrixConnectorWithKeyExport)
+	if !ok {
+		ce.Reply("This bridge does not support exporting keys")
+		return
+	}
+	ce.Redact()
+	roomID := id.RoomID(ce.Args[0])
+	ce.Args = ce.Args[1:]
+	since, ok := parseKeyExportArgs(ce)
+	if !ok {
+		return
+	}
+	portal, err := ce.Bridge.GetPortalByMXID(ce.Ctx, roomID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get portal for key export")
+		ce.Reply("Failed to get portal: %v", err)
+		return
+	} else if portal == nil {
+		ce.Reply("`%s` is not a portal room", roomID)
+		return
+	}
+	data, count, err := exporter.ExportRoomKeys(ce.Ctx, []id.RoomID{portal.MXID}, since, ce.Args[0])
+	if err != nil {
+		ce.Reply("Failed to export keys: %v", err)
+		return
+	} else if count == 0 {
+		ce.Reply("No keys found for that room")
+		return
+	}
+	sendKeyExport(ce, data, count, fmt.Sprintf("megolm-keys-%s.txt", portal.MXID))
+}
+
+func parseKeyExportArgs(ce *Event) (since time.Time, ok bool) {
+	if len(ce.Args) > 1 {
+		var err error
+		since, err = time.Parse(time.DateOnly, ce.Args[1])
+		if err != nil {
+			ce.Reply("Invalid date `%s`, expected format YYYY-MM-DD", ce.Args[1])
+			return
+		}
+	}
+	return since, true
+}
+
+func sendKeyExport(ce *Event, data []byte, count int, fileName string) {
+	mxc, file, err := ce.Bot.UploadMedia(ce.Ctx, ce.RoomID, data, fileName, "text/plain")
+	if err != nil {
+		ce.Reply("Failed to upload key export: %v", err)
+		return
+	}
+	_, err = ce.Bot.SendMessage(ce.Ctx, ce.RoomID, event.EventMessage, &event.Content{Parsed: &event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     fmt.Sprintf("Exported %d keys", count),
+		FileName: fileName,
+		URL:      mxc,
+		File:     file,
+		Info: &event.FileInfo{
+			MimeType: "text/plain",
+			Size:     len(data),
+		},
+	}}, nil)
+	if err != nil {
+		ce.Reply("Failed to send key export: %v", err)
+	}
+}
+
+var CommandExportKeys = &FullHandler{
+	Func: fnExportKeys,
+	Name: "export-keys",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Export the bridge bot's encryption keys for all portals of your logins. Only usable in the management room. The command message is redacted to hide the passphrase.",
+		Args:        "<_passphrase_> [_since YYYY-MM-DD_]",
+	},
+	RequiresAdmin: true,
+	RequiresLogin: true,
+}
+
+func fnExportKeys(ce *Event) {
+	if !requireManagementRoom(ce) {
+		return
+	} else if len(ce.Args) == 0 || len(ce.Args) > 2 {
+		ce.Reply("**Usage:** `$cmdprefix export-keys <passphrase> [since YYYY-MM-DD]`")
+		return
+	}
+	exporter, ok := ce.Bridge.Matrix.(bridgev2.MatrixConnectorWithKeyExport)
+	if !ok {
+		ce.Reply("This bridge does not support exporting keys")
+		return
+	}
+	ce.Redact()
+	since, ok := parseKeyExportArgs(ce)
+	if !ok {
+		return
+	}
+	roomIDs, err := getUserPortalRoomIDs(ce)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get user portals for key export")
+		ce.Reply("Failed to get your portals: %v", err)
+		return
+	} else if len(roomIDs) == 0 {
+		ce.Reply("You don't have any portals")
+		return
+	}
+	data, count, err := exporter.ExportRoomKeys(ce.Ctx, roomIDs, since, ce.Args[0])
+	if err != nil {
+		ce.Reply("Failed to export keys: %v", err)
+		return
+	} else if count == 0 {
+		ce.Reply("No keys found for your portals")
+		return
+	}
+	sendKeyExport(ce, data, count, fmt.Sprintf("megolm-keys-%s.txt", ce.User.MXID))
+}
+
+func getUserPortalRoomIDs(ce *Event) ([]id.RoomID, error) {
+	seen := make(map[id.RoomID]bool)
+	var roomIDs []id.RoomID
+	for _, login := range ce.User.GetUserLogins() {
+		userPortals, err := ce.Bridge.DB.UserPortal.GetAllForLogin(ce.Ctx, login.UserLogin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get portals of %s: %w", login.ID, err)
+		}
+		for _, up := range userPortals {
+			portal, err := ce.Bridge.GetExistingPortalByKey(ce.Ctx, up.Portal)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get portal %s: %w", up.Portal, err)
+			} else if portal == nil || portal.MXID == "" || seen[portal.MXID] {
+				continue
+			}
+			seen[portal.MXID] = true
+			roomIDs = append(roomIDs, portal.MXID)
+		}
+	}
+	return roomIDs, nil
+}
+
+var CommandImportKeys = &FullHandler{
+	Func: fnImportKeys,
+	Name: "import-keys",
+	Help: HelpMeta{
+		Section:     HelpSectionAdmin,
+		Description: "Import encryption keys into the bridge bot from a key export file. Reply to the uploaded file in the management room with this command. The command message is redacted to hide the passphrase.",
+		Args:        "<_passphrase_>",
+	},
+	RequiresAdmin: true,
+}
+
+func fnImportKeys(ce *Event) {
+	if !requireManagementRoom(ce) {
+		return
+	} else if len(ce.Args) != 1 {
+		ce.Reply("**Usage:** `$cmdprefix import-keys <passphrase>` as a reply to a key export file")
+		return
+	}
+	importer, ok := ce.Bridge.Matrix.(bridgev2.MatrixConnectorWithKeyImport)
+	fetcher, ok2 := ce.Bridge.Matrix.(bridgev2.MatrixConnectorWithEventFetching)
+	if !ok || !ok2 {
+		ce.Reply("This bridge does not support importing keys")
+		return
+	}
+	ce.Redact()
+	if ce.ReplyTo == "" {
+		ce.Reply("You must reply to a key export file with this command")
+		return
+	}
+	evt, err := fetcher.FetchEvent(ce.Ctx, ce.RoomID, ce.ReplyTo)
+	if err != nil {
+		ce.Log.Err(err).Stringer("event_id", ce.ReplyTo).Msg("Failed to fetch key export event")
+		ce.Reply("Failed to fetch replied-to event: %v", err)
+		return
+	}
+	msg := evt.Content.AsMessage()
+	if msg.MsgType != event.MsgFile || (msg.URL == "" && msg.File == nil) {
+		ce.Reply("The replied-to event is not a file")
+		return
+	}
+	url := msg.URL
+	if msg.File != nil {
+		url = msg.File.URL
+	}
+	data, err := ce.Bot.DownloadMedia(ce.Ctx, url, msg.File)
+	if err != nil {
+		ce.Reply("Failed to download key export: %v", err)
+		return
+	}
+	imported, total, err := importer.ImportKeys(ce.Ctx, ce.Args[0], data)
+	if err != nil {
+		ce.Reply("Failed to import keys: %v", err)
+		return
+	}
+	ce.Reply("Successfully imported %d/%d keys", imported, total)
+}
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/net/html"
 
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -464,7 +465,7 @@ func fnLogout(ce *Event) {
 		return
 	}
 	login := ce.Bridge.GetCachedUserLoginByID(networkid.UserLoginID(ce.Args[0]))
-	if login == nil || login.UserMXID != ce.User.MXID {
+	if login == nil || (login.UserMXID != ce.User.MXID && !ce.User.Permissions.Has(bridgeconfig.CapabilityManageLogins)) {
 		ce.Reply("Login `%s` not found", ce.Args[0])
 		return
 	}
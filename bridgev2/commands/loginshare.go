@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandShareLogin = &FullHandler{
+	Func: fnShareLogin,
+	Name: "share-login",
+	Help: HelpMeta{
+		Section:     HelpSectionAuth,
+		Description: "Allow another Matrix user to send messages through one of your logins, or list who it's shared with",
+		Args:        "<_login ID_> [_user ID_]",
+	},
+	RequiresLoginPermission: true,
+}
+
+var CommandUnshareLogin = &FullHandler{
+	Func: fnUnshareLogin,
+	Name: "unshare-login",
+	Help: HelpMeta{
+		Section:     HelpSectionAuth,
+		Description: "Revoke another Matrix user's access to one of your logins",
+		Args:        "<_login ID_> <_user ID_>",
+	},
+	RequiresLoginPermission: true,
+}
+
+var CommandListSharedLogins = &FullHandler{
+	Func: fnListSharedLogins,
+	Name: "list-shared-logins",
+	Help: HelpMeta{
+		Section:     HelpSectionAuth,
+		Description: "List logins other users have shared with you",
+	},
+}
+
+// getManageableLogin finds a login that the command sender owns or is allowed to manage.
+func getManageableLogin(ce *Event, loginID string) *bridgev2.UserLogin {
+	login := ce.Bridge.GetCachedUserLoginByID(networkid.UserLoginID(loginID))
+	if login == nil || (login.UserMXID != ce.User.MXID && !ce.User.Permissions.Has(bridgeconfig.CapabilityManageLogins)) {
+		ce.Reply("Login `%s` not found", loginID)
+		return nil
+	}
+	return login
+}
+
+func parseShareTarget(ce *Event, rawUserID string) (id.UserID, bool) {
+	userID := id.UserID(rawUserID)
+	if _, _, err := userID.Parse(); err != nil || len(userID) > id.UserIDMaxLength {
+		ce.Reply("Invalid user ID `%s`", rawUserID)
+		return "", false
+	} else if ce.Bridge.IsGhostMXID(userID) || userID == ce.Bot.GetMXID() {
+		ce.Reply("Logins can't be shared with bridge bots or ghosts")
+		return "", false
+	}
+	return userID, true
+}
+
+func fnShareLogin(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Usage: `$cmdprefix share-login <login ID> [user ID]`\n\nYour logins:\n\n%s", ce.User.GetFormattedUserLogins())
+		return
+	}
+	login := getManageableLogin(ce, ce.Args[0])
+	if login == nil {
+		return
+	}
+	if len(ce.Args) == 1 {
+		users, err := login.GetSharedWith(ce.Ctx)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to get users login is shared with")
+			ce.Reply("Failed to get users the login is shared with")
+		} else if len(users) == 0 {
+			ce.Reply("Login `%s` isn't shared with anyone", login.ID)
+		} else {
+			lines := make([]string, len(users))
+			for i, userID := range users {
+				lines[i] = fmt.Sprintf("* %s", userID)
+			}
+			ce.Reply("Login `%s` is shared with:\n\n%s", login.ID, strings.Join(lines, "\n"))
+		}
+		return
+	}
+	userID, ok := parseShareTarget(ce, ce.Args[1])
+	if !ok {
+		return
+	}
+	target, err := ce.Bridge.GetUserByMXID(ce.Ctx, userID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get user to share login with")
+		ce.Reply("Failed to get user")
+		return
+	} else if !target.Permissions.SendEvents {
+		ce.Reply("%s doesn't have permission to use the bridge", userID)
+		return
+	}
+	err = login.Share(ce.Ctx, target)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to share login")
+		ce.Reply("Failed to share login: %v", err)
+		return
+	}
+	ce.Log.Info().
+		Str("login_id", string(login.ID)).
+		Stringer("shared_with", userID).
+		Msg("Shared login with user")
+	ce.Reply("Shared login `%s` with %s. Their messages will be prefixed with their name.", login.ID, userID)
+}
+
+func fnUnshareLogin(ce *Event) {
+	if len(ce.Args) < 2 {
+		ce.Reply("Usage: `$cmdprefix unshare-login <login ID> <user ID>`")
+		return
+	}
+	login := getManageableLogin(ce, ce.Args[0])
+	if login == nil {
+		return
+	}
+	removed, err := login.Unshare(ce.Ctx, id.UserID(ce.Args[1]))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to unshare login")
+		ce.Reply("Failed to unshare login: %v", err)
+	} else if !removed {
+		ce.Reply("Login `%s` isn't shared with %s", login.ID, ce.Args[1])
+	} else {
+		ce.Reply("Login `%s` is no longer shared with %s", login.ID, ce.Args[1])
+	}
+}
+
+func fnListSharedLogins(ce *Event) {
+	logins, err := ce.User.GetSharedLogins(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get shared logins")
+		ce.Reply("Failed to get shared logins")
+		return
+	} else if len(logins) == 0 {
+		ce.Reply("No logins have been shared with you")
+		return
+	}
+	lines := make([]string, len(logins))
+	for i, login := range logins {
+		lines[i] = fmt.Sprintf("* `%s` (%s), shared by %s", login.ID, login.RemoteName, login.UserMXID)
+	}
+	ce.Reply("Logins shared with you:\n\n%s", strings.Join(lines, "\n"))
+}
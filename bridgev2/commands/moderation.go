@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandKick = &FullHandler{
+	Func: moderationCommand(bridgev2.ModerationActionKick, "Kicked"),
+	Name: "kick",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Kick a user from the current portal, both on Matrix and on the remote network",
+		Args:        "<_Matrix user ID_> [_reason_]",
+	},
+	RequiresPortal: true,
+}
+
+var CommandBan = &FullHandler{
+	Func: moderationCommand(bridgev2.ModerationActionBan, "Banned"),
+	Name: "ban",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Ban a user from the current portal, both on Matrix and on the remote network",
+		Args:        "<_Matrix user ID_> [_reason_]",
+	},
+	RequiresPortal: true,
+}
+
+var CommandMute = &FullHandler{
+	Func: moderationCommand(bridgev2.ModerationActionMute, "Muted"),
+	Name: "mute",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Prevent a user from sending messages in the current portal, both on Matrix and on the remote network",
+		Args:        "<_Matrix user ID_>",
+	},
+	RequiresPortal: true,
+}
+
+func moderationCommand(action bridgev2.ModerationAction, pastTense string) func(ce *Event) {
+	return func(ce *Event) {
+		if len(ce.Args) == 0 {
+			ce.Reply("**Usage:** `$cmdprefix %s <Matrix user ID> [reason]`", action)
+			return
+		}
+		target := id.UserID(ce.Args[0])
+		if _, _, err := target.ParseAndValidate(); err != nil {
+			ce.Reply("`%s` is not a valid Matrix user ID", ce.Args[0])
+			return
+		}
+		reason := strings.Join(ce.Args[1:], " ")
+		res, err := ce.Portal.Moderate(ce.Ctx, ce.User, action, target, reason)
+		if res == nil && err != nil {
+			ce.Log.Err(err).Str("moderation_action", string(action)).Msg("Failed to moderate user")
+			ce.Reply("Failed to %s %s: %v", action, target, err)
+		} else if err != nil {
+			ce.Log.Err(err).Str("moderation_action", string(action)).Msg("Failed to bridge moderation action")
+			ce.Reply("%s %s on Matrix, but failed to bridge it to the remote network: %v", pastTense, target, err)
+		} else if !res.BridgedToRemote {
+			ce.Reply("%s %s on Matrix (not bridged to the remote network)", pastTense, target)
+		} else {
+			ce.Reply("%s %s", pastTense, target)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+)
+
+var fakeEvtSetNotificationPolicy = event.Type{Type: "fi.mau.bridge.notification_policy", Class: event.StateEventType}
+
+var CommandNotifications = &FullHandler{
+	Func: fnNotifications,
+	Name: "notifications",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "View or change which bridged messages in this room are allowed to notify",
+		Args:        "[_always_|_mentions_|_never_|_auto_]",
+	},
+	RequiresPortal: true,
+}
+
+func describeNotificationPolicy(policy database.NotificationPolicy) string {
+	switch policy {
+	case database.NotificationPolicyMentionsOnly:
+		return "only messages that mention you will notify"
+	case database.NotificationPolicyNever:
+		return "bridged messages will not notify"
+	default:
+		return "all bridged messages will notify"
+	}
+}
+
+func fnNotifications(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("The notification policy in this room is `%s`: %s", policyName(ce.Portal.Notify), describeNotificationPolicy(ce.Portal.Notify))
+		return
+	}
+	var policy database.NotificationPolicy
+	switch strings.ToLower(ce.Args[0]) {
+	case "auto":
+		if !canManageNotificationPolicy(ce) {
+			ce.Reply("You don't have permission to change the notification policy in this room")
+			return
+		}
+		err := ce.Portal.ResetNotificationPolicy(ce.Ctx)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to reset notification policy")
+			ce.Reply("Failed to save notification policy")
+			return
+		}
+		ce.Reply("Notification policy will follow the mute state of the chat")
+		return
+	case "always", "all":
+		policy = database.NotificationPolicyAlways
+	case "mentions", "mentions-only":
+		policy = database.NotificationPolicyMentionsOnly
+	case "never", "none":
+		policy = database.NotificationPolicyNever
+	default:
+		ce.Reply("Usage: `$cmdprefix notifications [always|mentions|never|auto]`")
+		return
+	}
+	if !canManageNotificationPolicy(ce) {
+		ce.Reply("You don't have permission to change the notification policy in this room")
+		return
+	}
+	err := ce.Portal.SetNotificationPolicy(ce.Ctx, policy)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to set notification policy")
+		ce.Reply("Failed to save notification policy")
+		return
+	}
+	ce.Reply("Notification policy set to `%s`: %s", policyName(policy), describeNotificationPolicy(policy))
+}
+
+func policyName(policy database.NotificationPolicy) string {
+	if policy == database.NotificationPolicyAlways {
+		return "always"
+	}
+	return string(policy)
+}
+
+func canManageNotificationPolicy(ce *Event) bool {
+	if ce.User.Permissions.Admin {
+		return true
+	}
+	levels, err := ce.Bridge.Matrix.GetPowerLevels(ce.Ctx, ce.RoomID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to check room power levels")
+		return false
+	}
+	return levels.GetUserLevel(ce.User.MXID) >= levels.GetEventLevel(fakeEvtSetNotificationPolicy)
+}
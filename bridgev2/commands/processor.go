@@ -42,10 +42,13 @@ func NewProcessor(bridge *bridgev2.Bridge) bridgev2.CommandProcessor {
 	}
 	proc.AddHandlers(
 		CommandHelp, CommandCancel,
-		CommandRegisterPush, CommandDeletePortal, CommandDeleteAllPortals,
+		CommandRegisterPush, CommandDeletePortal, CommandDeleteAllPortals, CommandDeleteOrphanedPortals, CommandEncryptLoginMetadata, CommandDoctor,
+		CommandExportRoomKeys, CommandExportKeys, CommandImportKeys,
 		CommandLogin, CommandListLogins, CommandLogout, CommandSetPreferredLogin,
-		CommandSetRelay, CommandUnsetRelay,
-		CommandResolveIdentifier, CommandStartChat, CommandSearch,
+		CommandShareLogin, CommandUnshareLogin, CommandListSharedLogins,
+		CommandSetRelay, CommandUnsetRelay, CommandNotifications,
+		CommandKick, CommandBan, CommandMute, CommandDisappearingTimer, CommandRetry, CommandBackfill,
+		CommandResolveIdentifier, CommandStartChat, CommandSearch, CommandPreviewChat, CommandJoinChat,
 		CommandSudo, CommandDoIn,
 	)
 	return proc
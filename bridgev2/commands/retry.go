@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/exfmt"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandRetry = &FullHandler{
+	Func: fnRetry,
+	Name: "retry",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "List messages in the current portal that failed to bridge, or retry bridging them",
+		Args:        "[_event ID_ | `all`]",
+	},
+	RequiresPortal: true,
+}
+
+func formatFailedMessage(fm *database.FailedMessage) string {
+	var nextRetry string
+	if !fm.NextRetry.IsZero() {
+		nextRetry = fmt.Sprintf(", next automatic retry in %s", exfmt.Duration(time.Until(fm.NextRetry).Round(time.Second)))
+	}
+	return fmt.Sprintf(
+		"* `%s` from %s: %s (%d attempts%s)",
+		fm.EventID, fm.SenderMXID.URI().MatrixToURL(), fm.Error, fm.Attempts, nextRetry,
+	)
+}
+
+func fnRetry(ce *Event) {
+	if !ce.Bridge.Config.MessageRetry.Enabled {
+		ce.Reply("Failed message tracking is not enabled on this bridge")
+		return
+	}
+	failed, err := ce.Bridge.DB.FailedMessage.GetAllInPortal(ce.Ctx, ce.Portal.PortalKey)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get failed messages")
+		ce.Reply("Failed to get failed messages: %v", err)
+		return
+	}
+	var target id.EventID
+	if len(ce.Args) > 0 {
+		target = id.EventID(ce.Args[0])
+	} else if ce.ReplyTo != "" {
+		target = ce.ReplyTo
+	} else if len(failed) == 0 {
+		ce.Reply("No failed messages in this portal")
+		return
+	} else {
+		lines := make([]string, len(failed))
+		for i, fm := range failed {
+			lines[i] = formatFailedMessage(fm)
+		}
+		ce.Reply("Failed messages in this portal:\n\n%s\n\nUse `$cmdprefix retry <event ID>` or reply to a message with `$cmdprefix retry` to retry it.", strings.Join(lines, "\n"))
+		return
+	}
+	var retried, forbidden int
+	for _, fm := range failed {
+		if target != "all" && fm.EventID != target {
+			continue
+		} else if fm.SenderMXID != ce.User.MXID && !ce.User.Permissions.Admin {
+			forbidden++
+			continue
+		}
+		err = ce.Bridge.RetryFailedMessage(ce.Ctx, fm)
+		if err != nil {
+			ce.Log.Err(err).Stringer("failed_event_id", fm.EventID).Msg("Failed to retry message")
+			ce.Reply("Failed to retry `%s`: %v", fm.EventID, err)
+		} else {
+			retried++
+		}
+	}
+	if retried > 0 {
+		ce.React("✅")
+	} else if forbidden > 0 {
+		ce.Reply("Only admins can retry messages sent by other users")
+	} else if target != "all" {
+		ce.Reply("Message `%s` is not in the list of failed messages", target)
+	} else if len(failed) == 0 {
+		ce.Reply("No failed messages in this portal")
+	}
+}
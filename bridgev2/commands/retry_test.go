@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func putTestFailedMessage(t *testing.T, ce *Event, eventID id.EventID, sender id.UserID) {
+	t.Helper()
+	require.NoError(t, ce.Bridge.DB.FailedMessage.Put(ce.Ctx, &database.FailedMessage{
+		EventID:     eventID,
+		RoomID:      ce.Portal.MXID,
+		Portal:      ce.Portal.PortalKey,
+		SenderMXID:  sender,
+		Error:       "network is down",
+		Attempts:    1,
+		LastAttempt: time.Now(),
+		NextRetry:   time.Now().Add(time.Minute),
+	}))
+}
+
+func TestFnRetry_Disabled(t *testing.T) {
+	ce, matrix := newTestEvent(t, "retry")
+	fnRetry(ce)
+	assert.Equal(t, "Failed message tracking is not enabled on this bridge", getReplyBody(t, matrix.bot.popSent()))
+}
+
+func TestFnRetry_List(t *testing.T) {
+	ce, matrix := newTestEvent(t, "retry")
+	ce.Bridge.Config.MessageRetry.Enabled = true
+	fnRetry(ce)
+	assert.Equal(t, "No failed messages in this portal", getReplyBody(t, matrix.bot.popSent()))
+
+	putTestFailedMessage(t, ce, "$failed", ce.User.MXID)
+	fnRetry(ce)
+	body := getReplyBody(t, matrix.bot.popSent())
+	assert.Contains(t, body, "$failed")
+	assert.Contains(t, body, "network is down (1 attempts, next automatic retry in")
+	assert.Contains(t, body, "!test retry <event ID>")
+}
+
+func TestFnRetry_Target(t *testing.T) {
+	ce, matrix := newTestEvent(t, "retry")
+	ce.Bridge.Config.MessageRetry.Enabled = true
+	putTestFailedMessage(t, ce, "$own", ce.User.MXID)
+	putTestFailedMessage(t, ce, "$other", "@bob:example.com")
+	matrix.events["$own"] = &event.Event{
+		ID:      "$own",
+		Type:    event.EventMessage,
+		RoomID:  ce.Portal.MXID,
+		Sender:  ce.User.MXID,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+	}
+
+	ce.Args = []string{"$missing"}
+	fnRetry(ce)
+	assert.Equal(t, "Message `$missing` is not in the list of failed messages", getReplyBody(t, matrix.bot.popSent()))
+
+	ce.Args = []string{"$other"}
+	fnRetry(ce)
+	assert.Equal(t, "Only admins can retry messages sent by other users", getReplyBody(t, matrix.bot.popSent()))
+
+	ce.Args = []string{"$own"}
+	fnRetry(ce)
+	sent := matrix.bot.popSent()
+	require.Len(t, sent, 1)
+	reaction, ok := sent[0].Parsed.(*event.ReactionEventContent)
+	require.True(t, ok, "expected a reaction")
+	assert.Equal(t, "✅", reaction.RelatesTo.Key)
+	assert.Equal(t, id.EventID("$command"), reaction.RelatesTo.EventID)
+	fm, err := ce.Bridge.DB.FailedMessage.GetByEventID(ce.Ctx, "$own")
+	require.NoError(t, err)
+	require.NotNil(t, fm)
+	assert.True(t, fm.NextRetry.IsZero(), "manually retried message shouldn't be retried automatically while it's queued")
+}
@@ -12,10 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog"
 	"golang.org/x/net/html"
 
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -42,7 +44,7 @@ var CommandStartChat = &FullHandler{
 	RequiresLogin: true,
 }
 
-func getClientForStartingChat[T bridgev2.IdentifierResolvingNetworkAPI](ce *Event, thing string) (*bridgev2.UserLogin, T, []string) {
+func getClientForStartingChat[T bridgev2.NetworkAPI](ce *Event, thing string) (*bridgev2.UserLogin, T, []string) {
 	remainingArgs := ce.Args[1:]
 	login := ce.Bridge.GetCachedUserLoginByID(networkid.UserLoginID(ce.Args[0]))
 	if login == nil || login.UserMXID != ce.User.MXID {
@@ -88,6 +90,9 @@ func fnResolveIdentifier(ce *Event) {
 	}
 	createChat := ce.Command == "start-chat"
 	identifier := strings.Join(identifierParts, " ")
+	if ghostID, isGhost := ce.Bridge.Matrix.ParseGhostMXID(id.UserID(identifier)); isGhost {
+		identifier = string(ghostID)
+	}
 	resp, err := api.ResolveIdentifier(ce.Ctx, identifier, createChat)
 	if err != nil {
 		ce.Log.Err(err).Msg("Failed to resolve identifier")
@@ -150,15 +155,20 @@ var CommandSearch = &FullHandler{
 	Name: "search",
 	Help: HelpMeta{
 		Section:     HelpSectionChats,
-		Description: "Search for users on the remote network",
-		Args:        "<_query_>",
+		Description: "Search for users or public chats on the remote network",
+		Args:        "[--chats] <_query_>",
 	},
 	RequiresLogin: true,
 }
 
 func fnSearch(ce *Event) {
+	if len(ce.Args) > 0 && strings.ToLower(ce.Args[0]) == "--chats" {
+		ce.Args = ce.Args[1:]
+		fnSearchPublicChats(ce)
+		return
+	}
 	if len(ce.Args) == 0 {
-		ce.Reply("Usage: `$cmdprefix search <query>`")
+		ce.Reply("Usage: `$cmdprefix search [--chats] <query>`")
 		return
 	}
 	_, api, queryParts := getClientForStartingChat[bridgev2.UserSearchingNetworkAPI](ce, "searching users")
@@ -193,3 +203,132 @@ func fnSearch(ce *Event) {
 	}
 	ce.Reply("Search results:\n\n%s", strings.Join(resultsString, "\n"))
 }
+
+func formatPublicChat(ctx context.Context, br *bridgev2.Bridge, chat *bridgev2.PublicChat) string {
+	name := chat.Name
+	if name == "" {
+		name = chat.Identifier
+	}
+	name = format.EscapeMarkdown(name)
+	formatted := fmt.Sprintf("`%s` / %s", chat.Identifier, name)
+	if chat.MemberCount > 0 {
+		formatted = fmt.Sprintf("%s (%d members)", formatted, chat.MemberCount)
+	}
+	portal, err := chat.GetExistingPortal(ctx, br)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("identifier", chat.Identifier).Msg("Failed to get portal for public chat")
+	} else if portal != nil {
+		formatted = fmt.Sprintf("%s - portal: [%s](%s)", formatted, name, portal.MXID.URI().MatrixToURL())
+	}
+	return formatted
+}
+
+func fnSearchPublicChats(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Usage: `$cmdprefix search --chats <query>`")
+		return
+	}
+	_, api, queryParts := getClientForStartingChat[bridgev2.PublicChatSearchingNetworkAPI](ce, "searching public chats")
+	if api == nil {
+		return
+	}
+	results, err := api.SearchPublicChats(ce.Ctx, strings.Join(queryParts, " "))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to search for public chats")
+		ce.Reply("Failed to search for public chats: %v", err)
+		return
+	} else if len(results) == 0 {
+		ce.Reply("No public chats found")
+		return
+	}
+	resultsString := make([]string, len(results))
+	for i, res := range results {
+		resultsString[i] = fmt.Sprintf("* %s", formatPublicChat(ce.Ctx, ce.Bridge, res))
+	}
+	ce.Reply("Search results:\n\n%s\n\nUse `$cmdprefix join-chat <identifier>` to join a chat.", strings.Join(resultsString, "\n"))
+}
+
+var CommandPreviewChat = &FullHandler{
+	Func: fnPreviewChat,
+	Name: "preview-chat",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Show recent messages in a public chat without joining it",
+		Args:        "[_login ID_] <_identifier_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnPreviewChat(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Usage: `$cmdprefix preview-chat <identifier>`")
+		return
+	}
+	_, api, identifierParts := getClientForStartingChat[bridgev2.PublicChatPreviewingNetworkAPI](ce, "previewing public chats")
+	if api == nil {
+		return
+	}
+	preview, err := api.PreviewPublicChat(ce.Ctx, strings.Join(identifierParts, " "))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to preview public chat")
+		ce.Reply("Failed to preview public chat: %v", err)
+		return
+	} else if preview == nil || preview.Chat == nil {
+		ce.Reply("Chat not found")
+		return
+	}
+	var out strings.Builder
+	out.WriteString(formatPublicChat(ce.Ctx, ce.Bridge, preview.Chat))
+	if preview.Chat.Topic != "" {
+		out.WriteString("\n\n")
+		out.WriteString(format.EscapeMarkdown(preview.Chat.Topic))
+	}
+	if len(preview.Messages) == 0 {
+		out.WriteString("\n\nNo recent messages")
+	} else {
+		out.WriteString("\n\nRecent messages:\n")
+		for _, msg := range preview.Messages {
+			// Escape the remote text and keep multiline messages inside the quote
+			text := strings.ReplaceAll(format.EscapeMarkdown(msg.Text), "\n", "\n> ")
+			_, _ = fmt.Fprintf(&out, "\n> **%s** (%s): %s", format.EscapeMarkdown(msg.SenderName), msg.Timestamp.Format(time.DateTime), text)
+		}
+	}
+	ce.Reply(out.String())
+}
+
+var CommandJoinChat = &FullHandler{
+	Func: fnJoinChat,
+	Name: "join-chat",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Join a public chat on the remote network",
+		Args:        "[_login ID_] <_identifier_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnJoinChat(ce *Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Usage: `$cmdprefix join-chat <identifier>`")
+		return
+	}
+	login, api, identifierParts := getClientForStartingChat[bridgev2.PublicChatSearchingNetworkAPI](ce, "joining public chats")
+	if api == nil {
+		return
+	}
+	portal, created, err := login.JoinPublicChat(ce.Ctx, strings.Join(identifierParts, " "))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to join public chat")
+		ce.Reply("Failed to join public chat: %v", err)
+		return
+	}
+	name := portal.Name
+	if name == "" {
+		name = portal.MXID.String()
+	}
+	if created {
+		ce.Reply("Joined chat: [%s](%s)", name, portal.MXID.URI().MatrixToURL())
+	} else {
+		ce.Reply("You already have a portal for that chat: [%s](%s)", name, portal.MXID.URI().MatrixToURL())
+	}
+}
@@ -38,11 +38,18 @@ type BackfillTask struct {
 var BackfillNextDispatchNever = time.Unix(0, (1<<63)-1)
 
 const (
+	// The latest message timestamp is used to prioritize recently active portals in the queue.
+	// It's refreshed whenever the task is written, so that getting the next task doesn't need to look at messages.
+	latestMessageTSSubquery = `(
+		SELECT COALESCE(MAX(message.timestamp), 0) FROM message
+		WHERE message.bridge_id = $1 AND message.room_id = $2 AND message.room_receiver = $3
+	)`
 	ensureBackfillExistsQuery = `
-		INSERT INTO backfill_task (bridge_id, portal_id, portal_receiver, user_login_id, batch_count, is_done, next_dispatch_min_ts)
-		VALUES ($1, $2, $3, $4, -1, false, $5)
+		INSERT INTO backfill_task (bridge_id, portal_id, portal_receiver, user_login_id, batch_count, is_done, next_dispatch_min_ts, latest_message_ts)
+		VALUES ($1, $2, $3, $4, -1, false, $5, ` + latestMessageTSSubquery + `)
 		ON CONFLICT (bridge_id, portal_id, portal_receiver) DO UPDATE
-			SET user_login_id=CASE
+			SET latest_message_ts=excluded.latest_message_ts,
+			    user_login_id=CASE
 					WHEN backfill_task.user_login_id=''
 						THEN excluded.user_login_id
 					ELSE backfill_task.user_login_id
@@ -56,8 +63,8 @@ const (
 	upsertBackfillQueueQuery = `
 		INSERT INTO backfill_task (
 			bridge_id, portal_id, portal_receiver, user_login_id, batch_count, is_done, cursor,
-			oldest_message_id, dispatched_at, completed_at, next_dispatch_min_ts
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			oldest_message_id, dispatched_at, completed_at, next_dispatch_min_ts, latest_message_ts
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, ` + latestMessageTSSubquery + `)
 		ON CONFLICT (bridge_id, portal_id, portal_receiver) DO UPDATE
 			SET user_login_id=excluded.user_login_id,
 				batch_count=excluded.batch_count,
@@ -66,7 +73,8 @@ const (
 				oldest_message_id=excluded.oldest_message_id,
 				dispatched_at=excluded.dispatched_at,
 				completed_at=excluded.completed_at,
-				next_dispatch_min_ts=excluded.next_dispatch_min_ts
+				next_dispatch_min_ts=excluded.next_dispatch_min_ts,
+				latest_message_ts=excluded.latest_message_ts
 	`
 	markBackfillDispatchedQuery = `
 		UPDATE backfill_task SET dispatched_at=$4, completed_at=NULL, next_dispatch_min_ts=$5
@@ -75,7 +83,7 @@ const (
 	updateBackfillQueueQuery = `
 		UPDATE backfill_task
 		SET user_login_id=$4, batch_count=$5, is_done=$6, cursor=$7, oldest_message_id=$8,
-			dispatched_at=$9, completed_at=$10, next_dispatch_min_ts=$11
+			dispatched_at=$9, completed_at=$10, next_dispatch_min_ts=$11, latest_message_ts=` + latestMessageTSSubquery + `
 		WHERE bridge_id = $1 AND portal_id = $2 AND portal_receiver = $3
 	`
 	getNextBackfillQuery = `
@@ -84,7 +92,15 @@ const (
 			cursor, oldest_message_id, dispatched_at, completed_at, next_dispatch_min_ts
 		FROM backfill_task
 		WHERE bridge_id = $1 AND next_dispatch_min_ts < $2 AND is_done = false AND user_login_id <> ''
-		ORDER BY next_dispatch_min_ts LIMIT 1
+		ORDER BY latest_message_ts DESC, next_dispatch_min_ts
+		LIMIT 1
+	`
+	getBackfillByPortalQuery = `
+		SELECT
+			bridge_id, portal_id, portal_receiver, user_login_id, batch_count, is_done,
+			cursor, oldest_message_id, dispatched_at, completed_at, next_dispatch_min_ts
+		FROM backfill_task
+		WHERE bridge_id = $1 AND portal_id = $2 AND portal_receiver = $3
 	`
 	deleteBackfillQueueQuery = `
 		DELETE FROM backfill_task
@@ -124,6 +140,10 @@ func (btq *BackfillTaskQuery) GetNext(ctx context.Context) (*BackfillTask, error
 	return btq.QueryOne(ctx, getNextBackfillQuery, btq.BridgeID, time.Now().UnixNano())
 }
 
+func (btq *BackfillTaskQuery) GetByPortal(ctx context.Context, portalKey networkid.PortalKey) (*BackfillTask, error) {
+	return btq.QueryOne(ctx, getBackfillByPortalQuery, btq.BridgeID, portalKey.ID, portalKey.Receiver)
+}
+
 func (btq *BackfillTaskQuery) Delete(ctx context.Context, portalKey networkid.PortalKey) error {
 	return btq.Exec(ctx, deleteBackfillQueueQuery, btq.BridgeID, portalKey.ID, portalKey.Receiver)
 }
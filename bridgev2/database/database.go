@@ -34,6 +34,9 @@ type Database struct {
 	UserPortal          *UserPortalQuery
 	BackfillTask        *BackfillTaskQuery
 	KV                  *KVQuery
+	InstanceLock        *InstanceLockQuery
+	UserLoginShare      *UserLoginShareQuery
+	FailedMessage       *FailedMessageQuery
 }
 
 type MetaMerger interface {
@@ -75,6 +78,13 @@ func New(bridgeID networkid.BridgeID, mt MetaTypes, db *dbutil.Database) *Databa
 		mt.UserLogin = blankMetaCreator
 	}
 	db.UpgradeTable = upgrades.Table
+	userLoginQuery := &UserLoginQuery{
+		BridgeID: bridgeID,
+		MetaType: mt.UserLogin,
+	}
+	userLoginQuery.QueryHelper = dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*UserLogin]) *UserLogin {
+		return (&UserLogin{crypter: userLoginQuery.Crypter}).ensureHasMetadata(mt.UserLogin)
+	})
 	return &Database{
 		Database: db,
 		BridgeID: bridgeID,
@@ -118,13 +128,7 @@ func New(bridgeID networkid.BridgeID, mt MetaTypes, db *dbutil.Database) *Databa
 				return &User{}
 			}),
 		},
-		UserLogin: &UserLoginQuery{
-			BridgeID: bridgeID,
-			MetaType: mt.UserLogin,
-			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*UserLogin]) *UserLogin {
-				return (&UserLogin{}).ensureHasMetadata(mt.UserLogin)
-			}),
-		},
+		UserLogin: userLoginQuery,
 		UserPortal: &UserPortalQuery{
 			BridgeID: bridgeID,
 			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*UserPortal]) *UserPortal {
@@ -141,6 +145,20 @@ func New(bridgeID networkid.BridgeID, mt MetaTypes, db *dbutil.Database) *Databa
 			BridgeID: bridgeID,
 			Database: db,
 		},
+		InstanceLock: &InstanceLockQuery{
+			BridgeID: bridgeID,
+			Database: db,
+		},
+		UserLoginShare: &UserLoginShareQuery{
+			BridgeID: bridgeID,
+			Database: db,
+		},
+		FailedMessage: &FailedMessageQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*FailedMessage]) *FailedMessage {
+				return &FailedMessage{}
+			}),
+		},
 	}
 }
 
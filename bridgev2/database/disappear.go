@@ -66,6 +66,9 @@ const (
 	deleteDisappearingMessageQuery = `
 		DELETE FROM disappearing_message WHERE bridge_id=$1 AND mxid=$2
 	`
+	deleteAllDisappearingMessagesInRoomQuery = `
+		DELETE FROM disappearing_message WHERE bridge_id=$1 AND mx_room=$2
+	`
 )
 
 func (dmq *DisappearingMessageQuery) Put(ctx context.Context, dm *DisappearingMessage) error {
@@ -85,6 +88,10 @@ func (dmq *DisappearingMessageQuery) Delete(ctx context.Context, eventID id.Even
 	return dmq.Exec(ctx, deleteDisappearingMessageQuery, dmq.BridgeID, eventID)
 }
 
+func (dmq *DisappearingMessageQuery) DeleteAllInRoom(ctx context.Context, roomID id.RoomID) error {
+	return dmq.Exec(ctx, deleteAllDisappearingMessagesInRoomQuery, dmq.BridgeID, roomID)
+}
+
 func (d *DisappearingMessage) Scan(row dbutil.Scannable) (*DisappearingMessage, error) {
 	var disappearAt sql.NullInt64
 	err := row.Scan(&d.BridgeID, &d.RoomID, &d.EventID, &d.Type, &d.Timer, &disappearAt)
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+type FailedMessageQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*FailedMessage]
+}
+
+// FailedMessage is a Matrix message that failed to be bridged to the remote network.
+type FailedMessage struct {
+	BridgeID    networkid.BridgeID
+	EventID     id.EventID
+	RoomID      id.RoomID
+	Portal      networkid.PortalKey
+	SenderMXID  id.UserID
+	Error       string
+	Attempts    int
+	LastAttempt time.Time
+	// NextRetry is when the message should be retried automatically. It's zero if there are no more automatic retries.
+	NextRetry time.Time
+}
+
+const (
+	getFailedMessageBaseQuery = `
+		SELECT bridge_id, event_id, room_id, portal_id, portal_receiver, sender_mxid, error, attempts, last_attempt, next_retry
+		FROM failed_message
+	`
+	getFailedMessageByEventIDQuery = getFailedMessageBaseQuery + `WHERE bridge_id=$1 AND event_id=$2`
+	getFailedMessagesInPortalQuery = getFailedMessageBaseQuery + `
+		WHERE bridge_id=$1 AND portal_id=$2 AND portal_receiver=$3 ORDER BY last_attempt
+	`
+	getFailedMessagesDueQuery = getFailedMessageBaseQuery + `
+		WHERE bridge_id=$1 AND next_retry IS NOT NULL AND next_retry<=$2 ORDER BY next_retry
+	`
+	upsertFailedMessageQuery = `
+		INSERT INTO failed_message (
+			bridge_id, event_id, room_id, portal_id, portal_receiver, sender_mxid, error, attempts, last_attempt, next_retry
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (bridge_id, event_id) DO UPDATE
+			SET error=excluded.error, attempts=excluded.attempts, last_attempt=excluded.last_attempt, next_retry=excluded.next_retry
+	`
+	deleteFailedMessageQuery = `
+		DELETE FROM failed_message WHERE bridge_id=$1 AND event_id=$2
+	`
+)
+
+func (fmq *FailedMessageQuery) GetByEventID(ctx context.Context, eventID id.EventID) (*FailedMessage, error) {
+	return fmq.QueryOne(ctx, getFailedMessageByEventIDQuery, fmq.BridgeID, eventID)
+}
+
+func (fmq *FailedMessageQuery) GetAllInPortal(ctx context.Context, portal networkid.PortalKey) ([]*FailedMessage, error) {
+	return fmq.QueryMany(ctx, getFailedMessagesInPortalQuery, fmq.BridgeID, portal.ID, portal.Receiver)
+}
+
+// GetDue returns all failed messages whose next automatic retry is before the given time.
+func (fmq *FailedMessageQuery) GetDue(ctx context.Context, before time.Time) ([]*FailedMessage, error) {
+	return fmq.QueryMany(ctx, getFailedMessagesDueQuery, fmq.BridgeID, before.UnixMilli())
+}
+
+func (fmq *FailedMessageQuery) Put(ctx context.Context, fm *FailedMessage) error {
+	ensureBridgeIDMatches(&fm.BridgeID, fmq.BridgeID)
+	return fmq.Exec(ctx, upsertFailedMessageQuery, fm.sqlVariables()...)
+}
+
+func (fmq *FailedMessageQuery) Delete(ctx context.Context, eventID id.EventID) error {
+	return fmq.Exec(ctx, deleteFailedMessageQuery, fmq.BridgeID, eventID)
+}
+
+func (fm *FailedMessage) Scan(row dbutil.Scannable) (*FailedMessage, error) {
+	var lastAttempt int64
+	var nextRetry sql.NullInt64
+	err := row.Scan(
+		&fm.BridgeID, &fm.EventID, &fm.RoomID, &fm.Portal.ID, &fm.Portal.Receiver, &fm.SenderMXID,
+		&fm.Error, &fm.Attempts, &lastAttempt, &nextRetry,
+	)
+	if err != nil {
+		return nil, err
+	}
+	fm.LastAttempt = time.UnixMilli(lastAttempt)
+	if nextRetry.Valid {
+		fm.NextRetry = time.UnixMilli(nextRetry.Int64)
+	}
+	return fm, nil
+}
+
+func (fm *FailedMessage) sqlVariables() []any {
+	return []any{
+		fm.BridgeID, fm.EventID, fm.RoomID, fm.Portal.ID, fm.Portal.Receiver, fm.SenderMXID,
+		fm.Error, fm.Attempts, fm.LastAttempt.UnixMilli(), dbutil.ConvertedPtr(fm.NextRetry, time.Time.UnixMilli),
+	}
+}
@@ -9,6 +9,7 @@ package database
 import (
 	"context"
 	"encoding/hex"
+	"time"
 
 	"go.mau.fi/util/dbutil"
 
@@ -36,12 +37,15 @@ type Ghost struct {
 	IsBot          bool
 	Identifiers    []string
 	Metadata       any
+
+	// InfoRefreshedAt is the last time the full user info was fetched from the remote network.
+	InfoRefreshedAt time.Time
 }
 
 const (
 	getGhostBaseQuery = `
 		SELECT bridge_id, id, name, avatar_id, avatar_hash, avatar_mxc,
-		       name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata
+		       name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata, info_refreshed_at
 		FROM ghost
 	`
 	getGhostByIDQuery       = getGhostBaseQuery + `WHERE bridge_id=$1 AND id=$2`
@@ -49,13 +53,14 @@ const (
 	insertGhostQuery        = `
 		INSERT INTO ghost (
 			bridge_id, id, name, avatar_id, avatar_hash, avatar_mxc,
-			name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata
+			name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata, info_refreshed_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
 	updateGhostQuery = `
 		UPDATE ghost SET name=$3, avatar_id=$4, avatar_hash=$5, avatar_mxc=$6,
-		                 name_set=$7, avatar_set=$8, contact_info_set=$9, is_bot=$10, identifiers=$11, metadata=$12
+		                 name_set=$7, avatar_set=$8, contact_info_set=$9, is_bot=$10, identifiers=$11, metadata=$12,
+		                 info_refreshed_at=$13
 		WHERE bridge_id=$1 AND id=$2
 	`
 )
@@ -82,15 +87,19 @@ func (gq *GhostQuery) Update(ctx context.Context, ghost *Ghost) error {
 
 func (g *Ghost) Scan(row dbutil.Scannable) (*Ghost, error) {
 	var avatarHash string
+	var infoRefreshedAt int64
 	err := row.Scan(
 		&g.BridgeID, &g.ID,
 		&g.Name, &g.AvatarID, &avatarHash, &g.AvatarMXC,
 		&g.NameSet, &g.AvatarSet, &g.ContactInfoSet, &g.IsBot,
-		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata},
+		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata}, &infoRefreshedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if infoRefreshedAt != 0 {
+		g.InfoRefreshedAt = time.UnixMilli(infoRefreshedAt)
+	}
 	if avatarHash != "" {
 		data, _ := hex.DecodeString(avatarHash)
 		if len(data) == 32 {
@@ -112,10 +121,14 @@ func (g *Ghost) sqlVariables() []any {
 	if g.AvatarHash != [32]byte{} {
 		avatarHash = hex.EncodeToString(g.AvatarHash[:])
 	}
+	var infoRefreshedAt int64
+	if !g.InfoRefreshedAt.IsZero() {
+		infoRefreshedAt = g.InfoRefreshedAt.UnixMilli()
+	}
 	return []any{
 		g.BridgeID, g.ID,
 		g.Name, g.AvatarID, avatarHash, g.AvatarMXC,
 		g.NameSet, g.AvatarSet, g.ContactInfoSet, g.IsBot,
-		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata},
+		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata}, infoRefreshedAt,
 	}
 }
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+type InstanceLockQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.Database
+}
+
+const (
+	acquireInstanceLockQuery = `
+		INSERT INTO instance_lock (bridge_id, instance_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (bridge_id) DO UPDATE
+			SET instance_id=excluded.instance_id, expires_at=excluded.expires_at
+			WHERE instance_lock.instance_id=excluded.instance_id OR instance_lock.expires_at<$4
+	`
+	getInstanceLockOwnerQuery = `
+		SELECT instance_id FROM instance_lock WHERE bridge_id=$1 AND expires_at>=$2
+	`
+	releaseInstanceLockQuery = `
+		DELETE FROM instance_lock WHERE bridge_id=$1 AND instance_id=$2
+	`
+)
+
+// TryAcquire tries to take or renew the lock of the bridge for the given instance.
+// The returned boolean is true if the lock is now held by the instance.
+func (ilq *InstanceLockQuery) TryAcquire(ctx context.Context, instanceID string, expiry time.Time) (bool, error) {
+	res, err := ilq.Exec(ctx, acquireInstanceLockQuery, ilq.BridgeID, instanceID, expiry.UnixMilli(), time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// GetOwner returns the ID of the instance currently holding the lock,
+// or an empty string if the bridge isn't locked.
+func (ilq *InstanceLockQuery) GetOwner(ctx context.Context) (instanceID string, err error) {
+	err = ilq.QueryRow(ctx, getInstanceLockOwnerQuery, ilq.BridgeID, time.Now().UnixMilli()).Scan(&instanceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return
+}
+
+func (ilq *InstanceLockQuery) Release(ctx context.Context, instanceID string) error {
+	_, err := ilq.Exec(ctx, releaseInstanceLockQuery, ilq.BridgeID, instanceID)
+	return err
+}
@@ -21,6 +21,8 @@ type Key string
 
 const (
 	KeySplitPortalsEnabled Key = "split_portals_enabled"
+	// KeyGhostLocalpartPrefix is followed by a truncated ghost localpart and stores the full remote user ID.
+	KeyGhostLocalpartPrefix Key = "ghost_localpart:"
 )
 
 type KVQuery struct {
@@ -37,16 +39,26 @@ const (
 )
 
 func (kvq *KVQuery) Get(ctx context.Context, key Key) string {
-	var value string
-	err := kvq.QueryRow(ctx, getKVQuery, kvq.BridgeID, key).Scan(&value)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	value, err := kvq.GetWithError(ctx, key)
+	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Str("key", string(key)).Msg("Failed to get key from kvstore")
 	}
 	return value
 }
 
+// GetWithError is like Get, but returns database errors instead of logging them.
+// An empty string is returned without an error if the key doesn't exist.
+func (kvq *KVQuery) GetWithError(ctx context.Context, key Key) (string, error) {
+	var value string
+	err := kvq.QueryRow(ctx, getKVQuery, kvq.BridgeID, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return value, err
+}
+
 func (kvq *KVQuery) Set(ctx context.Context, key Key, value string) {
-	_, err := kvq.Exec(ctx, setKVQuery, kvq.BridgeID, key, value)
+	err := kvq.SetWithError(ctx, key, value)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Str("key", string(key)).
@@ -54,3 +66,9 @@ func (kvq *KVQuery) Set(ctx context.Context, key Key, value string) {
 			Msg("Failed to set key in kvstore")
 	}
 }
+
+// SetWithError is like Set, but returns database errors instead of logging them.
+func (kvq *KVQuery) SetWithError(ctx context.Context, key Key, value string) error {
+	_, err := kvq.Exec(ctx, setKVQuery, kvq.BridgeID, key, value)
+	return err
+}
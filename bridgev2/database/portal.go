@@ -28,6 +28,24 @@ const (
 	RoomTypeSpace   RoomType = "space"
 )
 
+// NotificationPolicy controls which bridged messages in a portal are allowed to trigger notifications.
+type NotificationPolicy string
+
+const (
+	NotificationPolicyAlways       NotificationPolicy = ""
+	NotificationPolicyMentionsOnly NotificationPolicy = "mentions"
+	NotificationPolicyNever        NotificationPolicy = "never"
+)
+
+func (np NotificationPolicy) IsValid() bool {
+	switch np {
+	case NotificationPolicyAlways, NotificationPolicyMentionsOnly, NotificationPolicyNever:
+		return true
+	default:
+		return false
+	}
+}
+
 type PortalQuery struct {
 	BridgeID networkid.BridgeID
 	MetaType MetaTypeCreator
@@ -54,7 +72,10 @@ type Portal struct {
 	InSpace      bool
 	RoomType     RoomType
 	Disappear    DisappearingSetting
-	Metadata     any
+	Notify       NotificationPolicy
+	// NotifyIsManual is true if the notification policy was set manually rather than synced from the remote mute state.
+	NotifyIsManual bool
+	Metadata       any
 }
 
 const (
@@ -62,7 +83,7 @@ const (
 		SELECT bridge_id, id, receiver, mxid, parent_id, parent_receiver, relay_login_id, other_user_id,
 		       name, topic, avatar_id, avatar_hash, avatar_mxc,
 		       name_set, topic_set, avatar_set, name_is_custom, in_space,
-		       room_type, disappear_type, disappear_timer,
+		       room_type, disappear_type, disappear_timer, notification_policy, notification_policy_manual,
 		       metadata
 		FROM portal
 	`
@@ -82,10 +103,10 @@ const (
 			parent_id, parent_receiver, relay_login_id, other_user_id,
 			name, topic, avatar_id, avatar_hash, avatar_mxc,
 			name_set, avatar_set, topic_set, name_is_custom, in_space,
-			room_type, disappear_type, disappear_timer,
+			room_type, disappear_type, disappear_timer, notification_policy, notification_policy_manual,
 			metadata, relay_bridge_id
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, cast($7 AS TEXT), $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+			$1, $2, $3, $4, $5, $6, cast($7 AS TEXT), $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24,
 			CASE WHEN cast($7 AS TEXT) IS NULL THEN NULL ELSE $1 END
 		)
 	`
@@ -95,7 +116,8 @@ const (
 		    relay_login_id=cast($7 AS TEXT), relay_bridge_id=CASE WHEN cast($7 AS TEXT) IS NULL THEN NULL ELSE bridge_id END,
 		    other_user_id=$8, name=$9, topic=$10, avatar_id=$11, avatar_hash=$12, avatar_mxc=$13,
 		    name_set=$14, avatar_set=$15, topic_set=$16, name_is_custom=$17, in_space=$18,
-		    room_type=$19, disappear_type=$20, disappear_timer=$21, metadata=$22
+		    room_type=$19, disappear_type=$20, disappear_timer=$21, notification_policy=$22,
+		    notification_policy_manual=$23, metadata=$24
 		WHERE bridge_id=$1 AND id=$2 AND receiver=$3
 	`
 	deletePortalQuery = `
@@ -188,7 +210,7 @@ func (p *Portal) Scan(row dbutil.Scannable) (*Portal, error) {
 		&parentID, &parentReceiver, &relayLoginID, &otherUserID,
 		&p.Name, &p.Topic, &p.AvatarID, &avatarHash, &p.AvatarMXC,
 		&p.NameSet, &p.TopicSet, &p.AvatarSet, &p.NameIsCustom, &p.InSpace,
-		&p.RoomType, &disappearType, &disappearTimer,
+		&p.RoomType, &disappearType, &disappearTimer, &p.Notify, &p.NotifyIsManual,
 		dbutil.JSON{Data: p.Metadata},
 	)
 	if err != nil {
@@ -235,7 +257,7 @@ func (p *Portal) sqlVariables() []any {
 		dbutil.StrPtr(p.ParentKey.ID), p.ParentKey.Receiver, dbutil.StrPtr(p.RelayLoginID), dbutil.StrPtr(p.OtherUserID),
 		p.Name, p.Topic, p.AvatarID, avatarHash, p.AvatarMXC,
 		p.NameSet, p.TopicSet, p.AvatarSet, p.NameIsCustom, p.InSpace,
-		p.RoomType, dbutil.StrPtr(p.Disappear.Type), dbutil.NumPtr(p.Disappear.Timer),
+		p.RoomType, dbutil.StrPtr(p.Disappear.Type), dbutil.NumPtr(p.Disappear.Timer), p.Notify, p.NotifyIsManual,
 		dbutil.JSON{Data: p.Metadata},
 	}
 }
@@ -0,0 +1,199 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+)
+
+// QueryLatencyBuckets are the upper bounds of the latency histogram buckets collected by [QueryMetrics].
+var QueryLatencyBuckets = []time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// QueryStats contains the latency histogram of a single query.
+type QueryStats struct {
+	Name         string  `json:"name"`
+	Count        uint64  `json:"count"`
+	Errors       uint64  `json:"errors"`
+	TotalSeconds float64 `json:"total_seconds"`
+	MaxSeconds   float64 `json:"max_seconds"`
+	// Buckets contains the number of queries that took at most the corresponding duration in QueryLatencyBuckets.
+	// The counts are cumulative like in Prometheus histograms, so queries slower than the last bucket are only
+	// included in Count.
+	Buckets []uint64 `json:"buckets"`
+}
+
+// QueryMetrics is a [dbutil.DatabaseLogger] that measures the latency of each query and logs slow queries.
+// All calls are also passed through to the next logger, except for the timing of queries slower than one second
+// when SlowQueryThreshold is set, as the next logger would log them again with its own hardcoded threshold.
+// Such queries that are below SlowQueryThreshold are logged at debug level instead of as warnings.
+//
+// Queries are named after the function that called the dbutil methods, e.g. `bridgev2/database.(*MessageQuery).GetPartByID`.
+type QueryMetrics struct {
+	Next dbutil.DatabaseLogger
+	Log  *zerolog.Logger
+
+	// SlowQueryThreshold is the duration after which queries are logged as warnings. Zero disables slow query logging.
+	SlowQueryThreshold time.Duration
+	// CollectHistograms specifies whether latency histograms should be collected.
+	CollectHistograms bool
+
+	lock    sync.Mutex
+	queries map[string]*QueryStats
+}
+
+var _ dbutil.DatabaseLogger = (*QueryMetrics)(nil)
+
+// dbutilSlowQueryThreshold is the threshold hardcoded in the zerolog logger of dbutil.
+const dbutilSlowQueryThreshold = 1 * time.Second
+
+func (qm *QueryMetrics) QueryTiming(ctx context.Context, method, query string, args []any, nrows int, duration time.Duration, err error) {
+	// Slow queries aren't passed to the next logger when the threshold is configured, so they have to be logged here,
+	// either as warnings if they're above the configured threshold or as debug logs otherwise.
+	logSlow := qm.SlowQueryThreshold > 0 && duration >= min(qm.SlowQueryThreshold, dbutilSlowQueryThreshold)
+	var name string
+	if qm.CollectHistograms || logSlow {
+		name = getQueryName()
+	}
+	if qm.CollectHistograms {
+		qm.record(name, duration, err)
+	}
+	if qm.SlowQueryThreshold <= 0 || duration < dbutilSlowQueryThreshold {
+		qm.Next.QueryTiming(ctx, method, query, args, nrows, duration, err)
+	}
+	if !logSlow {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	if log.GetLevel() == zerolog.Disabled || log == zerolog.DefaultContextLogger {
+		log = qm.Log
+	}
+	var evt *zerolog.Event
+	if duration >= qm.SlowQueryThreshold {
+		evt = log.Warn()
+	} else {
+		evt = log.Debug()
+	}
+	evt = evt.
+		Err(err).
+		Float64("duration_seconds", duration.Seconds()).
+		Str("query_name", name).
+		Str("method", method).
+		Str("query", strings.TrimSpace(whitespaceRegex.ReplaceAllLiteralString(query, " ")))
+	if nrows > -1 {
+		evt = evt.Int("rows", nrows)
+	}
+	evt.Msg("Query took long")
+}
+
+func (qm *QueryMetrics) record(name string, duration time.Duration, err error) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+	if qm.queries == nil {
+		qm.queries = make(map[string]*QueryStats)
+	}
+	stats, ok := qm.queries[name]
+	if !ok {
+		stats = &QueryStats{Name: name, Buckets: make([]uint64, len(QueryLatencyBuckets))}
+		qm.queries[name] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+	}
+	seconds := duration.Seconds()
+	stats.TotalSeconds += seconds
+	stats.MaxSeconds = max(stats.MaxSeconds, seconds)
+	for i, bucket := range QueryLatencyBuckets {
+		if duration <= bucket {
+			stats.Buckets[i]++
+		}
+	}
+}
+
+// Snapshot returns a copy of the current latency histograms, sorted by total time spent in each query.
+func (qm *QueryMetrics) Snapshot() []*QueryStats {
+	qm.lock.Lock()
+	stats := make([]*QueryStats, 0, len(qm.queries))
+	for _, item := range qm.queries {
+		itemCopy := *item
+		itemCopy.Buckets = append([]uint64(nil), item.Buckets...)
+		stats = append(stats, &itemCopy)
+	}
+	qm.lock.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TotalSeconds > stats[j].TotalSeconds
+	})
+	return stats
+}
+
+// Reset clears all collected latency histograms.
+func (qm *QueryMetrics) Reset() {
+	qm.lock.Lock()
+	qm.queries = nil
+	qm.lock.Unlock()
+}
+
+func (qm *QueryMetrics) WarnUnsupportedVersion(current, compat, latest int) {
+	qm.Next.WarnUnsupportedVersion(current, compat, latest)
+}
+
+func (qm *QueryMetrics) PrepareUpgrade(current, compat, latest int) {
+	qm.Next.PrepareUpgrade(current, compat, latest)
+}
+
+func (qm *QueryMetrics) DoUpgrade(from, to int, message string, txn dbutil.TxnMode) {
+	qm.Next.DoUpgrade(from, to, message, txn)
+}
+
+func (qm *QueryMetrics) Warn(msg string, args ...any) {
+	qm.Next.Warn(msg, args...)
+}
+
+var whitespaceRegex = regexp.MustCompile(`\s+`)
+
+const queryNameMaxDepth = 32
+
+// getQueryName returns the name of the first function in the call stack that isn't a part of dbutil or database/sql.
+func getQueryName() string {
+	var pcs [queryNameMaxDepth]uintptr
+	// Skip runtime.Callers, getQueryName and QueryMetrics.QueryTiming
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" &&
+			!strings.HasPrefix(frame.Function, "go.mau.fi/util/") &&
+			!strings.HasPrefix(frame.Function, "database/sql.") {
+			return strings.TrimPrefix(frame.Function, "maunium.net/go/mautrix/")
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+)
+
+type testNextLogger struct {
+	dbutil.DatabaseLogger
+	durations []time.Duration
+}
+
+func (tnl *testNextLogger) QueryTiming(ctx context.Context, method, query string, args []any, nrows int, duration time.Duration, err error) {
+	tnl.durations = append(tnl.durations, duration)
+}
+
+func newTestQueryMetrics(threshold time.Duration) (*QueryMetrics, *testNextLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+	next := &testNextLogger{DatabaseLogger: dbutil.NoopLogger}
+	return &QueryMetrics{Next: next, Log: &log, SlowQueryThreshold: threshold, CollectHistograms: true}, next, &buf
+}
+
+func TestQueryMetrics_Histogram(t *testing.T) {
+	qm, _, _ := newTestQueryMetrics(0)
+	ctx := context.Background()
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, 3*time.Millisecond, nil)
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, 200*time.Millisecond, errors.New("meow"))
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, time.Minute, nil)
+
+	snapshot := qm.Snapshot()
+	require.Len(t, snapshot, 1)
+	stats := snapshot[0]
+	assert.Equal(t, "bridgev2/database.TestQueryMetrics_Histogram", stats.Name)
+	assert.EqualValues(t, 3, stats.Count)
+	assert.EqualValues(t, 1, stats.Errors)
+	assert.Equal(t, time.Minute.Seconds(), stats.MaxSeconds)
+	assert.Equal(t, []uint64{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2}, stats.Buckets, "buckets should be cumulative")
+
+	stats.Buckets[0] = 100
+	assert.EqualValues(t, 0, qm.Snapshot()[0].Buckets[0], "snapshot should be a copy")
+	qm.Reset()
+	assert.Empty(t, qm.Snapshot())
+}
+
+func runTestMetricsQuery(ctx context.Context, db *dbutil.Database) error {
+	_, err := db.Exec(ctx, "SELECT 1")
+	return err
+}
+
+func TestQueryMetrics_Naming(t *testing.T) {
+	rawDB, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	qm, _, _ := newTestQueryMetrics(0)
+	db.Log = qm
+	require.NoError(t, runTestMetricsQuery(context.Background(), db))
+	snapshot := qm.Snapshot()
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, "bridgev2/database.runTestMetricsQuery", snapshot[0].Name, "dbutil frames should be skipped")
+}
+
+func TestQueryMetrics_SlowQueryLogging(t *testing.T) {
+	ctx := context.Background()
+	qm, next, buf := newTestQueryMetrics(5 * time.Second)
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, 100*time.Millisecond, nil)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond}, next.durations)
+	assert.Empty(t, buf.String())
+
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, 2*time.Second, nil)
+	assert.Len(t, next.durations, 1, "queries above the hardcoded dbutil threshold shouldn't be passed through")
+	assert.Contains(t, buf.String(), `"level":"debug"`, "queries below the configured threshold should still be logged")
+	buf.Reset()
+
+	qm.QueryTiming(ctx, "Exec", "SELECT 1", nil, -1, 6*time.Second, nil)
+	assert.Len(t, next.durations, 1)
+	assert.Contains(t, buf.String(), `"level":"warn"`)
+	assert.Contains(t, buf.String(), `"query_name":"bridgev2/database.TestQueryMetrics_SlowQueryLogging"`)
+}
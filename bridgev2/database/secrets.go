@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrMetadataEncrypted        = errors.New("metadata is encrypted, but no crypter is configured")
+	ErrMetadataDecryptionFailed = errors.New("failed to decrypt metadata (wrong key?)")
+)
+
+// MetadataCrypter encrypts and decrypts user login metadata when it's stored in the database.
+//
+// The default implementation is AESMetadataCrypter, which uses a static key from the bridge config.
+// Bridges can provide their own implementation, e.g. to use a key management service,
+// by setting UserLoginQuery.Crypter before the bridge is started.
+type MetadataCrypter interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+const metadataKeyInfo = "mautrix bridgev2 user login metadata v1"
+
+// AESMetadataCrypter is a MetadataCrypter that uses AES-256-GCM with a key derived from a static secret.
+type AESMetadataCrypter struct {
+	aead cipher.AEAD
+}
+
+var _ MetadataCrypter = (*AESMetadataCrypter)(nil)
+
+// NewAESMetadataCrypter creates a new AESMetadataCrypter. The key is passed through HKDF, so any length is accepted,
+// but it should be high-entropy (e.g. at least 32 random bytes).
+func NewAESMetadataCrypter(key []byte) (*AESMetadataCrypter, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("metadata encryption key must not be empty")
+	}
+	aesKey := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(metadataKeyInfo)), aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive metadata encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESMetadataCrypter{aead: aead}, nil
+}
+
+func (ac *AESMetadataCrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, ac.aead.NonceSize(), ac.aead.NonceSize()+len(plaintext)+ac.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return ac.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (ac *AESMetadataCrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < ac.aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:ac.aead.NonceSize()], ciphertext[ac.aead.NonceSize():]
+	return ac.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// encryptedMetadata is the JSON object stored in the metadata column when encryption is enabled.
+// It's a JSON object rather than a raw string, so that the column stays valid jsonb.
+type encryptedMetadata struct {
+	Ciphertext []byte `json:"mautrix_encrypted_v1"`
+}
+
+func parseEncryptedMetadata(data []byte) ([]byte, bool) {
+	var wrapper encryptedMetadata
+	if json.Unmarshal(data, &wrapper) != nil || wrapper.Ciphertext == nil {
+		return nil, false
+	}
+	return wrapper.Ciphertext, true
+}
+
+func encryptMetadata(crypter MetadataCrypter, plaintext []byte) (string, error) {
+	ciphertext, err := crypter.Encrypt(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	wrapped, err := json.Marshal(&encryptedMetadata{Ciphertext: ciphertext})
+	return string(wrapped), err
+}
+
+// cryptedJSON is like dbutil.JSON, but transparently encrypts and decrypts the data if a crypter is set.
+// Plaintext values are always accepted when scanning to allow migrating existing rows.
+type cryptedJSON struct {
+	Data    any
+	Crypter MetadataCrypter
+}
+
+func (cj cryptedJSON) Scan(i any) error {
+	var data []byte
+	switch value := i.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("invalid type %T for cryptedJSON.Scan", i)
+	}
+	if ciphertext, ok := parseEncryptedMetadata(data); ok {
+		if cj.Crypter == nil {
+			return ErrMetadataEncrypted
+		}
+		var err error
+		data, err = cj.Crypter.Decrypt(ciphertext)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMetadataDecryptionFailed, err)
+		}
+	}
+	return json.Unmarshal(data, cj.Data)
+}
+
+func (cj cryptedJSON) Value() (driver.Value, error) {
+	if cj.Data == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(cj.Data)
+	if err != nil {
+		return nil, err
+	} else if cj.Crypter == nil {
+		return string(data), nil
+	}
+	return encryptMetadata(cj.Crypter, data)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+)
+
+type testLoginMetadata struct {
+	Token string `json:"token"`
+}
+
+func newTestCrypter(t *testing.T, key string) *AESMetadataCrypter {
+	t.Helper()
+	crypter, err := NewAESMetadataCrypter([]byte(key))
+	require.NoError(t, err)
+	return crypter
+}
+
+func newTestLoginDatabase(t *testing.T) *Database {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	require.NoError(t, err)
+	// Every connection to :memory: gets its own database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	bdb := New("test", MetaTypes{UserLogin: func() any { return &testLoginMetadata{} }}, db)
+	require.NoError(t, bdb.Upgrade(context.Background()))
+	require.NoError(t, bdb.User.Insert(context.Background(), &User{MXID: "@alice:example.com"}))
+	return bdb
+}
+
+func getRawLoginMetadata(t *testing.T, db *Database, loginID string) string {
+	t.Helper()
+	var metadata string
+	require.NoError(t, db.QueryRow(context.Background(), "SELECT metadata FROM user_login WHERE id=$1", loginID).Scan(&metadata))
+	return metadata
+}
+
+func TestAESMetadataCrypter(t *testing.T) {
+	_, err := NewAESMetadataCrypter(nil)
+	assert.Error(t, err)
+
+	crypter := newTestCrypter(t, "meow")
+	ciphertext, err := crypter.Encrypt([]byte("secret"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "secret")
+	plaintext, err := crypter.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), plaintext)
+
+	_, err = newTestCrypter(t, "hmm").Decrypt(ciphertext)
+	assert.Error(t, err, "decrypting with the wrong key should fail")
+	_, err = crypter.Decrypt(ciphertext[:4])
+	assert.Error(t, err)
+}
+
+func TestCryptedJSON_RoundTrip(t *testing.T) {
+	crypter := newTestCrypter(t, "meow")
+	value, err := cryptedJSON{Data: &testLoginMetadata{Token: "secret"}, Crypter: crypter}.Value()
+	require.NoError(t, err)
+	assert.NotContains(t, value, "secret")
+
+	var meta testLoginMetadata
+	require.NoError(t, cryptedJSON{Data: &meta, Crypter: crypter}.Scan(value))
+	assert.Equal(t, "secret", meta.Token)
+
+	var plainMeta testLoginMetadata
+	assert.ErrorIs(t, cryptedJSON{Data: &plainMeta}.Scan(value), ErrMetadataEncrypted)
+	assert.ErrorIs(t, cryptedJSON{Data: &plainMeta, Crypter: newTestCrypter(t, "hmm")}.Scan(value), ErrMetadataDecryptionFailed)
+}
+
+func TestCryptedJSON_Plaintext(t *testing.T) {
+	value, err := cryptedJSON{Data: &testLoginMetadata{Token: "secret"}}.Value()
+	require.NoError(t, err)
+	assert.Equal(t, `{"token":"secret"}`, value)
+
+	var meta testLoginMetadata
+	require.NoError(t, cryptedJSON{Data: &meta, Crypter: newTestCrypter(t, "meow")}.Scan([]byte(`{"token":"secret"}`)))
+	assert.Equal(t, "secret", meta.Token, "legacy plaintext values should be readable with a crypter")
+}
+
+func TestUserLoginQuery_EncryptPlaintextMetadata(t *testing.T) {
+	ctx := context.Background()
+	db := newTestLoginDatabase(t)
+	_, err := db.UserLogin.EncryptPlaintextMetadata(ctx)
+	assert.Error(t, err, "migrating without a crypter should fail")
+
+	require.NoError(t, db.UserLogin.Insert(ctx, &UserLogin{UserMXID: "@alice:example.com", ID: "alice", Metadata: &testLoginMetadata{Token: "secret"}}))
+	assert.Contains(t, getRawLoginMetadata(t, db, "alice"), "secret")
+
+	db.UserLogin.Crypter = newTestCrypter(t, "meow")
+	login, err := db.UserLogin.GetByID(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", login.Metadata.(*testLoginMetadata).Token, "legacy plaintext rows should be readable")
+
+	migrated, err := db.UserLogin.EncryptPlaintextMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	assert.NotContains(t, getRawLoginMetadata(t, db, "alice"), "secret")
+	login, err = db.UserLogin.GetByID(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", login.Metadata.(*testLoginMetadata).Token)
+
+	migrated, err = db.UserLogin.EncryptPlaintextMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated, "already encrypted rows shouldn't be migrated again")
+
+	db.UserLogin.Crypter = newTestCrypter(t, "hmm")
+	_, err = db.UserLogin.GetByID(ctx, "alice")
+	assert.ErrorIs(t, err, ErrMetadataDecryptionFailed)
+}
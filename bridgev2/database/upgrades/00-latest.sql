@@ -1,4 +1,4 @@
--- v0 -> v18 (compatible with v9+): Latest revision
+-- v0 -> v24 (compatible with v9+): Latest revision
 CREATE TABLE "user" (
 	bridge_id       TEXT NOT NULL,
 	mxid            TEXT NOT NULL,
@@ -51,6 +51,8 @@ CREATE TABLE portal (
 	room_type       TEXT    NOT NULL,
 	disappear_type  TEXT,
 	disappear_timer BIGINT,
+	notification_policy TEXT NOT NULL DEFAULT '',
+	notification_policy_manual BOOLEAN NOT NULL DEFAULT false,
 	metadata        jsonb   NOT NULL,
 
 	PRIMARY KEY (bridge_id, id, receiver),
@@ -78,6 +80,8 @@ CREATE TABLE ghost (
 	identifiers      jsonb   NOT NULL,
 	metadata         jsonb   NOT NULL,
 
+	info_refreshed_at BIGINT NOT NULL DEFAULT 0,
+
 	PRIMARY KEY (bridge_id, id)
 );
 
@@ -192,6 +196,7 @@ CREATE TABLE backfill_task (
 	dispatched_at        BIGINT,
 	completed_at         BIGINT,
 	next_dispatch_min_ts BIGINT  NOT NULL,
+	latest_message_ts    BIGINT  NOT NULL DEFAULT 0,
 
 	PRIMARY KEY (bridge_id, portal_id, portal_receiver),
 	CONSTRAINT backfill_queue_portal_fkey FOREIGN KEY (bridge_id, portal_id, portal_receiver)
@@ -206,3 +211,44 @@ CREATE TABLE kv_store (
 
 	PRIMARY KEY (bridge_id, key)
 );
+
+CREATE TABLE instance_lock (
+	bridge_id   TEXT   NOT NULL PRIMARY KEY,
+	instance_id TEXT   NOT NULL,
+	expires_at  BIGINT NOT NULL
+);
+
+CREATE TABLE user_login_share (
+	bridge_id TEXT NOT NULL,
+	login_id  TEXT NOT NULL,
+	user_mxid TEXT NOT NULL,
+
+	PRIMARY KEY (bridge_id, login_id, user_mxid),
+	CONSTRAINT user_login_share_login_fkey FOREIGN KEY (bridge_id, login_id)
+		REFERENCES user_login (bridge_id, id)
+		ON DELETE CASCADE ON UPDATE CASCADE,
+	CONSTRAINT user_login_share_user_fkey FOREIGN KEY (bridge_id, user_mxid)
+		REFERENCES "user" (bridge_id, mxid)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX user_login_share_user_idx ON user_login_share (bridge_id, user_mxid);
+
+CREATE TABLE failed_message (
+	bridge_id       TEXT    NOT NULL,
+	event_id        TEXT    NOT NULL,
+	room_id         TEXT    NOT NULL,
+	portal_id       TEXT    NOT NULL,
+	portal_receiver TEXT    NOT NULL,
+	sender_mxid     TEXT    NOT NULL,
+	error           TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL,
+	last_attempt    BIGINT  NOT NULL,
+	next_retry      BIGINT,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT failed_message_portal_fkey FOREIGN KEY (bridge_id, portal_id, portal_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX failed_message_portal_idx ON failed_message (bridge_id, portal_id, portal_receiver);
+CREATE INDEX failed_message_next_retry_idx ON failed_message (bridge_id, next_retry);
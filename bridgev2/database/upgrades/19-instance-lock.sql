@@ -0,0 +1,6 @@
+-- v19 (compatible with v9+): Add lock for the active instance in multi-instance deployments
+CREATE TABLE instance_lock (
+	bridge_id   TEXT   NOT NULL PRIMARY KEY,
+	instance_id TEXT   NOT NULL,
+	expires_at  BIGINT NOT NULL
+);
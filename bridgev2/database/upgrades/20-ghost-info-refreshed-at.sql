@@ -0,0 +1,2 @@
+-- v20 (compatible with v9+): Store when ghost info was last fetched from the remote network
+ALTER TABLE ghost ADD COLUMN info_refreshed_at BIGINT NOT NULL DEFAULT 0;
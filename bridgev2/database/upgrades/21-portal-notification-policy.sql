@@ -0,0 +1,3 @@
+-- v21 (compatible with v9+): Add notification policy to portals
+ALTER TABLE portal ADD COLUMN notification_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE portal ADD COLUMN notification_policy_manual BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,15 @@
+-- v22 (compatible with v9+): Add table for sharing logins with other users
+CREATE TABLE user_login_share (
+	bridge_id TEXT NOT NULL,
+	login_id  TEXT NOT NULL,
+	user_mxid TEXT NOT NULL,
+
+	PRIMARY KEY (bridge_id, login_id, user_mxid),
+	CONSTRAINT user_login_share_login_fkey FOREIGN KEY (bridge_id, login_id)
+		REFERENCES user_login (bridge_id, id)
+		ON DELETE CASCADE ON UPDATE CASCADE,
+	CONSTRAINT user_login_share_user_fkey FOREIGN KEY (bridge_id, user_mxid)
+		REFERENCES "user" (bridge_id, mxid)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX user_login_share_user_idx ON user_login_share (bridge_id, user_mxid);
@@ -0,0 +1,8 @@
+-- v23 (compatible with v9+): Store latest message timestamp in backfill tasks for prioritization
+ALTER TABLE backfill_task ADD COLUMN latest_message_ts BIGINT NOT NULL DEFAULT 0;
+UPDATE backfill_task SET latest_message_ts=(
+	SELECT COALESCE(MAX(message.timestamp), 0) FROM message
+	WHERE message.bridge_id = backfill_task.bridge_id
+		AND message.room_id = backfill_task.portal_id
+		AND message.room_receiver = backfill_task.portal_receiver
+);
@@ -0,0 +1,20 @@
+-- v24 (compatible with v9+): Add table for Matrix messages that failed to bridge
+CREATE TABLE failed_message (
+	bridge_id       TEXT    NOT NULL,
+	event_id        TEXT    NOT NULL,
+	room_id         TEXT    NOT NULL,
+	portal_id       TEXT    NOT NULL,
+	portal_receiver TEXT    NOT NULL,
+	sender_mxid     TEXT    NOT NULL,
+	error           TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL,
+	last_attempt    BIGINT  NOT NULL,
+	next_retry      BIGINT,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT failed_message_portal_fkey FOREIGN KEY (bridge_id, portal_id, portal_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX failed_message_portal_idx ON failed_message (bridge_id, portal_id, portal_receiver);
+CREATE INDEX failed_message_next_retry_idx ON failed_message (bridge_id, next_retry);
@@ -9,6 +9,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"go.mau.fi/util/dbutil"
 
@@ -20,6 +21,8 @@ import (
 type UserLoginQuery struct {
 	BridgeID networkid.BridgeID
 	MetaType MetaTypeCreator
+	// Crypter is used to encrypt the metadata of user logins at rest. If nil, metadata is stored as plaintext.
+	Crypter MetadataCrypter
 	*dbutil.QueryHelper[*UserLogin]
 }
 
@@ -31,6 +34,8 @@ type UserLogin struct {
 	RemoteProfile status.RemoteProfile
 	SpaceRoom     id.RoomID
 	Metadata      any
+
+	crypter MetadataCrypter
 }
 
 const (
@@ -56,6 +61,8 @@ const (
 	deleteUserLoginQuery = `
 		DELETE FROM user_login WHERE bridge_id=$1 AND id=$2
 	`
+	getAllLoginMetadataQuery = `SELECT id, metadata FROM user_login WHERE bridge_id=$1`
+	updateLoginMetadataQuery = `UPDATE user_login SET metadata=$3 WHERE bridge_id=$1 AND id=$2`
 )
 
 func (uq *UserLoginQuery) GetByID(ctx context.Context, id networkid.UserLoginID) (*UserLogin, error) {
@@ -77,18 +84,59 @@ func (uq *UserLoginQuery) GetAllForUser(ctx context.Context, userID id.UserID) (
 
 func (uq *UserLoginQuery) Insert(ctx context.Context, login *UserLogin) error {
 	ensureBridgeIDMatches(&login.BridgeID, uq.BridgeID)
-	return uq.Exec(ctx, insertUserLoginQuery, login.ensureHasMetadata(uq.MetaType).sqlVariables()...)
+	return uq.Exec(ctx, insertUserLoginQuery, login.ensureHasMetadata(uq.MetaType).sqlVariables(uq.Crypter)...)
 }
 
 func (uq *UserLoginQuery) Update(ctx context.Context, login *UserLogin) error {
 	ensureBridgeIDMatches(&login.BridgeID, uq.BridgeID)
-	return uq.Exec(ctx, updateUserLoginQuery, login.ensureHasMetadata(uq.MetaType).sqlVariables()...)
+	return uq.Exec(ctx, updateUserLoginQuery, login.ensureHasMetadata(uq.MetaType).sqlVariables(uq.Crypter)...)
 }
 
 func (uq *UserLoginQuery) Delete(ctx context.Context, loginID networkid.UserLoginID) error {
 	return uq.Exec(ctx, deleteUserLoginQuery, uq.BridgeID, loginID)
 }
 
+type rawLoginMetadata struct {
+	ID       networkid.UserLoginID
+	Metadata []byte
+}
+
+func scanRawLoginMetadata(row dbutil.Scannable) (rlm rawLoginMetadata, err error) {
+	err = row.Scan(&rlm.ID, &rlm.Metadata)
+	return
+}
+
+// EncryptPlaintextMetadata encrypts the metadata of all user logins that were stored before encryption was enabled.
+// It returns the number of logins that were migrated. Logins that are already encrypted are not touched.
+func (uq *UserLoginQuery) EncryptPlaintextMetadata(ctx context.Context) (migrated int, err error) {
+	if uq.Crypter == nil {
+		return 0, fmt.Errorf("metadata encryption is not enabled")
+	}
+	err = uq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		rows, err := uq.GetDB().Query(ctx, getAllLoginMetadataQuery, uq.BridgeID)
+		logins, err := dbutil.NewRowIterWithError(rows, scanRawLoginMetadata, err).AsList()
+		if err != nil {
+			return fmt.Errorf("failed to get login metadata: %w", err)
+		}
+		for _, login := range logins {
+			if _, alreadyEncrypted := parseEncryptedMetadata(login.Metadata); alreadyEncrypted {
+				continue
+			}
+			encrypted, err := encryptMetadata(uq.Crypter, login.Metadata)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt metadata of %s: %w", login.ID, err)
+			}
+			err = uq.Exec(ctx, updateLoginMetadataQuery, uq.BridgeID, login.ID, encrypted)
+			if err != nil {
+				return fmt.Errorf("failed to update metadata of %s: %w", login.ID, err)
+			}
+			migrated++
+		}
+		return nil
+	})
+	return
+}
+
 func (u *UserLogin) Scan(row dbutil.Scannable) (*UserLogin, error) {
 	var spaceRoom sql.NullString
 	err := row.Scan(
@@ -98,7 +146,7 @@ func (u *UserLogin) Scan(row dbutil.Scannable) (*UserLogin, error) {
 		&u.RemoteName,
 		dbutil.JSON{Data: &u.RemoteProfile},
 		&spaceRoom,
-		dbutil.JSON{Data: u.Metadata},
+		cryptedJSON{Data: u.Metadata, Crypter: u.crypter},
 	)
 	if err != nil {
 		return nil, err
@@ -114,10 +162,10 @@ func (u *UserLogin) ensureHasMetadata(metaType MetaTypeCreator) *UserLogin {
 	return u
 }
 
-func (u *UserLogin) sqlVariables() []any {
+func (u *UserLogin) sqlVariables(crypter MetadataCrypter) []any {
 	var remoteProfile dbutil.JSON
 	if !u.RemoteProfile.IsEmpty() {
 		remoteProfile.Data = &u.RemoteProfile
 	}
-	return []any{u.BridgeID, u.UserMXID, u.ID, u.RemoteName, remoteProfile, dbutil.StrPtr(u.SpaceRoom), dbutil.JSON{Data: u.Metadata}}
+	return []any{u.BridgeID, u.UserMXID, u.ID, u.RemoteName, remoteProfile, dbutil.StrPtr(u.SpaceRoom), cryptedJSON{Data: u.Metadata, Crypter: crypter}}
 }
@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+type UserLoginShareQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.Database
+}
+
+const (
+	addUserLoginShareQuery = `
+		INSERT INTO user_login_share (bridge_id, login_id, user_mxid) VALUES ($1, $2, $3)
+		ON CONFLICT (bridge_id, login_id, user_mxid) DO NOTHING
+	`
+	removeUserLoginShareQuery = `
+		DELETE FROM user_login_share WHERE bridge_id=$1 AND login_id=$2 AND user_mxid=$3
+	`
+	getUserLoginSharesQuery = `
+		SELECT user_mxid FROM user_login_share WHERE bridge_id=$1 AND login_id=$2 ORDER BY user_mxid
+	`
+	getLoginsSharedWithUserQuery = `
+		SELECT login_id FROM user_login_share WHERE bridge_id=$1 AND user_mxid=$2 ORDER BY login_id
+	`
+)
+
+// Add shares the given login with the given user. Adding an existing share is a no-op.
+func (ulsq *UserLoginShareQuery) Add(ctx context.Context, loginID networkid.UserLoginID, userID id.UserID) error {
+	_, err := ulsq.Exec(ctx, addUserLoginShareQuery, ulsq.BridgeID, loginID, userID)
+	return err
+}
+
+func (ulsq *UserLoginShareQuery) Remove(ctx context.Context, loginID networkid.UserLoginID, userID id.UserID) (removed bool, err error) {
+	res, err := ulsq.Exec(ctx, removeUserLoginShareQuery, ulsq.BridgeID, loginID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// GetUsers returns the users that the given login has been shared with.
+func (ulsq *UserLoginShareQuery) GetUsers(ctx context.Context, loginID networkid.UserLoginID) ([]id.UserID, error) {
+	rows, err := ulsq.Query(ctx, getUserLoginSharesQuery, ulsq.BridgeID, loginID)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.UserID], err).AsList()
+}
+
+// GetLogins returns the IDs of the logins that have been shared with the given user.
+func (ulsq *UserLoginShareQuery) GetLogins(ctx context.Context, userID id.UserID) ([]networkid.UserLoginID, error) {
+	rows, err := ulsq.Query(ctx, getLoginsSharedWithUserQuery, ulsq.BridgeID, userID)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[networkid.UserLoginID], err).AsList()
+}
@@ -11,6 +11,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exmime"
@@ -225,8 +226,14 @@ func (br *Bridge) allowAggressiveUpdateForType(evtType RemoteEventType) bool {
 }
 
 func (ghost *Ghost) UpdateInfoIfNecessary(ctx context.Context, source *UserLogin, evtType RemoteEventType) {
-	if ghost.Name != "" && ghost.NameSet && !ghost.Bridge.allowAggressiveUpdateForType(evtType) {
-		return
+	if ghost.Name != "" && ghost.NameSet {
+		if !ghost.Bridge.allowAggressiveUpdateForType(evtType) {
+			ghost.Bridge.GhostRefresher.QueueIfStale(ghost, source)
+			return
+		} else if ghost.Bridge.Network.GetCapabilities().UserInfoTTL > 0 && !ghost.Bridge.GhostRefresher.IsStale(ghost) {
+			// Info was fetched recently enough, don't re-fetch it even in aggressive mode
+			return
+		}
 	}
 	info, err := source.Client.GetUserInfo(ctx, ghost)
 	if err != nil {
@@ -236,7 +243,7 @@ func (ghost *Ghost) UpdateInfoIfNecessary(ctx context.Context, source *UserLogin
 			Bool("has_name", ghost.Name != "").
 			Bool("name_set", ghost.NameSet).
 			Msg("Updating ghost info in IfNecessary call")
-		ghost.UpdateInfo(ctx, info)
+		ghost.updateInfo(ctx, info, true)
 	} else {
 		zerolog.Ctx(ctx).Trace().
 			Bool("has_name", ghost.Name != "").
@@ -260,7 +267,15 @@ func (ghost *Ghost) updateDMPortals(ctx context.Context) {
 }
 
 func (ghost *Ghost) UpdateInfo(ctx context.Context, info *UserInfo) {
+	ghost.updateInfo(ctx, info, false)
+}
+
+func (ghost *Ghost) updateInfo(ctx context.Context, info *UserInfo, fullRefresh bool) {
 	update := false
+	if fullRefresh {
+		ghost.InfoRefreshedAt = time.Now()
+		update = true
+	}
 	oldName := ghost.Name
 	oldAvatar := ghost.AvatarMXC
 	if info.Name != nil {
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// BulkGhostSetupMinMembers is the minimum number of ghosts that need to be set up when creating a portal
+// for them to be set up concurrently using [MatrixConnectorWithBulkGhostSetup].
+var BulkGhostSetupMinMembers = 50
+
+// setupGhostsInBulk registers the ghosts of joined members and sets their displaynames concurrently,
+// if the Matrix connector supports it and there are enough ghosts that need to be set up.
+//
+// The rest of the info (like avatars) is still updated one member at a time by the caller,
+// but the displayname updates will be no-ops at that point.
+func (portal *Portal) setupGhostsInBulk(ctx context.Context, members *ChatMemberList) {
+	bulk, ok := portal.Bridge.Matrix.(MatrixConnectorWithBulkGhostSetup)
+	if !ok || len(members.MemberMap) < BulkGhostSetupMinMembers {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	ghosts := make(map[id.UserID]*Ghost)
+	displaynames := make(map[id.UserID]string)
+	for _, member := range members.MemberMap {
+		if (member.Membership != event.MembershipJoin && member.Membership != "") ||
+			member.Sender == "" || member.UserInfo == nil || member.UserInfo.Name == nil {
+			continue
+		}
+		ghost, err := portal.Bridge.GetGhostByID(ctx, member.Sender)
+		if err != nil {
+			log.Err(err).Str("ghost_id", string(member.Sender)).Msg("Failed to get ghost for bulk setup")
+			continue
+		} else if ghost.Name == *member.UserInfo.Name && ghost.NameSet {
+			continue
+		}
+		ghosts[ghost.Intent.GetMXID()] = ghost
+		displaynames[ghost.Intent.GetMXID()] = *member.UserInfo.Name
+	}
+	if len(displaynames) < BulkGhostSetupMinMembers {
+		return
+	}
+	log.Debug().Int("ghost_count", len(displaynames)).Msg("Setting up ghosts in bulk")
+	progress := getPortalCreationProgress(ctx)
+	progress.Start(ctx, PortalCreationStageGhosts, len(displaynames))
+	errs := bulk.SetupGhosts(ctx, displaynames, func() {
+		progress.Increment(ctx)
+	})
+	for userID, ghost := range ghosts {
+		if err, failed := errs[userID]; failed {
+			log.Err(err).Stringer("user_id", userID).Msg("Failed to set up ghost in bulk")
+			continue
+		} else if ctx.Err() != nil {
+			// Ghosts that weren't processed before the context was canceled aren't included in the errors
+			return
+		}
+		ghost.Name = displaynames[userID]
+		ghost.NameSet = true
+		err := portal.Bridge.DB.Ghost.Update(ctx, ghost.Ghost)
+		if err != nil {
+			log.Err(err).Stringer("user_id", userID).Msg("Failed to update ghost in database after bulk setup")
+		}
+		ghost.updateDMPortals(ctx)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type testGhostIntent struct {
+	MatrixAPI
+	userID id.UserID
+}
+
+func (tgi *testGhostIntent) GetMXID() id.UserID {
+	return tgi.userID
+}
+
+type testBulkMatrix struct {
+	testMatrix
+	setupCalls   int
+	displaynames map[id.UserID]string
+	failUser     id.UserID
+}
+
+var _ MatrixConnectorWithBulkGhostSetup = (*testBulkMatrix)(nil)
+
+func (tbm *testBulkMatrix) GhostIntent(userID networkid.UserID) MatrixAPI {
+	return &testGhostIntent{userID: id.UserID(fmt.Sprintf("@test_%s:example.com", userID))}
+}
+
+func (tbm *testBulkMatrix) SetupGhosts(ctx context.Context, displaynames map[id.UserID]string, progress func()) map[id.UserID]error {
+	tbm.setupCalls++
+	tbm.displaynames = displaynames
+	errs := make(map[id.UserID]error)
+	for userID := range displaynames {
+		if userID == tbm.failUser {
+			errs[userID] = errors.New("registration failed")
+		}
+		progress()
+	}
+	return errs
+}
+
+func newTestBulkMembers(count int) *ChatMemberList {
+	members := &ChatMemberList{MemberMap: make(map[networkid.UserID]ChatMember)}
+	for i := 0; i < count; i++ {
+		userID := networkid.UserID(fmt.Sprintf("user%d", i))
+		name := fmt.Sprintf("User %d", i)
+		members.MemberMap[userID] = ChatMember{
+			EventSender: EventSender{Sender: userID},
+			UserInfo:    &UserInfo{Name: &name},
+		}
+	}
+	return members
+}
+
+func TestPortal_SetupGhostsInBulk(t *testing.T) {
+	ctx := context.Background()
+	br := newTestBridge(t)
+	matrix := &testBulkMatrix{failUser: "@test_user1:example.com"}
+	br.Matrix = matrix
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	members := newTestBulkMembers(BulkGhostSetupMinMembers + 1)
+	members.MemberMap["left"] = ChatMember{
+		EventSender: EventSender{Sender: "left"},
+		Membership:  event.MembershipLeave,
+		UserInfo:    &UserInfo{Name: members.MemberMap["user0"].UserInfo.Name},
+	}
+
+	portal.setupGhostsInBulk(ctx, members)
+	require.Equal(t, 1, matrix.setupCalls)
+	assert.Len(t, matrix.displaynames, BulkGhostSetupMinMembers+1, "only joined members should be set up")
+	assert.Equal(t, "User 0", matrix.displaynames["@test_user0:example.com"])
+
+	ghost, err := br.GetGhostByID(ctx, "user0")
+	require.NoError(t, err)
+	assert.Equal(t, "User 0", ghost.Name)
+	assert.True(t, ghost.NameSet)
+	dbGhost, err := br.DB.Ghost.GetByID(ctx, "user0")
+	require.NoError(t, err)
+	assert.True(t, dbGhost.NameSet)
+	failedGhost, err := br.GetGhostByID(ctx, "user1")
+	require.NoError(t, err)
+	assert.False(t, failedGhost.NameSet, "ghosts that failed to be set up shouldn't be marked as done")
+
+	// Ghosts that are already set up are skipped, which leaves too few ghosts for bulk setup
+	portal.setupGhostsInBulk(ctx, members)
+	assert.Equal(t, 1, matrix.setupCalls)
+}
+
+func TestPortal_SetupGhostsInBulk_SmallGroup(t *testing.T) {
+	br := newTestBridge(t)
+	matrix := &testBulkMatrix{}
+	br.Matrix = matrix
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	portal.setupGhostsInBulk(context.Background(), newTestBulkMembers(BulkGhostSetupMinMembers-1))
+	assert.Equal(t, 0, matrix.setupCalls)
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+const (
+	// How long to wait for more stale ghosts to be queued before fetching a batch.
+	ghostRefreshCollectDelay = 5 * time.Second
+	// The maximum number of ghosts to refresh in one batch.
+	ghostRefreshBatchSize = 100
+	// How long to wait between individual GetUserInfo calls for connectors that don't support bulk fetching.
+	ghostRefreshSingleDelay = 500 * time.Millisecond
+)
+
+type queuedGhostRefresh struct {
+	ghost  *Ghost
+	source *UserLogin
+}
+
+// GhostInfoRefresher re-fetches info of ghosts whose info is older than [NetworkGeneralCapabilities.UserInfoTTL].
+//
+// Stale ghosts are queued when they're seen in remote events and fetched in the background in batches,
+// so that reconnecting (which usually produces a burst of events from many users) doesn't cause a request
+// for every ghost at once.
+type GhostInfoRefresher struct {
+	br      *Bridge
+	queue   map[networkid.UserID]*queuedGhostRefresh
+	lock    sync.Mutex
+	wakeup  chan struct{}
+	stop    context.CancelFunc
+	running bool
+}
+
+func newGhostInfoRefresher(br *Bridge) *GhostInfoRefresher {
+	return &GhostInfoRefresher{
+		br:     br,
+		queue:  make(map[networkid.UserID]*queuedGhostRefresh),
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// IsStale returns true if the given ghost's info should be re-fetched.
+// Info is never considered stale if the network connector doesn't set a TTL.
+func (gir *GhostInfoRefresher) IsStale(ghost *Ghost) bool {
+	ttl := gir.br.Network.GetCapabilities().UserInfoTTL
+	return ttl > 0 && time.Since(ghost.InfoRefreshedAt) > ttl
+}
+
+// QueueIfStale queues the ghost for a background info refresh if its info is stale.
+// The source login is used to fetch the info. Ghosts that are already queued are not queued again.
+func (gir *GhostInfoRefresher) QueueIfStale(ghost *Ghost, source *UserLogin) {
+	if source == nil || !gir.IsStale(ghost) {
+		return
+	}
+	gir.lock.Lock()
+	if !gir.running {
+		gir.lock.Unlock()
+		return
+	}
+	_, alreadyQueued := gir.queue[ghost.ID]
+	if !alreadyQueued {
+		gir.queue[ghost.ID] = &queuedGhostRefresh{ghost: ghost, source: source}
+	}
+	gir.lock.Unlock()
+	if !alreadyQueued {
+		select {
+		case gir.wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (gir *GhostInfoRefresher) Start() {
+	if gir.br.Network.GetCapabilities().UserInfoTTL <= 0 {
+		return
+	}
+	log := gir.br.Log.With().Str("component", "ghost info refresher").Logger()
+	ctx := log.WithContext(context.Background())
+	gir.lock.Lock()
+	ctx, gir.stop = context.WithCancel(ctx)
+	gir.running = true
+	gir.lock.Unlock()
+	go gir.loop(ctx)
+}
+
+func (gir *GhostInfoRefresher) loop(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	log.Debug().Msg("Ghost info refresher starting")
+	for {
+		select {
+		case <-gir.wakeup:
+		case <-ctx.Done():
+			log.Debug().Msg("Ghost info refresher stopping")
+			return
+		}
+		select {
+		case <-time.After(ghostRefreshCollectDelay):
+		case <-ctx.Done():
+			log.Debug().Msg("Ghost info refresher stopping")
+			return
+		}
+		for gir.refreshBatch(ctx) {
+		}
+	}
+}
+
+func (gir *GhostInfoRefresher) Stop() {
+	gir.lock.Lock()
+	defer gir.lock.Unlock()
+	if gir.stop != nil {
+		gir.stop()
+	}
+	gir.running = false
+	clear(gir.queue)
+}
+
+func (gir *GhostInfoRefresher) takeBatch() (map[*UserLogin][]*Ghost, bool) {
+	gir.lock.Lock()
+	defer gir.lock.Unlock()
+	batch := make(map[*UserLogin][]*Ghost)
+	count := 0
+	for ghostID, item := range gir.queue {
+		if count >= ghostRefreshBatchSize {
+			break
+		}
+		delete(gir.queue, ghostID)
+		batch[item.source] = append(batch[item.source], item.ghost)
+		count++
+	}
+	return batch, len(gir.queue) > 0
+}
+
+func (gir *GhostInfoRefresher) refreshBatch(ctx context.Context) (hasMore bool) {
+	var batch map[*UserLogin][]*Ghost
+	batch, hasMore = gir.takeBatch()
+	for source, ghosts := range batch {
+		if ctx.Err() != nil {
+			return false
+		} else if !source.Client.IsLoggedIn() {
+			continue
+		}
+		loginCtx := zerolog.Ctx(ctx).With().Str("login_id", string(source.ID)).Logger().WithContext(ctx)
+		if bulkAPI, ok := source.Client.(BulkUserInfoFetchingNetworkAPI); ok {
+			gir.refreshBulk(loginCtx, bulkAPI, ghosts)
+		} else {
+			gir.refreshSingle(loginCtx, source, ghosts)
+		}
+	}
+	return hasMore && ctx.Err() == nil
+}
+
+func (gir *GhostInfoRefresher) refreshBulk(ctx context.Context, api BulkUserInfoFetchingNetworkAPI, ghosts []*Ghost) {
+	infos, err := api.GetUserInfoBulk(ctx, ghosts)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Int("ghost_count", len(ghosts)).Msg("Failed to get info to refresh ghosts")
+		return
+	}
+	for _, ghost := range ghosts {
+		if info, ok := infos[ghost.ID]; ok && info != nil {
+			ghost.updateInfo(ctx, info, true)
+		}
+	}
+	zerolog.Ctx(ctx).Debug().
+		Int("ghost_count", len(ghosts)).
+		Int("info_count", len(infos)).
+		Msg("Refreshed stale ghost info")
+}
+
+func (gir *GhostInfoRefresher) refreshSingle(ctx context.Context, source *UserLogin, ghosts []*Ghost) {
+	for i, ghost := range ghosts {
+		if i > 0 {
+			select {
+			case <-time.After(ghostRefreshSingleDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		info, err := source.Client.GetUserInfo(ctx, ghost)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("ghost_id", string(ghost.ID)).Msg("Failed to get info to refresh ghost")
+		} else if info != nil {
+			ghost.updateInfo(ctx, info, true)
+		}
+	}
+	zerolog.Ctx(ctx).Debug().Int("ghost_count", len(ghosts)).Msg("Refreshed stale ghost info")
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+type testTTLNetwork struct {
+	testNetwork
+}
+
+func (tn *testTTLNetwork) GetCapabilities() *NetworkGeneralCapabilities {
+	return &NetworkGeneralCapabilities{UserInfoTTL: time.Hour}
+}
+
+type testBulkNetworkAPI struct {
+	testNetworkAPI
+
+	fetchLock sync.Mutex
+	fetched   [][]networkid.UserID
+}
+
+var _ BulkUserInfoFetchingNetworkAPI = (*testBulkNetworkAPI)(nil)
+
+func (tna *testBulkNetworkAPI) GetUserInfoBulk(ctx context.Context, ghosts []*Ghost) (map[networkid.UserID]*UserInfo, error) {
+	ids := make([]networkid.UserID, len(ghosts))
+	for i, ghost := range ghosts {
+		ids[i] = ghost.ID
+	}
+	tna.fetchLock.Lock()
+	tna.fetched = append(tna.fetched, ids)
+	tna.fetchLock.Unlock()
+	return nil, nil
+}
+
+func newTestGhost(br *Bridge, ghostID networkid.UserID, refreshedAt time.Time) *Ghost {
+	return &Ghost{
+		Ghost:  &database.Ghost{BridgeID: br.ID, ID: ghostID, InfoRefreshedAt: refreshedAt},
+		Bridge: br,
+	}
+}
+
+func newTestGhostRefresher(t *testing.T) (*Bridge, *UserLogin, *testBulkNetworkAPI) {
+	br, login, _ := newTestBridgeWithLogin(t)
+	br.Network = &testTTLNetwork{}
+	br.GhostRefresher = newGhostInfoRefresher(br)
+	client := &testBulkNetworkAPI{}
+	login.Client = client
+	return br, login, client
+}
+
+func TestGhostInfoRefresher_IsStale(t *testing.T) {
+	br, _, _ := newTestGhostRefresher(t)
+	assert.True(t, br.GhostRefresher.IsStale(newTestGhost(br, "old", time.Now().Add(-2*time.Hour))))
+	assert.True(t, br.GhostRefresher.IsStale(newTestGhost(br, "never", time.Time{})))
+	assert.False(t, br.GhostRefresher.IsStale(newTestGhost(br, "fresh", time.Now())))
+
+	br.Network = &testNetwork{}
+	assert.False(t, br.GhostRefresher.IsStale(newTestGhost(br, "no-ttl", time.Time{})), "info shouldn't be stale without a TTL")
+}
+
+func TestGhostInfoRefresher_QueueIfStale(t *testing.T) {
+	br, login, _ := newTestGhostRefresher(t)
+	gir := br.GhostRefresher
+	stale := newTestGhost(br, "stale", time.Time{})
+
+	gir.QueueIfStale(stale, login)
+	assert.Empty(t, gir.queue, "ghosts shouldn't be queued when the refresher isn't running")
+
+	gir.Start()
+	defer gir.Stop()
+	gir.QueueIfStale(stale, login)
+	gir.QueueIfStale(stale, login)
+	gir.QueueIfStale(newTestGhost(br, "fresh", time.Now()), login)
+	gir.QueueIfStale(newTestGhost(br, "no-source", time.Time{}), nil)
+	gir.lock.Lock()
+	assert.Len(t, gir.queue, 1)
+	assert.Contains(t, gir.queue, networkid.UserID("stale"))
+	gir.lock.Unlock()
+
+	gir.Stop()
+	assert.Empty(t, gir.queue, "stopping should clear the queue")
+	gir.QueueIfStale(stale, login)
+	assert.Empty(t, gir.queue, "ghosts shouldn't be queued after stopping")
+}
+
+func TestGhostInfoRefresher_StartStop(t *testing.T) {
+	br, _, _ := newTestGhostRefresher(t)
+	gir := br.GhostRefresher
+	// Start must set up the stop function before returning, so stopping immediately can't race with it
+	gir.Start()
+	gir.Stop()
+	assert.False(t, gir.running)
+	assert.NotNil(t, gir.stop)
+}
+
+func TestGhostInfoRefresher_RefreshBatch(t *testing.T) {
+	ctx := context.Background()
+	br, login, client := newTestGhostRefresher(t)
+	gir := br.GhostRefresher
+	gir.Start()
+	defer gir.Stop()
+	for i := 0; i < ghostRefreshBatchSize+10; i++ {
+		gir.QueueIfStale(newTestGhost(br, networkid.UserID(fmt.Sprintf("ghost%d", i)), time.Time{}), login)
+	}
+
+	require.True(t, gir.refreshBatch(ctx), "there should be more ghosts after the first batch")
+	require.False(t, gir.refreshBatch(ctx))
+	require.Len(t, client.fetched, 2)
+	assert.Len(t, client.fetched[0], ghostRefreshBatchSize)
+	assert.Len(t, client.fetched[1], 10)
+	assert.Empty(t, gir.queue)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+)
+
+const DefaultInstanceLockTTL = 1 * time.Minute
+
+// InstanceLock makes sure only one bridge instance sharing a database is active at a time.
+//
+// When multi-instance mode is enabled, [Bridge.StartConnectors] waits until the lock is free before starting
+// anything, which means additional instances act as hot standbys: when the active instance is stopped, it releases
+// the lock and a standby takes over immediately. If the active instance crashes, the lock expires after the TTL.
+// This allows zero-downtime deploys, but doesn't spread the load over multiple instances.
+//
+// If the lock can't be renewed, the instance steps down once two thirds of the TTL have passed since the last
+// successful renewal, which leaves a safety margin before the lock expires and another instance can take over.
+type InstanceLock struct {
+	br         *Bridge
+	InstanceID string
+	// OnLost is called when this instance steps down because the lock couldn't be renewed.
+	// The bridge should stop as soon as possible when this happens. If OnLost is nil, [Bridge.Stop] is called.
+	OnLost func()
+
+	held atomic.Bool
+	stop context.CancelFunc
+}
+
+func newInstanceLock(br *Bridge) *InstanceLock {
+	instanceID := br.Config.MultiInstance.InstanceID
+	if instanceID == "" {
+		instanceID = random.String(16)
+	}
+	return &InstanceLock{br: br, InstanceID: instanceID}
+}
+
+func (il *InstanceLock) IsEnabled() bool {
+	return il.br.Config.MultiInstance.Enabled
+}
+
+func (il *InstanceLock) TTL() time.Duration {
+	if ttl := il.br.Config.MultiInstance.LockTTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return DefaultInstanceLockTTL
+}
+
+// Acquire waits until this instance holds the lock. If multi-instance mode is disabled, this is a no-op.
+func (il *InstanceLock) Acquire(ctx context.Context) error {
+	if !il.IsEnabled() {
+		return nil
+	}
+	log := zerolog.Ctx(ctx).With().Str("instance_id", il.InstanceID).Logger()
+	ttl := il.TTL()
+	loggedWaiting := false
+	for {
+		acquired, err := il.br.DB.InstanceLock.TryAcquire(ctx, il.InstanceID, time.Now().Add(ttl))
+		if err != nil {
+			return err
+		} else if acquired {
+			il.held.Store(true)
+			log.Info().Msg("Acquired instance lock")
+			return nil
+		} else if !loggedWaiting {
+			owner, _ := il.br.DB.InstanceLock.GetOwner(ctx)
+			log.Info().Str("lock_owner", owner).Msg("Bridge is locked by another instance, waiting for lock to be released")
+			loggedWaiting = true
+		}
+		select {
+		case <-time.After(ttl / 10):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Start starts renewing the lock in the background.
+func (il *InstanceLock) Start() {
+	if !il.held.Load() {
+		return
+	}
+	log := il.br.Log.With().Str("component", "instance lock").Str("instance_id", il.InstanceID).Logger()
+	ctx := log.WithContext(context.Background())
+	ctx, il.stop = context.WithCancel(ctx)
+	go il.renewLoop(ctx)
+}
+
+// IsHeld returns true if this instance currently holds the lock.
+// If multi-instance mode is disabled, this always returns false.
+func (il *InstanceLock) IsHeld() bool {
+	return il.held.Load()
+}
+
+func (il *InstanceLock) renewLoop(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	ttl := il.TTL()
+	stepDownAfter := ttl * 2 / 3
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	stepDownTimer := time.NewTimer(stepDownAfter)
+	defer stepDownTimer.Stop()
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stepDownTimer.C:
+			log.Error().Time("last_renewed", lastRenewed).Msg("Failed to renew instance lock in time, stepping down")
+			il.stepDown()
+			return
+		case <-ctx.Done():
+			return
+		}
+		renewStarted := time.Now()
+		renewCtx, cancel := context.WithDeadline(ctx, lastRenewed.Add(stepDownAfter))
+		acquired, err := il.br.DB.InstanceLock.TryAcquire(renewCtx, il.InstanceID, renewStarted.Add(ttl))
+		cancel()
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			log.Err(err).Msg("Failed to renew instance lock")
+		} else if acquired {
+			lastRenewed = renewStarted
+			if !stepDownTimer.Stop() {
+				select {
+				case <-stepDownTimer.C:
+				default:
+				}
+			}
+			stepDownTimer.Reset(time.Until(lastRenewed.Add(stepDownAfter)))
+		} else {
+			log.Error().Msg("Instance lock was taken by another instance, stepping down")
+			il.stepDown()
+			return
+		}
+	}
+}
+
+func (il *InstanceLock) stepDown() {
+	il.held.Store(false)
+	if il.OnLost != nil {
+		il.OnLost()
+	} else {
+		go il.br.Stop()
+	}
+}
+
+// Stop stops renewing the lock and releases it, so that a standby instance can take over immediately.
+func (il *InstanceLock) Stop() {
+	if il.stop != nil {
+		il.stop()
+	}
+	if !il.held.Swap(false) {
+		return
+	}
+	err := il.br.DB.InstanceLock.Release(il.br.Log.WithContext(context.Background()), il.InstanceID)
+	if err != nil {
+		il.br.Log.Err(err).Msg("Failed to release instance lock")
+	}
+}
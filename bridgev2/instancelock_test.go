@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInstanceLocks(t *testing.T) (*Bridge, *InstanceLock, *InstanceLock) {
+	t.Helper()
+	br := newTestBridge(t)
+	br.Config.MultiInstance.Enabled = true
+	br.Config.MultiInstance.LockTTL = 1
+	return br, &InstanceLock{br: br, InstanceID: "first"}, &InstanceLock{br: br, InstanceID: "second"}
+}
+
+func startTestInstanceLock(t *testing.T, il *InstanceLock) <-chan time.Time {
+	t.Helper()
+	lost := make(chan time.Time, 1)
+	il.OnLost = func() {
+		lost <- time.Now()
+	}
+	require.NoError(t, il.Acquire(context.Background()))
+	il.Start()
+	t.Cleanup(il.Stop)
+	return lost
+}
+
+func TestInstanceLock_Standby(t *testing.T) {
+	ctx := context.Background()
+	br, first, second := newTestInstanceLocks(t)
+	startTestInstanceLock(t, first)
+	assert.True(t, first.IsHeld())
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, second.Acquire(waitCtx), context.DeadlineExceeded)
+	assert.False(t, second.IsHeld())
+	owner, err := br.DB.InstanceLock.GetOwner(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "first", owner)
+
+	first.Stop()
+	assert.False(t, first.IsHeld())
+	require.NoError(t, second.Acquire(ctx))
+	assert.True(t, second.IsHeld())
+	second.Stop()
+}
+
+func TestInstanceLock_Disabled(t *testing.T) {
+	br := newTestBridge(t)
+	il := &InstanceLock{br: br, InstanceID: "first"}
+	require.NoError(t, il.Acquire(context.Background()))
+	assert.False(t, il.IsHeld())
+	owner, err := br.DB.InstanceLock.GetOwner(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, owner)
+}
+
+func TestInstanceLock_StepDownOnRenewalFailure(t *testing.T) {
+	br, first, _ := newTestInstanceLocks(t)
+	started := time.Now()
+	lost := startTestInstanceLock(t, first)
+	_, err := br.DB.Exec(context.Background(), "DROP TABLE instance_lock")
+	require.NoError(t, err)
+
+	select {
+	case lostAt := <-lost:
+		assert.Less(t, lostAt.Sub(started), first.TTL(), "instance should step down before the lock expires")
+	case <-time.After(5 * time.Second):
+		t.Fatal("instance didn't step down after failing to renew lock")
+	}
+	assert.False(t, first.IsHeld())
+}
+
+func TestInstanceLock_StepDownWhenTaken(t *testing.T) {
+	br, first, _ := newTestInstanceLocks(t)
+	lost := startTestInstanceLock(t, first)
+	_, err := br.DB.Exec(context.Background(), "UPDATE instance_lock SET instance_id='second'")
+	require.NoError(t, err)
+
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("instance didn't step down after lock was taken")
+	}
+	assert.False(t, first.IsHeld())
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	DefaultLiveLocationUpdateInterval = 10 * time.Second
+	DefaultLiveLocationTimeout        = 1 * time.Hour
+)
+
+// LiveLocationManager keeps track of live location shares (MSC3672 beacons) that are being bridged.
+//
+// Remote shares are converted into beacon_info state events and beacon message events sent by the ghost
+// of the sharer. Matrix shares are passed to network connectors implementing [LiveLocationHandlingNetworkAPI].
+// In both directions, location updates are throttled and shares are stopped automatically when they expire.
+//
+// Shares are only tracked in memory. If the bridge is restarted, the next update of a remote share will
+// replace the previous beacon_info event, while updates to existing Matrix shares will be rejected.
+type LiveLocationManager struct {
+	br     *Bridge
+	lock   sync.Mutex
+	remote map[remoteLiveLocationKey]*remoteLiveLocation
+	matrix map[id.EventID]*matrixLiveLocation
+}
+
+func newLiveLocationManager(br *Bridge) *LiveLocationManager {
+	return &LiveLocationManager{
+		br:     br,
+		remote: make(map[remoteLiveLocationKey]*remoteLiveLocation),
+		matrix: make(map[id.EventID]*matrixLiveLocation),
+	}
+}
+
+// Stop cancels all pending updates and expiry timers without sending anything.
+func (llm *LiveLocationManager) Stop() {
+	llm.lock.Lock()
+	defer llm.lock.Unlock()
+	for key, share := range llm.remote {
+		share.stop()
+		delete(llm.remote, key)
+	}
+	for key, share := range llm.matrix {
+		share.stop()
+		delete(llm.matrix, key)
+	}
+}
+
+func (llm *LiveLocationManager) updateInterval() time.Duration {
+	interval := llm.br.Network.GetCapabilities().LiveLocationUpdateInterval
+	if interval <= 0 {
+		return DefaultLiveLocationUpdateInterval
+	}
+	return interval
+}
+
+type liveLocationShare struct {
+	lock sync.Mutex
+	// sendLock is held while sending updates to keep them in order without blocking new updates.
+	sendLock    sync.Mutex
+	lastSent    time.Time
+	pending     func()
+	flushTimer  *time.Timer
+	expiryTimer *time.Timer
+	stopped     bool
+}
+
+// throttle calls send immediately if the update interval has passed since the previous update.
+// Otherwise, send is scheduled to be called when the interval passes, replacing any previously scheduled update.
+func (share *liveLocationShare) throttle(interval time.Duration, send func()) {
+	share.lock.Lock()
+	if share.stopped {
+		share.lock.Unlock()
+		return
+	}
+	share.pending = send
+	if share.flushTimer != nil {
+		share.lock.Unlock()
+		return
+	}
+	if wait := interval - time.Since(share.lastSent); wait > 0 {
+		share.flushTimer = time.AfterFunc(wait, share.flush)
+		share.lock.Unlock()
+		return
+	}
+	send = share.takePendingLocked()
+	share.sendLock.Lock()
+	share.lock.Unlock()
+	defer share.sendLock.Unlock()
+	send()
+}
+
+func (share *liveLocationShare) flush() {
+	share.lock.Lock()
+	share.flushTimer = nil
+	if share.stopped || share.pending == nil {
+		share.lock.Unlock()
+		return
+	}
+	send := share.takePendingLocked()
+	share.sendLock.Lock()
+	share.lock.Unlock()
+	defer share.sendLock.Unlock()
+	send()
+}
+
+func (share *liveLocationShare) takePendingLocked() func() {
+	send := share.pending
+	share.pending = nil
+	share.lastSent = time.Now()
+	return send
+}
+
+// stop marks the share as stopped and cancels timers. It returns false if the share was already stopped.
+func (share *liveLocationShare) stop() bool {
+	share.lock.Lock()
+	defer share.lock.Unlock()
+	if share.stopped {
+		return false
+	}
+	share.stopped = true
+	share.pending = nil
+	if share.flushTimer != nil {
+		share.flushTimer.Stop()
+		share.flushTimer = nil
+	}
+	if share.expiryTimer != nil {
+		share.expiryTimer.Stop()
+	}
+	return true
+}
+
+type remoteLiveLocationKey struct {
+	RoomID   id.RoomID
+	StateKey string
+}
+
+type remoteLiveLocation struct {
+	liveLocationShare
+	key     remoteLiveLocationKey
+	shareID string
+	intent  MatrixAPI
+	eventID id.EventID
+	info    *event.BeaconInfoEventContent
+}
+
+func (portal *Portal) handleRemoteLiveLocation(ctx context.Context, source *UserLogin, evt RemoteLiveLocation) {
+	update := evt.GetLiveLocation()
+	if update == nil {
+		return
+	}
+	intent := portal.GetIntentFor(ctx, evt.GetSender(), source, RemoteEventLiveLocation)
+	portal.Bridge.LiveLocations.handleRemote(ctx, portal, intent, evt.GetLiveLocationID(), update)
+}
+
+func (llm *LiveLocationManager) handleRemote(ctx context.Context, portal *Portal, intent MatrixAPI, shareID string, update *LiveLocationUpdate) {
+	// Only one beacon_info state event can exist per ghost, so the state key is always the ghost's MXID.
+	key := remoteLiveLocationKey{
+		RoomID:   portal.MXID,
+		StateKey: intent.GetMXID().String(),
+	}
+	log := zerolog.Ctx(ctx).With().Str("beacon_state_key", key.StateKey).Str("live_location_id", shareID).Logger()
+	ctx = log.WithContext(context.WithoutCancel(ctx))
+	llm.lock.Lock()
+	share := llm.remote[key]
+	if share != nil && share.shareID != shareID {
+		if update.Stop {
+			llm.lock.Unlock()
+			log.Debug().Str("current_live_location_id", share.shareID).Msg("Ignoring stop of live location share that was already replaced")
+			return
+		}
+		// A new share from the same ghost replaces the previous one, startRemote will stop the old share.
+		share = nil
+	}
+	if update.Stop && share != nil {
+		delete(llm.remote, key)
+	}
+	llm.lock.Unlock()
+	if update.Stop {
+		if share != nil {
+			llm.stopRemote(ctx, share, update.Timestamp)
+		}
+		return
+	}
+	if share == nil {
+		var err error
+		share, err = llm.startRemote(ctx, key, shareID, intent, update)
+		if err != nil {
+			log.Err(err).Msg("Failed to start live location share")
+			return
+		}
+		log.Debug().Stringer("beacon_info_event_id", share.eventID).Msg("Started bridging remote live location share")
+	}
+	if update.GeoURI != "" {
+		share.throttle(llm.updateInterval(), func() {
+			llm.sendRemoteUpdate(ctx, share, update)
+		})
+	}
+}
+
+func (llm *LiveLocationManager) startRemote(ctx context.Context, key remoteLiveLocationKey, shareID string, intent MatrixAPI, update *LiveLocationUpdate) (*remoteLiveLocation, error) {
+	timeout := update.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLiveLocationTimeout
+	}
+	info := event.NewBeaconInfo(update.Description, timeout)
+	if !update.Timestamp.IsZero() {
+		info.Timestamp = jsontime.UM(update.Timestamp)
+	}
+	resp, err := intent.SendState(ctx, key.RoomID, event.StateUnstableBeaconInfo, key.StateKey, &event.Content{Parsed: info}, update.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	share := &remoteLiveLocation{
+		key:     key,
+		shareID: shareID,
+		intent:  intent,
+		eventID: resp.EventID,
+		info:    info,
+	}
+	share.expiryTimer = time.AfterFunc(time.Until(info.ExpiresAt(time.Now())), func() {
+		llm.lock.Lock()
+		if llm.remote[key] == share {
+			delete(llm.remote, key)
+		}
+		llm.lock.Unlock()
+		zerolog.Ctx(ctx).Debug().Msg("Remote live location share expired")
+		llm.stopRemote(ctx, share, time.Time{})
+	})
+	llm.lock.Lock()
+	if prev := llm.remote[key]; prev != nil {
+		prev.stop()
+	}
+	llm.remote[key] = share
+	llm.lock.Unlock()
+	return share, nil
+}
+
+func (llm *LiveLocationManager) sendRemoteUpdate(ctx context.Context, share *remoteLiveLocation, update *LiveLocationUpdate) {
+	ts := update.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	content := event.NewBeacon(share.eventID, update.GeoURI, ts)
+	content.Location.Description = update.Description
+	_, err := share.intent.SendMessage(ctx, share.key.RoomID, event.EventUnstableBeacon, &event.Content{Parsed: content}, &MatrixSendExtra{
+		Timestamp: ts,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send live location update")
+	}
+}
+
+func (llm *LiveLocationManager) stopRemote(ctx context.Context, share *remoteLiveLocation, ts time.Time) {
+	if !share.stop() {
+		return
+	}
+	_, err := share.intent.SendState(ctx, share.key.RoomID, event.StateUnstableBeaconInfo, share.key.StateKey, &event.Content{
+		Parsed: share.info.Stop(),
+	}, ts)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to stop live location share")
+	} else {
+		zerolog.Ctx(ctx).Debug().Msg("Stopped bridging remote live location share")
+	}
+}
+
+type matrixLiveLocation struct {
+	liveLocationShare
+	roomID   id.RoomID
+	stateKey string
+	sender   id.UserID
+	info     *event.BeaconInfoEventContent
+}
+
+func (portal *Portal) handleMatrixLiveLocationShare(ctx context.Context, sender *UserLogin, origSender *OrigSender, evt *event.Event) {
+	log := zerolog.Ctx(ctx)
+	content, ok := evt.Content.Parsed.(*event.BeaconInfoEventContent)
+	if !ok {
+		log.Error().Type("content_type", evt.Content.Parsed).Msg("Unexpected parsed content type")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("%w: %T", ErrUnexpectedParsedContentType, evt.Content.Parsed))
+		return
+	}
+	if origSender != nil {
+		log.Debug().Msg("Ignoring live location share from relayed user")
+		portal.sendErrorStatus(ctx, evt, ErrIgnoringBeaconFromRelayedUser)
+		return
+	}
+	stateKey := evt.GetStateKey()
+	if stateKey != evt.Sender.String() && !strings.HasPrefix(stateKey, evt.Sender.String()+"_") {
+		log.Debug().Str("state_key", stateKey).Msg("Ignoring live location share with state key not owned by sender")
+		return
+	}
+	api, ok := sender.Client.(LiveLocationHandlingNetworkAPI)
+	if !ok {
+		portal.sendErrorStatus(ctx, evt, ErrLiveLocationNotSupported)
+		return
+	}
+	var prevContent *event.BeaconInfoEventContent
+	if evt.Unsigned.PrevContent != nil {
+		_ = evt.Unsigned.PrevContent.ParseRaw(evt.Type)
+		prevContent, _ = evt.Unsigned.PrevContent.Parsed.(*event.BeaconInfoEventContent)
+	}
+	err := api.HandleMatrixLiveLocationShare(ctx, &MatrixLiveLocationShare{
+		MatrixEventBase: MatrixEventBase[*event.BeaconInfoEventContent]{
+			Event:   evt,
+			Content: content,
+			Portal:  portal,
+		},
+		PrevContent: prevContent,
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to handle Matrix live location share")
+		portal.sendErrorStatus(ctx, evt, err)
+		return
+	}
+	portal.Bridge.LiveLocations.trackMatrix(ctx, api, portal, evt, content)
+	portal.sendSuccessStatus(ctx, evt, 0, "")
+}
+
+func (llm *LiveLocationManager) trackMatrix(ctx context.Context, api LiveLocationHandlingNetworkAPI, portal *Portal, evt *event.Event, content *event.BeaconInfoEventContent) {
+	stateKey := evt.GetStateKey()
+	llm.lock.Lock()
+	defer llm.lock.Unlock()
+	// The new state event replaces any previous share with the same state key
+	for eventID, share := range llm.matrix {
+		if share.roomID == evt.RoomID && share.stateKey == stateKey {
+			share.stop()
+			delete(llm.matrix, eventID)
+		}
+	}
+	sentAt := time.UnixMilli(evt.Timestamp)
+	if !content.IsLive(time.Now(), sentAt) {
+		return
+	}
+	share := &matrixLiveLocation{
+		roomID:   evt.RoomID,
+		stateKey: stateKey,
+		sender:   evt.Sender,
+		info:     content,
+	}
+	ctx = context.WithoutCancel(ctx)
+	share.expiryTimer = time.AfterFunc(time.Until(content.ExpiresAt(sentAt)), func() {
+		llm.lock.Lock()
+		if llm.matrix[evt.ID] == share {
+			delete(llm.matrix, evt.ID)
+		}
+		llm.lock.Unlock()
+		if !share.stop() {
+			return
+		}
+		zerolog.Ctx(ctx).Debug().Msg("Matrix live location share expired")
+		err := api.HandleMatrixLiveLocationShare(ctx, &MatrixLiveLocationShare{
+			MatrixEventBase: MatrixEventBase[*event.BeaconInfoEventContent]{
+				Event:   evt,
+				Content: content.Stop(),
+				Portal:  portal,
+			},
+			PrevContent: content,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to stop expired Matrix live location share")
+		}
+	})
+	llm.matrix[evt.ID] = share
+}
+
+func (portal *Portal) handleMatrixLiveLocationUpdate(ctx context.Context, sender *UserLogin, origSender *OrigSender, evt *event.Event) {
+	log := zerolog.Ctx(ctx)
+	content, ok := evt.Content.Parsed.(*event.BeaconEventContent)
+	if !ok {
+		log.Error().Type("content_type", evt.Content.Parsed).Msg("Unexpected parsed content type")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("%w: %T", ErrUnexpectedParsedContentType, evt.Content.Parsed))
+		return
+	}
+	if origSender != nil {
+		log.Debug().Msg("Ignoring live location update from relayed user")
+		portal.sendErrorStatus(ctx, evt, ErrIgnoringBeaconFromRelayedUser)
+		return
+	}
+	api, ok := sender.Client.(LiveLocationHandlingNetworkAPI)
+	if !ok {
+		portal.sendErrorStatus(ctx, evt, ErrLiveLocationNotSupported)
+		return
+	}
+	shareEventID := content.RelatesTo.GetReferenceID()
+	llm := portal.Bridge.LiveLocations
+	llm.lock.Lock()
+	share := llm.matrix[shareEventID]
+	llm.lock.Unlock()
+	if share == nil || share.roomID != evt.RoomID || share.sender != evt.Sender {
+		log.Debug().Stringer("beacon_info_event_id", shareEventID).Msg("Live location share not found")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("live location share %w", ErrTargetMessageNotFound))
+		return
+	}
+	msg := &MatrixLiveLocationUpdate{
+		MatrixEventBase: MatrixEventBase[*event.BeaconEventContent]{
+			Event:   evt,
+			Content: content,
+			Portal:  portal,
+		},
+		ShareEventID: shareEventID,
+		Share:        share.info,
+	}
+	ctx = context.WithoutCancel(ctx)
+	// Throttled updates may be sent later, so errors can only be logged
+	share.throttle(llm.updateInterval(), func() {
+		err := api.HandleMatrixLiveLocationUpdate(ctx, msg)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to handle Matrix live location update")
+		}
+	})
+	portal.sendSuccessStatus(ctx, evt, 0, "")
+}
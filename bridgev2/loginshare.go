@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrCantShareLoginWithOwner = errors.New("can't share login with its owner")
+
+// Share allows the given user to use this login in portals where the login is present.
+//
+// Messages sent by other users through a shared login are treated like relayed messages,
+// i.e. they're prefixed with the sender's name using the relay message formats.
+func (ul *UserLogin) Share(ctx context.Context, user *User) error {
+	if user.MXID == ul.UserMXID {
+		return ErrCantShareLoginWithOwner
+	}
+	return ul.Bridge.DB.UserLoginShare.Add(ctx, ul.ID, user.MXID)
+}
+
+// Unshare removes the given user's access to this login. The returned boolean is false if the login wasn't shared with the user.
+func (ul *UserLogin) Unshare(ctx context.Context, userID id.UserID) (bool, error) {
+	return ul.Bridge.DB.UserLoginShare.Remove(ctx, ul.ID, userID)
+}
+
+// GetSharedWith returns the users this login has been shared with.
+func (ul *UserLogin) GetSharedWith(ctx context.Context) ([]id.UserID, error) {
+	return ul.Bridge.DB.UserLoginShare.GetUsers(ctx, ul.ID)
+}
+
+// GetSharedLogins returns the logins of other users that have been shared with this user.
+func (user *User) GetSharedLogins(ctx context.Context) ([]*UserLogin, error) {
+	loginIDs, err := user.Bridge.DB.UserLoginShare.GetLogins(ctx, user.MXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logins shared with user: %w", err)
+	}
+	logins := make([]*UserLogin, 0, len(loginIDs))
+	for _, loginID := range loginIDs {
+		login, err := user.Bridge.GetExistingUserLoginByID(ctx, loginID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get shared login %s: %w", loginID, err)
+		} else if login != nil {
+			logins = append(logins, login)
+		}
+	}
+	return logins, nil
+}
+
+// findSharedLogin finds a login that has been shared with the given user and is present in this portal.
+// The bridge cache lock must not be held when calling this.
+func (portal *Portal) findSharedLogin(ctx context.Context, user *User) (*UserLogin, *database.UserPortal, error) {
+	logins, err := user.GetSharedLogins(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, login := range logins {
+		if login.Client == nil || !login.Client.IsLoggedIn() {
+			continue
+		} else if portal.Receiver != "" && portal.Receiver != login.ID {
+			continue
+		}
+		up, err := portal.Bridge.DB.UserPortal.Get(ctx, login.UserLogin, portal.PortalKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check if shared login %s is in portal: %w", login.ID, err)
+		} else if up != nil || portal.Receiver == login.ID {
+			zerolog.Ctx(ctx).Debug().
+				Str("shared_login_id", string(login.ID)).
+				Stringer("login_owner_mxid", login.UserMXID).
+				Msg("Using login shared by another user")
+			return login, up, nil
+		}
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUserLogin_Share(t *testing.T) {
+	ctx := context.Background()
+	br, login, _ := newTestBridgeWithLogin(t)
+	bob, err := br.GetUserByMXID(ctx, "@bob:example.com")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, login.Share(ctx, login.User), ErrCantShareLoginWithOwner)
+	require.NoError(t, login.Share(ctx, bob))
+	sharedWith, err := login.GetSharedWith(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@bob:example.com"}, sharedWith)
+	sharedLogins, err := bob.GetSharedLogins(ctx)
+	require.NoError(t, err)
+	require.Len(t, sharedLogins, 1)
+	assert.Same(t, login, sharedLogins[0])
+
+	removed, err := login.Unshare(ctx, bob.MXID)
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = login.Unshare(ctx, bob.MXID)
+	require.NoError(t, err)
+	assert.False(t, removed)
+	sharedLogins, err = bob.GetSharedLogins(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, sharedLogins)
+}
+
+func TestPortal_FindPreferredLogin_Shared(t *testing.T) {
+	ctx := context.Background()
+	br, login, _ := newTestBridgeWithLogin(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	otherPortal := newTestPortal(t, br, networkid.PortalKey{ID: "other"}, "!other:example.com")
+	require.NoError(t, br.DB.UserPortal.Put(ctx, database.UserPortalFor(login.UserLogin, portal.PortalKey)))
+	bob, err := br.GetUserByMXID(ctx, "@bob:example.com")
+	require.NoError(t, err)
+
+	_, _, err = portal.FindPreferredLogin(ctx, bob, true)
+	assert.ErrorIs(t, err, ErrNotLoggedIn, "login shouldn't be usable before sharing")
+
+	require.NoError(t, login.Share(ctx, bob))
+	found, up, err := portal.FindPreferredLogin(ctx, bob, true)
+	require.NoError(t, err)
+	assert.Same(t, login, found)
+	assert.NotNil(t, up)
+
+	_, _, err = portal.FindPreferredLogin(ctx, bob, false)
+	assert.ErrorIs(t, err, ErrNotLoggedIn, "shared logins should only be used when relaying is allowed")
+	_, _, err = otherPortal.FindPreferredLogin(ctx, bob, true)
+	assert.ErrorIs(t, err, ErrNotLoggedIn, "shared logins should only be used in portals where the login is present")
+
+	found, _, err = portal.FindPreferredLogin(ctx, login.User, true)
+	require.NoError(t, err)
+	assert.Same(t, login, found)
+}
+
+func TestPortal_HandleMatrixEvent_SharedLoginAccountData(t *testing.T) {
+	ctx := context.Background()
+	br, login, client := newTestBridgeWithLogin(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	require.NoError(t, br.DB.UserPortal.Put(ctx, database.UserPortalFor(login.UserLogin, portal.PortalKey)))
+	bob, err := br.GetUserByMXID(ctx, "@bob:example.com")
+	require.NoError(t, err)
+	require.NoError(t, login.Share(ctx, bob))
+
+	makeEvt := func(sender *User) *event.Event {
+		return &event.Event{
+			Type:    event.AccountDataMarkedUnread,
+			RoomID:  portal.MXID,
+			Sender:  sender.MXID,
+			Content: event.Content{Parsed: &event.MarkedUnreadEventContent{Unread: true}},
+		}
+	}
+	portal.handleMatrixEvent(ctx, bob, makeEvt(bob))
+	assert.Empty(t, client.markedUnread, "account data of other users must not be applied to a shared login")
+	portal.handleMatrixEvent(ctx, login.User, makeEvt(login.User))
+	assert.Len(t, client.markedUnread, 1)
+}
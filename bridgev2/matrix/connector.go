@@ -15,7 +15,6 @@ import (
 	"fmt"
 	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -59,6 +58,16 @@ type Crypto interface {
 	ShareKeys(context.Context) error
 }
 
+// CryptoWithKeyExport is an optional interface for [Crypto] implementations that can export Megolm sessions.
+type CryptoWithKeyExport interface {
+	ExportRoomKeys(ctx context.Context, roomIDs []id.RoomID, since time.Time, passphrase string) ([]byte, int, error)
+}
+
+// CryptoWithKeyImport is an optional interface for [Crypto] implementations that can import Megolm sessions.
+type CryptoWithKeyImport interface {
+	ImportKeys(ctx context.Context, passphrase string, data []byte) (int, int, error)
+}
+
 type Connector struct {
 	AS           *appservice.AppService
 	Bot          *appservice.IntentAPI
@@ -86,7 +95,7 @@ type Connector struct {
 
 	EventProcessor *appservice.EventProcessor
 
-	userIDRegex *regexp.Regexp
+	ghostLocalparts *id.LocalpartMapper
 
 	Websocket                      bool
 	wsStopPinger                   chan struct{}
@@ -107,12 +116,24 @@ var (
 	_ bridgev2.MatrixConnectorWithNameDisambiguation     = (*Connector)(nil)
 	_ bridgev2.MatrixConnectorWithURLPreviews            = (*Connector)(nil)
 	_ bridgev2.MatrixConnectorWithAnalytics              = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithPinnedEvents           = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithEncryptionState        = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithEventLookup            = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithKeyExport              = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithKeyImport              = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithEventFetching          = (*Connector)(nil)
+	_ appservice.QueryHandler                            = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithBulkGhostSetup         = (*Connector)(nil)
 )
 
 func NewConnector(cfg *bridgeconfig.Config) *Connector {
 	c := &Connector{}
 	c.Config = cfg
-	c.userIDRegex = cfg.MakeUserIDRegex("(.+)")
+	c.ghostLocalparts = &id.LocalpartMapper{
+		Homeserver:     cfg.Homeserver.Domain,
+		ReservedLength: len(cfg.AppService.FormatUsername("")),
+		Store:          (*ghostLocalpartStore)(c),
+	}
 	c.MediaConfig.UploadSize = 50 * 1024 * 1024
 	c.uploadSema = semaphore.NewWeighted(c.MediaConfig.UploadSize + 1)
 	c.Capabilities = &bridgev2.MatrixCapabilities{}
@@ -127,6 +148,10 @@ func (br *Connector) Init(bridge *bridgev2.Bridge) {
 	br.AS = br.Config.MakeAppService()
 	br.AS.Log = bridge.Log
 	br.AS.StateStore = br.StateStore
+	br.AS.QueryHandler = br
+	if _, ok := bridge.Network.(bridgev2.ThirdPartyProtocolNetwork); ok {
+		br.AS.ThirdPartyHandler = br
+	}
 	br.EventProcessor = appservice.NewEventProcessor(br.AS)
 	if !br.Config.AppService.AsyncTransactions {
 		br.EventProcessor.ExecMode = appservice.Sync
@@ -146,6 +171,9 @@ func (br *Connector) Init(bridge *bridgev2.Bridge) {
 	br.EventProcessor.On(event.StateRoomName, br.handleRoomEvent)
 	br.EventProcessor.On(event.StateRoomAvatar, br.handleRoomEvent)
 	br.EventProcessor.On(event.StateTopic, br.handleRoomEvent)
+	br.EventProcessor.On(event.StateUnstableBeaconInfo, br.handleRoomEvent)
+	br.EventProcessor.On(event.EventUnstableBeacon, br.handleRoomEvent)
+	br.EventProcessor.On(event.StatePinnedEvents, br.handleRoomEvent)
 	br.EventProcessor.On(event.EphemeralEventReceipt, br.handleEphemeralEvent)
 	br.EventProcessor.On(event.EphemeralEventTyping, br.handleEphemeralEvent)
 	br.Bot = br.AS.BotIntent()
@@ -427,6 +455,20 @@ func (br *Connector) GhostIntent(userID networkid.UserID) bridgev2.MatrixAPI {
 	}
 }
 
+func (br *Connector) SetupGhosts(ctx context.Context, displaynames map[id.UserID]string, progress func()) map[id.UserID]error {
+	profiles := make([]appservice.GhostProfile, 0, len(displaynames))
+	for userID, displayname := range displaynames {
+		profiles = append(profiles, appservice.GhostProfile{UserID: userID, DisplayName: displayname})
+	}
+	return br.AS.SetupGhostProfiles(ctx, profiles, appservice.BulkProfileParams{
+		Progress: func(appservice.BulkProfileProgress) {
+			if progress != nil {
+				progress()
+			}
+		},
+	})
+}
+
 func (br *Connector) SendBridgeStatus(ctx context.Context, state *status.BridgeState) error {
 	if br.Websocket {
 		br.hasSentAnyStates = true
@@ -512,22 +554,59 @@ func (br *Connector) SendMessageCheckpoints(checkpoints []*status.MessageCheckpo
 }
 
 func (br *Connector) ParseGhostMXID(userID id.UserID) (networkid.UserID, bool) {
-	match := br.userIDRegex.FindStringSubmatch(string(userID))
-	if match == nil || userID == br.Bot.UserID {
+	localpart, homeserver, err := userID.Parse()
+	if err != nil || homeserver != br.Config.Homeserver.Domain || userID == br.Bot.UserID {
 		return "", false
 	}
-	decoded, err := id.DecodeUserLocalpart(match[1])
+	username, ok := br.Config.AppService.ParseUsername(localpart)
+	if !ok {
+		return "", false
+	}
+	decoded, err := br.ghostLocalparts.Decode(br.Log.WithContext(context.TODO()), username)
 	if err != nil {
 		return "", false
 	}
 	return networkid.UserID(decoded), true
 }
 
+func (br *Connector) QueryAlias(alias string) bool {
+	return false
+}
+
+// QueryUser handles user queries from the homeserver by registering ghost users on demand.
+// Whether the user ID is a valid ghost is determined from the username template alone, without touching the database.
+func (br *Connector) QueryUser(userID id.UserID) bool {
+	if _, isGhost := br.ParseGhostMXID(userID); !isGhost {
+		return false
+	}
+	err := br.AS.Intent(userID).EnsureRegistered(br.Log.WithContext(context.TODO()))
+	if err != nil {
+		br.Log.Err(err).Stringer("user_id", userID).Msg("Failed to register ghost for user query")
+		return false
+	}
+	return true
+}
+
 func (br *Connector) FormatGhostMXID(userID networkid.UserID) id.UserID {
-	localpart := br.Config.AppService.FormatUsername(id.EncodeUserLocalpart(string(userID)))
+	encoded, err := br.ghostLocalparts.Encode(br.Log.WithContext(context.TODO()), string(userID))
+	if err != nil {
+		br.Log.Warn().Err(err).Str("ghost_id", string(userID)).Msg("Failed to store ghost localpart mapping")
+	}
+	localpart := br.Config.AppService.FormatUsername(encoded)
 	return id.NewUserID(localpart, br.Config.Homeserver.Domain)
 }
 
+// ghostLocalpartStore stores the remote IDs of ghosts whose localparts had to be truncated in the kv_store table.
+type ghostLocalpartStore Connector
+
+func (store *ghostLocalpartStore) GetIdentifier(ctx context.Context, localpart string) (string, error) {
+	return store.Bridge.DB.KV.GetWithError(ctx, database.KeyGhostLocalpartPrefix+database.Key(localpart))
+}
+
+func (store *ghostLocalpartStore) SetIdentifier(ctx context.Context, localpart, identifier string) error {
+	return store.Bridge.DB.KV.SetWithError(ctx, database.KeyGhostLocalpartPrefix+database.Key(localpart), identifier)
+}
+
 func (br *Connector) NewUserIntent(ctx context.Context, userID id.UserID, accessToken string) (bridgev2.MatrixAPI, string, error) {
 	intent, newToken, err := br.DoublePuppet.Setup(ctx, userID, accessToken)
 	if err != nil {
@@ -571,6 +650,78 @@ func (br *Connector) GetMemberInfo(ctx context.Context, roomID id.RoomID, userID
 	return br.AS.StateStore.GetMember(ctx, roomID, userID)
 }
 
+func (br *Connector) GetPinnedEvents(ctx context.Context, roomID id.RoomID) ([]id.EventID, error) {
+	var content event.PinnedEventsEventContent
+	err := br.Bot.StateEvent(ctx, roomID, event.StatePinnedEvents, "", &content)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	}
+	return content.Pinned, err
+}
+
+func (br *Connector) IsRoomEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	return br.StateStore.IsEncrypted(ctx, roomID)
+}
+
+func (br *Connector) ShouldEncryptRooms() bool {
+	return br.Config.Encryption.Default
+}
+
+func (br *Connector) ExportRoomKeys(ctx context.Context, roomIDs []id.RoomID, since time.Time, passphrase string) ([]byte, int, error) {
+	if br.Crypto == nil {
+		return nil, 0, fmt.Errorf("encryption is not enabled")
+	}
+	exporter, ok := br.Crypto.(CryptoWithKeyExport)
+	if !ok {
+		return nil, 0, fmt.Errorf("crypto helper doesn't support exporting keys")
+	}
+	return exporter.ExportRoomKeys(ctx, roomIDs, since, passphrase)
+}
+
+func (br *Connector) ImportKeys(ctx context.Context, passphrase string, data []byte) (int, int, error) {
+	if br.Crypto == nil {
+		return 0, 0, fmt.Errorf("encryption is not enabled")
+	}
+	importer, ok := br.Crypto.(CryptoWithKeyImport)
+	if !ok {
+		return 0, 0, fmt.Errorf("crypto helper doesn't support importing keys")
+	}
+	return importer.ImportKeys(ctx, passphrase, data)
+}
+
+func (br *Connector) FetchEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	evt, err := br.Bot.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	evt.Type.Class = event.MessageEventType
+	if evt.StateKey != nil {
+		evt.Type.Class = event.StateEventType
+	}
+	err = evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("failed to parse event content: %w", err)
+	}
+	if evt.Type == event.EventEncrypted {
+		if br.Crypto == nil {
+			return nil, fmt.Errorf("event is encrypted, but encryption is not enabled")
+		}
+		evt, err = br.Crypto.Decrypt(ctx, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt event: %w", err)
+		}
+	}
+	return evt, nil
+}
+
+func (br *Connector) EventExists(ctx context.Context, roomID id.RoomID, eventID id.EventID) (bool, error) {
+	_, err := br.Bot.GetEvent(ctx, roomID, eventID)
+	if errors.Is(err, mautrix.MNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (br *Connector) IsConfusableName(ctx context.Context, roomID id.RoomID, userID id.UserID, name string) ([]id.UserID, error) {
 	return br.AS.StateStore.IsConfusableName(ctx, roomID, userID, name)
 }
@@ -70,7 +70,16 @@ func (helper *CryptoHelper) Init(ctx context.Context) error {
 	if len(helper.bridge.Config.Encryption.PickleKey) == 0 {
 		panic("CryptoPickleKey not set")
 	}
-	helper.log.Debug().Msg("Initializing end-to-bridge encryption...")
+	if backend := helper.bridge.Config.Encryption.OlmBackend; backend != "" {
+		err := olm.SetBackend(backend)
+		if err != nil {
+			return err
+		}
+	}
+	helper.log.Debug().
+		Str("olm_backend", string(olm.ActiveBackend())).
+		Interface("available_olm_backends", olm.AvailableBackends()).
+		Msg("Initializing end-to-bridge encryption...")
 
 	helper.store = NewSQLCryptoStore(
 		helper.bridge.Bridge.DB.Database,
@@ -452,6 +461,33 @@ func (helper *CryptoHelper) ShareKeys(ctx context.Context) error {
 	return helper.mach.ShareKeys(ctx, -1)
 }
 
+var (
+	_ CryptoWithKeyExport = (*CryptoHelper)(nil)
+	_ CryptoWithKeyImport = (*CryptoHelper)(nil)
+)
+
+func (helper *CryptoHelper) ExportRoomKeys(ctx context.Context, roomIDs []id.RoomID, since time.Time, passphrase string) ([]byte, int, error) {
+	helper.lock.RLock()
+	defer helper.lock.RUnlock()
+	if helper.mach == nil {
+		return nil, 0, fmt.Errorf("encryption is not initialized")
+	}
+	data, count, err := helper.mach.ExportKeysForRooms(ctx, roomIDs, since, passphrase)
+	if errors.Is(err, crypto.ErrNoSessionsToExport) {
+		return nil, 0, nil
+	}
+	return data, count, err
+}
+
+func (helper *CryptoHelper) ImportKeys(ctx context.Context, passphrase string, data []byte) (int, int, error) {
+	helper.lock.RLock()
+	defer helper.lock.RUnlock()
+	if helper.mach == nil {
+		return 0, 0, fmt.Errorf("encryption is not initialized")
+	}
+	return helper.mach.ImportKeys(ctx, passphrase, data)
+}
+
 type cryptoSyncer struct {
 	*crypto.OlmMachine
 }
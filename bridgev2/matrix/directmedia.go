@@ -42,6 +42,7 @@ func (br *Connector) initDirectMedia() error {
 	}
 	br.MediaProxy.RegisterRoutes(br.AS.Router)
 	br.dmaSigKey = sha256.Sum256(br.MediaProxy.GetServerKey().Priv.Seed())
+	br.Capabilities.DirectMedia = true
 	dmn.SetUseDirectMedia()
 	br.Log.Debug().Str("server_name", br.MediaProxy.GetServerName()).Msg("Enabled direct media access")
 	return nil
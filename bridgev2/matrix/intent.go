@@ -43,6 +43,7 @@ type ASIntent struct {
 
 var _ bridgev2.MatrixAPI = (*ASIntent)(nil)
 var _ bridgev2.MarkAsDMMatrixAPI = (*ASIntent)(nil)
+var _ bridgev2.StreamingDownloadMatrixAPI = (*ASIntent)(nil)
 
 func (as *ASIntent) SendMessage(ctx context.Context, roomID id.RoomID, eventType event.Type, content *event.Content, extra *bridgev2.MatrixSendExtra) (*mautrix.RespSendEvent, error) {
 	if extra == nil {
@@ -268,6 +269,32 @@ func (as *ASIntent) DownloadMediaToFile(ctx context.Context, uri id.ContentURISt
 	return nil
 }
 
+func (as *ASIntent) DownloadMediaStream(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo) (io.ReadCloser, int64, error) {
+	if file != nil {
+		uri = file.URL
+		err := file.PrepareForDecryption()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	parsedURI, err := uri.Parse()
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := as.Matrix.Download(ctx, parsedURI)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send download request: %w", err)
+	}
+	size := resp.ContentLength
+	if size < 0 {
+		size = -1
+	}
+	if file != nil {
+		return file.DecryptStream(resp.Body), size, nil
+	}
+	return resp.Body, size, nil
+}
+
 func (as *ASIntent) UploadMedia(ctx context.Context, roomID id.RoomID, data []byte, fileName, mimeType string) (url id.ContentURIString, file *event.EncryptedFileInfo, err error) {
 	if int64(len(data)) > as.Connector.MediaConfig.UploadSize {
 		return "", nil, fmt.Errorf("file too large (%.2f MB > %.2f MB)", float64(len(data))/1000/1000, float64(as.Connector.MediaConfig.UploadSize)/1000/1000)
@@ -631,6 +658,10 @@ func (as *ASIntent) TagRoom(ctx context.Context, roomID id.RoomID, tag event.Roo
 	return nil
 }
 
+func (as *ASIntent) SetPresence(ctx context.Context, req mautrix.ReqPresence) error {
+	return as.Matrix.SetPresenceWithStatus(ctx, req)
+}
+
 func (as *ASIntent) MuteRoom(ctx context.Context, roomID id.RoomID, until time.Time) error {
 	var mutedUntil int64
 	if until.Before(time.Now()) {
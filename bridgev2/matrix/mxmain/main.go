@@ -33,6 +33,7 @@ import (
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
 	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/matrix"
 )
 
@@ -197,6 +198,9 @@ func (br *BridgeMain) GenerateRegistration() {
 		os.Exit(20)
 	}
 	reg := br.Config.GenerateRegistration()
+	if _, ok := br.Connector.(bridgev2.ThirdPartyProtocolNetwork); ok {
+		reg.Protocols = []string{br.Connector.GetName().NetworkID}
+	}
 	err := reg.Save(br.RegistrationPath)
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "Failed to save registration:", err)
@@ -248,6 +252,9 @@ func (br *BridgeMain) Init() {
 	}
 	br.Matrix.IgnoreUnsupportedServer = *ignoreUnsupportedServer
 	br.Bridge = bridgev2.NewBridge("", br.DB, *br.Log, &br.Config.Bridge, br.Matrix, br.Connector, commands.NewProcessor)
+	br.Bridge.InstanceLock.OnLost = func() {
+		br.TriggerStop(23)
+	}
 	br.Matrix.AS.DoublePuppetValue = br.Name
 	br.Bridge.Commands.(*commands.Processor).AddHandler(&commands.FullHandler{
 		Func: func(ce *commands.Event) {
@@ -280,8 +287,26 @@ func (br *BridgeMain) initDB() {
 			Str("fixed_uri_example", fixedExampleURI).
 			Msg("Using SQLite without _txlock=immediate is not recommended")
 	}
+	dbLog := br.Log.With().Str("db_section", "main").Logger()
+	var dbLogger dbutil.DatabaseLogger = dbutil.ZeroLogger(dbLog)
+	if dbConfig.SlowQueryThreshold != "" || dbConfig.QueryMetrics {
+		metrics := &database.QueryMetrics{
+			Next:              dbLogger,
+			Log:               &dbLog,
+			CollectHistograms: dbConfig.QueryMetrics,
+		}
+		if dbConfig.SlowQueryThreshold != "" {
+			var err error
+			metrics.SlowQueryThreshold, err = time.ParseDuration(dbConfig.SlowQueryThreshold)
+			if err != nil {
+				br.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to parse database.slow_query_threshold")
+				os.Exit(14)
+			}
+		}
+		dbLogger = metrics
+	}
 	var err error
-	br.DB, err = dbutil.NewFromConfig("megabridge/"+br.Name, dbConfig, dbutil.ZeroLogger(br.Log.With().Str("db_section", "main").Logger()))
+	br.DB, err = dbutil.NewFromConfig("megabridge/"+br.Name, dbConfig.Config, dbLogger)
 	if err != nil {
 		br.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to initialize database connection")
 		if sqlError := (&sqlite3.Error{}); errors.As(err, sqlError) && sqlError.Code == sqlite3.ErrCorrupt {
@@ -309,9 +334,15 @@ func (br *BridgeMain) validateConfig() error {
 		return errors.New("appservice.database not configured")
 	case !br.Config.Bridge.Permissions.IsConfigured():
 		return errors.New("bridge.permissions not configured")
-	case !strings.Contains(br.Config.AppService.FormatUsername("1234567890"), "1234567890"):
-		return errors.New("username template is missing user ID placeholder")
 	default:
+		err := br.Config.AppService.ValidateUsernameTemplate()
+		if err != nil {
+			return err
+		}
+		err = br.Config.Bridge.Permissions.ResolveRoles(br.Config.Bridge.Roles)
+		if err != nil {
+			return fmt.Errorf("invalid bridge.permissions: %w", err)
+		}
 		cfgValidator, ok := br.Connector.(bridgev2.ConfigValidatingNetwork)
 		if ok {
 			err := cfgValidator.ValidateConfig()
@@ -27,6 +27,7 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/bridge/status"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/federation"
 	"maunium.net/go/mautrix/id"
@@ -120,7 +121,11 @@ func (prov *ProvisioningAPI) Init() {
 	prov.Router.Path("/v3/logout/{loginID}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostLogout)
 	prov.Router.Path("/v3/logins").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetLogins)
 	prov.Router.Path("/v3/contacts").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetContactList)
+	prov.Router.Path("/v3/portals").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetPortals)
 	prov.Router.Path("/v3/search_users").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostSearchUsers)
+	prov.Router.Path("/v3/search_chats").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostSearchPublicChats)
+	prov.Router.Path("/v3/preview_chat/{identifier}").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetPreviewPublicChat)
+	prov.Router.Path("/v3/join_chat/{identifier}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostJoinPublicChat)
 	prov.Router.Path("/v3/resolve_identifier/{identifier}").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetResolveIdentifier)
 	prov.Router.Path("/v3/create_dm/{identifier}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostCreateDM)
 	prov.Router.Path("/v3/create_group").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostCreateGroup)
@@ -134,6 +139,8 @@ func (prov *ProvisioningAPI) Init() {
 		r.HandleFunc("/pprof/symbol", pprof.Symbol).Methods(http.MethodGet)
 		r.HandleFunc("/pprof/trace", pprof.Trace).Methods(http.MethodGet)
 		r.PathPrefix("/pprof/").HandlerFunc(pprof.Index)
+		r.HandleFunc("/database/query_metrics", prov.GetQueryMetrics).Methods(http.MethodGet)
+		r.HandleFunc("/database/query_metrics", prov.DeleteQueryMetrics).Methods(http.MethodDelete)
 	}
 }
 
@@ -596,7 +603,11 @@ func (prov *ProvisioningAPI) doResolveIdentifier(w http.ResponseWriter, r *http.
 		})
 		return
 	}
-	resp, err := api.ResolveIdentifier(r.Context(), mux.Vars(r)["identifier"], createChat)
+	identifier := mux.Vars(r)["identifier"]
+	if ghostID, isGhost := prov.br.ParseGhostMXID(id.UserID(identifier)); isGhost {
+		identifier = string(ghostID)
+	}
+	resp, err := api.ResolveIdentifier(r.Context(), identifier, createChat)
 	if err != nil {
 		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to resolve identifier")
 		RespondWithError(w, err, "Internal error resolving identifier")
@@ -721,6 +732,58 @@ func (prov *ProvisioningAPI) GetContactList(w http.ResponseWriter, r *http.Reque
 	})
 }
 
+type RespPortal struct {
+	PortalID  networkid.PortalID    `json:"portal_id"`
+	Receiver  networkid.UserLoginID `json:"portal_receiver,omitempty"`
+	MXID      id.RoomID             `json:"mxid,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Topic     string                `json:"topic,omitempty"`
+	AvatarURL id.ContentURIString   `json:"avatar_url,omitempty"`
+	RoomType  database.RoomType     `json:"room_type,omitempty"`
+	InSpace   bool                  `json:"in_space"`
+	Preferred bool                  `json:"preferred"`
+}
+
+type RespGetPortals struct {
+	Portals []*RespPortal `json:"portals"`
+}
+
+func (prov *ProvisioningAPI) GetPortals(w http.ResponseWriter, r *http.Request) {
+	login := prov.GetLoginForRequest(w, r)
+	if login == nil {
+		return
+	}
+	userPortals, err := prov.br.Bridge.DB.UserPortal.GetAllForLogin(r.Context(), login.UserLogin)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to get user portals")
+		RespondWithError(w, err, "Internal error fetching portals")
+		return
+	}
+	resp := &RespGetPortals{Portals: make([]*RespPortal, 0, len(userPortals))}
+	for _, up := range userPortals {
+		portal, err := prov.br.Bridge.GetExistingPortalByKey(r.Context(), up.Portal)
+		if err != nil {
+			zerolog.Ctx(r.Context()).Err(err).Object("portal_key", up.Portal).Msg("Failed to get portal")
+			RespondWithError(w, err, "Internal error fetching portals")
+			return
+		} else if portal == nil {
+			continue
+		}
+		resp.Portals = append(resp.Portals, &RespPortal{
+			PortalID:  portal.ID,
+			Receiver:  portal.Receiver,
+			MXID:      portal.MXID,
+			Name:      portal.Name,
+			Topic:     portal.Topic,
+			AvatarURL: portal.AvatarMXC,
+			RoomType:  portal.RoomType,
+			InSpace:   up.InSpace != nil && *up.InSpace,
+			Preferred: up.Preferred != nil && *up.Preferred,
+		})
+	}
+	jsonResponse(w, http.StatusOK, resp)
+}
+
 type ReqSearchUsers struct {
 	Query string `json:"query"`
 }
@@ -763,6 +826,155 @@ func (prov *ProvisioningAPI) PostSearchUsers(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+type RespPublicChat struct {
+	Identifier  string              `json:"identifier"`
+	Name        string              `json:"name,omitempty"`
+	Topic       string              `json:"topic,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	MemberCount int                 `json:"member_count,omitempty"`
+	RoomID      id.RoomID           `json:"room_id,omitempty"`
+}
+
+type ReqSearchPublicChats struct {
+	Query string `json:"query"`
+}
+
+type RespSearchPublicChats struct {
+	Results []*RespPublicChat `json:"results"`
+}
+
+type RespPublicChatPreviewMessage struct {
+	SenderName string             `json:"sender_name"`
+	Timestamp  jsontime.UnixMilli `json:"timestamp"`
+	Text       string             `json:"text"`
+}
+
+type RespPreviewPublicChat struct {
+	*RespPublicChat
+	Messages []*RespPublicChatPreviewMessage `json:"messages"`
+}
+
+type RespJoinPublicChat struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+func (prov *ProvisioningAPI) processPublicChat(ctx context.Context, chat *bridgev2.PublicChat) *RespPublicChat {
+	apiChat := &RespPublicChat{
+		Identifier:  chat.Identifier,
+		Name:        chat.Name,
+		Topic:       chat.Topic,
+		AvatarURL:   chat.AvatarURL,
+		MemberCount: chat.MemberCount,
+	}
+	portal, err := chat.GetExistingPortal(ctx, prov.br.Bridge)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("identifier", chat.Identifier).Msg("Failed to get portal for public chat")
+	} else if portal != nil {
+		apiChat.RoomID = portal.MXID
+	}
+	return apiChat
+}
+
+func (prov *ProvisioningAPI) PostSearchPublicChats(w http.ResponseWriter, r *http.Request) {
+	var req ReqSearchPublicChats
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to decode request body")
+		jsonResponse(w, http.StatusBadRequest, &mautrix.RespError{
+			Err:     "Failed to decode request body",
+			ErrCode: mautrix.MNotJSON.ErrCode,
+		})
+		return
+	}
+	login := prov.GetLoginForRequest(w, r)
+	if login == nil {
+		return
+	}
+	api, ok := login.Client.(bridgev2.PublicChatSearchingNetworkAPI)
+	if !ok {
+		jsonResponse(w, http.StatusNotImplemented, &mautrix.RespError{
+			Err:     "This bridge does not support searching for public chats",
+			ErrCode: mautrix.MUnrecognized.ErrCode,
+		})
+		return
+	}
+	resp, err := api.SearchPublicChats(r.Context(), req.Query)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to search public chats")
+		RespondWithError(w, err, "Internal error searching public chats")
+		return
+	}
+	apiResp := &RespSearchPublicChats{Results: make([]*RespPublicChat, len(resp))}
+	for i, chat := range resp {
+		apiResp.Results[i] = prov.processPublicChat(r.Context(), chat)
+	}
+	jsonResponse(w, http.StatusOK, apiResp)
+}
+
+func (prov *ProvisioningAPI) GetPreviewPublicChat(w http.ResponseWriter, r *http.Request) {
+	login := prov.GetLoginForRequest(w, r)
+	if login == nil {
+		return
+	}
+	api, ok := login.Client.(bridgev2.PublicChatPreviewingNetworkAPI)
+	if !ok {
+		jsonResponse(w, http.StatusNotImplemented, &mautrix.RespError{
+			Err:     "This bridge does not support previewing public chats",
+			ErrCode: mautrix.MUnrecognized.ErrCode,
+		})
+		return
+	}
+	preview, err := api.PreviewPublicChat(r.Context(), mux.Vars(r)["identifier"])
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to preview public chat")
+		RespondWithError(w, err, "Internal error previewing public chat")
+		return
+	} else if preview == nil || preview.Chat == nil {
+		jsonResponse(w, http.StatusNotFound, &mautrix.RespError{
+			ErrCode: mautrix.MNotFound.ErrCode,
+			Err:     "Chat not found",
+		})
+		return
+	}
+	apiResp := &RespPreviewPublicChat{
+		RespPublicChat: prov.processPublicChat(r.Context(), preview.Chat),
+		Messages:       make([]*RespPublicChatPreviewMessage, len(preview.Messages)),
+	}
+	for i, msg := range preview.Messages {
+		apiResp.Messages[i] = &RespPublicChatPreviewMessage{
+			SenderName: msg.SenderName,
+			Timestamp:  jsontime.UM(msg.Timestamp),
+			Text:       msg.Text,
+		}
+	}
+	jsonResponse(w, http.StatusOK, apiResp)
+}
+
+func (prov *ProvisioningAPI) PostJoinPublicChat(w http.ResponseWriter, r *http.Request) {
+	login := prov.GetLoginForRequest(w, r)
+	if login == nil {
+		return
+	}
+	if _, ok := login.Client.(bridgev2.PublicChatSearchingNetworkAPI); !ok {
+		jsonResponse(w, http.StatusNotImplemented, &mautrix.RespError{
+			Err:     "This bridge does not support joining public chats",
+			ErrCode: mautrix.MUnrecognized.ErrCode,
+		})
+		return
+	}
+	portal, created, err := login.JoinPublicChat(r.Context(), mux.Vars(r)["identifier"])
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to join public chat")
+		RespondWithError(w, err, "Internal error joining public chat")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	jsonResponse(w, status, &RespJoinPublicChat{RoomID: portal.MXID})
+}
+
 func (prov *ProvisioningAPI) GetResolveIdentifier(w http.ResponseWriter, r *http.Request) {
 	prov.doResolveIdentifier(w, r, false)
 }
@@ -781,3 +993,45 @@ func (prov *ProvisioningAPI) PostCreateGroup(w http.ResponseWriter, r *http.Requ
 		ErrCode: mautrix.MUnrecognized.ErrCode,
 	})
 }
+
+type RespQueryMetrics struct {
+	// BucketsSeconds contains the upper bounds of the histogram buckets in each query's stats.
+	BucketsSeconds []float64              `json:"buckets_seconds"`
+	Queries        []*database.QueryStats `json:"queries"`
+}
+
+func (prov *ProvisioningAPI) getQueryMetrics(w http.ResponseWriter) *database.QueryMetrics {
+	metrics, ok := prov.br.Bridge.DB.Log.(*database.QueryMetrics)
+	if !ok || !metrics.CollectHistograms {
+		jsonResponse(w, http.StatusNotFound, &mautrix.RespError{
+			Err:     "Query metrics are not enabled",
+			ErrCode: mautrix.MNotFound.ErrCode,
+		})
+		return nil
+	}
+	return metrics
+}
+
+func (prov *ProvisioningAPI) GetQueryMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := prov.getQueryMetrics(w)
+	if metrics == nil {
+		return
+	}
+	buckets := make([]float64, len(database.QueryLatencyBuckets))
+	for i, bucket := range database.QueryLatencyBuckets {
+		buckets[i] = bucket.Seconds()
+	}
+	jsonResponse(w, http.StatusOK, &RespQueryMetrics{
+		BucketsSeconds: buckets,
+		Queries:        metrics.Snapshot(),
+	})
+}
+
+func (prov *ProvisioningAPI) DeleteQueryMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := prov.getQueryMetrics(w)
+	if metrics == nil {
+		return
+	}
+	metrics.Reset()
+	jsonResponse(w, http.StatusOK, json.RawMessage("{}"))
+}
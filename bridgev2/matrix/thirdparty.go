@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package matrix
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+var _ appservice.ThirdPartyHandler = (*Connector)(nil)
+
+func (br *Connector) getThirdPartyProtocol() *mautrix.ThirdPartyProtocol {
+	tpn, ok := br.Bridge.Network.(bridgev2.ThirdPartyProtocolNetwork)
+	if !ok {
+		return nil
+	}
+	origProto := tpn.GetThirdPartyProtocol()
+	if origProto == nil {
+		return nil
+	}
+	// Copy the struct to avoid mutating the connector's data when filling defaults
+	proto := *origProto
+	netName := br.Bridge.Network.GetName()
+	if proto.Icon == "" {
+		proto.Icon = string(netName.NetworkIcon)
+	}
+	if proto.UserFields == nil {
+		proto.UserFields = []string{}
+	}
+	if proto.LocationFields == nil {
+		proto.LocationFields = []string{}
+	}
+	if proto.FieldTypes == nil {
+		proto.FieldTypes = map[string]mautrix.ThirdPartyFieldType{}
+	}
+	if len(proto.Instances) == 0 {
+		proto.Instances = []mautrix.ThirdPartyProtocolInstance{{
+			Desc:      netName.DisplayName,
+			Icon:      string(netName.NetworkIcon),
+			Fields:    map[string]string{},
+			NetworkID: netName.NetworkID,
+		}}
+	}
+	return &proto
+}
+
+// getThirdPartyUserIDField returns the name of the third-party user field that contains the remote user ID.
+func (br *Connector) getThirdPartyUserIDField(protocol string) string {
+	if protocol != br.Bridge.Network.GetName().NetworkID {
+		return ""
+	}
+	proto := br.getThirdPartyProtocol()
+	if proto == nil || len(proto.UserFields) != 1 {
+		return ""
+	}
+	return proto.UserFields[0]
+}
+
+func (br *Connector) GetProtocol(ctx context.Context, protocol string) (*mautrix.ThirdPartyProtocol, error) {
+	if protocol != br.Bridge.Network.GetName().NetworkID {
+		return nil, nil
+	}
+	return br.getThirdPartyProtocol(), nil
+}
+
+func (br *Connector) QueryLocations(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyLocation, error) {
+	return nil, nil
+}
+
+func (br *Connector) QueryUsers(ctx context.Context, protocol string, fields map[string]string) ([]*mautrix.ThirdPartyUser, error) {
+	userIDField := br.getThirdPartyUserIDField(protocol)
+	remoteUserID := networkid.UserID(fields[userIDField])
+	if userIDField == "" || remoteUserID == "" {
+		return nil, nil
+	}
+	if validator, ok := br.Bridge.Network.(bridgev2.IdentifierValidatingNetwork); ok && !validator.ValidateUserID(remoteUserID) {
+		return nil, nil
+	}
+	return []*mautrix.ThirdPartyUser{{
+		UserID:   br.FormatGhostMXID(remoteUserID),
+		Protocol: protocol,
+		Fields:   map[string]string{userIDField: string(remoteUserID)},
+	}}, nil
+}
+
+func (br *Connector) LookupLocationByAlias(ctx context.Context, alias id.RoomAlias) ([]*mautrix.ThirdPartyLocation, error) {
+	return nil, nil
+}
+
+func (br *Connector) LookupUserByID(ctx context.Context, userID id.UserID) ([]*mautrix.ThirdPartyUser, error) {
+	protocol := br.Bridge.Network.GetName().NetworkID
+	userIDField := br.getThirdPartyUserIDField(protocol)
+	if userIDField == "" {
+		return nil, nil
+	}
+	remoteUserID, ok := br.ParseGhostMXID(userID)
+	if !ok {
+		return nil, nil
+	}
+	return []*mautrix.ThirdPartyUser{{
+		UserID:   userID,
+		Protocol: protocol,
+		Fields:   map[string]string{userIDField: string(remoteUserID)},
+	}}, nil
+}
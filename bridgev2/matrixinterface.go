@@ -26,6 +26,8 @@ import (
 type MatrixCapabilities struct {
 	AutoJoinInvites bool
 	BatchSending    bool
+	// DirectMedia is true if [MatrixConnector.GenerateContentURI] is available.
+	DirectMedia bool
 }
 
 type MatrixConnector interface {
@@ -77,6 +79,56 @@ type MatrixConnectorWithPostRoomBridgeHandling interface {
 	HandleNewlyBridgedRoom(ctx context.Context, roomID id.RoomID) error
 }
 
+// MatrixConnectorWithPinnedEvents is implemented by Matrix connectors that can read the current pinned events
+// of a room, which is required for bridging pins from the remote network.
+type MatrixConnectorWithPinnedEvents interface {
+	GetPinnedEvents(ctx context.Context, roomID id.RoomID) ([]id.EventID, error)
+}
+
+// MatrixConnectorWithEncryptionState is implemented by Matrix connectors that can check the encryption
+// state of rooms. It's used to verify that the encryption state of portals matches the config.
+type MatrixConnectorWithEncryptionState interface {
+	IsRoomEncrypted(ctx context.Context, roomID id.RoomID) (bool, error)
+	// ShouldEncryptRooms returns true if new portal rooms are encrypted by default.
+	ShouldEncryptRooms() bool
+}
+
+// MatrixConnectorWithBulkGhostSetup is implemented by Matrix connectors that can register many ghosts
+// and set their displaynames concurrently. It's used when creating portals for large groups.
+type MatrixConnectorWithBulkGhostSetup interface {
+	// SetupGhosts registers the given ghost users and sets their displaynames. The progress function
+	// (if non-nil) is called after each ghost is processed, but never concurrently. The returned map
+	// contains the errors for ghosts that couldn't be set up.
+	SetupGhosts(ctx context.Context, displaynames map[id.UserID]string, progress func()) map[id.UserID]error
+}
+
+// MatrixConnectorWithEventLookup is implemented by Matrix connectors that can check if an event exists in a room.
+type MatrixConnectorWithEventLookup interface {
+	EventExists(ctx context.Context, roomID id.RoomID, eventID id.EventID) (bool, error)
+}
+
+// MatrixConnectorWithKeyExport is implemented by Matrix connectors that can export the Megolm sessions
+// of the bridge bot, which is useful for giving users access to history they can't decrypt anymore.
+type MatrixConnectorWithKeyExport interface {
+	// ExportRoomKeys exports the inbound Megolm sessions of the given rooms received after the given time
+	// in the standard key export format. The number of exported sessions is returned along with the data.
+	// If there are no sessions to export, the count is zero and the error is nil.
+	ExportRoomKeys(ctx context.Context, roomIDs []id.RoomID, since time.Time, passphrase string) ([]byte, int, error)
+}
+
+// MatrixConnectorWithKeyImport is implemented by Matrix connectors that can import Megolm sessions
+// from a standard key export file into the bridge bot's crypto store.
+type MatrixConnectorWithKeyImport interface {
+	// ImportKeys imports the sessions in the given key export and returns the number of
+	// newly imported sessions and the total number of sessions in the export.
+	ImportKeys(ctx context.Context, passphrase string, data []byte) (imported, total int, err error)
+}
+
+// MatrixConnectorWithEventFetching is implemented by Matrix connectors that can fetch (and decrypt) arbitrary events.
+type MatrixConnectorWithEventFetching interface {
+	FetchEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error)
+}
+
 type MatrixConnectorWithAnalytics interface {
 	TrackAnalytics(userID id.UserID, event string, properties map[string]any)
 }
@@ -151,6 +203,20 @@ type MatrixAPI interface {
 	MuteRoom(ctx context.Context, roomID id.RoomID, until time.Time) error
 }
 
+// StreamingDownloadMatrixAPI is an optional interface for Matrix connectors that can stream media
+// downloads without buffering them in memory or on disk. See [UploadMediaInChunks].
+type StreamingDownloadMatrixAPI interface {
+	// DownloadMediaStream starts downloading the given file and returns the decrypted data stream
+	// along with the size of the file, or -1 if the size isn't known. The stream must be closed by the caller.
+	DownloadMediaStream(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo) (io.ReadCloser, int64, error)
+}
+
+// PresenceMatrixAPI is an optional interface for Matrix APIs that can set the presence of the user.
+// It's used to bridge the presence of users on the remote network using double puppeting.
+type PresenceMatrixAPI interface {
+	SetPresence(ctx context.Context, req mautrix.ReqPresence) error
+}
+
 type MarkAsDMMatrixAPI interface {
 	MarkAsDM(ctx context.Context, roomID id.RoomID, otherUser id.UserID) error
 }
@@ -0,0 +1,196 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+)
+
+var (
+	ErrMessageRetryNotSupported = errors.New("retrying messages requires a Matrix connector that can fetch events")
+	ErrFailedMessagePortalGone  = errors.New("the portal of the failed message no longer exists")
+	ErrFailedMessageRedacted    = errors.New("the failed message has been redacted")
+)
+
+var MessageRetryCheckInterval = 1 * time.Minute
+
+// MessageRetryMaxDelay is the maximum delay between automatic retries of a failed message.
+var MessageRetryMaxDelay = 24 * time.Hour
+
+type messageRetryNumKey struct{}
+
+// getMessageRetryNum returns the number of the retry if the event being handled is a retry of a failed message.
+func getMessageRetryNum(ctx context.Context) int {
+	retryNum, _ := ctx.Value(messageRetryNumKey{}).(int)
+	return retryNum
+}
+
+// getMessageRetryDelay returns the delay before the given automatic retry, doubling the delay after each attempt.
+func getMessageRetryDelay(initialDelay time.Duration, attempts int) time.Duration {
+	delay := initialDelay
+	for i := 1; i < attempts && delay < MessageRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, MessageRetryMaxDelay)
+}
+
+func (portal *Portal) trackFailedMessage(ctx context.Context, evt *event.Event, err error) {
+	cfg := &portal.Bridge.Config.MessageRetry
+	if !cfg.Enabled {
+		return
+	}
+	status := WrapErrorInStatus(err)
+	if status.Status != "" && status.Status != event.MessageStatusRetriable {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	fm, dbErr := portal.Bridge.DB.FailedMessage.GetByEventID(ctx, evt.ID)
+	if dbErr != nil {
+		log.Err(dbErr).Msg("Failed to get previous failed message entry")
+		return
+	} else if fm == nil {
+		fm = &database.FailedMessage{
+			EventID:    evt.ID,
+			RoomID:     portal.MXID,
+			Portal:     portal.PortalKey,
+			SenderMXID: evt.Sender,
+		}
+	}
+	fm.Error = err.Error()
+	fm.Attempts++
+	fm.LastAttempt = time.Now()
+	fm.NextRetry = time.Time{}
+	if fm.Attempts <= cfg.MaxAttempts {
+		fm.NextRetry = fm.LastAttempt.Add(getMessageRetryDelay(time.Duration(cfg.InitialDelay)*time.Second, fm.Attempts))
+	}
+	dbErr = portal.Bridge.DB.FailedMessage.Put(ctx, fm)
+	if dbErr != nil {
+		log.Err(dbErr).Msg("Failed to save failed message entry")
+	} else {
+		log.Debug().
+			Int("attempts", fm.Attempts).
+			Time("next_retry", fm.NextRetry).
+			Msg("Saved failed message entry")
+	}
+}
+
+func (portal *Portal) clearFailedMessage(ctx context.Context, evt *event.Event) {
+	if !portal.Bridge.Config.MessageRetry.Enabled {
+		return
+	}
+	err := portal.Bridge.DB.FailedMessage.Delete(ctx, evt.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete failed message entry")
+	}
+}
+
+// RetryFailedMessage fetches the original event of a message that failed to be bridged
+// and queues it to be handled again. The result of the retry is handled asynchronously:
+// the entry is deleted if bridging succeeds, and updated with the new error otherwise.
+func (br *Bridge) RetryFailedMessage(ctx context.Context, fm *database.FailedMessage) error {
+	fetcher, ok := br.Matrix.(MatrixConnectorWithEventFetching)
+	if !ok {
+		return ErrMessageRetryNotSupported
+	}
+	portal, err := br.GetExistingPortalByKey(ctx, fm.Portal)
+	if err != nil {
+		return fmt.Errorf("failed to get portal: %w", err)
+	} else if portal == nil || portal.MXID != fm.RoomID {
+		return errors.Join(ErrFailedMessagePortalGone, br.DB.FailedMessage.Delete(ctx, fm.EventID))
+	}
+	sender, err := br.GetUserByMXID(ctx, fm.SenderMXID)
+	if err != nil {
+		return fmt.Errorf("failed to get sender: %w", err)
+	}
+	evt, err := fetcher.FetchEvent(ctx, fm.RoomID, fm.EventID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch event: %w", err)
+	} else if evt.Unsigned.RedactedBecause != nil {
+		return errors.Join(ErrFailedMessageRedacted, br.DB.FailedMessage.Delete(ctx, fm.EventID))
+	}
+	// Clear the next retry time so the retry loop doesn't queue the event again while it's being handled
+	fm.NextRetry = time.Time{}
+	err = br.DB.FailedMessage.Put(ctx, fm)
+	if err != nil {
+		return fmt.Errorf("failed to update failed message entry: %w", err)
+	}
+	portal.queueEvent(ctx, &portalMatrixEvent{evt: evt, sender: sender, retryNum: fm.Attempts})
+	return nil
+}
+
+type MessageRetryLoop struct {
+	br   *Bridge
+	stop context.CancelFunc
+}
+
+func (mrl *MessageRetryLoop) Start() {
+	if !mrl.br.Config.MessageRetry.Enabled || mrl.br.Config.MessageRetry.MaxAttempts <= 0 {
+		return
+	}
+	log := mrl.br.Log.With().Str("component", "message retry loop").Logger()
+	ctx := log.WithContext(context.Background())
+	ctx, mrl.stop = context.WithCancel(ctx)
+	go mrl.loop(ctx)
+}
+
+func (mrl *MessageRetryLoop) loop(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	log.Debug().Msg("Message retry loop starting")
+	for {
+		select {
+		case <-time.After(MessageRetryCheckInterval):
+		case <-ctx.Done():
+			log.Debug().Msg("Message retry loop stopping")
+			return
+		}
+		mrl.retryDue(ctx)
+	}
+}
+
+func (mrl *MessageRetryLoop) retryDue(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	due, err := mrl.br.DB.FailedMessage.GetDue(ctx, time.Now())
+	if err != nil {
+		log.Err(err).Msg("Failed to get failed messages to retry")
+		return
+	}
+	for _, fm := range due {
+		log.Debug().
+			Stringer("event_id", fm.EventID).
+			Int("attempts", fm.Attempts).
+			Msg("Retrying failed message")
+		err = mrl.br.RetryFailedMessage(ctx, fm)
+		if errors.Is(err, ErrMessageRetryNotSupported) {
+			log.Warn().Msg("Matrix connector doesn't support fetching events, can't retry messages automatically")
+			return
+		} else if errors.Is(err, ErrFailedMessagePortalGone) || errors.Is(err, ErrFailedMessageRedacted) {
+			log.Debug().Err(err).Stringer("event_id", fm.EventID).Msg("Dropped failed message entry")
+		} else if err != nil {
+			log.Err(err).Stringer("event_id", fm.EventID).Msg("Failed to retry message")
+			// Don't try to queue the same message every minute if fetching it fails
+			fm.NextRetry = time.Time{}
+			err = mrl.br.DB.FailedMessage.Put(ctx, fm)
+			if err != nil {
+				log.Err(err).Stringer("event_id", fm.EventID).Msg("Failed to clear next retry time")
+			}
+		}
+	}
+}
+
+func (mrl *MessageRetryLoop) Stop() {
+	if mrl.stop != nil {
+		mrl.stop()
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type testFetchingMatrix struct {
+	testMatrix
+	events map[id.EventID]*event.Event
+
+	statusLock sync.Mutex
+	statuses   []*MessageStatus
+}
+
+func (tfm *testFetchingMatrix) SendMessageStatus(ctx context.Context, status *MessageStatus, evt *MessageStatusEventInfo) {
+	tfm.statusLock.Lock()
+	tfm.statuses = append(tfm.statuses, status)
+	tfm.statusLock.Unlock()
+}
+
+func (tfm *testFetchingMatrix) getStatuses() []*MessageStatus {
+	tfm.statusLock.Lock()
+	defer tfm.statusLock.Unlock()
+	return append([]*MessageStatus(nil), tfm.statuses...)
+}
+
+var _ MatrixConnectorWithEventFetching = (*testFetchingMatrix)(nil)
+
+func (tfm *testFetchingMatrix) FetchEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	evt, ok := tfm.events[eventID]
+	if !ok {
+		return nil, errors.New("event not found")
+	}
+	return evt, nil
+}
+
+func newTestMessageRetryBridge(t *testing.T) (*Bridge, *UserLogin, *testNetworkAPI, *testFetchingMatrix) {
+	br, login, client := newTestBridgeWithLogin(t)
+	matrix := &testFetchingMatrix{events: make(map[id.EventID]*event.Event)}
+	br.Matrix = matrix
+	br.Config.MessageRetry.Enabled = true
+	br.Config.MessageRetry.MaxAttempts = 3
+	br.Config.MessageRetry.InitialDelay = 10
+	return br, login, client, matrix
+}
+
+func newTestMessage(portal *Portal, sender *User, eventID id.EventID) *event.Event {
+	return &event.Event{
+		ID:      eventID,
+		Type:    event.EventMessage,
+		RoomID:  portal.MXID,
+		Sender:  sender.MXID,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+	}
+}
+
+func TestPortal_TrackFailedMessage(t *testing.T) {
+	ctx := context.Background()
+	br, login, _, _ := newTestMessageRetryBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	evt := newTestMessage(portal, login.User, "$msg")
+
+	for i, expectedDelay := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 0} {
+		portal.trackFailedMessage(ctx, evt, errors.New("network is down"))
+		fm, err := br.DB.FailedMessage.GetByEventID(ctx, evt.ID)
+		require.NoError(t, err)
+		require.NotNil(t, fm)
+		assert.Equal(t, i+1, fm.Attempts)
+		assert.Equal(t, "network is down", fm.Error)
+		if expectedDelay == 0 {
+			assert.True(t, fm.NextRetry.IsZero(), "there should be no automatic retries after max attempts")
+		} else {
+			assert.Equal(t, expectedDelay, fm.NextRetry.Sub(fm.LastAttempt))
+		}
+	}
+
+	permanentEvt := newTestMessage(portal, login.User, "$permanent")
+	portal.trackFailedMessage(ctx, permanentEvt, MessageStatus{Status: event.MessageStatusFail, InternalError: errors.New("unsupported")})
+	fm, err := br.DB.FailedMessage.GetByEventID(ctx, permanentEvt.ID)
+	require.NoError(t, err)
+	assert.Nil(t, fm, "permanent failures shouldn't be retried")
+}
+
+func TestGetMessageRetryDelay_Cap(t *testing.T) {
+	assert.Equal(t, 10*time.Second, getMessageRetryDelay(10*time.Second, 1))
+	assert.Equal(t, 80*time.Second, getMessageRetryDelay(10*time.Second, 4))
+	assert.Equal(t, MessageRetryMaxDelay, getMessageRetryDelay(10*time.Second, 100))
+	assert.Equal(t, MessageRetryMaxDelay, getMessageRetryDelay(time.Duration(1<<62), 2))
+}
+
+func TestBridge_RetryFailedMessage(t *testing.T) {
+	ctx := context.Background()
+	br, login, client, matrix := newTestMessageRetryBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	require.NoError(t, br.DB.UserPortal.Put(ctx, database.UserPortalFor(login.UserLogin, portal.PortalKey)))
+	evt := newTestMessage(portal, login.User, "$msg")
+	matrix.events[evt.ID] = evt
+
+	client.setMessageErr(WrapErrorInStatus(errors.New("network is down")).WithSendNotice(true))
+	portal.handleMatrixEvent(ctx, login.User, evt)
+	fm, err := br.DB.FailedMessage.GetByEventID(ctx, evt.ID)
+	require.NoError(t, err)
+	require.NotNil(t, fm)
+	statuses := matrix.getStatuses()
+	require.Len(t, statuses, 1)
+	assert.True(t, statuses[0].SendNotice)
+
+	require.NoError(t, br.RetryFailedMessage(ctx, fm))
+	require.Eventually(t, func() bool {
+		fm, err = br.DB.FailedMessage.GetByEventID(ctx, evt.ID)
+		return err == nil && fm != nil && fm.Attempts == 2
+	}, 5*time.Second, 10*time.Millisecond, "failed retry should be tracked")
+	statuses = matrix.getStatuses()
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses[1].SendNotice, "failed retries shouldn't send another error notice")
+	assert.Equal(t, 1, statuses[1].RetryNum)
+
+	client.setMessageErr(nil)
+	require.NoError(t, br.RetryFailedMessage(ctx, fm))
+	require.Eventually(t, func() bool {
+		fm, err = br.DB.FailedMessage.GetByEventID(ctx, evt.ID)
+		return err == nil && fm == nil
+	}, 5*time.Second, 10*time.Millisecond, "failed message entry should be deleted after a successful retry")
+	assert.Equal(t, 3, client.messageCount())
+}
+
+func TestBridge_RetryFailedMessage_Dropped(t *testing.T) {
+	ctx := context.Background()
+	br, login, _, matrix := newTestMessageRetryBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+
+	redactedEvt := newTestMessage(portal, login.User, "$redacted")
+	redactedEvt.Unsigned.RedactedBecause = &event.Event{ID: "$redaction"}
+	matrix.events[redactedEvt.ID] = redactedEvt
+	portal.trackFailedMessage(ctx, redactedEvt, errors.New("network is down"))
+	fm, err := br.DB.FailedMessage.GetByEventID(ctx, redactedEvt.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, br.RetryFailedMessage(ctx, fm), ErrFailedMessageRedacted)
+	fm, err = br.DB.FailedMessage.GetByEventID(ctx, redactedEvt.ID)
+	require.NoError(t, err)
+	assert.Nil(t, fm)
+
+	movedEvt := newTestMessage(portal, login.User, "$moved")
+	portal.trackFailedMessage(ctx, movedEvt, errors.New("network is down"))
+	fm, err = br.DB.FailedMessage.GetByEventID(ctx, movedEvt.ID)
+	require.NoError(t, err)
+	fm.RoomID = "!old:example.com"
+	assert.ErrorIs(t, br.RetryFailedMessage(ctx, fm), ErrFailedMessagePortalGone)
+	fm, err = br.DB.FailedMessage.GetByEventID(ctx, movedEvt.ID)
+	require.NoError(t, err)
+	assert.Nil(t, fm)
+
+	br.Matrix = &testMatrix{}
+	assert.ErrorIs(t, br.RetryFailedMessage(ctx, &database.FailedMessage{EventID: "$msg"}), ErrMessageRetryNotSupported)
+}
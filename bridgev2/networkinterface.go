@@ -16,6 +16,7 @@ import (
 	"go.mau.fi/util/configupgrade"
 	"go.mau.fi/util/ptr"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
@@ -278,6 +279,37 @@ type MaxFileSizeingNetwork interface {
 	SetMaxFileSize(maxSize int64)
 }
 
+// ThirdPartyProtocolNetwork is an optional interface that network connectors can implement to describe how
+// remote users and chats are identified. The metadata is served to clients via the third-party protocol API
+// (`/_matrix/client/v3/thirdparty/protocol/{network ID}`).
+//
+// If the returned protocol has exactly one user field, it is assumed to contain the remote user ID,
+// which allows the bridge to answer third-party user lookups by mapping it to ghost user IDs and back.
+// The icon and instance list will be filled from [BridgeName] if they're empty.
+type ThirdPartyProtocolNetwork interface {
+	NetworkConnector
+	GetThirdPartyProtocol() *mautrix.ThirdPartyProtocol
+}
+
+// ReactionMappingNetwork is an optional interface that network connectors can implement to have the bridge
+// normalize reaction keys and map them to network-specific emoji IDs.
+//
+// The mapped values are available in [MatrixReaction] and are used as the pre-handle response if the connector
+// leaves both the emoji and emoji ID empty. For remote reactions that only have an emoji ID, the map is used to
+// find the emoji to send to Matrix.
+type ReactionMappingNetwork interface {
+	NetworkConnector
+	GetReactionMap() *ReactionMap
+}
+
+// BackfillRateLimitingNetwork is an optional interface that network connectors can implement to limit how many
+// backfill batches the backfill queue fetches per minute. The limit is only used if the bridge admin hasn't set
+// max_batches_per_minute in the config.
+type BackfillRateLimitingNetwork interface {
+	NetworkConnector
+	GetBackfillMaxBatchesPerMinute() int
+}
+
 type RemoteEchoHandler func(RemoteMessage, *database.Message) (bool, error)
 
 type MatrixMessageResponse struct {
@@ -306,6 +338,14 @@ type NetworkGeneralCapabilities struct {
 	// Should the bridge re-request user info on incoming messages even if the ghost already has info?
 	// By default, info is only requested for ghosts with no name, and other updating is left to events.
 	AggressiveUpdateInfo bool
+	// If set, ghost info is considered stale after this duration and is re-fetched in the background
+	// when the ghost is next seen in a remote event. Aggressive info updates are also skipped for ghosts
+	// whose info was fetched more recently than this.
+	UserInfoTTL time.Duration
+	// The minimum interval between live location updates bridged in either direction.
+	// Updates received more often are throttled so that only the latest one is bridged once the interval passes.
+	// If zero, [DefaultLiveLocationUpdateInterval] is used.
+	LiveLocationUpdateInterval time.Duration
 }
 
 type NetworkRoomCapabilities struct {
@@ -403,6 +443,13 @@ type FetchMessagesParams struct {
 
 	// When the messages are being fetched for a queued backfill, this is the task object.
 	Task *database.BackfillTask
+
+	// If true, the network connector should not download media in the fetched messages.
+	// Instead, it should generate placeholder content URIs using [MatrixConnector.GenerateContentURI],
+	// and the media will only be downloaded (with [DirectMediableNetwork.Download]) when someone opens it.
+	//
+	// This is only set if deferred backfill media is enabled in the config and direct media is available.
+	DeferMedia bool
 }
 
 // BackfillReaction is an individual reaction to a message in a history pagination request.
@@ -425,6 +472,20 @@ type BackfillReaction struct {
 	DBMetadata   any
 }
 
+// BackfillReadReceipt is the read position of a single user in a history pagination request.
+//
+// The receipt is sent after all messages in the batch have been bridged, so it can point at any message in the batch
+// or at a message that was bridged earlier.
+type BackfillReadReceipt struct {
+	Sender EventSender
+	// The ID of the last message the user has read.
+	LastTarget networkid.MessageID
+	// The time up to which the user has read messages. Used if LastTarget is unset or not found.
+	ReadUpTo time.Time
+	// Optional timestamp for the receipt. If unset, the timestamp of the target message is used.
+	Timestamp time.Time
+}
+
 // BackfillMessage is an individual message in a history pagination request.
 type BackfillMessage struct {
 	*ConvertedMessage
@@ -491,6 +552,9 @@ type FetchMessagesResponse struct {
 	// When sending forward backfill (or the first batch in a room), this field can be set
 	// to mark the messages as read immediately after backfilling.
 	MarkRead bool
+	// Read receipts of other participants (and optionally the user themselves) to bridge after the batch.
+	// This is ignored for thread backfills.
+	ReadReceipts []*BackfillReadReceipt
 
 	// Should the bridge check each message against the database to ensure it's not a duplicate before bridging?
 	// By default, the bridge will only drop messages that are older than the last bridged message for forward backfills,
@@ -625,6 +689,16 @@ type RoomTopicHandlingNetworkAPI interface {
 	HandleMatrixRoomTopic(ctx context.Context, msg *MatrixRoomTopic) (bool, error)
 }
 
+// DisappearingTimerChangingNetworkAPI is an optional interface that network connectors can implement
+// to allow changing the disappearing message timer of a chat from Matrix.
+type DisappearingTimerChangingNetworkAPI interface {
+	NetworkAPI
+	// HandleMatrixDisappearingTimer is called when a Matrix user changes the disappearing timer of a portal.
+	// This method should apply the new timer on the remote network and return true if the change was successful.
+	// The Disappear field of the Portal is updated by the bridge after this returns true, so it must not be changed here.
+	HandleMatrixDisappearingTimer(ctx context.Context, msg *MatrixDisappearingTimer) (bool, error)
+}
+
 type ResolveIdentifierResponse struct {
 	// Ghost is the ghost of the user that the identifier resolves to.
 	// This field should be set whenever possible. However, it is not required,
@@ -660,6 +734,14 @@ type IdentifierResolvingNetworkAPI interface {
 	ResolveIdentifier(ctx context.Context, identifier string, createChat bool) (*ResolveIdentifierResponse, error)
 }
 
+// BulkUserInfoFetchingNetworkAPI is an optional interface that network connectors can implement
+// to fetch the info of multiple users in one request. It's used when refreshing stale ghost info.
+type BulkUserInfoFetchingNetworkAPI interface {
+	NetworkAPI
+	// GetUserInfoBulk returns info for the given ghosts. Ghosts missing from the returned map are ignored.
+	GetUserInfoBulk(ctx context.Context, ghosts []*Ghost) (map[networkid.UserID]*UserInfo, error)
+}
+
 // ContactListingNetworkAPI is an optional interface that network connectors can implement to provide the user's contact list.
 type ContactListingNetworkAPI interface {
 	NetworkAPI
@@ -676,6 +758,50 @@ type GroupCreatingNetworkAPI interface {
 	CreateGroup(ctx context.Context, name string, users ...networkid.UserID) (*CreateChatResponse, error)
 }
 
+// PublicChat is a public chat (e.g. a community or channel) found using SearchPublicChats.
+type PublicChat struct {
+	// Identifier is the value that can be passed to JoinPublicChat or PreviewPublicChat.
+	Identifier string
+	// PortalKey is optional. If set, the bridge will check if the user already has a portal for the chat.
+	PortalKey   *networkid.PortalKey
+	Name        string
+	Topic       string
+	AvatarURL   id.ContentURIString
+	MemberCount int
+}
+
+// PublicChatPreview contains info about a public chat and its recent messages.
+type PublicChatPreview struct {
+	Chat *PublicChat
+	// Messages are the most recent messages in the chat, with the newest message last.
+	Messages []*PublicChatPreviewMessage
+}
+
+type PublicChatPreviewMessage struct {
+	SenderName string
+	Timestamp  time.Time
+	Text       string
+}
+
+// PublicChatSearchingNetworkAPI is an optional interface that network connectors can implement to allow users
+// to find and join public chats on the remote network that they aren't a member of yet.
+type PublicChatSearchingNetworkAPI interface {
+	NetworkAPI
+	// SearchPublicChats is called when the user wants to find public chats.
+	// This can happen via the `search --chats` bridge bot command or the corresponding provisioning API endpoint.
+	SearchPublicChats(ctx context.Context, query string) ([]*PublicChat, error)
+	// JoinPublicChat is called when the user explicitly wants to join a public chat.
+	// The bridge will create the portal room after this returns, if it doesn't exist yet.
+	JoinPublicChat(ctx context.Context, identifier string) (*CreateChatResponse, error)
+}
+
+// PublicChatPreviewingNetworkAPI is an optional extension to PublicChatSearchingNetworkAPI
+// for networks that allow reading messages in public chats without joining.
+type PublicChatPreviewingNetworkAPI interface {
+	PublicChatSearchingNetworkAPI
+	PreviewPublicChat(ctx context.Context, identifier string) (*PublicChatPreview, error)
+}
+
 type MembershipChangeType struct {
 	From   event.Membership
 	To     event.Membership
@@ -754,6 +880,48 @@ type PowerLevelHandlingNetworkAPI interface {
 	HandleMatrixPowerLevels(ctx context.Context, msg *MatrixPowerLevelChange) (bool, error)
 }
 
+// LiveLocationHandlingNetworkAPI is an optional interface that network connectors can implement
+// to bridge live location sharing (MSC3672 beacons) from Matrix to the remote network.
+type LiveLocationHandlingNetworkAPI interface {
+	NetworkAPI
+	// HandleMatrixLiveLocationShare is called when a user starts or stops sharing their live location.
+	// The Live field of the content is false when sharing is stopped. This is also called with a synthetic
+	// stopped content when a share expires without the Matrix client explicitly stopping it.
+	HandleMatrixLiveLocationShare(ctx context.Context, msg *MatrixLiveLocationShare) error
+	// HandleMatrixLiveLocationUpdate is called when a new location is sent for a live share.
+	// Updates are throttled according to [NetworkGeneralCapabilities.LiveLocationUpdateInterval].
+	HandleMatrixLiveLocationUpdate(ctx context.Context, msg *MatrixLiveLocationUpdate) error
+}
+
+// PinHandlingNetworkAPI is an optional interface that network connectors can implement
+// to bridge changes to m.room.pinned_events from Matrix to the remote network.
+type PinHandlingNetworkAPI interface {
+	NetworkAPI
+	// HandleMatrixPinChange is called when messages are pinned or unpinned in a portal room.
+	// Only messages that exist in the bridge database are included in the change.
+	HandleMatrixPinChange(ctx context.Context, msg *MatrixPinChange) error
+}
+
+type ModerationAction string
+
+const (
+	ModerationActionKick ModerationAction = "kick"
+	ModerationActionBan  ModerationAction = "ban"
+	ModerationActionMute ModerationAction = "mute"
+)
+
+// ModerationCheckingNetworkAPI is an optional interface that network connectors can implement
+// to check the remote roles of a user before moderation commands are executed in a portal.
+//
+// The moderation actions themselves are bridged using [MembershipHandlingNetworkAPI] (kicks and bans)
+// and [PowerLevelHandlingNetworkAPI] (mutes).
+type ModerationCheckingNetworkAPI interface {
+	NetworkAPI
+	// CanModerate returns true if the user login is allowed to perform the given action
+	// on the target user in the given chat on the remote network.
+	CanModerate(ctx context.Context, portal *Portal, action ModerationAction, target GhostOrUserLogin) (bool, error)
+}
+
 type PushType int
 
 func (pt PushType) String() string {
@@ -854,6 +1022,12 @@ func (ret RemoteEventType) String() string {
 		return "RemoteEventChatDelete"
 	case RemoteEventBackfill:
 		return "RemoteEventBackfill"
+	case RemoteEventLiveLocation:
+		return "RemoteEventLiveLocation"
+	case RemoteEventPin:
+		return "RemoteEventPin"
+	case RemoteEventChatLeave:
+		return "RemoteEventChatLeave"
 	default:
 		return fmt.Sprintf("RemoteEventType(%d)", int(ret))
 	}
@@ -876,6 +1050,9 @@ const (
 	RemoteEventChatResync
 	RemoteEventChatDelete
 	RemoteEventBackfill
+	RemoteEventLiveLocation
+	RemoteEventPin
+	RemoteEventChatLeave
 )
 
 // RemoteEvent represents a single event from the remote network, such as a message or a reaction.
@@ -942,6 +1119,19 @@ type RemoteChatDelete interface {
 	RemoteDeleteOnlyForMe
 }
 
+// RemoteChatLeave is a remote event signaling that the user left the chat or was removed from it
+// on the remote network. The portal is handled according to the deleted_chat_handling config.
+type RemoteChatLeave interface {
+	RemoteEvent
+}
+
+// RemoteChatLeaveWithReason can be implemented by [RemoteChatLeave] events to include a reason in the notice
+// posted in the portal room.
+type RemoteChatLeaveWithReason interface {
+	RemoteChatLeave
+	GetLeaveReason() string
+}
+
 type RemoteEventThatMayCreatePortal interface {
 	RemoteEvent
 	ShouldCreatePortal() bool
@@ -1071,6 +1261,38 @@ type RemoteMarkUnread interface {
 	GetUnread() bool
 }
 
+// LiveLocationUpdate is a single update to a live location share from the remote network.
+type LiveLocationUpdate struct {
+	// The location as a geo URI (RFC 5870). May be empty when starting or stopping a share.
+	GeoURI string
+	// A description of the location. When starting a share, this is also used as the description of the share.
+	Description string
+	// The time when the location was recorded. Defaults to the current time.
+	Timestamp time.Time
+	// How long the share lasts. Only used when the share is started. If zero, the share
+	// lasts for [DefaultLiveLocationTimeout].
+	Timeout time.Duration
+	// Set to true to stop the share. Other fields are ignored when stopping.
+	Stop bool
+}
+
+type RemoteLiveLocation interface {
+	RemoteEvent
+	// GetLiveLocationID returns an identifier for the share, which must be unique among the sender's shares in the chat.
+	//
+	// Beacon info state events use the ghost's MXID as the state key, so each ghost can only have one active share
+	// per room. Starting a new share replaces the previous one, and updates to the replaced share are ignored.
+	GetLiveLocationID() string
+	GetLiveLocation() *LiveLocationUpdate
+}
+
+// RemotePin is a remote event that pins or unpins the target message.
+type RemotePin interface {
+	RemoteEventWithTargetMessage
+	// GetPinned returns true if the message was pinned and false if it was unpinned.
+	GetPinned() bool
+}
+
 type RemoteTyping interface {
 	RemoteEvent
 	GetTimeout() time.Duration
@@ -1143,6 +1365,11 @@ type MatrixReaction struct {
 	ReactionToOverride *database.Reaction
 	// When MaxReactions is >0 in the pre-response, this is the list of previous reactions that should be preserved.
 	ExistingReactionsToKeep []*database.Reaction
+
+	// The reaction key converted using the network's [ReactionMap]. If the network doesn't provide a map,
+	// MappedEmoji is the reaction key as-is and MappedEmojiID is empty.
+	MappedEmoji   string
+	MappedEmojiID networkid.EmojiID
 }
 
 type MatrixReactionPreResponse struct {
@@ -1192,6 +1419,34 @@ type MatrixTyping struct {
 	Type     TypingType
 }
 
+type MatrixLiveLocationShare = MatrixRoomMeta[*event.BeaconInfoEventContent]
+
+type MatrixLiveLocationUpdate struct {
+	MatrixEventBase[*event.BeaconEventContent]
+	// The event ID of the beacon_info state event that the update belongs to.
+	ShareEventID id.EventID
+	// The content of the beacon_info state event.
+	Share *event.BeaconInfoEventContent
+}
+
+type MatrixPinChange struct {
+	MatrixRoomMeta[*event.PinnedEventsEventContent]
+	// The messages that were added to the pinned events list.
+	Pinned []*database.Message
+	// The messages that were removed from the pinned events list.
+	Unpinned []*database.Message
+}
+
+type MatrixDisappearingTimer struct {
+	Portal *Portal
+	// The Matrix user who requested the change.
+	Sender *User
+	// The new disappearing message setting. Timer is zero and Type is empty if disappearing messages are being disabled.
+	Setting database.DisappearingSetting
+	// The previous disappearing message setting of the portal.
+	PrevSetting database.DisappearingSetting
+}
+
 type MatrixMarkedUnread = MatrixRoomMeta[*event.MarkedUnreadEventContent]
 type MatrixMute = MatrixRoomMeta[*event.BeeperMuteEventContent]
 type MatrixRoomTag = MatrixRoomMeta[*event.TagEventContent]
@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+)
+
+// NotificationPolicyHintKey is added to the content of bridged messages whose notifications
+// were suppressed by the portal's notification policy. The value is the policy that was applied.
+//
+// Clients and push rules can match on this key (e.g. with an event_match condition on
+// content.fi\.mau\.notification_policy) to handle the messages differently.
+const NotificationPolicyHintKey = "fi.mau.notification_policy"
+
+// SetNotificationPolicy changes the notification policy of the portal and saves it to the database.
+//
+// Policies set using this method are treated as manual overrides: they won't be replaced by the remote mute state
+// (see the sync_mute_to_notification_policy config option) until [Portal.ResetNotificationPolicy] is called.
+func (portal *Portal) SetNotificationPolicy(ctx context.Context, policy database.NotificationPolicy) error {
+	return portal.setNotificationPolicy(ctx, policy, true)
+}
+
+// ResetNotificationPolicy removes the manual override flag of the notification policy,
+// so that it'll follow the remote mute state again the next time it's synced.
+func (portal *Portal) ResetNotificationPolicy(ctx context.Context) error {
+	if !portal.NotifyIsManual {
+		return nil
+	}
+	portal.NotifyIsManual = false
+	return portal.Save(ctx)
+}
+
+func (portal *Portal) setNotificationPolicy(ctx context.Context, policy database.NotificationPolicy, manual bool) error {
+	if !policy.IsValid() {
+		return fmt.Errorf("invalid notification policy %q", policy)
+	} else if portal.Notify == policy && portal.NotifyIsManual == manual {
+		return nil
+	}
+	zerolog.Ctx(ctx).Debug().
+		Str("old_policy", string(portal.Notify)).
+		Str("new_policy", string(policy)).
+		Bool("manual", manual).
+		Msg("Changing portal notification policy")
+	portal.Notify = policy
+	portal.NotifyIsManual = manual
+	return portal.Save(ctx)
+}
+
+// shouldSuppressNotifications checks if a message with the given mentions shouldn't notify
+// according to the portal's notification policy.
+func (portal *Portal) shouldSuppressNotifications(mentions *event.Mentions) bool {
+	switch portal.Notify {
+	case database.NotificationPolicyAlways:
+		return false
+	case database.NotificationPolicyMentionsOnly:
+		return !portal.mentionsRealUser(mentions)
+	default:
+		return true
+	}
+}
+
+// suppressNotifications turns text and emote messages into notices, which don't notify with the default push rules.
+// Other message types can't be changed, so they only get the [NotificationPolicyHintKey] hint.
+func (portal *Portal) suppressNotifications(content *event.MessageEventContent, extra map[string]any) {
+	extra[NotificationPolicyHintKey] = string(portal.Notify)
+	switch content.MsgType {
+	case event.MsgText, event.MsgEmote:
+		content.MsgType = event.MsgNotice
+	}
+}
+
+// applyNotificationPolicy adjusts a converted message part so that it doesn't notify
+// if the portal's notification policy says it shouldn't.
+func (portal *Portal) applyNotificationPolicy(part *ConvertedMessagePart) {
+	if part.Type != event.EventMessage || part.Content == nil || !portal.shouldSuppressNotifications(part.Content.Mentions) {
+		return
+	}
+	if part.Extra == nil {
+		part.Extra = make(map[string]any)
+	}
+	portal.suppressNotifications(part.Content, part.Extra)
+}
+
+// applyEditNotificationPolicy is the equivalent of applyNotificationPolicy for edits.
+// It must be called before the edit content is wrapped with [event.MessageEventContent.SetEdit].
+func (portal *Portal) applyEditNotificationPolicy(part *ConvertedEditPart) {
+	if part.Type != event.EventMessage || part.Content == nil {
+		return
+	}
+	mentions := part.NewMentions
+	if mentions == nil {
+		mentions = part.Content.Mentions
+	}
+	if !portal.shouldSuppressNotifications(mentions) {
+		return
+	}
+	if part.TopLevelExtra == nil {
+		part.TopLevelExtra = make(map[string]any)
+	}
+	portal.suppressNotifications(part.Content, part.TopLevelExtra)
+}
+
+func (portal *Portal) mentionsRealUser(mentions *event.Mentions) bool {
+	if mentions == nil {
+		return false
+	} else if mentions.Room {
+		return true
+	}
+	for _, userID := range mentions.UserIDs {
+		if userID != portal.Bridge.Bot.GetMXID() && !portal.Bridge.IsGhostMXID(userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncNotificationPolicyFromMute updates the notification policy of a portal owned by a single login
+// to match the mute state of the chat on the remote network. Manually set policies are not changed,
+// and if mute_only_on_create is enabled, the policy is only synced when the room is created.
+func (portal *Portal) syncNotificationPolicyFromMute(ctx context.Context, mutedUntil time.Time, didJustCreate bool) {
+	if portal.Receiver == "" {
+		// The mute state is per-user, so don't let one user's mute affect everyone in shared portals.
+		return
+	} else if portal.NotifyIsManual || (!didJustCreate && portal.Bridge.Config.MuteOnlyOnCreate) {
+		return
+	}
+	policy := database.NotificationPolicyAlways
+	if mutedUntil.After(time.Now()) {
+		policy = database.NotificationPolicyNever
+	}
+	err := portal.setNotificationPolicy(ctx, policy, false)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to sync notification policy from remote mute state")
+	}
+}
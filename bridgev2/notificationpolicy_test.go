@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestPortal_SyncNotificationPolicyFromMute(t *testing.T) {
+	ctx := context.Background()
+	br, login, _ := newTestBridgeWithLogin(t)
+	br.Config.MuteOnlyOnCreate = false
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat", Receiver: login.ID}, "!chat:example.com")
+	muted := time.Now().Add(time.Hour)
+
+	portal.syncNotificationPolicyFromMute(ctx, muted, false)
+	assert.Equal(t, database.NotificationPolicyNever, portal.Notify)
+	assert.False(t, portal.NotifyIsManual)
+	portal.syncNotificationPolicyFromMute(ctx, time.Time{}, false)
+	assert.Equal(t, database.NotificationPolicyAlways, portal.Notify)
+
+	require.NoError(t, portal.SetNotificationPolicy(ctx, database.NotificationPolicyMentionsOnly))
+	portal.syncNotificationPolicyFromMute(ctx, muted, true)
+	assert.Equal(t, database.NotificationPolicyMentionsOnly, portal.Notify, "manually set policy shouldn't be overridden")
+	dbPortal, err := br.DB.Portal.GetByKey(ctx, portal.PortalKey)
+	require.NoError(t, err)
+	assert.True(t, dbPortal.NotifyIsManual)
+
+	require.NoError(t, portal.ResetNotificationPolicy(ctx))
+	portal.syncNotificationPolicyFromMute(ctx, muted, false)
+	assert.Equal(t, database.NotificationPolicyNever, portal.Notify)
+}
+
+func TestPortal_SyncNotificationPolicyFromMute_OnlyOnCreate(t *testing.T) {
+	ctx := context.Background()
+	br, login, _ := newTestBridgeWithLogin(t)
+	br.Config.MuteOnlyOnCreate = true
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat", Receiver: login.ID}, "!chat:example.com")
+
+	portal.syncNotificationPolicyFromMute(ctx, time.Now().Add(time.Hour), false)
+	assert.Equal(t, database.NotificationPolicyAlways, portal.Notify, "policy should only be synced on create")
+	portal.syncNotificationPolicyFromMute(ctx, time.Now().Add(time.Hour), true)
+	assert.Equal(t, database.NotificationPolicyNever, portal.Notify)
+}
+
+func TestPortal_SyncNotificationPolicyFromMute_SharedPortal(t *testing.T) {
+	ctx := context.Background()
+	br := newTestBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	portal.syncNotificationPolicyFromMute(ctx, time.Now().Add(time.Hour), true)
+	assert.Equal(t, database.NotificationPolicyAlways, portal.Notify, "mute state shouldn't affect shared portals")
+}
+
+func TestPortal_ApplyNotificationPolicy(t *testing.T) {
+	br := newTestBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+
+	part := &ConvertedMessagePart{Type: event.EventMessage, Content: &event.MessageEventContent{MsgType: event.MsgText}}
+	portal.applyNotificationPolicy(part)
+	assert.Equal(t, event.MsgText, part.Content.MsgType)
+	assert.Nil(t, part.Extra)
+
+	portal.Notify = database.NotificationPolicyNever
+	portal.applyNotificationPolicy(part)
+	assert.Equal(t, event.MsgNotice, part.Content.MsgType)
+	assert.Equal(t, "never", part.Extra[NotificationPolicyHintKey])
+
+	portal.Notify = database.NotificationPolicyMentionsOnly
+	part = &ConvertedMessagePart{Type: event.EventMessage, Content: &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Mentions: &event.Mentions{Room: true},
+	}}
+	portal.applyNotificationPolicy(part)
+	assert.Equal(t, event.MsgText, part.Content.MsgType, "messages with mentions should notify")
+}
+
+func TestPortal_ApplyEditNotificationPolicy(t *testing.T) {
+	br := newTestBridge(t)
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	portal.Notify = database.NotificationPolicyMentionsOnly
+
+	part := &ConvertedEditPart{Type: event.EventMessage, Content: &event.MessageEventContent{MsgType: event.MsgText, Body: "edited"}}
+	portal.applyEditNotificationPolicy(part)
+	part.Content.SetEdit("$original")
+	assert.Equal(t, event.MsgNotice, part.Content.MsgType)
+	assert.Equal(t, event.MsgNotice, part.Content.NewContent.MsgType)
+	assert.Equal(t, "mentions", part.TopLevelExtra[NotificationPolicyHintKey])
+
+	part = &ConvertedEditPart{
+		Type:        event.EventMessage,
+		Content:     &event.MessageEventContent{MsgType: event.MsgText, Body: "edited"},
+		NewMentions: &event.Mentions{Room: true},
+	}
+	portal.applyEditNotificationPolicy(part)
+	assert.Equal(t, event.MsgText, part.Content.MsgType, "edits with new mentions should notify")
+	assert.Nil(t, part.TopLevelExtra)
+}
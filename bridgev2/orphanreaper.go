@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+type OrphanReason string
+
+const (
+	// OrphanReasonRoomGone means the bridge bot is no longer in the Matrix room (or the room doesn't exist).
+	OrphanReasonRoomGone OrphanReason = "room_gone"
+	// OrphanReasonNoUsers means the Matrix room only contains the bridge bot and ghosts.
+	OrphanReasonNoUsers OrphanReason = "no_users"
+)
+
+type OrphanedPortal struct {
+	Portal *Portal
+	Reason OrphanReason
+}
+
+// FindOrphanedPortals returns all portals whose Matrix room either doesn't exist anymore
+// or doesn't have any users other than the bridge bot and ghosts.
+func (br *Bridge) FindOrphanedPortals(ctx context.Context) ([]*OrphanedPortal, error) {
+	portals, err := br.GetAllPortalsWithMXID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []*OrphanedPortal
+	for _, portal := range portals {
+		reason, err := br.checkPortalOrphaned(ctx, portal)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).
+				Stringer("portal_mxid", portal.MXID).
+				Object("portal_key", portal.PortalKey).
+				Msg("Failed to check if portal is orphaned")
+		} else if reason != "" {
+			orphans = append(orphans, &OrphanedPortal{Portal: portal, Reason: reason})
+		}
+	}
+	return orphans, nil
+}
+
+func (br *Bridge) checkPortalOrphaned(ctx context.Context, portal *Portal) (OrphanReason, error) {
+	// GetMemberInfo can't be used here, as the state store treats unknown members as having left the room.
+	// GetMembers either uses a fully fetched member list or asks the server.
+	members, err := br.Matrix.GetMembers(ctx, portal.MXID)
+	if errors.Is(err, mautrix.MForbidden) || errors.Is(err, mautrix.MNotFound) {
+		return OrphanReasonRoomGone, nil
+	} else if err != nil {
+		return "", err
+	}
+	botMember, ok := members[br.Bot.GetMXID()]
+	if !ok || botMember == nil {
+		// Membership of the bot is unknown, don't risk deleting a live portal
+		return "", nil
+	} else if botMember.Membership != event.MembershipJoin && botMember.Membership != event.MembershipInvite {
+		return OrphanReasonRoomGone, nil
+	}
+	for userID, member := range members {
+		if userID == br.Bot.GetMXID() || br.IsGhostMXID(userID) {
+			continue
+		}
+		if member.Membership == event.MembershipJoin || member.Membership == event.MembershipInvite {
+			return "", nil
+		}
+	}
+	return OrphanReasonNoUsers, nil
+}
+
+// ReapOrphanedPortals finds orphaned portals and deletes them. If dryRun is true,
+// the orphaned portals are only returned and nothing is deleted.
+func (br *Bridge) ReapOrphanedPortals(ctx context.Context, dryRun bool, errorCallback func(portal *Portal, delete bool, err error)) ([]*OrphanedPortal, error) {
+	orphans, err := br.FindOrphanedPortals(ctx)
+	if err != nil || dryRun || len(orphans) == 0 {
+		return orphans, err
+	}
+	portals := make([]*Portal, len(orphans))
+	for i, orphan := range orphans {
+		portals[i] = orphan.Portal
+	}
+	DeleteManyPortals(ctx, portals, errorCallback)
+	return orphans, nil
+}
+
+type OrphanReaper struct {
+	br   *Bridge
+	stop context.CancelFunc
+}
+
+func (reaper *OrphanReaper) Start() {
+	cfg := &reaper.br.Config.CleanupOrphanedPortals
+	if !cfg.Enabled {
+		return
+	}
+	log := reaper.br.Log.With().Str("component", "orphan reaper").Logger()
+	ctx := log.WithContext(context.Background())
+	ctx, reaper.stop = context.WithCancel(ctx)
+	go reaper.loop(ctx)
+}
+
+func (reaper *OrphanReaper) loop(ctx context.Context) {
+	cfg := &reaper.br.Config.CleanupOrphanedPortals
+	log := zerolog.Ctx(ctx)
+	interval := time.Duration(cfg.Interval) * time.Hour
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	log.Debug().Stringer("interval", interval).Bool("dry_run", cfg.DryRun).Msg("Orphaned portal reaper starting")
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			log.Debug().Msg("Orphaned portal reaper stopping")
+			return
+		}
+		orphans, err := reaper.br.ReapOrphanedPortals(ctx, cfg.DryRun, nil)
+		if err != nil {
+			log.Err(err).Msg("Failed to reap orphaned portals")
+			continue
+		}
+		for _, orphan := range orphans {
+			log.Info().
+				Stringer("portal_mxid", orphan.Portal.MXID).
+				Object("portal_key", orphan.Portal.PortalKey).
+				Str("reason", string(orphan.Reason)).
+				Bool("dry_run", cfg.DryRun).
+				Msg("Found orphaned portal")
+		}
+	}
+}
+
+func (reaper *OrphanReaper) Stop() {
+	if reaper.stop != nil {
+		reaper.stop()
+	}
+}
@@ -26,15 +26,19 @@ import (
 	"golang.org/x/exp/slices"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 )
 
 type portalMatrixEvent struct {
 	evt    *event.Event
 	sender *User
+	// retryNum is the number of previous attempts if this is a retry of a failed message
+	retryNum int
 }
 
 type portalRemoteEvent struct {
@@ -380,6 +384,9 @@ func (portal *Portal) handleSingleEvent(ctx context.Context, rawEvt any, doneCal
 	}()
 	switch evt := rawEvt.(type) {
 	case *portalMatrixEvent:
+		if evt.retryNum > 0 {
+			ctx = context.WithValue(ctx, messageRetryNumKey{}, evt.retryNum)
+		}
 		portal.handleMatrixEvent(ctx, evt.sender, evt.evt)
 	case *portalRemoteEvent:
 		portal.handleRemoteEvent(ctx, evt.source, evt.evtType, evt.evt)
@@ -390,6 +397,11 @@ func (portal *Portal) handleSingleEvent(ctx context.Context, rawEvt any, doneCal
 	}
 }
 
+// FindPreferredLogin finds the login that should be used for the given user in this portal.
+//
+// If allowRelay is true and the user doesn't have a login in the portal, logins shared with the user by other users
+// are checked next. If there are no shared logins either, the returned login will be nil if the portal has a relay.
+// The caller should check whether the returned login belongs to the user to decide if the event is being relayed.
 func (portal *Portal) FindPreferredLogin(ctx context.Context, user *User, allowRelay bool) (*UserLogin, *database.UserPortal, error) {
 	if portal.Receiver != "" {
 		login, err := portal.Bridge.GetExistingUserLoginByID(ctx, portal.Receiver)
@@ -397,8 +409,13 @@ func (portal *Portal) FindPreferredLogin(ctx context.Context, user *User, allowR
 			return nil, nil, err
 		}
 		if login == nil || login.UserMXID != user.MXID {
-			if allowRelay && portal.Relay != nil {
-				return nil, nil, nil
+			if allowRelay {
+				sharedLogin, up, err := portal.findSharedLogin(ctx, user)
+				if err != nil || sharedLogin != nil {
+					return sharedLogin, up, err
+				} else if portal.Relay != nil {
+					return nil, nil, nil
+				}
 			}
 			// TODO different error for this case?
 			return nil, nil, ErrNotLoggedIn
@@ -411,25 +428,30 @@ func (portal *Portal) FindPreferredLogin(ctx context.Context, user *User, allowR
 		return nil, nil, err
 	}
 	portal.Bridge.cacheLock.Lock()
-	defer portal.Bridge.cacheLock.Unlock()
 	for i, up := range logins {
 		login, ok := user.logins[up.LoginID]
 		if ok && login.Client != nil && (len(logins) == i-1 || login.Client.IsLoggedIn()) {
+			portal.Bridge.cacheLock.Unlock()
 			return login, up, nil
 		}
 	}
+	var firstLogin *UserLogin
+	for _, login := range user.logins {
+		firstLogin = login
+		break
+	}
+	portal.Bridge.cacheLock.Unlock()
 	if !allowRelay {
 		return nil, nil, ErrNotLoggedIn
 	}
+	sharedLogin, up, err := portal.findSharedLogin(ctx, user)
+	if err != nil || sharedLogin != nil {
+		return sharedLogin, up, err
+	}
 	// Portal has relay, use it
 	if portal.Relay != nil {
 		return nil, nil, nil
 	}
-	var firstLogin *UserLogin
-	for _, login := range user.logins {
-		firstLogin = login
-		break
-	}
 	if firstLogin != nil {
 		zerolog.Ctx(ctx).Warn().
 			Str("chosen_login_id", string(firstLogin.ID)).
@@ -460,6 +482,11 @@ func (portal *Portal) sendErrorStatus(ctx context.Context, evt *event.Event, err
 	if status.InternalError == nil {
 		status.InternalError = err
 	}
+	if retryNum := getMessageRetryNum(ctx); retryNum > 0 {
+		status.RetryNum = retryNum
+		// The user was already notified about the original failure
+		status.SendNotice = false
+	}
 	portal.Bridge.Matrix.SendMessageStatus(ctx, &status, StatusEventInfoFromEvent(evt))
 }
 
@@ -494,6 +521,9 @@ func (portal *Portal) handleMatrixEvent(ctx context.Context, sender *User, evt *
 		return
 	}
 	login, _, err := portal.FindPreferredLogin(ctx, sender, true)
+	if err == nil && login == nil && !sender.Permissions.Has(bridgeconfig.CapabilityUseRelay) {
+		err = ErrNotLoggedIn
+	}
 	if err != nil {
 		log.Err(err).Msg("Failed to get user login to handle Matrix event")
 		if errors.Is(err, ErrNotLoggedIn) {
@@ -504,8 +534,12 @@ func (portal *Portal) handleMatrixEvent(ctx context.Context, sender *User, evt *
 		return
 	}
 	var origSender *OrigSender
-	if login == nil {
-		login = portal.Relay
+	if login == nil || login.UserMXID != sender.MXID {
+		// The user is either being relayed or using a login shared by another user,
+		// so the message needs to be attributed to the real sender.
+		if login == nil {
+			login = portal.Relay
+		}
 		origSender = &OrigSender{
 			User:   sender,
 			UserID: sender.MXID,
@@ -528,6 +562,14 @@ func (portal *Portal) handleMatrixEvent(ctx context.Context, sender *User, evt *
 		}
 		origSender.FormattedName = portal.Bridge.Config.Relay.FormatName(origSender)
 	}
+	switch evt.Type {
+	case event.AccountDataMarkedUnread, event.AccountDataRoomTags, event.AccountDataBeeperMute:
+		if origSender != nil {
+			// Account data is personal to the sender, so it must never be applied to someone else's login
+			log.Debug().Msg("Ignoring account data event from user without own login")
+			return
+		}
+	}
 	// Copy logger because many of the handlers will use UpdateContext
 	ctx = log.With().Str("login_id", string(login.ID)).Logger().WithContext(ctx)
 	switch evt.Type {
@@ -560,6 +602,12 @@ func (portal *Portal) handleMatrixEvent(ctx context.Context, sender *User, evt *
 		portal.handleMatrixMembership(ctx, login, origSender, evt)
 	case event.StatePowerLevels:
 		portal.handleMatrixPowerLevels(ctx, login, origSender, evt)
+	case event.StateUnstableBeaconInfo:
+		portal.handleMatrixLiveLocationShare(ctx, login, origSender, evt)
+	case event.EventUnstableBeacon:
+		portal.handleMatrixLiveLocationUpdate(ctx, login, origSender, evt)
+	case event.StatePinnedEvents:
+		portal.handleMatrixPinnedEvents(ctx, login, origSender, evt)
 	}
 }
 
@@ -918,8 +966,10 @@ func (portal *Portal) handleMatrixMessage(ctx context.Context, sender *UserLogin
 	if err != nil {
 		log.Err(err).Msg("Failed to handle Matrix message")
 		portal.sendErrorStatus(ctx, evt, err)
+		portal.trackFailedMessage(ctx, evt, err)
 		return
 	}
+	portal.clearFailedMessage(ctx, evt)
 	message := wrappedMsgEvt.fillDBMessage(resp.DB)
 	if !resp.Pending {
 		if resp.DB == nil {
@@ -1137,18 +1187,29 @@ func (portal *Portal) handleMatrixReaction(ctx context.Context, sender *UserLogi
 		},
 		TargetMessage: reactionTarget,
 	}
+	var mapped bool
+	react.MappedEmoji, react.MappedEmojiID, mapped = portal.Bridge.GetReactionMap().ToRemote(content.RelatesTo.Key)
+	if !mapped {
+		log.Debug().Str("reaction_key", content.RelatesTo.Key).Msg("Rejecting reaction not in network reaction map")
+		portal.sendErrorStatus(ctx, evt, ErrUnsupportedReactionEmoji)
+		return
+	}
 	preResp, err := reactingAPI.PreHandleMatrixReaction(ctx, react)
 	if err != nil {
 		log.Err(err).Msg("Failed to pre-handle Matrix reaction")
 		portal.sendErrorStatus(ctx, evt, err)
 		return
 	}
+	if preResp.Emoji == "" && preResp.EmojiID == "" {
+		preResp.Emoji = react.MappedEmoji
+		preResp.EmojiID = react.MappedEmojiID
+	}
 	existing, err := portal.Bridge.DB.Reaction.GetByID(ctx, reactionTarget.ID, reactionTarget.PartID, preResp.SenderID, preResp.EmojiID)
 	if err != nil {
 		log.Err(err).Msg("Failed to check if reaction is a duplicate")
 		return
 	} else if existing != nil {
-		if existing.EmojiID != "" || existing.Emoji == preResp.Emoji {
+		if existing.EmojiID != "" || format.EmojiEqual(existing.Emoji, preResp.Emoji) {
 			log.Debug().Msg("Ignoring duplicate reaction")
 			portal.sendSuccessStatus(ctx, evt, 0, "")
 			return
@@ -1661,6 +1722,12 @@ func (portal *Portal) handleRemoteEvent(ctx context.Context, source *UserLogin,
 		portal.handleRemoteChatDelete(ctx, source, evt.(RemoteChatDelete))
 	case RemoteEventBackfill:
 		portal.handleRemoteBackfill(ctx, source, evt.(RemoteBackfill))
+	case RemoteEventLiveLocation:
+		portal.handleRemoteLiveLocation(ctx, source, evt.(RemoteLiveLocation))
+	case RemoteEventPin:
+		portal.handleRemotePin(ctx, source, evt.(RemotePin))
+	case RemoteEventChatLeave:
+		portal.handleRemoteChatLeave(ctx, source, evt.(RemoteChatLeave))
 	default:
 		log.Warn().Msg("Got remote event with unknown type")
 	}
@@ -1806,6 +1873,7 @@ func (portal *Portal) sendConvertedMessage(
 	output := make([]*database.Message, 0, len(converted.Parts))
 	for i, part := range converted.Parts {
 		portal.applyRelationMeta(part.Content, replyTo, threadRoot, prevThreadEvent)
+		portal.applyNotificationPolicy(part)
 		dbMessage := &database.Message{
 			ID:         id,
 			PartID:     part.ID,
@@ -2064,6 +2132,7 @@ func (portal *Portal) sendConvertedEdit(
 ) {
 	log := zerolog.Ctx(ctx)
 	for i, part := range converted.ModifiedParts {
+		portal.applyEditNotificationPolicy(part)
 		if part.Content.Mentions == nil {
 			part.Content.Mentions = &event.Mentions{}
 		}
@@ -2307,11 +2376,12 @@ func (portal *Portal) handleRemoteReaction(ctx context.Context, source *UserLogi
 		return
 	}
 	emoji, emojiID := evt.GetReactionEmoji()
+	emoji = portal.Bridge.GetReactionMap().ToMatrix(emoji, emojiID)
 	existingReaction, err := portal.Bridge.DB.Reaction.GetByID(ctx, targetMessage.ID, targetMessage.PartID, evt.GetSender().Sender, emojiID)
 	if err != nil {
 		log.Err(err).Msg("Failed to check if reaction is a duplicate")
 		return
-	} else if existingReaction != nil && (emojiID != "" || existingReaction.Emoji == emoji) {
+	} else if existingReaction != nil && (emojiID != "" || format.EmojiEqual(existingReaction.Emoji, emoji)) {
 		log.Debug().Msg("Ignoring duplicate reaction")
 		return
 	}
@@ -2349,6 +2419,7 @@ func (portal *Portal) sendConvertedReaction(
 		}
 	}
 	log := zerolog.Ctx(ctx)
+	emoji = portal.Bridge.GetReactionMap().ToMatrix(emoji, emojiID)
 	dbReaction := &database.Reaction{
 		Room:          portal.PortalKey,
 		MessageID:     targetMessage.ID,
@@ -2640,21 +2711,6 @@ func (portal *Portal) handleRemoteChatResync(ctx context.Context, source *UserLo
 	}
 }
 
-func (portal *Portal) handleRemoteChatDelete(ctx context.Context, source *UserLogin, evt RemoteChatDelete) {
-	if portal.Receiver == "" && evt.DeleteOnlyForMe() {
-		// TODO check if there are other users
-	}
-	err := portal.Delete(ctx)
-	if err != nil {
-		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete portal from database")
-		return
-	}
-	err = portal.Bridge.Bot.DeleteRoom(ctx, portal.MXID, false)
-	if err != nil {
-		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete Matrix room")
-	}
-}
-
 func (portal *Portal) handleRemoteBackfill(ctx context.Context, source *UserLogin, backfill RemoteBackfill) {
 	//data, err := backfill.GetBackfillData(ctx, portal)
 	//if err != nil {
@@ -3002,7 +3058,11 @@ func (portal *Portal) getInitialMemberList(ctx context.Context, members *ChatMem
 	}
 	members.PowerLevels.Apply("", pl)
 	members.memberListToMap(ctx)
+	portal.setupGhostsInBulk(ctx, members)
+	progress := getPortalCreationProgress(ctx)
+	progress.Start(ctx, PortalCreationStageMembers, len(members.MemberMap))
 	for _, member := range members.MemberMap {
+		progress.Increment(ctx)
 		if member.Membership != event.MembershipJoin && member.Membership != "" {
 			continue
 		}
@@ -3179,6 +3239,8 @@ func (portal *Portal) syncParticipants(ctx context.Context, members *ChatMemberL
 			}
 		}
 	}
+	progress := getPortalCreationProgress(ctx)
+	progress.Start(ctx, PortalCreationStageMembers, len(members.MemberMap))
 	for _, member := range members.MemberMap {
 		if member.Sender != "" && member.UserInfo != nil {
 			ghost, err := portal.Bridge.GetGhostByID(ctx, member.Sender)
@@ -3195,6 +3257,7 @@ func (portal *Portal) syncParticipants(ctx context.Context, members *ChatMemberL
 		if extraUserID != "" {
 			syncUser(extraUserID, member, false)
 		}
+		progress.Increment(ctx)
 	}
 	if powerChanged {
 		_, err = portal.sendStateWithIntentOrBot(ctx, sender, event.StatePowerLevels, "", &event.Content{Parsed: currentPower}, ts)
@@ -3233,6 +3296,9 @@ func (portal *Portal) updateUserLocalInfo(ctx context.Context, info *UserLocalPo
 	if portal.MXID == "" {
 		return
 	}
+	if info != nil && info.MutedUntil != nil && portal.Bridge.Config.SyncMuteToNotificationPolicy {
+		portal.syncNotificationPolicyFromMute(ctx, *info.MutedUntil, didJustCreate)
+	}
 	dp := source.User.DoublePuppet(ctx)
 	if dp == nil {
 		return
@@ -3475,7 +3541,7 @@ func (portal *Portal) CreateMatrixRoom(ctx context.Context, source *UserLogin, i
 	}
 }
 
-func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLogin, info *ChatInfo, backfillBundle any) error {
+func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLogin, info *ChatInfo, backfillBundle any) (retErr error) {
 	portal.roomCreateLock.Lock()
 	defer portal.roomCreateLock.Unlock()
 	if portal.MXID != "" {
@@ -3501,6 +3567,11 @@ func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLo
 			return err
 		}
 	}
+	progress := portal.newPortalCreationProgress(source, info)
+	ctx = progress.withContext(ctx)
+	defer func() {
+		progress.Finish(ctx, retErr)
+	}()
 
 	portal.UpdateInfo(ctx, info, source, nil, time.Time{})
 
@@ -3677,15 +3748,56 @@ func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLo
 	return nil
 }
 
+// DeletePortalOpts contains options for [Portal.DeleteWithOpts].
+type DeletePortalOpts struct {
+	// CleanupRoom removes ghosts from the Matrix room and deletes the room.
+	// If false, only the database rows and caches of the portal are removed.
+	CleanupRoom bool
+	// KickUsers also removes real Matrix users from the room when cleaning it up.
+	KickUsers bool
+	// LeaveRoom makes the bridge bot leave the room instead of deleting it.
+	// Matrix users are left in the room unless KickUsers is set.
+	LeaveRoom bool
+	// Reason is used for the kicks when leaving the room.
+	Reason string
+}
+
+// Delete deletes the portal from the database along with its messages, reactions and user portal rows.
+// The Matrix room is left untouched, use [Portal.DeleteWithOpts] to also clean up the room.
 func (portal *Portal) Delete(ctx context.Context) error {
+	return portal.DeleteWithOpts(ctx, nil)
+}
+
+// DeleteWithOpts deletes the portal like [Portal.Delete] and then cleans up the Matrix room according to the given options.
+// If opts is nil, the Matrix room is left untouched.
+//
+// If the database rows were deleted, but cleaning up the room failed, the returned error wraps [ErrPortalRoomCleanupFailed].
+func (portal *Portal) DeleteWithOpts(ctx context.Context, opts *DeletePortalOpts) error {
 	portal.removeInPortalCache(ctx)
 	err := portal.Bridge.DB.Portal.Delete(ctx, portal.PortalKey)
 	if err != nil {
 		return err
 	}
 	portal.Bridge.cacheLock.Lock()
-	defer portal.Bridge.cacheLock.Unlock()
 	portal.unlockedDeleteCache()
+	portal.Bridge.cacheLock.Unlock()
+	if portal.MXID == "" {
+		return nil
+	}
+	err = portal.Bridge.DB.DisappearingMessage.DeleteAllInRoom(ctx, portal.MXID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete disappearing messages of deleted portal")
+	}
+	if opts == nil || !opts.CleanupRoom {
+		return nil
+	} else if opts.LeaveRoom {
+		err = portal.leaveRoom(ctx, opts.KickUsers, opts.Reason)
+	} else {
+		err = portal.Bridge.Bot.DeleteRoom(ctx, portal.MXID, !opts.KickUsers)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPortalRoomCleanupFailed, err)
+	}
 	return nil
 }
 
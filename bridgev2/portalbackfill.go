@@ -52,6 +52,7 @@ func (portal *Portal) doForwardBackfill(ctx context.Context, source *UserLogin,
 		AnchorMessage: lastMessage,
 		Count:         limit,
 		BundledData:   bundledData,
+		DeferMedia:    portal.shouldDeferBackfillMedia(),
 	})
 	if err != nil {
 		log.Err(err).Msg("Failed to fetch messages for forward backfill")
@@ -77,7 +78,7 @@ func (portal *Portal) doForwardBackfill(ctx context.Context, source *UserLogin,
 		}
 		return
 	}
-	portal.sendBackfill(ctx, source, resp.Messages, true, resp.MarkRead, false, resp.CompleteCallback)
+	portal.sendBackfill(ctx, source, resp.Messages, resp.ReadReceipts, true, resp.MarkRead, false, resp.CompleteCallback)
 }
 
 func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin, task *database.BackfillTask) error {
@@ -108,6 +109,7 @@ func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin
 		AnchorMessage: firstMessage,
 		Count:         portal.Bridge.Config.Backfill.Queue.BatchSize,
 		Task:          task,
+		DeferMedia:    portal.shouldDeferBackfillMedia(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to fetch messages for backward backfill: %w", err)
@@ -143,13 +145,21 @@ func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin
 		}
 		return fmt.Errorf("no messages left to backfill after cutting off too new messages")
 	}
-	portal.sendBackfill(ctx, source, resp.Messages, false, resp.MarkRead, false, resp.CompleteCallback)
+	portal.sendBackfill(ctx, source, resp.Messages, resp.ReadReceipts, false, resp.MarkRead, false, resp.CompleteCallback)
 	if len(resp.Messages) > 0 {
 		task.OldestMessageID = resp.Messages[0].ID
 	}
 	return nil
 }
 
+func (portal *Portal) shouldDeferBackfillMedia() bool {
+	if !portal.Bridge.Config.Backfill.DeferMedia || !portal.Bridge.Matrix.GetCapabilities().DirectMedia {
+		return false
+	}
+	_, ok := portal.Bridge.Network.(DirectMediableNetwork)
+	return ok
+}
+
 func (portal *Portal) fetchThreadBackfill(ctx context.Context, source *UserLogin, anchor *database.Message) *FetchMessagesResponse {
 	log := zerolog.Ctx(ctx)
 	resp, err := source.Client.(BackfillingNetworkAPI).FetchMessages(ctx, FetchMessagesParams{
@@ -158,6 +168,7 @@ func (portal *Portal) fetchThreadBackfill(ctx context.Context, source *UserLogin
 		Forward:       true,
 		AnchorMessage: anchor,
 		Count:         portal.Bridge.Config.Backfill.Threads.MaxInitialMessages,
+		DeferMedia:    portal.shouldDeferBackfillMedia(),
 	})
 	if err != nil {
 		log.Err(err).Msg("Failed to fetch messages for thread backfill")
@@ -194,7 +205,7 @@ func (portal *Portal) doThreadBackfill(ctx context.Context, source *UserLogin, t
 	}
 	resp := portal.fetchThreadBackfill(ctx, source, anchorMessage)
 	if resp != nil {
-		portal.sendBackfill(ctx, source, resp.Messages, true, resp.MarkRead, true, resp.CompleteCallback)
+		portal.sendBackfill(ctx, source, resp.Messages, nil, true, resp.MarkRead, true, resp.CompleteCallback)
 	}
 }
 
@@ -273,6 +284,7 @@ func (portal *Portal) sendBackfill(
 	ctx context.Context,
 	source *UserLogin,
 	messages []*BackfillMessage,
+	receipts []*BackfillReadReceipt,
 	forceForward,
 	markRead,
 	inThread bool,
@@ -292,6 +304,9 @@ func (portal *Portal) sendBackfill(
 	} else {
 		portal.sendLegacyBackfill(ctx, source, messages, markRead || forceMarkRead)
 	}
+	if len(receipts) > 0 {
+		portal.sendBackfillReadReceipts(ctx, source, receipts)
+	}
 	if done != nil {
 		done()
 	}
@@ -331,6 +346,7 @@ func (portal *Portal) compileBatchMessage(ctx context.Context, source *UserLogin
 	for i, part := range msg.Parts {
 		partIDs = append(partIDs, part.ID)
 		portal.applyRelationMeta(part.Content, replyTo, threadRoot, prevThreadEvent)
+		portal.applyNotificationPolicy(part)
 		evtID := portal.Bridge.Matrix.GenerateDeterministicEventID(portal.MXID, portal.PortalKey, msg.ID, part.ID)
 		dbMessage := &database.Message{
 			ID:         msg.ID,
@@ -382,8 +398,8 @@ func (portal *Portal) compileBatchMessage(ctx context.Context, source *UserLogin
 		}
 	}
 	slices.Sort(partIDs)
+	seenReactions := make(map[backfillReactionKey]bool, len(msg.Reactions))
 	for _, reaction := range msg.Reactions {
-		reactionIntent := portal.GetIntentFor(ctx, reaction.Sender, source, RemoteEventReactionRemove)
 		if reaction.TargetPart == nil {
 			reaction.TargetPart = &partIDs[0]
 		}
@@ -392,9 +408,18 @@ func (portal *Portal) compileBatchMessage(ctx context.Context, source *UserLogin
 		}
 		targetPart, ok := partMap[*reaction.TargetPart]
 		if !ok {
-			// TODO warning log and/or skip reaction?
+			zerolog.Ctx(ctx).Warn().
+				Str("message_id", string(msg.ID)).
+				Str("part_id", string(*reaction.TargetPart)).
+				Any("reaction_sender_id", reaction.Sender).
+				Msg("Dropping backfilled reaction to unknown message part")
+			continue
+		} else if portal.isDuplicateBackfillReaction(ctx, targetPart, reaction, seenReactions) {
+			continue
 		}
+		reactionIntent := portal.GetIntentFor(ctx, reaction.Sender, source, RemoteEventReaction)
 		reactionMXID := portal.Bridge.Matrix.GenerateReactionEventID(portal.MXID, targetPart, reaction.Sender.Sender, reaction.EmojiID)
+		reaction.Emoji = portal.Bridge.GetReactionMap().ToMatrix(reaction.Emoji, reaction.EmojiID)
 		dbReaction := &database.Reaction{
 			Room:          portal.PortalKey,
 			MessageID:     msg.ID,
@@ -431,6 +456,39 @@ func (portal *Portal) compileBatchMessage(ctx context.Context, source *UserLogin
 	}
 }
 
+type backfillReactionKey struct {
+	PartID  networkid.PartID
+	Sender  networkid.UserID
+	EmojiID networkid.EmojiID
+}
+
+// isDuplicateBackfillReaction checks if the given reaction has already been bridged, either earlier in the same
+// backfilled message or live (e.g. if the message was bridged live and later included in a backfill response).
+func (portal *Portal) isDuplicateBackfillReaction(ctx context.Context, target *database.Message, reaction *BackfillReaction, seen map[backfillReactionKey]bool) bool {
+	key := backfillReactionKey{PartID: target.PartID, Sender: reaction.Sender.Sender, EmojiID: reaction.EmojiID}
+	if seen[key] {
+		return true
+	}
+	seen[key] = true
+	existing, err := portal.Bridge.DB.Reaction.GetByID(ctx, target.ID, target.PartID, reaction.Sender.Sender, reaction.EmojiID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Str("message_id", string(target.ID)).
+			Str("part_id", string(target.PartID)).
+			Msg("Failed to check for existing reaction in backfill")
+		return false
+	} else if existing != nil {
+		zerolog.Ctx(ctx).Debug().
+			Str("message_id", string(target.ID)).
+			Str("part_id", string(target.PartID)).
+			Str("reaction_sender_id", string(reaction.Sender.Sender)).
+			Stringer("existing_mxid", existing.MXID).
+			Msg("Ignoring duplicate reaction in backfill")
+		return true
+	}
+	return false
+}
+
 func (portal *Portal) fetchThreadInsideBatch(ctx context.Context, source *UserLogin, dbMsg *database.Message, out *compileBatchOutput) {
 	log := zerolog.Ctx(ctx).With().
 		Str("subaction", "thread backfill in batch").
@@ -518,19 +576,30 @@ func (portal *Portal) sendLegacyBackfill(ctx context.Context, source *UserLogin,
 		})
 		if len(dbMessages) > 0 {
 			lastPart = dbMessages[len(dbMessages)-1].MXID
+			seenReactions := make(map[backfillReactionKey]bool, len(msg.Reactions))
 			for _, reaction := range msg.Reactions {
-				reactionIntent := portal.GetIntentFor(ctx, reaction.Sender, source, RemoteEventReaction)
 				targetPart := dbMessages[0]
 				if reaction.TargetPart != nil {
 					targetPartIdx := slices.IndexFunc(dbMessages, func(dbMsg *database.Message) bool {
 						return dbMsg.PartID == *reaction.TargetPart
 					})
-					if targetPartIdx != -1 {
-						targetPart = dbMessages[targetPartIdx]
-					} else {
-						// TODO warning log and/or skip reaction?
+					if targetPartIdx == -1 {
+						zerolog.Ctx(ctx).Warn().
+							Str("message_id", string(msg.ID)).
+							Str("part_id", string(*reaction.TargetPart)).
+							Any("reaction_sender_id", reaction.Sender).
+							Msg("Dropping backfilled reaction to unknown message part")
+						continue
 					}
+					targetPart = dbMessages[targetPartIdx]
+				}
+				if reaction.Timestamp.IsZero() {
+					reaction.Timestamp = msg.Timestamp.Add(10 * time.Millisecond)
 				}
+				if portal.isDuplicateBackfillReaction(ctx, targetPart, reaction, seenReactions) {
+					continue
+				}
+				reactionIntent := portal.GetIntentFor(ctx, reaction.Sender, source, RemoteEventReaction)
 				portal.sendConvertedReaction(
 					ctx, reaction.Sender.Sender, reactionIntent, targetPart, reaction.EmojiID, reaction.Emoji,
 					reaction.Timestamp, reaction.DBMetadata, reaction.ExtraContent,
@@ -555,3 +624,54 @@ func (portal *Portal) sendLegacyBackfill(ctx context.Context, source *UserLogin,
 		}
 	}
 }
+
+func (portal *Portal) sendBackfillReadReceipts(ctx context.Context, source *UserLogin, receipts []*BackfillReadReceipt) {
+	log := zerolog.Ctx(ctx)
+	sentCount := 0
+	for _, receipt := range receipts {
+		var target *database.Message
+		var err error
+		if receipt.LastTarget != "" {
+			target, err = portal.Bridge.DB.Message.GetLastPartByID(ctx, portal.Receiver, receipt.LastTarget)
+			if err != nil {
+				log.Err(err).Str("last_target_id", string(receipt.LastTarget)).
+					Msg("Failed to get target message for backfilled read receipt")
+				continue
+			}
+		}
+		if target == nil && !receipt.ReadUpTo.IsZero() {
+			target, err = portal.Bridge.DB.Message.GetLastPartAtOrBeforeTime(ctx, portal.PortalKey, receipt.ReadUpTo)
+			if err != nil {
+				log.Err(err).Time("read_up_to", receipt.ReadUpTo).
+					Msg("Failed to get target message for backfilled read receipt")
+				continue
+			}
+		}
+		if target == nil || target.HasFakeMXID() {
+			log.Debug().
+				Any("sender", receipt.Sender).
+				Str("last_target_id", string(receipt.LastTarget)).
+				Time("read_up_to", receipt.ReadUpTo).
+				Msg("No target message found for backfilled read receipt")
+			continue
+		}
+		ts := receipt.Timestamp
+		if ts.IsZero() {
+			ts = target.Timestamp
+		}
+		intent := portal.GetIntentFor(ctx, receipt.Sender, source, RemoteEventReadReceipt)
+		err = intent.MarkRead(ctx, portal.MXID, target.MXID, ts)
+		if err != nil {
+			log.Err(err).
+				Any("sender", receipt.Sender).
+				Stringer("target_mxid", target.MXID).
+				Msg("Failed to bridge backfilled read receipt")
+		} else {
+			sentCount++
+		}
+	}
+	log.Debug().
+		Int("receipt_count", len(receipts)).
+		Int("sent_count", sentCount).
+		Msg("Sent backfilled read receipts")
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func (portal *Portal) handleRemoteChatDelete(ctx context.Context, source *UserLogin, evt RemoteChatDelete) {
+	portal.handleRemoteChatGone(ctx, source, portal.Bridge.Config.DeletedChatHandling.Deleted, evt.DeleteOnlyForMe(), "This chat was deleted on the remote network.")
+}
+
+func (portal *Portal) handleRemoteChatLeave(ctx context.Context, source *UserLogin, evt RemoteChatLeave) {
+	notice := "You are no longer in this chat on the remote network."
+	if reasonEvt, ok := evt.(RemoteChatLeaveWithReason); ok && reasonEvt.GetLeaveReason() != "" {
+		notice = fmt.Sprintf("You are no longer in this chat on the remote network: %s", reasonEvt.GetLeaveReason())
+	}
+	portal.handleRemoteChatGone(ctx, source, portal.Bridge.Config.DeletedChatHandling.Left, true, notice)
+}
+
+func (portal *Portal) handleRemoteChatGone(ctx context.Context, source *UserLogin, action bridgeconfig.ChatDeleteAction, onlyForSource bool, notice string) {
+	if action == bridgeconfig.ChatDeleteActionNull {
+		// Don't delete anything unless the bridge admin has explicitly chosen to do so
+		action = bridgeconfig.ChatDeleteActionNothing
+	}
+	log := zerolog.Ctx(ctx).With().Str("chat_delete_action", string(action)).Logger()
+	ctx = log.WithContext(ctx)
+	if onlyForSource && portal.Receiver == "" {
+		logins, err := portal.Bridge.GetUserLoginsInPortal(ctx, portal.PortalKey)
+		if err != nil {
+			log.Err(err).Msg("Failed to get user logins in portal")
+			return
+		}
+		otherLogins := slices.DeleteFunc(logins, func(login *UserLogin) bool {
+			return login.ID == source.ID
+		})
+		if len(otherLogins) > 0 {
+			log.Debug().Msg("Other logins are still in the portal, only removing the source user")
+			portal.removeLoginFromPortal(ctx, source, otherLogins)
+			return
+		}
+	}
+	if portal.MXID == "" && action != bridgeconfig.ChatDeleteActionNothing {
+		action = bridgeconfig.ChatDeleteActionDelete
+	}
+	log.Debug().Msg("Handling deleted remote chat")
+	var err error
+	switch action {
+	case bridgeconfig.ChatDeleteActionNothing:
+		return
+	case bridgeconfig.ChatDeleteActionArchive:
+		err = portal.archiveRoom(ctx, notice)
+	case bridgeconfig.ChatDeleteActionReadOnly:
+		err = portal.makeRoomReadOnly(ctx, notice)
+	case bridgeconfig.ChatDeleteActionDelete:
+		err = portal.DeleteWithOpts(ctx, &DeletePortalOpts{CleanupRoom: true, KickUsers: true})
+	default:
+		// Unknown actions are rejected when loading the config, so this is only reachable if the config was modified at runtime
+		log.Warn().Msg("Unknown deleted chat action, not doing anything")
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to handle deleted remote chat")
+	}
+}
+
+// removeLoginFromPortal removes the given login from a shared portal, and kicks the Matrix user
+// if none of their other logins are in the portal.
+func (portal *Portal) removeLoginFromPortal(ctx context.Context, login *UserLogin, otherLogins []*UserLogin) {
+	log := zerolog.Ctx(ctx)
+	up, err := portal.Bridge.DB.UserPortal.Get(ctx, login.UserLogin, portal.PortalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to get user portal row")
+	} else if up != nil {
+		err = portal.Bridge.DB.UserPortal.Delete(ctx, up)
+		if err != nil {
+			log.Err(err).Msg("Failed to delete user portal row")
+		}
+	}
+	login.inPortalCache.Remove(portal.PortalKey)
+	if portal.MXID == "" || slices.ContainsFunc(otherLogins, func(other *UserLogin) bool {
+		return other.UserMXID == login.UserMXID
+	}) {
+		return
+	}
+	portal.kickFromRoom(ctx, login.UserMXID, "Left the chat on the remote network")
+}
+
+func (portal *Portal) kickFromRoom(ctx context.Context, userID id.UserID, reason string) {
+	_, err := portal.Bridge.Bot.SendState(ctx, portal.MXID, event.StateMember, userID.String(), &event.Content{
+		Parsed: &event.MemberEventContent{
+			Membership: event.MembershipLeave,
+			Reason:     reason,
+		},
+	}, time.Time{})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to remove user from portal room")
+	}
+}
+
+func (portal *Portal) sendBotNotice(ctx context.Context, notice string) {
+	_, err := portal.Bridge.Bot.SendMessage(ctx, portal.MXID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    notice,
+		},
+	}, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send notice to portal room")
+	}
+}
+
+// archiveRoom posts a notice in the room, removes all ghosts and disassociates the room from the remote chat.
+// Matrix users are left in the room so that they can still read the history.
+func (portal *Portal) archiveRoom(ctx context.Context, notice string) error {
+	portal.sendBotNotice(ctx, notice)
+	return portal.DeleteWithOpts(ctx, &DeletePortalOpts{CleanupRoom: true, LeaveRoom: true, Reason: "Chat archived"})
+}
+
+// leaveRoom removes all ghosts (and optionally Matrix users) from the portal room, then makes the bridge bot leave.
+func (portal *Portal) leaveRoom(ctx context.Context, kickUsers bool, reason string) error {
+	members, err := portal.Bridge.Matrix.GetMembers(ctx, portal.MXID)
+	if err != nil {
+		return fmt.Errorf("failed to get room members: %w", err)
+	}
+	botMXID := portal.Bridge.Bot.GetMXID()
+	for userID, member := range members {
+		if member.Membership != event.MembershipJoin || userID == botMXID {
+			continue
+		} else if kickUsers || portal.Bridge.IsGhostMXID(userID) {
+			portal.kickFromRoom(ctx, userID, reason)
+		}
+	}
+	portal.kickFromRoom(ctx, botMXID, reason)
+	return nil
+}
+
+// makeRoomReadOnly posts a notice in the room and raises the power level required to send events
+// to the level of the bridge bot. The portal stays bridged.
+func (portal *Portal) makeRoomReadOnly(ctx context.Context, notice string) error {
+	portal.sendBotNotice(ctx, notice)
+	pl, err := portal.Bridge.Matrix.GetPowerLevels(ctx, portal.MXID)
+	if err != nil {
+		return fmt.Errorf("failed to get power levels: %w", err)
+	}
+	botLevel := pl.GetUserLevel(portal.Bridge.Bot.GetMXID())
+	if pl.EventsDefault >= botLevel {
+		return nil
+	}
+	pl.EventsDefault = botLevel
+	_, err = portal.sendStateWithIntentOrBot(ctx, nil, event.StatePowerLevels, "", &event.Content{Parsed: pl}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to update power levels: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const testBotMXID = id.UserID("@bot:example.com")
+
+// testRoomBot is a MatrixAPI that records room cleanup calls. Methods that aren't overridden panic if called.
+type testRoomBot struct {
+	MatrixAPI
+	deletedRooms []id.RoomID
+	puppetsOnly  bool
+	deleteErr    error
+	kicked       []id.UserID
+}
+
+func (trb *testRoomBot) GetMXID() id.UserID {
+	return testBotMXID
+}
+
+func (trb *testRoomBot) DeleteRoom(ctx context.Context, roomID id.RoomID, puppetsOnly bool) error {
+	trb.deletedRooms = append(trb.deletedRooms, roomID)
+	trb.puppetsOnly = puppetsOnly
+	return trb.deleteErr
+}
+
+func (trb *testRoomBot) SendState(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, content *event.Content, ts time.Time) (*mautrix.RespSendEvent, error) {
+	if eventType == event.StateMember && content.AsMember().Membership == event.MembershipLeave {
+		trb.kicked = append(trb.kicked, id.UserID(stateKey))
+	}
+	return &mautrix.RespSendEvent{}, nil
+}
+
+type testMemberMatrix struct {
+	testMatrix
+}
+
+func (tmm *testMemberMatrix) GetMembers(ctx context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error) {
+	return map[id.UserID]*event.MemberEventContent{
+		testBotMXID:               {Membership: event.MembershipJoin},
+		"@test_ghost:example.com": {Membership: event.MembershipJoin},
+		"@alice:example.com":      {Membership: event.MembershipJoin},
+		"@bob:example.com":        {Membership: event.MembershipLeave},
+	}, nil
+}
+
+func (tmm *testMemberMatrix) ParseGhostMXID(userID id.UserID) (networkid.UserID, bool) {
+	ghostID, isGhost := strings.CutPrefix(string(userID), "@test_")
+	return networkid.UserID(ghostID), isGhost
+}
+
+func newTestDeletePortal(t *testing.T) (*Portal, *testRoomBot) {
+	t.Helper()
+	br, _, _ := newTestBridgeWithLogin(t)
+	br.Matrix = &testMemberMatrix{}
+	bot := &testRoomBot{}
+	br.Bot = bot
+	return newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com"), bot
+}
+
+func assertTestPortalDeleted(t *testing.T, portal *Portal) {
+	t.Helper()
+	dbPortal, err := portal.Bridge.DB.Portal.GetByKey(context.Background(), portal.PortalKey)
+	require.NoError(t, err)
+	assert.Nil(t, dbPortal)
+	portal.Bridge.cacheLock.Lock()
+	_, cached := portal.Bridge.portalsByMXID["!chat:example.com"]
+	portal.Bridge.cacheLock.Unlock()
+	assert.False(t, cached)
+}
+
+func TestPortal_Delete(t *testing.T) {
+	portal, bot := newTestDeletePortal(t)
+	require.NoError(t, portal.Delete(context.Background()))
+	assertTestPortalDeleted(t, portal)
+	assert.Empty(t, bot.deletedRooms, "the room shouldn't be touched without options")
+	assert.Empty(t, bot.kicked)
+}
+
+func TestPortal_DeleteWithOpts_DeleteRoom(t *testing.T) {
+	portal, bot := newTestDeletePortal(t)
+	require.NoError(t, portal.DeleteWithOpts(context.Background(), &DeletePortalOpts{CleanupRoom: true, KickUsers: true}))
+	assertTestPortalDeleted(t, portal)
+	assert.Equal(t, []id.RoomID{"!chat:example.com"}, bot.deletedRooms)
+	assert.False(t, bot.puppetsOnly)
+
+	portal, bot = newTestDeletePortal(t)
+	require.NoError(t, portal.DeleteWithOpts(context.Background(), &DeletePortalOpts{CleanupRoom: true}))
+	assert.True(t, bot.puppetsOnly, "only ghosts should be removed when keeping users")
+}
+
+func TestPortal_DeleteWithOpts_LeaveRoom(t *testing.T) {
+	portal, bot := newTestDeletePortal(t)
+	require.NoError(t, portal.DeleteWithOpts(context.Background(), &DeletePortalOpts{CleanupRoom: true, LeaveRoom: true}))
+	assertTestPortalDeleted(t, portal)
+	assert.Empty(t, bot.deletedRooms)
+	assert.Equal(t, []id.UserID{"@test_ghost:example.com", testBotMXID}, bot.kicked, "only ghosts should be kicked before the bot leaves")
+
+	portal, bot = newTestDeletePortal(t)
+	require.NoError(t, portal.DeleteWithOpts(context.Background(), &DeletePortalOpts{CleanupRoom: true, LeaveRoom: true, KickUsers: true}))
+	assert.ElementsMatch(t, []id.UserID{"@test_ghost:example.com", "@alice:example.com", testBotMXID}, bot.kicked)
+	assert.Equal(t, testBotMXID, bot.kicked[len(bot.kicked)-1], "the bot should leave last")
+}
+
+func TestPortal_DeleteWithOpts_CleanupFailed(t *testing.T) {
+	portal, bot := newTestDeletePortal(t)
+	bot.deleteErr = errors.New("room not found")
+	err := portal.DeleteWithOpts(context.Background(), &DeletePortalOpts{CleanupRoom: true, KickUsers: true})
+	assert.ErrorIs(t, err, ErrPortalRoomCleanupFailed)
+	assertTestPortalDeleted(t, portal)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+var (
+	ErrDisappearingMessagesNotSupported    = errors.New("this bridge does not support disappearing messages")
+	ErrDisappearingTimerChangeNotSupported = errors.New("this bridge does not support changing the disappearing timer from Matrix")
+)
+
+// SetDisappearingTimer changes the disappearing message timer of the portal on behalf of the given user.
+//
+// The change is first applied on the remote network using the sender's login via [DisappearingTimerChangingNetworkAPI].
+// If the network connector accepts it, the portal's Disappear setting is updated and saved, and a notice is sent
+// to the Matrix room. The returned bool is false if the setting didn't change.
+func (portal *Portal) SetDisappearingTimer(ctx context.Context, sender *User, setting database.DisappearingSetting) (bool, error) {
+	if !portal.Bridge.Network.GetCapabilities().DisappearingMessages {
+		return false, ErrDisappearingMessagesNotSupported
+	}
+	if setting.Timer == 0 {
+		setting.Type = database.DisappearingTypeNone
+	} else if setting.Type == database.DisappearingTypeNone {
+		setting.Type = database.DisappearingTypeAfterSend
+	}
+	setting.DisappearAt = time.Time{}
+	if portal.Disappear.Timer == setting.Timer && portal.Disappear.Type == setting.Type {
+		return false, nil
+	}
+	login, _, err := portal.FindPreferredLogin(ctx, sender, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to find login: %w", err)
+	}
+	api, ok := login.Client.(DisappearingTimerChangingNetworkAPI)
+	if !ok {
+		return false, ErrDisappearingTimerChangeNotSupported
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "set disappearing timer").
+		Str("login_id", string(login.ID)).
+		Dur("new_timer", setting.Timer).
+		Str("new_type", string(setting.Type)).
+		Logger()
+	ctx = log.WithContext(ctx)
+	changed, err := api.HandleMatrixDisappearingTimer(ctx, &MatrixDisappearingTimer{
+		Portal:      portal,
+		Sender:      sender,
+		Setting:     setting,
+		PrevSetting: portal.Disappear,
+	})
+	if err != nil {
+		return false, err
+	} else if !changed {
+		log.Debug().Msg("Network connector didn't change disappearing timer")
+		return false, nil
+	}
+	return portal.UpdateDisappearingSetting(ctx, setting, nil, time.Now(), false, true), nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// PortalDoctorMessageSampleSize is the number of latest messages whose Matrix event IDs are checked by [Portal.Diagnose].
+var PortalDoctorMessageSampleSize = 20
+
+type PortalProblemType string
+
+const (
+	PortalProblemRoomGone        PortalProblemType = "room_gone"
+	PortalProblemBotPowerLevel   PortalProblemType = "bot_power_level"
+	PortalProblemMissingGhost    PortalProblemType = "missing_ghost"
+	PortalProblemExtraGhost      PortalProblemType = "extra_ghost"
+	PortalProblemEncryption      PortalProblemType = "encryption"
+	PortalProblemUnresolvedEvent PortalProblemType = "unresolved_event"
+)
+
+// PortalProblem is an inconsistency between the portal's database row, its Matrix room and the remote chat.
+type PortalProblem struct {
+	Type        PortalProblemType
+	Description string
+	// Whether the problem can be fixed safely by [Portal.Diagnose]. Irreversible changes are never considered safe.
+	Fixable bool
+	Fixed   bool
+	FixErr  error
+}
+
+// Diagnose checks that the portal's Matrix room matches the bridge's view of the chat and returns the problems found.
+// If fix is true, problems that can be repaired without losing data are repaired.
+//
+// The source login is used to fetch the remote member list. If it's nil, the member list isn't checked.
+func (portal *Portal) Diagnose(ctx context.Context, source *UserLogin, fix bool) ([]*PortalProblem, error) {
+	if portal.MXID == "" {
+		return nil, fmt.Errorf("portal doesn't have a Matrix room")
+	}
+	botMember, err := portal.Bridge.Matrix.GetMemberInfo(ctx, portal.MXID, portal.Bridge.Bot.GetMXID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bot membership: %w", err)
+	} else if botMember == nil || botMember.Membership != event.MembershipJoin {
+		return []*PortalProblem{{
+			Type:        PortalProblemRoomGone,
+			Description: "The bridge bot isn't in the room or the room doesn't exist",
+		}}, nil
+	}
+	var problems []*PortalProblem
+	for _, check := range []func(context.Context, *UserLogin) ([]*PortalProblem, error){
+		portal.diagnoseBotPowerLevel,
+		portal.diagnoseGhostMembers,
+		portal.diagnoseEncryption,
+		portal.diagnoseMessageEvents,
+	} {
+		newProblems, err := check(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, newProblems...)
+	}
+	if fix {
+		portal.fixProblems(ctx, source, problems)
+	}
+	return problems, nil
+}
+
+func (portal *Portal) diagnoseBotPowerLevel(ctx context.Context, _ *UserLogin) ([]*PortalProblem, error) {
+	pl, err := portal.Bridge.Matrix.GetPowerLevels(ctx, portal.MXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	botLevel := pl.GetUserLevel(portal.Bridge.Bot.GetMXID())
+	var missing []string
+	for _, evtType := range []event.Type{event.StatePowerLevels, event.StateRoomName, event.StateRoomAvatar, event.StateTopic, event.StateMember} {
+		if botLevel < pl.GetEventLevel(evtType) {
+			missing = append(missing, evtType.Type)
+		}
+	}
+	if botLevel < pl.Kick() {
+		missing = append(missing, "kick")
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return []*PortalProblem{{
+		Type:        PortalProblemBotPowerLevel,
+		Description: fmt.Sprintf("The bridge bot's power level (%d) is too low for %v", botLevel, missing),
+	}}, nil
+}
+
+func (portal *Portal) diagnoseGhostMembers(ctx context.Context, source *UserLogin) ([]*PortalProblem, error) {
+	if source == nil {
+		return nil, nil
+	}
+	info, err := source.Client.GetChatInfo(ctx, portal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat info: %w", err)
+	} else if info == nil || info.Members == nil {
+		return nil, nil
+	}
+	info.Members.memberListToMap(ctx)
+	currentMembers, err := portal.Bridge.Matrix.GetMembers(ctx, portal.MXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current members: %w", err)
+	}
+	expectedGhosts := make(map[id.UserID]struct{}, len(info.Members.MemberMap))
+	var problems []*PortalProblem
+	for _, member := range info.Members.MemberMap {
+		if member.Membership != "" && member.Membership != event.MembershipJoin {
+			continue
+		}
+		intent, _ := portal.getIntentAndUserMXIDFor(ctx, member.EventSender, source, nil, 0)
+		if intent == nil || !portal.Bridge.IsGhostMXID(intent.GetMXID()) {
+			continue
+		}
+		ghostMXID := intent.GetMXID()
+		expectedGhosts[ghostMXID] = struct{}{}
+		if currentMember, ok := currentMembers[ghostMXID]; !ok || currentMember.Membership != event.MembershipJoin {
+			problems = append(problems, &PortalProblem{
+				Type:        PortalProblemMissingGhost,
+				Description: fmt.Sprintf("+ %s is in the remote chat, but not in the room", ghostMXID),
+				Fixable:     true,
+			})
+		}
+	}
+	if info.Members.IsFull {
+		for userID, member := range currentMembers {
+			if member.Membership != event.MembershipJoin || !portal.Bridge.IsGhostMXID(userID) {
+				continue
+			} else if _, expected := expectedGhosts[userID]; !expected {
+				problems = append(problems, &PortalProblem{
+					Type:        PortalProblemExtraGhost,
+					Description: fmt.Sprintf("- %s is in the room, but not in the remote chat", userID),
+					Fixable:     true,
+				})
+			}
+		}
+	}
+	slices.SortFunc(problems, func(a, b *PortalProblem) int {
+		return strings.Compare(a.Description, b.Description)
+	})
+	return problems, nil
+}
+
+func (portal *Portal) diagnoseEncryption(ctx context.Context, _ *UserLogin) ([]*PortalProblem, error) {
+	encMatrix, ok := portal.Bridge.Matrix.(MatrixConnectorWithEncryptionState)
+	if !ok {
+		return nil, nil
+	}
+	encrypted, err := encMatrix.IsRoomEncrypted(ctx, portal.MXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if room is encrypted: %w", err)
+	}
+	if encMatrix.ShouldEncryptRooms() && !encrypted {
+		return []*PortalProblem{{
+			Type:        PortalProblemEncryption,
+			Description: "The room isn't encrypted, but encryption is enabled by default in the config",
+		}}, nil
+	}
+	// Encryption can't be disabled once enabled, so the doctor only reports mismatches instead of fixing them.
+	// Encrypted rooms when encryption isn't default are fine for the same reason.
+	return nil, nil
+}
+
+func (portal *Portal) diagnoseMessageEvents(ctx context.Context, _ *UserLogin) ([]*PortalProblem, error) {
+	messages, err := portal.Bridge.DB.Message.GetLastNInPortal(ctx, portal.PortalKey, PortalDoctorMessageSampleSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest messages: %w", err)
+	}
+	lookupMatrix, canLookup := portal.Bridge.Matrix.(MatrixConnectorWithEventLookup)
+	var problems []*PortalProblem
+	for _, msg := range messages {
+		if msg.MXID == "" {
+			problems = append(problems, &PortalProblem{
+				Type:        PortalProblemUnresolvedEvent,
+				Description: fmt.Sprintf("Message %s (part %q) doesn't have a Matrix event ID", msg.ID, msg.PartID),
+			})
+			continue
+		}
+		byMXID, err := portal.Bridge.DB.Message.GetPartByMXID(ctx, msg.MXID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message by event ID: %w", err)
+		} else if byMXID == nil || byMXID.RowID != msg.RowID {
+			problems = append(problems, &PortalProblem{
+				Type:        PortalProblemUnresolvedEvent,
+				Description: fmt.Sprintf("Event ID %s of message %s resolves to a different message", msg.MXID, msg.ID),
+			})
+			continue
+		}
+		if canLookup {
+			exists, err := lookupMatrix.EventExists(ctx, portal.MXID, msg.MXID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check if %s exists: %w", msg.MXID, err)
+			} else if !exists {
+				problems = append(problems, &PortalProblem{
+					Type:        PortalProblemUnresolvedEvent,
+					Description: fmt.Sprintf("Event %s of message %s doesn't exist in the room", msg.MXID, msg.ID),
+				})
+			}
+		}
+	}
+	return problems, nil
+}
+
+func (portal *Portal) fixProblems(ctx context.Context, source *UserLogin, problems []*PortalProblem) {
+	var membersSynced bool
+	var membersErr error
+	for _, problem := range problems {
+		if !problem.Fixable {
+			continue
+		}
+		switch problem.Type {
+		case PortalProblemMissingGhost, PortalProblemExtraGhost:
+			if !membersSynced {
+				membersSynced = true
+				membersErr = portal.resyncMembers(ctx, source)
+			}
+			problem.FixErr = membersErr
+		default:
+			continue
+		}
+		problem.Fixed = problem.FixErr == nil
+	}
+}
+
+func (portal *Portal) resyncMembers(ctx context.Context, source *UserLogin) error {
+	info, err := source.Client.GetChatInfo(ctx, portal)
+	if err != nil {
+		return fmt.Errorf("failed to get chat info: %w", err)
+	} else if info == nil || info.Members == nil {
+		return errors.New("chat info didn't contain members")
+	}
+	return portal.syncParticipants(ctx, info.Members, source, nil, time.Now())
+}
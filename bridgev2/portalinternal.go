@@ -297,6 +297,10 @@ func (portal *PortalInternals) DoForwardBackfill(ctx context.Context, source *Us
 	(*Portal)(portal).doForwardBackfill(ctx, source, lastMessage, bundledData)
 }
 
+func (portal *PortalInternals) ShouldDeferBackfillMedia() bool {
+	return (*Portal)(portal).shouldDeferBackfillMedia()
+}
+
 func (portal *PortalInternals) FetchThreadBackfill(ctx context.Context, source *UserLogin, anchor *database.Message) *FetchMessagesResponse {
 	return (*Portal)(portal).fetchThreadBackfill(ctx, source, anchor)
 }
@@ -309,14 +313,18 @@ func (portal *PortalInternals) CutoffMessages(ctx context.Context, messages []*B
 	return (*Portal)(portal).cutoffMessages(ctx, messages, aggressiveDedup, forward, lastMessage)
 }
 
-func (portal *PortalInternals) SendBackfill(ctx context.Context, source *UserLogin, messages []*BackfillMessage, forceForward, markRead, inThread bool, done func()) {
-	(*Portal)(portal).sendBackfill(ctx, source, messages, forceForward, markRead, inThread, done)
+func (portal *PortalInternals) SendBackfill(ctx context.Context, source *UserLogin, messages []*BackfillMessage, receipts []*BackfillReadReceipt, forceForward, markRead, inThread bool, done func()) {
+	(*Portal)(portal).sendBackfill(ctx, source, messages, receipts, forceForward, markRead, inThread, done)
 }
 
 func (portal *PortalInternals) CompileBatchMessage(ctx context.Context, source *UserLogin, msg *BackfillMessage, out *compileBatchOutput, inThread bool) {
 	(*Portal)(portal).compileBatchMessage(ctx, source, msg, out, inThread)
 }
 
+func (portal *PortalInternals) IsDuplicateBackfillReaction(ctx context.Context, target *database.Message, reaction *BackfillReaction, seen map[backfillReactionKey]bool) bool {
+	return (*Portal)(portal).isDuplicateBackfillReaction(ctx, target, reaction, seen)
+}
+
 func (portal *PortalInternals) FetchThreadInsideBatch(ctx context.Context, source *UserLogin, dbMsg *database.Message, out *compileBatchOutput) {
 	(*Portal)(portal).fetchThreadInsideBatch(ctx, source, dbMsg, out)
 }
@@ -329,6 +337,10 @@ func (portal *PortalInternals) SendLegacyBackfill(ctx context.Context, source *U
 	(*Portal)(portal).sendLegacyBackfill(ctx, source, messages, markRead)
 }
 
+func (portal *PortalInternals) SendBackfillReadReceipts(ctx context.Context, source *UserLogin, receipts []*BackfillReadReceipt) {
+	(*Portal)(portal).sendBackfillReadReceipts(ctx, source, receipts)
+}
+
 func (portal *PortalInternals) UnlockedReID(ctx context.Context, target networkid.PortalKey) error {
 	return (*Portal)(portal).unlockedReID(ctx, target)
 }
@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrInsufficientPowerLevel  = errors.New("you don't have a high enough power level in this room")
+	ErrRemoteModerationDenied  = errors.New("you don't have permission to do that on the remote network")
+	ErrModerationTargetMissing = errors.New("user is not in this room")
+)
+
+// ModerationResult describes how a moderation action was applied by [Portal.Moderate].
+type ModerationResult struct {
+	// The ID of the Matrix event that was sent to apply the action in the room.
+	EventID id.EventID
+	// True if the action was also bridged to the remote network.
+	BridgedToRemote bool
+}
+
+// Moderate kicks, bans or mutes the target user in the portal on behalf of the given user.
+//
+// The sender must have a high enough power level in the Matrix room for the action, and must outrank the target.
+// Bridge admins skip the power level check. If the sender has a login in the portal and the target is a ghost
+// or another user login, the action is also bridged to the remote network if the network connector supports it.
+// Connectors can implement [ModerationCheckingNetworkAPI] to deny actions based on the remote roles of the sender.
+//
+// The action is applied on Matrix by the bridge bot, so the bot must have the necessary power level too.
+// If the action was applied on Matrix, but bridging it to the remote network failed, both the result
+// and the error are returned.
+func (portal *Portal) Moderate(ctx context.Context, sender *User, action ModerationAction, targetMXID id.UserID, reason string) (*ModerationResult, error) {
+	if portal.MXID == "" {
+		return nil, fmt.Errorf("portal doesn't have a Matrix room")
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "moderate portal").
+		Str("moderation_action", string(action)).
+		Stringer("target_user_id", targetMXID).
+		Logger()
+	ctx = log.WithContext(ctx)
+	levels, err := portal.Bridge.Matrix.GetPowerLevels(ctx, portal.MXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	if !sender.Permissions.Admin {
+		senderLevel := levels.GetUserLevel(sender.MXID)
+		if senderLevel < getModerationLevel(levels, action) || senderLevel <= levels.GetUserLevel(targetMXID) {
+			return nil, ErrInsufficientPowerLevel
+		}
+	}
+	prevMember, err := portal.Bridge.Matrix.GetMemberInfo(ctx, portal.MXID, targetMXID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target member info: %w", err)
+	} else if prevMember == nil {
+		prevMember = &event.MemberEventContent{Membership: event.MembershipLeave}
+	}
+	if action == ModerationActionKick && prevMember.Membership != event.MembershipJoin && prevMember.Membership != event.MembershipInvite {
+		return nil, ErrModerationTargetMissing
+	}
+	target, err := portal.getTargetUser(ctx, targetMXID)
+	if err != nil {
+		return nil, err
+	}
+	var login *UserLogin
+	if target != nil {
+		login, _, err = portal.FindPreferredLogin(ctx, sender, false)
+		if errors.Is(err, ErrNotLoggedIn) {
+			log.Debug().Msg("Sender isn't logged in, only applying moderation action on Matrix")
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to find login: %w", err)
+		}
+	}
+	if login != nil {
+		if checker, ok := login.Client.(ModerationCheckingNetworkAPI); ok {
+			allowed, err := checker.CanModerate(ctx, portal, action, target)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check remote permissions: %w", err)
+			} else if !allowed {
+				return nil, ErrRemoteModerationDenied
+			}
+		}
+	}
+
+	evt := &event.Event{
+		Sender:    sender.MXID,
+		RoomID:    portal.MXID,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	var stateKey string
+	switch action {
+	case ModerationActionKick, ModerationActionBan:
+		membership := event.MembershipLeave
+		if action == ModerationActionBan {
+			membership = event.MembershipBan
+		}
+		stateKey = targetMXID.String()
+		evt.Type = event.StateMember
+		evt.Content.Parsed = &event.MemberEventContent{Membership: membership, Reason: reason}
+		evt.Unsigned.PrevContent = &event.Content{Parsed: prevMember}
+	case ModerationActionMute:
+		newLevels := levels.Clone()
+		newLevels.SetUserLevel(targetMXID, levels.EventsDefault-1)
+		evt.Type = event.StatePowerLevels
+		evt.Content.Parsed = newLevels
+		evt.Unsigned.PrevContent = &event.Content{Parsed: levels}
+	default:
+		return nil, fmt.Errorf("unknown moderation action %q", action)
+	}
+	evt.StateKey = &stateKey
+	resp, err := portal.Bridge.Bot.SendState(ctx, portal.MXID, evt.Type, stateKey, &evt.Content, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s event: %w", evt.Type.Type, err)
+	}
+	evt.ID = resp.EventID
+	result := &ModerationResult{EventID: resp.EventID}
+	if login != nil {
+		result.BridgedToRemote, err = portal.moderateRemote(ctx, login, action, evt, target, targetMXID)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func getModerationLevel(levels *event.PowerLevelsEventContent, action ModerationAction) int {
+	switch action {
+	case ModerationActionKick:
+		return levels.Kick()
+	case ModerationActionBan:
+		return levels.Ban()
+	default:
+		return levels.GetEventLevel(event.StatePowerLevels)
+	}
+}
+
+func (portal *Portal) moderateRemote(
+	ctx context.Context,
+	login *UserLogin,
+	action ModerationAction,
+	evt *event.Event,
+	target GhostOrUserLogin,
+	targetMXID id.UserID,
+) (bool, error) {
+	switch action {
+	case ModerationActionKick, ModerationActionBan:
+		api, ok := login.Client.(MembershipHandlingNetworkAPI)
+		if !ok {
+			return false, nil
+		}
+		content := evt.Content.Parsed.(*event.MemberEventContent)
+		prevContent := evt.Unsigned.PrevContent.Parsed.(*event.MemberEventContent)
+		targetGhost, _ := target.(*Ghost)
+		targetUserLogin, _ := target.(*UserLogin)
+		_, err := api.HandleMatrixMembership(ctx, &MatrixMembershipChange{
+			MatrixRoomMeta: MatrixRoomMeta[*event.MemberEventContent]{
+				MatrixEventBase: MatrixEventBase[*event.MemberEventContent]{
+					Event:   evt,
+					Content: content,
+					Portal:  portal,
+				},
+				PrevContent: prevContent,
+			},
+			Target:          target,
+			TargetGhost:     targetGhost,
+			TargetUserLogin: targetUserLogin,
+			Type:            MembershipChangeType{From: prevContent.Membership, To: content.Membership},
+		})
+		return err == nil, err
+	case ModerationActionMute:
+		api, ok := login.Client.(PowerLevelHandlingNetworkAPI)
+		if !ok {
+			return false, nil
+		}
+		content := evt.Content.Parsed.(*event.PowerLevelsEventContent)
+		prevContent := evt.Unsigned.PrevContent.Parsed.(*event.PowerLevelsEventContent)
+		_, err := api.HandleMatrixPowerLevels(ctx, &MatrixPowerLevelChange{
+			MatrixRoomMeta: MatrixRoomMeta[*event.PowerLevelsEventContent]{
+				MatrixEventBase: MatrixEventBase[*event.PowerLevelsEventContent]{
+					Event:   evt,
+					Content: content,
+					Portal:  portal,
+				},
+				PrevContent: prevContent,
+			},
+			Users: map[id.UserID]*UserPowerLevelChange{
+				targetMXID: {
+					Target: target,
+					SinglePowerLevelChange: SinglePowerLevelChange{
+						OrigLevel: prevContent.GetUserLevel(targetMXID),
+						NewLevel:  content.GetUserLevel(targetMXID),
+						NewIsSet:  true,
+					},
+				},
+			},
+			Events: make(map[string]*SinglePowerLevelChange),
+		})
+		return err == nil, err
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func (portal *Portal) handleRemotePin(ctx context.Context, source *UserLogin, evt RemotePin) {
+	log := zerolog.Ctx(ctx)
+	pinGetter, ok := portal.Bridge.Matrix.(MatrixConnectorWithPinnedEvents)
+	if !ok {
+		log.Warn().Msg("Matrix connector doesn't support getting pinned events, ignoring remote pin")
+		return
+	}
+	targetMessage, err := portal.getTargetMessagePart(ctx, evt)
+	if err != nil {
+		log.Err(err).Msg("Failed to get target message for pin")
+		return
+	} else if targetMessage == nil {
+		log.Warn().Msg("Target message for pin not found")
+		return
+	}
+	pinned, err := pinGetter.GetPinnedEvents(ctx, portal.MXID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get current pinned events")
+		return
+	}
+	shouldPin := evt.GetPinned()
+	if slices.Contains(pinned, targetMessage.MXID) == shouldPin {
+		log.Debug().Stringer("event_id", targetMessage.MXID).Bool("pinned", shouldPin).Msg("Pin state is already up to date")
+		return
+	}
+	if shouldPin {
+		pinned = append(pinned, targetMessage.MXID)
+	} else {
+		pinned = slices.DeleteFunc(pinned, func(eventID id.EventID) bool {
+			return eventID == targetMessage.MXID
+		})
+	}
+	intent := portal.GetIntentFor(ctx, evt.GetSender(), source, RemoteEventPin)
+	portal.sendRoomMeta(ctx, intent, getEventTS(evt), event.StatePinnedEvents, "", &event.PinnedEventsEventContent{Pinned: pinned})
+}
+
+func (portal *Portal) getPinChangeMessages(ctx context.Context, eventIDs []id.EventID) ([]*database.Message, error) {
+	messages := make([]*database.Message, 0, len(eventIDs))
+	for _, eventID := range eventIDs {
+		msg, err := portal.Bridge.DB.Message.GetPartByMXID(ctx, eventID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %s: %w", eventID, err)
+		} else if msg != nil && msg.Room == portal.PortalKey {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (portal *Portal) handleMatrixPinnedEvents(ctx context.Context, sender *UserLogin, origSender *OrigSender, evt *event.Event) {
+	log := zerolog.Ctx(ctx)
+	content, ok := evt.Content.Parsed.(*event.PinnedEventsEventContent)
+	if !ok {
+		log.Error().Type("content_type", evt.Content.Parsed).Msg("Unexpected parsed content type")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("%w: %T", ErrUnexpectedParsedContentType, evt.Content.Parsed))
+		return
+	}
+	api, ok := sender.Client.(PinHandlingNetworkAPI)
+	if !ok {
+		portal.sendErrorStatus(ctx, evt, ErrPinningNotSupported)
+		return
+	}
+	var prevContent *event.PinnedEventsEventContent
+	if evt.Unsigned.PrevContent != nil {
+		_ = evt.Unsigned.PrevContent.ParseRaw(evt.Type)
+		prevContent, _ = evt.Unsigned.PrevContent.Parsed.(*event.PinnedEventsEventContent)
+	}
+	var prevPinned []id.EventID
+	if prevContent != nil {
+		prevPinned = prevContent.Pinned
+	}
+	var added, removed []id.EventID
+	for _, eventID := range content.Pinned {
+		if !slices.Contains(prevPinned, eventID) {
+			added = append(added, eventID)
+		}
+	}
+	for _, eventID := range prevPinned {
+		if !slices.Contains(content.Pinned, eventID) {
+			removed = append(removed, eventID)
+		}
+	}
+	pinnedMessages, err := portal.getPinChangeMessages(ctx, added)
+	if err != nil {
+		log.Err(err).Msg("Failed to get pinned messages")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("%w: %w", ErrDatabaseError, err))
+		return
+	}
+	unpinnedMessages, err := portal.getPinChangeMessages(ctx, removed)
+	if err != nil {
+		log.Err(err).Msg("Failed to get unpinned messages")
+		portal.sendErrorStatus(ctx, evt, fmt.Errorf("%w: %w", ErrDatabaseError, err))
+		return
+	}
+	if len(pinnedMessages) == 0 && len(unpinnedMessages) == 0 {
+		log.Debug().Msg("No bridged messages were pinned or unpinned")
+		portal.sendSuccessStatus(ctx, evt, 0, "")
+		return
+	}
+	err = api.HandleMatrixPinChange(ctx, &MatrixPinChange{
+		MatrixRoomMeta: MatrixRoomMeta[*event.PinnedEventsEventContent]{
+			MatrixEventBase: MatrixEventBase[*event.PinnedEventsEventContent]{
+				Event:      evt,
+				Content:    content,
+				Portal:     portal,
+				OrigSender: origSender,
+			},
+			PrevContent: prevContent,
+		},
+		Pinned:   pinnedMessages,
+		Unpinned: unpinnedMessages,
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to handle Matrix pin change")
+		portal.sendErrorStatus(ctx, evt, err)
+		return
+	}
+	portal.sendSuccessStatus(ctx, evt, 0, "")
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// How often the progress notice is edited at most while a stage is in progress.
+const portalCreationProgressEditInterval = 3 * time.Second
+
+type PortalCreationStage string
+
+const (
+	PortalCreationStageGhosts  PortalCreationStage = "ghosts"
+	PortalCreationStageMembers PortalCreationStage = "members"
+)
+
+func (stage PortalCreationStage) description() string {
+	switch stage {
+	case PortalCreationStageGhosts:
+		return "Setting up member profiles"
+	case PortalCreationStageMembers:
+		return "Syncing members"
+	default:
+		return string(stage)
+	}
+}
+
+// portalCreationProgress posts a notice about the progress of creating a portal for a large chat
+// and edits it as the creation proceeds.
+//
+// The notice is sent to the management room of the user whose login is creating the portal.
+// If the user doesn't have a management room, progress isn't reported.
+type portalCreationProgress struct {
+	portal *Portal
+	roomID id.RoomID
+
+	lock     sync.Mutex
+	stage    PortalCreationStage
+	done     int
+	total    int
+	lastEdit time.Time
+
+	// sendLock is held while sending the notice, so that edits are only sent after the initial notice
+	sendLock sync.Mutex
+	eventID  id.EventID
+}
+
+type portalCreationProgressKey struct{}
+
+// newPortalCreationProgress returns a progress reporter if the chat is large enough for progress
+// reporting to be useful, or nil otherwise. All methods are safe to call on a nil reporter.
+func (portal *Portal) newPortalCreationProgress(source *UserLogin, info *ChatInfo) *portalCreationProgress {
+	threshold := portal.Bridge.Config.CreationProgressThreshold
+	if threshold <= 0 || source == nil || source.User.ManagementRoom == "" || info == nil || info.Members == nil {
+		return nil
+	}
+	memberCount := len(info.Members.MemberMap)
+	if info.Members.MemberMap == nil {
+		memberCount = len(info.Members.Members)
+	}
+	if memberCount < threshold {
+		return nil
+	}
+	return &portalCreationProgress{portal: portal, roomID: source.User.ManagementRoom}
+}
+
+func (pcp *portalCreationProgress) withContext(ctx context.Context) context.Context {
+	if pcp == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, portalCreationProgressKey{}, pcp)
+}
+
+func getPortalCreationProgress(ctx context.Context) *portalCreationProgress {
+	pcp, _ := ctx.Value(portalCreationProgressKey{}).(*portalCreationProgress)
+	return pcp
+}
+
+// Start begins a new stage of portal creation with the given number of steps.
+func (pcp *portalCreationProgress) Start(ctx context.Context, stage PortalCreationStage, total int) {
+	if pcp == nil {
+		return
+	}
+	pcp.lock.Lock()
+	pcp.stage = stage
+	pcp.done = 0
+	pcp.total = total
+	text := pcp.getProgressText(true)
+	pcp.lock.Unlock()
+	pcp.send(ctx, text, true)
+}
+
+// Increment marks one step of the current stage as done.
+func (pcp *portalCreationProgress) Increment(ctx context.Context) {
+	if pcp == nil {
+		return
+	}
+	pcp.lock.Lock()
+	pcp.done++
+	text := pcp.getProgressText(pcp.done >= pcp.total)
+	pcp.lock.Unlock()
+	pcp.send(ctx, text, true)
+}
+
+// Finish edits the notice to say that the portal has been created, or that creating it failed.
+// Nothing is sent if no progress notice was sent yet.
+func (pcp *portalCreationProgress) Finish(ctx context.Context, err error) {
+	if pcp == nil {
+		return
+	}
+	if err != nil {
+		pcp.send(ctx, fmt.Sprintf("Failed to create portal: %v", err), false)
+	} else {
+		pcp.send(ctx, "Finished creating portal", false)
+	}
+}
+
+// getProgressText returns the text for the progress notice, or an empty string if the notice
+// was edited too recently. Must be called with the lock held.
+func (pcp *portalCreationProgress) getProgressText(force bool) string {
+	if !force && time.Since(pcp.lastEdit) < portalCreationProgressEditInterval {
+		return ""
+	}
+	pcp.lastEdit = time.Now()
+	if pcp.total > 0 {
+		return fmt.Sprintf("%s %d/%d…", pcp.stage.description(), pcp.done, pcp.total)
+	}
+	return pcp.stage.description() + "…"
+}
+
+func (pcp *portalCreationProgress) send(ctx context.Context, text string, inProgress bool) {
+	if text == "" {
+		return
+	}
+	pcp.sendLock.Lock()
+	defer pcp.sendLock.Unlock()
+	name := pcp.portal.Name
+	if name == "" {
+		name = string(pcp.portal.ID)
+	}
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    fmt.Sprintf("Creating portal for %s: %s", name, text),
+	}
+	if pcp.eventID != "" {
+		content.SetEdit(pcp.eventID)
+	} else if !inProgress {
+		return
+	}
+	resp, err := pcp.portal.Bridge.Bot.SendMessage(ctx, pcp.roomID, event.EventMessage, &event.Content{Parsed: content}, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", pcp.roomID).Msg("Failed to send portal creation progress notice")
+		return
+	}
+	if pcp.eventID == "" {
+		pcp.eventID = resp.EventID
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type testSentNotice struct {
+	roomID  id.RoomID
+	content *event.MessageEventContent
+}
+
+// testNoticeBot is a MatrixAPI that records sent messages. Methods that aren't overridden panic if called.
+type testNoticeBot struct {
+	MatrixAPI
+	lock sync.Mutex
+	sent []testSentNotice
+}
+
+func (tnb *testNoticeBot) SendMessage(ctx context.Context, roomID id.RoomID, eventType event.Type, content *event.Content, extra *MatrixSendExtra) (*mautrix.RespSendEvent, error) {
+	tnb.lock.Lock()
+	defer tnb.lock.Unlock()
+	tnb.sent = append(tnb.sent, testSentNotice{roomID: roomID, content: content.AsMessage()})
+	return &mautrix.RespSendEvent{EventID: id.EventID(fmt.Sprintf("$notice%d", len(tnb.sent)))}, nil
+}
+
+func (tnb *testNoticeBot) popSent() []testSentNotice {
+	tnb.lock.Lock()
+	defer tnb.lock.Unlock()
+	sent := tnb.sent
+	tnb.sent = nil
+	return sent
+}
+
+func newTestProgressChatInfo(memberCount int) *ChatInfo {
+	members := &ChatMemberList{MemberMap: make(map[networkid.UserID]ChatMember)}
+	for i := 0; i < memberCount; i++ {
+		userID := networkid.UserID(fmt.Sprintf("user%d", i))
+		members.MemberMap[userID] = ChatMember{EventSender: EventSender{Sender: userID}}
+	}
+	return &ChatInfo{Members: members}
+}
+
+func newTestProgressPortal(t *testing.T) (*Portal, *UserLogin, *testNoticeBot) {
+	t.Helper()
+	br, login, _ := newTestBridgeWithLogin(t)
+	br.Config.CreationProgressThreshold = 3
+	bot := &testNoticeBot{}
+	br.Bot = bot
+	login.User.ManagementRoom = "!management:example.com"
+	portal := newTestPortal(t, br, networkid.PortalKey{ID: "chat"}, "!chat:example.com")
+	portal.Name = "Big chat"
+	return portal, login, bot
+}
+
+func TestPortal_NewPortalCreationProgress(t *testing.T) {
+	portal, login, _ := newTestProgressPortal(t)
+	assert.Nil(t, portal.newPortalCreationProgress(login, newTestProgressChatInfo(2)), "small chats shouldn't report progress")
+	assert.NotNil(t, portal.newPortalCreationProgress(login, newTestProgressChatInfo(3)))
+	login.User.ManagementRoom = ""
+	assert.Nil(t, portal.newPortalCreationProgress(login, newTestProgressChatInfo(3)), "progress should only be sent to the management room")
+}
+
+func TestPortalCreationProgress(t *testing.T) {
+	ctx := context.Background()
+	portal, login, bot := newTestProgressPortal(t)
+	progress := portal.newPortalCreationProgress(login, newTestProgressChatInfo(3))
+	require.NotNil(t, progress)
+
+	progress.Start(ctx, PortalCreationStageMembers, 3)
+	sent := bot.popSent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, id.RoomID("!management:example.com"), sent[0].roomID)
+	assert.Equal(t, "Creating portal for Big chat: Syncing members 0/3…", sent[0].content.Body)
+	assert.Nil(t, sent[0].content.RelatesTo)
+
+	progress.Increment(ctx)
+	progress.Increment(ctx)
+	assert.Empty(t, bot.popSent(), "progress shouldn't be edited more often than the edit interval")
+	progress.Increment(ctx)
+	sent = bot.popSent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "Creating portal for Big chat: Syncing members 3/3…", sent[0].content.NewContent.Body)
+	assert.Equal(t, id.EventID("$notice1"), sent[0].content.RelatesTo.GetReplaceID())
+
+	progress.Finish(ctx, errors.New("meow"))
+	sent = bot.popSent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "Creating portal for Big chat: Failed to create portal: meow", sent[0].content.NewContent.Body)
+}
+
+func TestPortalCreationProgress_FinishWithoutNotice(t *testing.T) {
+	portal, login, bot := newTestProgressPortal(t)
+	progress := portal.newPortalCreationProgress(login, newTestProgressChatInfo(3))
+	progress.Finish(context.Background(), nil)
+	assert.Empty(t, bot.popSent())
+
+	var nilProgress *portalCreationProgress
+	nilProgress.Start(context.Background(), PortalCreationStageGhosts, 10)
+	nilProgress.Increment(context.Background())
+	nilProgress.Finish(context.Background(), nil)
+}
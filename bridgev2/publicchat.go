@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrPublicChatsNotSupported = errors.New("this bridge does not support public chats")
+
+// GetExistingPortal returns the portal for the public chat, if the network connector provided a portal key
+// and the portal already has a Matrix room.
+func (pc *PublicChat) GetExistingPortal(ctx context.Context, br *Bridge) (*Portal, error) {
+	if pc.PortalKey == nil {
+		return nil, nil
+	}
+	portal, err := br.GetExistingPortalByKey(ctx, *pc.PortalKey)
+	if err != nil || portal == nil || portal.MXID == "" {
+		return nil, err
+	}
+	return portal, nil
+}
+
+// JoinPublicChat joins a public chat found with [PublicChatSearchingNetworkAPI.SearchPublicChats]
+// and creates the portal room for it if it doesn't exist yet.
+//
+// The created return value is true if a new Matrix room was created.
+func (ul *UserLogin) JoinPublicChat(ctx context.Context, identifier string) (portal *Portal, created bool, err error) {
+	api, ok := ul.Client.(PublicChatSearchingNetworkAPI)
+	if !ok {
+		return nil, false, ErrPublicChatsNotSupported
+	}
+	resp, err := api.JoinPublicChat(ctx, identifier)
+	if err != nil {
+		return nil, false, err
+	} else if resp == nil {
+		return nil, false, fmt.Errorf("network connector didn't return chat after joining")
+	}
+	portal = resp.Portal
+	if portal == nil {
+		portal, err = ul.Bridge.GetPortalByKey(ctx, resp.PortalKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get portal: %w", err)
+		}
+	}
+	if resp.PortalInfo == nil {
+		resp.PortalInfo, err = api.GetChatInfo(ctx, portal)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get portal info: %w", err)
+		}
+	}
+	if portal.MXID != "" {
+		portal.UpdateInfo(ctx, resp.PortalInfo, ul, nil, time.Time{})
+		return portal, false, nil
+	}
+	err = portal.CreateMatrixRoom(ctx, ul, resp.PortalInfo)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create room: %w", err)
+	}
+	return portal, true, nil
+}
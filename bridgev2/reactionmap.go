@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"strings"
+	"sync"
+
+	"go.mau.fi/util/variationselector"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+// EmojiNormalization specifies how variation selectors in Matrix reaction keys are handled
+// before the reaction is sent to the remote network.
+type EmojiNormalization int
+
+const (
+	// EmojiNormalizationNone passes the reaction key through as-is. Matrix clients usually add variation selectors.
+	EmojiNormalizationNone EmojiNormalization = iota
+	// EmojiNormalizationRemove removes all emoji variation selectors.
+	EmojiNormalizationRemove
+	// EmojiNormalizationFullyQualify converts emojis to their fully-qualified form as defined by Unicode.
+	EmojiNormalizationFullyQualify
+)
+
+// ReactionMap describes how Matrix reaction keys are converted to reactions on the remote network and back.
+// Network connectors can provide one by implementing [ReactionMappingNetwork].
+//
+// The methods are safe to call on a nil map, in which case reaction keys are passed through unchanged.
+type ReactionMap struct {
+	// EmojiIDs maps unicode emojis to network-specific emoji IDs.
+	// Emojis are matched ignoring variation selectors, so they don't need to be normalized.
+	EmojiIDs map[string]networkid.EmojiID
+	// Normalization specifies how variation selectors are handled for emojis that aren't in EmojiIDs.
+	Normalization EmojiNormalization
+	// StripSkinTones removes skin tone modifiers, for networks that don't support them in reactions.
+	StripSkinTones bool
+	// OnlyMapped rejects reactions with keys that aren't in EmojiIDs.
+	OnlyMapped bool
+
+	initOnce sync.Once
+	forward  map[string]networkid.EmojiID
+	reverse  map[networkid.EmojiID]string
+}
+
+func (rm *ReactionMap) init() {
+	rm.forward = make(map[string]networkid.EmojiID, len(rm.EmojiIDs))
+	rm.reverse = make(map[networkid.EmojiID]string, len(rm.EmojiIDs))
+	for emoji, emojiID := range rm.EmojiIDs {
+		rm.forward[variationselector.Remove(emoji)] = emojiID
+		existing, ok := rm.reverse[emojiID]
+		// Prefer the shortest emoji if multiple ones map to the same ID to keep the result deterministic
+		if !ok || len(emoji) < len(existing) || (len(emoji) == len(existing) && emoji < existing) {
+			rm.reverse[emojiID] = emoji
+		}
+	}
+}
+
+var skinToneRemover = strings.NewReplacer(
+	"\U0001F3FB", "",
+	"\U0001F3FC", "",
+	"\U0001F3FD", "",
+	"\U0001F3FE", "",
+	"\U0001F3FF", "",
+)
+
+// ToRemote converts a Matrix reaction key to the emoji and emoji ID to send to the remote network.
+// If the map has OnlyMapped set and the key isn't in the map, ok will be false.
+func (rm *ReactionMap) ToRemote(key string) (emoji string, emojiID networkid.EmojiID, ok bool) {
+	if rm == nil {
+		return key, "", true
+	}
+	rm.initOnce.Do(rm.init)
+	emoji = key
+	if rm.StripSkinTones {
+		emoji = skinToneRemover.Replace(emoji)
+	}
+	emojiID, ok = rm.forward[variationselector.Remove(emoji)]
+	if !ok && rm.OnlyMapped {
+		return "", "", false
+	}
+	switch rm.Normalization {
+	case EmojiNormalizationRemove:
+		emoji = variationselector.Remove(emoji)
+	case EmojiNormalizationFullyQualify:
+		emoji = variationselector.FullyQualify(emoji)
+	}
+	return emoji, emojiID, true
+}
+
+// ToMatrix returns the unicode emoji for a reaction received from the remote network.
+// If the reaction already has an emoji, it's returned as-is. Otherwise, the emoji ID is looked up from the map.
+// The result does not have variation selectors added, as that's done when sending the reaction to Matrix.
+func (rm *ReactionMap) ToMatrix(emoji string, emojiID networkid.EmojiID) string {
+	if emoji != "" || rm == nil || emojiID == "" {
+		return emoji
+	}
+	rm.initOnce.Do(rm.init)
+	return rm.reverse[emojiID]
+}
+
+// GetReactionMap returns the reaction map of the network connector, or nil if it doesn't implement [ReactionMappingNetwork].
+func (br *Bridge) GetReactionMap() *ReactionMap {
+	mapper, ok := br.Network.(ReactionMappingNetwork)
+	if !ok {
+		return nil
+	}
+	return mapper.GetReactionMap()
+}
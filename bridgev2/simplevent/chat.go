@@ -73,6 +73,18 @@ func (evt *ChatDelete) DeleteOnlyForMe() bool {
 	return evt.OnlyForMe
 }
 
+// ChatLeave is a simple implementation of [bridgev2.RemoteChatLeave].
+type ChatLeave struct {
+	EventMeta
+	Reason string
+}
+
+var _ bridgev2.RemoteChatLeaveWithReason = (*ChatLeave)(nil)
+
+func (evt *ChatLeave) GetLeaveReason() string {
+	return evt.Reason
+}
+
 // ChatInfoChange is a simple implementation of [bridgev2.RemoteChatInfoChange].
 type ChatInfoChange struct {
 	EventMeta
@@ -0,0 +1,30 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package simplevent
+
+import (
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+// LiveLocation is a simple implementation of [bridgev2.RemoteLiveLocation].
+type LiveLocation struct {
+	EventMeta
+	ShareID string
+	Update  bridgev2.LiveLocationUpdate
+}
+
+var (
+	_ bridgev2.RemoteLiveLocation = (*LiveLocation)(nil)
+)
+
+func (evt *LiveLocation) GetLiveLocationID() string {
+	return evt.ShareID
+}
+
+func (evt *LiveLocation) GetLiveLocation() *bridgev2.LiveLocationUpdate {
+	return &evt.Update
+}
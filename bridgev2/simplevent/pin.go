@@ -0,0 +1,31 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package simplevent
+
+import (
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+// Pin is a simple implementation of [bridgev2.RemotePin].
+type Pin struct {
+	EventMeta
+	TargetMessage networkid.MessageID
+	Pinned        bool
+}
+
+var (
+	_ bridgev2.RemotePin = (*Pin)(nil)
+)
+
+func (evt *Pin) GetTargetMessage() networkid.MessageID {
+	return evt.TargetMessage
+}
+
+func (evt *Pin) GetPinned() bool {
+	return evt.Pinned
+}
@@ -35,6 +35,7 @@ type User struct {
 
 	doublePuppetIntent      MatrixAPI
 	doublePuppetInitialized bool
+	doublePuppetPresence    *mautrix.PresenceManager
 	doublePuppetLock        sync.Mutex
 
 	managementCreateLock sync.Mutex
@@ -109,6 +110,7 @@ func (user *User) LogoutDoublePuppet(ctx context.Context) {
 	}
 	user.doublePuppetIntent = nil
 	user.doublePuppetInitialized = false
+	user.doublePuppetPresence = nil
 }
 
 func (user *User) LoginDoublePuppet(ctx context.Context, token string) error {
@@ -124,6 +126,7 @@ func (user *User) LoginDoublePuppet(ctx context.Context, token string) error {
 	user.AccessToken = newToken
 	user.doublePuppetIntent = intent
 	user.doublePuppetInitialized = true
+	user.doublePuppetPresence = nil
 	err = user.Save(ctx)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to save new access token")
@@ -159,6 +162,35 @@ func (user *User) DoublePuppet(ctx context.Context) MatrixAPI {
 	return intent
 }
 
+// SetDoublePuppetPresence bridges the presence of the user on the remote network to Matrix using double puppeting.
+// Updates are deduplicated, so network connectors can call this whenever they receive presence from the remote network.
+func (user *User) SetDoublePuppetPresence(ctx context.Context, presence event.Presence, statusMsg string) error {
+	pm := user.getDoublePuppetPresence(ctx)
+	if pm == nil {
+		return nil
+	} else if presence == event.PresenceOffline {
+		return pm.Stop(ctx)
+	}
+	err := pm.SetDesired(ctx, presence, statusMsg)
+	if err != nil {
+		return err
+	}
+	return pm.Start(ctx)
+}
+
+func (user *User) getDoublePuppetPresence(ctx context.Context) *mautrix.PresenceManager {
+	presenceAPI, ok := user.DoublePuppet(ctx).(PresenceMatrixAPI)
+	if !ok {
+		return nil
+	}
+	user.doublePuppetLock.Lock()
+	defer user.doublePuppetLock.Unlock()
+	if user.doublePuppetPresence == nil {
+		user.doublePuppetPresence = mautrix.NewPresenceManager(presenceAPI.SetPresence)
+	}
+	return user.doublePuppetPresence
+}
+
 func (user *User) GetUserLoginIDs() []networkid.UserLoginID {
 	user.Bridge.cacheLock.Lock()
 	defer user.Bridge.cacheLock.Unlock()
@@ -9,6 +9,7 @@ package bridgev2
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"slices"
 	"sync"
@@ -327,6 +328,11 @@ func (ul *UserLogin) KickUserFromPortalsForBadCredentials(ctx context.Context) {
 }
 
 func DeleteManyPortals(ctx context.Context, portals []*Portal, errorCallback func(portal *Portal, delete bool, err error)) {
+	DeleteManyPortalsWithOpts(ctx, portals, &DeletePortalOpts{CleanupRoom: true, KickUsers: true}, errorCallback)
+}
+
+// DeleteManyPortalsWithOpts deletes the given portals using [Portal.DeleteWithOpts], making sure child portals are deleted before their parents.
+func DeleteManyPortalsWithOpts(ctx context.Context, portals []*Portal, opts *DeletePortalOpts, errorCallback func(portal *Portal, delete bool, err error)) {
 	// TODO is there a more sensible place/name for this function?
 	if len(portals) == 0 {
 		return
@@ -344,8 +350,15 @@ func DeleteManyPortals(ctx context.Context, portals []*Portal, errorCallback fun
 		return cmp.Compare(getDepth(b), getDepth(a))
 	})
 	for _, portal := range portals {
-		err := portal.Delete(ctx)
-		if err != nil {
+		err := portal.DeleteWithOpts(ctx, opts)
+		if errors.Is(err, ErrPortalRoomCleanupFailed) {
+			zerolog.Ctx(ctx).Err(err).
+				Stringer("portal_mxid", portal.MXID).
+				Msg("Failed to clean up portal room")
+			if errorCallback != nil {
+				errorCallback(portal, true, err)
+			}
+		} else if err != nil {
 			zerolog.Ctx(ctx).Err(err).
 				Stringer("portal_mxid", portal.MXID).
 				Object("portal_key", portal.PortalKey).
@@ -353,18 +366,6 @@ func DeleteManyPortals(ctx context.Context, portals []*Portal, errorCallback fun
 			if errorCallback != nil {
 				errorCallback(portal, false, err)
 			}
-			continue
-		}
-		if portal.MXID != "" {
-			err = portal.Bridge.Bot.DeleteRoom(ctx, portal.MXID, false)
-			if err != nil {
-				zerolog.Ctx(ctx).Err(err).
-					Stringer("portal_mxid", portal.MXID).
-					Msg("Failed to clean up portal room")
-				if errorCallback != nil {
-					errorCallback(portal, true, err)
-				}
-			}
 		}
 	}
 }
@@ -0,0 +1,253 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mau.fi/util/retryafter"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	DefaultBulkRedactInterval     = 200 * time.Millisecond
+	DefaultMassRedactionBatchSize = 50
+
+	bulkRedactMaxRateLimitRetries = 5
+	bulkRedactDefaultBackoff      = 5 * time.Second
+	bulkRedactPageSize            = 100
+)
+
+// BulkRedactProgress is passed to [ReqBulkRedact.Progress] after each redaction request.
+type BulkRedactProgress struct {
+	Total  int
+	Done   int
+	Failed int
+}
+
+// ReqBulkRedact contains the parameters for [Client.RedactEvents].
+type ReqBulkRedact struct {
+	Reason string
+	// The minimum time to wait between redaction requests. Defaults to [DefaultBulkRedactInterval].
+	Interval time.Duration
+	// The maximum number of events to redact with a single MSC2244 mass redaction. Mass redactions are only
+	// used if the server advertises support for them. Defaults to [DefaultMassRedactionBatchSize],
+	// set to 1 to disable mass redactions.
+	MassRedactionBatchSize int
+	// An optional function that is called after each redaction request.
+	Progress func(BulkRedactProgress)
+}
+
+// ReqRedactUserEvents contains the parameters for [Client.RedactUserEvents].
+type ReqRedactUserEvents struct {
+	ReqBulkRedact
+	// If set, only events sent after this time are redacted.
+	Since time.Time
+	// The maximum number of events to redact. Zero means no limit.
+	MaxEvents int
+	// If true, state events (e.g. the user's membership) are redacted too.
+	IncludeState bool
+}
+
+// RespBulkRedact is the result of [Client.RedactEvents] and [Client.RedactUserEvents].
+type RespBulkRedact struct {
+	// Redactions maps the IDs of redacted events to the IDs of the redaction events.
+	Redactions map[id.EventID]id.EventID
+	// Failed contains the errors for events that couldn't be redacted.
+	Failed map[id.EventID]error
+}
+
+// RedactEvents redacts all the given events in a room.
+//
+// Requests are paced according to [ReqBulkRedact.Interval], and rate limited requests are retried after
+// the delay requested by the server. If the server supports MSC2244, events are redacted in batches
+// using mass redactions, falling back to individual redactions if the mass redaction is rejected
+// (e.g. because the room version doesn't support it).
+//
+// Errors for individual events are collected in [RespBulkRedact.Failed]. An error is only returned
+// if the context is canceled, in which case the response contains the redactions done so far.
+func (cli *Client) RedactEvents(ctx context.Context, roomID id.RoomID, eventIDs []id.EventID, req *ReqBulkRedact) (*RespBulkRedact, error) {
+	if req == nil {
+		req = &ReqBulkRedact{}
+	}
+	interval := req.Interval
+	if interval <= 0 {
+		interval = DefaultBulkRedactInterval
+	}
+	batchSize := req.MassRedactionBatchSize
+	if batchSize == 0 {
+		batchSize = DefaultMassRedactionBatchSize
+	}
+	useMassRedaction := batchSize > 1 && cli.SpecVersions.Supports(FeatureMassRedaction)
+	resp := &RespBulkRedact{
+		Redactions: make(map[id.EventID]id.EventID, len(eventIDs)),
+		Failed:     make(map[id.EventID]error),
+	}
+	progress := BulkRedactProgress{Total: len(eventIDs)}
+	for i := 0; i < len(eventIDs); {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return resp, ctx.Err()
+			}
+		}
+		if useMassRedaction {
+			batch := eventIDs[i:min(i+batchSize, len(eventIDs))]
+			redactionID, err := cli.massRedact(ctx, roomID, batch, req.Reason)
+			if err == nil {
+				for _, evtID := range batch {
+					resp.Redactions[evtID] = redactionID
+				}
+				i += len(batch)
+				progress.Done += len(batch)
+				if req.Progress != nil {
+					req.Progress(progress)
+				}
+				continue
+			} else if ctx.Err() != nil {
+				return resp, ctx.Err()
+			}
+			cli.Log.Warn().Err(err).
+				Stringer("room_id", roomID).
+				Msg("Mass redaction failed, falling back to redacting events individually")
+			useMassRedaction = false
+		}
+		txnID := cli.TxnID()
+		var redactResp *RespSendEvent
+		err := cli.retryRateLimited(ctx, func() (err error) {
+			redactResp, err = cli.RedactEvent(ctx, roomID, eventIDs[i], ReqRedact{Reason: req.Reason, TxnID: txnID})
+			return
+		})
+		if err != nil && ctx.Err() != nil {
+			return resp, ctx.Err()
+		} else if err != nil {
+			resp.Failed[eventIDs[i]] = err
+			progress.Failed++
+		} else {
+			resp.Redactions[eventIDs[i]] = redactResp.EventID
+			progress.Done++
+		}
+		i++
+		if req.Progress != nil {
+			req.Progress(progress)
+		}
+	}
+	return resp, nil
+}
+
+// RedactUserEvents redacts recent events sent by the given user in a room.
+//
+// The room history is paginated backwards from the latest event until [ReqRedactUserEvents.Since]
+// or [ReqRedactUserEvents.MaxEvents] is reached, and the found events are redacted using [Client.RedactEvents].
+// Events that are already redacted and redaction events themselves are skipped.
+func (cli *Client) RedactUserEvents(ctx context.Context, roomID id.RoomID, userID id.UserID, req *ReqRedactUserEvents) (*RespBulkRedact, error) {
+	if req == nil {
+		req = &ReqRedactUserEvents{}
+	}
+	eventIDs, err := cli.findUserEvents(ctx, roomID, userID, req)
+	if err != nil {
+		return nil, err
+	}
+	return cli.RedactEvents(ctx, roomID, eventIDs, &req.ReqBulkRedact)
+}
+
+// RedactOwnEvents redacts recent events sent by the current user in a room. See [Client.RedactUserEvents] for details.
+func (cli *Client) RedactOwnEvents(ctx context.Context, roomID id.RoomID, req *ReqRedactUserEvents) (*RespBulkRedact, error) {
+	return cli.RedactUserEvents(ctx, roomID, cli.UserID, req)
+}
+
+func (cli *Client) findUserEvents(ctx context.Context, roomID id.RoomID, userID id.UserID, req *ReqRedactUserEvents) ([]id.EventID, error) {
+	filter := &FilterPart{Senders: []id.UserID{userID}}
+	var eventIDs []id.EventID
+	var from string
+	for {
+		var resp *RespMessages
+		err := cli.retryRateLimited(ctx, func() (err error) {
+			resp, err = cli.Messages(ctx, roomID, from, "", DirectionBackward, filter, bulkRedactPageSize)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, evt := range resp.Chunk {
+			if evt.Sender != userID {
+				// The filter should've excluded these, but check anyway in case the server ignores it
+				continue
+			} else if !req.Since.IsZero() && evt.Timestamp < req.Since.UnixMilli() {
+				return eventIDs, nil
+			} else if evt.Unsigned.RedactedBecause != nil || evt.Type == event.EventRedaction ||
+				(evt.StateKey != nil && !req.IncludeState) {
+				continue
+			}
+			eventIDs = append(eventIDs, evt.ID)
+			if req.MaxEvents > 0 && len(eventIDs) >= req.MaxEvents {
+				return eventIDs, nil
+			}
+		}
+		if resp.End == "" || len(resp.Chunk) == 0 {
+			return eventIDs, nil
+		}
+		from = resp.End
+	}
+}
+
+func (cli *Client) massRedact(ctx context.Context, roomID id.RoomID, eventIDs []id.EventID, reason string) (id.EventID, error) {
+	content := map[string]any{"redacts": eventIDs}
+	if reason != "" {
+		content["reason"] = reason
+	}
+	txnID := cli.TxnID()
+	var resp *RespSendEvent
+	err := cli.retryRateLimited(ctx, func() (err error) {
+		resp, err = cli.SendMessageEvent(ctx, roomID, event.EventRedaction, content, ReqSendEvent{
+			TransactionID: txnID,
+			DontEncrypt:   true,
+		})
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// retryRateLimited calls fn until it doesn't return a rate limit error, waiting as long as the server requests between attempts.
+func (cli *Client) retryRateLimited(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= bulkRedactMaxRateLimitRetries || !errors.Is(err, MLimitExceeded) {
+			return err
+		}
+		backoff := rateLimitBackoff(err)
+		cli.Log.Debug().Err(err).Dur("backoff", backoff).Msg("Request was rate limited, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func rateLimitBackoff(err error) time.Duration {
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) {
+		return bulkRedactDefaultBackoff
+	} else if httpErr.RespError != nil {
+		if retryAfterMS, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && retryAfterMS > 0 {
+			return time.Duration(retryAfterMS) * time.Millisecond
+		}
+	}
+	if httpErr.Response != nil {
+		return retryafter.Parse(httpErr.Response.Header.Get("Retry-After"), bulkRedactDefaultBackoff)
+	}
+	return bulkRedactDefaultBackoff
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+type redactTestServer struct {
+	lock         sync.Mutex
+	redacted     []id.EventID
+	massRequests int
+	rateLimited  bool
+}
+
+func newRedactTestClient(t *testing.T, rts *redactTestServer, messages string) *mautrix.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rts.lock.Lock()
+		defer rts.lock.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.com/")
+		switch {
+		case strings.HasPrefix(path, "redact/"):
+			if !rts.rateLimited {
+				rts.rateLimited = true
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":10}`))
+				return
+			}
+			rts.redacted = append(rts.redacted, id.EventID(strings.Split(path, "/")[1]))
+			_, _ = w.Write([]byte(`{"event_id":"$redaction"}`))
+		case strings.HasPrefix(path, "send/m.room.redaction/"):
+			rts.massRequests++
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errcode":"M_BAD_JSON","error":"redacts must be a string"}`))
+		case path == "messages":
+			_, _ = w.Write([]byte(messages))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Not found"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@alice:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestClient_RedactEvents(t *testing.T) {
+	var rts redactTestServer
+	cli := newRedactTestClient(t, &rts, "")
+	cli.SpecVersions = &mautrix.RespVersions{UnstableFeatures: map[string]bool{"org.matrix.msc2244": true}}
+	eventIDs := []id.EventID{"$a", "$b", "$c"}
+	var progress []mautrix.BulkRedactProgress
+	resp, err := cli.RedactEvents(context.Background(), "!room:example.com", eventIDs, &mautrix.ReqBulkRedact{
+		Interval: time.Millisecond,
+		Progress: func(p mautrix.BulkRedactProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Failed)
+	assert.Len(t, resp.Redactions, 3)
+	assert.Equal(t, eventIDs, rts.redacted)
+	assert.Equal(t, 1, rts.massRequests, "mass redaction should only be attempted once")
+	require.Len(t, progress, 3)
+	assert.Equal(t, mautrix.BulkRedactProgress{Total: 3, Done: 3}, progress[2])
+}
+
+func TestClient_RedactUserEvents(t *testing.T) {
+	var rts redactTestServer
+	rts.rateLimited = true
+	now := time.Now().UnixMilli()
+	cli := newRedactTestClient(t, &rts, `{"start":"s1","chunk":[
+		{"event_id":"$new","sender":"@bob:example.com","type":"m.room.message","origin_server_ts":`+strconv.FormatInt(now, 10)+`,"content":{}},
+		{"event_id":"$already","sender":"@bob:example.com","type":"m.room.message","origin_server_ts":`+strconv.FormatInt(now-1, 10)+`,"content":{},"unsigned":{"redacted_because":{"event_id":"$r","type":"m.room.redaction","sender":"@alice:example.com","origin_server_ts":1,"content":{}}}},
+		{"event_id":"$other","sender":"@carol:example.com","type":"m.room.message","origin_server_ts":`+strconv.FormatInt(now-2, 10)+`,"content":{}},
+		{"event_id":"$member","sender":"@bob:example.com","type":"m.room.member","state_key":"@bob:example.com","origin_server_ts":`+strconv.FormatInt(now-3, 10)+`,"content":{"membership":"join"}},
+		{"event_id":"$old","sender":"@bob:example.com","type":"m.room.message","origin_server_ts":`+strconv.FormatInt(now-time.Hour.Milliseconds(), 10)+`,"content":{}}
+	],"end":"s2"}`)
+	resp, err := cli.RedactUserEvents(context.Background(), "!room:example.com", "@bob:example.com", &mautrix.ReqRedactUserEvents{
+		ReqBulkRedact: mautrix.ReqBulkRedact{Interval: time.Millisecond},
+		Since:         time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []id.EventID{"$new"}, rts.redacted)
+	assert.Equal(t, map[id.EventID]id.EventID{"$new": "$redaction"}, resp.Redactions)
+}
@@ -87,6 +87,13 @@ type Client struct {
 	Crypto        CryptoHelper
 	Verification  VerificationHelper
 	SpecVersions  *RespVersions
+	// ResponseCache is an optional cache for responses of endpoints that rarely change, like profiles.
+	ResponseCache *ResponseCache
+	// PrefixTransitions is an optional registry of unstable event types and content fields to dual-write when sending.
+	// State events are sent once for each type returned by [event.PrefixTransitions.WriteTypes]. Message events
+	// are only sent once, using the unstable type when writing both variants, as clients that know the stable
+	// type also read the unstable one. Reading always uses [event.DefaultPrefixTransitions].
+	PrefixTransitions *event.PrefixTransitions
 
 	Log zerolog.Logger
 
@@ -248,7 +255,7 @@ func (cli *Client) SyncWithContext(ctx context.Context) error {
 			Since:          nextBatch,
 			FilterID:       filterID,
 			FullState:      false,
-			SetPresence:    cli.SyncPresence,
+			SetPresence:    cli.getSyncPresence(),
 			StreamResponse: streamResp,
 		})
 		if err != nil {
@@ -295,6 +302,13 @@ func (cli *Client) SyncWithContext(ctx context.Context) error {
 	}
 }
 
+func (cli *Client) getSyncPresence() event.Presence {
+	if presenceSyncer, ok := cli.Syncer.(PresenceSettingSyncer); ok {
+		return presenceSyncer.GetSyncPresence()
+	}
+	return cli.SyncPresence
+}
+
 func (cli *Client) incrementSyncingID() uint32 {
 	return atomic.AddUint32(&cli.syncingID, 1)
 }
@@ -858,6 +872,52 @@ func (cli *Client) RegisterDummy(ctx context.Context, req *ReqRegister) (*RespRe
 	return res, nil
 }
 
+// RegisterWithUIA registers an account using the given helper to complete user-interactive auth.
+// The Auth field of the request is overwritten.
+//
+// This does not set credentials on the client instance. See SetCredentials() instead.
+//
+//	helper := mautrix.NewUIAHelper()
+//	helper.HandleReCAPTCHA(showCaptcha)
+//	tokenResp, err := cli.RequestRegisterEmailToken(ctx, &mautrix.ReqRequestEmailToken{...})
+//	helper.HandleEmailIdentity(mautrix.ThreePIDCredentials{SID: tokenResp.SID, ClientSecret: clientSecret})
+//	res, err := cli.RegisterWithUIA(ctx, &mautrix.ReqRegister{Username: "alice", Password: "wonderland"}, helper)
+func (cli *Client) RegisterWithUIA(ctx context.Context, req *ReqRegister, helper *UIAHelper) (resp *RespRegister, err error) {
+	var bodyBytes []byte
+	bodyBytes, err = helper.Do(ctx, func(ctx context.Context, auth any) ([]byte, error) {
+		req.Auth = auth
+		return cli.MakeFullRequest(ctx, FullRequest{
+			Method:           http.MethodPost,
+			URL:              cli.BuildClientURL("v3", "register"),
+			RequestJSON:      req,
+			SensitiveContent: len(req.Password) > 0,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bodyBytes, &resp)
+	return
+}
+
+// RequestRegisterEmailToken asks the server to send a validation email for registering with the given address
+// according to https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3registeremailrequesttoken
+//
+// The returned session ID can be used in [UIAHelper.HandleEmailIdentity] together with the client secret.
+func (cli *Client) RequestRegisterEmailToken(ctx context.Context, req *ReqRequestEmailToken) (resp *RespRequestToken, err error) {
+	urlPath := cli.BuildClientURL("v3", "register", "email", "requestToken")
+	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
+	return
+}
+
+// RequestAddEmailToken asks the server to send a validation email for adding the given address to the account
+// according to https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3account3pidemailrequesttoken
+func (cli *Client) RequestAddEmailToken(ctx context.Context, req *ReqRequestEmailToken) (resp *RespRequestToken, err error) {
+	urlPath := cli.BuildClientURL("v3", "account", "3pid", "email", "requestToken")
+	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
+	return
+}
+
 // GetLoginFlows fetches the login flows that the homeserver supports using https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3login
 func (cli *Client) GetLoginFlows(ctx context.Context) (resp *RespLoginFlows, err error) {
 	urlPath := cli.BuildClientURL("v3", "login")
@@ -865,6 +925,20 @@ func (cli *Client) GetLoginFlows(ctx context.Context) (resp *RespLoginFlows, err
 	return
 }
 
+// BuildSSORedirectURL builds the URL that the user should be sent to for logging in with SSO.
+// After logging in, the user will be redirected to redirectURL with a loginToken query parameter,
+// which can be used with the m.login.token login type.
+//
+// If idpID is empty, the homeserver will let the user choose the identity provider.
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3loginssoredirect
+func (cli *Client) BuildSSORedirectURL(idpID, redirectURL string) string {
+	urlPath := ClientURLPath{"v3", "login", "sso", "redirect"}
+	if idpID != "" {
+		urlPath = append(urlPath, idpID)
+	}
+	return cli.BuildURLWithQuery(urlPath, map[string]string{"redirectUrl": redirectURL})
+}
+
 // Login a user to the homeserver according to https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3login
 func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, err error) {
 	_, err = cli.MakeFullRequest(ctx, FullRequest{
@@ -920,7 +994,7 @@ func (cli *Client) LogoutAll(ctx context.Context) (resp *RespLogout, err error)
 // Versions returns the list of supported Matrix versions on this homeserver. See https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientversions
 func (cli *Client) Versions(ctx context.Context) (resp *RespVersions, err error) {
 	urlPath := cli.BuildClientURL("versions")
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointVersions, "", urlPath, &resp)
 	if resp != nil {
 		cli.SpecVersions = resp
 	}
@@ -930,7 +1004,7 @@ func (cli *Client) Versions(ctx context.Context) (resp *RespVersions, err error)
 // Capabilities returns capabilities on this homeserver. See https://spec.matrix.org/v1.3/client-server-api/#capabilities-negotiation
 func (cli *Client) Capabilities(ctx context.Context) (resp *RespCapabilities, err error) {
 	urlPath := cli.BuildClientURL("v3", "capabilities")
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointCapabilities, "", urlPath, &resp)
 	return
 }
 
@@ -972,16 +1046,54 @@ func (cli *Client) JoinRoomByID(ctx context.Context, roomID id.RoomID) (resp *Re
 	return
 }
 
+func viaQuery(via []string) func(q url.Values) {
+	return func(q url.Values) {
+		if len(via) > 0 {
+			q["via"] = via
+			// server_name is the deprecated name of the parameter, which older servers still use
+			q["server_name"] = via
+		}
+	}
+}
+
+// JoinRoomVia joins the client to a room ID or alias, asking the server to try joining via the given servers.
+// See https://spec.matrix.org/v1.12/client-server-api/#post_matrixclientv3joinroomidoralias
+func (cli *Client) JoinRoomVia(ctx context.Context, roomIDorAlias string, req *ReqJoinRoom) (resp *RespJoinRoom, err error) {
+	urlPath := cli.BuildURLWithFullQuery(ClientURLPath{"v3", "join", roomIDorAlias}, viaQuery(req.Via))
+	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
+	if err == nil && cli.StateStore != nil {
+		err = cli.StateStore.SetMembership(ctx, resp.RoomID, cli.UserID, event.MembershipJoin)
+		if err != nil {
+			err = fmt.Errorf("failed to update state store: %w", err)
+		}
+	}
+	return
+}
+
+// KnockRoom asks to be let into a room that has the knock join rule.
+// See https://spec.matrix.org/v1.12/client-server-api/#post_matrixclientv3knockroomidoralias
+func (cli *Client) KnockRoom(ctx context.Context, roomIDorAlias string, req *ReqKnockRoom) (resp *RespKnockRoom, err error) {
+	urlPath := cli.BuildURLWithFullQuery(ClientURLPath{"v3", "knock", roomIDorAlias}, viaQuery(req.Via))
+	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
+	if err == nil && cli.StateStore != nil {
+		err = cli.StateStore.SetMembership(ctx, resp.RoomID, cli.UserID, event.MembershipKnock)
+		if err != nil {
+			err = fmt.Errorf("failed to update state store: %w", err)
+		}
+	}
+	return
+}
+
 func (cli *Client) GetProfile(ctx context.Context, mxid id.UserID) (resp *RespUserProfile, err error) {
 	urlPath := cli.BuildClientURL("v3", "profile", mxid)
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointProfile, mxid.String(), urlPath, &resp)
 	return
 }
 
 // GetDisplayName returns the display name of the user with the specified MXID. See https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3profileuseriddisplayname
 func (cli *Client) GetDisplayName(ctx context.Context, mxid id.UserID) (resp *RespUserDisplayName, err error) {
 	urlPath := cli.BuildClientURL("v3", "profile", mxid, "displayname")
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointDisplayName, mxid.String(), urlPath, &resp)
 	return
 }
 
@@ -997,6 +1109,9 @@ func (cli *Client) SetDisplayName(ctx context.Context, displayName string) (err
 		DisplayName string `json:"displayname"`
 	}{displayName}
 	_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, &s, nil)
+	if err == nil {
+		cli.ResponseCache.InvalidateProfile(cli.UserID)
+	}
 	return
 }
 
@@ -1007,7 +1122,7 @@ func (cli *Client) GetAvatarURL(ctx context.Context, mxid id.UserID) (url id.Con
 		AvatarURL id.ContentURI `json:"avatar_url"`
 	}{}
 
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &s)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointAvatarURL, mxid.String(), urlPath, &s)
 	if err != nil {
 		return
 	}
@@ -1030,6 +1145,7 @@ func (cli *Client) SetAvatarURL(ctx context.Context, url id.ContentURI) (err err
 	if err != nil {
 		return err
 	}
+	cli.ResponseCache.InvalidateProfile(cli.UserID)
 
 	return nil
 }
@@ -1038,6 +1154,9 @@ func (cli *Client) SetAvatarURL(ctx context.Context, url id.ContentURI) (err err
 func (cli *Client) BeeperUpdateProfile(ctx context.Context, data any) (err error) {
 	urlPath := cli.BuildClientURL("v3", "profile", cli.UserID)
 	_, err = cli.MakeRequest(ctx, http.MethodPatch, urlPath, data, nil)
+	if err == nil {
+		cli.ResponseCache.InvalidateProfile(cli.UserID)
+	}
 	return
 }
 
@@ -1094,6 +1213,14 @@ func (cli *Client) SendMessageEvent(ctx context.Context, roomID id.RoomID, event
 		req = extra[0]
 	}
 
+	if cli.PrefixTransitions != nil {
+		writeTypes := cli.PrefixTransitions.WriteTypes(eventType)
+		eventType = writeTypes[len(writeTypes)-1]
+		if contentJSON, err = cli.prepareContentForWrite(contentJSON); err != nil {
+			return
+		}
+	}
+
 	var txnID string
 	if len(req.TransactionID) > 0 {
 		txnID = req.TransactionID
@@ -1134,27 +1261,67 @@ func (cli *Client) SendMessageEvent(ctx context.Context, roomID id.RoomID, event
 // SendStateEvent sends a state event into a room. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3roomsroomidstateeventtypestatekey
 // contentJSON should be a pointer to something that can be encoded as JSON using json.Marshal.
 func (cli *Client) SendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}) (resp *RespSendEvent, err error) {
-	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "state", eventType.String(), stateKey)
-	_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, contentJSON, &resp)
-	if err == nil && cli.StateStore != nil {
-		cli.updateStoreWithOutgoingEvent(ctx, roomID, eventType, stateKey, contentJSON)
-	}
-	return
+	return cli.sendStateEvent(ctx, roomID, eventType, stateKey, contentJSON, nil)
 }
 
 // SendMassagedStateEvent sends a state event into a room with a custom timestamp. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3roomsroomidstateeventtypestatekey
 // contentJSON should be a pointer to something that can be encoded as JSON using json.Marshal.
 func (cli *Client) SendMassagedStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}, ts int64) (resp *RespSendEvent, err error) {
-	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "rooms", roomID, "state", eventType.String(), stateKey}, map[string]string{
+	return cli.sendStateEvent(ctx, roomID, eventType, stateKey, contentJSON, map[string]string{
 		"ts": strconv.FormatInt(ts, 10),
 	})
-	_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, contentJSON, &resp)
-	if err == nil && cli.StateStore != nil {
-		cli.updateStoreWithOutgoingEvent(ctx, roomID, eventType, stateKey, contentJSON)
+}
+
+func (cli *Client) sendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}, queryParams map[string]string) (resp *RespSendEvent, err error) {
+	writeTypes := []event.Type{eventType}
+	if cli.PrefixTransitions != nil {
+		writeTypes = cli.PrefixTransitions.WriteTypes(eventType)
+		if contentJSON, err = cli.prepareContentForWrite(contentJSON); err != nil {
+			return
+		}
+	}
+	for i, writeType := range writeTypes {
+		var typeResp *RespSendEvent
+		urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "rooms", roomID, "state", writeType.String(), stateKey}, queryParams)
+		_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, contentJSON, &typeResp)
+		if err != nil {
+			if i > 0 {
+				err = fmt.Errorf("failed to send %s variant: %w", writeType.Type, err)
+			}
+			return
+		}
+		if i == 0 {
+			resp = typeResp
+		}
+		if cli.StateStore != nil {
+			cli.updateStoreWithOutgoingEvent(ctx, roomID, writeType, stateKey, contentJSON)
+		}
 	}
 	return
 }
 
+// prepareContentForWrite applies the content field transitions in cli.PrefixTransitions to the given content.
+func (cli *Client) prepareContentForWrite(contentJSON interface{}) (interface{}, error) {
+	if !cli.PrefixTransitions.HasFields() {
+		return contentJSON, nil
+	}
+	data, err := json.Marshal(contentJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal content: %w", err)
+	}
+	var raw map[string]any
+	// Use json.Number to avoid losing precision of large integers in custom content
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&raw)
+	if err != nil {
+		// Not an object, there are no fields to transition
+		return contentJSON, nil
+	}
+	cli.PrefixTransitions.PrepareContentForWrite(raw)
+	return raw, nil
+}
+
 // SendText sends an m.room.message event into the given room with a msgtype of m.text
 // See https://spec.matrix.org/v1.2/client-server-api/#mtext
 func (cli *Client) SendText(ctx context.Context, roomID id.RoomID, text string) (*RespSendEvent, error) {
@@ -1350,8 +1517,13 @@ func (cli *Client) GetOwnPresence(ctx context.Context) (resp *RespPresence, err
 	return cli.GetPresence(ctx, cli.UserID)
 }
 
+// SetPresence sets the user's presence without a status message. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3presenceuseridstatus
 func (cli *Client) SetPresence(ctx context.Context, status event.Presence) (err error) {
-	req := ReqPresence{Presence: status}
+	return cli.SetPresenceWithStatus(ctx, ReqPresence{Presence: status})
+}
+
+// SetPresenceWithStatus sets the user's presence and status message. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3presenceuseridstatus
+func (cli *Client) SetPresenceWithStatus(ctx context.Context, req ReqPresence) (err error) {
 	u := cli.BuildClientURL("v3", "presence", cli.UserID, "status")
 	_, err = cli.MakeRequest(ctx, http.MethodPut, u, req, nil)
 	return
@@ -1483,7 +1655,7 @@ func (cli *Client) StateAsArray(ctx context.Context, roomID id.RoomID) (state []
 
 // GetMediaConfig fetches the configuration of the content repository, such as upload limitations.
 func (cli *Client) GetMediaConfig(ctx context.Context) (resp *RespMediaConfig, err error) {
-	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildClientURL("v1", "media", "config"), nil, &resp)
+	err = cli.makeCachedGetRequest(ctx, CachedEndpointMediaConfig, "", cli.BuildClientURL("v1", "media", "config"), &resp)
 	return
 }
 
@@ -1834,6 +2006,29 @@ func (cli *Client) Hierarchy(ctx context.Context, roomID id.RoomID, req *ReqHier
 	return
 }
 
+// GetRoomSummary fetches the summary of a room using the unstable endpoint from MSC3266.
+// The room can be specified using either a room ID or an alias. The via parameters are used
+// to tell the server which servers to ask if it isn't in the room itself.
+//
+// Use GetRoomPreview if you want to fall back to other methods when the server doesn't support MSC3266.
+func (cli *Client) GetRoomSummary(ctx context.Context, roomIDOrAlias string, via ...string) (resp *RespRoomSummary, err error) {
+	urlPath := cli.BuildURLWithFullQuery(ClientURLPath{"unstable", "im.nheko.summary", "summary", roomIDOrAlias}, func(q url.Values) {
+		if len(via) > 0 {
+			q["via"] = via
+		}
+	})
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	if resp != nil {
+		if resp.RoomVersion == "" {
+			resp.RoomVersion = resp.UnstableRoomVersion
+		}
+		if resp.Encryption == "" {
+			resp.Encryption = resp.UnstableEncryption
+		}
+	}
+	return
+}
+
 // Messages returns a list of message and state events for a room. It uses
 // pagination query parameters to paginate history in the room.
 // See https://spec.matrix.org/v1.12/client-server-api/#get_matrixclientv3roomsroomidmessages
@@ -1898,6 +2093,22 @@ func (cli *Client) Context(ctx context.Context, roomID id.RoomID, eventID id.Eve
 	return
 }
 
+// GetRelations returns a page of events that relate to the given event.
+// See https://spec.matrix.org/v1.10/client-server-api/#get_matrixclientv1roomsroomidrelationseventid
+//
+// The relation and event types to filter by are set in the request, which may be nil to get all relations.
+// The NextBatch field of the response can be passed as From in the next request to get the next page.
+// If Recurse is set and the server only supports the unstable version of MSC3981, the unstable flag is sent too.
+func (cli *Client) GetRelations(ctx context.Context, roomID id.RoomID, eventID id.EventID, req *ReqGetRelations) (resp *RespGetRelations, err error) {
+	query := req.Query()
+	if query["recurse"] != "" && !cli.SpecVersions.ContainsGreaterOrEqual(SpecV110) {
+		query["org.matrix.msc3981.recurse"] = query["recurse"]
+	}
+	urlPath := cli.BuildURLWithQuery(append(ClientURLPath{"v1", "rooms", roomID, "relations", eventID}, req.PathSuffix()...), query)
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
 func (cli *Client) GetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (resp *event.Event, err error) {
 	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "event", eventID)
 	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
@@ -1966,6 +2177,58 @@ func (cli *Client) RemoveTag(ctx context.Context, roomID id.RoomID, tag event.Ro
 	return
 }
 
+// GetTag returns the metadata of a single tag on the given room, or nil if the room doesn't have the tag.
+func (cli *Client) GetTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag) (*event.TagMetadata, error) {
+	tags, err := cli.GetTags(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := tags.Tags[tag]
+	if !ok {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+// SetTag adds a tag to the given room. Unlike AddTag, the order is optional.
+// See [event.TagOrderBetween] for calculating orders when moving rooms.
+func (cli *Client) SetTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag, order *float64) error {
+	var meta event.TagMetadata
+	if order != nil {
+		meta.Order = json.Number(strconv.FormatFloat(*order, 'f', -1, 64))
+	}
+	return cli.AddTagWithCustomData(ctx, roomID, tag, &meta)
+}
+
+// SetFavourite adds or removes the m.favourite tag on the given room.
+// When adding the tag, the m.lowpriority tag is removed, as a room can't be in both sections.
+func (cli *Client) SetFavourite(ctx context.Context, roomID id.RoomID, favourite bool, order *float64) error {
+	return cli.setExclusiveTag(ctx, roomID, event.RoomTagFavourite, event.RoomTagLowPriority, favourite, order)
+}
+
+// SetLowPriority adds or removes the m.lowpriority tag on the given room.
+// When adding the tag, the m.favourite tag is removed, as a room can't be in both sections.
+func (cli *Client) SetLowPriority(ctx context.Context, roomID id.RoomID, lowPriority bool, order *float64) error {
+	return cli.setExclusiveTag(ctx, roomID, event.RoomTagLowPriority, event.RoomTagFavourite, lowPriority, order)
+}
+
+func (cli *Client) setExclusiveTag(ctx context.Context, roomID id.RoomID, tag, otherTag event.RoomTag, add bool, order *float64) error {
+	if !add {
+		return cli.RemoveTag(ctx, roomID, tag)
+	}
+	tags, err := cli.GetTags(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get existing tags: %w", err)
+	}
+	if _, hasOther := tags.Tags[otherTag]; hasOther {
+		err = cli.RemoveTag(ctx, roomID, otherTag)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s tag: %w", otherTag, err)
+		}
+	}
+	return cli.SetTag(ctx, roomID, tag, order)
+}
+
 // Deprecated: Synapse may not handle setting m.tag directly properly, so you should use the Add/RemoveTag methods instead.
 func (cli *Client) SetTags(ctx context.Context, roomID id.RoomID, tags event.Tags) (err error) {
 	return cli.SetRoomAccountData(ctx, roomID, "m.tag", map[string]event.Tags{
@@ -2216,7 +2479,30 @@ func (cli *Client) DeleteDevice(ctx context.Context, deviceID id.DeviceID, req *
 
 func (cli *Client) DeleteDevices(ctx context.Context, req *ReqDeleteDevices) error {
 	urlPath := cli.BuildClientURL("v3", "delete_devices")
-	_, err := cli.MakeRequest(ctx, http.MethodDelete, urlPath, req, nil)
+	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, req, nil)
+	return err
+}
+
+// DeleteDevicesWithUIA deletes the given devices, using the callback to complete user-interactive authentication
+// if the server requires it. The callback works the same way as in [Client.UploadCrossSigningKeys].
+func (cli *Client) DeleteDevicesWithUIA(ctx context.Context, req *ReqDeleteDevices, uiaCallback UIACallback) error {
+	content, err := cli.MakeFullRequest(ctx, FullRequest{
+		Method:           http.MethodPost,
+		URL:              cli.BuildClientURL("v3", "delete_devices"),
+		RequestJSON:      req,
+		SensitiveContent: req.Auth != nil,
+	})
+	if respErr, ok := err.(HTTPError); ok && respErr.IsStatus(http.StatusUnauthorized) && uiaCallback != nil {
+		var uiAuthResp RespUserInteractive
+		if err := json.Unmarshal(content, &uiAuthResp); err != nil {
+			return fmt.Errorf("failed to decode UIA response: %w", err)
+		}
+		auth := uiaCallback(&uiAuthResp)
+		if auth != nil {
+			req.Auth = auth
+			return cli.DeleteDevicesWithUIA(ctx, req, nil)
+		}
+	}
 	return err
 }
 
@@ -2295,9 +2581,11 @@ func (cli *Client) PutPushRule(ctx context.Context, scope string, kind pushrules
 	return err
 }
 
-func (cli *Client) ReportEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
+// ReportEvent reports an event to the homeserver admins.
+// The score ranges from -100 (most offensive) to 0 (inoffensive).
+func (cli *Client) ReportEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string, score int) error {
 	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "report", eventID)
-	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason, Score: -100}, nil)
+	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason, Score: score}, nil)
 	return err
 }
 
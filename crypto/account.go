@@ -8,6 +8,7 @@ package crypto
 
 import (
 	"encoding/json"
+	"io"
 
 	"github.com/tidwall/sjson"
 
@@ -121,3 +122,36 @@ func (account *OlmAccount) getOneTimeKeys(userID id.UserID, deviceID id.DeviceID
 	}
 	return oneTimeKeys
 }
+
+// fallbackKeyAccount is implemented by olm account backends that support fallback keys.
+type fallbackKeyAccount interface {
+	GenFallbackKey(reader io.Reader) error
+	FallbackKeyUnpublished() map[string]id.Curve25519
+}
+
+// getFallbackKeys returns the unpublished fallback key of the account, optionally generating a new one first.
+// If the olm backend doesn't support fallback keys, this returns nil.
+func (account *OlmAccount) getFallbackKeys(userID id.UserID, deviceID id.DeviceID, generateNew bool) (map[id.KeyID]mautrix.OneTimeKey, error) {
+	fka, ok := account.Internal.(fallbackKeyAccount)
+	if !ok {
+		return nil, nil
+	}
+	if generateNew {
+		err := fka.GenFallbackKey(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fallbackKeys := make(map[id.KeyID]mautrix.OneTimeKey)
+	for keyID, key := range fka.FallbackKeyUnpublished() {
+		key := mautrix.OneTimeKey{Key: key, Fallback: true}
+		signature, err := account.SignJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		key.Signatures = signatures.NewSingleSignature(userID, id.KeyAlgorithmEd25519, deviceID.String(), signature)
+		key.IsSigned = true
+		fallbackKeys[id.NewKeyID(id.KeyAlgorithmSignedCurve25519, keyID)] = key
+	}
+	return fallbackKeys, nil
+}
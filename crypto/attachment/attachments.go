@@ -164,6 +164,43 @@ func (ef *EncryptedFile) EncryptFile(file ReadWriterAt) error {
 	return nil
 }
 
+// DecryptFile decrypts the given file in-place. The SHA256 hash of the ciphertext is verified while decrypting,
+// so if HashMismatch is returned, the file has already been overwritten and should not be used further.
+func (ef *EncryptedFile) DecryptFile(file ReadWriterAt) error {
+	err := ef.PrepareForDecryption()
+	if err != nil {
+		return err
+	}
+	block, _ := aes.NewCipher(ef.decoded.key[:])
+	stream := cipher.NewCTR(block, ef.decoded.iv[:])
+	hasher := sha256.New()
+	buf := make([]byte, 32*1024)
+	var writePtr int64
+	var n int
+	for {
+		n, err = file.Read(buf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		hasher.Write(buf[:n])
+		stream.XORKeyStream(buf[:n], buf[:n])
+		_, err = file.WriteAt(buf[:n], writePtr)
+		if err != nil {
+			return err
+		}
+		writePtr += int64(n)
+	}
+	var checksum [utils.SHAHashLength]byte
+	hasher.Sum(checksum[:0])
+	if checksum != ef.decoded.sha256 {
+		return HashMismatch
+	}
+	return nil
+}
+
 type encryptingReader struct {
 	stream cipher.Stream
 	hash   hash.Hash
@@ -191,23 +228,35 @@ func (r *encryptingReader) Seek(offset int64, whence int) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	r.hash.Reset()
+	if r.isDecrypting && r.stream == nil {
+		// The stream hasn't been initialized yet, Read will do it
+		return n, nil
+	}
 	block, _ := aes.NewCipher(r.file.decoded.key[:])
 	r.stream = cipher.NewCTR(block, r.file.decoded.iv[:])
-	r.hash.Reset()
 	return n, nil
 }
 
 func (r *encryptingReader) Read(dst []byte) (n int, err error) {
 	if r.closed {
 		return 0, ReaderClosed
-	} else if r.isDecrypting && r.file.decoded == nil {
+	} else if r.isDecrypting && r.stream == nil {
 		if err = r.file.PrepareForDecryption(); err != nil {
 			return
 		}
+		block, _ := aes.NewCipher(r.file.decoded.key[:])
+		r.stream = cipher.NewCTR(block, r.file.decoded.iv[:])
 	}
 	n, err = r.source.Read(dst)
-	r.stream.XORKeyStream(dst[:n], dst[:n])
-	r.hash.Write(dst[:n])
+	if r.isDecrypting {
+		// The hash in the file info is of the ciphertext
+		r.hash.Write(dst[:n])
+		r.stream.XORKeyStream(dst[:n], dst[:n])
+	} else {
+		r.stream.XORKeyStream(dst[:n], dst[:n])
+		r.hash.Write(dst[:n])
+	}
 	return
 }
 
@@ -218,8 +267,8 @@ func (r *encryptingReader) Close() (err error) {
 	}
 	if r.isDecrypting {
 		var downloadedChecksum [utils.SHAHashLength]byte
-		r.hash.Sum(downloadedChecksum[:])
-		if downloadedChecksum != r.file.decoded.sha256 {
+		copy(downloadedChecksum[:], r.hash.Sum(nil))
+		if r.file.decoded == nil || downloadedChecksum != r.file.decoded.sha256 {
 			return HashMismatch
 		}
 	} else {
@@ -290,11 +339,11 @@ func (ef *EncryptedFile) DecryptInPlace(data []byte) error {
 // The Close call will validate the hash and return an error if it doesn't match.
 // In this case, the written data should be considered compromised and should not be used further.
 func (ef *EncryptedFile) DecryptStream(reader io.Reader) io.ReadSeekCloser {
-	block, _ := aes.NewCipher(ef.decoded.key[:])
 	return &encryptingReader{
-		stream: cipher.NewCTR(block, ef.decoded.iv[:]),
 		hash:   sha256.New(),
 		source: reader,
 		file:   ef,
+
+		isDecrypting: true,
 	}
 }
@@ -1,8 +1,12 @@
 package attachment
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,6 +53,37 @@ func TestEncryptHelloWorld(t *testing.T) {
 	assert.Equal(t, helloWorldCiphertext, string(data), "unexpected encrypt output")
 }
 
+func TestDecryptFileHelloWorld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, []byte(helloWorldCiphertext), 0600)
+	assert.NoError(t, err)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+	err = parseHelloWorld().DecryptFile(f)
+	assert.NoError(t, err, "failed to decrypt file")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data), "unexpected decrypt output")
+}
+
+func TestDecryptStreamHelloWorld(t *testing.T) {
+	reader := parseHelloWorld().DecryptStream(bytes.NewReader([]byte(helloWorldCiphertext)))
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data), "unexpected decrypt output")
+	assert.NoError(t, reader.Close(), "hash check failed")
+}
+
+func TestDecryptStreamHashMismatch(t *testing.T) {
+	file := parseHelloWorld()
+	file.Hashes.SHA256 = base64.RawStdEncoding.EncodeToString([]byte(random32Bytes))
+	reader := file.DecryptStream(bytes.NewReader([]byte(helloWorldCiphertext)))
+	_, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, reader.Close(), HashMismatch)
+}
+
 func TestUnsupportedVersion(t *testing.T) {
 	file := parseHelloWorld()
 	file.Version = "foo"
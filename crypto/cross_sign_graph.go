@@ -0,0 +1,203 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// TrustGraph is a snapshot of the cross-signing trust relationships that are known locally.
+// It's meant for debugging tools and security settings screens, see [OlmMachine.GetTrustGraph].
+type TrustGraph struct {
+	OwnUserID   id.UserID         `json:"own_user_id"`
+	OwnDeviceID id.DeviceID       `json:"own_device_id"`
+	Users       []*TrustGraphUser `json:"users"`
+	Edges       []*TrustGraphEdge `json:"edges"`
+}
+
+type TrustGraphUser struct {
+	UserID         id.UserID  `json:"user_id"`
+	MasterKey      id.Ed25519 `json:"master_key,omitempty"`
+	SelfSigningKey id.Ed25519 `json:"self_signing_key,omitempty"`
+	UserSigningKey id.Ed25519 `json:"user_signing_key,omitempty"`
+	// MasterKeyChanged is true if the master key is different from the first one that was seen for the user.
+	MasterKeyChanged bool `json:"master_key_changed,omitempty"`
+	// Verified is true if the user's master key is signed by our user-signing key, or if this is our own user.
+	Verified bool `json:"verified"`
+
+	Devices []*TrustGraphDevice `json:"devices"`
+}
+
+type TrustGraphDevice struct {
+	DeviceID   id.DeviceID   `json:"device_id"`
+	Name       string        `json:"name,omitempty"`
+	SigningKey id.Ed25519    `json:"signing_key"`
+	Trust      id.TrustState `json:"trust"`
+	// CrossSigned is true if the device is signed by the user's self-signing key.
+	CrossSigned bool `json:"cross_signed"`
+	// Dangling is true for our own devices that haven't been signed by our self-signing key.
+	Dangling bool `json:"dangling,omitempty"`
+}
+
+// TrustGraphEdge is a signature made by one key over another.
+type TrustGraphEdge struct {
+	SignerUserID id.UserID  `json:"signer_user_id"`
+	SignerKey    id.Ed25519 `json:"signer_key"`
+	SignedUserID id.UserID  `json:"signed_user_id"`
+	SignedKey    id.Ed25519 `json:"signed_key"`
+}
+
+// GetTrustGraph builds the trust graph of our own user and the given users. Keys, devices and signatures
+// are read from the crypto store, so users whose device lists aren't tracked are left out.
+func (mach *OlmMachine) GetTrustGraph(ctx context.Context, userIDs []id.UserID) (*TrustGraph, error) {
+	ownUserID := mach.Client.UserID
+	otherUserIDs := slices.Clone(userIDs)
+	slices.Sort(otherUserIDs)
+	otherUserIDs = slices.DeleteFunc(slices.Compact(otherUserIDs), func(userID id.UserID) bool {
+		return userID == ownUserID
+	})
+	tracked, err := mach.CryptoStore.FilterTrackedUsers(ctx, otherUserIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter tracked users: %w", err)
+	}
+	graph := &TrustGraph{
+		OwnUserID:   ownUserID,
+		OwnDeviceID: mach.Client.DeviceID,
+		Users:       make([]*TrustGraphUser, 0, len(tracked)+1),
+		Edges:       make([]*TrustGraphEdge, 0),
+	}
+	ownKeys := mach.GetOwnCrossSigningPublicKeys(ctx)
+	for _, userID := range append([]id.UserID{ownUserID}, tracked...) {
+		user, err := mach.getTrustGraphUser(ctx, graph, ownKeys, userID)
+		if err != nil {
+			return nil, err
+		}
+		graph.Users = append(graph.Users, user)
+	}
+	return graph, nil
+}
+
+func (mach *OlmMachine) addTrustGraphEdgeIfSigned(ctx context.Context, graph *TrustGraph, signedUser id.UserID, signedKey id.Ed25519, signerUser id.UserID, signerKey id.Ed25519) (bool, error) {
+	if signedKey == "" || signerKey == "" {
+		return false, nil
+	}
+	signed, err := mach.CryptoStore.IsKeySignedBy(ctx, signedUser, signedKey, signerUser, signerKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if %s's key %s is signed by %s's key %s: %w", signedUser, signedKey, signerUser, signerKey, err)
+	} else if signed {
+		graph.Edges = append(graph.Edges, &TrustGraphEdge{
+			SignerUserID: signerUser,
+			SignerKey:    signerKey,
+			SignedUserID: signedUser,
+			SignedKey:    signedKey,
+		})
+	}
+	return signed, nil
+}
+
+func (mach *OlmMachine) getTrustGraphUser(ctx context.Context, graph *TrustGraph, ownKeys *CrossSigningPublicKeysCache, userID id.UserID) (*TrustGraphUser, error) {
+	keys, err := mach.CryptoStore.GetCrossSigningKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cross-signing keys of %s: %w", userID, err)
+	}
+	user := &TrustGraphUser{
+		UserID:         userID,
+		MasterKey:      keys[id.XSUsageMaster].Key,
+		SelfSigningKey: keys[id.XSUsageSelfSigning].Key,
+		UserSigningKey: keys[id.XSUsageUserSigning].Key,
+		Devices:        make([]*TrustGraphDevice, 0),
+	}
+	if mk, ok := keys[id.XSUsageMaster]; ok {
+		user.MasterKeyChanged = mk.First != "" && mk.Key != mk.First
+	}
+	sskValid, err := mach.addTrustGraphEdgeIfSigned(ctx, graph, userID, user.SelfSigningKey, userID, user.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = mach.addTrustGraphEdgeIfSigned(ctx, graph, userID, user.UserSigningKey, userID, user.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	if userID == graph.OwnUserID {
+		user.Verified = ownKeys != nil
+	} else if ownKeys != nil {
+		user.Verified, err = mach.addTrustGraphEdgeIfSigned(ctx, graph, userID, user.MasterKey, graph.OwnUserID, ownKeys.UserSigningKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	devices, err := mach.CryptoStore.GetDevices(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices of %s: %w", userID, err)
+	}
+	for _, device := range devices {
+		graphDevice := &TrustGraphDevice{
+			DeviceID:   device.DeviceID,
+			Name:       device.Name,
+			SigningKey: device.SigningKey,
+		}
+		graphDevice.Trust, err = mach.ResolveTrustContext(ctx, device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve trust of %s/%s: %w", userID, device.DeviceID, err)
+		}
+		if sskValid {
+			graphDevice.CrossSigned, err = mach.addTrustGraphEdgeIfSigned(ctx, graph, userID, device.SigningKey, userID, user.SelfSigningKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		graphDevice.Dangling = userID == graph.OwnUserID && !graphDevice.CrossSigned
+		user.Devices = append(user.Devices, graphDevice)
+	}
+	slices.SortFunc(user.Devices, func(a, b *TrustGraphDevice) int {
+		return strings.Compare(a.DeviceID.String(), b.DeviceID.String())
+	})
+	return user, nil
+}
+
+// DOT returns the trust graph in the Graphviz DOT format. Keys are nodes and signatures are edges,
+// with unverified users and dangling devices highlighted in red.
+func (tg *TrustGraph) DOT() string {
+	var buf strings.Builder
+	buf.WriteString("digraph trust {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+	writeNode := func(key id.Ed25519, label, color, style string) {
+		if key != "" {
+			_, _ = fmt.Fprintf(&buf, "\t%q [label=%q, color=%s, style=%s];\n", key, label, color, style)
+		}
+	}
+	for _, user := range tg.Users {
+		userColor := "black"
+		if !user.Verified {
+			userColor = "red"
+		}
+		writeNode(user.MasterKey, fmt.Sprintf("%s\nmaster key", user.UserID), userColor, "solid")
+		writeNode(user.SelfSigningKey, fmt.Sprintf("%s\nself-signing key", user.UserID), userColor, "solid")
+		writeNode(user.UserSigningKey, fmt.Sprintf("%s\nuser-signing key", user.UserID), userColor, "solid")
+		for _, device := range user.Devices {
+			color, style := "black", "rounded"
+			if device.Dangling {
+				color, style = "red", "dashed"
+			}
+			label := fmt.Sprintf("%s\n%s (%s)", user.UserID, device.DeviceID, device.Trust)
+			if user.UserID == tg.OwnUserID && device.DeviceID == tg.OwnDeviceID {
+				label += "\ncurrent device"
+			}
+			writeNode(device.SigningKey, label, color, style)
+		}
+	}
+	for _, edge := range tg.Edges {
+		_, _ = fmt.Fprintf(&buf, "\t%q -> %q;\n", edge.SignerKey, edge.SignedKey)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -10,6 +10,8 @@ package crypto
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/rs/zerolog"
@@ -155,3 +157,50 @@ func TestTrustOtherDevice(t *testing.T) {
 		t.Error("Other device not trusted while it should be")
 	}
 }
+
+func TestTrustGraph(t *testing.T) {
+	m := getOlmMachine(t)
+	ctx := context.TODO()
+	otherUser := id.UserID("@user")
+	signedDevice := &id.Device{UserID: m.Client.UserID, DeviceID: "signed", SigningKey: id.Ed25519("signedKey")}
+	danglingDevice := &id.Device{UserID: m.Client.UserID, DeviceID: "dangling", SigningKey: id.Ed25519("danglingKey")}
+	m.CryptoStore.PutDevices(ctx, m.Client.UserID, map[id.DeviceID]*id.Device{
+		signedDevice.DeviceID:   signedDevice,
+		danglingDevice.DeviceID: danglingDevice,
+	})
+	m.CryptoStore.PutSignature(ctx, m.Client.UserID, m.CrossSigningKeys.SelfSigningKey.PublicKey(),
+		m.Client.UserID, m.CrossSigningKeys.MasterKey.PublicKey(), "sig1")
+	m.CryptoStore.PutSignature(ctx, m.Client.UserID, signedDevice.SigningKey,
+		m.Client.UserID, m.CrossSigningKeys.SelfSigningKey.PublicKey(), "sig2")
+
+	theirMasterKey, _ := olm.NewPKSigning()
+	m.CryptoStore.PutCrossSigningKey(ctx, otherUser, id.XSUsageMaster, theirMasterKey.PublicKey())
+	m.CryptoStore.PutDevices(ctx, otherUser, map[id.DeviceID]*id.Device{})
+
+	graph, err := m.GetTrustGraph(ctx, []id.UserID{otherUser, "@untracked", otherUser, m.Client.UserID})
+	if err != nil {
+		t.Fatalf("Failed to get trust graph: %v", err)
+	}
+	if len(graph.Users) != 2 || graph.Users[0].UserID != m.Client.UserID || graph.Users[1].UserID != otherUser {
+		t.Fatalf("Unexpected users in trust graph: %+v", graph.Users)
+	}
+	own := graph.Users[0]
+	if !own.Verified || len(own.Devices) != 2 {
+		t.Fatalf("Unexpected own user in trust graph: %+v", own)
+	}
+	if own.Devices[0].DeviceID != danglingDevice.DeviceID || !own.Devices[0].Dangling || own.Devices[0].CrossSigned {
+		t.Errorf("Dangling device not marked as dangling: %+v", own.Devices[0])
+	}
+	if own.Devices[1].DeviceID != signedDevice.DeviceID || own.Devices[1].Dangling || !own.Devices[1].CrossSigned {
+		t.Errorf("Signed device not marked as cross-signed: %+v", own.Devices[1])
+	}
+	if graph.Users[1].Verified {
+		t.Error("Other user verified while they shouldn't be")
+	}
+	if len(graph.Edges) != 2 {
+		t.Errorf("Expected 2 edges, got %d", len(graph.Edges))
+	}
+	if dot := graph.DOT(); !strings.Contains(dot, fmt.Sprintf("%q -> %q", m.CrossSigningKeys.SelfSigningKey.PublicKey(), signedDevice.SigningKey)) {
+		t.Errorf("DOT output doesn't contain device signature edge:\n%s", dot)
+	}
+}
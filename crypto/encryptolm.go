@@ -131,3 +131,40 @@ func (mach *OlmMachine) createOutboundSessions(ctx context.Context, input map[id
 	}
 	return nil
 }
+
+// EncryptToDevices encrypts the given to-device event content separately for each of the given devices using Olm,
+// creating new Olm sessions where necessary. Devices that no session could be established with are skipped.
+//
+// The returned request can be sent using [mautrix.Client.SendToDevice] with the [event.ToDeviceEncrypted] type.
+func (mach *OlmMachine) EncryptToDevices(ctx context.Context, evtType event.Type, content event.Content, devices map[id.UserID]map[id.DeviceID]*id.Device) (*mautrix.ReqSendToDevice, error) {
+	err := mach.createOutboundSessions(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	mach.olmLock.Lock()
+	defer mach.olmLock.Unlock()
+
+	log := mach.machOrContextLog(ctx)
+	req := &mautrix.ReqSendToDevice{Messages: make(map[id.UserID]map[id.DeviceID]*event.Content, len(devices))}
+	for userID, userDevices := range devices {
+		for deviceID, device := range userDevices {
+			olmSess, err := mach.CryptoStore.GetLatestSession(ctx, device.IdentityKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get olm session for device %s of %s: %w", deviceID, userID, err)
+			} else if olmSess == nil {
+				log.Warn().
+					Stringer("user_id", userID).
+					Stringer("device_id", deviceID).
+					Msg("No olm session found for device, not encrypting to-device event for it")
+				continue
+			}
+			if _, ok := req.Messages[userID]; !ok {
+				req.Messages[userID] = make(map[id.DeviceID]*event.Content, len(userDevices))
+			}
+			encrypted := mach.encryptOlmEvent(ctx, olmSess, device, evtType, content)
+			req.Messages[userID][deviceID] = &event.Content{Parsed: encrypted}
+		}
+	}
+	return req, nil
+}
@@ -12,7 +12,8 @@ import (
 	"maunium.net/go/mautrix/crypto/olm"
 )
 
-func init() {
+// Register sets the goolm account implementation as the one used by the olm package.
+func Register() {
 	olm.InitNewAccount = func(r io.Reader) (olm.Account, error) {
 		return NewAccount(r)
 	}
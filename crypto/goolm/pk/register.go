@@ -8,7 +8,8 @@ package pk
 
 import "maunium.net/go/mautrix/crypto/olm"
 
-func init() {
+// Register sets the goolm PK implementations as the ones used by the olm package.
+func Register() {
 	olm.InitNewPKSigningFromSeed = func(seed []byte) (olm.PKSigning, error) {
 		return NewSigningFromSeed(seed)
 	}
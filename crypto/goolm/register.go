@@ -7,15 +7,17 @@
 package goolm
 
 import (
-	// Need to import these subpackages to ensure they are registered
-	_ "maunium.net/go/mautrix/crypto/goolm/account"
-	_ "maunium.net/go/mautrix/crypto/goolm/pk"
-	_ "maunium.net/go/mautrix/crypto/goolm/session"
-
+	"maunium.net/go/mautrix/crypto/goolm/account"
+	"maunium.net/go/mautrix/crypto/goolm/pk"
+	"maunium.net/go/mautrix/crypto/goolm/session"
 	"maunium.net/go/mautrix/crypto/olm"
 )
 
-func init() {
+// Register sets the goolm implementations as the functions used by the olm package.
+func Register() {
+	account.Register()
+	pk.Register()
+	session.Register()
 	olm.GetVersion = func() (major, minor, patch uint8) {
 		return 3, 2, 15
 	}
@@ -23,3 +25,7 @@ func init() {
 		panic("gob and json encoding is deprecated and not supported with goolm")
 	}
 }
+
+func init() {
+	olm.RegisterBackend(olm.BackendGoolm, 0, Register)
+}
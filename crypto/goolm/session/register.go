@@ -10,7 +10,8 @@ import (
 	"maunium.net/go/mautrix/crypto/olm"
 )
 
-func init() {
+// Register sets the goolm session implementations as the ones used by the olm package.
+func Register() {
 	// Inbound Session
 	olm.InitInboundGroupSessionFromPickled = func(pickled, key []byte) (olm.InboundGroupSession, error) {
 		if len(pickled) == 0 {
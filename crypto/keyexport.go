@@ -8,6 +8,7 @@ package crypto
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -16,8 +17,10 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"go.mau.fi/util/random"
 	"golang.org/x/crypto/pbkdf2"
@@ -90,7 +93,7 @@ func exportSessions(sessions []*InboundGroupSession) ([]ExportedSession, error)
 			ForwardingChains:  session.ForwardingChains,
 			RoomID:            session.RoomID,
 			SenderKey:         session.SenderKey,
-			SenderClaimedKeys: SenderClaimedKeys{},
+			SenderClaimedKeys: SenderClaimedKeys{Ed25519: session.SigningKey},
 			SessionID:         session.ID(),
 			SessionKey:        string(key),
 		}
@@ -123,8 +126,9 @@ func formatKeyExportData(data []byte) []byte {
 		buf.WriteRune('\n')
 	}
 	buf.WriteString(exportSuffix)
-	if buf.Len() != buf.Cap() || buf.Len() != outputLength {
-		panic(fmt.Errorf("unexpected length %d / %d / %d", buf.Len(), buf.Cap(), outputLength))
+	// The buffer may have grown to more than the requested capacity, so only compare the length
+	if buf.Len() != outputLength {
+		panic(fmt.Errorf("unexpected length %d / %d", buf.Len(), outputLength))
 	}
 	return buf.Bytes()
 }
@@ -169,3 +173,38 @@ func ExportKeys(passphrase string, sessions []*InboundGroupSession) ([]byte, err
 	// Format the export (prefix, base64'd exportData, suffix) and return
 	return formatKeyExportData(exportData), nil
 }
+
+// ErrNoSessionsToExport is returned by [OlmMachine.ExportRoomKeys] if the room doesn't have any matching sessions.
+var ErrNoSessionsToExport = errors.New("no sessions to export")
+
+// ExportRoomKeys exports the inbound Megolm sessions of a single room in the format specified in the Matrix spec.
+// If since is non-zero, only sessions received at or after that time are included.
+//
+// The number of exported sessions is returned along with the export data.
+func (mach *OlmMachine) ExportRoomKeys(ctx context.Context, roomID id.RoomID, since time.Time, passphrase string) ([]byte, int, error) {
+	return mach.ExportKeysForRooms(ctx, []id.RoomID{roomID}, since, passphrase)
+}
+
+// ExportKeysForRooms is like [OlmMachine.ExportRoomKeys], but exports the sessions of multiple rooms into one file.
+func (mach *OlmMachine) ExportKeysForRooms(ctx context.Context, roomIDs []id.RoomID, since time.Time, passphrase string) ([]byte, int, error) {
+	var sessions []*InboundGroupSession
+	for _, roomID := range roomIDs {
+		err := mach.CryptoStore.GetGroupSessionsForRoom(ctx, roomID).Iter(func(session *InboundGroupSession) (bool, error) {
+			if since.IsZero() || !session.ReceivedAt.Before(since) {
+				sessions = append(sessions, session)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get sessions for %s: %w", roomID, err)
+		}
+	}
+	if len(sessions) == 0 {
+		return nil, 0, ErrNoSessionsToExport
+	}
+	data, err := ExportKeys(passphrase, sessions)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, len(sessions), nil
+}
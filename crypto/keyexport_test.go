@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportKeys(t *testing.T) {
+	machineOut := newMachine(t, "user1")
+	machineIn := newMachine(t, "user2")
+
+	outSess, err := machineOut.newOutboundGroupSession(context.TODO(), "room1")
+	require.NoError(t, err)
+	inSess, err := machineOut.CryptoStore.GetGroupSession(context.TODO(), "room1", outSess.ID())
+	require.NoError(t, err)
+
+	export, err := ExportKeys("hunter2", []*InboundGroupSession{inSess})
+	require.NoError(t, err)
+
+	_, _, err = machineIn.ImportKeys(context.TODO(), "wrong password", export)
+	assert.ErrorIs(t, err, ErrMismatchingExportHash)
+
+	imported, total, err := machineIn.ImportKeys(context.TODO(), "hunter2", export)
+	require.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, total)
+
+	importedSess, err := machineIn.CryptoStore.GetGroupSession(context.TODO(), "room1", outSess.ID())
+	require.NoError(t, err)
+	require.NotNil(t, importedSess)
+	assert.Equal(t, inSess.SigningKey, importedSess.SigningKey)
+	assert.Equal(t, inSess.SenderKey, importedSess.SenderKey)
+
+	imported, _, err = machineIn.ImportKeys(context.TODO(), "hunter2", export)
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported, "already known session shouldn't be imported again")
+}
+
+func TestExportRoomKeys(t *testing.T) {
+	machineOut := newMachine(t, "user1")
+	machineIn := newMachine(t, "user2")
+
+	outSess, err := machineOut.newOutboundGroupSession(context.TODO(), "room1")
+	require.NoError(t, err)
+	_, err = machineOut.newOutboundGroupSession(context.TODO(), "room2")
+	require.NoError(t, err)
+
+	_, _, err = machineOut.ExportRoomKeys(context.TODO(), "room1", time.Now().Add(time.Hour), "hunter2")
+	assert.ErrorIs(t, err, ErrNoSessionsToExport)
+
+	export, count, err := machineOut.ExportRoomKeys(context.TODO(), "room1", time.Now().Add(-time.Hour), "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	imported, total, err := machineIn.ImportKeys(context.TODO(), "hunter2", export)
+	require.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, total)
+	importedSess, err := machineIn.CryptoStore.GetGroupSession(context.TODO(), "room1", outSess.ID())
+	require.NoError(t, err)
+	assert.NotNil(t, importedSess)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrKeyUploadOTKConflict       = errors.New("server already had one-time keys with the same IDs")
+	ErrKeyUploadTooManyOTKs       = errors.New("server has more one-time keys than the local account can hold")
+	ErrKeyUploadDeviceKeysMissing = errors.New("device keys were missing from the server")
+
+	ErrServerDeviceKeysMismatch = errors.New("device keys on the server don't match the local account")
+)
+
+func (mach *OlmMachine) emitKeyUploadWarning(ctx context.Context, err error) {
+	mach.machOrContextLog(ctx).Warn().Err(err).Msg("Keys on server were out of sync with local account")
+	if mach.KeyUploadWarning != nil {
+		mach.KeyUploadWarning(ctx, err)
+	}
+}
+
+// fetchOwnDeviceKeys fetches the device keys of the current device from the server.
+// If the server doesn't have any keys for the device, this returns nil without an error.
+func (mach *OlmMachine) fetchOwnDeviceKeys(ctx context.Context) (*mautrix.DeviceKeys, error) {
+	resp, err := mach.Client.QueryKeys(ctx, &mautrix.ReqQueryKeys{
+		DeviceKeys: mautrix.DeviceKeysRequest{
+			mach.Client.UserID: mautrix.DeviceIDList{mach.Client.DeviceID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query own device keys: %w", err)
+	}
+	deviceKeys, ok := resp.DeviceKeys[mach.Client.UserID][mach.Client.DeviceID]
+	if !ok {
+		return nil, nil
+	}
+	return &deviceKeys, nil
+}
+
+// checkServerDeviceKeys makes sure the server doesn't have different device keys for the current device ID
+// before the local account keys are uploaded. The server would reject the upload anyway, but it's better to
+// fail with a clear error than to keep uploading one-time keys that don't belong to the device.
+func (mach *OlmMachine) checkServerDeviceKeys(ctx context.Context, localKeys *mautrix.DeviceKeys) error {
+	serverKeys, err := mach.fetchOwnDeviceKeys(ctx)
+	if err != nil {
+		return err
+	} else if serverKeys == nil {
+		return nil
+	}
+	if serverKeys.Keys.GetEd25519(mach.Client.DeviceID) != localKeys.Keys.GetEd25519(mach.Client.DeviceID) ||
+		serverKeys.Keys.GetCurve25519(mach.Client.DeviceID) != localKeys.Keys.GetCurve25519(mach.Client.DeviceID) {
+		return ErrServerDeviceKeysMismatch
+	}
+	return nil
+}
+
+// checkKeyCountMismatch checks whether the server still has the device keys of an already shared account
+// when the server reports that there are no one-time keys left. If the keys are gone (e.g. because the
+// server-side device was reset), the account is marked as unshared so that everything is re-uploaded.
+func (mach *OlmMachine) checkKeyCountMismatch(ctx context.Context, currentOTKCount int) error {
+	if !mach.account.Shared || currentOTKCount != 0 {
+		return nil
+	}
+	serverKeys, err := mach.fetchOwnDeviceKeys(ctx)
+	if err != nil {
+		return err
+	} else if serverKeys == nil {
+		mach.account.Shared = false
+		mach.emitKeyUploadWarning(ctx, ErrKeyUploadDeviceKeysMissing)
+	} else if serverKeys.Keys.GetEd25519(mach.Client.DeviceID) != mach.account.SigningKey() {
+		return ErrServerDeviceKeysMismatch
+	}
+	return nil
+}
+
+func isOTKAlreadyExistsError(err error) bool {
+	var respErr mautrix.RespError
+	return errors.As(err, &respErr) &&
+		respErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(respErr.Err, "already exists")
+}
+
+// recoverFromOTKConflict handles the server rejecting a key upload because some of the one-time key IDs
+// already exist. This happens if a previous upload succeeded but the response was lost, or if the server
+// has keys from an earlier incarnation of the account. The conflicting keys are dropped from the upload queue
+// and a fresh batch with new IDs is uploaded instead.
+func (mach *OlmMachine) recoverFromOTKConflict(ctx context.Context, req *mautrix.ReqUploadKeys, uploadErr error) error {
+	log := mach.machOrContextLog(ctx)
+	log.Warn().Err(uploadErr).Msg("Server rejected one-time key upload due to conflicting key IDs, generating new keys")
+	mach.emitKeyUploadWarning(ctx, ErrKeyUploadOTKConflict)
+	mach.account.Internal.MarkKeysAsPublished()
+	halfMax := int(mach.account.Internal.MaxNumberOfOneTimeKeys() / 2)
+	fallbackKeys, err := mach.account.getFallbackKeys(mach.Client.UserID, mach.Client.DeviceID, len(req.FallbackKeys) > 0)
+	if err != nil {
+		return fmt.Errorf("failed to regenerate fallback key: %w", err)
+	}
+	newReq := &mautrix.ReqUploadKeys{
+		DeviceKeys:   req.DeviceKeys,
+		OneTimeKeys:  mach.account.getOneTimeKeys(mach.Client.UserID, mach.Client.DeviceID, halfMax-len(req.OneTimeKeys)),
+		FallbackKeys: fallbackKeys,
+	}
+	if err = mach.saveAccount(ctx); err != nil {
+		return err
+	}
+	log.Debug().Int("count", len(newReq.OneTimeKeys)).Msg("Retrying one-time key upload with new keys")
+	_, err = mach.Client.UploadKeys(ctx, newReq)
+	if err != nil {
+		return fmt.Errorf("failed to upload new keys after conflict: %w", err)
+	}
+	return nil
+}
+
+// HandleUnusedFallbackKeys uploads a new fallback key if the server reports that it doesn't have an unused one.
+//
+// The list of unused fallback key algorithms is nil if the server didn't include it, in which case nothing is done.
+// This is called automatically by [OlmMachine.ProcessSyncResponse].
+func (mach *OlmMachine) HandleUnusedFallbackKeys(ctx context.Context, unusedTypes []id.KeyAlgorithm) {
+	if unusedTypes == nil || !mach.account.Shared || slices.Contains(unusedTypes, id.KeyAlgorithmSignedCurve25519) {
+		return
+	} else if _, ok := mach.account.Internal.(fallbackKeyAccount); !ok {
+		return
+	}
+	log := mach.machOrContextLog(ctx)
+	log.Debug().Msg("Server doesn't have an unused fallback key, uploading a new one")
+	err := mach.shareKeys(ctx, int(mach.account.Internal.MaxNumberOfOneTimeKeys()/2), true)
+	if err != nil {
+		log.Err(err).Msg("Failed to upload new fallback key")
+	}
+}
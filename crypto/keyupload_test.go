@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func newKeyUploadTestMachine(t *testing.T, handler http.HandlerFunc) (*OlmMachine, *[]error) {
+	mach := newMachine(t, "@user1:example.com")
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	var err error
+	mach.Client.HomeserverURL, err = url.Parse(srv.URL)
+	require.NoError(t, err)
+	var warnings []error
+	mach.KeyUploadWarning = func(ctx context.Context, err error) {
+		warnings = append(warnings, err)
+	}
+	return mach, &warnings
+}
+
+func TestOlmMachine_ShareKeys_OTKConflict(t *testing.T) {
+	var uploads []*mautrix.ReqUploadKeys
+	mach, warnings := newKeyUploadTestMachine(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/keys/query":
+			_, _ = w.Write([]byte(`{"device_keys": {}}`))
+		case "/_matrix/client/v3/keys/upload":
+			var req mautrix.ReqUploadKeys
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			uploads = append(uploads, &req)
+			if len(uploads) == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errcode": "M_UNKNOWN", "error": "One time key signed_curve25519:AAAAAQ already exists"}`))
+			} else {
+				_, _ = w.Write([]byte(`{"one_time_key_counts": {"signed_curve25519": 50}}`))
+			}
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	})
+	require.NoError(t, mach.ShareKeys(context.Background(), 0))
+	require.Len(t, uploads, 2)
+	assert.Equal(t, []error{ErrKeyUploadOTKConflict}, *warnings)
+	assert.NotNil(t, uploads[1].DeviceKeys)
+	assert.Len(t, uploads[1].OneTimeKeys, len(uploads[0].OneTimeKeys))
+	for keyID := range uploads[1].OneTimeKeys {
+		assert.NotContains(t, uploads[0].OneTimeKeys, keyID)
+	}
+	assert.Len(t, uploads[0].FallbackKeys, 1)
+	assert.Len(t, uploads[1].FallbackKeys, 1)
+	for keyID, key := range uploads[1].FallbackKeys {
+		assert.NotContains(t, uploads[0].FallbackKeys, keyID)
+		assert.True(t, key.Fallback)
+	}
+	assert.True(t, mach.account.Shared)
+}
+
+func TestOlmMachine_ShareKeys_DeviceKeysMissing(t *testing.T) {
+	var uploads []*mautrix.ReqUploadKeys
+	mach, warnings := newKeyUploadTestMachine(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/keys/query":
+			_, _ = w.Write([]byte(`{"device_keys": {"@user1:example.com": {}}}`))
+		case "/_matrix/client/v3/keys/upload":
+			var req mautrix.ReqUploadKeys
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			uploads = append(uploads, &req)
+			_, _ = w.Write([]byte(`{"one_time_key_counts": {"signed_curve25519": 50}}`))
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	})
+	mach.account.Shared = true
+	require.NoError(t, mach.ShareKeys(context.Background(), 0))
+	assert.Equal(t, []error{ErrKeyUploadDeviceKeysMissing}, *warnings)
+	require.Len(t, uploads, 1)
+	require.NotNil(t, uploads[0].DeviceKeys)
+	assert.Equal(t, mach.account.SigningKey(), uploads[0].DeviceKeys.Keys.GetEd25519(mach.Client.DeviceID))
+	assert.NotEmpty(t, uploads[0].OneTimeKeys)
+	assert.Len(t, uploads[0].FallbackKeys, 1)
+	assert.True(t, mach.account.Shared)
+}
+
+func TestOlmMachine_ShareKeys_ServerDeviceKeysMismatch(t *testing.T) {
+	mach, _ := newKeyUploadTestMachine(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/keys/query":
+			_, _ = w.Write([]byte(`{"device_keys": {"@user1:example.com": {"device1": {
+				"user_id": "@user1:example.com",
+				"device_id": "device1",
+				"keys": {"ed25519:device1": "other", "curve25519:device1": "other"}
+			}}}}`))
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	})
+	err := mach.ShareKeys(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrServerDeviceKeysMismatch)
+	assert.False(t, mach.account.Shared)
+}
+
+func TestOlmMachine_HandleUnusedFallbackKeys(t *testing.T) {
+	var uploads []*mautrix.ReqUploadKeys
+	mach, _ := newKeyUploadTestMachine(t, func(w http.ResponseWriter, r *http.Request) {
+		var req mautrix.ReqUploadKeys
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		uploads = append(uploads, &req)
+		_, _ = w.Write([]byte(`{"one_time_key_counts": {"signed_curve25519": 50}}`))
+	})
+	mach.account.Shared = true
+	mach.HandleUnusedFallbackKeys(context.Background(), nil)
+	mach.HandleUnusedFallbackKeys(context.Background(), []id.KeyAlgorithm{id.KeyAlgorithmSignedCurve25519})
+	assert.Empty(t, uploads)
+	mach.HandleUnusedFallbackKeys(context.Background(), []id.KeyAlgorithm{})
+	require.Len(t, uploads, 1)
+	assert.Nil(t, uploads[0].DeviceKeys)
+	assert.Empty(t, uploads[0].OneTimeKeys)
+	assert.Len(t, uploads[0].FallbackKeys, 1)
+}
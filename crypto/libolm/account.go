@@ -23,7 +23,7 @@ type Account struct {
 	mem []byte
 }
 
-func init() {
+func registerAccount() {
 	olm.InitNewAccount = func(r io.Reader) (olm.Account, error) {
 		return NewAccount(r)
 	}
@@ -20,7 +20,7 @@ type InboundGroupSession struct {
 	mem []byte
 }
 
-func init() {
+func registerInboundGroupSession() {
 	olm.InitInboundGroupSessionFromPickled = func(pickled, key []byte) (olm.InboundGroupSession, error) {
 		return InboundGroupSessionFromPickled(pickled, key)
 	}
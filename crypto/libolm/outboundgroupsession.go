@@ -20,7 +20,7 @@ type OutboundGroupSession struct {
 	mem []byte
 }
 
-func init() {
+func registerOutboundGroupSession() {
 	olm.InitNewOutboundGroupSessionFromPickled = func(pickled, key []byte) (olm.OutboundGroupSession, error) {
 		if len(pickled) == 0 {
 			return nil, olm.EmptyInput
@@ -34,7 +34,7 @@ type PKSigning struct {
 // Ensure that [PKSigning] implements [olm.PKSigning].
 var _ olm.PKSigning = (*PKSigning)(nil)
 
-func init() {
+func registerPK() {
 	olm.InitNewPKSigning = func() (olm.PKSigning, error) { return NewPKSigning() }
 	olm.InitNewPKSigningFromSeed = func(seed []byte) (olm.PKSigning, error) {
 		return NewPKSigningFromSeed(seed)
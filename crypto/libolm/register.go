@@ -7,7 +7,13 @@ import "maunium.net/go/mautrix/crypto/olm"
 
 var pickleKey = []byte("maunium.net/go/mautrix/crypto/olm")
 
-func init() {
+// Register sets the libolm implementations as the functions used by the olm package.
+func Register() {
+	registerAccount()
+	registerSession()
+	registerInboundGroupSession()
+	registerOutboundGroupSession()
+	registerPK()
 	olm.GetVersion = func() (major, minor, patch uint8) {
 		C.olm_get_library_version(
 			(*C.uint8_t)(&major),
@@ -19,3 +25,7 @@ func init() {
 		pickleKey = key
 	}
 }
+
+func init() {
+	olm.RegisterBackend(olm.BackendLibolm, 10, Register)
+}
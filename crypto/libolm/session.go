@@ -38,7 +38,7 @@ type Session struct {
 // Ensure that [Session] implements [olm.Session].
 var _ olm.Session = (*Session)(nil)
 
-func init() {
+func registerSession() {
 	olm.InitSessionFromPickled = func(pickled, key []byte) (olm.Session, error) {
 		return SessionFromPickled(pickled, key)
 	}
@@ -56,6 +56,10 @@ type OlmMachine struct {
 
 	// Optional callback which is called when we save a session to store
 	SessionReceived func(context.Context, id.RoomID, id.SessionID, uint32)
+	// Optional callback which is called when the keys on the server were found to be out of sync with
+	// the local account and had to be regenerated. The error is one of the ErrKeyUpload* values.
+	// Olm sessions that other devices create using the orphaned keys will fail to decrypt.
+	KeyUploadWarning func(context.Context, error)
 
 	devicesToUnwedge     map[id.IdentityKey]bool
 	devicesToUnwedgeLock sync.Mutex
@@ -69,6 +73,7 @@ type OlmMachine struct {
 	otkUploadLock       sync.Mutex
 	lastOTKUpload       time.Time
 	receivedOTKsForSelf atomic.Bool
+	warnedTooManyOTKs   atomic.Bool
 
 	CrossSigningKeys    *CrossSigningKeysCache
 	crossSigningPubkeys *CrossSigningPublicKeysCache
@@ -272,7 +277,11 @@ func (mach *OlmMachine) HandleOTKCounts(ctx context.Context, otkCount *mautrix.O
 		mach.receivedOTKsForSelf.Store(true)
 	}
 
-	minCount := mach.account.Internal.MaxNumberOfOneTimeKeys() / 2
+	maxCount := mach.account.Internal.MaxNumberOfOneTimeKeys()
+	if otkCount.SignedCurve25519 > int(maxCount) && !mach.warnedTooManyOTKs.Swap(true) {
+		mach.emitKeyUploadWarning(ctx, ErrKeyUploadTooManyOTKs)
+	}
+	minCount := maxCount / 2
 	if otkCount.SignedCurve25519 < int(minCount) {
 		traceID := time.Now().Format("15:04:05.000000")
 		log := mach.Log.With().Str("trace_id", traceID).Logger()
@@ -308,6 +317,7 @@ func (mach *OlmMachine) ProcessSyncResponse(ctx context.Context, resp *mautrix.R
 	}
 
 	mach.HandleOTKCounts(ctx, &resp.DeviceOTKCount)
+	mach.HandleUnusedFallbackKeys(ctx, resp.FallbackKeys)
 	return true
 }
 
@@ -662,6 +672,10 @@ func (mach *OlmMachine) HandleRoomKeyWithheld(ctx context.Context, content *even
 // If currentOTKCount is less than half of the limit (100 / 2 = 50), enough one-time keys will be uploaded so exactly
 // half of the limit is filled.
 func (mach *OlmMachine) ShareKeys(ctx context.Context, currentOTKCount int) error {
+	return mach.shareKeys(ctx, currentOTKCount, false)
+}
+
+func (mach *OlmMachine) shareKeys(ctx context.Context, currentOTKCount int, newFallbackKey bool) error {
 	log := mach.machOrContextLog(ctx)
 	start := time.Now()
 	mach.otkUploadLock.Lock()
@@ -678,10 +692,17 @@ func (mach *OlmMachine) ShareKeys(ctx context.Context, currentOTKCount int) erro
 			Msg("Fetched current OTK count from server")
 		currentOTKCount = resp.OneTimeKeyCounts.SignedCurve25519
 	}
+	if err := mach.checkKeyCountMismatch(ctx, currentOTKCount); err != nil {
+		return err
+	}
 	var deviceKeys *mautrix.DeviceKeys
 	if !mach.account.Shared {
 		deviceKeys = mach.account.getInitialKeys(mach.Client.UserID, mach.Client.DeviceID)
-		err := mach.CryptoStore.PutDevice(ctx, mach.Client.UserID, &id.Device{
+		err := mach.checkServerDeviceKeys(ctx, deviceKeys)
+		if err != nil {
+			return err
+		}
+		err = mach.CryptoStore.PutDevice(ctx, mach.Client.UserID, &id.Device{
 			UserID:      mach.Client.UserID,
 			DeviceID:    mach.Client.DeviceID,
 			IdentityKey: deviceKeys.Keys.GetCurve25519(mach.Client.DeviceID),
@@ -691,23 +712,35 @@ func (mach *OlmMachine) ShareKeys(ctx context.Context, currentOTKCount int) erro
 			return fmt.Errorf("failed to save initial keys: %w", err)
 		}
 		log.Debug().Msg("Going to upload initial account keys")
+		newFallbackKey = true
 	}
 	oneTimeKeys := mach.account.getOneTimeKeys(mach.Client.UserID, mach.Client.DeviceID, currentOTKCount)
-	if len(oneTimeKeys) == 0 && deviceKeys == nil {
+	fallbackKeys, err := mach.account.getFallbackKeys(mach.Client.UserID, mach.Client.DeviceID, newFallbackKey)
+	if err != nil {
+		return fmt.Errorf("failed to get fallback keys: %w", err)
+	}
+	if len(oneTimeKeys) == 0 && len(fallbackKeys) == 0 && deviceKeys == nil {
 		log.Debug().Msg("No one-time keys nor device keys got when trying to share keys")
 		return nil
 	}
 	// Save the keys before sending the upload request in case there is a
 	// network failure.
-	if err := mach.saveAccount(ctx); err != nil {
+	if err = mach.saveAccount(ctx); err != nil {
 		return err
 	}
 	req := &mautrix.ReqUploadKeys{
-		DeviceKeys:  deviceKeys,
-		OneTimeKeys: oneTimeKeys,
+		DeviceKeys:   deviceKeys,
+		OneTimeKeys:  oneTimeKeys,
+		FallbackKeys: fallbackKeys,
+	}
+	log.Debug().
+		Int("count", len(oneTimeKeys)).
+		Bool("fallback_key", len(fallbackKeys) > 0).
+		Msg("Uploading one-time keys")
+	_, err = mach.Client.UploadKeys(ctx, req)
+	if isOTKAlreadyExistsError(err) {
+		err = mach.recoverFromOTKConflict(ctx, req, err)
 	}
-	log.Debug().Int("count", len(oneTimeKeys)).Msg("Uploading one-time keys")
-	_, err := mach.Client.UploadKeys(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -174,3 +174,47 @@ func TestOlmMachineOlmMegolmSessions(t *testing.T) {
 		t.Error("Megolm outbound session not expired after 3rd message")
 	}
 }
+
+func TestOlmMachine_EncryptToDevices(t *testing.T) {
+	machineOut := newMachine(t, "user1")
+	machineIn := newMachine(t, "user2")
+
+	otks := machineIn.account.getOneTimeKeys("user2", "device2", 0)
+	var otk mautrix.OneTimeKey
+	for _, otkTmp := range otks {
+		otk = otkTmp
+		break
+	}
+	machineIn.account.Internal.MarkKeysAsPublished()
+	olmSession, err := machineOut.account.Internal.NewOutboundSession(machineIn.account.IdentityKey(), otk.Key)
+	require.NoError(t, err)
+	err = machineOut.CryptoStore.AddSession(context.TODO(), machineIn.account.IdentityKey(), wrapSession(olmSession))
+	require.NoError(t, err)
+
+	evtType := event.Type{Type: "com.example.custom", Class: event.ToDeviceEventType}
+	req, err := machineOut.EncryptToDevices(context.TODO(), evtType, event.Content{VeryRaw: []byte(`{"hello":"world"}`)}, map[id.UserID]map[id.DeviceID]*id.Device{
+		"user2": {
+			"device2": {
+				UserID:      "user2",
+				DeviceID:    "device2",
+				IdentityKey: machineIn.account.IdentityKey(),
+				SigningKey:  machineIn.account.SigningKey(),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, req.Messages, id.UserID("user2"))
+	encrypted, ok := req.Messages["user2"]["device2"].Parsed.(*event.EncryptedEventContent)
+	require.True(t, ok)
+	assert.Equal(t, id.AlgorithmOlmV1, encrypted.Algorithm)
+	ciphertext, ok := encrypted.OlmCiphertext[machineIn.account.IdentityKey()]
+	require.True(t, ok)
+
+	decrypted, err := machineIn.decryptAndParseOlmCiphertext(context.TODO(), &event.Event{
+		Type:   event.ToDeviceEncrypted,
+		Sender: "user1",
+	}, machineOut.account.IdentityKey(), ciphertext.Type, ciphertext.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.custom", decrypted.Type.Type)
+	assert.JSONEq(t, `{"hello":"world"}`, string(decrypted.Content.VeryRaw))
+}
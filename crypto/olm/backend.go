@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package olm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Backend is the name of an olm implementation.
+type Backend string
+
+const (
+	// BackendLibolm is the cgo wrapper for the C/C++ libolm library.
+	BackendLibolm Backend = "libolm"
+	// BackendGoolm is the pure Go implementation.
+	BackendGoolm Backend = "goolm"
+)
+
+var ErrUnknownBackend = errors.New("unknown olm backend")
+
+type registeredBackend struct {
+	priority int
+	activate func()
+}
+
+var (
+	backendsLock  sync.Mutex
+	backends      = make(map[Backend]*registeredBackend)
+	activeBackend Backend
+	// Whether SetBackend has been called. If true, registering new backends won't change the active one.
+	backendLocked bool
+)
+
+// RegisterBackend registers an olm implementation. The activate function must set all the Init* functions
+// in this package, as well as GetVersion and SetPickleKeyImpl.
+//
+// The backend with the highest priority is activated automatically unless SetBackend has been called.
+// This is meant to be called from the init function of the implementing package.
+func RegisterBackend(name Backend, priority int, activate func()) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	backends[name] = &registeredBackend{priority: priority, activate: activate}
+	if backendLocked {
+		return
+	}
+	if current, ok := backends[activeBackend]; !ok || current.priority <= priority {
+		activate()
+		activeBackend = name
+	}
+}
+
+// SetBackend switches the olm implementation used by the top-level constructors in this package.
+//
+// This should be called before any olm objects are created. Objects created using the previous backend
+// will continue to work, and pickles are compatible between backends, but mixing backends is not recommended.
+func SetBackend(name Backend) error {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	backend, ok := backends[name]
+	if !ok {
+		return fmt.Errorf("%w %q (available: %v)", ErrUnknownBackend, name, availableBackends())
+	}
+	backend.activate()
+	activeBackend = name
+	backendLocked = true
+	return nil
+}
+
+// ActiveBackend returns the name of the olm implementation that is currently in use.
+func ActiveBackend() Backend {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	return activeBackend
+}
+
+// AvailableBackends returns the names of all olm implementations that were compiled in.
+func AvailableBackends() []Backend {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	return availableBackends()
+}
+
+func availableBackends() []Backend {
+	names := make([]Backend, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
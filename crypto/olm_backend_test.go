@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/id"
+)
+
+// withBackend activates the given olm backend for the duration of the test or benchmark.
+func withBackend(tb testing.TB, backend olm.Backend) {
+	tb.Helper()
+	prev := olm.ActiveBackend()
+	require.NoError(tb, olm.SetBackend(backend))
+	tb.Cleanup(func() {
+		_ = olm.SetBackend(prev)
+	})
+}
+
+func newOlmSessionPair(tb testing.TB) (sender, receiver olm.Session) {
+	tb.Helper()
+	senderAccount, err := olm.NewAccount(nil)
+	require.NoError(tb, err)
+	receiverAccount, err := olm.NewAccount(nil)
+	require.NoError(tb, err)
+	require.NoError(tb, receiverAccount.GenOneTimeKeys(nil, 1))
+	_, receiverIdentityKey, err := receiverAccount.IdentityKeys()
+	require.NoError(tb, err)
+	otks, err := receiverAccount.OneTimeKeys()
+	require.NoError(tb, err)
+	var otk id.Curve25519
+	for _, otk = range otks {
+		break
+	}
+	sender, err = senderAccount.NewOutboundSession(receiverIdentityKey, otk)
+	require.NoError(tb, err)
+	msgType, ciphertext, err := sender.Encrypt([]byte("hello"))
+	require.NoError(tb, err)
+	receiver, err = receiverAccount.NewInboundSession(string(ciphertext))
+	require.NoError(tb, err)
+	_, err = receiver.Decrypt(string(ciphertext), msgType)
+	require.NoError(tb, err)
+	return
+}
+
+func TestSetBackend_Unknown(t *testing.T) {
+	prev := olm.ActiveBackend()
+	assert.ErrorIs(t, olm.SetBackend("nonexistent"), olm.ErrUnknownBackend)
+	assert.Equal(t, prev, olm.ActiveBackend())
+}
+
+func TestBackends_MegolmParity(t *testing.T) {
+	for _, encryptBackend := range olm.AvailableBackends() {
+		for _, decryptBackend := range olm.AvailableBackends() {
+			t.Run(fmt.Sprintf("%s->%s", encryptBackend, decryptBackend), func(t *testing.T) {
+				withBackend(t, encryptBackend)
+				outbound := olm.NewOutboundGroupSession()
+				sessionKey := outbound.Key()
+				ciphertexts := make([][]byte, 5)
+				for i := range ciphertexts {
+					var err error
+					ciphertexts[i], err = outbound.Encrypt([]byte(fmt.Sprintf("message %d", i)))
+					require.NoError(t, err)
+				}
+
+				require.NoError(t, olm.SetBackend(decryptBackend))
+				inbound, err := olm.NewInboundGroupSession([]byte(sessionKey))
+				require.NoError(t, err)
+				assert.Equal(t, outbound.ID(), inbound.ID())
+				for i, ciphertext := range ciphertexts {
+					plaintext, index, err := inbound.Decrypt(ciphertext)
+					require.NoError(t, err)
+					assert.EqualValues(t, i, index)
+					assert.Equal(t, fmt.Sprintf("message %d", i), string(plaintext))
+				}
+			})
+		}
+	}
+}
+
+func TestBackends_PickleParity(t *testing.T) {
+	pickleKey := []byte("secret_key")
+	for _, pickleBackend := range olm.AvailableBackends() {
+		for _, unpickleBackend := range olm.AvailableBackends() {
+			t.Run(fmt.Sprintf("%s->%s", pickleBackend, unpickleBackend), func(t *testing.T) {
+				withBackend(t, pickleBackend)
+				account, err := olm.NewAccount(nil)
+				require.NoError(t, err)
+				pickledAccount, err := account.Pickle(pickleKey)
+				require.NoError(t, err)
+				sender, _ := newOlmSessionPair(t)
+				pickledSession, err := sender.Pickle(pickleKey)
+				require.NoError(t, err)
+
+				require.NoError(t, olm.SetBackend(unpickleBackend))
+				unpickledAccount, err := olm.AccountFromPickled(pickledAccount, pickleKey)
+				require.NoError(t, err)
+				origSigning, origIdentity, err := account.IdentityKeys()
+				require.NoError(t, err)
+				signing, identity, err := unpickledAccount.IdentityKeys()
+				require.NoError(t, err)
+				assert.Equal(t, origSigning, signing)
+				assert.Equal(t, origIdentity, identity)
+
+				unpickledSession, err := olm.SessionFromPickled(pickledSession, pickleKey)
+				require.NoError(t, err)
+				assert.Equal(t, sender.ID(), unpickledSession.ID())
+			})
+		}
+	}
+}
+
+func BenchmarkAccount_New(b *testing.B) {
+	for _, backend := range olm.AvailableBackends() {
+		b.Run(string(backend), func(b *testing.B) {
+			withBackend(b, backend)
+			for i := 0; i < b.N; i++ {
+				_, err := olm.NewAccount(nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMegolm_Encrypt(b *testing.B) {
+	plaintext := make([]byte, 1024)
+	for _, backend := range olm.AvailableBackends() {
+		b.Run(string(backend), func(b *testing.B) {
+			withBackend(b, backend)
+			outbound := olm.NewOutboundGroupSession()
+			b.SetBytes(int64(len(plaintext)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := outbound.Encrypt(plaintext)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMegolm_Decrypt(b *testing.B) {
+	plaintext := make([]byte, 1024)
+	for _, backend := range olm.AvailableBackends() {
+		b.Run(string(backend), func(b *testing.B) {
+			withBackend(b, backend)
+			outbound := olm.NewOutboundGroupSession()
+			inbound, err := olm.NewInboundGroupSession([]byte(outbound.Key()))
+			require.NoError(b, err)
+			ciphertext, err := outbound.Encrypt(plaintext)
+			require.NoError(b, err)
+			b.SetBytes(int64(len(plaintext)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err = inbound.Decrypt(ciphertext)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkOlm_EncryptDecrypt(b *testing.B) {
+	plaintext := make([]byte, 1024)
+	for _, backend := range olm.AvailableBackends() {
+		b.Run(string(backend), func(b *testing.B) {
+			withBackend(b, backend)
+			sender, receiver := newOlmSessionPair(b)
+			b.SetBytes(int64(len(plaintext)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msgType, ciphertext, err := sender.Encrypt(plaintext)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = receiver.Decrypt(string(ciphertext), msgType)
+				if err != nil {
+					b.Fatal(err)
+				}
+				sender, receiver = receiver, sender
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrChannelNotEstablished  = errors.New("secure channel is not established")
+	ErrInvalidChannelMessage  = errors.New("invalid secure channel message")
+	ErrUnexpectedHandshake    = errors.New("unexpected secure channel handshake message")
+	ErrCheckCodeMismatch      = errors.New("check code doesn't match")
+	ErrChannelAlreadyAssigned = errors.New("secure channel is already established")
+)
+
+const (
+	channelAppInfo          = "MATRIX_QR_CODE_LOGIN"
+	channelInitiateMessage  = "MATRIX_QR_CODE_LOGIN_INITIATE"
+	channelAcknowledgement  = "MATRIX_QR_CODE_LOGIN_OK"
+	channelPublicKeyLength  = 32
+	channelNonceCounterSize = 8
+)
+
+// SecureChannel is an ECIES channel on top of a rendezvous session. Both sides generate an ephemeral X25519 key.
+// The side that scanned the QR code (the initiator) sends its public key in the first message, after which both
+// sides derive a separate ChaCha20-Poly1305 key for each direction using HKDF-SHA512 over the shared secret.
+//
+// Messages are encrypted with a counter nonce, which means they must be decrypted in the order they were sent.
+type SecureChannel struct {
+	privateKey *ecdh.PrivateKey
+
+	sendCipher  cipher.AEAD
+	recvCipher  cipher.AEAD
+	sendCounter uint64
+	recvCounter uint64
+	checkCode   [2]byte
+}
+
+// NewSecureChannel generates a new ephemeral key for a secure channel.
+func NewSecureChannel() (*SecureChannel, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	return &SecureChannel{privateKey: key}, nil
+}
+
+// PublicKey returns the ephemeral public key of this side of the channel.
+func (sc *SecureChannel) PublicKey() [32]byte {
+	return [32]byte(sc.privateKey.PublicKey().Bytes())
+}
+
+// IsEstablished returns true if the shared keys of the channel have been derived.
+func (sc *SecureChannel) IsEstablished() bool {
+	return sc.sendCipher != nil
+}
+
+// CheckCode returns the two-digit code that the user must compare between the two devices
+// to make sure the channel hasn't been intercepted.
+func (sc *SecureChannel) CheckCode() string {
+	return fmt.Sprintf("%d%d", sc.checkCode[0]%10, sc.checkCode[1]%10)
+}
+
+func (sc *SecureChannel) establish(theirKey []byte, initiator bool) error {
+	if sc.IsEstablished() {
+		return ErrChannelAlreadyAssigned
+	}
+	theirPublicKey, err := ecdh.X25519().NewPublicKey(theirKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	sharedSecret, err := sc.privateKey.ECDH(theirPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	ourKey := sc.privateKey.PublicKey().Bytes()
+	initiatorKey, recipientKey := ourKey, theirKey
+	if !initiator {
+		initiatorKey, recipientKey = theirKey, ourKey
+	}
+	keyInfo := "|" + base64.RawStdEncoding.EncodeToString(initiatorKey) + "|" + base64.RawStdEncoding.EncodeToString(recipientKey)
+	derive := func(label string, length int) ([]byte, error) {
+		out := make([]byte, length)
+		_, err := io.ReadFull(hkdf.New(sha512.New, sharedSecret, nil, []byte(channelAppInfo+"_"+label+keyInfo)), out)
+		return out, err
+	}
+	initiatorSendKey, err := derive("ENCKEY_INITIATOR", chacha20poly1305.KeySize)
+	if err != nil {
+		return err
+	}
+	recipientSendKey, err := derive("ENCKEY_RECIPIENT", chacha20poly1305.KeySize)
+	if err != nil {
+		return err
+	}
+	checkCode, err := derive("CHECKCODE", 2)
+	if err != nil {
+		return err
+	}
+	if !initiator {
+		initiatorSendKey, recipientSendKey = recipientSendKey, initiatorSendKey
+	}
+	sendCipher, err := chacha20poly1305.New(initiatorSendKey)
+	if err != nil {
+		return err
+	}
+	recvCipher, err := chacha20poly1305.New(recipientSendKey)
+	if err != nil {
+		return err
+	}
+	sc.sendCipher, sc.recvCipher = sendCipher, recvCipher
+	sc.checkCode = [2]byte(checkCode)
+	return nil
+}
+
+func counterNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[chacha20poly1305.NonceSize-channelNonceCounterSize:], counter)
+	return nonce
+}
+
+func (sc *SecureChannel) seal(plaintext []byte) []byte {
+	ciphertext := sc.sendCipher.Seal(nil, counterNonce(sc.sendCounter), plaintext, nil)
+	sc.sendCounter++
+	return ciphertext
+}
+
+func (sc *SecureChannel) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := sc.recvCipher.Open(nil, counterNonce(sc.recvCounter), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChannelMessage, err)
+	}
+	sc.recvCounter++
+	return plaintext, nil
+}
+
+// Initiate establishes the channel with the public key from a scanned QR code
+// and returns the initial message to send to the other side.
+func (sc *SecureChannel) Initiate(theirKey [32]byte) ([]byte, error) {
+	err := sc.establish(theirKey[:], true)
+	if err != nil {
+		return nil, err
+	}
+	ourKey := sc.privateKey.PublicKey().Bytes()
+	message := append(ourKey, sc.seal([]byte(channelInitiateMessage))...)
+	return []byte(base64.RawStdEncoding.EncodeToString(message)), nil
+}
+
+// Accept establishes the channel using the initial message sent by the side that scanned the QR code.
+func (sc *SecureChannel) Accept(initialMessage []byte) error {
+	data, err := base64.RawStdEncoding.DecodeString(string(initialMessage))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidChannelMessage, err)
+	} else if len(data) <= channelPublicKeyLength {
+		return ErrInvalidChannelMessage
+	}
+	err = sc.establish(data[:channelPublicKeyLength], false)
+	if err != nil {
+		return err
+	}
+	plaintext, err := sc.open(data[channelPublicKeyLength:])
+	if err != nil {
+		return err
+	} else if string(plaintext) != channelInitiateMessage {
+		return ErrUnexpectedHandshake
+	}
+	return nil
+}
+
+// Encrypt encrypts a message to send through the channel.
+func (sc *SecureChannel) Encrypt(plaintext []byte) ([]byte, error) {
+	if !sc.IsEstablished() {
+		return nil, ErrChannelNotEstablished
+	}
+	return []byte(base64.RawStdEncoding.EncodeToString(sc.seal(plaintext))), nil
+}
+
+// Decrypt decrypts a message received through the channel.
+func (sc *SecureChannel) Decrypt(message []byte) ([]byte, error) {
+	if !sc.IsEstablished() {
+		return nil, ErrChannelNotEstablished
+	}
+	data, err := base64.RawStdEncoding.DecodeString(string(message))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChannelMessage, err)
+	}
+	return sc.open(data)
+}
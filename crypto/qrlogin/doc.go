@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package qrlogin implements logging in a new device by scanning a QR code with an existing device
+// according to [MSC4108].
+//
+// The new device displays a QR code containing a rendezvous session URL and an ephemeral public key.
+// The existing device scans it and establishes a secure channel through the rendezvous session, after which
+// the new device is logged in using the OAuth 2.0 device authorization grant and receives the cross-signing
+// and key backup secrets from the existing device.
+//
+// [MSC4108]: https://github.com/matrix-org/matrix-spec-proposals/pull/4108
+package qrlogin
@@ -0,0 +1,196 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrUnsupportedQRCodeIntent = errors.New("QR code was not generated by a device that wants to log in")
+	ErrDeviceAlreadyExists     = errors.New("requested device ID already exists")
+	ErrNewDeviceKeysNotFound   = errors.New("didn't find the identity keys of the new device")
+)
+
+// NewDeviceKeysPollCount and NewDeviceKeysPollInterval control how long [GrantLogin] waits for the new device
+// to upload its identity keys after it reports a successful login.
+var (
+	NewDeviceKeysPollCount    = 10
+	NewDeviceKeysPollInterval = 2 * time.Second
+)
+
+// ExistingDeviceParams contains the callbacks and secrets for [GrantLogin].
+type ExistingDeviceParams struct {
+	// GetCheckCode is called after the secure channel is established. It must ask the user
+	// for the two-digit code displayed on the new device and return it.
+	GetCheckCode func(ctx context.Context) (string, error)
+	// OpenVerificationURI is called with the URL where the user must approve the login of the new device.
+	OpenVerificationURI func(uri string)
+
+	// Mach is used to sign the new device and to get the cross-signing private keys sent to the new device.
+	// If it's nil or doesn't have the private keys, the new device won't be cross-signed.
+	Mach *crypto.OlmMachine
+	// BackupKey and BackupVersion are sent to the new device if set.
+	BackupKey     *backup.MegolmBackupKey
+	BackupVersion id.KeyBackupVersion
+}
+
+// GrantLogin logs in a new device by scanning its QR code on an existing device.
+// The given client must be logged in, and qrData is the raw binary data of the scanned QR code.
+func GrantLogin(ctx context.Context, cli *mautrix.Client, qrData []byte, params *ExistingDeviceParams) (id.DeviceID, error) {
+	qr, err := ParseQRCode(qrData)
+	if err != nil {
+		return "", err
+	} else if qr.Intent != QRCodeIntentLogin {
+		return "", ErrUnsupportedQRCodeIntent
+	}
+	log := zerolog.Ctx(ctx).With().Str("action", "grant qr login").Logger()
+	ctx = log.WithContext(ctx)
+	channel, err := NewSecureChannel()
+	if err != nil {
+		return "", err
+	}
+	rs := cli.JoinRendezvousSession(qr.RendezvousURL)
+	// Read the current payload first to get the etag for sending the initial message
+	_, err = rs.Receive(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get rendezvous session: %w", err)
+	}
+	initialMessage, err := channel.Initiate(qr.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to establish secure channel: %w", err)
+	}
+	err = rs.Send(ctx, initialMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to send initial secure channel message: %w", err)
+	}
+	ack, err := rs.Receive(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to receive secure channel acknowledgement: %w", err)
+	}
+	decryptedAck, err := channel.Decrypt(ack)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secure channel acknowledgement: %w", err)
+	} else if string(decryptedAck) != channelAcknowledgement {
+		return "", ErrUnexpectedHandshake
+	}
+	sess := &session{rs: rs, channel: channel}
+
+	checkCode, err := params.GetCheckCode(ctx)
+	if err != nil {
+		sess.sendFailure(ctx, FailureReasonUserCancelled)
+		return "", err
+	} else if checkCode != channel.CheckCode() {
+		// The channel may have been intercepted, so don't send anything else through it
+		return "", ErrCheckCodeMismatch
+	}
+
+	err = sess.send(ctx, &Message{
+		Type:       MessageTypeProtocols,
+		Protocols:  []string{ProtocolDeviceAuthorizationGrant},
+		Homeserver: cli.HomeserverURL.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	protocol, err := sess.receive(ctx, MessageTypeProtocol)
+	if err != nil {
+		return "", err
+	} else if protocol.Protocol != ProtocolDeviceAuthorizationGrant || protocol.DeviceAuthorizationGrant == nil {
+		sess.sendFailure(ctx, FailureReasonUnsupportedProtocol)
+		return "", fmt.Errorf("new device requested unsupported protocol %q", protocol.Protocol)
+	}
+	deviceID := protocol.DeviceID
+	_, err = cli.GetDeviceInfo(ctx, deviceID)
+	if err == nil {
+		sess.sendFailure(ctx, FailureReasonDeviceAlreadyExists)
+		return "", ErrDeviceAlreadyExists
+	} else if !errors.Is(err, mautrix.MNotFound) {
+		return "", fmt.Errorf("failed to check if device already exists: %w", err)
+	}
+	err = sess.send(ctx, &Message{Type: MessageTypeProtocolAccepted})
+	if err != nil {
+		return "", err
+	}
+	verificationURI := protocol.DeviceAuthorizationGrant.VerificationURIComplete
+	if verificationURI == "" {
+		verificationURI = protocol.DeviceAuthorizationGrant.VerificationURI
+	}
+	params.OpenVerificationURI(verificationURI)
+
+	_, err = sess.receive(ctx, MessageTypeSuccess)
+	if err != nil {
+		return "", err
+	}
+	log.Info().Stringer("device_id", deviceID).Msg("New device reported successful login")
+	secrets := &Message{Type: MessageTypeSecrets}
+	if params.Mach == nil || params.Mach.CrossSigningKeys == nil {
+		log.Debug().Msg("No cross-signing keys available, not cross-signing new device")
+	} else {
+		device, err := waitForDeviceKeys(ctx, params.Mach, cli.UserID, deviceID)
+		if err != nil {
+			sess.sendFailure(ctx, FailureReasonDeviceNotFound)
+			return deviceID, err
+		}
+		err = params.Mach.SignOwnDevice(ctx, device)
+		if err != nil {
+			return deviceID, fmt.Errorf("failed to sign new device: %w", err)
+		}
+		keys := params.Mach.CrossSigningKeys
+		secrets.CrossSigning = &CrossSigningSecrets{
+			MasterKey:      base64.RawStdEncoding.EncodeToString(keys.MasterKey.Seed()),
+			SelfSigningKey: base64.RawStdEncoding.EncodeToString(keys.SelfSigningKey.Seed()),
+			UserSigningKey: base64.RawStdEncoding.EncodeToString(keys.UserSigningKey.Seed()),
+		}
+	}
+	if params.BackupKey != nil && params.BackupVersion != "" {
+		secrets.Backup = &BackupSecrets{
+			Algorithm:     id.KeyBackupAlgorithmMegolmBackupV1,
+			Key:           base64.RawStdEncoding.EncodeToString(params.BackupKey.Bytes()),
+			BackupVersion: params.BackupVersion,
+		}
+	}
+	err = sess.send(ctx, secrets)
+	if err != nil {
+		return deviceID, err
+	}
+	return deviceID, nil
+}
+
+func waitForDeviceKeys(ctx context.Context, mach *crypto.OlmMachine, userID id.UserID, deviceID id.DeviceID) (*id.Device, error) {
+	for i := 0; i < NewDeviceKeysPollCount; i++ {
+		devices, err := mach.FetchKeys(ctx, []id.UserID{userID}, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch own devices: %w", err)
+		} else if device, ok := devices[userID][deviceID]; ok {
+			return device, nil
+		}
+		select {
+		case <-time.After(NewDeviceKeysPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, ErrNewDeviceKeysNotFound
+}
+
+// DecodeSecret decodes a base64 secret sent in a m.login.secrets message. Both padded and unpadded base64 are accepted.
+func DecodeSecret(secret string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+type MessageType string
+
+const (
+	MessageTypeProtocols        MessageType = "m.login.protocols"
+	MessageTypeProtocol         MessageType = "m.login.protocol"
+	MessageTypeProtocolAccepted MessageType = "m.login.protocol_accepted"
+	MessageTypeSuccess          MessageType = "m.login.success"
+	MessageTypeDeclined         MessageType = "m.login.declined"
+	MessageTypeFailure          MessageType = "m.login.failure"
+	MessageTypeSecrets          MessageType = "m.login.secrets"
+)
+
+// ProtocolDeviceAuthorizationGrant is the OAuth 2.0 device authorization grant (RFC 8628),
+// which is currently the only login protocol defined in MSC4108.
+const ProtocolDeviceAuthorizationGrant = "device_authorization_grant"
+
+type FailureReason string
+
+const (
+	FailureReasonAuthorizationExpired   FailureReason = "authorization_expired"
+	FailureReasonDeviceAlreadyExists    FailureReason = "device_already_exists"
+	FailureReasonDeviceNotFound         FailureReason = "device_not_found"
+	FailureReasonUnexpectedMessage      FailureReason = "unexpected_message_received"
+	FailureReasonUnsupportedProtocol    FailureReason = "unsupported_protocol"
+	FailureReasonUserCancelled          FailureReason = "user_cancelled"
+	FailureReasonHomeserverNotSupported FailureReason = "homeserver_lacks_support"
+)
+
+type DeviceAuthorizationGrant struct {
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+}
+
+type CrossSigningSecrets struct {
+	MasterKey      string `json:"master_key,omitempty"`
+	SelfSigningKey string `json:"self_signing_key,omitempty"`
+	UserSigningKey string `json:"user_signing_key,omitempty"`
+}
+
+type BackupSecrets struct {
+	Algorithm     id.KeyBackupAlgorithm `json:"algorithm"`
+	Key           string                `json:"key"`
+	BackupVersion id.KeyBackupVersion   `json:"backup_version"`
+}
+
+// Message is a message sent through the secure channel. Which fields are set depends on the type.
+type Message struct {
+	Type MessageType `json:"type"`
+
+	// Fields for m.login.protocols
+	Protocols  []string `json:"protocols,omitempty"`
+	Homeserver string   `json:"homeserver,omitempty"`
+
+	// Fields for m.login.protocol
+	Protocol                 string                    `json:"protocol,omitempty"`
+	DeviceAuthorizationGrant *DeviceAuthorizationGrant `json:"device_authorization_grant,omitempty"`
+	DeviceID                 id.DeviceID               `json:"device_id,omitempty"`
+
+	// Fields for m.login.failure
+	Reason FailureReason `json:"reason,omitempty"`
+
+	// Fields for m.login.secrets
+	CrossSigning *CrossSigningSecrets `json:"cross_signing,omitempty"`
+	Backup       *BackupSecrets       `json:"backup,omitempty"`
+}
+
+// RemoteFailureError is returned when the other side sends a m.login.failure or m.login.declined message.
+type RemoteFailureError struct {
+	Type   MessageType
+	Reason FailureReason
+}
+
+func (e RemoteFailureError) Error() string {
+	if e.Type == MessageTypeDeclined {
+		return "login was declined on the other device"
+	}
+	return fmt.Sprintf("other device reported failure: %s", e.Reason)
+}
+
+// UnexpectedMessageError is returned when the other side sends a message that doesn't fit in the current step of the flow.
+type UnexpectedMessageError struct {
+	Expected MessageType
+	Received MessageType
+}
+
+func (e UnexpectedMessageError) Error() string {
+	return fmt.Sprintf("expected %s message, got %s", e.Expected, e.Received)
+}
+
+type session struct {
+	rs      *mautrix.RendezvousSession
+	channel *SecureChannel
+}
+
+func (s *session) send(ctx context.Context, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", msg.Type, err)
+	}
+	encrypted, err := s.channel.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s message: %w", msg.Type, err)
+	}
+	err = s.rs.Send(ctx, encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to send %s message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+func (s *session) sendFailure(ctx context.Context, reason FailureReason) {
+	_ = s.send(ctx, &Message{Type: MessageTypeFailure, Reason: reason})
+}
+
+func (s *session) receive(ctx context.Context, expected MessageType) (*Message, error) {
+	data, err := s.rs.Receive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive %s message: %w", expected, err)
+	}
+	decrypted, err := s.channel.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s message: %w", expected, err)
+	}
+	var msg Message
+	err = json.Unmarshal(decrypted, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s message: %w", expected, err)
+	}
+	switch msg.Type {
+	case expected:
+		return &msg, nil
+	case MessageTypeFailure, MessageTypeDeclined:
+		return nil, RemoteFailureError{Type: msg.Type, Reason: msg.Reason}
+	default:
+		s.sendFailure(ctx, FailureReasonUnexpectedMessage)
+		return nil, UnexpectedMessageError{Expected: expected, Received: msg.Type}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrNoMutualProtocol     = errors.New("other device doesn't support any mutual login protocols")
+	ErrInvalidHomeserverURL = errors.New("other device sent an invalid homeserver URL")
+)
+
+// NewDeviceParams contains the callbacks and client metadata for [LoginWithQR].
+type NewDeviceParams struct {
+	// ClientMetadata is used to register the new device as an OAuth client. ClientURI is required.
+	ClientMetadata ClientMetadata
+	// DeviceID is the device ID to request. If empty, a random one is generated.
+	DeviceID id.DeviceID
+
+	// DisplayQRCode is called with the QR code that must be scanned by the existing device.
+	DisplayQRCode func(qr *QRCode)
+	// DisplayCheckCode is called with the two-digit code that the user must enter on the existing device.
+	DisplayCheckCode func(code string)
+	// DisplayVerificationURI is called with the URL that the existing device was told to open for approving the login.
+	// This is optional and is only useful if the existing device fails to open the URL by itself.
+	DisplayVerificationURI func(uri string)
+	// OnLoggedIn is called after the access token has been received, but before reporting success to the existing device.
+	// It should set up encryption and upload the device keys, so that the existing device can cross-sign the new device.
+	OnLoggedIn func(ctx context.Context, result *LoginResult) error
+}
+
+// LoginResult is returned by [LoginWithQR] after the new device has been logged in.
+type LoginResult struct {
+	UserID       id.UserID
+	DeviceID     id.DeviceID
+	AccessToken  string
+	RefreshToken string
+	// TokenEndpoint and ClientID are needed for refreshing the access token with [RefreshAccessToken].
+	TokenEndpoint string
+	ClientID      string
+
+	// Secrets contains the cross-signing and key backup secrets sent by the existing device.
+	// It may be nil if the existing device didn't send any secrets.
+	Secrets *Message
+}
+
+// LoginWithQR logs in a new device by displaying a QR code that is scanned by an existing device.
+// The homeserver URL of the client must be set and the homeserver must support MSC4108 rendezvous sessions.
+//
+// The returned result contains the new credentials, which are also set on the given client.
+// The caller is responsible for setting up encryption with the device ID and importing the returned secrets.
+func LoginWithQR(ctx context.Context, cli *mautrix.Client, params *NewDeviceParams) (*LoginResult, error) {
+	log := zerolog.Ctx(ctx).With().Str("action", "qr login").Logger()
+	ctx = log.WithContext(ctx)
+	channel, err := NewSecureChannel()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := cli.CreateRendezvousSession(ctx, []byte{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rendezvous session: %w", err)
+	}
+	defer func() {
+		err := rs.Delete(context.WithoutCancel(ctx))
+		if err != nil && !errors.Is(err, mautrix.ErrRendezvousSessionExpired) {
+			log.Warn().Err(err).Msg("Failed to delete rendezvous session")
+		}
+	}()
+	params.DisplayQRCode(&QRCode{
+		Intent:        QRCodeIntentLogin,
+		PublicKey:     channel.PublicKey(),
+		RendezvousURL: rs.URL,
+	})
+
+	initialMessage, err := rs.Receive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for QR code to be scanned: %w", err)
+	}
+	err = channel.Accept(initialMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish secure channel: %w", err)
+	}
+	params.DisplayCheckCode(channel.CheckCode())
+	ack, err := channel.Encrypt([]byte(channelAcknowledgement))
+	if err != nil {
+		return nil, err
+	}
+	err = rs.Send(ctx, ack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send secure channel acknowledgement: %w", err)
+	}
+	sess := &session{rs: rs, channel: channel}
+
+	protocols, err := sess.receive(ctx, MessageTypeProtocols)
+	if err != nil {
+		return nil, err
+	} else if !slices.Contains(protocols.Protocols, ProtocolDeviceAuthorizationGrant) {
+		sess.sendFailure(ctx, FailureReasonUnsupportedProtocol)
+		return nil, ErrNoMutualProtocol
+	}
+	homeserverURL, err := validateHomeserverURL(protocols.Homeserver, cli.HomeserverURL.Scheme)
+	if err != nil {
+		sess.sendFailure(ctx, FailureReasonHomeserverNotSupported)
+		return nil, err
+	}
+	if homeserverURL.String() != cli.HomeserverURL.String() {
+		log.Debug().
+			Stringer("rendezvous_homeserver_url", cli.HomeserverURL).
+			Stringer("homeserver_url", homeserverURL).
+			Msg("Using homeserver URL sent by existing device")
+	}
+	cli.HomeserverURL = homeserverURL
+
+	oauth, err := newOAuthClient(ctx, cli, &params.ClientMetadata)
+	if err != nil {
+		sess.sendFailure(ctx, FailureReasonHomeserverNotSupported)
+		return nil, err
+	}
+	deviceID := params.DeviceID
+	if deviceID == "" {
+		deviceID = id.DeviceID(strings.ToUpper(random.String(10)))
+	}
+	auth, err := oauth.startDeviceAuthorization(ctx, deviceID)
+	if err != nil {
+		sess.sendFailure(ctx, FailureReasonHomeserverNotSupported)
+		return nil, err
+	}
+	err = sess.send(ctx, &Message{
+		Type:     MessageTypeProtocol,
+		Protocol: ProtocolDeviceAuthorizationGrant,
+		DeviceAuthorizationGrant: &DeviceAuthorizationGrant{
+			VerificationURI:         auth.VerificationURI,
+			VerificationURIComplete: auth.VerificationURIComplete,
+		},
+		DeviceID: deviceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = sess.receive(ctx, MessageTypeProtocolAccepted)
+	if err != nil {
+		return nil, err
+	}
+	if params.DisplayVerificationURI != nil {
+		if auth.VerificationURIComplete != "" {
+			params.DisplayVerificationURI(auth.VerificationURIComplete)
+		} else {
+			params.DisplayVerificationURI(auth.VerificationURI)
+		}
+	}
+
+	token, err := oauth.pollToken(ctx, auth)
+	if errors.Is(err, ErrDeviceGrantExpired) {
+		sess.sendFailure(ctx, FailureReasonAuthorizationExpired)
+		return nil, err
+	} else if err != nil {
+		sess.sendFailure(ctx, FailureReasonUserCancelled)
+		return nil, err
+	}
+	cli.AccessToken = token.AccessToken
+	cli.DeviceID = deviceID
+	whoami, err := cli.Whoami(ctx)
+	if err != nil {
+		sess.sendFailure(ctx, FailureReasonUserCancelled)
+		return nil, fmt.Errorf("failed to get user ID with new access token: %w", err)
+	}
+	cli.UserID = whoami.UserID
+	log.Info().Stringer("user_id", whoami.UserID).Stringer("device_id", deviceID).Msg("Logged in with QR code")
+	result := &LoginResult{
+		UserID:       whoami.UserID,
+		DeviceID:     deviceID,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+
+		TokenEndpoint: oauth.metadata.TokenEndpoint,
+		ClientID:      oauth.clientID,
+	}
+	if params.OnLoggedIn != nil {
+		err = params.OnLoggedIn(ctx, result)
+		if err != nil {
+			sess.sendFailure(ctx, FailureReasonUserCancelled)
+			return nil, err
+		}
+	}
+
+	err = sess.send(ctx, &Message{Type: MessageTypeSuccess, DeviceID: deviceID})
+	if err != nil {
+		return nil, err
+	}
+	result.Secrets, err = sess.receive(ctx, MessageTypeSecrets)
+	if err != nil {
+		// The login itself already succeeded at this point, so just log the error and return the credentials.
+		log.Warn().Err(err).Msg("Failed to receive secrets from existing device")
+	}
+	return result, nil
+}
+
+// validateHomeserverURL parses the homeserver URL sent by the existing device. Plain HTTP is only allowed
+// if the rendezvous server was also contacted over plain HTTP.
+func validateHomeserverURL(homeserver, rendezvousScheme string) (*url.URL, error) {
+	if homeserver == "" {
+		return nil, fmt.Errorf("%w: URL is empty", ErrInvalidHomeserverURL)
+	}
+	parsed, err := mautrix.ParseAndNormalizeBaseURL(homeserver)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHomeserverURL, err)
+	} else if parsed.Host == "" || parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, fmt.Errorf("%w: %q is not a valid base URL", ErrInvalidHomeserverURL, homeserver)
+	} else if parsed.Scheme != "https" && (parsed.Scheme != "http" || rendezvousScheme != "http") {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidHomeserverURL, parsed.Scheme)
+	}
+	return parsed, nil
+}
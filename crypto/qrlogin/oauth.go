@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+var (
+	ErrDeviceGrantNotSupported = errors.New("authorization server doesn't support the device authorization grant")
+	ErrDeviceGrantExpired      = errors.New("device authorization grant expired before the login was approved")
+	ErrDeviceGrantDenied       = errors.New("login was denied by the authorization server")
+	ErrRefreshTokenInvalid     = errors.New("refresh token is no longer valid")
+)
+
+// ClientMetadata is the metadata used to dynamically register the new device as an OAuth client (MSC2966).
+type ClientMetadata struct {
+	ClientName string `json:"client_name,omitempty"`
+	ClientURI  string `json:"client_uri"`
+	PolicyURI  string `json:"policy_uri,omitempty"`
+	TOSURI     string `json:"tos_uri,omitempty"`
+}
+
+type authMetadata struct {
+	Issuer                      string   `json:"issuer"`
+	RegistrationEndpoint        string   `json:"registration_endpoint"`
+	TokenEndpoint               string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint"`
+	GrantTypesSupported         []string `json:"grant_types_supported"`
+}
+
+type respAuthIssuer struct {
+	Issuer string `json:"issuer"`
+}
+
+type reqRegisterClient struct {
+	ClientMetadata
+	ApplicationType         string   `json:"application_type"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
+
+type respRegisterClient struct {
+	ClientID string `json:"client_id"`
+}
+
+type respDeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type respToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type respOAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type oauthClient struct {
+	cli      *mautrix.Client
+	metadata *authMetadata
+	clientID string
+}
+
+func discoverAuthMetadata(ctx context.Context, cli *mautrix.Client) (*authMetadata, error) {
+	var metadata authMetadata
+	_, err := cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method:       http.MethodGet,
+		URL:          cli.BuildClientURL("unstable", "org.matrix.msc2965", "auth_metadata"),
+		ResponseJSON: &metadata,
+	})
+	if err == nil {
+		return &metadata, nil
+	}
+	var issuer respAuthIssuer
+	_, issuerErr := cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method:       http.MethodGet,
+		URL:          cli.BuildClientURL("unstable", "org.matrix.msc2965", "auth_issuer"),
+		ResponseJSON: &issuer,
+	})
+	if issuerErr != nil {
+		return nil, fmt.Errorf("failed to get auth metadata (%w) or issuer (%w)", err, issuerErr)
+	}
+	_, err = cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method:       http.MethodGet,
+		URL:          strings.TrimRight(issuer.Issuer, "/") + "/.well-known/openid-configuration",
+		ResponseJSON: &metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OpenID configuration of issuer: %w", err)
+	}
+	return &metadata, nil
+}
+
+func newOAuthClient(ctx context.Context, cli *mautrix.Client, clientMetadata *ClientMetadata) (*oauthClient, error) {
+	metadata, err := discoverAuthMetadata(ctx, cli)
+	if err != nil {
+		return nil, err
+	} else if metadata.DeviceAuthorizationEndpoint == "" || metadata.RegistrationEndpoint == "" {
+		return nil, ErrDeviceGrantNotSupported
+	}
+	var resp respRegisterClient
+	_, err = cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method: http.MethodPost,
+		URL:    metadata.RegistrationEndpoint,
+		RequestJSON: &reqRegisterClient{
+			ClientMetadata:          *clientMetadata,
+			ApplicationType:         "native",
+			GrantTypes:              []string{grantTypeDeviceCode, "refresh_token"},
+			ResponseTypes:           []string{},
+			TokenEndpointAuthMethod: "none",
+		},
+		ResponseJSON: &resp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register OAuth client: %w", err)
+	}
+	return &oauthClient{cli: cli, metadata: metadata, clientID: resp.ClientID}, nil
+}
+
+func (oc *oauthClient) postForm(ctx context.Context, endpoint string, form url.Values, respJSON any) (*respOAuthError, error) {
+	form.Set("client_id", oc.clientID)
+	data, err := oc.cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method:           http.MethodPost,
+		URL:              endpoint,
+		Headers:          http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		RequestBytes:     []byte(form.Encode()),
+		ResponseJSON:     respJSON,
+		SensitiveContent: true,
+	})
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusBadRequest) {
+		var oauthErr respOAuthError
+		if json.Unmarshal(data, &oauthErr) == nil && oauthErr.Error != "" {
+			return &oauthErr, nil
+		}
+	}
+	return nil, err
+}
+
+func (oc *oauthClient) startDeviceAuthorization(ctx context.Context, deviceID id.DeviceID) (*respDeviceAuthorization, error) {
+	var resp respDeviceAuthorization
+	oauthErr, err := oc.postForm(ctx, oc.metadata.DeviceAuthorizationEndpoint, url.Values{
+		"scope": {fmt.Sprintf("openid urn:matrix:org.matrix.msc2967.client:api:* urn:matrix:org.matrix.msc2967.client:device:%s", deviceID)},
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	} else if oauthErr != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %s (%s)", oauthErr.Error, oauthErr.ErrorDescription)
+	}
+	return &resp, nil
+}
+
+func (oc *oauthClient) pollToken(ctx context.Context, auth *respDeviceAuthorization) (*respToken, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for {
+		var resp respToken
+		oauthErr, err := oc.postForm(ctx, oc.metadata.TokenEndpoint, url.Values{
+			"grant_type":  {grantTypeDeviceCode},
+			"device_code": {auth.DeviceCode},
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll for access token: %w", err)
+		} else if oauthErr == nil {
+			return &resp, nil
+		}
+		switch oauthErr.Error {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			return nil, ErrDeviceGrantExpired
+		case "access_denied":
+			return nil, ErrDeviceGrantDenied
+		default:
+			return nil, fmt.Errorf("failed to poll for access token: %s (%s)", oauthErr.Error, oauthErr.ErrorDescription)
+		}
+		if auth.ExpiresIn > 0 && time.Now().Add(interval).After(deadline) {
+			return nil, ErrDeviceGrantExpired
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// RefreshAccessToken uses a refresh token received from [LoginWithQR] to get a new access token.
+// The returned refresh token replaces the old one. It may be empty if the server didn't rotate it.
+//
+// The client is only used for making the HTTP request, its credentials are not changed.
+func RefreshAccessToken(ctx context.Context, cli *mautrix.Client, tokenEndpoint, clientID, refreshToken string) (newAccessToken, newRefreshToken string, err error) {
+	oc := &oauthClient{cli: cli, metadata: &authMetadata{TokenEndpoint: tokenEndpoint}, clientID: clientID}
+	var resp respToken
+	oauthErr, err := oc.postForm(ctx, tokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}, &resp)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to refresh access token: %w", err)
+	} else if oauthErr != nil && oauthErr.Error == "invalid_grant" {
+		return "", "", ErrRefreshTokenInvalid
+	} else if oauthErr != nil {
+		return "", "", fmt.Errorf("failed to refresh access token: %s (%s)", oauthErr.Error, oauthErr.ErrorDescription)
+	} else if resp.AccessToken == "" {
+		return "", "", fmt.Errorf("failed to refresh access token: response didn't contain an access token")
+	}
+	return resp.AccessToken, resp.RefreshToken, nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidQRCodeHeader  = errors.New("invalid QR code header")
+	ErrUnknownQRCodeVersion = errors.New("unknown QR code version")
+	ErrInvalidQRCodeIntent  = errors.New("invalid QR code intent")
+	ErrQRCodeTooShort       = errors.New("QR code data is too short")
+)
+
+type QRCodeIntent byte
+
+const (
+	// QRCodeIntentLogin means the QR code was generated by a new device that wants to log in.
+	QRCodeIntentLogin QRCodeIntent = 0x00
+	// QRCodeIntentReciprocate means the QR code was generated by an existing device that wants to log in a new device.
+	QRCodeIntentReciprocate QRCodeIntent = 0x01
+)
+
+const (
+	qrCodePrefix  = "MATRIX"
+	qrCodeVersion = 0x02
+)
+
+// QRCode is the data encoded in a login QR code.
+type QRCode struct {
+	Intent        QRCodeIntent
+	PublicKey     [32]byte
+	RendezvousURL string
+	// ServerName is the server name of the user. It's only included in QR codes with [QRCodeIntentReciprocate].
+	ServerName string
+}
+
+// Bytes returns the binary data that should be encoded in the QR code.
+func (qr *QRCode) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(qrCodePrefix)
+	buf.WriteByte(qrCodeVersion)
+	buf.WriteByte(byte(qr.Intent))
+	buf.Write(qr.PublicKey[:])
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(qr.RendezvousURL)))
+	buf.WriteString(qr.RendezvousURL)
+	if qr.Intent == QRCodeIntentReciprocate {
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(qr.ServerName)))
+		buf.WriteString(qr.ServerName)
+	}
+	return buf.Bytes()
+}
+
+func readLengthPrefixed(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, ErrQRCodeTooShort
+	}
+	length := int(binary.BigEndian.Uint16(data[:2]))
+	if len(data) < 2+length {
+		return "", nil, ErrQRCodeTooShort
+	}
+	return string(data[2 : 2+length]), data[2+length:], nil
+}
+
+// ParseQRCode parses the binary data of a scanned login QR code.
+func ParseQRCode(data []byte) (*QRCode, error) {
+	if !bytes.HasPrefix(data, []byte(qrCodePrefix)) {
+		return nil, ErrInvalidQRCodeHeader
+	}
+	data = data[len(qrCodePrefix):]
+	if len(data) < 2+32 {
+		return nil, ErrQRCodeTooShort
+	} else if data[0] != qrCodeVersion {
+		return nil, ErrUnknownQRCodeVersion
+	}
+	qr := &QRCode{Intent: QRCodeIntent(data[1])}
+	if qr.Intent != QRCodeIntentLogin && qr.Intent != QRCodeIntentReciprocate {
+		return nil, ErrInvalidQRCodeIntent
+	}
+	copy(qr.PublicKey[:], data[2:34])
+	var err error
+	qr.RendezvousURL, data, err = readLengthPrefixed(data[34:])
+	if err != nil {
+		return nil, err
+	}
+	if qr.Intent == QRCodeIntentReciprocate {
+		qr.ServerName, _, err = readLengthPrefixed(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return qr, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package qrlogin_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/qrlogin"
+)
+
+func TestQRCode_Roundtrip(t *testing.T) {
+	var key [32]byte
+	copy(key[:], bytes.Repeat([]byte{0x01}, 32))
+	for _, qr := range []*qrlogin.QRCode{{
+		Intent:        qrlogin.QRCodeIntentLogin,
+		PublicKey:     key,
+		RendezvousURL: "https://example.com/_matrix/client/unstable/org.matrix.msc4108/rendezvous/abc",
+	}, {
+		Intent:        qrlogin.QRCodeIntentReciprocate,
+		PublicKey:     key,
+		RendezvousURL: "https://example.com/_matrix/client/unstable/org.matrix.msc4108/rendezvous/def",
+		ServerName:    "example.com",
+	}} {
+		decoded, err := qrlogin.ParseQRCode(qr.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, qr, decoded)
+	}
+}
+
+func TestParseQRCode_Invalid(t *testing.T) {
+	_, err := qrlogin.ParseQRCode([]byte("NOTMATRIX"))
+	assert.ErrorIs(t, err, qrlogin.ErrInvalidQRCodeHeader)
+	qr := (&qrlogin.QRCode{RendezvousURL: "https://example.com"}).Bytes()
+	_, err = qrlogin.ParseQRCode(qr[:len(qr)-1])
+	assert.ErrorIs(t, err, qrlogin.ErrQRCodeTooShort)
+}
+
+func TestSecureChannel(t *testing.T) {
+	newDevice, err := qrlogin.NewSecureChannel()
+	require.NoError(t, err)
+	existingDevice, err := qrlogin.NewSecureChannel()
+	require.NoError(t, err)
+
+	initialMessage, err := existingDevice.Initiate(newDevice.PublicKey())
+	require.NoError(t, err)
+	require.NoError(t, newDevice.Accept(initialMessage))
+	assert.Equal(t, existingDevice.CheckCode(), newDevice.CheckCode())
+	assert.Len(t, newDevice.CheckCode(), 2)
+
+	for _, msg := range []string{"hello", "world"} {
+		encrypted, err := newDevice.Encrypt([]byte(msg))
+		require.NoError(t, err)
+		decrypted, err := existingDevice.Decrypt(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(decrypted))
+
+		encrypted, err = existingDevice.Encrypt([]byte(msg))
+		require.NoError(t, err)
+		decrypted, err = newDevice.Decrypt(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(decrypted))
+	}
+
+	// Messages encrypted in one direction must not be decryptable with the key of the other direction
+	encrypted, err := newDevice.Encrypt([]byte("test"))
+	require.NoError(t, err)
+	_, err = newDevice.Decrypt(encrypted)
+	assert.ErrorIs(t, err, qrlogin.ErrInvalidChannelMessage)
+}
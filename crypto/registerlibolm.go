@@ -2,4 +2,9 @@
 
 package crypto
 
-import _ "maunium.net/go/mautrix/crypto/libolm"
+import (
+	// goolm is always included so that it can be selected at runtime with olm.SetBackend.
+	// libolm has a higher priority, so it's used by default.
+	_ "maunium.net/go/mautrix/crypto/goolm"
+	_ "maunium.net/go/mautrix/crypto/libolm"
+)
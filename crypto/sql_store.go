@@ -933,3 +933,33 @@ func (store *SQLCryptoStore) DeleteSecret(ctx context.Context, name id.Secret) (
 	_, err = store.DB.Exec(ctx, "DELETE FROM crypto_secrets WHERE account_id=$1 AND name=$2", store.AccountID, name)
 	return
 }
+
+// DeleteAccount deletes all crypto material belonging to the current account from the database
+// and clears the pickle key from memory. The store must not be used after calling this.
+func (store *SQLCryptoStore) DeleteAccount(ctx context.Context) error {
+	err := store.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, table := range []string{
+			"crypto_secrets",
+			"crypto_megolm_outbound_session",
+			"crypto_megolm_inbound_session",
+			"crypto_olm_session",
+			"crypto_account",
+		} {
+			_, err := store.DB.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE account_id=$1", table), store.AccountID)
+			if err != nil {
+				return fmt.Errorf("failed to clear %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	store.olmSessionCacheLock.Lock()
+	clear(store.olmSessionCache)
+	store.olmSessionCacheLock.Unlock()
+	clear(store.PickleKey)
+	store.PickleKey = nil
+	store.Account = nil
+	return nil
+}
@@ -8,6 +8,8 @@ package verificationhelper_test
 
 import (
 	"context"
+	"slices"
+	"sync"
 
 	"maunium.net/go/mautrix/crypto/verificationhelper"
 	"maunium.net/go/mautrix/event"
@@ -21,12 +23,16 @@ type MockVerificationCallbacks interface {
 }
 
 type baseVerificationCallbacks struct {
+	// lock protects all the fields below, as the callbacks can be called from
+	// other goroutines, like the verification expiry timers.
+	lock                     sync.Mutex
 	scanQRCodeTransactions   []id.VerificationTransactionID
 	verificationsRequested   map[id.UserID][]id.VerificationTransactionID
 	qrCodesShown             map[id.VerificationTransactionID]*verificationhelper.QRCode
 	qrCodesScanned           map[id.VerificationTransactionID]struct{}
 	doneTransactions         map[id.VerificationTransactionID]struct{}
 	verificationCancellation map[id.VerificationTransactionID]*event.VerificationCancelEventContent
+	timedOutTransactions     map[id.VerificationTransactionID]struct{}
 	emojisShown              map[id.VerificationTransactionID][]rune
 	decimalsShown            map[id.VerificationTransactionID][]int
 }
@@ -38,50 +44,82 @@ func newBaseVerificationCallbacks() *baseVerificationCallbacks {
 		qrCodesScanned:           map[id.VerificationTransactionID]struct{}{},
 		doneTransactions:         map[id.VerificationTransactionID]struct{}{},
 		verificationCancellation: map[id.VerificationTransactionID]*event.VerificationCancelEventContent{},
+		timedOutTransactions:     map[id.VerificationTransactionID]struct{}{},
 		emojisShown:              map[id.VerificationTransactionID][]rune{},
 		decimalsShown:            map[id.VerificationTransactionID][]int{},
 	}
 }
 
 func (c *baseVerificationCallbacks) GetRequestedVerifications() map[id.UserID][]id.VerificationTransactionID {
-	return c.verificationsRequested
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	requested := make(map[id.UserID][]id.VerificationTransactionID, len(c.verificationsRequested))
+	for userID, txnIDs := range c.verificationsRequested {
+		requested[userID] = slices.Clone(txnIDs)
+	}
+	return requested
 }
 
 func (c *baseVerificationCallbacks) GetScanQRCodeTransactions() []id.VerificationTransactionID {
-	return c.scanQRCodeTransactions
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return slices.Clone(c.scanQRCodeTransactions)
 }
 
 func (c *baseVerificationCallbacks) GetQRCodeShown(txnID id.VerificationTransactionID) *verificationhelper.QRCode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.qrCodesShown[txnID]
 }
 
 func (c *baseVerificationCallbacks) WasOurQRCodeScanned(txnID id.VerificationTransactionID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	_, ok := c.qrCodesScanned[txnID]
 	return ok
 }
 
 func (c *baseVerificationCallbacks) IsVerificationDone(txnID id.VerificationTransactionID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	_, ok := c.doneTransactions[txnID]
 	return ok
 }
 
 func (c *baseVerificationCallbacks) GetVerificationCancellation(txnID id.VerificationTransactionID) *event.VerificationCancelEventContent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.verificationCancellation[txnID]
 }
 
+func (c *baseVerificationCallbacks) DidVerificationTimeOut(txnID id.VerificationTransactionID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.timedOutTransactions[txnID]
+	return ok
+}
+
 func (c *baseVerificationCallbacks) GetEmojisShown(txnID id.VerificationTransactionID) []rune {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.emojisShown[txnID]
 }
 
 func (c *baseVerificationCallbacks) GetDecimalsShown(txnID id.VerificationTransactionID) []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.decimalsShown[txnID]
 }
 
 func (c *baseVerificationCallbacks) VerificationRequested(ctx context.Context, txnID id.VerificationTransactionID, from id.UserID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.verificationsRequested[from] = append(c.verificationsRequested[from], txnID)
 }
 
 func (c *baseVerificationCallbacks) VerificationCancelled(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.verificationCancellation[txnID] = &event.VerificationCancelEventContent{
 		Code:   code,
 		Reason: reason,
@@ -89,9 +127,17 @@ func (c *baseVerificationCallbacks) VerificationCancelled(ctx context.Context, t
 }
 
 func (c *baseVerificationCallbacks) VerificationDone(ctx context.Context, txnID id.VerificationTransactionID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.doneTransactions[txnID] = struct{}{}
 }
 
+func (c *baseVerificationCallbacks) VerificationTimedOut(ctx context.Context, txnID id.VerificationTransactionID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timedOutTransactions[txnID] = struct{}{}
+}
+
 type sasVerificationCallbacks struct {
 	*baseVerificationCallbacks
 }
@@ -105,6 +151,8 @@ func newSASVerificationCallbacksWithBase(base *baseVerificationCallbacks) *sasVe
 }
 
 func (c *sasVerificationCallbacks) ShowSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, decimals []int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.emojisShown[txnID] = emojis
 	c.decimalsShown[txnID] = decimals
 }
@@ -122,14 +170,20 @@ func newQRCodeVerificationCallbacksWithBase(base *baseVerificationCallbacks) *qr
 }
 
 func (c *qrCodeVerificationCallbacks) ScanQRCode(ctx context.Context, txnID id.VerificationTransactionID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.scanQRCodeTransactions = append(c.scanQRCodeTransactions, txnID)
 }
 
 func (c *qrCodeVerificationCallbacks) ShowQRCode(ctx context.Context, txnID id.VerificationTransactionID, qrCode *verificationhelper.QRCode) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.qrCodesShown[txnID] = qrCode
 }
 
 func (c *qrCodeVerificationCallbacks) QRCodeScanned(ctx context.Context, txnID id.VerificationTransactionID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.qrCodesScanned[txnID] = struct{}{}
 }
 
@@ -0,0 +1,18 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper
+
+import (
+	"time"
+)
+
+// SetClock replaces the functions used to get the current time and to schedule
+// transaction expiry, so that tests can control when transactions expire.
+func (vh *VerificationHelper) SetClock(now func() time.Time, afterFunc func(d time.Duration, f func())) {
+	vh.now = now
+	vh.afterFunc = afterFunc
+}
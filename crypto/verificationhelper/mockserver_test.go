@@ -13,8 +13,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -33,8 +36,14 @@ import (
 type mockServer struct {
 	*httptest.Server
 
+	// lock protects the maps below, as requests can be made from other
+	// goroutines, like the verification expiry timers.
+	lock sync.Mutex
+
 	AccessTokenToUserID map[string]id.UserID
 	DeviceInbox         map[id.UserID]map[id.DeviceID][]event.Event
+	RoomEvents          map[id.RoomID][]event.Event
+	roomEventsConsumed  map[*mautrix.Client]map[id.RoomID]int
 	AccountData         map[id.UserID]map[event.Type]json.RawMessage
 	DeviceKeys          map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys
 	MasterKeys          map[id.UserID]mautrix.CrossSigningKeys
@@ -62,6 +71,8 @@ func createMockServer(t *testing.T) *mockServer {
 	server := mockServer{
 		AccessTokenToUserID: map[string]id.UserID{},
 		DeviceInbox:         map[id.UserID]map[id.DeviceID][]event.Event{},
+		RoomEvents:          map[id.RoomID][]event.Event{},
+		roomEventsConsumed:  map[*mautrix.Client]map[id.RoomID]int{},
 		AccountData:         map[id.UserID]map[event.Type]json.RawMessage{},
 		DeviceKeys:          map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys{},
 		MasterKeys:          map[id.UserID]mautrix.CrossSigningKeys{},
@@ -74,6 +85,7 @@ func createMockServer(t *testing.T) *mockServer {
 	router.HandleFunc("/_matrix/client/v3/login", server.postLogin).Methods(http.MethodPost)
 	router.HandleFunc("/_matrix/client/v3/keys/query", server.postKeysQuery).Methods(http.MethodPost)
 	router.HandleFunc("/_matrix/client/v3/sendToDevice/{type}/{txn}", server.putSendToDevice).Methods(http.MethodPut)
+	router.HandleFunc("/_matrix/client/v3/rooms/{roomID}/send/{type}/{txn}", server.putSendRoomEvent).Methods(http.MethodPut)
 	router.HandleFunc("/_matrix/client/v3/user/{userID}/account_data/{type}", server.putAccountData).Methods(http.MethodPut)
 	router.HandleFunc("/_matrix/client/v3/keys/device_signing/upload", server.postDeviceSigningUpload).Methods(http.MethodPost)
 	router.HandleFunc("/_matrix/client/v3/keys/signatures/upload", server.emptyResp).Methods(http.MethodPost)
@@ -98,6 +110,8 @@ func (s *mockServer) emptyResp(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (s *mockServer) postLogin(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	var loginReq mautrix.ReqLogin
 	json.NewDecoder(r.Body).Decode(&loginReq)
 
@@ -118,6 +132,8 @@ func (s *mockServer) postLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *mockServer) putSendToDevice(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	vars := mux.Vars(r)
 	var req mautrix.ReqSendToDevice
 	json.NewDecoder(r.Body).Decode(&req)
@@ -139,7 +155,31 @@ func (s *mockServer) putSendToDevice(w http.ResponseWriter, r *http.Request) {
 	s.emptyResp(w, r)
 }
 
+func (s *mockServer) putSendRoomEvent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	vars := mux.Vars(r)
+	roomID := id.RoomID(vars["roomID"])
+	evtType := event.Type{Type: vars["type"], Class: event.MessageEventType}
+	var content event.Content
+	json.NewDecoder(r.Body).Decode(&content)
+	content.ParseRaw(evtType)
+
+	eventID := id.EventID("$" + random.String(20))
+	s.RoomEvents[roomID] = append(s.RoomEvents[roomID], event.Event{
+		Sender:    s.getUserID(r),
+		Type:      evtType,
+		ID:        eventID,
+		RoomID:    roomID,
+		Timestamp: time.Now().UnixMilli(),
+		Content:   content,
+	})
+	json.NewEncoder(w).Encode(&mautrix.RespSendEvent{EventID: eventID})
+}
+
 func (s *mockServer) putAccountData(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	vars := mux.Vars(r)
 	userID := id.UserID(vars["userID"])
 	eventType := event.Type{Type: vars["type"], Class: event.AccountDataEventType}
@@ -153,6 +193,8 @@ func (s *mockServer) putAccountData(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *mockServer) postKeysQuery(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	var req mautrix.ReqQueryKeys
 	json.NewDecoder(r.Body).Decode(&req)
 	resp := mautrix.RespQueryKeys{
@@ -171,6 +213,8 @@ func (s *mockServer) postKeysQuery(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *mockServer) postKeysUpload(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	var req mautrix.ReqUploadKeys
 	json.NewDecoder(r.Body).Decode(&req)
 
@@ -186,6 +230,8 @@ func (s *mockServer) postKeysUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *mockServer) postDeviceSigningUpload(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	var req mautrix.UploadCrossSigningKeysReq
 	json.NewDecoder(r.Body).Decode(&req)
 
@@ -238,9 +284,43 @@ func (ms *mockServer) Login(t *testing.T, ctx context.Context, userID id.UserID,
 func (ms *mockServer) dispatchToDevice(t *testing.T, ctx context.Context, client *mautrix.Client) {
 	t.Helper()
 
-	for _, evt := range ms.DeviceInbox[client.UserID][client.DeviceID] {
+	ms.lock.Lock()
+	evts := ms.DeviceInbox[client.UserID][client.DeviceID]
+	if evts != nil {
+		ms.DeviceInbox[client.UserID][client.DeviceID] = nil
+	}
+	ms.lock.Unlock()
+	for _, evt := range evts {
+		client.Syncer.(*mautrix.DefaultSyncer).Dispatch(ctx, &evt)
+	}
+}
+
+// getDeviceInbox returns a copy of the events waiting to be dispatched to the
+// given device.
+func (ms *mockServer) getDeviceInbox(userID id.UserID, deviceID id.DeviceID) []event.Event {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return slices.Clone(ms.DeviceInbox[userID][deviceID])
+}
+
+// dispatchRoomEvents dispatches all events in the given room that haven't
+// been dispatched to the client yet, including the client's own events.
+func (ms *mockServer) dispatchRoomEvents(t *testing.T, ctx context.Context, client *mautrix.Client, roomID id.RoomID) {
+	t.Helper()
+
+	for {
+		ms.lock.Lock()
+		if _, ok := ms.roomEventsConsumed[client]; !ok {
+			ms.roomEventsConsumed[client] = map[id.RoomID]int{}
+		}
+		if ms.roomEventsConsumed[client][roomID] >= len(ms.RoomEvents[roomID]) {
+			ms.lock.Unlock()
+			return
+		}
+		evt := ms.RoomEvents[roomID][ms.roomEventsConsumed[client][roomID]]
+		ms.roomEventsConsumed[client][roomID]++
+		ms.lock.Unlock()
 		client.Syncer.(*mautrix.DefaultSyncer).Dispatch(ctx, &evt)
-		ms.DeviceInbox[client.UserID][client.DeviceID] = ms.DeviceInbox[client.UserID][client.DeviceID][1:]
 	}
 }
 
@@ -19,6 +19,35 @@ import (
 	"maunium.net/go/mautrix/id"
 )
 
+// ShowQRCode returns the payload of the QR code that should be shown to the
+// other device for the given transaction. The QR code is generated if it
+// hasn't already been generated when the transaction became ready, so this can
+// be used to (re-)display the QR code at any point before it's scanned.
+func (vh *VerificationHelper) ShowQRCode(ctx context.Context, txnID id.VerificationTransactionID) ([]byte, error) {
+	defer vh.persistTransaction(ctx, txnID)
+	vh.activeTransactionsLock.Lock()
+	defer vh.activeTransactionsLock.Unlock()
+	txn, ok := vh.activeTransactions[txnID]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction ID")
+	} else if txn.QRCode != nil {
+		return txn.QRCode.Bytes(), nil
+	} else if txn.VerificationState != verificationStateReady {
+		return nil, fmt.Errorf("transaction is not in the ready state")
+	} else if !slices.Contains(vh.supportedMethods, event.VerificationMethodQRCodeShow) {
+		return nil, fmt.Errorf("showing QR codes is not enabled on this device")
+	} else if !slices.Contains(txn.TheirSupportedMethods, event.VerificationMethodQRCodeScan) {
+		return nil, fmt.Errorf("other device cannot scan QR codes")
+	}
+	qrCode, err := vh.generateQRCode(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+	txn.QRCode = qrCode
+	txn.QRCodeSharedSecret = qrCode.SharedSecret
+	return qrCode.Bytes(), nil
+}
+
 // HandleScannedQRData verifies the keys from a scanned QR code and if
 // successful, sends the m.key.verification.start event and
 // m.key.verification.done event.
@@ -32,6 +61,7 @@ func (vh *VerificationHelper) HandleScannedQRData(ctx context.Context, data []by
 		Stringer("transaction_id", qrCode.TransactionID).
 		Int("mode", int(qrCode.Mode)).
 		Logger()
+	defer vh.persistTransaction(ctx, qrCode.TransactionID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 
@@ -194,6 +224,7 @@ func (vh *VerificationHelper) ConfirmQRCodeScanned(ctx context.Context, txnID id
 		Stringer("transaction_id", txnID).
 		Logger()
 
+	defer vh.persistTransaction(ctx, txnID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 	txn, ok := vh.activeTransactions[txnID]
@@ -253,7 +284,7 @@ func (vh *VerificationHelper) ConfirmQRCodeScanned(ctx context.Context, txnID id
 	return nil
 }
 
-func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *verificationTransaction) error {
+func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *VerificationTransaction) error {
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "generate and show QR code").
 		Stringer("transaction_id", txn.TransactionID).
@@ -266,14 +297,25 @@ func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *ve
 		return nil
 	}
 
+	qrCode, err := vh.generateQRCode(ctx, txn)
+	if err != nil {
+		return err
+	}
+	txn.QRCode = qrCode
+	txn.QRCodeSharedSecret = qrCode.SharedSecret
+	vh.showQRCode(ctx, txn.TransactionID, qrCode)
+	return nil
+}
+
+func (vh *VerificationHelper) generateQRCode(ctx context.Context, txn *VerificationTransaction) (*QRCode, error) {
 	ownCrossSigningPublicKeys := vh.mach.GetOwnCrossSigningPublicKeys(ctx)
 	if ownCrossSigningPublicKeys == nil || len(ownCrossSigningPublicKeys.MasterKey) == 0 {
-		return errors.New("failed to get own cross-signing master public key")
+		return nil, errors.New("failed to get own cross-signing master public key")
 	}
 
 	ownMasterKeyTrusted, err := vh.mach.CryptoStore.IsKeySignedBy(ctx, vh.client.UserID, ownCrossSigningPublicKeys.MasterKey, vh.client.UserID, vh.mach.OwnIdentity().SigningKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	mode := QRCodeModeCrossSigning
 	if vh.client.UserID == txn.TheirUser {
@@ -286,7 +328,7 @@ func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *ve
 	} else {
 		// This is a cross-signing situation.
 		if !ownMasterKeyTrusted {
-			return errors.New("cannot cross-sign other device when own master key is not trusted")
+			return nil, errors.New("cannot cross-sign other device when own master key is not trusted")
 		}
 		mode = QRCodeModeCrossSigning
 	}
@@ -300,7 +342,7 @@ func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *ve
 		// Key 2 is the other user's master signing key.
 		theirSigningKeys, err := vh.mach.GetCrossSigningPublicKeys(ctx, txn.TheirUser)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		key2 = theirSigningKeys.MasterKey.Bytes()
 	case QRCodeModeSelfVerifyingMasterKeyTrusted:
@@ -310,7 +352,7 @@ func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *ve
 		// Key 2 is the other device's key.
 		theirDevice, err := vh.mach.GetOrFetchDevice(ctx, txn.TheirUser, txn.TheirDevice)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		key2 = theirDevice.SigningKey.Bytes()
 	case QRCodeModeSelfVerifyingMasterKeyUntrusted:
@@ -320,11 +362,8 @@ func (vh *VerificationHelper) generateAndShowQRCode(ctx context.Context, txn *ve
 		// Key 2 is the master signing key.
 		key2 = ownCrossSigningPublicKeys.MasterKey.Bytes()
 	default:
-		log.Fatal().Int("mode", int(mode)).Msg("Unknown QR code mode")
+		return nil, fmt.Errorf("unknown QR code mode %d", mode)
 	}
 
-	qrCode := NewQRCode(mode, txn.TransactionID, [32]byte(key1), [32]byte(key2))
-	txn.QRCodeSharedSecret = qrCode.SharedSecret
-	vh.showQRCode(ctx, txn.TransactionID, qrCode)
-	return nil
+	return NewQRCode(mode, txn.TransactionID, [32]byte(key1), [32]byte(key2)), nil
 }
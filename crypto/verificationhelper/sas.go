@@ -38,6 +38,7 @@ func (vh *VerificationHelper) StartSAS(ctx context.Context, txnID id.Verificatio
 		Stringer("transaction_id", txnID).
 		Logger()
 
+	defer vh.persistTransaction(ctx, txnID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 	txn, ok := vh.activeTransactions[txnID]
@@ -92,6 +93,7 @@ func (vh *VerificationHelper) ConfirmSAS(ctx context.Context, txnID id.Verificat
 		Stringer("transaction_id", txnID).
 		Logger()
 
+	defer vh.persistTransaction(ctx, txnID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 	txn, ok := vh.activeTransactions[txnID]
@@ -160,7 +162,7 @@ func (vh *VerificationHelper) ConfirmSAS(ctx context.Context, txnID id.Verificat
 // Spec.
 //
 // [Section 11.12.2.2]: https://spec.matrix.org/v1.9/client-server-api/#short-authentication-string-sas-verification
-func (vh *VerificationHelper) onVerificationStartSAS(ctx context.Context, txn *verificationTransaction, evt *event.Event) error {
+func (vh *VerificationHelper) onVerificationStartSAS(ctx context.Context, txn *VerificationTransaction, evt *event.Event) error {
 	startEvt := evt.Content.AsVerificationStart()
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "start_sas").
@@ -252,7 +254,7 @@ func calculateCommitment(ephemeralPubKey *ecdh.PublicKey, startEvt *event.Verifi
 // event. This follows Step 4 of [Section 11.12.2.2] of the Spec.
 //
 // [Section 11.12.2.2]: https://spec.matrix.org/v1.9/client-server-api/#short-authentication-string-sas-verification
-func (vh *VerificationHelper) onVerificationAccept(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationAccept(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	acceptEvt := evt.Content.AsVerificationAccept()
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "accept").
@@ -294,7 +296,7 @@ func (vh *VerificationHelper) onVerificationAccept(ctx context.Context, txn *ver
 	txn.EphemeralPublicKeyShared = true
 }
 
-func (vh *VerificationHelper) onVerificationKey(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationKey(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "key").
 		Logger()
@@ -372,7 +374,7 @@ func (vh *VerificationHelper) onVerificationKey(ctx context.Context, txn *verifi
 	vh.showSAS(ctx, txn.TransactionID, emojis, decimals)
 }
 
-func (vh *VerificationHelper) verificationSASHKDF(txn *verificationTransaction) ([]byte, error) {
+func (vh *VerificationHelper) verificationSASHKDF(txn *VerificationTransaction) ([]byte, error) {
 	sharedSecret, err := txn.EphemeralKey.ECDH(txn.OtherPublicKey)
 	if err != nil {
 		return nil, err
@@ -462,7 +464,7 @@ func BrokenB64Encode(input []byte) string {
 	return string(output)
 }
 
-func (vh *VerificationHelper) verificationMACHKDF(txn *verificationTransaction, senderUser id.UserID, senderDevice id.DeviceID, receivingUser id.UserID, receivingDevice id.DeviceID, keyID, key string) ([]byte, error) {
+func (vh *VerificationHelper) verificationMACHKDF(txn *VerificationTransaction, senderUser id.UserID, senderDevice id.DeviceID, receivingUser id.UserID, receivingDevice id.DeviceID, keyID, key string) ([]byte, error) {
 	sharedSecret, err := txn.EphemeralKey.ECDH(txn.OtherPublicKey)
 	if err != nil {
 		return nil, err
@@ -563,7 +565,7 @@ var allEmojis = []rune{
 	'📌',
 }
 
-func (vh *VerificationHelper) onVerificationMAC(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationMAC(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "mac").
 		Logger()
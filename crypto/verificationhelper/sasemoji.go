@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SASEmoji is an emoji in a short authentication string along with its
+// description, which should be shown next to the emoji.
+type SASEmoji struct {
+	Emoji       rune
+	Description string
+	// Number is the index of the emoji in the table in the spec, which
+	// can be used to look up translations that aren't registered here.
+	Number int
+}
+
+// DefaultSASEmojiLanguage is the language of the built-in emoji descriptions.
+const DefaultSASEmojiLanguage = "en"
+
+// englishSASEmojiDescriptions are the descriptions of the emojis in
+// allEmojis as listed in [Section 11.12.2.2.3] of the Spec.
+//
+// [Section 11.12.2.2.3]: https://spec.matrix.org/v1.9/client-server-api/#sas-method-emoji
+var englishSASEmojiDescriptions = []string{
+	"Dog", "Cat", "Lion", "Horse", "Unicorn", "Pig", "Elephant", "Rabbit",
+	"Panda", "Rooster", "Penguin", "Turtle", "Fish", "Octopus", "Butterfly", "Flower",
+	"Tree", "Cactus", "Mushroom", "Globe", "Moon", "Cloud", "Fire", "Banana",
+	"Apple", "Strawberry", "Corn", "Pizza", "Cake", "Heart", "Smiley", "Robot",
+	"Hat", "Glasses", "Spanner", "Santa", "Thumbs Up", "Umbrella", "Hourglass", "Clock",
+	"Gift", "Light Bulb", "Book", "Pencil", "Paperclip", "Scissors", "Lock", "Key",
+	"Hammer", "Telephone", "Flag", "Train", "Bicycle", "Aeroplane", "Rocket", "Trophy",
+	"Ball", "Guitar", "Trumpet", "Bell", "Anchor", "Headphones", "Folder", "Pin",
+}
+
+var (
+	sasEmojiTranslations     = map[string][]string{}
+	sasEmojiTranslationsLock sync.RWMutex
+)
+
+func normalizeSASLanguage(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(lang, "-", "_"))
+}
+
+// RegisterSASEmojiTranslations registers the emoji descriptions for the given
+// language. The descriptions must be in the same order as the emojis in the
+// spec. Empty descriptions fall back to English.
+//
+// Language codes are matched case-insensitively and "-" is treated the same
+// as "_", so "pt-BR" and "pt_BR" are the same language.
+func RegisterSASEmojiTranslations(lang string, descriptions []string) error {
+	if len(descriptions) != len(englishSASEmojiDescriptions) {
+		return fmt.Errorf("expected %d emoji descriptions, got %d", len(englishSASEmojiDescriptions), len(descriptions))
+	}
+	sasEmojiTranslationsLock.Lock()
+	sasEmojiTranslations[normalizeSASLanguage(lang)] = descriptions
+	sasEmojiTranslationsLock.Unlock()
+	return nil
+}
+
+type specSASEmoji struct {
+	Number                 int               `json:"number"`
+	TranslatedDescriptions map[string]string `json:"translated_descriptions"`
+}
+
+// LoadSASEmojiTranslationsJSON registers all translations from a JSON file in
+// the same format as the sas-emoji.json file distributed with the Spec.
+func LoadSASEmojiTranslationsJSON(data []byte) error {
+	var emojis []specSASEmoji
+	err := json.Unmarshal(data, &emojis)
+	if err != nil {
+		return fmt.Errorf("failed to parse emoji translations: %w", err)
+	}
+	translations := map[string][]string{}
+	for _, emoji := range emojis {
+		if emoji.Number < 0 || emoji.Number >= len(englishSASEmojiDescriptions) {
+			return fmt.Errorf("invalid emoji number %d", emoji.Number)
+		}
+		for lang, description := range emoji.TranslatedDescriptions {
+			if translations[lang] == nil {
+				translations[lang] = make([]string, len(englishSASEmojiDescriptions))
+			}
+			translations[lang][emoji.Number] = description
+		}
+	}
+	for lang, descriptions := range translations {
+		err = RegisterSASEmojiTranslations(lang, descriptions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SASEmojiDescriptions returns the descriptions of all 64 SAS emojis in the
+// given language.
+//
+// If there are no translations for the language, the translations for the
+// base language (e.g. "pt" for "pt_BR") are used. Missing translations fall
+// back to English.
+func SASEmojiDescriptions(lang string) []string {
+	lang = normalizeSASLanguage(lang)
+	sasEmojiTranslationsLock.RLock()
+	translations, ok := sasEmojiTranslations[lang]
+	if !ok {
+		base, _, _ := strings.Cut(lang, "_")
+		translations = sasEmojiTranslations[base]
+	}
+	sasEmojiTranslationsLock.RUnlock()
+
+	descriptions := make([]string, len(englishSASEmojiDescriptions))
+	for i, english := range englishSASEmojiDescriptions {
+		if translations != nil && translations[i] != "" {
+			descriptions[i] = translations[i]
+		} else {
+			descriptions[i] = english
+		}
+	}
+	return descriptions
+}
+
+// LocalizeSASEmojis returns the given emojis, as passed to
+// [ShowSASCallbacks.ShowSAS], with their descriptions in the given language.
+func LocalizeSASEmojis(emojis []rune, lang string) []SASEmoji {
+	descriptions := SASEmojiDescriptions(lang)
+	localized := make([]SASEmoji, len(emojis))
+	for i, emoji := range emojis {
+		localized[i] = SASEmoji{Emoji: emoji}
+		for idx, candidate := range allEmojis {
+			if candidate == emoji {
+				localized[i].Description = descriptions[idx]
+				localized[i].Number = idx
+				break
+			}
+		}
+	}
+	return localized
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+)
+
+func TestSASEmojiDescriptions_English(t *testing.T) {
+	descriptions := verificationhelper.SASEmojiDescriptions("en")
+	require.Len(t, descriptions, 64)
+	assert.Equal(t, "Dog", descriptions[0])
+	assert.Equal(t, "Pin", descriptions[63])
+	assert.Equal(t, descriptions, verificationhelper.SASEmojiDescriptions("xx"))
+}
+
+func TestSASEmojiDescriptions_LoadJSON(t *testing.T) {
+	err := verificationhelper.LoadSASEmojiTranslationsJSON([]byte(`[
+		{"number": 0, "emoji": "🐶", "description": "Dog", "translated_descriptions": {"de": "Hund", "pt_BR": "Cachorro"}},
+		{"number": 1, "emoji": "🐱", "description": "Cat", "translated_descriptions": {"de": "Katze"}}
+	]`))
+	require.NoError(t, err)
+
+	de := verificationhelper.SASEmojiDescriptions("de")
+	assert.Equal(t, "Hund", de[0])
+	assert.Equal(t, "Katze", de[1])
+	// Missing translations fall back to English
+	assert.Equal(t, "Lion", de[2])
+	// Regional variants fall back to the base language
+	assert.Equal(t, "Hund", verificationhelper.SASEmojiDescriptions("de-AT")[0])
+	assert.Equal(t, "Cachorro", verificationhelper.SASEmojiDescriptions("pt-BR")[0])
+
+	localized := verificationhelper.LocalizeSASEmojis([]rune{'🐱', '📌'}, "de")
+	assert.Equal(t, []verificationhelper.SASEmoji{{'🐱', "Katze", 1}, {'📌', "Pin", 63}}, localized)
+}
+
+func TestRegisterSASEmojiTranslations_WrongLength(t *testing.T) {
+	assert.Error(t, verificationhelper.RegisterSASEmojiTranslations("fr", []string{"Chien"}))
+}
@@ -0,0 +1,8 @@
+-- v0 -> v1: Latest revision
+CREATE TABLE verification_transaction (
+	account_id     TEXT  NOT NULL,
+	transaction_id TEXT  NOT NULL,
+	data           bytea NOT NULL,
+
+	PRIMARY KEY (account_id, transaction_id)
+);
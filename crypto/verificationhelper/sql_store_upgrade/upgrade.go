@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sql_store_upgrade
+
+import (
+	"embed"
+
+	"go.mau.fi/util/dbutil"
+)
+
+var Table dbutil.UpgradeTable
+
+const VersionTableName = "verificationhelper_version"
+
+//go:embed *.sql
+var fs embed.FS
+
+func init() {
+	Table.RegisterFS(fs)
+}
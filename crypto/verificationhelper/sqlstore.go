@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/crypto/goolm/cipher"
+	"maunium.net/go/mautrix/crypto/verificationhelper/sql_store_upgrade"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	saveVerificationTransactionQuery = `
+		INSERT INTO verification_transaction (account_id, transaction_id, data) VALUES ($1, $2, $3)
+		ON CONFLICT (account_id, transaction_id) DO UPDATE SET data = excluded.data
+	`
+	deleteVerificationTransactionQuery = `
+		DELETE FROM verification_transaction WHERE account_id = $1 AND transaction_id = $2
+	`
+	getAllVerificationTransactionsQuery = `
+		SELECT data FROM verification_transaction WHERE account_id = $1
+	`
+)
+
+// SQLVerificationStore is a [VerificationStore] that stores transactions in an SQL database.
+// Transactions are encrypted with the pickle key, as they contain the ephemeral
+// keys and QR code secrets of the verification.
+type SQLVerificationStore struct {
+	DB        *dbutil.Database
+	AccountID string
+	PickleKey []byte
+}
+
+var _ VerificationStore = (*SQLVerificationStore)(nil)
+
+// NewSQLVerificationStore creates a new SQL verification store. The account ID
+// is used to separate transactions of different accounts in the same database.
+// The pickle key is used to encrypt the transactions, usually it should be the
+// same as the one used for the crypto store. Call Upgrade to create the tables
+// before using the store.
+func NewSQLVerificationStore(db *dbutil.Database, log dbutil.DatabaseLogger, accountID string, pickleKey []byte) *SQLVerificationStore {
+	return &SQLVerificationStore{
+		DB:        db.Child(sql_store_upgrade.VersionTableName, sql_store_upgrade.Table, log),
+		AccountID: accountID,
+		PickleKey: pickleKey,
+	}
+}
+
+func (store *SQLVerificationStore) Upgrade(ctx context.Context) error {
+	return store.DB.Upgrade(ctx)
+}
+
+func (store *SQLVerificationStore) SaveVerificationTransaction(ctx context.Context, txn *VerificationTransaction) error {
+	data, err := json.Marshal(txn)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+	encrypted, err := cipher.Pickle(store.PickleKey, data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt transaction: %w", err)
+	}
+	_, err = store.DB.Exec(ctx, saveVerificationTransactionQuery, store.AccountID, txn.TransactionID, encrypted)
+	return err
+}
+
+func (store *SQLVerificationStore) DeleteVerificationTransaction(ctx context.Context, txnID id.VerificationTransactionID) error {
+	_, err := store.DB.Exec(ctx, deleteVerificationTransactionQuery, store.AccountID, txnID)
+	return err
+}
+
+func (store *SQLVerificationStore) GetAllVerificationTransactions(ctx context.Context) ([]*VerificationTransaction, error) {
+	rows, err := store.DB.Query(ctx, getAllVerificationTransactionsQuery, store.AccountID)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*VerificationTransaction, error) {
+		var encrypted []byte
+		err := row.Scan(&encrypted)
+		if err != nil {
+			return nil, err
+		}
+		data, err := cipher.Unpickle(store.PickleKey, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt transaction: %w", err)
+		}
+		var txn VerificationTransaction
+		err = json.Unmarshal(data, &txn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal transaction: %w", err)
+		}
+		return &txn, nil
+	}, err).AsList()
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// VerificationStore persists in-progress verification transactions.
+//
+// Transactions are saved whenever their state changes and deleted when they
+// are completed or cancelled. All transactions in the store are loaded when
+// the [VerificationHelper] is initialized.
+//
+// Transactions contain secrets like the ephemeral SAS key and the QR code
+// shared secret, which would allow completing the verification, so stores
+// must not persist them in plaintext.
+type VerificationStore interface {
+	SaveVerificationTransaction(ctx context.Context, txn *VerificationTransaction) error
+	DeleteVerificationTransaction(ctx context.Context, txnID id.VerificationTransactionID) error
+	GetAllVerificationTransactions(ctx context.Context) ([]*VerificationTransaction, error)
+}
+
+type marshalableVerificationTransaction struct {
+	*verificationTransactionAlias
+	EphemeralKey   []byte `json:"EphemeralKey,omitempty"`
+	OtherPublicKey []byte `json:"OtherPublicKey,omitempty"`
+}
+
+type verificationTransactionAlias VerificationTransaction
+
+func (txn *VerificationTransaction) MarshalJSON() ([]byte, error) {
+	data := marshalableVerificationTransaction{verificationTransactionAlias: (*verificationTransactionAlias)(txn)}
+	if txn.EphemeralKey != nil {
+		data.EphemeralKey = txn.EphemeralKey.Bytes()
+	}
+	if txn.OtherPublicKey != nil {
+		data.OtherPublicKey = txn.OtherPublicKey.Bytes()
+	}
+	return json.Marshal(&data)
+}
+
+func (txn *VerificationTransaction) UnmarshalJSON(raw []byte) error {
+	data := marshalableVerificationTransaction{verificationTransactionAlias: (*verificationTransactionAlias)(txn)}
+	err := json.Unmarshal(raw, &data)
+	if err != nil {
+		return err
+	}
+	if data.EphemeralKey != nil {
+		txn.EphemeralKey, err = ecdh.X25519().NewPrivateKey(data.EphemeralKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse ephemeral key: %w", err)
+		}
+	}
+	if data.OtherPublicKey != nil {
+		txn.OtherPublicKey, err = ecdh.X25519().NewPublicKey(data.OtherPublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse other public key: %w", err)
+		}
+	}
+	return nil
+}
+
+// persistTransaction saves the current state of the given transaction to the
+// store, or deletes it from the store if it's no longer active.
+//
+// Must not be called with the activeTransactionsLock held.
+func (vh *VerificationHelper) persistTransaction(ctx context.Context, txnID id.VerificationTransactionID) {
+	if vh.Store == nil {
+		return
+	}
+	vh.activeTransactionsLock.Lock()
+	defer vh.activeTransactionsLock.Unlock()
+	var err error
+	if txn, ok := vh.activeTransactions[txnID]; ok {
+		err = vh.Store.SaveVerificationTransaction(ctx, txn)
+	} else {
+		err = vh.Store.DeleteVerificationTransaction(ctx, txnID)
+	}
+	if err != nil {
+		vh.getLog(ctx).Err(err).
+			Stringer("transaction_id", txnID).
+			Msg("Failed to persist verification transaction")
+	}
+}
+
+func (vh *VerificationHelper) loadTransactions(ctx context.Context) error {
+	txns, err := vh.Store.GetAllVerificationTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load verification transactions: %w", err)
+	}
+	vh.activeTransactionsLock.Lock()
+	defer vh.activeTransactionsLock.Unlock()
+	for _, txn := range txns {
+		vh.activeTransactions[txn.TransactionID] = txn
+		if !txn.ExpiresAt.IsZero() {
+			// Transactions that expired while we were offline will be
+			// cancelled immediately.
+			vh.expireTransactionAt(txn.TransactionID, txn.ExpiresAt)
+		}
+	}
+	vh.getLog(ctx).Debug().Int("count", len(txns)).Msg("Loaded verification transactions from store")
+	return nil
+}
+
+// ActiveTransactions returns the IDs of all in-progress verification
+// transactions. After a restart, this can be used to find transactions that
+// were loaded from the [VerificationStore] to resume or cancel them.
+func (vh *VerificationHelper) ActiveTransactions() []id.VerificationTransactionID {
+	vh.activeTransactionsLock.Lock()
+	defer vh.activeTransactionsLock.Unlock()
+	txnIDs := make([]id.VerificationTransactionID, 0, len(vh.activeTransactions))
+	for txnID := range vh.activeTransactions {
+		txnIDs = append(txnIDs, txnID)
+	}
+	return txnIDs
+}
+
+// TransactionExpiresAt returns the time when the given transaction will be
+// cancelled due to a timeout, or a zero time if it doesn't expire.
+func (vh *VerificationHelper) TransactionExpiresAt(txnID id.VerificationTransactionID) time.Time {
+	vh.activeTransactionsLock.Lock()
+	defer vh.activeTransactionsLock.Unlock()
+	if txn, ok := vh.activeTransactions[txnID]; ok {
+		return txn.ExpiresAt
+	}
+	return time.Time{}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestVerificationStore_ResumeAfterRestart(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginAliceBob(t, ctx)
+	defer ts.Close()
+
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000")
+	require.NoError(t, err)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	store := verificationhelper.NewSQLVerificationStore(db, dbutil.ZeroLogger(log.Logger), "alice", []byte("test"))
+	require.NoError(t, store.Upgrade(ctx))
+
+	sendingCallbacks := newAllVerificationCallbacks()
+	sendingHelper := verificationhelper.NewVerificationHelper(sendingClient, sendingMachine, sendingCallbacks, true)
+	sendingHelper.Store = store
+	require.NoError(t, sendingHelper.Init(ctx))
+	receivingCallbacks := newAllVerificationCallbacks()
+	receivingHelper := verificationhelper.NewVerificationHelper(receivingClient, receivingMachine, receivingCallbacks, true)
+	require.NoError(t, receivingHelper.Init(ctx))
+
+	_, _, err = sendingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+	_, _, err = receivingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+	sendingMachine.FetchKeys(ctx, []id.UserID{bobUserID}, true)
+	receivingMachine.FetchKeys(ctx, []id.UserID{aliceUserID}, true)
+
+	txnID, err := sendingHelper.StartVerification(ctx, bobUserID)
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	require.NoError(t, receivingHelper.AcceptVerification(ctx, txnID))
+	ts.dispatchToDevice(t, ctx, sendingClient)
+	shownQRCode := sendingCallbacks.GetQRCodeShown(txnID)
+	require.NotNil(t, shownQRCode)
+
+	// Simulate a restart by creating a new helper with a fresh syncer and the
+	// same store.
+	sendingClient.Syncer = mautrix.NewDefaultSyncer()
+	restartedCallbacks := newAllVerificationCallbacks()
+	restartedHelper := verificationhelper.NewVerificationHelper(sendingClient, sendingMachine, restartedCallbacks, true)
+	restartedHelper.Store = store
+	require.NoError(t, restartedHelper.Init(ctx))
+	assert.Equal(t, []string{string(txnID)}, toStrings(restartedHelper.ActiveTransactions()))
+	assert.False(t, restartedHelper.TransactionExpiresAt(txnID).IsZero())
+
+	// The QR code that was shown before the restart is still valid.
+	qrBytes, err := restartedHelper.ShowQRCode(ctx, txnID)
+	require.NoError(t, err)
+	assert.Equal(t, shownQRCode.Bytes(), qrBytes)
+
+	// Cancelling after the restart notifies the other device and removes the
+	// transaction from the store.
+	err = restartedHelper.CancelVerification(ctx, txnID, event.VerificationCancelCodeUser, "restarted")
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	cancellation := receivingCallbacks.GetVerificationCancellation(txnID)
+	require.NotNil(t, cancellation)
+	assert.Equal(t, event.VerificationCancelCodeUser, cancellation.Code)
+
+	txns, err := store.GetAllVerificationTransactions(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, txns)
+}
+
+func TestVerificationTransaction_JSONRoundtrip(t *testing.T) {
+	ephemeralKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	txn := &verificationhelper.VerificationTransaction{
+		TransactionID:  id.NewVerificationTransactionID(),
+		TheirUser:      bobUserID,
+		TheirDevice:    receivingDeviceID,
+		EphemeralKey:   ephemeralKey,
+		OtherPublicKey: otherKey.PublicKey(),
+		SentOurMAC:     true,
+	}
+
+	data, err := json.Marshal(txn)
+	require.NoError(t, err)
+	var parsed verificationhelper.VerificationTransaction
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, txn.TransactionID, parsed.TransactionID)
+	assert.Equal(t, txn.TheirUser, parsed.TheirUser)
+	assert.Equal(t, txn.TheirDevice, parsed.TheirDevice)
+	assert.True(t, parsed.SentOurMAC)
+	require.NotNil(t, parsed.EphemeralKey)
+	assert.True(t, ephemeralKey.Equal(parsed.EphemeralKey))
+	require.NotNil(t, parsed.OtherPublicKey)
+	assert.True(t, otherKey.PublicKey().Equal(parsed.OtherPublicKey))
+}
+
+func TestSQLVerificationStore_Encrypted(t *testing.T) {
+	ctx := context.Background()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000")
+	require.NoError(t, err)
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	store := verificationhelper.NewSQLVerificationStore(db, dbutil.ZeroLogger(log.Logger), "alice", []byte("test"))
+	require.NoError(t, store.Upgrade(ctx))
+
+	ephemeralKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	txn := &verificationhelper.VerificationTransaction{
+		TransactionID:      id.NewVerificationTransactionID(),
+		TheirUser:          bobUserID,
+		EphemeralKey:       ephemeralKey,
+		QRCodeSharedSecret: []byte("meow meow secret"),
+	}
+	require.NoError(t, store.SaveVerificationTransaction(ctx, txn))
+
+	var stored []byte
+	err = db.QueryRow(ctx, "SELECT data FROM verification_transaction WHERE transaction_id=$1", txn.TransactionID).Scan(&stored)
+	require.NoError(t, err)
+	plaintext, err := json.Marshal(txn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), string(txn.TheirUser))
+	assert.NotEqual(t, plaintext, stored)
+
+	txns, err := store.GetAllVerificationTransactions(ctx)
+	require.NoError(t, err)
+	require.Len(t, txns, 1)
+	assert.True(t, ephemeralKey.Equal(txns[0].EphemeralKey))
+	assert.Equal(t, txn.QRCodeSharedSecret, txns[0].QRCodeSharedSecret)
+
+	store.PickleKey = []byte("wrong")
+	_, err = store.GetAllVerificationTransactions(ctx)
+	assert.Error(t, err)
+}
+
+func toStrings[T ~string](items []T) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = string(item)
+	}
+	return out
+}
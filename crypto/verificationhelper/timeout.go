@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultVerificationTimeout is the time after which verification requests
+// expire as specified in [Section 11.12.2.1] of the Spec.
+//
+// [Section 11.12.2.1]: https://spec.matrix.org/v1.9/client-server-api/#key-verification-framework
+const DefaultVerificationTimeout = 10 * time.Minute
+
+type TimeoutCallbacks interface {
+	// VerificationTimedOut is called when a transaction was cancelled because
+	// it wasn't completed before the timeout. It is called after the
+	// VerificationCancelled callback in [RequiredCallbacks].
+	VerificationTimedOut(ctx context.Context, txnID id.VerificationTransactionID)
+}
+
+// expireTransactionAt schedules the transaction with the given ID to be
+// cancelled at the given time. If the transaction has already been completed
+// or cancelled by then, nothing happens.
+func (vh *VerificationHelper) expireTransactionAt(txnID id.VerificationTransactionID, expireAt time.Time) {
+	vh.afterFunc(expireAt.Sub(vh.now()), func() {
+		vh.expireTransaction(txnID)
+	})
+}
+
+func (vh *VerificationHelper) expireTransaction(txnID id.VerificationTransactionID) {
+	log := vh.getLog(context.Background()).With().
+		Str("verification_action", "expire transaction").
+		Stringer("transaction_id", txnID).
+		Logger()
+	ctx := log.WithContext(context.Background())
+	defer vh.persistTransaction(ctx, txnID)
+
+	vh.activeTransactionsLock.Lock()
+	txn, ok := vh.activeTransactions[txnID]
+	if !ok || txn.ExpiresAt.IsZero() || vh.now().Before(txn.ExpiresAt) {
+		// The transaction is already gone or the expiry was moved forward,
+		// in which case another timer will handle it.
+		vh.activeTransactionsLock.Unlock()
+		return
+	}
+	log.Info().Time("expired_at", txn.ExpiresAt).Msg("Verification transaction timed out")
+	err := vh.cancelVerificationTxn(ctx, txn, event.VerificationCancelCodeTimeout, "verification timed out")
+	if _, stillActive := vh.activeTransactions[txnID]; stillActive {
+		// Sending the cancellation failed, but the transaction is expired
+		// regardless, so drop it and notify the application anyway.
+		log.Warn().Err(err).Msg("Failed to notify other device about timeout")
+		delete(vh.activeTransactions, txnID)
+		vh.verificationCancelledCallback(ctx, txnID, event.VerificationCancelCodeTimeout, "verification timed out")
+	}
+	vh.activeTransactionsLock.Unlock()
+
+	if vh.verificationTimedOut != nil {
+		vh.verificationTimedOut(ctx, txnID)
+	}
+}
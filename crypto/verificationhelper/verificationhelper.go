@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/ecdh"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -63,7 +64,9 @@ func (step verificationState) String() string {
 	}
 }
 
-type verificationTransaction struct {
+// VerificationTransaction is the state of a single in-progress verification.
+// It can be serialized to JSON to be persisted in a [VerificationStore].
+type VerificationTransaction struct {
 	// RoomID is the room ID if the verification is happening in a room or
 	// empty if it is a to-device verification.
 	RoomID id.RoomID
@@ -88,6 +91,13 @@ type verificationTransaction struct {
 	// verification request is accepted via a m.key.verification.ready event.
 	SentToDeviceIDs []id.DeviceID
 
+	// ExpiresAt is the time when the transaction will be cancelled if it
+	// hasn't been completed yet. Zero means the transaction doesn't expire.
+	ExpiresAt time.Time
+
+	// QRCode is the QR code that we showed, or nil if we haven't generated
+	// one for this transaction.
+	QRCode *QRCode
 	// QRCodeSharedSecret is the shared secret that was encoded in the QR code
 	// that we showed.
 	QRCodeSharedSecret []byte
@@ -126,6 +136,14 @@ type ShowSASCallbacks interface {
 	ShowSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, decimals []int)
 }
 
+type ShowLocalizedSASCallbacks interface {
+	// ShowLocalizedSAS is like [ShowSASCallbacks.ShowSAS], but the emojis
+	// include their descriptions in the language configured in
+	// [VerificationHelper.SASLanguage]. If the callbacks implement both
+	// interfaces, only ShowLocalizedSAS is called.
+	ShowLocalizedSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []SASEmoji, decimals []int)
+}
+
 type ShowQRCodeCallbacks interface {
 	// ScanQRCode is called when another device has sent a
 	// m.key.verification.ready event and indicated that they are capable of
@@ -145,14 +163,35 @@ type VerificationHelper struct {
 	client *mautrix.Client
 	mach   *crypto.OlmMachine
 
-	activeTransactions     map[id.VerificationTransactionID]*verificationTransaction
+	activeTransactions     map[id.VerificationTransactionID]*VerificationTransaction
 	activeTransactionsLock sync.Mutex
 
+	// Store is used to persist in-progress transactions so that they can be
+	// resumed or cancelled after a restart. If nil, transactions are only
+	// kept in memory. It must be set before calling [VerificationHelper.Init].
+	Store VerificationStore
+	// Timeout is how long verification requests are valid for. Transactions
+	// that haven't been completed when the timeout is reached are cancelled
+	// automatically with the m.timeout code. Defaults to
+	// [DefaultVerificationTimeout], which is the value mandated by the spec.
+	// Changes only affect transactions started after the change.
+	Timeout time.Duration
+	// SASLanguage is the language of the emoji descriptions passed to
+	// [ShowLocalizedSASCallbacks.ShowLocalizedSAS]. Defaults to English.
+	// Translations for languages other than English must be registered with
+	// [RegisterSASEmojiTranslations] or [LoadSASEmojiTranslationsJSON].
+	SASLanguage string
+
+	// now and afterFunc are used for transaction expiry and can be replaced in tests.
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func())
+
 	// supportedMethods are the methods that *we* support
 	supportedMethods              []event.VerificationMethod
 	verificationRequested         func(ctx context.Context, txnID id.VerificationTransactionID, from id.UserID)
 	verificationCancelledCallback func(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string)
 	verificationDone              func(ctx context.Context, txnID id.VerificationTransactionID)
+	verificationTimedOut          func(ctx context.Context, txnID id.VerificationTransactionID)
 
 	showSAS func(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, decimals []int)
 
@@ -171,7 +210,13 @@ func NewVerificationHelper(client *mautrix.Client, mach *crypto.OlmMachine, call
 	helper := VerificationHelper{
 		client:             client,
 		mach:               mach,
-		activeTransactions: map[id.VerificationTransactionID]*verificationTransaction{},
+		activeTransactions: map[id.VerificationTransactionID]*VerificationTransaction{},
+		Timeout:            DefaultVerificationTimeout,
+		SASLanguage:        DefaultSASEmojiLanguage,
+		now:                time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
 	}
 
 	if c, ok := callbacks.(RequiredCallbacks); !ok {
@@ -182,11 +227,21 @@ func NewVerificationHelper(client *mautrix.Client, mach *crypto.OlmMachine, call
 		helper.verificationDone = c.VerificationDone
 	}
 
+	if c, ok := callbacks.(TimeoutCallbacks); ok {
+		helper.verificationTimedOut = c.VerificationTimedOut
+	}
+
 	supportedMethods := map[event.VerificationMethod]struct{}{}
 	if c, ok := callbacks.(ShowSASCallbacks); ok {
 		supportedMethods[event.VerificationMethodSAS] = struct{}{}
 		helper.showSAS = c.ShowSAS
 	}
+	if c, ok := callbacks.(ShowLocalizedSASCallbacks); ok {
+		supportedMethods[event.VerificationMethodSAS] = struct{}{}
+		helper.showSAS = func(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, decimals []int) {
+			c.ShowLocalizedSAS(ctx, txnID, LocalizeSASEmojis(emojis, helper.SASLanguage), decimals)
+		}
+	}
 	if c, ok := callbacks.(ShowQRCodeCallbacks); ok {
 		supportedMethods[event.VerificationMethodQRCodeShow] = struct{}{}
 		supportedMethods[event.VerificationMethodReciprocate] = struct{}{}
@@ -222,6 +277,13 @@ func (vh *VerificationHelper) Init(ctx context.Context) error {
 		return fmt.Errorf("the client syncer must implement ExtensibleSyncer")
 	}
 
+	if vh.Store != nil {
+		err := vh.loadTransactions(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Event handlers for verification requests. These are special since we do
 	// not need to check that the transaction ID is known.
 	syncer.OnEventType(event.ToDeviceVerificationRequest, vh.onVerificationRequest)
@@ -233,7 +295,7 @@ func (vh *VerificationHelper) Init(ctx context.Context) error {
 
 	// Wrapper for the event handlers to check that the transaction ID is known
 	// and ignore the event if it isn't.
-	wrapHandler := func(callback func(context.Context, *verificationTransaction, *event.Event)) func(context.Context, *event.Event) {
+	wrapHandler := func(callback func(context.Context, *VerificationTransaction, *event.Event)) func(context.Context, *event.Event) {
 		return func(ctx context.Context, evt *event.Event) {
 			log := vh.getLog(ctx).With().
 				Str("verification_action", "check transaction ID").
@@ -243,15 +305,27 @@ func (vh *VerificationHelper) Init(ctx context.Context) error {
 				Logger()
 
 			var transactionID id.VerificationTransactionID
-			if evt.ID != "" {
-				transactionID = id.VerificationTransactionID(evt.ID)
-			} else {
-				if txnID, ok := evt.Content.Parsed.(event.VerificationTransactionable); !ok {
-					log.Warn().Msg("Ignoring verification event without a transaction ID")
+			if evt.RoomID != "" {
+				// In-room verification events are echoed back to us by the
+				// server, so ignore our own events. In-room verification is
+				// never used for verifying our own devices.
+				if evt.Sender == vh.client.UserID {
+					log.Debug().Msg("Ignoring our own in-room verification event")
 					return
-				} else {
-					transactionID = txnID.GetTransactionID()
 				}
+				// The transaction ID of in-room verifications is the event ID
+				// of the request, which all other events reference.
+				relatable, ok := evt.Content.Parsed.(event.Relatable)
+				if !ok || relatable.OptionalGetRelatesTo() == nil || relatable.OptionalGetRelatesTo().Type != event.RelReference {
+					log.Warn().Msg("Ignoring in-room verification event without a reference relation")
+					return
+				}
+				transactionID = id.VerificationTransactionID(relatable.OptionalGetRelatesTo().EventID)
+			} else if txnID, ok := evt.Content.Parsed.(event.VerificationTransactionable); !ok {
+				log.Warn().Msg("Ignoring verification event without a transaction ID")
+				return
+			} else {
+				transactionID = txnID.GetTransactionID()
 			}
 			log = log.With().Stringer("transaction_id", transactionID).Logger()
 
@@ -268,14 +342,10 @@ func (vh *VerificationHelper) Init(ctx context.Context) error {
 
 				// We have to create a fake transaction so that the call to
 				// verificationCancelled works.
-				txn = &verificationTransaction{
-					RoomID:    evt.RoomID,
-					TheirUser: evt.Sender,
-				}
-				if transactionable, ok := evt.Content.Parsed.(event.VerificationTransactionable); ok {
-					txn.TransactionID = transactionable.GetTransactionID()
-				} else {
-					txn.TransactionID = id.VerificationTransactionID(evt.ID)
+				txn = &VerificationTransaction{
+					RoomID:        evt.RoomID,
+					TransactionID: transactionID,
+					TheirUser:     evt.Sender,
 				}
 				if fromDevice, ok := evt.Content.Raw["from_device"]; ok {
 					txn.TheirDevice = id.DeviceID(fromDevice.(string))
@@ -298,6 +368,7 @@ func (vh *VerificationHelper) Init(ctx context.Context) error {
 					Stringer("event_id", evt.ID)
 			}
 			callback(logCtx.Logger().WithContext(ctx), txn, evt)
+			vh.persistTransaction(ctx, transactionID)
 		}
 	}
 
@@ -344,6 +415,27 @@ func (vh *VerificationHelper) StartVerification(ctx context.Context, to id.UserI
 		}
 	}
 
+	return vh.sendToDeviceVerificationRequest(ctx, txnID, to, devices)
+}
+
+// StartDeviceVerification starts an interactive verification flow with a
+// specific device of the given user via a to-device event. Unlike
+// [VerificationHelper.StartVerification], the request is only sent to the
+// given device.
+func (vh *VerificationHelper) StartDeviceVerification(ctx context.Context, to id.UserID, deviceID id.DeviceID) (id.VerificationTransactionID, error) {
+	if len(vh.supportedMethods) == 0 {
+		return "", fmt.Errorf("no supported verification methods")
+	} else if to == vh.client.UserID && deviceID == vh.client.DeviceID {
+		return "", fmt.Errorf("can't verify the current device with itself")
+	}
+	device, err := vh.mach.GetOrFetchDevice(ctx, to, deviceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get device: %w", err)
+	}
+	return vh.sendToDeviceVerificationRequest(ctx, id.NewVerificationTransactionID(), to, map[id.DeviceID]*id.Device{deviceID: device})
+}
+
+func (vh *VerificationHelper) sendToDeviceVerificationRequest(ctx context.Context, txnID id.VerificationTransactionID, to id.UserID, devices map[id.DeviceID]*id.Device) (id.VerificationTransactionID, error) {
 	vh.getLog(ctx).Info().
 		Str("verification_action", "start verification").
 		Stringer("transaction_id", txnID).
@@ -351,7 +443,7 @@ func (vh *VerificationHelper) StartVerification(ctx context.Context, to id.UserI
 		Any("device_ids", maps.Keys(devices)).
 		Msg("Sending verification request")
 
-	now := time.Now()
+	now := vh.now()
 	content := &event.Content{
 		Parsed: &event.VerificationRequestEventContent{
 			ToDeviceVerificationEvent: event.ToDeviceVerificationEvent{TransactionID: txnID},
@@ -360,7 +452,8 @@ func (vh *VerificationHelper) StartVerification(ctx context.Context, to id.UserI
 			Timestamp:                 jsontime.UM(now),
 		},
 	}
-	vh.expireTransactionAt(txnID, now.Add(time.Minute*10))
+	expiresAt := now.Add(vh.Timeout)
+	vh.expireTransactionAt(txnID, expiresAt)
 
 	req := mautrix.ReqSendToDevice{Messages: map[id.UserID]map[id.DeviceID]*event.Content{to: {}}}
 	for deviceID := range devices {
@@ -372,19 +465,21 @@ func (vh *VerificationHelper) StartVerification(ctx context.Context, to id.UserI
 
 		req.Messages[to][deviceID] = content
 	}
-	_, err = vh.client.SendToDevice(ctx, event.ToDeviceVerificationRequest, &req)
+	_, err := vh.client.SendToDevice(ctx, event.ToDeviceVerificationRequest, &req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send verification request: %w", err)
 	}
 
 	vh.activeTransactionsLock.Lock()
-	defer vh.activeTransactionsLock.Unlock()
-	vh.activeTransactions[txnID] = &verificationTransaction{
+	vh.activeTransactions[txnID] = &VerificationTransaction{
 		VerificationState: verificationStateRequested,
 		TransactionID:     txnID,
 		TheirUser:         to,
 		SentToDeviceIDs:   maps.Keys(devices),
+		ExpiresAt:         expiresAt,
 	}
+	vh.activeTransactionsLock.Unlock()
+	vh.persistTransaction(ctx, txnID)
 	return txnID, nil
 }
 
@@ -398,18 +493,14 @@ func (vh *VerificationHelper) StartInRoomVerification(ctx context.Context, roomI
 		Logger()
 
 	log.Info().Msg("Sending verification request")
-	content := event.MessageEventContent{
+	content := &event.MessageEventContent{
 		MsgType:    event.MsgVerificationRequest,
-		Body:       "Alice is requesting to verify your device, but your client does not support verification, so you may need to use a different verification method.",
+		Body:       fmt.Sprintf("%s is requesting to verify your device, but your client does not support verification, so you may need to use a different verification method.", vh.client.UserID),
 		FromDevice: vh.client.DeviceID,
 		Methods:    vh.supportedMethods,
 		To:         to,
 	}
-	encryptedContent, err := vh.client.Crypto.Encrypt(ctx, roomID, event.EventMessage, &content)
-	if err != nil {
-		return "", fmt.Errorf("failed to encrypt verification request: %w", err)
-	}
-	resp, err := vh.client.SendMessageEvent(ctx, roomID, event.EventMessage, encryptedContent)
+	resp, err := vh.client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
 	if err != nil {
 		return "", fmt.Errorf("failed to send verification request: %w", err)
 	}
@@ -417,17 +508,80 @@ func (vh *VerificationHelper) StartInRoomVerification(ctx context.Context, roomI
 	txnID := id.VerificationTransactionID(resp.EventID)
 	log.Info().Stringer("transaction_id", txnID).Msg("Got a transaction ID for the verification request")
 
+	expiresAt := vh.now().Add(vh.Timeout)
 	vh.activeTransactionsLock.Lock()
-	defer vh.activeTransactionsLock.Unlock()
-	vh.activeTransactions[txnID] = &verificationTransaction{
+	vh.activeTransactions[txnID] = &VerificationTransaction{
 		RoomID:            roomID,
 		VerificationState: verificationStateRequested,
 		TransactionID:     txnID,
 		TheirUser:         to,
+		ExpiresAt:         expiresAt,
 	}
+	vh.activeTransactionsLock.Unlock()
+	vh.persistTransaction(ctx, txnID)
+	vh.expireTransactionAt(txnID, expiresAt)
 	return txnID, nil
 }
 
+// StartVerificationInDM starts an interactive verification flow with the given
+// user in a direct chat. An existing direct chat from the m.direct account data
+// is used if there is one where both users are members, otherwise a new
+// encrypted direct chat is created and the other user is invited to it.
+func (vh *VerificationHelper) StartVerificationInDM(ctx context.Context, to id.UserID) (id.RoomID, id.VerificationTransactionID, error) {
+	if to == vh.client.UserID {
+		return "", "", fmt.Errorf("in-room verification can't be used to verify own devices")
+	}
+	roomID, err := vh.getOrCreateDM(ctx, to)
+	if err != nil {
+		return "", "", err
+	}
+	txnID, err := vh.StartInRoomVerification(ctx, roomID, to)
+	return roomID, txnID, err
+}
+
+func (vh *VerificationHelper) getOrCreateDM(ctx context.Context, with id.UserID) (id.RoomID, error) {
+	log := vh.getLog(ctx).With().
+		Str("verification_action", "get or create DM").
+		Stringer("with", with).
+		Logger()
+	var directChats event.DirectChatsEventContent
+	err := vh.client.GetAccountData(ctx, event.AccountDataDirectChats.Type, &directChats)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return "", fmt.Errorf("failed to get direct chat list: %w", err)
+	}
+	if vh.client.StateStore != nil {
+		for _, roomID := range directChats[with] {
+			if vh.client.StateStore.IsInRoom(ctx, roomID, vh.client.UserID) &&
+				vh.client.StateStore.IsMembership(ctx, roomID, with, event.MembershipJoin, event.MembershipInvite) {
+				log.Debug().Stringer("room_id", roomID).Msg("Found existing DM for verification")
+				return roomID, nil
+			}
+		}
+	}
+	resp, err := vh.client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		IsDirect: true,
+		Invite:   []id.UserID{with},
+		InitialState: []*event.Event{{
+			Type:    event.StateEncryption,
+			Content: event.Content{Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}},
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create DM: %w", err)
+	}
+	log.Info().Stringer("room_id", resp.RoomID).Msg("Created new DM for verification")
+	if directChats == nil {
+		directChats = event.DirectChatsEventContent{}
+	}
+	directChats[with] = append(directChats[with], resp.RoomID)
+	err = vh.client.SetAccountData(ctx, event.AccountDataDirectChats.Type, &directChats)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to add new DM to direct chat list")
+	}
+	return resp.RoomID, nil
+}
+
 // AcceptVerification accepts a verification request. The transaction ID should
 // be the transaction ID of a verification request that was received via the
 // VerificationRequested callback in [RequiredCallbacks].
@@ -436,6 +590,7 @@ func (vh *VerificationHelper) AcceptVerification(ctx context.Context, txnID id.V
 		Str("verification_action", "accept verification").
 		Stringer("transaction_id", txnID).
 		Logger()
+	defer vh.persistTransaction(ctx, txnID)
 
 	txn, ok := vh.activeTransactions[txnID]
 	if !ok {
@@ -487,6 +642,7 @@ func (vh *VerificationHelper) AcceptVerification(ctx context.Context, txnID id.V
 // VerificationRequested callback in [RequiredCallbacks] or the
 // [StartVerification] or [StartInRoomVerification] functions.
 func (vh *VerificationHelper) DismissVerification(ctx context.Context, txnID id.VerificationTransactionID) error {
+	defer vh.persistTransaction(ctx, txnID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 	delete(vh.activeTransactions, txnID)
@@ -498,6 +654,7 @@ func (vh *VerificationHelper) DismissVerification(ctx context.Context, txnID id.
 // VerificationRequested callback in [RequiredCallbacks] or the
 // [StartVerification] or [StartInRoomVerification] functions.
 func (vh *VerificationHelper) CancelVerification(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) error {
+	defer vh.persistTransaction(ctx, txnID)
 	vh.activeTransactionsLock.Lock()
 	defer vh.activeTransactionsLock.Unlock()
 
@@ -558,7 +715,7 @@ func (vh *VerificationHelper) CancelVerification(ctx context.Context, txnID id.V
 //     [event.VerificationTransactionable].
 //   - evtType can be either the to-device or in-room version of the event type
 //     as it is always stringified.
-func (vh *VerificationHelper) sendVerificationEvent(ctx context.Context, txn *verificationTransaction, evtType event.Type, content any) error {
+func (vh *VerificationHelper) sendVerificationEvent(ctx context.Context, txn *VerificationTransaction, evtType event.Type, content any) error {
 	if txn.RoomID != "" {
 		content.(event.Relatable).SetRelatesTo(&event.RelatesTo{Type: event.RelReference, EventID: id.EventID(txn.TransactionID)})
 		_, err := vh.client.SendMessageEvent(ctx, txn.RoomID, evtType, &event.Content{
@@ -570,25 +727,57 @@ func (vh *VerificationHelper) sendVerificationEvent(ctx context.Context, txn *ve
 	} else {
 		content.(event.VerificationTransactionable).SetTransactionID(txn.TransactionID)
 		req := mautrix.ReqSendToDevice{Messages: map[id.UserID]map[id.DeviceID]*event.Content{
-			txn.TheirUser: {
-				txn.TheirDevice: &event.Content{Parsed: content},
-			},
+			txn.TheirUser: {},
 		}}
+		if len(txn.TheirDevice) > 0 {
+			req.Messages[txn.TheirUser][txn.TheirDevice] = &event.Content{Parsed: content}
+		} else {
+			// The request hasn't been accepted by any device yet, so send the
+			// event to all of the devices that we sent the request to.
+			for _, deviceID := range txn.SentToDeviceIDs {
+				if deviceID != vh.client.DeviceID {
+					req.Messages[txn.TheirUser][deviceID] = &event.Content{Parsed: content}
+				}
+			}
+		}
 		_, err := vh.client.SendToDevice(ctx, evtType, &req)
 		if err != nil {
-			return fmt.Errorf("failed to send %s event to %s: %w", evtType.String(), txn.TheirDevice, err)
+			return fmt.Errorf("failed to send %s event to %v: %w", evtType.String(), maps.Keys(req.Messages[txn.TheirUser]), err)
 		}
 	}
 	return nil
 }
 
+// winsGlare returns whether our events take precedence over the other
+// device's when both devices send the same kind of event simultaneously. Per
+// the spec, the event from the user with the lexicographically smaller user
+// ID wins. When the user IDs are the same, the device IDs are compared.
+func (vh *VerificationHelper) winsGlare(theirUser id.UserID, theirDevice id.DeviceID) bool {
+	if vh.client.UserID != theirUser {
+		return vh.client.UserID < theirUser
+	}
+	return vh.client.DeviceID < theirDevice
+}
+
+// isOutgoingRequestTo returns whether the transaction is a request we sent
+// to the given device that hasn't been accepted yet.
+func (vh *VerificationHelper) isOutgoingRequestTo(txn *VerificationTransaction, userID id.UserID, deviceID id.DeviceID, roomID id.RoomID) bool {
+	if txn.VerificationState != verificationStateRequested || txn.TheirUser != userID || txn.RoomID != roomID {
+		return false
+	} else if roomID != "" {
+		// Incoming in-room requests always have the requesting device set.
+		return txn.TheirDevice == ""
+	}
+	return slices.Contains(txn.SentToDeviceIDs, deviceID)
+}
+
 // cancelVerificationTxn cancels a verification transaction with the given code
 // and reason. It always returns an error, which is the formatted error message
 // (this is allows the caller to return the result of this function call
 // directly to expose the error to its caller).
 //
 // Must always be called with the activeTransactionsLock held.
-func (vh *VerificationHelper) cancelVerificationTxn(ctx context.Context, txn *verificationTransaction, code event.VerificationCancelCode, reasonFmtStr string, fmtArgs ...any) error {
+func (vh *VerificationHelper) cancelVerificationTxn(ctx context.Context, txn *VerificationTransaction, code event.VerificationCancelCode, reasonFmtStr string, fmtArgs ...any) error {
 	log := vh.getLog(ctx)
 	reason := fmt.Errorf(reasonFmtStr, fmtArgs...).Error()
 	log.Info().
@@ -640,8 +829,8 @@ func (vh *VerificationHelper) onVerificationRequest(ctx context.Context, evt *ev
 		return
 	}
 
-	if verificationRequest.Timestamp.Add(10 * time.Minute).Before(time.Now()) {
-		log.Warn().Msg("Ignoring verification request that is over ten minutes old")
+	if verificationRequest.Timestamp.Add(vh.Timeout).Before(vh.now()) {
+		log.Warn().Stringer("timeout", vh.Timeout).Msg("Ignoring verification request that has already expired")
 		return
 	}
 
@@ -681,13 +870,37 @@ func (vh *VerificationHelper) onVerificationRequest(ctx context.Context, evt *ev
 	}
 
 	vh.activeTransactionsLock.Lock()
-	newTxn := &verificationTransaction{
+	newTxn := &VerificationTransaction{
 		RoomID:                evt.RoomID,
 		VerificationState:     verificationStateRequested,
 		TransactionID:         verificationRequest.TransactionID,
 		TheirDevice:           verificationRequest.FromDevice,
 		TheirUser:             evt.Sender,
 		TheirSupportedMethods: verificationRequest.Methods,
+		ExpiresAt:             verificationRequest.Timestamp.Add(vh.Timeout),
+	}
+	for existingTxnID, existingTxn := range vh.activeTransactions {
+		if !vh.isOutgoingRequestTo(existingTxn, evt.Sender, verificationRequest.FromDevice, evt.RoomID) {
+			continue
+		}
+		// Both sides sent a request to each other at the same time. Resolve
+		// the conflict the same way as simultaneous start events: the request
+		// from the lexicographically smaller user/device ID wins. Both sides
+		// come to the same conclusion, so the losing side cancels its own
+		// request and continues with the winning one.
+		if vh.winsGlare(evt.Sender, verificationRequest.FromDevice) {
+			log.Info().
+				Stringer("our_transaction_id", existingTxnID).
+				Msg("Ignoring verification request as our simultaneous request takes precedence")
+			vh.activeTransactionsLock.Unlock()
+			return
+		}
+		log.Info().
+			Stringer("our_transaction_id", existingTxnID).
+			Msg("Cancelling our verification request in favor of the simultaneous request from the other device")
+		vh.cancelVerificationTxn(ctx, existingTxn, event.VerificationCancelCodeUser, "superseded by a simultaneous verification request")
+		delete(vh.activeTransactions, existingTxnID)
+		defer vh.persistTransaction(ctx, existingTxnID)
 	}
 	for existingTxnID, existingTxn := range vh.activeTransactions {
 		if existingTxn.TheirUser == evt.Sender && existingTxn.TheirDevice == verificationRequest.FromDevice {
@@ -695,6 +908,7 @@ func (vh *VerificationHelper) onVerificationRequest(ctx context.Context, evt *ev
 			vh.cancelVerificationTxn(ctx, newTxn, event.VerificationCancelCodeUnexpectedMessage, "received multiple verification requests from the same device")
 			delete(vh.activeTransactions, existingTxnID)
 			vh.activeTransactionsLock.Unlock()
+			vh.persistTransaction(ctx, existingTxnID)
 			return
 		}
 
@@ -702,33 +916,19 @@ func (vh *VerificationHelper) onVerificationRequest(ctx context.Context, evt *ev
 			vh.cancelVerificationTxn(ctx, existingTxn, event.VerificationCancelCodeUnexpectedMessage, "received a new verification request for the same transaction ID")
 			delete(vh.activeTransactions, existingTxnID)
 			vh.activeTransactionsLock.Unlock()
+			vh.persistTransaction(ctx, existingTxnID)
 			return
 		}
 	}
 	vh.activeTransactions[verificationRequest.TransactionID] = newTxn
 	vh.activeTransactionsLock.Unlock()
+	vh.persistTransaction(ctx, verificationRequest.TransactionID)
 
-	vh.expireTransactionAt(verificationRequest.TransactionID, verificationRequest.Timestamp.Add(time.Minute*10))
+	vh.expireTransactionAt(verificationRequest.TransactionID, newTxn.ExpiresAt)
 	vh.verificationRequested(ctx, verificationRequest.TransactionID, evt.Sender)
 }
 
-func (vh *VerificationHelper) expireTransactionAt(txnID id.VerificationTransactionID, expireAt time.Time) {
-	go func() {
-		time.Sleep(time.Until(expireAt))
-
-		vh.activeTransactionsLock.Lock()
-		defer vh.activeTransactionsLock.Unlock()
-
-		txn, ok := vh.activeTransactions[txnID]
-		if !ok {
-			return
-		}
-
-		vh.cancelVerificationTxn(context.Background(), txn, event.VerificationCancelCodeTimeout, "verification timed out")
-	}()
-}
-
-func (vh *VerificationHelper) onVerificationReady(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationReady(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "verification ready").
 		Logger()
@@ -785,7 +985,7 @@ func (vh *VerificationHelper) onVerificationReady(ctx context.Context, txn *veri
 	}
 }
 
-func (vh *VerificationHelper) onVerificationStart(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationStart(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	startEvt := evt.Content.AsVerificationStart()
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "verification start").
@@ -828,11 +1028,16 @@ func (vh *VerificationHelper) onVerificationStart(ctx context.Context, txn *veri
 			return
 		}
 
-		if txn.TheirUser < vh.client.UserID || (txn.TheirUser == vh.client.UserID && txn.TheirDevice < vh.client.DeviceID) {
-			// Use their start event instead of ours
-			txn.StartedByUs = false
-			txn.StartEventContent = startEvt
+		if vh.winsGlare(txn.TheirUser, txn.TheirDevice) {
+			// Our start event takes precedence, so act as if they never sent
+			// theirs. They will process our start event instead.
+			log.Info().Msg("Ignoring simultaneous start event from other device as ours takes precedence")
+			return
 		}
+		// Use their start event instead of ours
+		log.Info().Msg("Other device's simultaneous start event takes precedence over ours")
+		txn.StartedByUs = false
+		txn.StartEventContent = startEvt
 	} else if txn.VerificationState != verificationStateReady {
 		vh.cancelVerificationTxn(ctx, txn, event.VerificationCancelCodeUnexpectedMessage, "got start event for transaction that is not in ready state")
 		return
@@ -861,7 +1066,7 @@ func (vh *VerificationHelper) onVerificationStart(ctx context.Context, txn *veri
 	}
 }
 
-func (vh *VerificationHelper) onVerificationDone(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationDone(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	vh.getLog(ctx).Info().
 		Str("verification_action", "done").
 		Stringer("transaction_id", txn.TransactionID).
@@ -883,7 +1088,7 @@ func (vh *VerificationHelper) onVerificationDone(ctx context.Context, txn *verif
 	}
 }
 
-func (vh *VerificationHelper) onVerificationCancel(ctx context.Context, txn *verificationTransaction, evt *event.Event) {
+func (vh *VerificationHelper) onVerificationCancel(ctx context.Context, txn *VerificationTransaction, evt *event.Event) {
 	cancelEvt := evt.Content.AsVerificationCancel()
 	log := vh.getLog(ctx).With().
 		Str("verification_action", "cancel").
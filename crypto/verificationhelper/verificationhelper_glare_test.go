@@ -0,0 +1,97 @@
+package verificationhelper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestVerification_SimultaneousRequests(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginTwoAlice(t, ctx)
+	defer ts.Close()
+	sendingCallbacks, receivingCallbacks, sendingHelper, receivingHelper := initDefaultCallbacks(t, ctx, sendingClient, receivingClient, sendingMachine, receivingMachine)
+
+	_, _, err := sendingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+
+	// Both devices request verification from each other before either of
+	// them has received the other device's request.
+	sendingTxnID, err := sendingHelper.StartVerification(ctx, aliceUserID)
+	require.NoError(t, err)
+	receivingTxnID, err := receivingHelper.StartVerification(ctx, aliceUserID)
+	require.NoError(t, err)
+
+	// The receiving device has the lower device ID, so it ignores the
+	// request from the sending device.
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	assert.Empty(t, receivingCallbacks.GetRequestedVerifications())
+	assert.Nil(t, receivingCallbacks.GetVerificationCancellation(receivingTxnID))
+
+	// The sending device cancels its own request and handles the request
+	// from the receiving device instead.
+	ts.dispatchToDevice(t, ctx, sendingClient)
+	assert.Equal(t, event.VerificationCancelCodeUser, sendingCallbacks.GetVerificationCancellation(sendingTxnID).Code)
+	assert.Contains(t, sendingCallbacks.GetRequestedVerifications()[aliceUserID], receivingTxnID)
+	assert.NotContains(t, sendingCallbacks.GetRequestedVerifications()[aliceUserID], sendingTxnID)
+
+	// The cancellation of the superseded request is ignored by the receiving
+	// device, and the remaining request can be accepted as usual.
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	assert.Nil(t, receivingCallbacks.GetVerificationCancellation(receivingTxnID))
+
+	require.NoError(t, sendingHelper.AcceptVerification(ctx, receivingTxnID))
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	assert.Nil(t, receivingCallbacks.GetVerificationCancellation(receivingTxnID))
+	assert.Nil(t, sendingCallbacks.GetVerificationCancellation(receivingTxnID))
+}
+
+func TestVerification_SimultaneousSASStart(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginTwoAlice(t, ctx)
+	defer ts.Close()
+	sendingCallbacks, receivingCallbacks, sendingHelper, receivingHelper := initDefaultCallbacks(t, ctx, sendingClient, receivingClient, sendingMachine, receivingMachine)
+
+	_, _, err := sendingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+
+	txnID, err := sendingHelper.StartVerification(ctx, aliceUserID)
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	require.NoError(t, receivingHelper.AcceptVerification(ctx, txnID))
+	ts.dispatchToDevice(t, ctx, sendingClient)
+
+	// Both devices start SAS at the same time.
+	require.NoError(t, sendingHelper.StartSAS(ctx, txnID))
+	require.NoError(t, receivingHelper.StartSAS(ctx, txnID))
+
+	// The receiving device has the lower device ID, so its start event wins
+	// and it ignores the start event from the sending device.
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	sendingInbox := ts.DeviceInbox[aliceUserID][sendingDeviceID]
+	require.Len(t, sendingInbox, 1)
+	assert.Equal(t, receivingDeviceID, sendingInbox[0].Content.AsVerificationStart().FromDevice)
+
+	// The sending device switches to the receiving device's start event and
+	// accepts it.
+	ts.dispatchToDevice(t, ctx, sendingClient)
+	receivingInbox := ts.DeviceInbox[aliceUserID][receivingDeviceID]
+	require.Len(t, receivingInbox, 1)
+	acceptEvt := receivingInbox[0].Content.AsVerificationAccept()
+	assert.Equal(t, txnID, acceptEvt.TransactionID)
+	assert.NotEmpty(t, acceptEvt.Commitment)
+
+	// The rest of the flow continues normally until both sides show the SAS.
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	ts.dispatchToDevice(t, ctx, sendingClient)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	assert.Nil(t, sendingCallbacks.GetVerificationCancellation(txnID))
+	assert.Nil(t, receivingCallbacks.GetVerificationCancellation(txnID))
+	assert.NotEmpty(t, sendingCallbacks.GetEmojisShown(txnID))
+	assert.Equal(t, sendingCallbacks.GetEmojisShown(txnID), receivingCallbacks.GetEmojisShown(txnID))
+}
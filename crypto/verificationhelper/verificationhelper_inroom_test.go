@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Sumner Evans
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package verificationhelper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestInRoomVerification_ScanQRAndConfirmScan(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	roomID := id.RoomID("!dm:example.org")
+
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginAliceBob(t, ctx)
+	defer ts.Close()
+	sendingCallbacks, receivingCallbacks, sendingHelper, receivingHelper := initDefaultCallbacks(t, ctx, sendingClient, receivingClient, sendingMachine, receivingMachine)
+	var err error
+
+	_, _, err = sendingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+	_, _, err = receivingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+	sendingMachine.FetchKeys(ctx, []id.UserID{bobUserID}, true)
+	receivingMachine.FetchKeys(ctx, []id.UserID{aliceUserID}, true)
+
+	// Send the verification request into the room. The transaction ID is the
+	// event ID of the request.
+	txnID, err := sendingHelper.StartInRoomVerification(ctx, roomID, bobUserID)
+	require.NoError(t, err)
+	require.Len(t, ts.RoomEvents[roomID], 1)
+	assert.EqualValues(t, ts.RoomEvents[roomID][0].ID, txnID)
+	assert.Equal(t, event.MsgVerificationRequest, ts.RoomEvents[roomID][0].Content.AsMessage().MsgType)
+
+	// The sender's own echo of the request must not create a new transaction.
+	ts.dispatchRoomEvents(t, ctx, sendingClient, roomID)
+	assert.False(t, sendingCallbacks.WasOurQRCodeScanned(txnID))
+
+	ts.dispatchRoomEvents(t, ctx, receivingClient, roomID)
+	err = receivingHelper.AcceptVerification(ctx, txnID)
+	require.NoError(t, err)
+	ts.dispatchRoomEvents(t, ctx, sendingClient, roomID)
+
+	sendingShownQRCode := sendingCallbacks.GetQRCodeShown(txnID)
+	require.NotNil(t, sendingShownQRCode)
+	require.NotNil(t, receivingCallbacks.GetQRCodeShown(txnID))
+
+	// Scan the sender's QR code on the receiving device. The start and done
+	// events are sent into the room and relate to the request event.
+	err = receivingHelper.HandleScannedQRData(ctx, sendingShownQRCode.Bytes())
+	require.NoError(t, err)
+	roomEvents := ts.RoomEvents[roomID]
+	require.Len(t, roomEvents, 4)
+	startEvt := roomEvents[2].Content.AsVerificationStart()
+	assert.EqualValues(t, txnID, startEvt.RelatesTo.EventID)
+	assert.Equal(t, event.VerificationMethodReciprocate, startEvt.Method)
+	assert.EqualValues(t, sendingShownQRCode.SharedSecret, startEvt.Secret)
+	assert.EqualValues(t, txnID, roomEvents[3].Content.AsVerificationDone().RelatesTo.EventID)
+
+	ts.dispatchRoomEvents(t, ctx, sendingClient, roomID)
+	assert.True(t, sendingCallbacks.WasOurQRCodeScanned(txnID))
+	err = sendingHelper.ConfirmQRCodeScanned(ctx, txnID)
+	require.NoError(t, err)
+	ts.dispatchRoomEvents(t, ctx, receivingClient, roomID)
+
+	assert.True(t, sendingCallbacks.IsVerificationDone(txnID))
+	assert.True(t, receivingCallbacks.IsVerificationDone(txnID))
+
+	// Nothing should have been sent as to-device events.
+	assert.Empty(t, ts.DeviceInbox[aliceUserID][sendingDeviceID])
+	assert.Empty(t, ts.DeviceInbox[bobUserID][receivingDeviceID])
+
+	bobTrustsAlice, err := receivingMachine.IsUserTrusted(ctx, aliceUserID)
+	assert.NoError(t, err)
+	assert.True(t, bobTrustsAlice)
+	aliceTrustsBob, err := sendingMachine.IsUserTrusted(ctx, bobUserID)
+	assert.NoError(t, err)
+	assert.True(t, aliceTrustsBob)
+}
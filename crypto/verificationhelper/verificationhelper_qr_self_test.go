@@ -371,3 +371,47 @@ func TestSelfVerification_ScanQRKeyCorrupted(t *testing.T) {
 		})
 	}
 }
+
+func TestSelfVerification_ShowQRCodeAndHandleScannedQRData(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginTwoAlice(t, ctx)
+	defer ts.Close()
+	sendingCallbacks, receivingCallbacks, sendingHelper, receivingHelper := initDefaultCallbacks(t, ctx, sendingClient, receivingClient, sendingMachine, receivingMachine)
+
+	_, _, err := sendingMachine.GenerateAndUploadCrossSigningKeys(ctx, nil, "")
+	require.NoError(t, err)
+
+	txnID, err := sendingHelper.StartVerification(ctx, aliceUserID)
+	require.NoError(t, err)
+
+	// The QR code can't be shown before the transaction is ready.
+	_, err = sendingHelper.ShowQRCode(ctx, txnID)
+	assert.ErrorContains(t, err, "not in the ready state")
+
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	err = receivingHelper.AcceptVerification(ctx, txnID)
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, sendingClient)
+
+	// ShowQRCode should return the same QR code that was passed to the
+	// ShowQRCode callback.
+	payload, err := sendingHelper.ShowQRCode(ctx, txnID)
+	require.NoError(t, err)
+	assert.Equal(t, sendingCallbacks.GetQRCodeShown(txnID).Bytes(), payload)
+
+	err = receivingHelper.HandleScannedQRData(ctx, payload)
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, sendingClient)
+	assert.True(t, sendingCallbacks.WasOurQRCodeScanned(txnID))
+
+	err = sendingHelper.ConfirmQRCodeScanned(ctx, txnID)
+	require.NoError(t, err)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+
+	assert.True(t, sendingCallbacks.IsVerificationDone(txnID))
+	assert.True(t, receivingCallbacks.IsVerificationDone(txnID))
+
+	_, err = sendingHelper.ShowQRCode(ctx, txnID)
+	assert.ErrorContains(t, err, "unknown transaction ID")
+}
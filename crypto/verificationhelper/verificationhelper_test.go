@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -139,6 +141,38 @@ func TestVerification_Start(t *testing.T) {
 	}
 }
 
+func TestVerification_StartDevice(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	receivingDeviceID2 := id.DeviceID("receiving2")
+
+	ts := createMockServer(t)
+	defer ts.Close()
+
+	client, cryptoStore := ts.Login(t, ctx, aliceUserID, sendingDeviceID)
+	addDeviceID(ctx, cryptoStore, aliceUserID, sendingDeviceID)
+	addDeviceID(ctx, cryptoStore, aliceUserID, receivingDeviceID)
+	addDeviceID(ctx, cryptoStore, aliceUserID, receivingDeviceID2)
+
+	senderHelper := verificationhelper.NewVerificationHelper(client, client.Crypto.(*cryptohelper.CryptoHelper).Machine(), newAllVerificationCallbacks(), true)
+	require.NoError(t, senderHelper.Init(ctx))
+
+	_, err := senderHelper.StartDeviceVerification(ctx, aliceUserID, sendingDeviceID)
+	assert.ErrorContains(t, err, "can't verify the current device with itself")
+
+	txnID, err := senderHelper.StartDeviceVerification(ctx, aliceUserID, receivingDeviceID2)
+	require.NoError(t, err)
+	assert.NotEmpty(t, txnID)
+
+	// Ensure that the verification request was only sent to the requested device.
+	toDeviceInbox := ts.DeviceInbox[aliceUserID]
+	assert.Empty(t, toDeviceInbox[sendingDeviceID])
+	assert.Empty(t, toDeviceInbox[receivingDeviceID])
+	require.Len(t, toDeviceInbox[receivingDeviceID2], 1)
+	verificationRequest := toDeviceInbox[receivingDeviceID2][0].Content.AsVerificationRequest()
+	assert.Equal(t, sendingDeviceID, verificationRequest.FromDevice)
+	assert.Equal(t, txnID, verificationRequest.TransactionID)
+}
+
 func TestVerification_StartThenCancel(t *testing.T) {
 	ctx := log.Logger.WithContext(context.TODO())
 	bystanderDeviceID := id.DeviceID("bystander")
@@ -478,3 +512,82 @@ func TestVerification_CancelOnDoubleStart(t *testing.T) {
 	assert.NotNil(t, sendingCallbacks.GetVerificationCancellation(txnID1))
 	assert.NotNil(t, sendingCallbacks.GetVerificationCancellation(txnID2))
 }
+
+// fakeClock is a clock for controlling verification expiry in tests. Expiry
+// functions are only run when the clock is advanced, on the calling goroutine.
+type fakeClock struct {
+	lock      sync.Mutex
+	current   time.Time
+	scheduled []fakeClockTimer
+}
+
+type fakeClockTimer struct {
+	at time.Time
+	f  func()
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.current
+}
+
+func (fc *fakeClock) AfterFunc(d time.Duration, f func()) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.scheduled = append(fc.scheduled, fakeClockTimer{at: fc.current.Add(d), f: f})
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	fc.current = fc.current.Add(d)
+	var due []fakeClockTimer
+	fc.scheduled = slices.DeleteFunc(fc.scheduled, func(timer fakeClockTimer) bool {
+		if !timer.at.After(fc.current) {
+			due = append(due, timer)
+			return true
+		}
+		return false
+	})
+	fc.lock.Unlock()
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func TestVerification_Timeout(t *testing.T) {
+	ctx := log.Logger.WithContext(context.TODO())
+	ts, sendingClient, receivingClient, _, _, sendingMachine, receivingMachine := initServerAndLoginTwoAlice(t, ctx)
+	defer ts.Close()
+	sendingCallbacks, receivingCallbacks, sendingHelper, _ := initDefaultCallbacks(t, ctx, sendingClient, receivingClient, sendingMachine, receivingMachine)
+	clock := &fakeClock{current: time.Now()}
+	sendingHelper.SetClock(clock.Now, clock.AfterFunc)
+	sendingHelper.Timeout = time.Minute
+
+	txnID, err := sendingHelper.StartVerification(ctx, aliceUserID)
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Add(time.Minute), sendingHelper.TransactionExpiresAt(txnID))
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	assert.Contains(t, receivingCallbacks.GetRequestedVerifications()[aliceUserID], txnID)
+
+	clock.Advance(59 * time.Second)
+	assert.Len(t, sendingHelper.ActiveTransactions(), 1, "transaction shouldn't expire before the timeout")
+	clock.Advance(time.Second)
+
+	// The sender should have cancelled the transaction and notified both the
+	// application and the other device.
+	assert.Empty(t, sendingHelper.ActiveTransactions())
+	assert.True(t, sendingCallbacks.DidVerificationTimeOut(txnID))
+	cancellation := sendingCallbacks.GetVerificationCancellation(txnID)
+	require.NotNil(t, cancellation)
+	assert.Equal(t, event.VerificationCancelCodeTimeout, cancellation.Code)
+
+	receivingInbox := ts.getDeviceInbox(aliceUserID, receivingDeviceID)
+	require.Len(t, receivingInbox, 1)
+	assert.Equal(t, event.VerificationCancelCodeTimeout, receivingInbox[0].Content.AsVerificationCancel().Code)
+	ts.dispatchToDevice(t, ctx, receivingClient)
+	receivingCancellation := receivingCallbacks.GetVerificationCancellation(txnID)
+	require.NotNil(t, receivingCancellation)
+	assert.Equal(t, event.VerificationCancelCodeTimeout, receivingCancellation.Code)
+	assert.False(t, receivingCallbacks.DidVerificationTimeOut(txnID))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+// PartialDownloadSuffix is appended to the target path of [Client.DownloadToFile] while the download is in progress.
+const PartialDownloadSuffix = ".part"
+
+// DownloadRange downloads the given file starting from the given byte offset using a HTTP range request.
+// If length is zero or negative, everything after the offset is downloaded.
+//
+// Servers are allowed to ignore the range, which means callers must check that the response status is
+// 206 Partial Content before assuming the body starts at the requested offset.
+func (cli *Client) DownloadRange(ctx context.Context, mxcURL id.ContentURI, offset, length int64) (*http.Response, error) {
+	rangeHeader := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rangeHeader += fmt.Sprintf("%d", offset+length-1)
+	}
+	_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
+		Method:           http.MethodGet,
+		URL:              cli.BuildClientURL("v1", "media", "download", mxcURL.Homeserver, mxcURL.FileID),
+		Headers:          http.Header{"Range": {rangeHeader}},
+		DontReadResponse: true,
+	})
+	return resp, err
+}
+
+// DownloadProgressFunc is called by [Client.DownloadToFile] as data is written to the file.
+// The total size is -1 if the server didn't specify the size.
+type DownloadProgressFunc func(downloaded, total int64)
+
+type ReqDownloadToFile struct {
+	// EncryptedFile is the encryption metadata of the file. If set, the hash of the downloaded data
+	// is verified and the file is decrypted before it's moved to the target path.
+	EncryptedFile *attachment.EncryptedFile
+	// Progress is an optional callback for reporting download progress.
+	Progress DownloadProgressFunc
+}
+
+type progressWriter struct {
+	writer     io.Writer
+	downloaded int64
+	total      int64
+	callback   DownloadProgressFunc
+}
+
+func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	n, err = pw.writer.Write(p)
+	pw.downloaded += int64(n)
+	if pw.callback != nil {
+		pw.callback(pw.downloaded, pw.total)
+	}
+	return
+}
+
+// DownloadToFile downloads the given file to the given path.
+//
+// The data is first written to the path with [PartialDownloadSuffix] appended, and moved to the target path
+// once the download is complete. If a partial file already exists, the download is resumed from the end of it
+// using a range request. If the server doesn't support range requests, the download starts from the beginning.
+func (cli *Client) DownloadToFile(ctx context.Context, mxcURL id.ContentURI, path string, req *ReqDownloadToFile) error {
+	if req == nil {
+		req = &ReqDownloadToFile{}
+	}
+	partPath := path + PartialDownloadSuffix
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek to end of partial file: %w", err)
+	}
+	err = cli.downloadToOpenFile(ctx, mxcURL, file, offset, req.Progress)
+	if err != nil {
+		return err
+	}
+	if req.EncryptedFile != nil {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to start of downloaded file: %w", err)
+		} else if err = req.EncryptedFile.DecryptFile(file); err != nil {
+			// The partial file is useless if the hash didn't match, so don't try to resume from it later
+			_ = file.Close()
+			_ = os.Remove(partPath)
+			return fmt.Errorf("failed to decrypt downloaded file: %w", err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close downloaded file: %w", err)
+	} else if err = os.Rename(partPath, path); err != nil {
+		return fmt.Errorf("failed to move downloaded file to target path: %w", err)
+	}
+	return nil
+}
+
+func (cli *Client) downloadToOpenFile(ctx context.Context, mxcURL id.ContentURI, file *os.File, offset int64, progress DownloadProgressFunc) error {
+	var resp *http.Response
+	var err error
+	if offset > 0 {
+		resp, err = cli.DownloadRange(ctx, mxcURL, offset, 0)
+		var httpErr HTTPError
+		if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusRequestedRangeNotSatisfiable) {
+			// The partial file already contains everything
+			return nil
+		}
+	} else {
+		resp, err = cli.Download(ctx, mxcURL)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		// The server ignored the range, so start from the beginning
+		cli.Log.Debug().
+			Stringer("mxc_url", mxcURL).
+			Int("status_code", resp.StatusCode).
+			Msg("Server didn't respond with partial content, restarting download")
+		offset = 0
+		if err = file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate partial file: %w", err)
+		} else if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to start of partial file: %w", err)
+		}
+	}
+	writer := &progressWriter{
+		writer:     file,
+		downloaded: offset,
+		total:      -1,
+		callback:   progress,
+	}
+	if resp.ContentLength >= 0 {
+		writer.total = offset + resp.ContentLength
+	}
+	_, err = io.Copy(writer, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write downloaded data to file: %w", err)
+	}
+	if writer.total >= 0 && writer.downloaded != writer.total {
+		return fmt.Errorf("%w: expected %d bytes, got %d", io.ErrUnexpectedEOF, writer.total, writer.downloaded)
+	}
+	return nil
+}
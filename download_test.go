@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+func newDownloadTestClient(t *testing.T, data []byte, supportRange bool) *mautrix.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !supportRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@alice:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+var testMXC = id.ContentURI{Homeserver: "example.com", FileID: "file"}
+
+func TestClient_DownloadToFile_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	for _, supportRange := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path+mautrix.PartialDownloadSuffix, data[:4000], 0600))
+		cli := newDownloadTestClient(t, data, supportRange)
+		var lastDownloaded, lastTotal int64
+		err := cli.DownloadToFile(context.Background(), testMXC, path, &mautrix.ReqDownloadToFile{
+			Progress: func(downloaded, total int64) {
+				lastDownloaded, lastTotal = downloaded, total
+			},
+		})
+		require.NoError(t, err)
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, downloaded)
+		assert.EqualValues(t, len(data), lastDownloaded)
+		assert.EqualValues(t, len(data), lastTotal)
+		assert.NoFileExists(t, path+mautrix.PartialDownloadSuffix)
+	}
+}
+
+func TestClient_DownloadToFile_Encrypted(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("hello world "), 1000)
+	ciphertext := bytes.Clone(plaintext)
+	encryptingFile := attachment.NewEncryptedFile()
+	encryptingFile.EncryptInPlace(ciphertext)
+	// Round-trip through JSON like a file received in an event
+	fileJSON, err := json.Marshal(encryptingFile)
+	require.NoError(t, err)
+	var file *attachment.EncryptedFile
+	require.NoError(t, json.Unmarshal(fileJSON, &file))
+	cli := newDownloadTestClient(t, ciphertext, true)
+
+	path := filepath.Join(t.TempDir(), "file")
+	err = cli.DownloadToFile(context.Background(), testMXC, path, &mautrix.ReqDownloadToFile{EncryptedFile: file})
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, downloaded)
+
+	// Corrupt partial data must be detected and discarded
+	path = filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path+mautrix.PartialDownloadSuffix, []byte("garbage"), 0600))
+	err = cli.DownloadToFile(context.Background(), testMXC, path, &mautrix.ReqDownloadToFile{EncryptedFile: file})
+	assert.ErrorIs(t, err, attachment.HashMismatch)
+	assert.NoFileExists(t, path)
+	assert.NoFileExists(t, path+mautrix.PartialDownloadSuffix)
+}
@@ -66,6 +66,8 @@ var (
 	MIncompatibleRoomVersion = RespError{ErrCode: "M_INCOMPATIBLE_ROOM_VERSION"}
 	// The client specified a parameter that has the wrong value.
 	MInvalidParam = RespError{ErrCode: "M_INVALID_PARAM", StatusCode: http.StatusBadRequest}
+	// The sliding sync position token is unknown or has expired, and syncing must be restarted without it.
+	MUnknownPos = RespError{ErrCode: "M_UNKNOWN_POS", StatusCode: http.StatusBadRequest}
 
 	MURLNotSet         = RespError{ErrCode: "M_URL_NOT_SET"}
 	MBadStatus         = RespError{ErrCode: "M_BAD_STATUS"}
@@ -63,6 +63,46 @@ type TagMetadata struct {
 	MauDoublePuppetSource string `json:"fi.mau.double_puppet_source,omitempty"`
 }
 
+// OrderFloat returns the order of the tag as a float. ok is false if the tag has no order or it's not a valid number.
+func (tm *TagMetadata) OrderFloat() (order float64, ok bool) {
+	if tm == nil || tm.Order == "" {
+		return 0, false
+	}
+	order, err := tm.Order.Float64()
+	return order, err == nil
+}
+
+// minTagOrderGap is the smallest gap between two tag orders that TagOrderBetween will split.
+const minTagOrderGap = 1e-9
+
+// TagOrderBetween calculates an order for a tag that sorts between the given orders.
+// A nil prev means the start of the list and a nil next means the end of the list.
+//
+// If there's no usable value between the orders (e.g. they're equal or too close together), ok is false and
+// all the tags in the list should be renumbered using EvenTagOrders.
+func TagOrderBetween(prev, next *float64) (order float64, ok bool) {
+	low, high := 0.0, 1.0
+	if prev != nil {
+		low = *prev
+	}
+	if next != nil {
+		high = *next
+	}
+	if high-low < minTagOrderGap {
+		return 0, false
+	}
+	return low + (high-low)/2, true
+}
+
+// EvenTagOrders returns count tag orders that are evenly spaced between 0 and 1 (exclusive).
+func EvenTagOrders(count int) []float64 {
+	orders := make([]float64, count)
+	for i := range orders {
+		orders[i] = float64(i+1) / float64(count+1)
+	}
+	return orders
+}
+
 // DirectChatsEventContent represents the content of a m.direct account data event.
 // https://spec.matrix.org/v1.2/client-server-api/#mdirect
 type DirectChatsEventContent map[id.UserID][]id.RoomID
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/util/ptr"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestTagMetadata_OrderFloat(t *testing.T) {
+	order, ok := (&event.TagMetadata{Order: "0.25"}).OrderFloat()
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, order)
+	_, ok = (&event.TagMetadata{}).OrderFloat()
+	assert.False(t, ok)
+	_, ok = (&event.TagMetadata{Order: "meow"}).OrderFloat()
+	assert.False(t, ok)
+	_, ok = (*event.TagMetadata)(nil).OrderFloat()
+	assert.False(t, ok)
+}
+
+func TestTagOrderBetween(t *testing.T) {
+	order, ok := event.TagOrderBetween(nil, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, order)
+	order, ok = event.TagOrderBetween(ptr.Ptr(0.5), nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0.75, order)
+	order, ok = event.TagOrderBetween(nil, ptr.Ptr(0.5))
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, order)
+	_, ok = event.TagOrderBetween(ptr.Ptr(0.5), ptr.Ptr(0.5))
+	assert.False(t, ok)
+	_, ok = event.TagOrderBetween(ptr.Ptr(0.6), ptr.Ptr(0.5))
+	assert.False(t, ok)
+}
+
+func TestEvenTagOrders(t *testing.T) {
+	assert.Equal(t, []float64{0.25, 0.5, 0.75}, event.EvenTagOrders(3))
+	assert.Empty(t, event.EvenTagOrders(0))
+}
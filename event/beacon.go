@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"time"
+
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type LocationAssetType string
+
+const (
+	LocationAssetSelf    LocationAssetType = "m.self"
+	LocationAssetPin     LocationAssetType = "m.pin"
+	LocationAssetUnknown LocationAssetType = ""
+)
+
+// LocationAsset describes what a location is (MSC3488).
+type LocationAsset struct {
+	Type LocationAssetType `json:"type"`
+}
+
+// LocationInfo is a single location (MSC3488).
+type LocationInfo struct {
+	URI         string `json:"uri"`
+	Description string `json:"description,omitempty"`
+}
+
+// BeaconInfoEventContent represents the content of a live location sharing state event (MSC3672).
+//
+// The state key is the user ID of the sharer, optionally followed by an underscore and an arbitrary suffix.
+type BeaconInfoEventContent struct {
+	Description string             `json:"description,omitempty"`
+	Live        bool               `json:"live"`
+	Timeout     int64              `json:"timeout"`
+	Timestamp   jsontime.UnixMilli `json:"org.matrix.msc3488.ts"`
+	Asset       *LocationAsset     `json:"org.matrix.msc3488.asset,omitempty"`
+}
+
+// NewBeaconInfo creates a live beacon info content that starts now and expires after the given duration.
+func NewBeaconInfo(description string, timeout time.Duration) *BeaconInfoEventContent {
+	return &BeaconInfoEventContent{
+		Description: description,
+		Live:        true,
+		Timeout:     timeout.Milliseconds(),
+		Timestamp:   jsontime.UnixMilliNow(),
+		Asset:       &LocationAsset{Type: LocationAssetSelf},
+	}
+}
+
+// GetAssetType returns the asset type of the beacon, defaulting to [LocationAssetSelf] as specified in MSC3488.
+func (content *BeaconInfoEventContent) GetAssetType() LocationAssetType {
+	if content.Asset == nil || content.Asset.Type == LocationAssetUnknown {
+		return LocationAssetSelf
+	}
+	return content.Asset.Type
+}
+
+// ExpiresAt returns the time after which the beacon is no longer live.
+//
+// If the content doesn't have a start timestamp, the origin server timestamp of the state event should be
+// used instead, which can be passed as the fallback parameter.
+func (content *BeaconInfoEventContent) ExpiresAt(fallback time.Time) time.Time {
+	start := content.Timestamp.Time
+	if content.Timestamp.IsZero() {
+		start = fallback
+	}
+	return start.Add(time.Duration(content.Timeout) * time.Millisecond)
+}
+
+// IsLive returns true if the beacon is marked as live and hasn't expired yet at the given time.
+func (content *BeaconInfoEventContent) IsLive(now, fallback time.Time) bool {
+	return content.Live && now.Before(content.ExpiresAt(fallback))
+}
+
+// Stop returns a copy of the content with live set to false, which can be sent to end location sharing.
+func (content *BeaconInfoEventContent) Stop() *BeaconInfoEventContent {
+	stopped := *content
+	stopped.Live = false
+	return &stopped
+}
+
+// BeaconEventContent represents the content of a live location update event (MSC3672).
+//
+// Beacon events reference the beacon info state event they belong to.
+type BeaconEventContent struct {
+	RelatesTo RelatesTo          `json:"m.relates_to"`
+	Location  LocationInfo       `json:"org.matrix.msc3488.location"`
+	Timestamp jsontime.UnixMilli `json:"org.matrix.msc3488.ts"`
+}
+
+// NewBeacon creates a location update for the given beacon info event.
+func NewBeacon(beaconInfoID id.EventID, geoURI string, ts time.Time) *BeaconEventContent {
+	return &BeaconEventContent{
+		RelatesTo: RelatesTo{
+			Type:    RelReference,
+			EventID: beaconInfoID,
+		},
+		Location:  LocationInfo{URI: geoURI},
+		Timestamp: jsontime.UM(ts),
+	}
+}
+
+// IsWithin returns true if the location update was taken while the given beacon was live.
+func (content *BeaconEventContent) IsWithin(info *BeaconInfoEventContent, fallback time.Time) bool {
+	start := info.Timestamp.Time
+	if info.Timestamp.IsZero() {
+		start = fallback
+	}
+	return !content.Timestamp.Before(start) && content.Timestamp.Before(info.ExpiresAt(fallback))
+}
+
+func (content *BeaconEventContent) GetRelatesTo() *RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *BeaconEventContent) OptionalGetRelatesTo() *RelatesTo {
+	if content.RelatesTo.Type == "" {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *BeaconEventContent) SetRelatesTo(rel *RelatesTo) {
+	content.RelatesTo = *rel
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const beaconInfo = `{
+	"type": "org.matrix.msc3672.beacon_info",
+	"state_key": "@stefan:matrix.org",
+	"event_id": "$beacon_info",
+	"origin_server_ts": 1436829458500,
+	"content": {
+		"description": "Stefan's live location",
+		"live": true,
+		"timeout": 86400000,
+		"org.matrix.msc3488.ts": 1436829458432,
+		"org.matrix.msc3488.asset": {
+			"type": "m.self"
+		}
+	}
+}`
+
+const beaconUpdate = `{
+	"type": "org.matrix.msc3672.beacon",
+	"event_id": "$beacon",
+	"origin_server_ts": 1436829459000,
+	"content": {
+		"m.relates_to": {
+			"rel_type": "m.reference",
+			"event_id": "$beacon_info"
+		},
+		"org.matrix.msc3488.location": {
+			"uri": "geo:51.5008,0.1247;u=35",
+			"description": "Arbitrary beacon information"
+		},
+		"org.matrix.msc3488.ts": 1436829458932
+	}
+}`
+
+func TestBeaconInfoEventContent(t *testing.T) {
+	var evt *event.Event
+	err := json.Unmarshal([]byte(beaconInfo), &evt)
+	require.NoError(t, err)
+	assert.Equal(t, event.StateEventType, evt.Type.Class)
+	err = evt.Content.ParseRaw(evt.Type)
+	require.NoError(t, err)
+	info, ok := evt.Content.Parsed.(*event.BeaconInfoEventContent)
+	require.True(t, ok)
+	assert.Equal(t, event.LocationAssetSelf, info.GetAssetType())
+	start := time.UnixMilli(1436829458432)
+	assert.Equal(t, start.Add(24*time.Hour), info.ExpiresAt(time.UnixMilli(evt.Timestamp)))
+	assert.True(t, info.IsLive(start.Add(time.Hour), time.Time{}))
+	assert.False(t, info.IsLive(start.Add(25*time.Hour), time.Time{}))
+	assert.False(t, info.Stop().IsLive(start.Add(time.Hour), time.Time{}))
+	assert.True(t, info.Live, "Stop must not modify the original content")
+
+	var update *event.Event
+	err = json.Unmarshal([]byte(beaconUpdate), &update)
+	require.NoError(t, err)
+	assert.Equal(t, event.MessageEventType, update.Type.Class)
+	err = update.Content.ParseRaw(update.Type)
+	require.NoError(t, err)
+	beacon, ok := update.Content.Parsed.(*event.BeaconEventContent)
+	require.True(t, ok)
+	assert.Equal(t, evt.ID, beacon.RelatesTo.GetReferenceID())
+	assert.Equal(t, "geo:51.5008,0.1247;u=35", beacon.Location.URI)
+	assert.True(t, beacon.IsWithin(info, time.Time{}))
+}
+
+func TestBeaconInfoEventContent_ExpiresAt_Fallback(t *testing.T) {
+	info := &event.BeaconInfoEventContent{Live: true, Timeout: 60000}
+	sent := time.UnixMilli(1700000000000)
+	assert.Equal(t, sent.Add(time.Minute), info.ExpiresAt(sent))
+	assert.False(t, info.IsLive(sent.Add(2*time.Minute), sent))
+}
@@ -48,6 +48,8 @@ var TypeMap = map[Type]reflect.Type{
 	StateUnstablePolicyUser:   reflect.TypeOf(ModPolicyContent{}),
 
 	StateElementFunctionalMembers: reflect.TypeOf(ElementFunctionalMembersContent{}),
+	StateUnstableImagePack:        reflect.TypeOf(ImagePackEventContent{}),
+	StateUnstableBeaconInfo:       reflect.TypeOf(BeaconInfoEventContent{}),
 
 	EventMessage:   reflect.TypeOf(MessageEventContent{}),
 	EventSticker:   reflect.TypeOf(MessageEventContent{}),
@@ -57,6 +59,8 @@ var TypeMap = map[Type]reflect.Type{
 
 	EventUnstablePollStart:    reflect.TypeOf(PollStartEventContent{}),
 	EventUnstablePollResponse: reflect.TypeOf(PollResponseEventContent{}),
+	EventUnstablePollEnd:      reflect.TypeOf(PollEndEventContent{}),
+	EventUnstableBeacon:       reflect.TypeOf(BeaconEventContent{}),
 
 	BeeperMessageStatus: reflect.TypeOf(BeeperMessageStatusEventContent{}),
 
@@ -67,6 +71,9 @@ var TypeMap = map[Type]reflect.Type{
 	AccountDataMarkedUnread:    reflect.TypeOf(MarkedUnreadEventContent{}),
 	AccountDataBeeperMute:      reflect.TypeOf(BeeperMuteEventContent{}),
 
+	AccountDataUnstableImagePack:      reflect.TypeOf(ImagePackEventContent{}),
+	AccountDataUnstableImagePackRooms: reflect.TypeOf(ImagePackRoomsEventContent{}),
+
 	EphemeralEventTyping:   reflect.TypeOf(TypingEventContent{}),
 	EphemeralEventReceipt:  reflect.TypeOf(ReceiptEventContent{}),
 	EphemeralEventPresence: reflect.TypeOf(PresenceEventContent{}),
@@ -193,11 +200,18 @@ func (content *Content) ParseRaw(evtType Type) error {
 		return ErrContentAlreadyParsed
 	}
 	structType, ok := TypeMap[evtType]
+	if !ok {
+		structType, ok = TypeMap[DefaultPrefixTransitions.StableType(evtType)]
+	}
 	if !ok {
 		return fmt.Errorf("%w %s", ErrUnsupportedContentType, evtType.Repr())
 	}
+	data := content.VeryRaw
+	if normalized := DefaultPrefixTransitions.normalizeJSON(data); normalized != nil {
+		data = normalized
+	}
 	content.Parsed = reflect.New(structType).Interface()
-	return json.Unmarshal(content.VeryRaw, &content.Parsed)
+	return json.Unmarshal(data, &content.Parsed)
 }
 
 func mergeMaps(into, from map[string]interface{}) {
@@ -446,6 +460,20 @@ func (content *Content) AsMarkedUnread() *MarkedUnreadEventContent {
 	}
 	return casted
 }
+func (content *Content) AsImagePack() *ImagePackEventContent {
+	casted, ok := content.Parsed.(*ImagePackEventContent)
+	if !ok {
+		return &ImagePackEventContent{}
+	}
+	return casted
+}
+func (content *Content) AsImagePackRooms() *ImagePackRoomsEventContent {
+	casted, ok := content.Parsed.(*ImagePackRoomsEventContent)
+	if !ok {
+		return &ImagePackRoomsEventContent{}
+	}
+	return casted
+}
 func (content *Content) AsTyping() *TypingEventContent {
 	casted, ok := content.Parsed.(*TypingEventContent)
 	if !ok {
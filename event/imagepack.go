@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"slices"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ImagePackUsage is the usage of an image pack or a single image in a pack as defined in MSC2545.
+type ImagePackUsage string
+
+const (
+	ImagePackUsageEmoticon ImagePackUsage = "emoticon"
+	ImagePackUsageSticker  ImagePackUsage = "sticker"
+)
+
+// ImagePackEventContent represents the content of an im.ponies.room_emotes state event
+// or an im.ponies.user_emotes account data event.
+// https://github.com/matrix-org/matrix-spec-proposals/pull/2545
+type ImagePackEventContent struct {
+	Images map[string]*ImagePackImage `json:"images"`
+	Pack   ImagePackMetadata          `json:"pack"`
+}
+
+type ImagePackMetadata struct {
+	DisplayName string              `json:"display_name,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	Usage       []ImagePackUsage    `json:"usage,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+}
+
+type ImagePackImage struct {
+	URL   id.ContentURIString `json:"url"`
+	Body  string              `json:"body,omitempty"`
+	Info  *FileInfo           `json:"info,omitempty"`
+	Usage []ImagePackUsage    `json:"usage,omitempty"`
+}
+
+// HasUsage checks if the given image can be used for the given purpose.
+// If the image doesn't specify any usage, the usage of the pack is used.
+// If neither specify usage, the image can be used for anything.
+func (ipc *ImagePackEventContent) HasUsage(img *ImagePackImage, usage ImagePackUsage) bool {
+	if len(img.Usage) > 0 {
+		return slices.Contains(img.Usage, usage)
+	} else if len(ipc.Pack.Usage) > 0 {
+		return slices.Contains(ipc.Pack.Usage, usage)
+	}
+	return true
+}
+
+// Stickers returns all images in the pack that can be used as stickers.
+func (ipc *ImagePackEventContent) Stickers() map[string]*ImagePackImage {
+	stickers := make(map[string]*ImagePackImage)
+	for shortcode, img := range ipc.Images {
+		if ipc.HasUsage(img, ImagePackUsageSticker) {
+			stickers[shortcode] = img
+		}
+	}
+	return stickers
+}
+
+// ImagePackRoomsEventContent represents the content of an im.ponies.emote_rooms account data event,
+// which lists room image packs that the user has enabled globally.
+type ImagePackRoomsEventContent struct {
+	Rooms map[id.RoomID]map[string]struct{} `json:"rooms"`
+}
+
+func (iprc *ImagePackRoomsEventContent) Add(roomID id.RoomID, stateKey string) {
+	if iprc.Rooms == nil {
+		iprc.Rooms = make(map[id.RoomID]map[string]struct{})
+	}
+	if iprc.Rooms[roomID] == nil {
+		iprc.Rooms[roomID] = make(map[string]struct{})
+	}
+	iprc.Rooms[roomID][stateKey] = struct{}{}
+}
+
+func (iprc *ImagePackRoomsEventContent) Remove(roomID id.RoomID, stateKey string) {
+	delete(iprc.Rooms[roomID], stateKey)
+	if len(iprc.Rooms[roomID]) == 0 {
+		delete(iprc.Rooms, roomID)
+	}
+}
@@ -37,6 +37,16 @@ type MSC1767Message struct {
 	} `json:"org.matrix.msc1767.message,omitempty"`
 }
 
+const (
+	PollKindDisclosed   = "org.matrix.msc3381.poll.disclosed"
+	PollKindUndisclosed = "org.matrix.msc3381.poll.undisclosed"
+)
+
+type PollAnswer struct {
+	ID string `json:"id"`
+	MSC1767Message
+}
+
 type PollStartEventContent struct {
 	RelatesTo *RelatesTo `json:"m.relates_to"`
 	Mentions  *Mentions  `json:"m.mentions,omitempty"`
@@ -44,11 +54,10 @@ type PollStartEventContent struct {
 		Kind          string         `json:"kind"`
 		MaxSelections int            `json:"max_selections"`
 		Question      MSC1767Message `json:"question"`
-		Answers       []struct {
-			ID string `json:"id"`
-			MSC1767Message
-		} `json:"answers"`
+		Answers       []PollAnswer   `json:"answers"`
 	} `json:"org.matrix.msc3381.poll.start"`
+	// Text is the plaintext fallback for clients that don't support polls.
+	Text string `json:"org.matrix.msc1767.text,omitempty"`
 }
 
 func (content *PollStartEventContent) GetRelatesTo() *RelatesTo {
@@ -65,3 +74,26 @@ func (content *PollStartEventContent) OptionalGetRelatesTo() *RelatesTo {
 func (content *PollStartEventContent) SetRelatesTo(rel *RelatesTo) {
 	content.RelatesTo = rel
 }
+
+// PollEndEventContent represents the content of an event that closes a poll (MSC3381).
+type PollEndEventContent struct {
+	RelatesTo RelatesTo `json:"m.relates_to"`
+	PollEnd   struct{}  `json:"org.matrix.msc3381.poll.end"`
+	// Text is the plaintext fallback for clients that don't support polls, usually containing the results.
+	Text string `json:"org.matrix.msc1767.text,omitempty"`
+}
+
+func (content *PollEndEventContent) GetRelatesTo() *RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *PollEndEventContent) OptionalGetRelatesTo() *RelatesTo {
+	if content.RelatesTo.Type == "" {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *PollEndEventContent) SetRelatesTo(rel *RelatesTo) {
+	content.RelatesTo = *rel
+}
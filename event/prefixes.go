@@ -0,0 +1,225 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// PrefixWriteMode specifies which variants of an event type or content field should be sent
+// when an unstable identifier has a stable equivalent.
+type PrefixWriteMode int
+
+const (
+	// WriteBothPrefixes sends both the unstable and stable variants. This is the safest option
+	// while a transition is in progress, as both old and new servers/clients will understand the event.
+	WriteBothPrefixes PrefixWriteMode = iota
+	// WriteUnstablePrefix only sends the unstable variant.
+	WriteUnstablePrefix
+	// WriteStablePrefix only sends the stable variant.
+	WriteStablePrefix
+)
+
+// PrefixTransitions is a registry of unstable event types and content fields that have stable equivalents.
+//
+// Reading is always done in dual mode: unstable types are normalized to the stable type and unstable fields
+// are copied to the stable field if it's not already set. [Content.ParseRaw] does this automatically using
+// [DefaultPrefixTransitions]. Writing is controlled by the write mode, see [PrefixTransitions.SetWriteMode].
+//
+// Only register transitions where the unstable and stable variants have identical content,
+// i.e. where the identifier is the only thing that changed.
+type PrefixTransitions struct {
+	lock             sync.RWMutex
+	writeMode        PrefixWriteMode
+	unstableToStable map[string]Type
+	stableToUnstable map[string][]Type
+	unstableFields   map[string]string
+	stableFieldOrder []string
+	stableFields     map[string]string
+}
+
+// NewPrefixTransitions creates an empty prefix transition registry.
+func NewPrefixTransitions() *PrefixTransitions {
+	return &PrefixTransitions{
+		unstableToStable: make(map[string]Type),
+		stableToUnstable: make(map[string][]Type),
+		unstableFields:   make(map[string]string),
+		stableFields:     make(map[string]string),
+	}
+}
+
+// DefaultPrefixTransitions contains the transitions for event types that have been stabilized in the spec.
+var DefaultPrefixTransitions = NewPrefixTransitions().
+	AddType(StateUnstablePolicyRoom, StatePolicyRoom).
+	AddType(StateUnstablePolicyServer, StatePolicyServer).
+	AddType(StateUnstablePolicyUser, StatePolicyUser).
+	AddType(StateLegacyPolicyRoom, StatePolicyRoom).
+	AddType(StateLegacyPolicyServer, StatePolicyServer).
+	AddType(StateLegacyPolicyUser, StatePolicyUser)
+
+// SetWriteMode changes which variants of registered types and fields are written.
+func (pt *PrefixTransitions) SetWriteMode(mode PrefixWriteMode) *PrefixTransitions {
+	pt.lock.Lock()
+	pt.writeMode = mode
+	pt.lock.Unlock()
+	return pt
+}
+
+// WriteMode returns the current write mode.
+func (pt *PrefixTransitions) WriteMode() PrefixWriteMode {
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	return pt.writeMode
+}
+
+// AddType registers an unstable event type that has a stable equivalent. A stable type may have
+// multiple unstable variants, in which case the first one registered is used when writing.
+func (pt *PrefixTransitions) AddType(unstable, stable Type) *PrefixTransitions {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.unstableToStable[unstable.Type] = stable
+	pt.stableToUnstable[stable.Type] = append(pt.stableToUnstable[stable.Type], unstable)
+	return pt
+}
+
+// AddField registers an unstable content field that has a stable equivalent.
+func (pt *PrefixTransitions) AddField(unstable, stable string) *PrefixTransitions {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.unstableFields[unstable] = stable
+	if _, exists := pt.stableFields[stable]; !exists {
+		pt.stableFieldOrder = append(pt.stableFieldOrder, stable)
+		pt.stableFields[stable] = unstable
+	}
+	return pt
+}
+
+// StableType returns the stable equivalent of the given event type,
+// or the input type as-is if it isn't a registered unstable type.
+func (pt *PrefixTransitions) StableType(evtType Type) Type {
+	pt.lock.RLock()
+	stable, ok := pt.unstableToStable[evtType.Type]
+	pt.lock.RUnlock()
+	if !ok {
+		return evtType
+	}
+	return Type{Type: stable.Type, Class: evtType.Class}
+}
+
+// IsEquivalent checks if the two event types are the same after normalizing unstable types.
+func (pt *PrefixTransitions) IsEquivalent(a, b Type) bool {
+	return pt.StableType(a).Type == pt.StableType(b).Type
+}
+
+// AllTypes returns the stable type and all registered unstable variants of the given type.
+// This is useful for reading, e.g. when checking room state for any of the variants.
+func (pt *PrefixTransitions) AllTypes(evtType Type) []Type {
+	stable := pt.StableType(evtType)
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	types := []Type{stable}
+	for _, unstable := range pt.stableToUnstable[stable.Type] {
+		types = append(types, Type{Type: unstable.Type, Class: evtType.Class})
+	}
+	return types
+}
+
+// WriteTypes returns the event types that an event should be sent as according to the current write mode.
+// The input type can be either the stable or unstable variant.
+func (pt *PrefixTransitions) WriteTypes(evtType Type) []Type {
+	stable := pt.StableType(evtType)
+	pt.lock.RLock()
+	unstables := pt.stableToUnstable[stable.Type]
+	writeMode := pt.writeMode
+	pt.lock.RUnlock()
+	if len(unstables) == 0 {
+		return []Type{evtType}
+	}
+	unstable := Type{Type: unstables[0].Type, Class: evtType.Class}
+	switch writeMode {
+	case WriteUnstablePrefix:
+		return []Type{unstable}
+	case WriteStablePrefix:
+		return []Type{stable}
+	default:
+		return []Type{stable, unstable}
+	}
+}
+
+// HasFields returns true if any content fields have been registered.
+func (pt *PrefixTransitions) HasFields() bool {
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	return len(pt.unstableFields) > 0
+}
+
+// NormalizeContent copies the values of registered unstable fields into their stable equivalents
+// if the stable field isn't already set. Unstable fields are not removed.
+func (pt *PrefixTransitions) NormalizeContent(raw map[string]any) (changed bool) {
+	if raw == nil {
+		return
+	}
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	for unstable, stable := range pt.unstableFields {
+		val, hasUnstable := raw[unstable]
+		if _, hasStable := raw[stable]; hasUnstable && !hasStable {
+			raw[stable] = val
+			changed = true
+		}
+	}
+	return
+}
+
+// normalizeJSON applies NormalizeContent to the given JSON object.
+// It returns nil if nothing needed to be changed or if the data isn't an object.
+func (pt *PrefixTransitions) normalizeJSON(data json.RawMessage) json.RawMessage {
+	if !pt.HasFields() {
+		return nil
+	}
+	var raw map[string]any
+	if json.Unmarshal(data, &raw) != nil || !pt.NormalizeContent(raw) {
+		return nil
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	return normalized
+}
+
+// PrepareContentForWrite adjusts the registered fields in the given content according to the current write mode.
+// Fields may be set using either the stable or unstable key.
+func (pt *PrefixTransitions) PrepareContentForWrite(raw map[string]any) {
+	if raw == nil {
+		return
+	}
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	for _, stable := range pt.stableFieldOrder {
+		unstable := pt.stableFields[stable]
+		val, ok := raw[stable]
+		if !ok {
+			val, ok = raw[unstable]
+		}
+		if !ok {
+			continue
+		}
+		switch pt.writeMode {
+		case WriteUnstablePrefix:
+			delete(raw, stable)
+			raw[unstable] = val
+		case WriteStablePrefix:
+			delete(raw, unstable)
+			raw[stable] = val
+		default:
+			raw[stable] = val
+			raw[unstable] = val
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestPrefixTransitions_StableType(t *testing.T) {
+	pt := event.DefaultPrefixTransitions
+	assert.Equal(t, event.StatePolicyUser, pt.StableType(event.StateUnstablePolicyUser))
+	assert.Equal(t, event.StatePolicyUser, pt.StableType(event.StateLegacyPolicyUser))
+	assert.Equal(t, event.StatePolicyUser, pt.StableType(event.StatePolicyUser))
+	assert.Equal(t, event.EventMessage, pt.StableType(event.EventMessage))
+	assert.True(t, pt.IsEquivalent(event.StateUnstablePolicyRoom, event.StateLegacyPolicyRoom))
+	assert.False(t, pt.IsEquivalent(event.StateUnstablePolicyRoom, event.StatePolicyUser))
+	assert.Equal(t, []event.Type{event.StatePolicyServer, event.StateUnstablePolicyServer, event.StateLegacyPolicyServer}, pt.AllTypes(event.StateLegacyPolicyServer))
+}
+
+func TestPrefixTransitions_WriteTypes(t *testing.T) {
+	pt := event.NewPrefixTransitions().AddType(event.StateUnstablePolicyRoom, event.StatePolicyRoom)
+	assert.Equal(t, []event.Type{event.StatePolicyRoom, event.StateUnstablePolicyRoom}, pt.WriteTypes(event.StatePolicyRoom))
+	pt.SetWriteMode(event.WriteStablePrefix)
+	assert.Equal(t, []event.Type{event.StatePolicyRoom}, pt.WriteTypes(event.StateUnstablePolicyRoom))
+	pt.SetWriteMode(event.WriteUnstablePrefix)
+	assert.Equal(t, []event.Type{event.StateUnstablePolicyRoom}, pt.WriteTypes(event.StatePolicyRoom))
+	assert.Equal(t, []event.Type{event.EventMessage}, pt.WriteTypes(event.EventMessage))
+}
+
+func TestPrefixTransitions_Content(t *testing.T) {
+	pt := event.NewPrefixTransitions().AddField("org.example.msc1234.field", "m.field")
+
+	raw := map[string]any{"org.example.msc1234.field": "meow"}
+	pt.NormalizeContent(raw)
+	assert.Equal(t, "meow", raw["m.field"])
+
+	raw = map[string]any{"org.example.msc1234.field": "old", "m.field": "new"}
+	pt.NormalizeContent(raw)
+	assert.Equal(t, "new", raw["m.field"])
+
+	raw = map[string]any{"m.field": "meow"}
+	pt.PrepareContentForWrite(raw)
+	assert.Equal(t, map[string]any{"m.field": "meow", "org.example.msc1234.field": "meow"}, raw)
+
+	pt.SetWriteMode(event.WriteStablePrefix)
+	raw = map[string]any{"org.example.msc1234.field": "meow"}
+	pt.PrepareContentForWrite(raw)
+	assert.Equal(t, map[string]any{"m.field": "meow"}, raw)
+
+	pt.SetWriteMode(event.WriteUnstablePrefix)
+	raw = map[string]any{"m.field": "meow"}
+	pt.PrepareContentForWrite(raw)
+	assert.Equal(t, map[string]any{"org.example.msc1234.field": "meow"}, raw)
+}
+
+func TestContent_ParseRaw_PrefixTransitions(t *testing.T) {
+	unstableType := event.Type{Type: "org.example.msc1234.rule.user", Class: event.StateEventType}
+	origTransitions := event.DefaultPrefixTransitions
+	t.Cleanup(func() {
+		event.DefaultPrefixTransitions = origTransitions
+	})
+	event.DefaultPrefixTransitions = event.NewPrefixTransitions().AddType(unstableType, event.StatePolicyUser)
+
+	content := event.Content{VeryRaw: []byte(`{"entity":"@evil:example.com","recommendation":"m.ban","reason":"spam"}`)}
+	require.NoError(t, content.ParseRaw(unstableType))
+	assert.Equal(t, "@evil:example.com", content.AsModPolicy().Entity)
+	assert.Equal(t, "spam", content.AsModPolicy().Reason)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pdu
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrUnsupportedRoomVersion = errors.New("unsupported room version")
+	ErrContentHashNotFound    = errors.New("event doesn't contain a sha256 content hash")
+	ErrContentHashMismatch    = errors.New("content hash doesn't match event")
+)
+
+func deleteKeys(evt json.RawMessage, keys ...string) (json.RawMessage, error) {
+	var err error
+	for _, key := range keys {
+		evt, err = sjson.DeleteBytes(evt, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	return evt, nil
+}
+
+// ContentHash calculates the sha256 [content hash] of the given event.
+//
+// [content hash]: https://spec.matrix.org/v1.12/server-server-api/#calculating-the-content-hash-for-an-event
+func ContentHash(evt json.RawMessage) ([32]byte, error) {
+	stripped, err := deleteKeys(evt, "unsigned", "signatures", "hashes")
+	if err != nil {
+		return [32]byte{}, err
+	}
+	canonical, err := canonicaljson.CanonicalJSON(stripped)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(canonical), nil
+}
+
+// AddContentHash calculates the content hash of the given event and stores it in the hashes.sha256 field.
+func AddContentHash(evt json.RawMessage) (json.RawMessage, error) {
+	hash, err := ContentHash(evt)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(evt, "hashes.sha256", base64.RawStdEncoding.EncodeToString(hash[:]))
+}
+
+// VerifyContentHash checks that the hashes.sha256 field in the given event matches the content.
+func VerifyContentHash(evt json.RawMessage) error {
+	expected := gjson.GetBytes(evt, "hashes.sha256")
+	if !expected.Exists() || expected.Type != gjson.String {
+		return ErrContentHashNotFound
+	}
+	expectedBytes, err := base64.RawStdEncoding.DecodeString(expected.Str)
+	if err != nil {
+		return fmt.Errorf("failed to decode content hash: %w", err)
+	}
+	hash, err := ContentHash(evt)
+	if err != nil {
+		return err
+	} else if subtle.ConstantTimeCompare(hash[:], expectedBytes) != 1 {
+		return ErrContentHashMismatch
+	}
+	return nil
+}
+
+// ReferenceHash calculates the sha256 [reference hash] of the given event.
+//
+// [reference hash]: https://spec.matrix.org/v1.12/server-server-api/#calculating-the-reference-hash-for-an-event
+func ReferenceHash(roomVersion event.RoomVersion, evt json.RawMessage) ([32]byte, error) {
+	redacted, err := Redact(roomVersion, evt)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	redacted, err = deleteKeys(redacted, "unsigned", "signatures")
+	if err != nil {
+		return [32]byte{}, err
+	}
+	canonical, err := canonicaljson.CanonicalJSON(redacted)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(canonical), nil
+}
+
+// CalculateEventID calculates the event ID of the given event based on its reference hash.
+//
+// Room versions 1 and 2 don't use hash-based event IDs, so an error is returned for them.
+func CalculateEventID(roomVersion event.RoomVersion, evt json.RawMessage) (id.EventID, error) {
+	version, err := roomVersionNumber(roomVersion)
+	if err != nil {
+		return "", err
+	} else if version < 3 {
+		return "", fmt.Errorf("%w: room version %s doesn't have hash-based event IDs", ErrUnsupportedRoomVersion, roomVersion)
+	}
+	hash, err := ReferenceHash(roomVersion, evt)
+	if err != nil {
+		return "", err
+	}
+	if version == 3 {
+		return id.EventID("$" + base64.RawStdEncoding.EncodeToString(hash[:])), nil
+	}
+	return id.EventID("$" + base64.RawURLEncoding.EncodeToString(hash[:])), nil
+}
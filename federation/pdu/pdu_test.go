@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pdu_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/federation/pdu"
+	"maunium.net/go/mautrix/id"
+)
+
+// Example key and event from https://spec.matrix.org/v1.12/appendices/#signing-details
+const specKeySeed = "YJDBA9Xnr2sVqXD9Vj7XVUnmFZcZrlw8Md7kMW+3XA1"
+const specKeyID id.KeyID = "ed25519:1"
+const specEvent = `{
+	"room_id": "!x:domain",
+	"sender": "@a:domain",
+	"origin": "domain",
+	"origin_server_ts": 1000000,
+	"signatures": {},
+	"hashes": {},
+	"type": "X",
+	"content": {},
+	"prev_events": [],
+	"auth_events": [],
+	"depth": 3,
+	"unsigned": {
+		"age_ts": 1000000
+	}
+}`
+
+func getSpecKey(t *testing.T) ed25519.PrivateKey {
+	seed, err := base64.RawStdEncoding.DecodeString(specKeySeed)
+	require.NoError(t, err)
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func TestSign_SpecExample(t *testing.T) {
+	evt, err := pdu.AddContentHash(json.RawMessage(specEvent))
+	require.NoError(t, err)
+	assert.Equal(t, "5jM4wQpv6lnBo7CLIghJuHdW+s2CMBJPUOGOC89ncos", gjson.GetBytes(evt, "hashes.sha256").Str)
+	evt, err = pdu.Sign(event.RoomV1, evt, "domain", specKeyID, getSpecKey(t))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"KxwGjPSDEtvnFgU00fwFz+l6d2pJM6XBIaMEn81SXPTRl16AqLAYqfIReFGZlHi5KLjAWbOoMszkwsQma+lYAg",
+		gjson.GetBytes(evt, "signatures.domain.ed25519:1").Str,
+	)
+}
+
+func TestVerifySignature(t *testing.T) {
+	key := getSpecKey(t)
+	evt, err := pdu.AddContentHash(json.RawMessage(specEvent))
+	require.NoError(t, err)
+	evt, err = pdu.Sign(event.RoomV11, evt, "domain", specKeyID, key)
+	require.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+	assert.NoError(t, pdu.VerifyContentHash(evt))
+	assert.NoError(t, pdu.VerifySignature(event.RoomV11, evt, "domain", specKeyID, pub))
+	assert.ErrorIs(t, pdu.VerifySignature(event.RoomV11, evt, "other", specKeyID, pub), pdu.ErrSignatureNotFound)
+
+	// Changing the content breaks the content hash but not the signature, as signatures cover the redacted event
+	modified := json.RawMessage(`{"body": "meow"}`)
+	var parsed map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(evt, &parsed))
+	parsed["content"] = modified
+	evt, err = json.Marshal(parsed)
+	require.NoError(t, err)
+	assert.ErrorIs(t, pdu.VerifyContentHash(evt), pdu.ErrContentHashMismatch)
+	assert.NoError(t, pdu.VerifySignature(event.RoomV11, evt, "domain", specKeyID, pub))
+
+	parsed["sender"] = json.RawMessage(`"@b:domain"`)
+	evt, err = json.Marshal(parsed)
+	require.NoError(t, err)
+	assert.ErrorIs(t, pdu.VerifySignature(event.RoomV11, evt, "domain", specKeyID, pub), pdu.ErrInvalidSignature)
+}
+
+func TestRedact(t *testing.T) {
+	const memberEvent = `{
+		"type": "m.room.member",
+		"state_key": "@a:domain",
+		"origin": "domain",
+		"membership": "join",
+		"content": {"membership": "join", "displayname": "A", "join_authorised_via_users_server": "@b:domain"},
+		"unsigned": {"age": 5}
+	}`
+	redacted, err := pdu.Redact(event.RoomV8, json.RawMessage(memberEvent))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "m.room.member",
+		"state_key": "@a:domain",
+		"origin": "domain",
+		"membership": "join",
+		"content": {"membership": "join"}
+	}`, string(redacted))
+
+	redacted, err = pdu.Redact(event.RoomV11, json.RawMessage(memberEvent))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "m.room.member",
+		"state_key": "@a:domain",
+		"content": {"membership": "join", "join_authorised_via_users_server": "@b:domain"}
+	}`, string(redacted))
+
+	const createEvent = `{"type": "m.room.create", "content": {"creator": "@a:domain", "room_version": "11"}}`
+	redacted, err = pdu.Redact(event.RoomV10, json.RawMessage(createEvent))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "m.room.create", "content": {"creator": "@a:domain"}}`, string(redacted))
+	redacted, err = pdu.Redact(event.RoomV11, json.RawMessage(createEvent))
+	require.NoError(t, err)
+	assert.JSONEq(t, createEvent, string(redacted))
+
+	_, err = pdu.Redact("meow", json.RawMessage(createEvent))
+	assert.ErrorIs(t, err, pdu.ErrUnsupportedRoomVersion)
+}
+
+func TestCalculateEventID(t *testing.T) {
+	evtV3, err := pdu.CalculateEventID(event.RoomV3, json.RawMessage(specEvent))
+	require.NoError(t, err)
+	evtV4, err := pdu.CalculateEventID(event.RoomV4, json.RawMessage(specEvent))
+	require.NoError(t, err)
+	hash, err := pdu.ReferenceHash(event.RoomV4, json.RawMessage(specEvent))
+	require.NoError(t, err)
+	assert.Equal(t, id.EventID("$"+base64.RawStdEncoding.EncodeToString(hash[:])), evtV3)
+	assert.Equal(t, id.EventID("$"+base64.RawURLEncoding.EncodeToString(hash[:])), evtV4)
+	_, err = pdu.CalculateEventID(event.RoomV1, json.RawMessage(specEvent))
+	assert.ErrorIs(t, err, pdu.ErrUnsupportedRoomVersion)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package pdu contains helpers for hashing, signing and verifying federation events (PDUs)
+// as described in the [signing events] section of the server-server API spec.
+//
+// [signing events]: https://spec.matrix.org/v1.12/server-server-api/#signing-events
+package pdu
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+)
+
+var keptTopLevelKeysV1 = []string{
+	"event_id", "type", "room_id", "sender", "state_key", "content", "hashes", "signatures",
+	"depth", "prev_events", "prev_state", "auth_events", "origin", "origin_server_ts", "membership",
+}
+
+var keptTopLevelKeysV11 = []string{
+	"event_id", "type", "room_id", "sender", "state_key", "content", "hashes", "signatures",
+	"depth", "prev_events", "auth_events", "origin_server_ts",
+}
+
+func roomVersionNumber(roomVersion event.RoomVersion) (int, error) {
+	switch roomVersion {
+	case event.RoomV1:
+		return 1, nil
+	case event.RoomV2:
+		return 2, nil
+	case event.RoomV3:
+		return 3, nil
+	case event.RoomV4:
+		return 4, nil
+	case event.RoomV5:
+		return 5, nil
+	case event.RoomV6:
+		return 6, nil
+	case event.RoomV7:
+		return 7, nil
+	case event.RoomV8:
+		return 8, nil
+	case event.RoomV9:
+		return 9, nil
+	case event.RoomV10:
+		return 10, nil
+	case event.RoomV11:
+		return 11, nil
+	default:
+		return 0, fmt.Errorf("%w %q", ErrUnsupportedRoomVersion, roomVersion)
+	}
+}
+
+func keptContentKeys(evtType string, version int) (keys []string, keepAll bool) {
+	switch evtType {
+	case event.StateMember.Type:
+		keys = []string{"membership"}
+		if version >= 9 {
+			keys = append(keys, "join_authorised_via_users_server")
+		}
+		if version >= 11 {
+			keys = append(keys, "third_party_invite")
+		}
+	case event.StateCreate.Type:
+		if version >= 11 {
+			return nil, true
+		}
+		keys = []string{"creator"}
+	case event.StateJoinRules.Type:
+		keys = []string{"join_rule"}
+		if version >= 8 {
+			keys = append(keys, "allow")
+		}
+	case event.StatePowerLevels.Type:
+		keys = []string{"ban", "events", "events_default", "kick", "redact", "state_default", "users", "users_default"}
+		if version >= 11 {
+			keys = append(keys, "invite")
+		}
+	case event.StateAliases.Type:
+		if version < 6 {
+			keys = []string{"aliases"}
+		}
+	case event.StateHistoryVisibility.Type:
+		keys = []string{"history_visibility"}
+	case event.EventRedaction.Type:
+		if version >= 11 {
+			keys = []string{"redacts"}
+		}
+	}
+	return
+}
+
+// Redact applies the [redaction algorithm] of the given room version to the given event JSON.
+//
+// [redaction algorithm]: https://spec.matrix.org/v1.12/client-server-api/#redactions
+func Redact(roomVersion event.RoomVersion, evt json.RawMessage) (json.RawMessage, error) {
+	version, err := roomVersionNumber(roomVersion)
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]json.RawMessage
+	err = json.Unmarshal(evt, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	var evtType string
+	if rawType, ok := parsed["type"]; ok {
+		err = json.Unmarshal(rawType, &evtType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse event type: %w", err)
+		}
+	}
+	keptTopLevelKeys := keptTopLevelKeysV1
+	if version >= 11 {
+		keptTopLevelKeys = keptTopLevelKeysV11
+	}
+	for key := range parsed {
+		if !slices.Contains(keptTopLevelKeys, key) {
+			delete(parsed, key)
+		}
+	}
+	keptKeys, keepAll := keptContentKeys(evtType, version)
+	if !keepAll {
+		var content map[string]json.RawMessage
+		if rawContent, ok := parsed["content"]; ok {
+			err = json.Unmarshal(rawContent, &content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse event content: %w", err)
+			}
+		}
+		redactedContent := make(map[string]json.RawMessage, len(keptKeys))
+		for _, key := range keptKeys {
+			if val, ok := content[key]; ok {
+				redactedContent[key] = val
+			}
+		}
+		if version >= 11 && evtType == event.StateMember.Type {
+			if tpi, ok := redactedContent["third_party_invite"]; ok {
+				redactedContent["third_party_invite"], err = redactThirdPartyInvite(tpi)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		parsed["content"], err = json.Marshal(redactedContent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(parsed)
+}
+
+func redactThirdPartyInvite(tpi json.RawMessage) (json.RawMessage, error) {
+	var parsed map[string]json.RawMessage
+	err := json.Unmarshal(tpi, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse third_party_invite: %w", err)
+	}
+	redacted := make(map[string]json.RawMessage, 1)
+	if signed, ok := parsed["signed"]; ok {
+		redacted["signed"] = signed
+	}
+	return json.Marshal(redacted)
+}
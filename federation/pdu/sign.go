@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pdu
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exgjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrSignatureNotFound = errors.New("event doesn't contain a signature from the specified key")
+	ErrInvalidSignature  = errors.New("event signature is invalid")
+)
+
+func signableJSON(roomVersion event.RoomVersion, evt json.RawMessage) ([]byte, error) {
+	redacted, err := Redact(roomVersion, evt)
+	if err != nil {
+		return nil, err
+	}
+	redacted, err = deleteKeys(redacted, "unsigned", "signatures")
+	if err != nil {
+		return nil, err
+	}
+	return canonicaljson.CanonicalJSON(redacted)
+}
+
+// Sign signs the redacted form of the given event with the given key and adds the signature
+// to the signatures object. The content hash should be added with [AddContentHash] before signing.
+func Sign(roomVersion event.RoomVersion, evt json.RawMessage, serverName string, keyID id.KeyID, key ed25519.PrivateKey) (json.RawMessage, error) {
+	signable, err := signableJSON(roomVersion, evt)
+	if err != nil {
+		return nil, err
+	}
+	signature := ed25519.Sign(key, signable)
+	return sjson.SetBytes(evt, exgjson.Path("signatures", serverName, string(keyID)), base64.RawStdEncoding.EncodeToString(signature))
+}
+
+// VerifySignature verifies the signature of the given server and key in the given event.
+func VerifySignature(roomVersion event.RoomVersion, evt json.RawMessage, serverName string, keyID id.KeyID, key ed25519.PublicKey) error {
+	sig := gjson.GetBytes(evt, exgjson.Path("signatures", serverName, string(keyID)))
+	if !sig.Exists() || sig.Type != gjson.String {
+		return ErrSignatureNotFound
+	}
+	sigBytes, err := base64.RawStdEncoding.DecodeString(sig.Str)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	signable, err := signableJSON(roomVersion, evt)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, signable, sigBytes) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format/mdext"
+)
+
+// MarkdownDialect is a flavor of Markdown used by a remote network. Bridges can use [RenderMarkdownDialect]
+// to convert remote message bodies into Matrix HTML instead of writing a converter for each network.
+type MarkdownDialect string
+
+const (
+	// DialectCommonMark is standard CommonMark with the same extensions as [RenderMarkdown], but without raw HTML.
+	DialectCommonMark MarkdownDialect = "commonmark"
+	// DialectWhatsApp is the formatting used by WhatsApp: _italic_, *bold*, ~strikethrough~, `code` and ```monospace```
+	// as well as quotes and lists. Links, headings and other Markdown features are not supported.
+	DialectWhatsApp MarkdownDialect = "whatsapp"
+	// DialectDiscord is the Markdown subset used by Discord: *italic*, **bold**, __underline__, ~~strikethrough~~,
+	// ||spoilers||, code, quotes, lists, headings and masked links.
+	DialectDiscord MarkdownDialect = "discord"
+)
+
+// WhatsAppMarkdown is the renderer used for [DialectWhatsApp].
+var WhatsAppMarkdown = goldmark.New(
+	goldmark.WithParser(mdext.ParserWithoutFeatures(
+		parser.NewEmphasisParser(), parser.NewLinkParser(), parser.NewAutoLinkParser(), parser.NewRawHTMLParser(),
+		parser.NewATXHeadingParser(), parser.NewSetextHeadingParser(), parser.NewThematicBreakParser(),
+		parser.NewHTMLBlockParser(), parser.LinkReferenceParagraphTransformer,
+	)),
+	goldmark.WithExtensions(mdext.ShortEmphasis, mdext.ShortStrike, mdext.EscapeHTML),
+	HTMLOptions,
+)
+
+// DiscordMarkdown is the renderer used for [DialectDiscord].
+var DiscordMarkdown = goldmark.New(
+	goldmark.WithParser(mdext.ParserWithoutFeatures(
+		parser.NewRawHTMLParser(), parser.NewSetextHeadingParser(), parser.NewThematicBreakParser(),
+		parser.NewHTMLBlockParser(), parser.LinkReferenceParagraphTransformer,
+	)),
+	goldmark.WithExtensions(extension.Strikethrough, mdext.SimpleSpoiler, mdext.DiscordUnderline, mdext.EscapeHTML),
+	HTMLOptions,
+)
+
+// Renderer returns the Markdown renderer for the dialect. Unknown dialects fall back to [DialectCommonMark].
+func (dialect MarkdownDialect) Renderer() goldmark.Markdown {
+	switch dialect {
+	case DialectWhatsApp:
+		return WhatsAppMarkdown
+	case DialectDiscord:
+		return DiscordMarkdown
+	default:
+		return noHTML
+	}
+}
+
+// RenderMarkdownDialect renders the given text using the given Markdown dialect.
+// Raw HTML in the text is always escaped.
+func RenderMarkdownDialect(text string, dialect MarkdownDialect) event.MessageEventContent {
+	return RenderMarkdownCustom(text, dialect.Renderer())
+}
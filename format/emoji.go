@@ -0,0 +1,205 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rivo/uniseg"
+	"go.mau.fi/util/variationselector"
+)
+
+type emojiShortcodes struct {
+	Emoji      string
+	Shortcodes []string
+}
+
+// emojiShortcodeList contains shortcodes for commonly used emojis. The first shortcode of each emoji is the
+// canonical one returned by [EmojiToShortcode]. Additional shortcodes can be added with [AddEmojiShortcode].
+var emojiShortcodeList = []emojiShortcodes{
+	{"😀", []string{"grinning"}},
+	{"😃", []string{"smiley"}},
+	{"😄", []string{"smile"}},
+	{"😁", []string{"grin"}},
+	{"😆", []string{"laughing", "satisfied"}},
+	{"😅", []string{"sweat_smile"}},
+	{"🤣", []string{"rofl", "rolling_on_the_floor_laughing"}},
+	{"😂", []string{"joy"}},
+	{"🙂", []string{"slightly_smiling_face"}},
+	{"🙃", []string{"upside_down_face"}},
+	{"😉", []string{"wink"}},
+	{"😊", []string{"blush"}},
+	{"😇", []string{"innocent"}},
+	{"🥰", []string{"smiling_face_with_three_hearts"}},
+	{"😍", []string{"heart_eyes"}},
+	{"😘", []string{"kissing_heart"}},
+	{"😋", []string{"yum"}},
+	{"😛", []string{"stuck_out_tongue"}},
+	{"😜", []string{"stuck_out_tongue_winking_eye"}},
+	{"🤔", []string{"thinking", "thinking_face"}},
+	{"🤨", []string{"raised_eyebrow"}},
+	{"😐", []string{"neutral_face"}},
+	{"😑", []string{"expressionless"}},
+	{"😶", []string{"no_mouth"}},
+	{"🙄", []string{"roll_eyes"}},
+	{"😏", []string{"smirk"}},
+	{"😬", []string{"grimacing"}},
+	{"😌", []string{"relieved"}},
+	{"😔", []string{"pensive"}},
+	{"😴", []string{"sleeping"}},
+	{"😎", []string{"sunglasses"}},
+	{"🤓", []string{"nerd_face"}},
+	{"😕", []string{"confused"}},
+	{"😟", []string{"worried"}},
+	{"😮", []string{"open_mouth"}},
+	{"😲", []string{"astonished"}},
+	{"😳", []string{"flushed"}},
+	{"🥺", []string{"pleading_face"}},
+	{"😢", []string{"cry"}},
+	{"😭", []string{"sob"}},
+	{"😱", []string{"scream"}},
+	{"😡", []string{"rage", "pout"}},
+	{"😠", []string{"angry"}},
+	{"🤯", []string{"exploding_head"}},
+	{"🥳", []string{"partying_face"}},
+	{"🤗", []string{"hugs", "hugging_face"}},
+	{"🤦", []string{"facepalm"}},
+	{"🤷", []string{"shrug"}},
+	{"💀", []string{"skull"}},
+	{"💩", []string{"poop", "hankey", "shit"}},
+	{"👍", []string{"+1", "thumbsup"}},
+	{"👎", []string{"-1", "thumbsdown"}},
+	{"👌", []string{"ok_hand"}},
+	{"✌️", []string{"v", "victory_hand"}},
+	{"🤞", []string{"crossed_fingers"}},
+	{"👋", []string{"wave"}},
+	{"👏", []string{"clap"}},
+	{"🙌", []string{"raised_hands"}},
+	{"🙏", []string{"pray"}},
+	{"💪", []string{"muscle"}},
+	{"👀", []string{"eyes"}},
+	{"❤️", []string{"heart"}},
+	{"🧡", []string{"orange_heart"}},
+	{"💛", []string{"yellow_heart"}},
+	{"💚", []string{"green_heart"}},
+	{"💙", []string{"blue_heart"}},
+	{"💜", []string{"purple_heart"}},
+	{"🖤", []string{"black_heart"}},
+	{"💔", []string{"broken_heart"}},
+	{"💯", []string{"100"}},
+	{"🔥", []string{"fire"}},
+	{"✨", []string{"sparkles"}},
+	{"⭐", []string{"star"}},
+	{"🎉", []string{"tada"}},
+	{"🎊", []string{"confetti_ball"}},
+	{"🚀", []string{"rocket"}},
+	{"✅", []string{"white_check_mark"}},
+	{"✔️", []string{"heavy_check_mark"}},
+	{"❌", []string{"x"}},
+	{"❓", []string{"question"}},
+	{"❗", []string{"exclamation", "heavy_exclamation_mark"}},
+	{"⚠️", []string{"warning"}},
+	{"🆗", []string{"ok"}},
+	{"👉", []string{"point_right"}},
+	{"👈", []string{"point_left"}},
+	{"👆", []string{"point_up_2"}},
+	{"👇", []string{"point_down"}},
+	{"🐛", []string{"bug"}},
+	{"🐱", []string{"cat"}},
+	{"🐶", []string{"dog"}},
+	{"☕", []string{"coffee"}},
+	{"🍕", []string{"pizza"}},
+	{"🍺", []string{"beer"}},
+	{"🤖", []string{"robot"}},
+}
+
+var (
+	shortcodeToEmoji map[string]string
+	emojiToShortcode map[string]string
+	emojiMapsLock    sync.RWMutex
+	emojiMapsInit    sync.Once
+)
+
+var shortcodeRegex = regexp.MustCompile(`:([a-z0-9_+\-]+):`)
+
+func initEmojiMaps() {
+	shortcodeToEmoji = make(map[string]string)
+	emojiToShortcode = make(map[string]string, len(emojiShortcodeList))
+	for _, item := range emojiShortcodeList {
+		unlockedAddEmojiShortcode(item.Emoji, item.Shortcodes...)
+	}
+}
+
+func unlockedAddEmojiShortcode(emoji string, shortcodes ...string) {
+	emoji = variationselector.FullyQualify(emoji)
+	for _, shortcode := range shortcodes {
+		shortcodeToEmoji[strings.Trim(shortcode, ":")] = emoji
+	}
+	if _, exists := emojiToShortcode[variationselector.Remove(emoji)]; !exists && len(shortcodes) > 0 {
+		emojiToShortcode[variationselector.Remove(emoji)] = strings.Trim(shortcodes[0], ":")
+	}
+}
+
+// AddEmojiShortcode registers additional shortcodes for the given emoji.
+// If the emoji didn't have any shortcodes before, the first given shortcode becomes its canonical shortcode.
+func AddEmojiShortcode(emoji string, shortcodes ...string) {
+	emojiMapsInit.Do(initEmojiMaps)
+	emojiMapsLock.Lock()
+	defer emojiMapsLock.Unlock()
+	unlockedAddEmojiShortcode(emoji, shortcodes...)
+}
+
+// ShortcodeToEmoji returns the unicode emoji for the given shortcode, which may be wrapped in colons.
+// If the shortcode isn't known, an empty string is returned.
+func ShortcodeToEmoji(shortcode string) string {
+	emojiMapsInit.Do(initEmojiMaps)
+	emojiMapsLock.RLock()
+	defer emojiMapsLock.RUnlock()
+	return shortcodeToEmoji[strings.Trim(shortcode, ":")]
+}
+
+// EmojiToShortcode returns the canonical shortcode of the given emoji without colons.
+// Variation selectors are ignored when looking up the emoji. If the emoji isn't known, an empty string is returned.
+func EmojiToShortcode(emoji string) string {
+	emojiMapsInit.Do(initEmojiMaps)
+	emojiMapsLock.RLock()
+	defer emojiMapsLock.RUnlock()
+	return emojiToShortcode[variationselector.Remove(emoji)]
+}
+
+// ReplaceShortcodes replaces all known :shortcodes: in the given text with unicode emojis.
+// Unknown shortcodes are left as-is.
+func ReplaceShortcodes(text string) string {
+	if !strings.ContainsRune(text, ':') {
+		return text
+	}
+	return shortcodeRegex.ReplaceAllStringFunc(text, func(match string) string {
+		if emoji := ShortcodeToEmoji(match); emoji != "" {
+			return emoji
+		}
+		return match
+	})
+}
+
+// NormalizeReactionKey adds emoji variation selectors to the given reaction key,
+// which is the form used for reaction keys on Matrix.
+func NormalizeReactionKey(key string) string {
+	return variationselector.Add(key)
+}
+
+// EmojiEqual checks if the two strings are the same emoji, ignoring variation selectors (e.g. ❤ and ❤️ are equal).
+func EmojiEqual(a, b string) bool {
+	return variationselector.Remove(a) == variationselector.Remove(b)
+}
+
+// GraphemeCount returns the number of user-perceived characters in the given string.
+// For example, multi-codepoint emojis like 👨‍👩‍👧 and 🏳️‍🌈 count as one.
+func GraphemeCount(text string) int {
+	return uniseg.GraphemeClusterCount(text)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/format"
+)
+
+func TestShortcodeToEmoji(t *testing.T) {
+	assert.Equal(t, "👍", format.ShortcodeToEmoji(":+1:"))
+	assert.Equal(t, "👍", format.ShortcodeToEmoji("thumbsup"))
+	assert.Equal(t, "❤️", format.ShortcodeToEmoji(":heart:"))
+	assert.Equal(t, "", format.ShortcodeToEmoji(":not_an_emoji:"))
+}
+
+func TestEmojiToShortcode(t *testing.T) {
+	assert.Equal(t, "+1", format.EmojiToShortcode("👍"))
+	assert.Equal(t, "heart", format.EmojiToShortcode("❤️"))
+	assert.Equal(t, "heart", format.EmojiToShortcode("❤"))
+	assert.Equal(t, "", format.EmojiToShortcode("🦩"))
+}
+
+func TestAddEmojiShortcode(t *testing.T) {
+	format.AddEmojiShortcode("🦩", "flamingo")
+	assert.Equal(t, "🦩", format.ShortcodeToEmoji(":flamingo:"))
+	assert.Equal(t, "flamingo", format.EmojiToShortcode("🦩"))
+	format.AddEmojiShortcode("👍", "like")
+	assert.Equal(t, "👍", format.ShortcodeToEmoji("like"))
+	assert.Equal(t, "+1", format.EmojiToShortcode("👍"))
+}
+
+func TestReplaceShortcodes(t *testing.T) {
+	assert.Equal(t, "hello 👋 ❤️!", format.ReplaceShortcodes("hello :wave: :heart:!"))
+	assert.Equal(t, "time is 12:30:45", format.ReplaceShortcodes("time is 12:30:45"))
+	assert.Equal(t, ":unknown: 🔥", format.ReplaceShortcodes(":unknown: :fire:"))
+}
+
+func TestEmojiEqual(t *testing.T) {
+	assert.True(t, format.EmojiEqual("❤", "❤️"))
+	assert.True(t, format.EmojiEqual(format.NormalizeReactionKey("❤"), "❤️"))
+	assert.False(t, format.EmojiEqual("❤️", "💙"))
+}
+
+func TestGraphemeCount(t *testing.T) {
+	assert.Equal(t, 1, format.GraphemeCount("👨‍👩‍👧"))
+	assert.Equal(t, 1, format.GraphemeCount("🏳️‍🌈"))
+	assert.Equal(t, 3, format.GraphemeCount("a❤️b"))
+}
@@ -8,6 +8,7 @@ package format
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/yuin/goldmark"
@@ -27,6 +28,14 @@ var HTMLOptions = goldmark.WithRendererOptions(html.WithHardWraps(), html.WithUn
 var withHTML = goldmark.New(Extensions, HTMLOptions)
 var noHTML = goldmark.New(Extensions, HTMLOptions, goldmark.WithExtensions(mdext.EscapeHTML))
 
+var mdEscapeRegex = regexp.MustCompile("([\\\\`*_~|\\[\\]()<>#])")
+
+// EscapeMarkdown escapes characters in the given text so that it's rendered as-is
+// when included in markdown passed to [RenderMarkdown].
+func EscapeMarkdown(text string) string {
+	return mdEscapeRegex.ReplaceAllString(text, "\\$1")
+}
+
 // UnwrapSingleParagraph removes paragraph tags surrounding a string if the string only contains a single paragraph.
 func UnwrapSingleParagraph(html string) string {
 	html = strings.TrimRight(html, "\n")
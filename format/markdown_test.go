@@ -158,3 +158,45 @@ func TestRenderMarkdown_DiscordUnderline(t *testing.T) {
 		assert.Equal(t, html, strings.ReplaceAll(rendered, "\n", ""))
 	}
 }
+
+var whatsAppDialectTests = map[string]string{
+	"_italic_ *bold* ~strike~":    "<em>italic</em> <strong>bold</strong> <del>strike</del>",
+	"`code`":                      "<code>code</code>",
+	"```\nmono\n```":              "<pre><code>mono</code></pre>",
+	"# not a heading":             "# not a heading",
+	"[not a link](https://x.com)": "[not a link](https://x.com)",
+	"<b>not html</b>":             "&lt;b&gt;not html&lt;/b&gt;",
+	"> quote":                     "<blockquote><p>quote</p></blockquote>",
+	"snake_case_name":             "snake_case_name",
+	"*bold _and italic_*":         "<strong>bold <em>and italic</em></strong>",
+}
+
+func TestRenderMarkdownDialect_WhatsApp(t *testing.T) {
+	for markdown, html := range whatsAppDialectTests {
+		rendered := format.UnwrapSingleParagraph(render(format.DialectWhatsApp.Renderer(), markdown))
+		assert.Equal(t, html, strings.ReplaceAll(rendered, "\n", ""), markdown)
+	}
+}
+
+var discordDialectTests = map[string]string{
+	"*italic* **bold** __underline__": "<em>italic</em> <strong>bold</strong> <u>underline</u>",
+	"~~strike~~ ||spoiler||":          "<del>strike</del> <span data-mx-spoiler>spoiler</span>",
+	"# heading":                       "<h1>heading</h1>",
+	"[link](https://example.com)":     "<a href=\"https://example.com\">link</a>",
+	"<b>not html</b>":                 "&lt;b&gt;not html&lt;/b&gt;",
+	"---":                             "---",
+}
+
+func TestRenderMarkdownDialect_Discord(t *testing.T) {
+	for markdown, html := range discordDialectTests {
+		rendered := format.UnwrapSingleParagraph(render(format.DialectDiscord.Renderer(), markdown))
+		assert.Equal(t, html, strings.ReplaceAll(rendered, "\n", ""), markdown)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	text := "**not bold** _or_ `code` [link](https://example.com) <b>tag</b> ~~strike~~ ||spoiler|| # \\"
+	content := format.RenderMarkdown(format.EscapeMarkdown(text), true, false)
+	assert.Equal(t, text, content.Body)
+	assert.NotContains(t, content.FormattedBody, "<", "escaped text shouldn't produce any HTML tags")
+}
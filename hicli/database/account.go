@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"go.mau.fi/util/dbutil"
 
@@ -17,20 +18,34 @@ import (
 )
 
 const (
-	getAccountQuery    = `SELECT user_id, device_id, access_token, homeserver_url, next_batch FROM account WHERE user_id = $1`
-	putNextBatchQuery  = `UPDATE account SET next_batch = $1 WHERE user_id = $2`
-	upsertAccountQuery = `
-		INSERT INTO account (user_id, device_id, access_token, homeserver_url, next_batch)
-		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id)
+	getAccountQuery = `
+		SELECT user_id, device_id, access_token, homeserver_url, next_batch, sliding_sync_pos, to_device_since,
+		       refresh_token, token_endpoint, oauth_client_id
+		FROM account WHERE user_id = $1`
+	putNextBatchQuery      = `UPDATE account SET next_batch = $1 WHERE user_id = $2`
+	putSlidingSyncPosQuery = `UPDATE account SET sliding_sync_pos = $1, to_device_since = $2 WHERE user_id = $3`
+	putTokensQuery         = `UPDATE account SET access_token = $1, refresh_token = $2 WHERE user_id = $3`
+	upsertAccountQuery     = `
+		INSERT INTO account (
+			user_id, device_id, access_token, homeserver_url, next_batch, sliding_sync_pos, to_device_since,
+			refresh_token, token_endpoint, oauth_client_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (user_id)
 			DO UPDATE SET device_id = excluded.device_id,
 			              access_token = excluded.access_token,
 			              homeserver_url = excluded.homeserver_url,
-			              next_batch = excluded.next_batch
+			              next_batch = excluded.next_batch,
+			              sliding_sync_pos = excluded.sliding_sync_pos,
+			              to_device_since = excluded.to_device_since,
+			              refresh_token = excluded.refresh_token,
+			              token_endpoint = excluded.token_endpoint,
+			              oauth_client_id = excluded.oauth_client_id
 	`
 )
 
 type AccountQuery struct {
 	*dbutil.QueryHelper[*Account]
+	cipher *columnCipher
 }
 
 func (aq *AccountQuery) GetFirstUserID(ctx context.Context) (userID id.UserID, err error) {
@@ -53,8 +68,18 @@ func (aq *AccountQuery) PutNextBatch(ctx context.Context, userID id.UserID, next
 	return aq.Exec(ctx, putNextBatchQuery, nextBatch, userID)
 }
 
+// PutSlidingSyncPos stores the sliding sync position and the since token of the to-device extension.
+func (aq *AccountQuery) PutSlidingSyncPos(ctx context.Context, userID id.UserID, pos, toDeviceSince string) error {
+	return aq.Exec(ctx, putSlidingSyncPosQuery, pos, toDeviceSince, userID)
+}
+
+// PutTokens stores a new access token and refresh token after refreshing the access token.
+func (aq *AccountQuery) PutTokens(ctx context.Context, userID id.UserID, accessToken, refreshToken string) error {
+	return aq.Exec(ctx, putTokensQuery, aq.cipher.encryptString(accessToken), encryptOptionalString(aq.cipher, refreshToken), userID)
+}
+
 func (aq *AccountQuery) Put(ctx context.Context, account *Account) error {
-	return aq.Exec(ctx, upsertAccountQuery, account.sqlVariables()...)
+	return aq.Exec(ctx, upsertAccountQuery, account.sqlVariables(aq.cipher)...)
 }
 
 type Account struct {
@@ -63,12 +88,48 @@ type Account struct {
 	AccessToken   string
 	HomeserverURL string
 	NextBatch     string
+
+	SlidingSyncPos string
+	ToDeviceSince  string
+
+	// RefreshToken, TokenEndpoint and OAuthClientID are set for OAuth logins (e.g. QR code logins)
+	// and are used to refresh the access token when it expires.
+	RefreshToken  string
+	TokenEndpoint string
+	OAuthClientID string
+
+	cipher *columnCipher
 }
 
 func (a *Account) Scan(row dbutil.Scannable) (*Account, error) {
-	return dbutil.ValueOrErr(a, row.Scan(&a.UserID, &a.DeviceID, &a.AccessToken, &a.HomeserverURL, &a.NextBatch))
+	err := row.Scan(
+		&a.UserID, &a.DeviceID, &a.AccessToken, &a.HomeserverURL, &a.NextBatch, &a.SlidingSyncPos, &a.ToDeviceSince,
+		&a.RefreshToken, &a.TokenEndpoint, &a.OAuthClientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.AccessToken, err = a.cipher.decryptString(a.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt access token: %w", err)
+	}
+	a.RefreshToken, err = a.cipher.decryptString(a.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	return a, nil
 }
 
-func (a *Account) sqlVariables() []any {
-	return []any{a.UserID, a.DeviceID, a.AccessToken, a.HomeserverURL, a.NextBatch}
+func (a *Account) sqlVariables(cc *columnCipher) []any {
+	return []any{
+		a.UserID, a.DeviceID, cc.encryptString(a.AccessToken), a.HomeserverURL, a.NextBatch, a.SlidingSyncPos, a.ToDeviceSince,
+		encryptOptionalString(cc, a.RefreshToken), a.TokenEndpoint, a.OAuthClientID,
+	}
+}
+
+func encryptOptionalString(cc *columnCipher, data string) string {
+	if data == "" {
+		return ""
+	}
+	return cc.encryptString(data)
 }
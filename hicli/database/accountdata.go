@@ -23,6 +23,15 @@ const (
 		INSERT INTO account_data (user_id, type, content) VALUES ($1, $2, $3)
 		ON CONFLICT (user_id, type) DO UPDATE SET content = excluded.content
 	`
+	getAccountDataQuery = `
+		SELECT user_id, NULL, type, content FROM account_data WHERE user_id = $1 AND type = $2
+	`
+	getRoomAccountDataQuery = `
+		SELECT user_id, room_id, type, content FROM room_account_data WHERE user_id = $1 AND room_id = $2 AND type = $3
+	`
+	getAllRoomAccountDataOfTypeQuery = `
+		SELECT user_id, room_id, type, content FROM room_account_data WHERE user_id = $1 AND type = $2
+	`
 	upsertRoomAccountDataQuery = `
 		INSERT INTO room_account_data (user_id, room_id, type, content) VALUES ($1, $2, $3, $4)
 		ON CONFLICT (user_id, room_id, type) DO UPDATE SET content = excluded.content
@@ -45,6 +54,19 @@ func (adq *AccountDataQuery) Put(ctx context.Context, userID id.UserID, eventTyp
 	return adq.Exec(ctx, upsertAccountDataQuery, userID, eventType.Type, unsafeJSONString(content))
 }
 
+func (adq *AccountDataQuery) Get(ctx context.Context, userID id.UserID, eventType event.Type) (*AccountData, error) {
+	return adq.QueryOne(ctx, getAccountDataQuery, userID, eventType.Type)
+}
+
+func (adq *AccountDataQuery) GetRoom(ctx context.Context, userID id.UserID, roomID id.RoomID, eventType event.Type) (*AccountData, error) {
+	return adq.QueryOne(ctx, getRoomAccountDataQuery, userID, roomID, eventType.Type)
+}
+
+// GetAllRooms returns the room account data events of the given type in all rooms.
+func (adq *AccountDataQuery) GetAllRooms(ctx context.Context, userID id.UserID, eventType event.Type) ([]*AccountData, error) {
+	return adq.QueryMany(ctx, getAllRoomAccountDataOfTypeQuery, userID, eventType.Type)
+}
+
 func (adq *AccountDataQuery) PutRoom(ctx context.Context, userID id.UserID, roomID id.RoomID, eventType event.Type, content json.RawMessage) error {
 	return adq.Exec(ctx, upsertRoomAccountDataQuery, userID, roomID, eventType.Type, unsafeJSONString(content))
 }
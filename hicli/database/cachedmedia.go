@@ -34,16 +34,58 @@ const (
 			SET enc_file = excluded.enc_file,
 			    file_name = excluded.file_name,
 			    mime_type = excluded.mime_type,
-			    size = excluded.size,
-			    hash = excluded.hash,
+			    size = COALESCE(excluded.size, cached_media.size),
+			    hash = COALESCE(excluded.hash, cached_media.hash),
 			    error = excluded.error
 			WHERE excluded.error IS NULL OR cached_media.hash IS NULL
 	`
 	getCachedMediaQuery = `
-		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error
+		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error, last_accessed, pinned
 		FROM cached_media
 		WHERE mxc = $1
 	`
+	markCachedMediaAccessedQuery = `
+		UPDATE cached_media SET last_accessed = $2 WHERE mxc = $1
+	`
+	setCachedMediaPinnedQuery = `
+		UPDATE cached_media SET pinned = $2 WHERE mxc = $1
+	`
+	clearCachedMediaFileQuery = `
+		UPDATE cached_media SET hash = NULL, last_accessed = NULL WHERE mxc = $1
+	`
+	getCachedMediaEvictionCandidatesQuery = `
+		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error, last_accessed, pinned
+		FROM cached_media
+		WHERE hash IS NOT NULL AND pinned = false
+		ORDER BY last_accessed ASC NULLS FIRST
+		LIMIT $1
+	`
+	getCachedMediaForClearQuery = `
+		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error, last_accessed, pinned
+		FROM cached_media
+		WHERE hash IS NOT NULL AND pinned = false
+	`
+	getCachedMediaByEventQuery = `
+		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error, last_accessed, pinned
+		FROM cached_media
+		WHERE event_rowid = $1
+	`
+	getCachedMediaAccessedBeforeQuery = `
+		SELECT mxc, event_rowid, enc_file, file_name, mime_type, size, hash, error, last_accessed, pinned
+		FROM cached_media
+		WHERE hash IS NOT NULL AND pinned = false AND (last_accessed IS NULL OR last_accessed < $1)
+	`
+	deleteCachedMediaQuery = `
+		DELETE FROM cached_media WHERE mxc = $1
+	`
+	countCachedMediaWithHashQuery = `
+		SELECT COUNT(*) FROM cached_media WHERE hash = $1
+	`
+	// Files are stored by hash, so the size of each distinct file is only counted once
+	getCachedMediaStatsQuery = `
+		SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(pinned), 0), COALESCE(SUM(CASE WHEN pinned THEN size ELSE 0 END), 0)
+		FROM (SELECT hash, MAX(size) AS size, MAX(pinned) AS pinned FROM cached_media WHERE hash IS NOT NULL GROUP BY hash)
+	`
 )
 
 type CachedMediaQuery struct {
@@ -62,6 +104,69 @@ func (cmq *CachedMediaQuery) Get(ctx context.Context, mxc id.ContentURI) (*Cache
 	return cmq.QueryOne(ctx, getCachedMediaQuery, &mxc)
 }
 
+func (cmq *CachedMediaQuery) MarkAccessed(ctx context.Context, mxc id.ContentURI, ts time.Time) error {
+	return cmq.Exec(ctx, markCachedMediaAccessedQuery, &mxc, ts.UnixMilli())
+}
+
+func (cmq *CachedMediaQuery) SetPinned(ctx context.Context, mxc id.ContentURI, pinned bool) error {
+	return cmq.Exec(ctx, setCachedMediaPinnedQuery, &mxc, pinned)
+}
+
+// ClearFile marks the file of the given media as no longer being cached on disk.
+func (cmq *CachedMediaQuery) ClearFile(ctx context.Context, mxc id.ContentURI) error {
+	return cmq.Exec(ctx, clearCachedMediaFileQuery, &mxc)
+}
+
+// GetEvictionCandidates returns unpinned media that is cached on disk, least recently accessed first.
+func (cmq *CachedMediaQuery) GetEvictionCandidates(ctx context.Context, limit int) ([]*CachedMedia, error) {
+	return cmq.QueryMany(ctx, getCachedMediaEvictionCandidatesQuery, limit)
+}
+
+// GetAllUnpinned returns all unpinned media that is cached on disk.
+func (cmq *CachedMediaQuery) GetAllUnpinned(ctx context.Context) ([]*CachedMedia, error) {
+	return cmq.QueryMany(ctx, getCachedMediaForClearQuery)
+}
+
+// GetByEventRowID returns all media entries (e.g. a file and its thumbnail) that were found in the given event.
+func (cmq *CachedMediaQuery) GetByEventRowID(ctx context.Context, rowID EventRowID) ([]*CachedMedia, error) {
+	return cmq.QueryMany(ctx, getCachedMediaByEventQuery, rowID)
+}
+
+// GetUnpinnedAccessedBefore returns unpinned media that is cached on disk and hasn't been accessed since the given time.
+func (cmq *CachedMediaQuery) GetUnpinnedAccessedBefore(ctx context.Context, ts time.Time) ([]*CachedMedia, error) {
+	return cmq.QueryMany(ctx, getCachedMediaAccessedBeforeQuery, ts.UnixMilli())
+}
+
+// Delete deletes the entry of the given media. The cached file must be removed with [CachedMediaQuery.ClearFile] first.
+func (cmq *CachedMediaQuery) Delete(ctx context.Context, mxc id.ContentURI) error {
+	return cmq.Exec(ctx, deleteCachedMediaQuery, &mxc)
+}
+
+// CountWithHash returns the number of media entries whose cached file has the given hash.
+func (cmq *CachedMediaQuery) CountWithHash(ctx context.Context, hash *[32]byte) (count int, err error) {
+	err = cmq.GetDB().QueryRow(ctx, countCachedMediaWithHashQuery, hash[:]).Scan(&count)
+	return
+}
+
+// MediaCacheStats contains statistics about the files in the local media cache.
+type MediaCacheStats struct {
+	FileCount   int   `json:"file_count"`
+	TotalSize   int64 `json:"total_size"`
+	PinnedCount int   `json:"pinned_count"`
+	PinnedSize  int64 `json:"pinned_size"`
+	MaxSize     int64 `json:"max_size"`
+}
+
+func (cmq *CachedMediaQuery) GetStats(ctx context.Context) (*MediaCacheStats, error) {
+	var stats MediaCacheStats
+	err := cmq.GetDB().QueryRow(ctx, getCachedMediaStatsQuery).
+		Scan(&stats.FileCount, &stats.TotalSize, &stats.PinnedCount, &stats.PinnedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
 type MediaError struct {
 	Matrix     *mautrix.RespError `json:"data"`
 	StatusCode int                `json:"status_code"`
@@ -97,6 +202,9 @@ type CachedMedia struct {
 	Size       int64
 	Hash       *[32]byte
 	Error      *MediaError
+
+	LastAccessed jsontime.UnixMilli
+	Pinned       bool
 }
 
 func (c *CachedMedia) UseCache() bool {
@@ -126,12 +234,18 @@ var safeMimes = []string{
 
 func (c *CachedMedia) Scan(row dbutil.Scannable) (*CachedMedia, error) {
 	var mimeType, fileName sql.NullString
-	var size, eventRowID sql.NullInt64
+	var size, eventRowID, lastAccessed sql.NullInt64
 	var hash []byte
-	err := row.Scan(&c.MXC, &eventRowID, dbutil.JSON{Data: &c.EncFile}, &fileName, &mimeType, &size, &hash, dbutil.JSON{Data: &c.Error})
+	err := row.Scan(
+		&c.MXC, &eventRowID, dbutil.JSON{Data: &c.EncFile}, &fileName, &mimeType, &size, &hash, dbutil.JSON{Data: &c.Error},
+		&lastAccessed, &c.Pinned,
+	)
 	if err != nil {
 		return nil, err
 	}
+	if lastAccessed.Valid {
+		c.LastAccessed = jsontime.UM(time.UnixMilli(lastAccessed.Int64))
+	}
 	c.MimeType = mimeType.String
 	c.FileName = fileName.String
 	c.EventRowID = EventRowID(eventRowID.Int64)
@@ -7,8 +7,12 @@
 package database
 
 import (
+	"context"
+	"fmt"
+
 	"go.mau.fi/util/dbutil"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/hicli/database/upgrades"
 )
 
@@ -22,34 +26,112 @@ type Database struct {
 	CurrentState   CurrentStateQuery
 	Timeline       TimelineQuery
 	SessionRequest SessionRequestQuery
+	SendQueue      SendQueueQuery
 	Receipt        ReceiptQuery
 	CachedMedia    CachedMediaQuery
+	SpaceEdge      SpaceEdgeQuery
+	Profile        ProfileQuery
+	URLPreview     URLPreviewQuery
+
+	// BinaryVersion is the version of the program using the database, which is recorded when the database is upgraded.
+	// It defaults to the mautrix-go version, but applications embedding hicli should set their own version.
+	BinaryVersion string
+
+	cipher       *columnCipher
+	extraSchemas []extraSchema
 }
 
 func New(rawDB *dbutil.Database) *Database {
 	rawDB.UpgradeTable = upgrades.Table
-	eventQH := dbutil.MakeQueryHelper(rawDB, newEvent)
+	cc := &columnCipher{}
+	eventQH := dbutil.MakeQueryHelper(rawDB, func(_ *dbutil.QueryHelper[*Event]) *Event {
+		return &Event{cipher: cc}
+	})
 	return &Database{
 		Database: rawDB,
 
-		Account:        AccountQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newAccount)},
+		Account: AccountQuery{
+			QueryHelper: dbutil.MakeQueryHelper(rawDB, func(_ *dbutil.QueryHelper[*Account]) *Account {
+				return &Account{cipher: cc}
+			}),
+			cipher: cc,
+		},
 		AccountData:    AccountDataQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newAccountData)},
 		Room:           RoomQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newRoom)},
-		Event:          EventQuery{QueryHelper: eventQH},
+		Event:          EventQuery{QueryHelper: eventQH, cipher: cc},
 		CurrentState:   CurrentStateQuery{QueryHelper: eventQH},
 		Timeline:       TimelineQuery{QueryHelper: eventQH},
 		SessionRequest: SessionRequestQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSessionRequest)},
+		SendQueue:      SendQueueQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSendQueueEntry)},
 		Receipt:        ReceiptQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newReceipt)},
 		CachedMedia:    CachedMediaQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newCachedMedia)},
+		SpaceEdge:      SpaceEdgeQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSpaceEdge)},
+		Profile:        ProfileQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newProfile)},
+		URLPreview:     URLPreviewQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newURLPreview)},
+
+		BinaryVersion: "mautrix-go " + mautrix.VersionWithCommit,
+
+		cipher: cc,
 	}
 }
 
+var wipeTables = []string{
+	"url_preview",
+	"profile",
+	"space_edge",
+	"receipt",
+	"current_state",
+	"timeline",
+	"session_request",
+	"send_queue",
+	"cached_media",
+	"room_account_data",
+	"account_data",
+	"event",
+	"room",
+	"account",
+}
+
+// Wipe deletes all data stored in the client database. On SQLite, secure_delete is enabled
+// and the database is vacuumed afterwards so that deleted data doesn't linger in free pages.
+func (db *Database) Wipe(ctx context.Context) error {
+	err := db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		if db.Dialect == dbutil.SQLite {
+			_, err := db.Exec(ctx, "PRAGMA secure_delete = ON")
+			if err != nil {
+				return fmt.Errorf("failed to enable secure_delete: %w", err)
+			}
+		}
+		_, err := db.Exec(ctx, "UPDATE room SET preview_event_rowid = NULL")
+		if err != nil {
+			return fmt.Errorf("failed to clear room previews: %w", err)
+		}
+		for _, table := range wipeTables {
+			_, err = db.Exec(ctx, "DELETE FROM "+table)
+			if err != nil {
+				return fmt.Errorf("failed to clear %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if db.Dialect == dbutil.SQLite {
+		_, err = db.Exec(ctx, "VACUUM")
+		if err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+	}
+	return nil
+}
+
 func newSessionRequest(_ *dbutil.QueryHelper[*SessionRequest]) *SessionRequest {
 	return &SessionRequest{}
 }
 
-func newEvent(_ *dbutil.QueryHelper[*Event]) *Event {
-	return &Event{}
+func newSendQueueEntry(_ *dbutil.QueryHelper[*SendQueueEntry]) *SendQueueEntry {
+	return &SendQueueEntry{}
 }
 
 func newRoom(_ *dbutil.QueryHelper[*Room]) *Room {
@@ -68,6 +150,14 @@ func newAccountData(_ *dbutil.QueryHelper[*AccountData]) *AccountData {
 	return &AccountData{}
 }
 
-func newAccount(_ *dbutil.QueryHelper[*Account]) *Account {
-	return &Account{}
+func newSpaceEdge(_ *dbutil.QueryHelper[*SpaceEdge]) *SpaceEdge {
+	return &SpaceEdge{}
+}
+
+func newProfile(_ *dbutil.QueryHelper[*Profile]) *Profile {
+	return &Profile{}
+}
+
+func newURLPreview(_ *dbutil.QueryHelper[*URLPreview]) *URLPreview {
+	return &URLPreview{}
 }
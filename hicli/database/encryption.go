@@ -0,0 +1,234 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mau.fi/util/exerrors"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrEncryptionAlreadyEnabled = errors.New("database encryption is already enabled")
+	ErrColumnEncrypted          = errors.New("value is encrypted, but database encryption is not enabled")
+	ErrColumnDecryptionFailed   = errors.New("failed to decrypt value (wrong database key?)")
+)
+
+const (
+	encryptedColumnPrefix = "hienc1:"
+	columnKeyInfo         = "hicli column encryption v1"
+
+	migrationBatchSize = 1000
+)
+
+// columnCipher encrypts sensitive column values at the application level.
+// A nil AEAD means encryption is disabled and values are stored as-is.
+type columnCipher struct {
+	aead cipher.AEAD
+}
+
+func (cc *columnCipher) enabled() bool {
+	return cc != nil && cc.aead != nil
+}
+
+func (cc *columnCipher) encrypt(data []byte) *string {
+	if data == nil {
+		return nil
+	} else if !cc.enabled() {
+		return unsafeJSONString(data)
+	}
+	nonce := make([]byte, cc.aead.NonceSize(), cc.aead.NonceSize()+len(data)+cc.aead.Overhead())
+	exerrors.Must(rand.Read(nonce))
+	sealed := cc.aead.Seal(nonce, nonce, data, nil)
+	str := encryptedColumnPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+	return &str
+}
+
+func (cc *columnCipher) encryptString(data string) string {
+	if !cc.enabled() {
+		return data
+	}
+	return *cc.encrypt([]byte(data))
+}
+
+func (cc *columnCipher) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedColumnPrefix)) {
+		return data, nil
+	} else if !cc.enabled() {
+		return nil, ErrColumnEncrypted
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(string(data[len(encryptedColumnPrefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrColumnDecryptionFailed, err)
+	} else if len(sealed) < cc.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrColumnDecryptionFailed)
+	}
+	nonce, ciphertext := sealed[:cc.aead.NonceSize()], sealed[cc.aead.NonceSize():]
+	plaintext, err := cc.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrColumnDecryptionFailed, err)
+	}
+	return plaintext, nil
+}
+
+func (cc *columnCipher) decryptString(data string) (string, error) {
+	out, err := cc.decrypt([]byte(data))
+	return string(out), err
+}
+
+// EnableEncryption enables application-level encryption of sensitive columns (decrypted event content
+// and the access token). The key should be high-entropy and stored outside the database, e.g. in the
+// platform keystore. It is passed through HKDF, so any length is accepted.
+//
+// This must be called before the database is used. Existing plaintext rows are still readable
+// after enabling encryption, use EncryptPlaintextRows to migrate them.
+func (db *Database) EnableEncryption(key []byte) error {
+	if db.cipher.enabled() {
+		return ErrEncryptionAlreadyEnabled
+	} else if len(key) == 0 {
+		return fmt.Errorf("database encryption key must not be empty")
+	}
+	aesKey := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(columnKeyInfo)), aesKey)
+	if err != nil {
+		return fmt.Errorf("failed to derive column encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return err
+	}
+	db.cipher.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// EncryptionEnabled returns true if EnableEncryption has been called.
+func (db *Database) EncryptionEnabled() bool {
+	return db.cipher.enabled()
+}
+
+const (
+	getPlaintextAccessTokensQuery  = `SELECT user_id, access_token FROM account WHERE access_token NOT LIKE 'hienc1:%'`
+	updateAccessTokenQuery         = `UPDATE account SET access_token = $1 WHERE user_id = $2`
+	getPlaintextRefreshTokensQuery = `
+		SELECT user_id, refresh_token FROM account WHERE refresh_token <> '' AND refresh_token NOT LIKE 'hienc1:%'
+	`
+	updateRefreshTokenQuery    = `UPDATE account SET refresh_token = $1 WHERE user_id = $2`
+	getPlaintextDecryptedQuery = `
+		SELECT rowid, decrypted FROM event
+		WHERE decrypted IS NOT NULL AND decrypted NOT LIKE 'hienc1:%'
+		LIMIT $1
+	`
+	updateDecryptedContentQuery = `UPDATE event SET decrypted = $1 WHERE rowid = $2`
+)
+
+// EncryptPlaintextRows encrypts all sensitive values that were stored before encryption was enabled.
+// It returns the number of rows that were migrated. Rows that are already encrypted are not touched,
+// so this is safe to call on every startup.
+func (db *Database) EncryptPlaintextRows(ctx context.Context) (migrated int, err error) {
+	if !db.cipher.enabled() {
+		return 0, fmt.Errorf("database encryption is not enabled")
+	}
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		tokens, err := db.scanPlaintextTokens(ctx, getPlaintextAccessTokensQuery)
+		if err != nil {
+			return fmt.Errorf("failed to get plaintext access tokens: %w", err)
+		}
+		for _, tok := range tokens {
+			_, err = db.Exec(ctx, updateAccessTokenQuery, db.cipher.encryptString(tok[1]), tok[0])
+			if err != nil {
+				return fmt.Errorf("failed to encrypt access token of %s: %w", tok[0], err)
+			}
+			migrated++
+		}
+		tokens, err = db.scanPlaintextTokens(ctx, getPlaintextRefreshTokensQuery)
+		if err != nil {
+			return fmt.Errorf("failed to get plaintext refresh tokens: %w", err)
+		}
+		for _, tok := range tokens {
+			_, err = db.Exec(ctx, updateRefreshTokenQuery, db.cipher.encryptString(tok[1]), tok[0])
+			if err != nil {
+				return fmt.Errorf("failed to encrypt refresh token of %s: %w", tok[0], err)
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for {
+		var batch int
+		batch, err = db.encryptDecryptedBatch(ctx)
+		migrated += batch
+		if err != nil || batch < migrationBatchSize {
+			return
+		}
+	}
+}
+
+func (db *Database) scanPlaintextTokens(ctx context.Context, query string) ([][2]string, error) {
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens [][2]string
+	for rows.Next() {
+		var tok [2]string
+		if err = rows.Scan(&tok[0], &tok[1]); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+func (db *Database) encryptDecryptedBatch(ctx context.Context) (count int, err error) {
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		rows, err := db.Query(ctx, getPlaintextDecryptedQuery, migrationBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to get plaintext events: %w", err)
+		}
+		type plaintextEvent struct {
+			rowID     EventRowID
+			decrypted []byte
+		}
+		var events []plaintextEvent
+		for rows.Next() {
+			var evt plaintextEvent
+			if err = rows.Scan(&evt.rowID, &evt.decrypted); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to scan plaintext event: %w", err)
+			}
+			events = append(events, evt)
+		}
+		if err = rows.Close(); err != nil {
+			return err
+		} else if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, evt := range events {
+			_, err = db.Exec(ctx, updateDecryptedContentQuery, db.cipher.encrypt(evt.decrypted), evt.rowID)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt content of event %d: %w", evt.rowID, err)
+			}
+		}
+		count = len(events)
+		return nil
+	})
+	return
+}
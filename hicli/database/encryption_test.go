@@ -0,0 +1,156 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	testUserID = id.UserID("@alice:example.com")
+	testRoomID = id.RoomID("!room:example.com")
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000&_foreign_keys=on")
+	require.NoError(t, err)
+	// Every connection to :memory: gets its own database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	hdb := New(db)
+	require.NoError(t, hdb.Upgrade(context.Background()))
+	return hdb
+}
+
+func newTestCipher(t *testing.T, key string) *columnCipher {
+	t.Helper()
+	db := &Database{cipher: &columnCipher{}}
+	require.NoError(t, db.EnableEncryption([]byte(key)))
+	return db.cipher
+}
+
+func TestColumnCipher_RoundTrip(t *testing.T) {
+	cc := newTestCipher(t, "meow")
+	plaintext := []byte(`{"body":"hello"}`)
+	encrypted := cc.encrypt(plaintext)
+	require.NotNil(t, encrypted)
+	assert.True(t, strings.HasPrefix(*encrypted, encryptedColumnPrefix))
+	assert.NotContains(t, *encrypted, "hello")
+	assert.NotEqual(t, *encrypted, *cc.encrypt(plaintext), "encrypting the same value twice should use a different nonce")
+	decrypted, err := cc.decrypt([]byte(*encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	str, err := cc.decryptString(cc.encryptString("syt_token"))
+	require.NoError(t, err)
+	assert.Equal(t, "syt_token", str)
+	assert.Nil(t, cc.encrypt(nil))
+
+	decrypted, err = cc.decrypt(plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted, "plaintext values should be readable after enabling encryption")
+}
+
+func TestColumnCipher_Disabled(t *testing.T) {
+	cc := &columnCipher{}
+	assert.Equal(t, "syt_token", cc.encryptString("syt_token"))
+	assert.Equal(t, `{"body":"hello"}`, *cc.encrypt([]byte(`{"body":"hello"}`)))
+
+	encrypted := newTestCipher(t, "meow").encryptString("syt_token")
+	_, err := cc.decryptString(encrypted)
+	assert.ErrorIs(t, err, ErrColumnEncrypted)
+}
+
+func TestColumnCipher_WrongKey(t *testing.T) {
+	encrypted := newTestCipher(t, "meow").encryptString("syt_token")
+	_, err := newTestCipher(t, "hmm").decryptString(encrypted)
+	assert.ErrorIs(t, err, ErrColumnDecryptionFailed)
+}
+
+func TestColumnCipher_Corrupted(t *testing.T) {
+	cc := newTestCipher(t, "meow")
+	encrypted := cc.encryptString("syt_token")
+	for _, data := range []string{
+		encryptedColumnPrefix + "not base64!",
+		encryptedColumnPrefix + "AAAA",
+		encrypted[:len(encrypted)-4],
+		encryptedColumnPrefix + "A" + encrypted[len(encryptedColumnPrefix)+1:],
+	} {
+		_, err := cc.decryptString(data)
+		assert.ErrorIs(t, err, ErrColumnDecryptionFailed, data)
+	}
+}
+
+func TestDatabase_EnableEncryption(t *testing.T) {
+	db := newTestDatabase(t)
+	assert.Error(t, db.EnableEncryption(nil))
+	assert.False(t, db.EncryptionEnabled())
+	require.NoError(t, db.EnableEncryption([]byte("meow")))
+	assert.True(t, db.EncryptionEnabled())
+	assert.ErrorIs(t, db.EnableEncryption([]byte("meow")), ErrEncryptionAlreadyEnabled)
+}
+
+func TestDatabase_EncryptPlaintextRows(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	_, err := db.EncryptPlaintextRows(ctx)
+	assert.Error(t, err, "migrating without a key should fail")
+
+	require.NoError(t, db.Account.Put(ctx, &Account{UserID: testUserID, DeviceID: "DEVICE", AccessToken: "syt_token"}))
+	require.NoError(t, db.Room.CreateRow(ctx, testRoomID))
+	decrypted := json.RawMessage(`{"msgtype":"m.text","body":"hello"}`)
+	rowID, err := db.Event.Insert(ctx, &Event{
+		RoomID:        testRoomID,
+		ID:            "$event",
+		Sender:        testUserID,
+		Type:          "m.room.encrypted",
+		Content:       json.RawMessage(`{}`),
+		Unsigned:      json.RawMessage(`{}`),
+		Decrypted:     decrypted,
+		DecryptedType: "m.room.message",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.EnableEncryption([]byte("meow")))
+	migrated, err := db.EncryptPlaintextRows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	var rawToken, rawDecrypted string
+	require.NoError(t, db.QueryRow(ctx, "SELECT access_token FROM account").Scan(&rawToken))
+	require.NoError(t, db.QueryRow(ctx, "SELECT decrypted FROM event WHERE rowid=$1", rowID).Scan(&rawDecrypted))
+	assert.True(t, strings.HasPrefix(rawToken, encryptedColumnPrefix))
+	assert.True(t, strings.HasPrefix(rawDecrypted, encryptedColumnPrefix))
+
+	account, err := db.Account.Get(ctx, testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, "syt_token", account.AccessToken)
+	assert.Empty(t, account.RefreshToken)
+	evt, err := db.Event.GetByID(ctx, "$event")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(decrypted), string(evt.Decrypted))
+
+	migrated, err = db.EncryptPlaintextRows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated, "already encrypted rows shouldn't be migrated again")
+}
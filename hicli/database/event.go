@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exgjson"
 	"go.mau.fi/util/jsontime"
+	"go.mau.fi/util/variationselector"
 
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -27,19 +29,29 @@ const (
 	getEventBaseQuery = `
 		SELECT rowid, -1, room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type, unsigned,
 		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error,
-		       reactions, last_edit_rowid
+		       reactions, last_edit_rowid, unread_type, url_previews
 		FROM event
 	`
-	getEventByRowID                  = getEventBaseQuery + `WHERE rowid = $1`
-	getManyEventsByRowID             = getEventBaseQuery + `WHERE rowid IN (%s)`
-	getEventByID                     = getEventBaseQuery + `WHERE event_id = $1`
+	getEventByRowID                = getEventBaseQuery + `WHERE rowid = $1`
+	getManyEventsByRowID           = getEventBaseQuery + `WHERE rowid IN (%s)`
+	getEventByID                   = getEventBaseQuery + `WHERE event_id = $1`
+	getEventByTransactionID        = getEventBaseQuery + `WHERE transaction_id = $1`
+	getClosestEventBeforeTimestamp = getEventBaseQuery + `
+		WHERE room_id = $1 AND timestamp <= $2 AND state_key IS NULL AND event_id NOT LIKE '~%'
+		ORDER BY timestamp DESC LIMIT 1
+	`
+	getClosestEventAfterTimestamp = getEventBaseQuery + `
+		WHERE room_id = $1 AND timestamp >= $2 AND state_key IS NULL AND event_id NOT LIKE '~%'
+		ORDER BY timestamp ASC LIMIT 1
+	`
 	getFailedEventsByMegolmSessionID = getEventBaseQuery + `WHERE room_id = $1 AND megolm_session_id = $2 AND decryption_error IS NOT NULL`
 	insertEventBaseQuery             = `
 		INSERT INTO event (
 			room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type, unsigned,
-			transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error
+			transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error,
+			unread_type
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 	`
 	insertEventQuery = insertEventBaseQuery + `RETURNING rowid`
 	upsertEventQuery = insertEventBaseQuery + `
@@ -50,7 +62,8 @@ const (
 			    decryption_error=CASE WHEN COALESCE(event.decrypted, excluded.decrypted) IS NULL THEN COALESCE(excluded.decryption_error, event.decryption_error) END,
 			    send_error=excluded.send_error,
 				timestamp=excluded.timestamp,
-				unsigned=COALESCE(excluded.unsigned, event.unsigned)
+				unsigned=COALESCE(excluded.unsigned, event.unsigned),
+				unread_type=COALESCE(NULLIF(excluded.unread_type, 0), event.unread_type)
 		ON CONFLICT (transaction_id) DO UPDATE
 			SET event_id=excluded.event_id,
 				timestamp=excluded.timestamp,
@@ -59,8 +72,35 @@ const (
 	`
 	updateEventSendErrorQuery = `UPDATE event SET send_error = $2 WHERE rowid = $1`
 	updateEventIDQuery        = `UPDATE event SET event_id = $2, send_error = NULL WHERE rowid=$1`
+	confirmLocalEchoQuery     = `UPDATE event SET event_id = $2, timestamp = $3, unsigned = $4, send_error = NULL WHERE rowid = $1`
 	updateEventDecryptedQuery = `UPDATE event SET decrypted = $1, decrypted_type = $2, decryption_error = NULL WHERE rowid = $3`
-	getEventReactionsQuery    = getEventBaseQuery + `
+	setEventUnreadTypeQuery   = `UPDATE event SET unread_type = $2 WHERE rowid = $1`
+	setEventURLPreviewsQuery  = `UPDATE event SET url_previews = $2 WHERE rowid = $1 AND redacted_by IS NULL`
+	setEventRedactedByQuery   = `UPDATE event SET redacted_by = $2 WHERE rowid = $1`
+	// The redacted_by column is set separately before clearing the content, because the triggers
+	// that update reaction counts need the original content.
+	markEventRedactedQuery  = `UPDATE event SET redacted_by = $2 WHERE rowid = $1 AND redacted_by IS NULL`
+	clearRedactedEventQuery = `
+		UPDATE event
+		SET content = $2, decrypted = NULL, decrypted_type = NULL, decryption_error = NULL, url_previews = NULL, redacted_by = $3
+		WHERE rowid = $1
+	`
+	replaceRedactedByQuery = `UPDATE event SET redacted_by = $2 WHERE redacted_by = $1`
+	// Current state events are kept so that the room state stays intact,
+	// and events in the send queue are kept so that pending messages aren't lost.
+	purgeRoomEventsQuery = `
+		DELETE FROM event
+		WHERE room_id = $1 AND timestamp < $2
+		  AND rowid NOT IN (SELECT event_rowid FROM current_state WHERE room_id = $1)
+		  AND rowid NOT IN (SELECT event_rowid FROM send_queue WHERE room_id = $1)
+	`
+	getRoomsWithEventsBeforeQuery = `
+		SELECT DISTINCT room_id FROM event
+		WHERE timestamp < $1
+		  AND rowid NOT IN (SELECT event_rowid FROM current_state)
+		  AND rowid NOT IN (SELECT event_rowid FROM send_queue)
+	`
+	getEventReactionsQuery = getEventBaseQuery + `
 		WHERE room_id = ?
 		  AND type = 'm.reaction'
 		  AND relation_type = 'm.annotation'
@@ -84,20 +124,123 @@ const (
 		UPDATE event SET last_edit_rowid = $2 WHERE event_id = $1
 	`
 	updateReactionCountsQuery = `UPDATE event SET reactions = $2 WHERE event_id = $1`
+	// Unsent reactions are counted as long as they're still in the send queue
+	getOwnReactionQuery = getEventBaseQuery + `
+		WHERE room_id = $1
+		  AND relates_to = $2
+		  AND sender = $3
+		  AND type = 'm.reaction'
+		  AND relation_type = 'm.annotation'
+		  AND redacted_by IS NULL
+		  AND content ->> '$."m.relates_to".key' IN ($4, $5, $6)
+		  AND (send_error IS NULL OR rowid IN (SELECT event_rowid FROM send_queue))
+		ORDER BY rowid DESC
+		LIMIT 1
+	`
+	// Like reactions, unsent relations are only included while they're in the send queue
+	getRelatedEventsQuery = getEventBaseQuery + `
+		WHERE room_id = $1
+		  AND relates_to = $2
+		  AND relation_type = $3
+		  AND redacted_by IS NULL
+		  AND (send_error IS NULL OR rowid IN (SELECT event_rowid FROM send_queue))
+		ORDER BY timestamp, rowid
+	`
+	recountReactionsQuery = `
+		UPDATE event
+		SET reactions = COALESCE((
+			SELECT json_group_object(reaction_key, reaction_count)
+			FROM (
+				SELECT reaction.content ->> '$."m.relates_to".key' AS reaction_key, COUNT(*) AS reaction_count
+				FROM event reaction
+				WHERE reaction.room_id = event.room_id
+				  AND reaction.relates_to = event.event_id
+				  AND reaction.type = 'm.reaction'
+				  AND reaction.relation_type = 'm.annotation'
+				  AND reaction.redacted_by IS NULL
+				  AND reaction.content ->> '$."m.relates_to".key' IS NOT NULL
+				  AND (reaction.send_error IS NULL OR reaction.rowid IN (SELECT event_rowid FROM send_queue))
+				GROUP BY reaction_key
+			)
+		), '{}')
+		WHERE room_id = $1 AND event_id = $2
+		RETURNING reactions
+	`
+	getFailedMegolmSessionIDs = `
+		SELECT DISTINCT room_id, megolm_session_id FROM event
+		WHERE megolm_session_id IS NOT NULL AND decryption_error IS NOT NULL AND decrypted IS NULL
+	`
 )
 
 type EventQuery struct {
 	*dbutil.QueryHelper[*Event]
+	cipher *columnCipher
 }
 
 func (eq *EventQuery) GetFailedByMegolmSessionID(ctx context.Context, roomID id.RoomID, sessionID id.SessionID) ([]*Event, error) {
 	return eq.QueryMany(ctx, getFailedEventsByMegolmSessionID, roomID, sessionID)
 }
 
+type roomSessionTuple struct {
+	roomID    id.RoomID
+	sessionID id.SessionID
+}
+
+// GetFailedMegolmSessionIDs returns the IDs of all megolm sessions that have events which failed to decrypt, grouped by room.
+func (eq *EventQuery) GetFailedMegolmSessionIDs(ctx context.Context) (map[id.RoomID][]id.SessionID, error) {
+	rows, err := eq.GetDB().Query(ctx, getFailedMegolmSessionIDs)
+	output := make(map[id.RoomID][]id.SessionID)
+	return output, dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (tuple roomSessionTuple, err error) {
+		err = row.Scan(&tuple.roomID, &tuple.sessionID)
+		return
+	}, err).Iter(func(tuple roomSessionTuple) (bool, error) {
+		output[tuple.roomID] = append(output[tuple.roomID], tuple.sessionID)
+		return true, nil
+	})
+}
+
 func (eq *EventQuery) GetByID(ctx context.Context, eventID id.EventID) (*Event, error) {
 	return eq.QueryOne(ctx, getEventByID, eventID)
 }
 
+// GetOwnReaction gets the reaction with the given key sent by the given user to the given event.
+// Reactions that failed to send are ignored. The key is matched both with and without variation selectors,
+// as reactions sent by other clients don't necessarily use normalized keys.
+func (eq *EventQuery) GetOwnReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, sender id.UserID, key string) (*Event, error) {
+	return eq.QueryOne(ctx, getOwnReactionQuery, roomID, eventID, sender, key, variationselector.Add(key), variationselector.Remove(key))
+}
+
+// GetRelated gets all non-redacted events that relate to the given event with the given relation type,
+// ordered by timestamp. Events that failed to send are ignored.
+func (eq *EventQuery) GetRelated(ctx context.Context, roomID id.RoomID, eventID id.EventID, relType event.RelationType) ([]*Event, error) {
+	return eq.QueryMany(ctx, getRelatedEventsQuery, roomID, eventID, relType)
+}
+
+// RecountReactions recalculates the reaction counts of the given event from scratch,
+// ignoring redacted reactions and reactions that failed to send.
+func (eq *EventQuery) RecountReactions(ctx context.Context, roomID id.RoomID, eventID id.EventID) (map[string]int, error) {
+	var counts map[string]int
+	err := eq.GetDB().QueryRow(ctx, recountReactionsQuery, roomID, eventID).Scan(dbutil.JSON{Data: &counts})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return counts, err
+}
+
+func (eq *EventQuery) GetByTransactionID(ctx context.Context, txnID string) (*Event, error) {
+	return eq.QueryOne(ctx, getEventByTransactionID, txnID)
+}
+
+// GetClosestByTimestamp finds the locally stored non-state event closest to the given timestamp.
+// If forward is true, the event at or after the timestamp is returned, otherwise the event at or before it.
+func (eq *EventQuery) GetClosestByTimestamp(ctx context.Context, roomID id.RoomID, ts time.Time, forward bool) (*Event, error) {
+	query := getClosestEventBeforeTimestamp
+	if forward {
+		query = getClosestEventAfterTimestamp
+	}
+	return eq.QueryOne(ctx, query, roomID, ts.UnixMilli())
+}
+
 func (eq *EventQuery) GetByRowID(ctx context.Context, rowID EventRowID) (*Event, error) {
 	return eq.QueryOne(ctx, getEventByRowID, rowID)
 }
@@ -108,7 +251,7 @@ func (eq *EventQuery) GetByRowIDs(ctx context.Context, rowIDs ...EventRowID) ([]
 }
 
 func (eq *EventQuery) Upsert(ctx context.Context, evt *Event) (rowID EventRowID, err error) {
-	err = eq.GetDB().QueryRow(ctx, upsertEventQuery, evt.sqlVariables()...).Scan(&rowID)
+	err = eq.GetDB().QueryRow(ctx, upsertEventQuery, evt.sqlVariables(eq.cipher)...).Scan(&rowID)
 	if err == nil {
 		evt.RowID = rowID
 	}
@@ -116,7 +259,7 @@ func (eq *EventQuery) Upsert(ctx context.Context, evt *Event) (rowID EventRowID,
 }
 
 func (eq *EventQuery) Insert(ctx context.Context, evt *Event) (rowID EventRowID, err error) {
-	err = eq.GetDB().QueryRow(ctx, insertEventQuery, evt.sqlVariables()...).Scan(&rowID)
+	err = eq.GetDB().QueryRow(ctx, insertEventQuery, evt.sqlVariables(eq.cipher)...).Scan(&rowID)
 	if err == nil {
 		evt.RowID = rowID
 	}
@@ -127,20 +270,78 @@ func (eq *EventQuery) UpdateID(ctx context.Context, rowID EventRowID, newID id.E
 	return eq.Exec(ctx, updateEventIDQuery, rowID, newID)
 }
 
+// ConfirmLocalEcho updates a locally inserted event with the data of its remote echo. The content is kept as-is,
+// as the local echo already contains the decrypted content of the event.
+func (eq *EventQuery) ConfirmLocalEcho(ctx context.Context, rowID EventRowID, remoteEcho *Event) error {
+	return eq.Exec(ctx, confirmLocalEchoQuery, rowID, remoteEcho.ID, remoteEcho.Timestamp.UnixMilli(), unsafeJSONString(remoteEcho.Unsigned))
+}
+
+func (eq *EventQuery) SetLastEditRowID(ctx context.Context, eventID id.EventID, editRowID EventRowID) error {
+	return eq.Exec(ctx, setLastEditRowIDQuery, eventID, editRowID)
+}
+
 func (eq *EventQuery) UpdateSendError(ctx context.Context, rowID EventRowID, sendError string) error {
 	return eq.Exec(ctx, updateEventSendErrorQuery, rowID, sendError)
 }
 
 func (eq *EventQuery) UpdateDecrypted(ctx context.Context, rowID EventRowID, decrypted json.RawMessage, decryptedType string) error {
-	return eq.Exec(ctx, updateEventDecryptedQuery, unsafeJSONString(decrypted), decryptedType, rowID)
+	return eq.Exec(ctx, updateEventDecryptedQuery, eq.cipher.encrypt(decrypted), decryptedType, rowID)
+}
+
+func (eq *EventQuery) UpdateUnreadType(ctx context.Context, rowID EventRowID, unreadType UnreadType) error {
+	return eq.Exec(ctx, setEventUnreadTypeQuery, rowID, unreadType)
+}
+
+// SetURLPreviews stores the URL previews fetched for the given event. Redacted events are left untouched.
+func (eq *EventQuery) SetURLPreviews(ctx context.Context, rowID EventRowID, previews []*event.BeeperLinkPreview) error {
+	return eq.Exec(ctx, setEventURLPreviewsQuery, rowID, dbutil.JSON{Data: previews})
+}
+
+// SetRedactedBy changes the redaction of the given event without touching its content.
+// It's used to tombstone events locally while the redaction is being sent.
+func (eq *EventQuery) SetRedactedBy(ctx context.Context, rowID EventRowID, redactedBy id.EventID) error {
+	return eq.Exec(ctx, setEventRedactedByQuery, rowID, dbutil.StrPtr(redactedBy))
+}
+
+// ReplaceRedactedBy updates the redaction event ID of all events redacted by oldID.
+func (eq *EventQuery) ReplaceRedactedBy(ctx context.Context, oldID, newID id.EventID) error {
+	return eq.Exec(ctx, replaceRedactedByQuery, oldID, newID)
+}
+
+// Redact marks the given event as redacted and replaces its content with the given redacted content.
+func (eq *EventQuery) Redact(ctx context.Context, rowID EventRowID, redactedBy id.EventID, redactedContent json.RawMessage) error {
+	return eq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := eq.Exec(ctx, markEventRedactedQuery, rowID, redactedBy)
+		if err != nil {
+			return err
+		}
+		return eq.Exec(ctx, clearRedactedEventQuery, rowID, unsafeJSONString(redactedContent), redactedBy)
+	})
+}
+
+// Purge deletes the events in the given room that were sent before the given time, except for current state
+// and events that are still being sent. Timeline entries of the events are deleted by the foreign key cascade.
+func (eq *EventQuery) Purge(ctx context.Context, roomID id.RoomID, before time.Time) (int64, error) {
+	res, err := eq.GetDB().Exec(ctx, purgeRoomEventsQuery, roomID, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetRoomsWithEventsBefore returns the IDs of rooms that have events sent before the given time
+// which would be deleted by [EventQuery.Purge].
+func (eq *EventQuery) GetRoomsWithEventsBefore(ctx context.Context, before time.Time) ([]id.RoomID, error) {
+	rows, err := eq.GetDB().Query(ctx, getRoomsWithEventsBeforeQuery, before.UnixMilli())
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
 }
 
 func (eq *EventQuery) FillReactionCounts(ctx context.Context, roomID id.RoomID, events []*Event) error {
 	eventIDs := make([]id.EventID, 0)
 	eventMap := make(map[id.EventID]*Event)
-	for i, evt := range events {
+	for _, evt := range events {
 		if evt.Reactions == nil {
-			eventIDs[i] = evt.ID
+			eventIDs = append(eventIDs, evt.ID)
 			eventMap[evt.ID] = evt
 		}
 	}
@@ -292,6 +493,27 @@ type Event struct {
 
 	Reactions     map[string]int `json:"reactions,omitempty"`
 	LastEditRowID *EventRowID    `json:"last_edit_rowid,omitempty"`
+	UnreadType    UnreadType     `json:"unread_type,omitempty"`
+	// URLPreviews contains previews fetched by the client for links in messages that didn't bundle previews.
+	URLPreviews []*event.BeeperLinkPreview `json:"url_previews,omitempty"`
+
+	cipher *columnCipher
+}
+
+// UnreadType contains flags describing how an event affects the unread counts of a room,
+// based on the type of the event and the push rules of the user.
+type UnreadType int
+
+const (
+	UnreadTypeNone      UnreadType = 0b0000
+	UnreadTypeNormal    UnreadType = 0b0001
+	UnreadTypeNotify    UnreadType = 0b0010
+	UnreadTypeHighlight UnreadType = 0b0100
+	UnreadTypeSound     UnreadType = 0b1000
+)
+
+func (ut UnreadType) Is(flag UnreadType) bool {
+	return ut&flag != 0
 }
 
 func MautrixToEvent(evt *event.Event) *Event {
@@ -364,6 +586,8 @@ func (e *Event) Scan(row dbutil.Scannable) (*Event, error) {
 		&sendError,
 		dbutil.JSON{Data: &e.Reactions},
 		&e.LastEditRowID,
+		&e.UnreadType,
+		dbutil.JSON{Data: &e.URLPreviews},
 	)
 	if err != nil {
 		return nil, err
@@ -374,6 +598,10 @@ func (e *Event) Scan(row dbutil.Scannable) (*Event, error) {
 	e.RelatesTo = id.EventID(relatesTo.String)
 	e.RelationType = event.RelationType(relationType.String)
 	e.MegolmSessionID = id.SessionID(megolmSessionID.String)
+	e.Decrypted, err = e.cipher.decrypt(e.Decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content of %s: %w", e.ID, err)
+	}
 	e.DecryptedType = decryptedType.String
 	e.DecryptionError = decryptionError.String
 	e.SendError = sendError.String
@@ -409,7 +637,7 @@ func getMegolmSessionID(evt *event.Event) id.SessionID {
 	return ""
 }
 
-func (e *Event) sqlVariables() []any {
+func (e *Event) sqlVariables(cc *columnCipher) []any {
 	var reactions any
 	if e.Reactions != nil {
 		reactions = e.Reactions
@@ -422,7 +650,7 @@ func (e *Event) sqlVariables() []any {
 		e.StateKey,
 		e.Timestamp.UnixMilli(),
 		unsafeJSONString(e.Content),
-		unsafeJSONString(e.Decrypted),
+		cc.encrypt(e.Decrypted),
 		dbutil.StrPtr(e.DecryptedType),
 		unsafeJSONString(e.Unsigned),
 		dbutil.StrPtr(e.TransactionID),
@@ -432,6 +660,7 @@ func (e *Event) sqlVariables() []any {
 		dbutil.StrPtr(e.MegolmSessionID),
 		dbutil.StrPtr(e.DecryptionError),
 		dbutil.StrPtr(e.SendError),
+		e.UnreadType,
 		dbutil.JSON{Data: reactions},
 		e.LastEditRowID,
 	}
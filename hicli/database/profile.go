@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getProfileQuery = `
+		SELECT user_id, displayname, avatar_url, fetched_at FROM profile WHERE user_id = $1
+	`
+	upsertProfileQuery = `
+		INSERT INTO profile (user_id, displayname, avatar_url, fetched_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+			SET displayname = excluded.displayname,
+			    avatar_url = excluded.avatar_url,
+			    fetched_at = excluded.fetched_at
+	`
+	deleteProfileQuery = `
+		DELETE FROM profile WHERE user_id = $1 RETURNING user_id
+	`
+)
+
+type ProfileQuery struct {
+	*dbutil.QueryHelper[*Profile]
+}
+
+func (pq *ProfileQuery) Get(ctx context.Context, userID id.UserID) (*Profile, error) {
+	return pq.QueryOne(ctx, getProfileQuery, userID)
+}
+
+func (pq *ProfileQuery) Put(ctx context.Context, profile *Profile) error {
+	return pq.Exec(ctx, upsertProfileQuery, profile.sqlVariables()...)
+}
+
+// Delete removes the cached profile of the given user and returns true if there was a cached profile.
+func (pq *ProfileQuery) Delete(ctx context.Context, userID id.UserID) (deleted bool, err error) {
+	var deletedUserID id.UserID
+	err = pq.GetDB().QueryRow(ctx, deleteProfileQuery, userID).Scan(&deletedUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	} else if err == nil {
+		deleted = true
+	}
+	return
+}
+
+// Profile is a cached global profile of a user.
+type Profile struct {
+	UserID      id.UserID           `json:"user_id"`
+	Displayname string              `json:"displayname,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	FetchedAt   jsontime.UnixMilli  `json:"fetched_at"`
+}
+
+func (p *Profile) Scan(row dbutil.Scannable) (*Profile, error) {
+	var fetchedAt int64
+	err := row.Scan(&p.UserID, &p.Displayname, &p.AvatarURL, &fetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.FetchedAt = jsontime.UM(time.UnixMilli(fetchedAt))
+	return p, nil
+}
+
+func (p *Profile) sqlVariables() []any {
+	return []any{p.UserID, p.Displayname, p.AvatarURL, p.FetchedAt.UnixMilli()}
+}
@@ -26,6 +26,11 @@ const (
 			SET event_id = excluded.event_id,
 			    timestamp = excluded.timestamp
 	`
+	getReadReceiptsQuery = `
+		SELECT room_id, user_id, receipt_type, thread_id, event_id, timestamp
+		FROM receipt
+		WHERE room_id = $1 AND receipt_type IN ('m.read', 'm.read.private')
+	`
 )
 
 var receiptMassInserter = dbutil.NewMassInsertBuilder[*Receipt, [1]any](upsertReceiptQuery, "($1, $%d, $%d, $%d, $%d, $%d)")
@@ -38,6 +43,11 @@ func (rq *ReceiptQuery) Put(ctx context.Context, receipt *Receipt) error {
 	return rq.Exec(ctx, upsertReceiptQuery, receipt.sqlVariables()...)
 }
 
+// GetRead gets all public and private read receipts in the given room.
+func (rq *ReceiptQuery) GetRead(ctx context.Context, roomID id.RoomID) ([]*Receipt, error) {
+	return rq.QueryMany(ctx, getReadReceiptsQuery, roomID)
+}
+
 func (rq *ReceiptQuery) PutMany(ctx context.Context, roomID id.RoomID, receipts ...*Receipt) error {
 	if len(receipts) > 1000 {
 		return rq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
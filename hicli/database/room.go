@@ -24,12 +24,18 @@ const (
 	getRoomBaseQuery = `
 		SELECT room_id, creation_content, name, name_quality, avatar, explicit_avatar, topic, canonical_alias,
 		       lazy_load_summary, encryption_event, has_member_list,
-		       preview_event_rowid, sorting_timestamp, prev_batch
+		       preview_event_rowid, sorting_timestamp, prev_batch,
+		       unread_highlights, unread_notifications, unread_messages
 		FROM room
 	`
-	getRoomsBySortingTimestampQuery = getRoomBaseQuery + `WHERE sorting_timestamp < $1 AND sorting_timestamp > 0 ORDER BY sorting_timestamp DESC LIMIT $2`
-	getRoomByIDQuery                = getRoomBaseQuery + `WHERE room_id = $1`
-	ensureRoomExistsQuery           = `
+	getRoomsBySortingTimestampQuery = getRoomBaseQuery + `
+		WHERE sorting_timestamp > 0 AND (sorting_timestamp < $1 OR (sorting_timestamp = $1 AND room_id < $2))
+		ORDER BY sorting_timestamp DESC, room_id DESC
+		LIMIT $3
+	`
+	getRoomByIDQuery       = getRoomBaseQuery + `WHERE room_id = $1`
+	getAllSortedRoomsQuery = getRoomBaseQuery + `WHERE sorting_timestamp > 0`
+	ensureRoomExistsQuery  = `
 		INSERT INTO room (room_id) VALUES ($1)
 		ON CONFLICT (room_id) DO NOTHING
 	`
@@ -53,6 +59,36 @@ const (
 	setRoomPrevBatchQuery = `
 		UPDATE room SET prev_batch = $2 WHERE room_id = $1
 	`
+	clearRoomSortingTimestampQuery = `
+		UPDATE room SET sorting_timestamp = NULL WHERE room_id = $1
+	`
+	markRoomForCleanupQuery = `
+		UPDATE room SET cleanup_at = MIN(COALESCE(cleanup_at, $2), $2) WHERE room_id = $1
+	`
+	unmarkRoomForCleanupQuery = `
+		UPDATE room SET cleanup_at = NULL WHERE room_id = $1 AND cleanup_at IS NOT NULL
+	`
+	getRoomsToCleanUpQuery = `
+		SELECT room_id FROM room WHERE cleanup_at IS NOT NULL AND cleanup_at <= $1
+	`
+	getRoomsWithoutCreateEventQuery = `
+		SELECT room_id FROM room
+		WHERE sorting_timestamp > 0 AND NOT EXISTS(
+			SELECT 1 FROM current_state cs
+			WHERE cs.room_id = room.room_id AND cs.event_type = 'm.room.create' AND cs.state_key = ''
+		)
+	`
+	// Edges where the room is a child are only deleted if they came from the m.space.parent event in the room,
+	// edges declared by the parent space are still valid.
+	deleteRoomSpaceEdgesQuery = `
+		DELETE FROM space_edge WHERE space_id = $1 OR (child_id = $1 AND child_event_rowid IS NULL)
+	`
+	clearRoomSpaceParentsQuery = `
+		UPDATE space_edge SET parent_event_rowid = NULL, canonical = false WHERE child_id = $1
+	`
+	deleteRoomIfCleanupDueQuery = `
+		DELETE FROM room WHERE room_id = $1 AND cleanup_at IS NOT NULL AND cleanup_at <= $2
+	`
 	updateRoomPreviewIfLaterOnTimelineQuery = `
 		UPDATE room
 		SET preview_event_rowid = $2
@@ -74,6 +110,39 @@ const (
 		ORDER BY timestamp DESC
 		LIMIT 1
 	`
+	// Events are unread if they're after both the user's latest read receipt and the user's latest own event.
+	// Receipts pointing at events that aren't in the local timeline are ignored.
+	recalculateRoomUnreadCountsQuery = `
+		WITH read_until AS (
+			SELECT MAX(COALESCE((
+				SELECT MAX(timeline.rowid)
+				FROM receipt
+				JOIN event ON event.event_id = receipt.event_id
+				JOIN timeline ON timeline.event_rowid = event.rowid
+				WHERE receipt.room_id = $1 AND receipt.user_id = $2 AND receipt.receipt_type IN ('m.read', 'm.read.private')
+			), -9223372036854775808), COALESCE((
+				SELECT MAX(timeline.rowid)
+				FROM timeline
+				JOIN event ON event.rowid = timeline.event_rowid
+				WHERE timeline.room_id = $1 AND event.sender = $2
+			), -9223372036854775808)) AS rowid
+		)
+		UPDATE room
+		SET (unread_highlights, unread_notifications, unread_messages) = (
+			SELECT COALESCE(SUM(event.unread_type & 4 <> 0), 0),
+			       COALESCE(SUM(event.unread_type & 2 <> 0), 0),
+			       COALESCE(SUM(event.unread_type & 1 <> 0), 0)
+			FROM timeline
+			JOIN event ON event.rowid = timeline.event_rowid
+			WHERE timeline.room_id = $1
+			  AND timeline.rowid > (SELECT rowid FROM read_until)
+			  AND event.sender <> $2
+			  AND event.redacted_by IS NULL
+			  AND event.unread_type > 0
+		)
+		WHERE room_id = $1
+		RETURNING unread_highlights, unread_notifications, unread_messages
+	`
 )
 
 type RoomQuery struct {
@@ -84,8 +153,15 @@ func (rq *RoomQuery) Get(ctx context.Context, roomID id.RoomID) (*Room, error) {
 	return rq.QueryOne(ctx, getRoomByIDQuery, roomID)
 }
 
-func (rq *RoomQuery) GetBySortTS(ctx context.Context, maxTS time.Time, limit int) ([]*Room, error) {
-	return rq.QueryMany(ctx, getRoomsBySortingTimestampQuery, maxTS.UnixMilli(), limit)
+// GetBySortTS returns rooms in the room list ordered by sorting timestamp and room ID, starting after the given
+// (timestamp, room ID) cursor. Rooms with the same timestamp are ordered by ID so that pagination doesn't skip them.
+func (rq *RoomQuery) GetBySortTS(ctx context.Context, maxTS time.Time, maxRoomID id.RoomID, limit int) ([]*Room, error) {
+	return rq.QueryMany(ctx, getRoomsBySortingTimestampQuery, maxTS.UnixMilli(), maxRoomID, limit)
+}
+
+// GetAll returns all rooms that have a sorting timestamp, i.e. the rooms that are shown in the room list.
+func (rq *RoomQuery) GetAll(ctx context.Context) ([]*Room, error) {
+	return rq.QueryMany(ctx, getAllSortedRoomsQuery)
 }
 
 func (rq *RoomQuery) Upsert(ctx context.Context, room *Room) error {
@@ -100,6 +176,62 @@ func (rq *RoomQuery) SetPrevBatch(ctx context.Context, roomID id.RoomID, prevBat
 	return rq.Exec(ctx, setRoomPrevBatchQuery, roomID, prevBatch)
 }
 
+// ClearSortingTimestamp removes the sorting timestamp of the room, which hides it from the room list
+// until a new event bumps the timestamp again.
+func (rq *RoomQuery) ClearSortingTimestamp(ctx context.Context, roomID id.RoomID) error {
+	return rq.Exec(ctx, clearRoomSortingTimestampQuery, roomID)
+}
+
+// MarkForCleanup schedules the local data of the room to be deleted at the given time.
+// If the room is already scheduled for cleanup, the earlier time is kept.
+func (rq *RoomQuery) MarkForCleanup(ctx context.Context, roomID id.RoomID, at time.Time) error {
+	return rq.Exec(ctx, markRoomForCleanupQuery, roomID, at.UnixMilli())
+}
+
+// UnmarkForCleanup cancels the scheduled deletion of the local data of the room, e.g. after it's rejoined.
+func (rq *RoomQuery) UnmarkForCleanup(ctx context.Context, roomID id.RoomID) error {
+	return rq.Exec(ctx, unmarkRoomForCleanupQuery, roomID)
+}
+
+// GetRoomsToCleanUp returns the IDs of rooms whose scheduled cleanup time is before the given time.
+func (rq *RoomQuery) GetRoomsToCleanUp(ctx context.Context, now time.Time) ([]id.RoomID, error) {
+	rows, err := rq.GetDB().Query(ctx, getRoomsToCleanUpQuery, now.UnixMilli())
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
+}
+
+// GetRoomsWithoutCreateEvent returns the IDs of rooms in the room list that don't have a create event
+// in the current state, i.e. rooms whose state was never loaded properly.
+func (rq *RoomQuery) GetRoomsWithoutCreateEvent(ctx context.Context) ([]id.RoomID, error) {
+	rows, err := rq.GetDB().Query(ctx, getRoomsWithoutCreateEventQuery)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
+}
+
+// DeleteIfCleanupDue deletes the room and all local data in it, if the room is still marked for cleanup
+// and the cleanup time is before the given time. Events, state, timeline rows and other room-specific data
+// are deleted by the foreign key cascades, while space edges of the room are deleted explicitly.
+//
+// The cleanup condition is checked in the same query as the delete, so rooms that were rejoined after
+// [RoomQuery.GetRoomsToCleanUp] returned them are not deleted.
+func (rq *RoomQuery) DeleteIfCleanupDue(ctx context.Context, roomID id.RoomID, before time.Time) (deleted bool, err error) {
+	err = rq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		res, err := rq.GetDB().Exec(ctx, deleteRoomIfCleanupDueQuery, roomID, before.UnixMilli())
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+		deleted = true
+		err = rq.Exec(ctx, deleteRoomSpaceEdgesQuery, roomID)
+		if err != nil {
+			return err
+		}
+		return rq.Exec(ctx, clearRoomSpaceParentsQuery, roomID)
+	})
+	return
+}
+
 func (rq *RoomQuery) UpdatePreviewIfLaterOnTimeline(ctx context.Context, roomID id.RoomID, rowID EventRowID) (previewChanged bool, err error) {
 	var newPreviewRowID EventRowID
 	err = rq.GetDB().QueryRow(ctx, updateRoomPreviewIfLaterOnTimelineQuery, roomID, rowID).Scan(&newPreviewRowID)
@@ -116,6 +248,14 @@ func (rq *RoomQuery) RecalculatePreview(ctx context.Context, roomID id.RoomID) (
 	return
 }
 
+// RecalculateUnreadCounts counts the unread events in the room based on the given user's read receipts
+// and the unread flags of the events, then stores and returns the new counts.
+func (rq *RoomQuery) RecalculateUnreadCounts(ctx context.Context, roomID id.RoomID, userID id.UserID) (counts UnreadCounts, err error) {
+	err = rq.GetDB().QueryRow(ctx, recalculateRoomUnreadCountsQuery, roomID, userID).
+		Scan(&counts.UnreadHighlights, &counts.UnreadNotifications, &counts.UnreadMessages)
+	return
+}
+
 type NameQuality int
 
 const (
@@ -147,6 +287,14 @@ type Room struct {
 	SortingTimestamp  jsontime.UnixMilli `json:"sorting_timestamp"`
 
 	PrevBatch string `json:"prev_batch"`
+
+	UnreadCounts
+}
+
+type UnreadCounts struct {
+	UnreadHighlights    int `json:"unread_highlights"`
+	UnreadNotifications int `json:"unread_notifications"`
+	UnreadMessages      int `json:"unread_messages"`
 }
 
 func (r *Room) CheckChangesAndCopyInto(other *Room) (hasChanges bool) {
@@ -213,6 +361,9 @@ func (r *Room) Scan(row dbutil.Scannable) (*Room, error) {
 		&previewEventRowID,
 		&sortingTimestamp,
 		&prevBatch,
+		&r.UnreadHighlights,
+		&r.UnreadNotifications,
+		&r.UnreadMessages,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getSendQueueBaseQuery = `
+		SELECT event_rowid, room_id, attempts, next_attempt FROM send_queue
+	`
+	getSendQueueEntryQuery = getSendQueueBaseQuery + `WHERE event_rowid = $1`
+	// Only the oldest entry in each room is returned to preserve the order of events within the room.
+	getDueSendQueueEntriesQuery = getSendQueueBaseQuery + `
+		WHERE event_rowid IN (SELECT MIN(event_rowid) FROM send_queue GROUP BY room_id) AND next_attempt <= $1
+		ORDER BY event_rowid
+	`
+	getNextSendQueueAttemptQuery = `
+		SELECT MIN(next_attempt) FROM send_queue
+		WHERE event_rowid IN (SELECT MIN(event_rowid) FROM send_queue GROUP BY room_id)
+	`
+	putSendQueueEntryQuery = `
+		INSERT INTO send_queue (event_rowid, room_id, attempts, next_attempt)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_rowid) DO UPDATE
+			SET attempts = excluded.attempts, next_attempt = excluded.next_attempt
+	`
+	deleteSendQueueEntryQuery  = `DELETE FROM send_queue WHERE event_rowid = $1`
+	resetSendQueueBackoffQuery = `UPDATE send_queue SET next_attempt = $1 WHERE next_attempt > $1`
+)
+
+type SendQueueQuery struct {
+	*dbutil.QueryHelper[*SendQueueEntry]
+}
+
+func (sqq *SendQueueQuery) Get(ctx context.Context, rowID EventRowID) (*SendQueueEntry, error) {
+	return sqq.QueryOne(ctx, getSendQueueEntryQuery, rowID)
+}
+
+// GetDue returns the entries that should be sent now. Only the first entry of each room is considered.
+func (sqq *SendQueueQuery) GetDue(ctx context.Context) ([]*SendQueueEntry, error) {
+	return sqq.QueryMany(ctx, getDueSendQueueEntriesQuery, time.Now().UnixMilli())
+}
+
+// GetNextAttempt returns the time when the next entry should be sent, or zero if the queue is empty.
+func (sqq *SendQueueQuery) GetNextAttempt(ctx context.Context) (next time.Time, err error) {
+	var nextMS *int64
+	err = sqq.GetDB().QueryRow(ctx, getNextSendQueueAttemptQuery).Scan(&nextMS)
+	if err == nil && nextMS != nil {
+		next = time.UnixMilli(*nextMS)
+	}
+	return
+}
+
+func (sqq *SendQueueQuery) Put(ctx context.Context, entry *SendQueueEntry) error {
+	return sqq.Exec(ctx, putSendQueueEntryQuery, entry.sqlVariables()...)
+}
+
+func (sqq *SendQueueQuery) Delete(ctx context.Context, rowID EventRowID) error {
+	return sqq.Exec(ctx, deleteSendQueueEntryQuery, rowID)
+}
+
+// ResetBackoff makes all queued entries due immediately.
+func (sqq *SendQueueQuery) ResetBackoff(ctx context.Context) error {
+	return sqq.Exec(ctx, resetSendQueueBackoffQuery, time.Now().UnixMilli())
+}
+
+type SendQueueEntry struct {
+	EventRowID  EventRowID
+	RoomID      id.RoomID
+	Attempts    int
+	NextAttempt jsontime.UnixMilli
+}
+
+func (sqe *SendQueueEntry) Scan(row dbutil.Scannable) (*SendQueueEntry, error) {
+	var nextAttempt int64
+	err := row.Scan(&sqe.EventRowID, &sqe.RoomID, &sqe.Attempts, &nextAttempt)
+	if err != nil {
+		return nil, err
+	}
+	sqe.NextAttempt = jsontime.UM(time.UnixMilli(nextAttempt))
+	return sqe, nil
+}
+
+func (sqe *SendQueueEntry) sqlVariables() []any {
+	return []any{sqe.EventRowID, sqe.RoomID, sqe.Attempts, sqe.NextAttempt.UnixMilli()}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getSpaceEdgeBaseQuery = `
+		SELECT space_id, child_id, child_event_rowid, child_order, suggested, parent_event_rowid, canonical
+		FROM space_edge
+	`
+	// Children with an order come first sorted by the order, as specified in https://spec.matrix.org/v1.11/client-server-api/#ordering-of-children-within-a-space
+	getSpaceChildrenQuery = getSpaceEdgeBaseQuery + `WHERE space_id = $1 ORDER BY child_order = '', child_order, child_id`
+	getSpaceParentsQuery  = getSpaceEdgeBaseQuery + `WHERE child_id = $1 ORDER BY space_id`
+	setSpaceChildQuery    = `
+		INSERT INTO space_edge (space_id, child_id, child_event_rowid, child_order, suggested)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (space_id, child_id) DO UPDATE
+			SET child_event_rowid = excluded.child_event_rowid,
+			    child_order = excluded.child_order,
+			    suggested = excluded.suggested
+	`
+	setSpaceParentQuery = `
+		INSERT INTO space_edge (space_id, child_id, parent_event_rowid, canonical)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (space_id, child_id) DO UPDATE
+			SET parent_event_rowid = excluded.parent_event_rowid,
+			    canonical = excluded.canonical
+	`
+	clearSpaceChildQuery = `
+		UPDATE space_edge SET child_event_rowid = NULL, child_order = '', suggested = false
+		WHERE space_id = $1 AND child_id = $2
+	`
+	clearSpaceParentQuery = `
+		UPDATE space_edge SET parent_event_rowid = NULL, canonical = false
+		WHERE space_id = $1 AND child_id = $2
+	`
+	deleteEmptySpaceEdgeQuery = `
+		DELETE FROM space_edge
+		WHERE space_id = $1 AND child_id = $2 AND child_event_rowid IS NULL AND parent_event_rowid IS NULL
+	`
+)
+
+type SpaceEdgeQuery struct {
+	*dbutil.QueryHelper[*SpaceEdge]
+}
+
+// GetChildren returns the edges from the given space to its children, in the order specified by the space.
+func (seq *SpaceEdgeQuery) GetChildren(ctx context.Context, spaceID id.RoomID) ([]*SpaceEdge, error) {
+	return seq.QueryMany(ctx, getSpaceChildrenQuery, spaceID)
+}
+
+// GetParents returns the edges from all known parent spaces to the given room.
+func (seq *SpaceEdgeQuery) GetParents(ctx context.Context, childID id.RoomID) ([]*SpaceEdge, error) {
+	return seq.QueryMany(ctx, getSpaceParentsQuery, childID)
+}
+
+// SetChild stores the child side of the edge, i.e. the m.space.child event in the space.
+func (seq *SpaceEdgeQuery) SetChild(ctx context.Context, edge *SpaceEdge) error {
+	return seq.Exec(ctx, setSpaceChildQuery, edge.SpaceID, edge.ChildID, edge.ChildEventRowID, edge.Order, edge.Suggested)
+}
+
+// SetParent stores the parent side of the edge, i.e. the m.space.parent event in the child room.
+func (seq *SpaceEdgeQuery) SetParent(ctx context.Context, edge *SpaceEdge) error {
+	return seq.Exec(ctx, setSpaceParentQuery, edge.SpaceID, edge.ChildID, edge.ParentEventRowID, edge.Canonical)
+}
+
+// RemoveChild removes the child side of the edge. The edge is deleted entirely if the parent side isn't set either.
+func (seq *SpaceEdgeQuery) RemoveChild(ctx context.Context, spaceID, childID id.RoomID) error {
+	return seq.removeSide(ctx, clearSpaceChildQuery, spaceID, childID)
+}
+
+// RemoveParent removes the parent side of the edge. The edge is deleted entirely if the child side isn't set either.
+func (seq *SpaceEdgeQuery) RemoveParent(ctx context.Context, spaceID, childID id.RoomID) error {
+	return seq.removeSide(ctx, clearSpaceParentQuery, spaceID, childID)
+}
+
+func (seq *SpaceEdgeQuery) removeSide(ctx context.Context, query string, spaceID, childID id.RoomID) error {
+	err := seq.Exec(ctx, query, spaceID, childID)
+	if err != nil {
+		return err
+	}
+	return seq.Exec(ctx, deleteEmptySpaceEdgeQuery, spaceID, childID)
+}
+
+// SpaceEdge is a link between a space and a child room. Either side of the edge may be missing:
+// spaces list their children with m.space.child events, while rooms can list their parents with m.space.parent.
+type SpaceEdge struct {
+	SpaceID id.RoomID `json:"space_id"`
+	ChildID id.RoomID `json:"child_id"`
+
+	ChildEventRowID EventRowID `json:"child_event_rowid,omitempty"`
+	Order           string     `json:"order,omitempty"`
+	Suggested       bool       `json:"suggested,omitempty"`
+
+	ParentEventRowID EventRowID `json:"parent_event_rowid,omitempty"`
+	Canonical        bool       `json:"canonical,omitempty"`
+}
+
+func (se *SpaceEdge) Scan(row dbutil.Scannable) (*SpaceEdge, error) {
+	var childRowID, parentRowID sql.NullInt64
+	err := row.Scan(&se.SpaceID, &se.ChildID, &childRowID, &se.Order, &se.Suggested, &parentRowID, &se.Canonical)
+	if err != nil {
+		return nil, err
+	}
+	se.ChildEventRowID = EventRowID(childRowID.Int64)
+	se.ParentEventRowID = EventRowID(parentRowID.Int64)
+	return se, nil
+}
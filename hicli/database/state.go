@@ -8,7 +8,9 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exslices"
@@ -31,12 +33,17 @@ const (
 	`
 	getCurrentRoomStateQuery = `
 		SELECT event.rowid, -1, event.room_id, event.event_id, sender, event.type, event.state_key, timestamp, content, decrypted, decrypted_type, unsigned,
-		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid
+		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, url_previews
 		FROM current_state cs
 		JOIN event ON cs.event_rowid = event.rowid
 		WHERE cs.room_id = $1
 	`
-	getCurrentStateEventQuery = getCurrentRoomStateQuery + `AND cs.event_type = $2 AND cs.state_key = $3`
+	getCurrentStateEventQuery  = getCurrentRoomStateQuery + `AND cs.event_type = $2 AND cs.state_key = $3`
+	getCurrentStateOfTypeQuery = getCurrentRoomStateQuery + `AND cs.event_type = $2`
+	getCurrentMembersQuery     = getCurrentRoomStateQuery + `
+		AND cs.event_type = 'm.room.member' AND cs.membership IN (SELECT value FROM json_each($2))
+		AND ($3 = '' OR cs.state_key LIKE '@' || $3 ESCAPE '\' OR event.content ->> 'displayname' LIKE $3 ESCAPE '\')
+	`
 )
 
 var massInsertCurrentStateBuilder = dbutil.NewMassInsertBuilder[*CurrentStateEntry, [1]any](addCurrentStateQuery, "($1, $%d, $%d, $%d, $%d)")
@@ -88,6 +95,25 @@ func (csq *CurrentStateQuery) Get(ctx context.Context, roomID id.RoomID, eventTy
 	return csq.QueryOne(ctx, getCurrentStateEventQuery, roomID, eventType.Type, stateKey)
 }
 
+func (csq *CurrentStateQuery) GetAllOfType(ctx context.Context, roomID id.RoomID, eventType event.Type) ([]*Event, error) {
+	return csq.QueryMany(ctx, getCurrentStateOfTypeQuery, roomID, eventType.Type)
+}
+
 func (csq *CurrentStateQuery) GetAll(ctx context.Context, roomID id.RoomID) ([]*Event, error) {
 	return csq.QueryMany(ctx, getCurrentRoomStateQuery, roomID)
 }
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetMembers returns the current member events in the room with the given memberships.
+// If query is set, only members whose user ID localpart or displayname starts with it are returned.
+func (csq *CurrentStateQuery) GetMembers(ctx context.Context, roomID id.RoomID, memberships []event.Membership, query string) ([]*Event, error) {
+	membershipsJSON, err := json.Marshal(memberships)
+	if err != nil {
+		return nil, err
+	}
+	if query = strings.TrimPrefix(query, "@"); query != "" {
+		query = likeEscaper.Replace(query) + "%"
+	}
+	return csq.QueryMany(ctx, getCurrentMembersQuery, roomID, string(membershipsJSON), query)
+}
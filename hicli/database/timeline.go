@@ -11,6 +11,7 @@ import (
 	"database/sql"
 	"errors"
 	"sync"
+	"time"
 
 	"go.mau.fi/util/dbutil"
 
@@ -21,6 +22,9 @@ const (
 	clearTimelineQuery = `
 		DELETE FROM timeline WHERE room_id = $1
 	`
+	deleteTimelineBeforeQuery = `
+		DELETE FROM timeline WHERE room_id = $1 AND rowid < $2
+	`
 	appendTimelineQuery = `
 		INSERT INTO timeline (room_id, event_rowid) VALUES ($1, $2)
 		ON CONFLICT DO NOTHING
@@ -35,13 +39,22 @@ const (
 	findMinRowIDQuery = `SELECT MIN(rowid) FROM timeline`
 	getTimelineQuery  = `
 		SELECT event.rowid, timeline.rowid, event.room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type, unsigned,
-		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid
+		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, url_previews
 		FROM timeline
 		JOIN event ON event.rowid = timeline.event_rowid
 		WHERE timeline.room_id = $1 AND ($2 = 0 OR timeline.rowid < $2)
 		ORDER BY timeline.rowid DESC
 		LIMIT $3
 	`
+	getOldestTimelineEventSinceQuery = `
+		SELECT event.rowid, timeline.rowid, event.room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type, unsigned,
+		       transaction_id, redacted_by, relates_to, relation_type, megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, url_previews
+		FROM timeline
+		JOIN event ON event.rowid = timeline.event_rowid
+		WHERE timeline.room_id = $1 AND event.timestamp >= $2
+		ORDER BY timeline.rowid ASC
+		LIMIT 1
+	`
 )
 
 type TimelineRowID int64
@@ -76,6 +89,12 @@ func (tq *TimelineQuery) Clear(ctx context.Context, roomID id.RoomID) error {
 	return tq.Exec(ctx, clearTimelineQuery, roomID)
 }
 
+// DeleteBefore removes the timeline entries of a given room that are older than the given timeline row ID.
+// The events themselves are not deleted.
+func (tq *TimelineQuery) DeleteBefore(ctx context.Context, roomID id.RoomID, before TimelineRowID) error {
+	return tq.Exec(ctx, deleteTimelineBeforeQuery, roomID, before)
+}
+
 func (tq *TimelineQuery) reserveRowIDs(ctx context.Context, count int) (startFrom TimelineRowID, err error) {
 	tq.prependLock.Lock()
 	defer tq.prependLock.Unlock()
@@ -128,6 +147,11 @@ func (tq *TimelineQuery) Get(ctx context.Context, roomID id.RoomID, limit int, b
 	return tq.QueryMany(ctx, getTimelineQuery, roomID, before, limit)
 }
 
+// GetOldestSince returns the oldest event in the timeline of a given room that was sent at or after the given time.
+func (tq *TimelineQuery) GetOldestSince(ctx context.Context, roomID id.RoomID, since time.Time) (*Event, error) {
+	return tq.QueryOne(ctx, getOldestTimelineEventSinceQuery, roomID, since.UnixMilli())
+}
+
 func (tq *TimelineQuery) Has(ctx context.Context, roomID id.RoomID, eventRowID EventRowID) (exists bool, err error) {
 	err = tq.GetDB().QueryRow(ctx, checkTimelineContainsQuery, roomID, eventRowID).Scan(&exists)
 	return
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+)
+
+const (
+	getBinaryVersionQuery = `SELECT version FROM binary_version WHERE key=0`
+	putBinaryVersionQuery = `
+		INSERT INTO binary_version (key, version, schema_version, updated_at) VALUES (0, $1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET version=excluded.version, schema_version=excluded.schema_version, updated_at=excluded.updated_at
+	`
+)
+
+type extraSchema struct {
+	versionTable string
+	upgrades     dbutil.UpgradeTable
+}
+
+// RegisterExtraSchema registers an upgrade table for tables owned by the application embedding hicli.
+// The schema version of the extra tables is stored in the given version table, which must not be used by anything else.
+//
+// Extra schemas are upgraded after the hicli schema, so the tables can safely reference hicli tables.
+// This must be called before [Database.Upgrade].
+func (db *Database) RegisterExtraSchema(versionTable string, upgrades dbutil.UpgradeTable) {
+	db.extraSchemas = append(db.extraSchemas, extraSchema{versionTable: versionTable, upgrades: upgrades})
+}
+
+// Upgrade upgrades the hicli schema and all registered extra schemas, then records [Database.BinaryVersion]
+// as the version that last opened the database.
+//
+// If the database has been upgraded by a newer version to a schema this version doesn't support,
+// the [dbutil.ErrUnsupportedDatabaseVersion] error returned by dbutil includes the version that last opened it.
+func (db *Database) Upgrade(ctx context.Context) error {
+	err := db.Database.Upgrade(ctx)
+	if errors.Is(err, dbutil.ErrUnsupportedDatabaseVersion) {
+		var binaryVersion string
+		if db.QueryRow(ctx, getBinaryVersionQuery).Scan(&binaryVersion) == nil {
+			err = fmt.Errorf("%w (last opened by %s)", err, binaryVersion)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+	for _, extra := range db.extraSchemas {
+		err = db.Child(extra.versionTable, extra.upgrades, nil).Upgrade(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade %s schema: %w", extra.versionTable, err)
+		}
+	}
+	_, err = db.Exec(ctx, putBinaryVersionQuery, db.BinaryVersion, len(db.UpgradeTable), time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save binary version: %w", err)
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
--- v0 -> v2 (compatible with v1+): Latest revision
+-- v0 -> v13 (compatible with v1+): Latest revision
 CREATE TABLE account (
-	user_id        TEXT NOT NULL PRIMARY KEY,
-	device_id      TEXT NOT NULL,
-	access_token   TEXT NOT NULL,
-	homeserver_url TEXT NOT NULL,
-
-	next_batch     TEXT NOT NULL
+	user_id          TEXT NOT NULL PRIMARY KEY,
+	device_id        TEXT NOT NULL,
+	access_token     TEXT NOT NULL,
+	homeserver_url   TEXT NOT NULL,
+
+	next_batch       TEXT NOT NULL,
+	sliding_sync_pos TEXT NOT NULL DEFAULT '',
+	to_device_since  TEXT NOT NULL DEFAULT '',
+
+	refresh_token    TEXT NOT NULL DEFAULT '',
+	token_endpoint   TEXT NOT NULL DEFAULT '',
+	oauth_client_id  TEXT NOT NULL DEFAULT ''
 ) STRICT;
 
 CREATE TABLE room (
@@ -28,6 +34,12 @@ CREATE TABLE room (
 
 	prev_batch          TEXT,
 
+	unread_highlights    INTEGER NOT NULL DEFAULT 0,
+	unread_notifications INTEGER NOT NULL DEFAULT 0,
+	unread_messages      INTEGER NOT NULL DEFAULT 0,
+
+	cleanup_at          INTEGER,
+
 	CONSTRAINT room_preview_event_fkey FOREIGN KEY (preview_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
 ) STRICT;
 CREATE INDEX room_type_idx ON room (creation_content ->> 'type');
@@ -79,6 +91,8 @@ CREATE TABLE event (
 
 	reactions         TEXT,
 	last_edit_rowid   INTEGER,
+	unread_type       INTEGER NOT NULL DEFAULT 0,
+	url_previews      TEXT,
 
 	CONSTRAINT event_id_unique_key UNIQUE (event_id),
 	CONSTRAINT transaction_id_unique_key UNIQUE (transaction_id),
@@ -194,8 +208,12 @@ CREATE TABLE cached_media (
 	hash        BLOB,
 	error       TEXT,
 
+	last_accessed INTEGER,
+	pinned        INTEGER NOT NULL DEFAULT false,
+
 	CONSTRAINT cached_media_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
 ) STRICT;
+CREATE INDEX cached_media_hash_idx ON cached_media (hash);
 
 CREATE TABLE session_request (
 	room_id        TEXT    NOT NULL,
@@ -210,6 +228,17 @@ CREATE TABLE session_request (
 ) STRICT;
 CREATE INDEX session_request_room_idx ON session_request (room_id);
 
+CREATE TABLE send_queue (
+	event_rowid  INTEGER PRIMARY KEY,
+	room_id      TEXT    NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+
+	CONSTRAINT send_queue_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT send_queue_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX send_queue_room_idx ON send_queue (room_id, event_rowid);
+
 CREATE TABLE timeline (
 	rowid       INTEGER PRIMARY KEY,
 	room_id     TEXT    NOT NULL,
@@ -233,6 +262,7 @@ CREATE TABLE current_state (
 	CONSTRAINT current_state_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
 	CONSTRAINT current_state_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid)
 ) STRICT, WITHOUT ROWID;
+CREATE INDEX current_state_membership_idx ON current_state (room_id, membership) WHERE membership IS NOT NULL;
 
 CREATE TABLE receipt (
 	room_id      TEXT    NOT NULL,
@@ -246,3 +276,43 @@ CREATE TABLE receipt (
 	CONSTRAINT receipt_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
 	-- note: there's no foreign key on event ID because receipts could point at events that are too far in history.
 ) STRICT;
+
+CREATE TABLE space_edge (
+	space_id           TEXT    NOT NULL,
+	child_id           TEXT    NOT NULL,
+
+	-- Set if the space has a m.space.child event for the child
+	child_event_rowid  INTEGER,
+	child_order        TEXT    NOT NULL DEFAULT '',
+	suggested          INTEGER NOT NULL DEFAULT false,
+
+	-- Set if the child has a m.space.parent event for the space
+	parent_event_rowid INTEGER,
+	canonical          INTEGER NOT NULL DEFAULT false,
+
+	PRIMARY KEY (space_id, child_id),
+	CONSTRAINT space_edge_child_event_fkey FOREIGN KEY (child_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL,
+	CONSTRAINT space_edge_parent_event_fkey FOREIGN KEY (parent_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX space_edge_child_idx ON space_edge (child_id);
+
+CREATE TABLE profile (
+	user_id     TEXT    NOT NULL PRIMARY KEY,
+	displayname TEXT    NOT NULL DEFAULT '',
+	avatar_url  TEXT    NOT NULL DEFAULT '',
+	fetched_at  INTEGER NOT NULL
+) STRICT;
+
+CREATE TABLE url_preview (
+	url        TEXT    NOT NULL PRIMARY KEY,
+	preview    TEXT,
+	error      TEXT,
+	fetched_at INTEGER NOT NULL
+) STRICT;
+
+CREATE TABLE binary_version (
+	key            INTEGER NOT NULL PRIMARY KEY CHECK ( key = 0 ),
+	version        TEXT    NOT NULL,
+	schema_version INTEGER NOT NULL,
+	updated_at     INTEGER NOT NULL
+) STRICT;
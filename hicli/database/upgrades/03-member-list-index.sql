@@ -0,0 +1,2 @@
+-- v3 (compatible with v1+): Add index for member list queries
+CREATE INDEX current_state_membership_idx ON current_state (room_id, membership) WHERE membership IS NOT NULL;
@@ -0,0 +1,11 @@
+-- v4 (compatible with v1+): Add persistent queue for outgoing events
+CREATE TABLE send_queue (
+	event_rowid  INTEGER PRIMARY KEY,
+	room_id      TEXT    NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+
+	CONSTRAINT send_queue_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT send_queue_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX send_queue_room_idx ON send_queue (room_id, event_rowid);
@@ -0,0 +1,34 @@
+-- v5 (compatible with v1+): Add table for tracking the space graph
+CREATE TABLE space_edge (
+	space_id           TEXT    NOT NULL,
+	child_id           TEXT    NOT NULL,
+
+	-- Set if the space has a m.space.child event for the child
+	child_event_rowid  INTEGER,
+	child_order        TEXT    NOT NULL DEFAULT '',
+	suggested          INTEGER NOT NULL DEFAULT false,
+
+	-- Set if the child has a m.space.parent event for the space
+	parent_event_rowid INTEGER,
+	canonical          INTEGER NOT NULL DEFAULT false,
+
+	PRIMARY KEY (space_id, child_id),
+	CONSTRAINT space_edge_child_event_fkey FOREIGN KEY (child_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL,
+	CONSTRAINT space_edge_parent_event_fkey FOREIGN KEY (parent_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX space_edge_child_idx ON space_edge (child_id);
+
+INSERT INTO space_edge (space_id, child_id, child_event_rowid, child_order, suggested)
+SELECT cs.room_id, cs.state_key, cs.event_rowid,
+       COALESCE(event.content ->> '$.order', ''), COALESCE(event.content ->> '$.suggested', false) = true
+FROM current_state cs
+JOIN event ON cs.event_rowid = event.rowid
+WHERE cs.event_type = 'm.space.child' AND cs.state_key LIKE '!%' AND json_array_length(event.content, '$.via') > 0;
+
+INSERT INTO space_edge (space_id, child_id, parent_event_rowid, canonical)
+SELECT cs.state_key, cs.room_id, cs.event_rowid, COALESCE(event.content ->> '$.canonical', false) = true
+FROM current_state cs
+JOIN event ON cs.event_rowid = event.rowid
+WHERE cs.event_type = 'm.space.parent' AND cs.state_key LIKE '!%' AND json_array_length(event.content, '$.via') > 0
+ON CONFLICT (space_id, child_id) DO UPDATE
+	SET parent_event_rowid = excluded.parent_event_rowid, canonical = excluded.canonical;
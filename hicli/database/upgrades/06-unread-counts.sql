@@ -0,0 +1,5 @@
+-- v6 (compatible with v1+): Add push rule evaluation results and unread counts
+ALTER TABLE event ADD COLUMN unread_type INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE room ADD COLUMN unread_highlights INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE room ADD COLUMN unread_notifications INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE room ADD COLUMN unread_messages INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- v7 (compatible with v1+): Add table for caching global profiles
+CREATE TABLE profile (
+	user_id     TEXT    NOT NULL PRIMARY KEY,
+	displayname TEXT    NOT NULL DEFAULT '',
+	avatar_url  TEXT    NOT NULL DEFAULT '',
+	fetched_at  INTEGER NOT NULL
+) STRICT;
@@ -0,0 +1,3 @@
+-- v8 (compatible with v1+): Store sliding sync position separately from /sync next_batch
+ALTER TABLE account ADD COLUMN sliding_sync_pos TEXT NOT NULL DEFAULT '';
+ALTER TABLE account ADD COLUMN to_device_since TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- v9 (compatible with v1+): Add timestamp for deleting local data of left and forgotten rooms
+ALTER TABLE room ADD COLUMN cleanup_at INTEGER;
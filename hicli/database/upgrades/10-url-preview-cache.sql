@@ -0,0 +1,8 @@
+-- v10 (compatible with v1+): Add table for caching URL previews and store fetched previews with events
+CREATE TABLE url_preview (
+	url        TEXT    NOT NULL PRIMARY KEY,
+	preview    TEXT,
+	error      TEXT,
+	fetched_at INTEGER NOT NULL
+) STRICT;
+ALTER TABLE event ADD COLUMN url_previews TEXT;
@@ -0,0 +1,4 @@
+-- v11 (compatible with v1+): Add access time and pin flag for local media cache eviction
+ALTER TABLE cached_media ADD COLUMN last_accessed INTEGER;
+ALTER TABLE cached_media ADD COLUMN pinned INTEGER NOT NULL DEFAULT false;
+CREATE INDEX cached_media_hash_idx ON cached_media (hash);
@@ -0,0 +1,7 @@
+-- v12 (compatible with v1+): Add table for tracking the binary version that last opened the database
+CREATE TABLE binary_version (
+	key            INTEGER NOT NULL PRIMARY KEY CHECK ( key = 0 ),
+	version        TEXT    NOT NULL,
+	schema_version INTEGER NOT NULL,
+	updated_at     INTEGER NOT NULL
+) STRICT;
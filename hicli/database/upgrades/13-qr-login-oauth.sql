@@ -0,0 +1,4 @@
+-- v13 (compatible with v1+): Store OAuth refresh token and client info for QR code logins
+ALTER TABLE account ADD COLUMN refresh_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE account ADD COLUMN token_endpoint TEXT NOT NULL DEFAULT '';
+ALTER TABLE account ADD COLUMN oauth_client_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	getURLPreviewQuery = `
+		SELECT url, preview, error, fetched_at FROM url_preview WHERE url = $1
+	`
+	upsertURLPreviewQuery = `
+		INSERT INTO url_preview (url, preview, error, fetched_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (url) DO UPDATE
+			SET preview = excluded.preview,
+			    error = excluded.error,
+			    fetched_at = excluded.fetched_at
+	`
+	deleteURLPreviewsFetchedBeforeQuery = `
+		DELETE FROM url_preview WHERE fetched_at < $1
+	`
+)
+
+type URLPreviewQuery struct {
+	*dbutil.QueryHelper[*URLPreview]
+}
+
+func (upq *URLPreviewQuery) Get(ctx context.Context, url string) (*URLPreview, error) {
+	return upq.QueryOne(ctx, getURLPreviewQuery, url)
+}
+
+func (upq *URLPreviewQuery) Put(ctx context.Context, preview *URLPreview) error {
+	return upq.Exec(ctx, upsertURLPreviewQuery, preview.sqlVariables()...)
+}
+
+// DeleteFetchedBefore deletes cached previews and errors that were fetched before the given time.
+func (upq *URLPreviewQuery) DeleteFetchedBefore(ctx context.Context, before time.Time) error {
+	return upq.Exec(ctx, deleteURLPreviewsFetchedBeforeQuery, before.UnixMilli())
+}
+
+// URLPreview is a cached URL preview. If fetching the preview failed, Preview is nil and Error is set.
+type URLPreview struct {
+	URL       string             `json:"url"`
+	Preview   *event.LinkPreview `json:"preview,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	FetchedAt jsontime.UnixMilli `json:"fetched_at"`
+}
+
+func (up *URLPreview) Scan(row dbutil.Scannable) (*URLPreview, error) {
+	var fetchedAt int64
+	var errorText sql.NullString
+	err := row.Scan(&up.URL, dbutil.JSON{Data: &up.Preview}, &errorText, &fetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	up.Error = errorText.String
+	up.FetchedAt = jsontime.UM(time.UnixMilli(fetchedAt))
+	return up, nil
+}
+
+func (up *URLPreview) sqlVariables() []any {
+	return []any{up.URL, dbutil.JSONPtr(up.Preview), dbutil.StrPtr(up.Error), up.FetchedAt.UnixMilli()}
+}
@@ -8,11 +8,14 @@ package hicli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/rs/zerolog"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/hicli/database"
@@ -20,21 +23,28 @@ import (
 )
 
 func (h *HiClient) fetchFromKeyBackup(ctx context.Context, roomID id.RoomID, sessionID id.SessionID) (*crypto.InboundGroupSession, error) {
-	data, err := h.Client.GetKeyBackupForRoomAndSession(ctx, h.KeyBackupVersion, roomID, sessionID)
-	if err != nil {
+	version, key := h.getKeyBackup()
+	if version == "" || key == nil {
+		return nil, nil
+	}
+	data, err := h.Client.GetKeyBackupForRoomAndSession(ctx, version, roomID, sessionID)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	} else if data == nil {
 		return nil, nil
 	}
-	decrypted, err := data.SessionData.Decrypt(h.KeyBackupKey)
+	decrypted, err := data.SessionData.Decrypt(key)
 	if err != nil {
 		return nil, err
 	}
-	return h.Crypto.ImportRoomKeyFromBackup(ctx, h.KeyBackupVersion, roomID, sessionID, decrypted)
+	return h.Crypto.ImportRoomKeyFromBackup(ctx, version, roomID, sessionID, decrypted)
 }
 
 func (h *HiClient) handleReceivedMegolmSession(ctx context.Context, roomID id.RoomID, sessionID id.SessionID, firstKnownIndex uint32) {
 	log := zerolog.Ctx(ctx)
+	h.WakeupKeyBackupUploader()
 	err := h.DB.SessionRequest.Remove(ctx, sessionID, firstKnownIndex)
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to remove session request after receiving megolm session")
@@ -60,16 +70,22 @@ func (h *HiClient) handleReceivedMegolmSession(ctx context.Context, roomID id.Ro
 		} else {
 			decrypted = append(decrypted, evt)
 			h.cacheMedia(ctx, mautrixEvt, evt.RowID)
+			evt.UnreadType = h.evaluateUnreadType(ctx, mautrixEvt, evt)
 		}
 	}
 	if len(decrypted) > 0 {
 		var newPreview database.EventRowID
+		var unreadCounts *database.UnreadCounts
 		err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 			for _, evt := range decrypted {
 				err = h.DB.Event.UpdateDecrypted(ctx, evt.RowID, evt.Decrypted, evt.DecryptedType)
 				if err != nil {
 					return fmt.Errorf("failed to save decrypted content for %s: %w", evt.ID, err)
 				}
+				err = h.DB.Event.UpdateUnreadType(ctx, evt.RowID, evt.UnreadType)
+				if err != nil {
+					return fmt.Errorf("failed to save unread type for %s: %w", evt.ID, err)
+				}
 				if evt.CanUseForPreview() {
 					var previewChanged bool
 					previewChanged, err = h.DB.Room.UpdatePreviewIfLaterOnTimeline(ctx, evt.RoomID, evt.RowID)
@@ -80,12 +96,54 @@ func (h *HiClient) handleReceivedMegolmSession(ctx context.Context, roomID id.Ro
 					}
 				}
 			}
+			counts, err := h.DB.Room.RecalculateUnreadCounts(ctx, roomID, h.Account.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to recalculate unread counts: %w", err)
+			}
+			unreadCounts = &counts
 			return nil
 		})
 		if err != nil {
 			log.Err(err).Msg("Failed to save decrypted events")
 		} else {
-			h.EventHandler(&EventsDecrypted{Events: decrypted, PreviewEventRowID: newPreview, RoomID: roomID})
+			h.EventHandler(&EventsDecrypted{Events: decrypted, PreviewEventRowID: newPreview, RoomID: roomID, UnreadCounts: unreadCounts})
+			h.RoomList.refreshRooms(ctx, roomID)
+			var updatedPolls []id.EventID
+			for _, evt := range decrypted {
+				if isPollRelation(evt) && !slices.Contains(updatedPolls, evt.RelatesTo) {
+					updatedPolls = append(updatedPolls, evt.RelatesTo)
+					h.updatePoll(ctx, roomID, evt.RelatesTo)
+				}
+			}
+		}
+	}
+}
+
+// retryFailedDecryptions retries decrypting stored events whose megolm session is already available locally.
+// This catches sessions that were received without the retry completing, e.g. if the client was stopped in between.
+func (h *HiClient) retryFailedDecryptions(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	failedSessions, err := h.DB.Event.GetFailedMegolmSessionIDs(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get megolm sessions of events that failed to decrypt")
+		return
+	}
+	for roomID, sessionIDs := range failedSessions {
+		for _, sessionID := range sessionIDs {
+			sess, err := h.CryptoStore.GetGroupSession(ctx, roomID, sessionID)
+			if err != nil {
+				if !errors.Is(err, crypto.ErrGroupSessionWithheld) {
+					log.Err(err).Stringer("session_id", sessionID).Msg("Failed to get megolm session to retry decryption")
+				}
+				continue
+			} else if sess == nil {
+				continue
+			}
+			log.Debug().
+				Stringer("room_id", roomID).
+				Stringer("session_id", sessionID).
+				Msg("Found stored megolm session for events that failed to decrypt, retrying decryption")
+			h.handleReceivedMegolmSession(ctx, roomID, sessionID, sess.Internal.FirstKnownIndex())
 		}
 	}
 }
@@ -104,6 +162,7 @@ func (h *HiClient) RunRequestQueue(ctx context.Context) {
 	defer func() {
 		log.Info().Msg("Stopping key request queue")
 	}()
+	h.retryFailedDecryptions(ctx)
 	for {
 		err := h.FetchKeysForOutdatedUsers(ctx)
 		if err != nil {
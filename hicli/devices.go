@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrCantDeleteCurrentDevice = errors.New("can't delete the current device, log out instead")
+
+// OwnDevice is a device of the logged-in user as returned by [HiClient.GetOwnDevices].
+type OwnDevice struct {
+	DeviceID   id.DeviceID        `json:"device_id"`
+	Name       string             `json:"name,omitempty"`
+	LastSeenIP string             `json:"last_seen_ip,omitempty"`
+	LastSeenTS jsontime.UnixMilli `json:"last_seen_ts,omitempty"`
+	IsCurrent  bool               `json:"is_current"`
+	// HasKeys is false if the device hasn't uploaded encryption keys, in which case it can't be verified.
+	HasKeys     bool          `json:"has_keys"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Trust       id.TrustState `json:"trust"`
+}
+
+// GetOwnDevices lists the devices of the logged-in user along with their verification status.
+// The current device is always first, followed by the other devices sorted by last seen time.
+func (h *HiClient) GetOwnDevices(ctx context.Context) ([]*OwnDevice, error) {
+	resp, err := h.Client.GetDevicesInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device list: %w", err)
+	}
+	ownUserID := h.Account.UserID
+	var keys map[id.DeviceID]*id.Device
+	fetched, err := h.Crypto.FetchKeys(ctx, []id.UserID{ownUserID}, true)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to fetch own device keys, using stored keys")
+		keys, err = h.CryptoStore.GetDevices(ctx, ownUserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stored device keys: %w", err)
+		}
+	} else {
+		keys = fetched[ownUserID]
+	}
+	devices := make([]*OwnDevice, len(resp.Devices))
+	for i, info := range resp.Devices {
+		device := &OwnDevice{
+			DeviceID:   info.DeviceID,
+			Name:       info.DisplayName,
+			LastSeenIP: info.LastSeenIP,
+			LastSeenTS: jsontime.UM(time.UnixMilli(info.LastSeenTS)),
+			IsCurrent:  info.DeviceID == h.Account.DeviceID,
+			Trust:      id.TrustStateUnset,
+		}
+		if identity, ok := keys[info.DeviceID]; ok {
+			device.HasKeys = true
+			device.Fingerprint = identity.Fingerprint()
+			device.Trust, err = h.Crypto.ResolveTrustContext(ctx, identity)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve trust of %s: %w", info.DeviceID, err)
+			}
+		}
+		devices[i] = device
+	}
+	slices.SortFunc(devices, func(a, b *OwnDevice) int {
+		if a.IsCurrent != b.IsCurrent {
+			if a.IsCurrent {
+				return -1
+			}
+			return 1
+		}
+		return b.LastSeenTS.Compare(a.LastSeenTS.Time)
+	})
+	return devices, nil
+}
+
+// RenameDevice changes the display name of one of the logged-in user's devices.
+func (h *HiClient) RenameDevice(ctx context.Context, deviceID id.DeviceID, name string) error {
+	return h.Client.SetDeviceInfo(ctx, deviceID, &mautrix.ReqDeviceInfo{DisplayName: name})
+}
+
+// DeleteDevices signs out the given devices of the logged-in user. The password is used for
+// user-interactive authentication if the server requires it.
+func (h *HiClient) DeleteDevices(ctx context.Context, deviceIDs []id.DeviceID, password string) error {
+	if slices.Contains(deviceIDs, h.Account.DeviceID) {
+		return ErrCantDeleteCurrentDevice
+	}
+	return h.Client.DeleteDevicesWithUIA(ctx, &mautrix.ReqDeleteDevices{Devices: deviceIDs}, func(uia *mautrix.RespUserInteractive) interface{} {
+		if password == "" || !uia.HasSingleStageFlow(mautrix.AuthTypePassword) {
+			return nil
+		}
+		return &mautrix.ReqUIAuthLogin{
+			BaseAuthData: mautrix.BaseAuthData{
+				Type:    mautrix.AuthTypePassword,
+				Session: uia.Session,
+			},
+			User:     h.Account.UserID.String(),
+			Password: password,
+		}
+	})
+}
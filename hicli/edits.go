@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const editHistoryPageSize = 100
+
+type EditHistory struct {
+	Original *database.Event `json:"original"`
+	// Edits contains the valid edits of the original event, sorted from oldest to newest.
+	Edits []*database.Event `json:"edits"`
+}
+
+// GetEditHistory returns the original event and all edits of it.
+//
+// The edits are fetched from the server using /relations first, so that edits which aren't stored locally
+// (e.g. ones sent before the earliest synced event) are included. If that fails, only the locally stored edits
+// are returned. Edits sent by someone other than the original sender or with a different event type are ignored.
+func (h *HiClient) GetEditHistory(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*EditHistory, error) {
+	original, err := h.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	} else if original.RoomID != roomID {
+		return nil, ErrEventNotFound
+	} else if original.RelationType == event.RelReplace {
+		return nil, fmt.Errorf("can't get the edit history of an edit")
+	}
+	if !strings.HasPrefix(string(eventID), "~") {
+		err = h.fetchEdits(ctx, roomID, eventID)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("event_id", eventID).
+				Msg("Failed to fetch edits from server, returning only local edits")
+		}
+	}
+	related, err := h.DB.Event.GetRelated(ctx, roomID, eventID, event.RelReplace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get edits from database: %w", err)
+	}
+	originalType, _ := getDecryptedContent(original)
+	history := &EditHistory{
+		Original: original,
+		Edits:    make([]*database.Event, 0, len(related)),
+	}
+	for _, edit := range related {
+		// Edits that haven't been decrypted are included, as they may be valid
+		if editType, _ := getDecryptedContent(edit); edit.Sender == original.Sender &&
+			(editType == originalType || editType == event.EventEncrypted.Type) {
+			history.Edits = append(history.Edits, edit)
+		}
+	}
+	return history, nil
+}
+
+func (h *HiClient) fetchEdits(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+	req := &mautrix.ReqGetRelations{
+		RelationType: event.RelReplace,
+		Dir:          mautrix.DirectionForward,
+		Limit:        editHistoryPageSize,
+	}
+	for {
+		resp, err := h.Client.GetRelations(ctx, roomID, eventID, req)
+		if err != nil {
+			return err
+		}
+		for _, evt := range resp.Chunk {
+			evt.RoomID = roomID
+			_, err = h.processEvent(ctx, evt, nil, true)
+			if err != nil {
+				return err
+			}
+		}
+		if resp.NextBatch == "" || len(resp.Chunk) == 0 {
+			return nil
+		}
+		req.From = resp.NextBatch
+	}
+}
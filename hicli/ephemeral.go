@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetTyping returns the users who are currently typing in the given room.
+func (h *HiClient) GetTyping(roomID id.RoomID) []id.UserID {
+	h.ephemeralLock.RLock()
+	defer h.ephemeralLock.RUnlock()
+	return slices.Clone(h.typing[roomID])
+}
+
+// GetPresence returns the last known presence of the given user, or nil if no presence has been received.
+func (h *HiClient) GetPresence(userID id.UserID) *event.PresenceEventContent {
+	h.ephemeralLock.RLock()
+	defer h.ephemeralLock.RUnlock()
+	content, ok := h.presence[userID]
+	if !ok {
+		return nil
+	}
+	contentCopy := *content
+	return &contentCopy
+}
+
+// GetReadReceipts returns the latest read receipt (public or private, in any thread) of each user in the given room.
+//
+// Receipts are loaded from the database the first time a room is requested and are kept up to date from sync after that.
+func (h *HiClient) GetReadReceipts(ctx context.Context, roomID id.RoomID) (map[id.UserID]*database.Receipt, error) {
+	h.ephemeralLock.RLock()
+	cached, ok := h.readReceipts[roomID]
+	if ok {
+		cached = maps.Clone(cached)
+	}
+	h.ephemeralLock.RUnlock()
+	if ok {
+		return cached, nil
+	}
+	receipts, err := h.DB.Receipt.GetRead(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read receipts: %w", err)
+	}
+	h.ephemeralLock.Lock()
+	defer h.ephemeralLock.Unlock()
+	if cached, ok = h.readReceipts[roomID]; ok {
+		// Another call loaded the receipts while we were querying the database
+		return maps.Clone(cached), nil
+	}
+	cached = make(map[id.UserID]*database.Receipt)
+	addReadReceipts(cached, receipts)
+	h.readReceipts[roomID] = cached
+	return maps.Clone(cached), nil
+}
+
+// addReadReceipts stores the given receipts in the map if they're newer than the user's existing read receipt.
+func addReadReceipts(into map[id.UserID]*database.Receipt, receipts []*database.Receipt) bool {
+	changed := false
+	for _, receipt := range receipts {
+		if receipt.ReceiptType != event.ReceiptTypeRead && receipt.ReceiptType != event.ReceiptTypeReadPrivate {
+			continue
+		}
+		existing, ok := into[receipt.UserID]
+		if !ok || existing.Timestamp.Before(receipt.Timestamp.Time) {
+			into[receipt.UserID] = receipt
+			changed = true
+		}
+	}
+	return changed
+}
+
+// dispatchEphemeral updates the in-memory typing, receipt and presence state with the data from a sync
+// and emits the corresponding events. It must only be called after the sync has been saved to the database.
+func (h *HiClient) dispatchEphemeral(syncCtx *syncContext) {
+	h.ephemeralLock.Lock()
+	changedReceipts := make(map[id.RoomID][]*database.Receipt, len(syncCtx.receipts))
+	for roomID, receipts := range syncCtx.receipts {
+		// Rooms that haven't been loaded yet will get the new receipts from the database
+		if cached, ok := h.readReceipts[roomID]; ok && !addReadReceipts(cached, receipts) {
+			continue
+		}
+		changedReceipts[roomID] = receipts
+	}
+	for roomID, userIDs := range syncCtx.typing {
+		if len(userIDs) == 0 {
+			if _, wasTyping := h.typing[roomID]; !wasTyping {
+				delete(syncCtx.typing, roomID)
+			}
+			delete(h.typing, roomID)
+		} else {
+			h.typing[roomID] = userIDs
+		}
+	}
+	for userID, content := range syncCtx.presence {
+		h.presence[userID] = content
+	}
+	h.ephemeralLock.Unlock()
+
+	for roomID, userIDs := range syncCtx.typing {
+		h.EventHandler(&Typing{
+			RoomID:             roomID,
+			TypingEventContent: event.TypingEventContent{UserIDs: userIDs},
+		})
+	}
+	for roomID, receipts := range changedReceipts {
+		h.EventHandler(&ReceiptsUpdated{
+			RoomID:   roomID,
+			Receipts: receipts,
+		})
+	}
+	for userID, content := range syncCtx.presence {
+		h.EventHandler(&PresenceUpdated{
+			UserID:               userID,
+			PresenceEventContent: *content,
+		})
+	}
+}
+
+// SetPresence changes the presence and status message the current user has while the client is active.
+func (h *HiClient) SetPresence(ctx context.Context, presence event.Presence, statusMsg string) error {
+	return h.Presence.SetDesired(ctx, presence, statusMsg)
+}
+
+func (h *HiClient) markActive(ctx context.Context) {
+	err := h.Presence.MarkActive(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to restore presence after being idle")
+	}
+}
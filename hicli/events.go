@@ -7,6 +7,10 @@
 package hicli
 
 import (
+	"encoding/json"
+
+	"go.mau.fi/util/jsontime"
+
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/hicli/database"
 	"maunium.net/go/mautrix/id"
@@ -28,10 +32,13 @@ func (c *SyncComplete) IsEmpty() bool {
 	return len(c.Rooms) == 0
 }
 
+type InitComplete struct{}
+
 type EventsDecrypted struct {
-	RoomID            id.RoomID           `json:"room_id"`
-	PreviewEventRowID database.EventRowID `json:"preview_event_rowid,omitempty"`
-	Events            []*database.Event   `json:"events"`
+	RoomID            id.RoomID              `json:"room_id"`
+	PreviewEventRowID database.EventRowID    `json:"preview_event_rowid,omitempty"`
+	UnreadCounts      *database.UnreadCounts `json:"unread_counts,omitempty"`
+	Events            []*database.Event      `json:"events"`
 }
 
 type Typing struct {
@@ -39,15 +46,181 @@ type Typing struct {
 	event.TypingEventContent
 }
 
+// ReceiptsUpdated is emitted when new receipts are received for a room.
+type ReceiptsUpdated struct {
+	RoomID   id.RoomID           `json:"room_id"`
+	Receipts []*database.Receipt `json:"receipts"`
+}
+
+// PresenceUpdated is emitted when the presence of a user changes.
+type PresenceUpdated struct {
+	UserID id.UserID `json:"user_id"`
+	event.PresenceEventContent
+}
+
 type SendComplete struct {
 	Event *database.Event `json:"event"`
 	Error error           `json:"error"`
+	// Retrying is true if sending failed with a temporary error and the event is still in the send queue.
+	Retrying bool `json:"retrying,omitempty"`
+}
+
+// EventConfirmed is emitted when the remote echo of an event sent by this client is received and merged into
+// the local echo. The row ID of the event stays the same, so frontends can update the existing event in place.
+type EventConfirmed struct {
+	RoomID        id.RoomID           `json:"room_id"`
+	EventRowID    database.EventRowID `json:"event_rowid"`
+	EventID       id.EventID          `json:"event_id"`
+	TransactionID string              `json:"transaction_id"`
+	Timestamp     jsontime.UnixMilli  `json:"timestamp"`
+}
+
+// ReactionsChanged is emitted when the reaction counts of an event are changed locally,
+// e.g. when a reaction is sent or removed, before the change comes down sync.
+type ReactionsChanged struct {
+	RoomID    id.RoomID      `json:"room_id"`
+	EventID   id.EventID     `json:"event_id"`
+	Reactions map[string]int `json:"reactions"`
+}
+
+// EventsRedacted is emitted when events are redacted, so that frontends can drop them from the timeline.
+// The events are included in their redacted form. Events that are only redacted locally while the redaction
+// is being sent will have the transaction ID of the redaction (prefixed with ~) in redacted_by.
+type EventsRedacted struct {
+	RoomID id.RoomID         `json:"room_id"`
+	Events []*database.Event `json:"events"`
+}
+
+// EditQueued is emitted when an edit is sent using [HiClient.EditMessage], before the edit has actually been sent.
+// A SendComplete event will be emitted for the edit event once it has been sent.
+type EditQueued struct {
+	RoomID id.RoomID       `json:"room_id"`
+	Target *database.Event `json:"target"`
+	Edit   *database.Event `json:"edit"`
+}
+
+// MediaUploadProgress is emitted periodically while a file is being uploaded by [HiClient.UploadFile].
+type MediaUploadProgress struct {
+	RoomID   id.RoomID `json:"room_id"`
+	Path     string    `json:"path"`
+	Uploaded int64     `json:"uploaded"`
+	Total    int64     `json:"total"`
+}
+
+// PollUpdated is emitted when the results of a poll change, e.g. when a vote or the end of the poll
+// is sent locally or received from sync.
+type PollUpdated struct {
+	*PollResults
+}
+
+// SpacesChanged is emitted when the children of spaces change, either because a m.space.child event
+// in the space changed or because a room added or removed the space as a parent using m.space.parent.
+type SpacesChanged struct {
+	// Spaces maps space IDs to the full current list of edges to their children.
+	Spaces map[id.RoomID][]*database.SpaceEdge `json:"spaces"`
 }
 
 type ClientState struct {
-	IsLoggedIn    bool        `json:"is_logged_in"`
-	IsVerified    bool        `json:"is_verified"`
-	UserID        id.UserID   `json:"user_id,omitempty"`
-	DeviceID      id.DeviceID `json:"device_id,omitempty"`
-	HomeserverURL string      `json:"homeserver_url,omitempty"`
+	IsLoggedIn       bool                `json:"is_logged_in"`
+	IsVerified       bool                `json:"is_verified"`
+	UserID           id.UserID           `json:"user_id,omitempty"`
+	DeviceID         id.DeviceID         `json:"device_id,omitempty"`
+	HomeserverURL    string              `json:"homeserver_url,omitempty"`
+	KeyBackupVersion id.KeyBackupVersion `json:"key_backup_version,omitempty"`
+}
+
+type LoggedOut struct {
+	UserID     id.UserID `json:"user_id"`
+	AllDevices bool      `json:"all_devices"`
+}
+
+// VerificationRequested is emitted when another device requests interactive verification.
+type VerificationRequested struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	From          id.UserID                    `json:"from"`
+}
+
+type VerificationSASEmoji struct {
+	Emoji       string `json:"emoji"`
+	Description string `json:"description"`
+	// Number is the index of the emoji in the SAS emoji table in the spec, which frontends can use for translations.
+	Number int `json:"number"`
+}
+
+// VerificationSAS is emitted when the short authentication string of a verification should be shown to the user.
+// The user's answer can be passed to [HiClient.RespondSAS] using ConfirmToken or RejectToken.
+type VerificationSAS struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Emojis        []VerificationSASEmoji       `json:"emojis,omitempty"`
+	Decimals      []int                        `json:"decimals,omitempty"`
+	ConfirmToken  string                       `json:"confirm_token"`
+	RejectToken   string                       `json:"reject_token"`
+}
+
+type VerificationCancelled struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Code          event.VerificationCancelCode `json:"code"`
+	Reason        string                       `json:"reason"`
+}
+
+type VerificationDone struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+}
+
+// RoomListChanged is emitted when rooms in the [RoomList] change after it has been requested once.
+// Removed contains the IDs of rooms that were dropped from the list, e.g. because they were forgotten.
+// Order contains the IDs of all rooms in the new order, and is only set if the order changed.
+type RoomListChanged struct {
+	Updated []*RoomListEntry `json:"updated"`
+	Removed []id.RoomID      `json:"removed,omitempty"`
+	Order   []id.RoomID      `json:"order,omitempty"`
+}
+
+// RoomRemoved is emitted when the local data of a left or forgotten room has been deleted.
+// Any data cached for the room should be dropped.
+type RoomRemoved struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+// HistoryPurged is emitted when the local copies of events in a room sent before the given time have been deleted,
+// either by [HiClient.PurgeRoomHistory] or the retention policy. Loaded timelines of the room should be reloaded.
+type HistoryPurged struct {
+	RoomID id.RoomID          `json:"room_id"`
+	Before jsontime.UnixMilli `json:"before"`
+}
+
+// URLPreviews is emitted when previews have been fetched for the links in a received message
+// that didn't have previews bundled by the sender. The previews are also stored in the URLPreviews field of the event.
+type URLPreviews struct {
+	RoomID     id.RoomID                  `json:"room_id"`
+	EventRowID database.EventRowID        `json:"event_rowid"`
+	Previews   []*event.BeeperLinkPreview `json:"previews"`
+}
+
+// PinnedEventsChanged is emitted when the pinned events of a room change in sync.
+type PinnedEventsChanged struct {
+	RoomID id.RoomID    `json:"room_id"`
+	Pinned []id.EventID `json:"pinned"`
+}
+
+// SettingsChanged is emitted when synced client settings in a namespace change,
+// either locally via [HiClient.SetSetting] or by another client in sync.
+type SettingsChanged struct {
+	Namespace string                     `json:"namespace"`
+	Changed   map[string]json.RawMessage `json:"changed,omitempty"`
+	Removed   []string                   `json:"removed,omitempty"`
+}
+
+// IntegrityCheckResult is emitted after the integrity check has finished, which happens in the background
+// after the first sync on startup.
+// Problems is empty if no inconsistencies were found in the local data.
+type IntegrityCheckResult struct {
+	Problems []*IntegrityProblem `json:"problems"`
+}
+
+// ProfileChanged is emitted when the global profile of a user changes. If Profile is nil, the cached profile
+// was invalidated by a member event and should be refetched with [HiClient.GetProfile] if it's needed.
+type ProfileChanged struct {
+	UserID  id.UserID         `json:"user_id"`
+	Profile *database.Profile `json:"profile,omitempty"`
 }
@@ -25,6 +25,8 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/hicli/database"
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/pushrules"
@@ -40,27 +42,87 @@ type HiClient struct {
 	Log         zerolog.Logger
 
 	Verified bool
+	// UseSlidingSync makes the client use simplified sliding sync (MSC4186) instead of /sync if the server supports it.
+	// This must be set before syncing is started.
+	UseSlidingSync bool
+	// DisableURLPreviews disables fetching previews for links in sent and received messages.
+	DisableURLPreviews bool
+	// URLPreviewsInEncryptedRooms enables URL previews in encrypted rooms. Previews are fetched by the homeserver,
+	// which means the links in encrypted messages would be revealed to it, so this is disabled by default.
+	URLPreviewsInEncryptedRooms bool
+	// MediaCacheDir is the directory where files downloaded using [HiClient.DownloadMedia] are stored.
+	MediaCacheDir string
+	// MediaCacheMaxSize is the maximum total size of the media cache in bytes.
+	// Defaults to [DefaultMediaCacheMaxSize] if zero.
+	MediaCacheMaxSize int64
+	// EventRetention is how long events are kept in the local database. Older events are purged periodically
+	// by [HiClient.RunRoomCleanup] and can't be paginated to anymore. Zero means events are kept forever.
+	EventRetention time.Duration
+	// MediaRetention is how long unpinned files are kept in the media cache after they were last accessed.
+	// Zero means files are only evicted when the cache grows past the maximum size.
+	MediaRetention time.Duration
 
+	// KeyBackupVersion and KeyBackupKey are used from multiple goroutines and must only be accessed
+	// while holding keyBackupLock (i.e. using getKeyBackup and setKeyBackup).
 	KeyBackupVersion id.KeyBackupVersion
 	KeyBackupKey     *backup.MegolmBackupKey
+	keyBackupLock    sync.RWMutex
 
 	PushRules atomic.Pointer[pushrules.PushRuleset]
 
+	// Presence maintains the presence of the current user. The user is online while syncing and offline after
+	// the client is stopped. Set Presence.IdleTimeout to enable automatically going unavailable when idle.
+	Presence *mautrix.PresenceManager
+
 	EventHandler func(evt any)
 
+	RoomList *RoomList
+
+	Verification *verificationhelper.VerificationHelper
+
+	syncHandlers           []mautrix.SyncHandler
+	toDeviceHandlers       map[event.Type][]mautrix.EventHandler
+	globalToDeviceHandlers []mautrix.EventHandler
+
 	firstSyncReceived bool
-	syncingID         int
-	syncLock          sync.Mutex
-	stopSync          atomic.Pointer[context.CancelFunc]
-	encryptLock       sync.Mutex
+	// pendingIntegrityProblems contains the results of integrity checks done before syncing started.
+	// If set, the rest of the startup integrity checks are run in the background after the next sync.
+	pendingIntegrityProblems atomic.Pointer[[]*IntegrityProblem]
+	syncingID                int
+	syncLock                 sync.Mutex
+	stopSync                 atomic.Pointer[context.CancelFunc]
+	encryptLock              sync.Mutex
 
 	requestQueueWakeup chan struct{}
+	keyBackupWakeup    chan struct{}
+
+	sendQueueWakeup chan struct{}
+	sendQueueLock   sync.Mutex
+	sendingRowID    database.EventRowID
+	syncFailing     atomic.Bool
+
+	mediaCacheLock sync.Mutex
+
+	ignoredUsers     atomic.Pointer[map[id.UserID]struct{}]
+	ignoredUsersLock sync.Mutex
+	settingsLock     sync.Mutex
+
+	sasTokensLock sync.Mutex
+	sasTokens     map[string]sasResponse
+
+	loginLock       sync.Mutex
+	pendingSSOState string
 
 	jsonRequestsLock sync.Mutex
 	jsonRequests     map[int64]context.CancelCauseFunc
 
 	paginationInterrupterLock sync.Mutex
 	paginationInterrupter     map[id.RoomID]context.CancelCauseFunc
+
+	ephemeralLock sync.RWMutex
+	typing        map[id.RoomID][]id.UserID
+	readReceipts  map[id.RoomID]map[id.UserID]*database.Receipt
+	presence      map[id.UserID]*event.PresenceEventContent
 }
 
 var ErrTimelineReset = errors.New("got limited timeline sync response")
@@ -82,12 +144,19 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 		Log: log,
 
 		requestQueueWakeup:    make(chan struct{}, 1),
+		keyBackupWakeup:       make(chan struct{}, 1),
+		sendQueueWakeup:       make(chan struct{}, 1),
 		jsonRequests:          make(map[int64]context.CancelCauseFunc),
 		paginationInterrupter: make(map[id.RoomID]context.CancelCauseFunc),
+		typing:                make(map[id.RoomID][]id.UserID),
+		readReceipts:          make(map[id.RoomID]map[id.UserID]*database.Receipt),
+		presence:              make(map[id.UserID]*event.PresenceEventContent),
+		toDeviceHandlers:      make(map[event.Type][]mautrix.EventHandler),
 
 		EventHandler: evtHandler,
 	}
 	c.ClientStore = &database.ClientStateStore{Database: db}
+	c.RoomList = newRoomList(c)
 	c.Client = &mautrix.Client{
 		UserAgent: mautrix.DefaultUserAgent,
 		Client: &http.Client{
@@ -112,9 +181,23 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 	c.Crypto.DisableRatchetTracking = true
 	c.Crypto.DisableDecryptKeyFetching = true
 	c.Client.Crypto = (*hiCryptoHelper)(c)
+	c.Presence = c.Client.NewPresenceManager()
+	c.Verification = verificationhelper.NewVerificationHelper(c.Client, c.Crypto, (*hiVerificationCallbacks)(c), false)
+	// Init only registers the event handlers, so it can't fail with the hicli syncer
+	exerrors.PanicIfNotNil(c.Verification.Init(context.Background()))
 	return c
 }
 
+// EnableDatabaseEncryption enables encryption of sensitive values (decrypted event content and the access token)
+// in the client database. The key should come from the platform keystore rather than being stored next to the
+// database. This must be called before Start. Any existing plaintext values are encrypted when the client starts.
+//
+// Alternatively, the entire database can be encrypted by opening it using a SQLCipher-enabled SQLite driver,
+// in which case this is not necessary.
+func (h *HiClient) EnableDatabaseEncryption(key []byte) error {
+	return h.DB.EnableEncryption(key)
+}
+
 func (h *HiClient) IsLoggedIn() bool {
 	return h.Account != nil
 }
@@ -131,6 +214,14 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 	if err != nil {
 		return fmt.Errorf("failed to upgrade crypto db: %w", err)
 	}
+	if h.DB.EncryptionEnabled() {
+		migrated, err := h.DB.EncryptPlaintextRows(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt plaintext rows in hicli db: %w", err)
+		} else if migrated > 0 {
+			zerolog.Ctx(ctx).Info().Int("row_count", migrated).Msg("Encrypted plaintext rows in database")
+		}
+	}
 	account, err := h.DB.Account.Get(ctx, userID)
 	if err != nil {
 		return err
@@ -174,6 +265,16 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 			if err != nil {
 				return err
 			}
+			err = h.loadIgnoredUsers(ctx)
+			if err != nil {
+				return err
+			}
+			// The sync token has to be checked before syncing starts, the rest of the checks are run after the first sync
+			integrityProblems, err := h.checkSyncToken(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to check integrity of local data: %w", err)
+			}
+			h.pendingIntegrityProblems.Store(&integrityProblems)
 			go h.Sync()
 		}
 	}
@@ -215,10 +316,25 @@ func (h *HiClient) Sync() {
 	defer cancel()
 	h.stopSync.Store(&cancel)
 	go h.RunRequestQueue(h.Log.WithContext(ctx))
+	go h.RunSendQueue(h.Log.WithContext(ctx))
+	go h.RunKeyBackupUploader(h.Log.WithContext(ctx))
 	go h.LoadPushRules(h.Log.WithContext(ctx))
+	go h.RunRoomCleanup(h.Log.WithContext(ctx))
 	ctx = log.WithContext(ctx)
-	log.Info().Msg("Starting syncing")
-	err := h.Client.SyncWithContext(ctx)
+	go func() {
+		err := h.Presence.Start(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to set presence")
+		}
+	}()
+	var err error
+	if h.ShouldUseSlidingSync() {
+		log.Info().Msg("Starting sliding sync")
+		err = h.runSlidingSync(ctx)
+	} else {
+		log.Info().Msg("Starting syncing")
+		err = h.Client.SyncWithContext(ctx)
+	}
 	if err != nil && ctx.Err() == nil {
 		log.Err(err).Msg("Fatal error in syncer")
 	} else {
@@ -236,13 +352,21 @@ func (h *HiClient) LoadPushRules(ctx context.Context) {
 	zerolog.Ctx(ctx).Debug().Msg("Updated push rules from fetch")
 }
 
-func (h *HiClient) Stop() {
+func (h *HiClient) stopSyncing() {
 	h.Client.StopSync()
 	if fn := h.stopSync.Swap(nil); fn != nil {
 		(*fn)()
 	}
 	h.syncLock.Lock()
 	h.syncLock.Unlock()
+	err := h.Presence.Stop(h.Log.WithContext(context.Background()))
+	if err != nil {
+		h.Log.Err(err).Msg("Failed to set presence to offline")
+	}
+}
+
+func (h *HiClient) Stop() {
+	h.stopSyncing()
 	err := h.DB.Close()
 	if err != nil {
 		h.Log.Err(err).Msg("Failed to close database cleanly")
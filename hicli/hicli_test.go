@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const testUserID = id.UserID("@alice:example.com")
+
+type testEventCollector struct {
+	lock   sync.Mutex
+	events []any
+}
+
+func (tec *testEventCollector) handle(evt any) {
+	tec.lock.Lock()
+	tec.events = append(tec.events, evt)
+	tec.lock.Unlock()
+}
+
+func (tec *testEventCollector) get() []any {
+	tec.lock.Lock()
+	defer tec.lock.Unlock()
+	return append([]any(nil), tec.events...)
+}
+
+// newTestClient creates a logged-in client with an in-memory database.
+// Requests to the homeserver are answered with an empty event ID response.
+func newTestClient(t *testing.T) (*HiClient, *testEventCollector) {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000&_foreign_keys=on")
+	require.NoError(t, err)
+	// Every connection to :memory: gets its own database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"event_id": "$remote"}`))
+	}))
+	t.Cleanup(server.Close)
+	collector := &testEventCollector{}
+	h := New(db, nil, zerolog.New(zerolog.NewTestWriter(t)), []byte("test"), collector.handle)
+	require.NoError(t, h.DB.Upgrade(context.Background()))
+	h.Account = &database.Account{UserID: testUserID, DeviceID: "DEVICE"}
+	h.Client.UserID = testUserID
+	h.Client.HomeserverURL, _ = url.Parse(server.URL)
+	return h, collector
+}
+
+func insertTestRoom(t *testing.T, h *HiClient, roomID id.RoomID) {
+	t.Helper()
+	require.NoError(t, h.DB.Room.CreateRow(context.Background(), roomID))
+}
+
+func insertTestEvent(t *testing.T, h *HiClient, evt *event.Event) *database.Event {
+	t.Helper()
+	var err error
+	evt.Content.VeryRaw, err = json.Marshal(evt.Content.Parsed)
+	require.NoError(t, err)
+	if evt.Timestamp == 0 {
+		evt.Timestamp = time.Now().UnixMilli()
+	}
+	dbEvt := database.MautrixToEvent(evt)
+	_, err = h.DB.Event.Insert(context.Background(), dbEvt)
+	require.NoError(t, err)
+	return dbEvt
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrInvalidReportScore = errors.New("report score must be between -100 and 0")
+
+// ReportEvent reports an event to the homeserver admins. The score ranges from -100 (most offensive) to 0 (inoffensive).
+func (h *HiClient) ReportEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string, score int) error {
+	if score < -100 || score > 0 {
+		return ErrInvalidReportScore
+	}
+	return h.Client.ReportEvent(ctx, roomID, eventID, reason, score)
+}
+
+// GetIgnoredUsers returns the IDs of all users the current user has ignored.
+func (h *HiClient) GetIgnoredUsers() []id.UserID {
+	ignored := h.ignoredUsers.Load()
+	if ignored == nil {
+		return []id.UserID{}
+	}
+	userIDs := make([]id.UserID, 0, len(*ignored))
+	for userID := range *ignored {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// IgnoreUser adds the given user to the ignored user list. Events from ignored users received in sync are hidden
+// from the timeline immediately, even if the server hasn't started filtering them yet.
+func (h *HiClient) IgnoreUser(ctx context.Context, userID id.UserID) error {
+	return h.updateIgnoredUsers(ctx, func(users map[id.UserID]event.IgnoredUser) bool {
+		if _, alreadyIgnored := users[userID]; alreadyIgnored {
+			return false
+		}
+		users[userID] = event.IgnoredUser{}
+		return true
+	})
+}
+
+// UnignoreUser removes the given user from the ignored user list.
+func (h *HiClient) UnignoreUser(ctx context.Context, userID id.UserID) error {
+	return h.updateIgnoredUsers(ctx, func(users map[id.UserID]event.IgnoredUser) bool {
+		if _, ignored := users[userID]; !ignored {
+			return false
+		}
+		delete(users, userID)
+		return true
+	})
+}
+
+func (h *HiClient) updateIgnoredUsers(ctx context.Context, update func(map[id.UserID]event.IgnoredUser) bool) error {
+	h.ignoredUsersLock.Lock()
+	defer h.ignoredUsersLock.Unlock()
+	var content event.IgnoredUserListEventContent
+	_, err := h.getAccountDataContent(ctx, event.AccountDataIgnoredUserList, &content)
+	if err != nil {
+		return err
+	}
+	if content.IgnoredUsers == nil {
+		content.IgnoredUsers = make(map[id.UserID]event.IgnoredUser)
+	}
+	if !update(content.IgnoredUsers) {
+		return nil
+	}
+	err = h.setAccountData(ctx, event.AccountDataIgnoredUserList, &content)
+	if err != nil {
+		return fmt.Errorf("failed to update ignored user list: %w", err)
+	}
+	h.setIgnoredUsers(&content)
+	return nil
+}
+
+func (h *HiClient) loadIgnoredUsers(ctx context.Context) error {
+	var content event.IgnoredUserListEventContent
+	_, err := h.getAccountDataContent(ctx, event.AccountDataIgnoredUserList, &content)
+	if err != nil {
+		return err
+	}
+	h.setIgnoredUsers(&content)
+	return nil
+}
+
+func (h *HiClient) setIgnoredUsers(content *event.IgnoredUserListEventContent) {
+	users := make(map[id.UserID]struct{}, len(content.IgnoredUsers))
+	for userID := range content.IgnoredUsers {
+		users[userID] = struct{}{}
+	}
+	h.ignoredUsers.Store(&users)
+}
+
+// IsIgnored returns true if the given user is on the current user's ignored user list.
+func (h *HiClient) IsIgnored(userID id.UserID) bool {
+	ignored := h.ignoredUsers.Load()
+	if ignored == nil {
+		return false
+	}
+	_, isIgnored := (*ignored)[userID]
+	return isIgnored
+}
+
+// splitIgnoredEvents separates non-state events sent by ignored users from the given list.
+// State events are never hidden, as they're needed to keep the room state correct.
+func (h *HiClient) splitIgnoredEvents(evts []*event.Event) (visible, ignored []*event.Event) {
+	if ignoredUsers := h.ignoredUsers.Load(); ignoredUsers == nil || len(*ignoredUsers) == 0 {
+		return evts, nil
+	}
+	visible = make([]*event.Event, 0, len(evts))
+	for _, evt := range evts {
+		if evt.StateKey == nil && evt.Sender != h.Account.UserID && h.IsIgnored(evt.Sender) {
+			ignored = append(ignored, evt)
+		} else {
+			visible = append(visible, evt)
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHiClient_SplitIgnoredEvents(t *testing.T) {
+	h, _ := newTestClient(t)
+	const ignoredUser = id.UserID("@spam:example.com")
+	stateKey := ""
+	evts := []*event.Event{
+		{ID: "$1", Sender: "@bob:example.com"},
+		{ID: "$2", Sender: ignoredUser},
+		{ID: "$3", Sender: ignoredUser, StateKey: &stateKey},
+	}
+
+	visible, ignored := h.splitIgnoredEvents(evts)
+	assert.Len(t, visible, 3)
+	assert.Empty(t, ignored)
+
+	h.setIgnoredUsers(&event.IgnoredUserListEventContent{IgnoredUsers: map[id.UserID]event.IgnoredUser{ignoredUser: {}}})
+	visible, ignored = h.splitIgnoredEvents(evts)
+	assert.Equal(t, []*event.Event{evts[0], evts[2]}, visible, "state events shouldn't be hidden")
+	assert.Equal(t, []*event.Event{evts[1]}, ignored)
+	assert.Len(t, evts, 3, "input list shouldn't be modified")
+
+	evt := &event.Event{ID: "$4", Sender: ignoredUser, Type: event.EventMessage, RoomID: testRoomID}
+	assert.Equal(t, database.UnreadTypeNone, h.evaluateUnreadType(context.Background(), evt, database.MautrixToEvent(evt)))
+}
+
+func TestHiClient_ReportEvent_InvalidScore(t *testing.T) {
+	h, _ := newTestClient(t)
+	assert.ErrorIs(t, h.ReportEvent(context.Background(), testRoomID, "$event", "spam", 10), ErrInvalidReportScore)
+	assert.NoError(t, h.ReportEvent(context.Background(), testRoomID, "$event", "spam", -50))
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const DefaultInitialSyncBatchSize = 100
+
+func newEmptySyncRoom(room *database.Room) *SyncRoom {
+	return &SyncRoom{
+		Meta:     room,
+		Timeline: make([]database.TimelineRowTuple, 0),
+		State:    make(map[event.Type]map[string]database.EventRowID),
+		Events:   make([]*database.Event, 0, 1),
+	}
+}
+
+func (h *HiClient) getInitialSyncRoom(ctx context.Context, room *database.Room) *SyncRoom {
+	syncRoom := newEmptySyncRoom(room)
+	if room.PreviewEventRowID != 0 {
+		previewEvent, err := h.DB.Event.GetByRowID(ctx, room.PreviewEventRowID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", room.ID).Msg("Failed to get preview event for room")
+		} else if previewEvent != nil {
+			syncRoom.Events = append(syncRoom.Events, previewEvent)
+		}
+	}
+	return syncRoom
+}
+
+// GetInitialSync returns a function that yields lightweight summaries of all rooms in batches,
+// starting from the most recently active room. Each batch only contains the room metadata and
+// preview event: clients should use PaginateRoomState to load the state and timeline of a room
+// when it's actually opened.
+//
+// The returned function has the same signature as iter.Seq[*SyncComplete].
+func (h *HiClient) GetInitialSync(ctx context.Context, batchSize int) func(yield func(*SyncComplete) bool) {
+	if batchSize <= 0 {
+		batchSize = DefaultInitialSyncBatchSize
+	}
+	return func(yield func(*SyncComplete) bool) {
+		maxTS := time.Now().Add(1 * time.Hour)
+		var maxRoomID id.RoomID
+		for {
+			rooms, err := h.DB.Room.GetBySortTS(ctx, maxTS, maxRoomID, batchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					zerolog.Ctx(ctx).Err(err).Msg("Failed to get initial rooms to send to client")
+				}
+				return
+			}
+			payload := &SyncComplete{Rooms: make(map[id.RoomID]*SyncRoom, len(rooms))}
+			for _, room := range rooms {
+				payload.Rooms[room.ID] = h.getInitialSyncRoom(ctx, room)
+				maxTS = room.SortingTimestamp.Time
+				maxRoomID = room.ID
+			}
+			if (len(rooms) > 0 && !yield(payload)) || len(rooms) < batchSize {
+				return
+			}
+		}
+	}
+}
+
+// DispatchInitialSync sends the initial room summaries to the event handler as a series of [SyncComplete]
+// events, followed by an [InitComplete] event once all rooms have been sent. JSON clients can trigger it
+// with the request_initial_sync command.
+func (h *HiClient) DispatchInitialSync(ctx context.Context, batchSize int) {
+	h.GetInitialSync(ctx, batchSize)(func(payload *SyncComplete) bool {
+		h.EventHandler(payload)
+		return ctx.Err() == nil
+	})
+	if ctx.Err() == nil {
+		h.EventHandler(&InitComplete{})
+	}
+}
+
+// PaginateRoomState returns the full current state and the latest timeline events of a single room.
+// It's meant to be used for hydrating rooms that were only sent as summaries by GetInitialSync.
+func (h *HiClient) PaginateRoomState(ctx context.Context, roomID id.RoomID, timelineLimit int) (*SyncRoom, error) {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	syncRoom := newEmptySyncRoom(room)
+	state, err := h.DB.CurrentState.GetAll(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room state: %w", err)
+	}
+	for _, evt := range state {
+		evtType := event.Type{Type: evt.Type, Class: event.StateEventType}
+		if syncRoom.State[evtType] == nil {
+			syncRoom.State[evtType] = make(map[string]database.EventRowID)
+		}
+		syncRoom.State[evtType][*evt.StateKey] = evt.RowID
+		syncRoom.Events = append(syncRoom.Events, evt)
+	}
+	if timelineLimit > 0 {
+		timeline, err := h.DB.Timeline.Get(ctx, roomID, timelineLimit, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room timeline: %w", err)
+		}
+		syncRoom.Timeline = make([]database.TimelineRowTuple, len(timeline))
+		// The database returns the newest events first, but sync payloads are in chronological order
+		for i, evt := range timeline {
+			syncRoom.Timeline[len(timeline)-i-1] = database.TimelineRowTuple{Timeline: evt.TimelineRowID, Event: evt.RowID}
+			syncRoom.Events = append(syncRoom.Events, evt)
+		}
+	}
+	return syncRoom, nil
+}
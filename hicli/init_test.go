@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHiClient_DispatchInitialSync_SameTimestamp(t *testing.T) {
+	ctx := context.Background()
+	h, collector := newTestClient(t)
+	var allRooms []id.RoomID
+	for i := 0; i < 5; i++ {
+		roomID := id.RoomID(fmt.Sprintf("!room%d:example.com", i))
+		insertTestRoom(t, h, roomID)
+		// Most rooms share a timestamp, so batches end in the middle of a timestamp
+		ts := 1000
+		if i == 4 {
+			ts = 2000
+		}
+		_, err := h.DB.Exec(ctx, "UPDATE room SET sorting_timestamp=$2 WHERE room_id=$1", roomID, ts)
+		require.NoError(t, err)
+		allRooms = append(allRooms, roomID)
+	}
+
+	h.DispatchInitialSync(ctx, 2)
+	events := collector.get()
+	require.Len(t, events, 4)
+	var dispatched []id.RoomID
+	for _, evt := range events[:3] {
+		payload, ok := evt.(*SyncComplete)
+		require.True(t, ok)
+		for roomID := range payload.Rooms {
+			dispatched = append(dispatched, roomID)
+		}
+	}
+	assert.ElementsMatch(t, allRooms, dispatched)
+	assert.IsType(t, &InitComplete{}, events[3])
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// IntegrityMaxStateRepairs is the maximum number of rooms whose state is refetched from the server
+// during a single startup integrity check. The remaining rooms are repaired on the next startup,
+// or lazily when their state is requested.
+var IntegrityMaxStateRepairs = 50
+
+type IntegrityCheck string
+
+const (
+	IntegrityCheckSyncToken     IntegrityCheck = "sync_token"
+	IntegrityCheckCryptoAccount IntegrityCheck = "crypto_account"
+	IntegrityCheckRoomState     IntegrityCheck = "room_state"
+)
+
+// IntegrityProblem is an inconsistency found in the local data by [HiClient.CheckIntegrity].
+type IntegrityProblem struct {
+	Check       IntegrityCheck `json:"check"`
+	RoomID      id.RoomID      `json:"room_id,omitempty"`
+	Message     string         `json:"message"`
+	Repaired    bool           `json:"repaired"`
+	RepairError string         `json:"repair_error,omitempty"`
+}
+
+type integrityCheckFunc func(context.Context) ([]*IntegrityProblem, error)
+
+// CheckIntegrity validates invariants of the local data and attempts to repair the problems it finds.
+// It's called automatically in the background after the first sync on startup, and the results are
+// reported to the event handler as an [IntegrityCheckResult].
+//
+// Errors are only returned if the checks themselves couldn't be run. Failed repairs are included in the result.
+func (h *HiClient) CheckIntegrity(ctx context.Context) (*IntegrityCheckResult, error) {
+	return h.checkIntegrity(ctx, nil, h.checkSyncToken, h.checkCryptoAccount, h.checkRoomState)
+}
+
+// finishStartupIntegrityCheck runs the integrity checks that don't need to be done before syncing starts.
+// The problems found by the checks that were done before syncing are included in the result.
+func (h *HiClient) finishStartupIntegrityCheck(ctx context.Context, problems []*IntegrityProblem) {
+	_, err := h.checkIntegrity(ctx, problems, h.checkCryptoAccount, h.checkRoomState)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check integrity of local data")
+	}
+}
+
+func (h *HiClient) checkIntegrity(ctx context.Context, problems []*IntegrityProblem, checks ...integrityCheckFunc) (*IntegrityCheckResult, error) {
+	log := zerolog.Ctx(ctx).With().Str("action", "check integrity").Logger()
+	ctx = log.WithContext(ctx)
+	result := &IntegrityCheckResult{Problems: append(make([]*IntegrityProblem, 0, len(problems)), problems...)}
+	for _, check := range checks {
+		found, err := check(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.Problems = append(result.Problems, found...)
+	}
+	for _, problem := range result.Problems {
+		log.Warn().
+			Str("check", string(problem.Check)).
+			Stringer("room_id", problem.RoomID).
+			Bool("repaired", problem.Repaired).
+			Str("repair_error", problem.RepairError).
+			Msg(problem.Message)
+	}
+	log.Debug().Int("problem_count", len(result.Problems)).Msg("Integrity check finished")
+	h.EventHandler(result)
+	return result, nil
+}
+
+func (h *HiClient) checkSyncToken(ctx context.Context) ([]*IntegrityProblem, error) {
+	if h.Account.NextBatch != "" || h.Account.SlidingSyncPos != "" {
+		return nil, nil
+	}
+	rooms, err := h.DB.Room.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if rooms exist: %w", err)
+	} else if len(rooms) == 0 {
+		return nil, nil
+	}
+	// Losing the sync token isn't fatal, the next sync will just be an initial sync that refills everything.
+	return []*IntegrityProblem{{
+		Check:    IntegrityCheckSyncToken,
+		Message:  "Sync token is missing even though rooms are stored locally, next sync will be an initial sync",
+		Repaired: true,
+	}}, nil
+}
+
+func (h *HiClient) checkCryptoAccount(ctx context.Context) ([]*IntegrityProblem, error) {
+	account, err := h.CryptoStore.GetAccount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get crypto account: %w", err)
+	} else if account == nil {
+		if !h.Verified {
+			return nil, nil
+		}
+		return []*IntegrityProblem{{
+			Check:   IntegrityCheckCryptoAccount,
+			Message: "Crypto account is missing for verified device",
+		}}, nil
+	}
+	ownDevice, err := h.CryptoStore.GetDevice(ctx, h.Account.UserID, h.Account.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get own device from crypto store: %w", err)
+	} else if ownDevice == nil {
+		return nil, nil
+	}
+	// The account can't be repaired without logging in again, as the device keys can't be changed on the server
+	if ownDevice.IdentityKey != account.IdentityKey() || ownDevice.SigningKey != account.SigningKey() {
+		return []*IntegrityProblem{{
+			Check: IntegrityCheckCryptoAccount,
+			Message: fmt.Sprintf(
+				"Stored crypto account doesn't match keys of device %s (expected %s, got %s)",
+				h.Account.DeviceID, ownDevice.IdentityKey, account.IdentityKey(),
+			),
+		}}, nil
+	}
+	return nil, nil
+}
+
+func (h *HiClient) checkRoomState(ctx context.Context) ([]*IntegrityProblem, error) {
+	roomIDs, err := h.DB.Room.GetRoomsWithoutCreateEvent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find rooms without create event: %w", err)
+	}
+	problems := make([]*IntegrityProblem, len(roomIDs))
+	for i, roomID := range roomIDs {
+		problem := &IntegrityProblem{
+			Check:   IntegrityCheckRoomState,
+			RoomID:  roomID,
+			Message: "Room has no create event in current state",
+		}
+		problems[i] = problem
+		if i >= IntegrityMaxStateRepairs {
+			problem.RepairError = "too many rooms to repair"
+			continue
+		}
+		_, err = h.GetRoomState(ctx, roomID, false, true)
+		if err != nil {
+			problem.RepairError = err.Error()
+		} else {
+			problem.Repaired = true
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHiClient_FinishStartupIntegrityCheck(t *testing.T) {
+	h, collector := newTestClient(t)
+	ctx := h.Log.WithContext(context.Background())
+	require.NoError(t, h.CryptoStore.DB.Upgrade(ctx))
+	// The sync token check is done before the first sync, as the sync will store a new token
+	problems := []*IntegrityProblem{{Check: IntegrityCheckSyncToken, Message: "Sync token is missing", Repaired: true}}
+	h.Account.NextBatch = "s_next"
+
+	h.finishStartupIntegrityCheck(ctx, problems)
+	events := collector.get()
+	require.Len(t, events, 1)
+	result, ok := events[0].(*IntegrityCheckResult)
+	require.True(t, ok)
+	require.Len(t, result.Problems, 1, "problems from before the sync should be included in the result")
+	assert.Equal(t, IntegrityCheckSyncToken, result.Problems[0].Check)
+}
@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/attachment"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/hicli/database"
 	"maunium.net/go/mautrix/id"
@@ -42,10 +44,90 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *sendMessageParams) (*database.Event, error) {
 			return h.SendMessage(ctx, params.RoomID, params.Text, params.MediaPath, params.ReplyTo, params.Mentions)
 		})
+	case "edit_message":
+		return unmarshalAndCall(req.Data, func(params *editMessageParams) (*database.Event, error) {
+			return h.EditMessage(ctx, params.RoomID, params.EventID, params.Text)
+		})
+	case "get_edit_history":
+		return unmarshalAndCall(req.Data, func(params *getEventParams) (*EditHistory, error) {
+			return h.GetEditHistory(ctx, params.RoomID, params.EventID)
+		})
+	case "send_reaction":
+		return unmarshalAndCall(req.Data, func(params *reactionParams) (*database.Event, error) {
+			return h.SendReaction(ctx, params.RoomID, params.EventID, params.Key)
+		})
+	case "remove_reaction":
+		return unmarshalAndCall(req.Data, func(params *reactionParams) (*database.Event, error) {
+			return h.RemoveReaction(ctx, params.RoomID, params.EventID, params.Key)
+		})
+	case "start_poll":
+		return unmarshalAndCall(req.Data, func(params *startPollParams) (*database.Event, error) {
+			return h.StartPoll(ctx, params.RoomID, params.Question, params.Answers, params.MaxSelections, params.Disclosed)
+		})
+	case "vote_poll":
+		return unmarshalAndCall(req.Data, func(params *votePollParams) (*database.Event, error) {
+			return h.VotePoll(ctx, params.RoomID, params.EventID, params.Answers)
+		})
+	case "end_poll":
+		return unmarshalAndCall(req.Data, func(params *getEventParams) (*database.Event, error) {
+			return h.EndPoll(ctx, params.RoomID, params.EventID)
+		})
+	case "get_poll_results":
+		return unmarshalAndCall(req.Data, func(params *getEventParams) (*PollResults, error) {
+			return h.GetPollResults(ctx, params.RoomID, params.EventID)
+		})
+	case "get_read_receipts":
+		return unmarshalAndCall(req.Data, func(params *roomIDParams) (map[id.UserID]*database.Receipt, error) {
+			return h.GetReadReceipts(ctx, params.RoomID)
+		})
+	case "set_presence":
+		return unmarshalAndCall(req.Data, func(params *setPresenceParams) (bool, error) {
+			return true, h.SetPresence(ctx, params.Presence, params.StatusMsg)
+		})
+	case "get_presence":
+		return unmarshalAndCall(req.Data, func(params *getPresenceParams) (*event.PresenceEventContent, error) {
+			return h.GetPresence(params.UserID), nil
+		})
+	case "redact_event":
+		return unmarshalAndCall(req.Data, func(params *redactEventParams) (*database.Event, error) {
+			return h.RedactEvent(ctx, params.RoomID, params.EventID, params.Reason)
+		})
 	case "send_event":
 		return unmarshalAndCall(req.Data, func(params *sendEventParams) (*database.Event, error) {
 			return h.Send(ctx, params.RoomID, params.EventType, params.Content)
 		})
+	case "send_to_device":
+		return unmarshalAndCall(req.Data, func(params *sendToDeviceParams) (*mautrix.RespSendToDevice, error) {
+			return h.SendToDevice(ctx, params.EventType, params.Targets, params.Content, params.Encrypted)
+		})
+	case "retry_send":
+		return unmarshalAndCall(req.Data, func(params *sendQueueParams) (bool, error) {
+			return true, h.RetrySend(ctx, params.TransactionID)
+		})
+	case "cancel_send":
+		return unmarshalAndCall(req.Data, func(params *sendQueueParams) (bool, error) {
+			return true, h.CancelSend(ctx, params.TransactionID)
+		})
+	case "send_sticker":
+		return unmarshalAndCall(req.Data, func(params *sendStickerParams) (*database.Event, error) {
+			return h.SendSticker(ctx, params.RoomID, params.Sticker, params.ReplyTo)
+		})
+	case "get_sticker_packs":
+		return unmarshalAndCall(req.Data, func(params *getStickerPacksParams) ([]*StickerPack, error) {
+			return h.GetStickerPacks(ctx, params.RoomID)
+		})
+	case "set_personal_sticker_pack":
+		return unmarshalAndCall(req.Data, func(params *event.ImagePackEventContent) (bool, error) {
+			return true, h.SetPersonalStickerPack(ctx, params)
+		})
+	case "add_sticker_pack":
+		return unmarshalAndCall(req.Data, func(params *stickerPackParams) (bool, error) {
+			return true, h.AddStickerPack(ctx, params.RoomID, params.StateKey)
+		})
+	case "remove_sticker_pack":
+		return unmarshalAndCall(req.Data, func(params *stickerPackParams) (bool, error) {
+			return true, h.RemoveStickerPack(ctx, params.RoomID, params.StateKey)
+		})
 	case "mark_read":
 		return unmarshalAndCall(req.Data, func(params *markReadParams) (bool, error) {
 			return true, h.MarkRead(ctx, params.RoomID, params.EventID, params.ReceiptType)
@@ -66,6 +148,31 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *getRoomStateParams) ([]*database.Event, error) {
 			return h.GetRoomState(ctx, params.RoomID, params.FetchMembers, params.Refetch)
 		})
+	case "get_state_event":
+		return unmarshalAndCall(req.Data, func(params *getStateEventParams) (*event.Event, error) {
+			return h.GetStateEvent(ctx, params.RoomID, params.EventType, params.StateKey)
+		})
+	case "get_all_members":
+		return unmarshalAndCall(req.Data, func(params *roomIDParams) (map[id.UserID]*event.MemberEventContent, error) {
+			return h.GetAllMembers(ctx, params.RoomID)
+		})
+	case "get_space_hierarchy":
+		return unmarshalAndCall(req.Data, func(params *roomIDParams) (*SpaceHierarchy, error) {
+			return h.GetSpaceHierarchy(ctx, params.RoomID)
+		})
+	case "get_member_list":
+		return unmarshalAndCall(req.Data, func(params *getMemberListParams) (*MemberListResponse, error) {
+			return h.GetMemberList(ctx, params.RoomID, params.Memberships, params.Query, params.Offset, params.Limit)
+		})
+	case "request_initial_sync":
+		return unmarshalAndCall(req.Data, func(params *requestInitialSyncParams) (bool, error) {
+			h.DispatchInitialSync(ctx, params.BatchSize)
+			return ctx.Err() == nil, ctx.Err()
+		})
+	case "paginate_room_state":
+		return unmarshalAndCall(req.Data, func(params *paginateRoomStateParams) (*SyncRoom, error) {
+			return h.PaginateRoomState(ctx, params.RoomID, params.TimelineLimit)
+		})
 	case "paginate":
 		return unmarshalAndCall(req.Data, func(params *paginateParams) (*PaginationResponse, error) {
 			return h.Paginate(ctx, params.RoomID, params.MaxTimelineID, params.Limit)
@@ -74,6 +181,18 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *paginateParams) (*PaginationResponse, error) {
 			return h.PaginateServer(ctx, params.RoomID, params.Limit)
 		})
+	case "get_event_near_timestamp":
+		return unmarshalAndCall(req.Data, func(params *getEventNearTimestampParams) (*database.Event, error) {
+			return h.GetEventNearTimestamp(ctx, params.RoomID, time.UnixMilli(params.Timestamp), params.Direction)
+		})
+	case "get_event_context":
+		return unmarshalAndCall(req.Data, func(params *getEventContextParams) (*EventContextResponse, error) {
+			return h.GetEventContext(ctx, params.RoomID, params.EventID, params.Limit)
+		})
+	case "paginate_from_token":
+		return unmarshalAndCall(req.Data, func(params *paginateFromTokenParams) (*AnchoredPaginationResponse, error) {
+			return h.PaginateFromToken(ctx, params.RoomID, params.Token, params.Direction, params.Limit)
+		})
 	case "ensure_group_session_shared":
 		return unmarshalAndCall(req.Data, func(params *ensureGroupSessionSharedParams) (bool, error) {
 			return true, h.EnsureGroupSessionShared(ctx, params.RoomID)
@@ -82,10 +201,252 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *loginParams) (bool, error) {
 			return true, h.LoginPassword(ctx, params.HomeserverURL, params.Username, params.Password)
 		})
+	case "get_login_flows":
+		return unmarshalAndCall(req.Data, func(params *getLoginFlowsParams) (*LoginFlows, error) {
+			return h.GetLoginFlows(ctx, params.Homeserver)
+		})
+	case "get_sso_redirect_url":
+		return unmarshalAndCall(req.Data, func(params *getSSORedirectURLParams) (string, error) {
+			return h.GetSSORedirectURL(params.IdentityProviderID, params.RedirectURL)
+		})
+	case "finish_sso_login":
+		return unmarshalAndCall(req.Data, func(params *finishSSOLoginParams) (bool, error) {
+			return true, h.FinishSSOLogin(ctx, params.CallbackURL)
+		})
+	case "login_token":
+		return unmarshalAndCall(req.Data, func(params *loginTokenParams) (bool, error) {
+			return true, h.LoginToken(ctx, params.Token)
+		})
+	case "logout":
+		return unmarshalAndCall(req.Data, func(params *logoutParams) (bool, error) {
+			if params.AllDevices {
+				return true, h.LogoutAllDevices(ctx)
+			}
+			return true, h.Logout(ctx)
+		})
 	case "verify":
 		return unmarshalAndCall(req.Data, func(params *verifyParams) (bool, error) {
 			return true, h.VerifyWithRecoveryKey(ctx, params.RecoveryKey)
 		})
+	case "get_trust_graph":
+		return unmarshalAndCall(req.Data, func(params *getTrustGraphParams) (*crypto.TrustGraph, error) {
+			return h.Crypto.GetTrustGraph(ctx, params.UserIDs)
+		})
+	case "create_key_backup":
+		return unmarshalAndCall(req.Data, func(params *verifyParams) (bool, error) {
+			return true, h.CreateKeyBackup(ctx, params.RecoveryKey)
+		})
+	case "restore_key_backup":
+		return true, h.RestoreKeyBackup(ctx)
+	case "get_room_list":
+		return unmarshalAndCall(req.Data, func(params *getRoomListParams) ([]*RoomListEntry, error) {
+			return h.RoomList.Get(ctx, params.Order, params.Filter)
+		})
+	case "get_profile":
+		return unmarshalAndCall(req.Data, func(params *getProfileParams) (*database.Profile, error) {
+			return h.GetProfile(ctx, params.UserID)
+		})
+	case "download_media":
+		return unmarshalAndCall(req.Data, func(params *downloadMediaParams) (*downloadMediaResponse, error) {
+			cached, path, err := h.DownloadMedia(ctx, params.MXC, params.EncryptedFile)
+			if err != nil {
+				return nil, err
+			}
+			return &downloadMediaResponse{Path: path, MimeType: cached.MimeType, Size: cached.Size}, nil
+		})
+	case "get_media_cache_stats":
+		return h.GetMediaCacheStats(ctx)
+	case "clear_media_cache":
+		return true, h.ClearMediaCache(ctx)
+	case "purge_media":
+		return unmarshalAndCall(req.Data, func(params *purgeMediaParams) (bool, error) {
+			return true, h.PurgeMedia(ctx, time.UnixMilli(params.AccessedBefore))
+		})
+	case "pin_media":
+		return unmarshalAndCall(req.Data, func(params *pinMediaParams) (bool, error) {
+			return true, h.PinMedia(ctx, params.MXC, params.Pinned)
+		})
+	case "get_url_preview":
+		return unmarshalAndCall(req.Data, func(params *getURLPreviewParams) (*event.LinkPreview, error) {
+			return h.GetURLPreview(ctx, params.URL)
+		})
+	case "set_displayname":
+		return unmarshalAndCall(req.Data, func(params *setDisplayNameParams) (bool, error) {
+			return true, h.SetDisplayName(ctx, params.Displayname)
+		})
+	case "set_avatar_url":
+		return unmarshalAndCall(req.Data, func(params *setAvatarURLParams) (bool, error) {
+			return true, h.SetAvatarURL(ctx, params.AvatarURL)
+		})
+	case "set_avatar_from_file":
+		return unmarshalAndCall(req.Data, func(params *setAvatarFromFileParams) (id.ContentURIString, error) {
+			return h.SetAvatarFromFile(ctx, params.Path)
+		})
+	case "create_room":
+		return unmarshalAndCall(req.Data, func(params *createRoomParams) (id.RoomID, error) {
+			return h.CreateRoom(ctx, params.Room, params.Encrypted)
+		})
+	case "create_dm":
+		return unmarshalAndCall(req.Data, func(params *createDMParams) (id.RoomID, error) {
+			return h.CreateDM(ctx, params.UserID, params.Encrypted)
+		})
+	case "invite_user":
+		return unmarshalAndCall(req.Data, func(params *roomMemberParams) (bool, error) {
+			return true, h.InviteUser(ctx, params.RoomID, params.UserID, params.Reason)
+		})
+	case "kick_user":
+		return unmarshalAndCall(req.Data, func(params *roomMemberParams) (bool, error) {
+			return true, h.KickUser(ctx, params.RoomID, params.UserID, params.Reason)
+		})
+	case "ban_user":
+		return unmarshalAndCall(req.Data, func(params *roomMemberParams) (bool, error) {
+			return true, h.BanUser(ctx, params.RoomID, params.UserID, params.Reason)
+		})
+	case "unban_user":
+		return unmarshalAndCall(req.Data, func(params *roomMemberParams) (bool, error) {
+			return true, h.UnbanUser(ctx, params.RoomID, params.UserID, params.Reason)
+		})
+	case "get_pinned_events":
+		return unmarshalAndCall(req.Data, func(params *roomIDParams) ([]id.EventID, error) {
+			return h.GetPinnedEvents(ctx, params.RoomID)
+		})
+	case "pin_event":
+		return unmarshalAndCall(req.Data, func(params *pinEventParams) (bool, error) {
+			return true, h.PinEvent(ctx, params.RoomID, params.EventID)
+		})
+	case "unpin_event":
+		return unmarshalAndCall(req.Data, func(params *pinEventParams) (bool, error) {
+			return true, h.UnpinEvent(ctx, params.RoomID, params.EventID)
+		})
+	case "set_room_name":
+		return unmarshalAndCall(req.Data, func(params *roomSettingParams) (bool, error) {
+			return true, h.SetRoomName(ctx, params.RoomID, params.Value)
+		})
+	case "set_room_topic":
+		return unmarshalAndCall(req.Data, func(params *roomSettingParams) (bool, error) {
+			return true, h.SetRoomTopic(ctx, params.RoomID, params.Value)
+		})
+	case "set_room_avatar":
+		return unmarshalAndCall(req.Data, func(params *roomSettingParams) (bool, error) {
+			return true, h.SetRoomAvatar(ctx, params.RoomID, id.ContentURIString(params.Value))
+		})
+	case "set_join_rule":
+		return unmarshalAndCall(req.Data, func(params *roomSettingParams) (bool, error) {
+			return true, h.SetJoinRule(ctx, params.RoomID, event.JoinRule(params.Value))
+		})
+	case "set_history_visibility":
+		return unmarshalAndCall(req.Data, func(params *roomSettingParams) (bool, error) {
+			return true, h.SetHistoryVisibility(ctx, params.RoomID, event.HistoryVisibility(params.Value))
+		})
+	case "set_user_power_level":
+		return unmarshalAndCall(req.Data, func(params *setUserPowerLevelParams) (bool, error) {
+			return true, h.SetUserPowerLevel(ctx, params.RoomID, params.UserID, params.Level)
+		})
+	case "set_favourite":
+		return unmarshalAndCall(req.Data, func(params *setSectionTagParams) (bool, error) {
+			return true, h.SetFavourite(ctx, params.RoomID, params.Enabled)
+		})
+	case "set_low_priority":
+		return unmarshalAndCall(req.Data, func(params *setSectionTagParams) (bool, error) {
+			return true, h.SetLowPriority(ctx, params.RoomID, params.Enabled)
+		})
+	case "move_room_in_tag":
+		return unmarshalAndCall(req.Data, func(params *moveRoomInTagParams) (bool, error) {
+			return true, h.MoveRoomInTag(ctx, params.RoomID, params.Tag, params.AfterRoomID)
+		})
+	case "get_settings":
+		return unmarshalAndCall(req.Data, func(params *settingsParams) (map[string]json.RawMessage, error) {
+			return h.GetSettings(ctx, params.Namespace)
+		})
+	case "set_setting":
+		return unmarshalAndCall(req.Data, func(params *settingsParams) (bool, error) {
+			var value any
+			if len(params.Value) > 0 && string(params.Value) != "null" {
+				value = params.Value
+			}
+			return true, h.SetSetting(ctx, params.Namespace, params.Key, value)
+		})
+	case "report_event":
+		return unmarshalAndCall(req.Data, func(params *reportEventParams) (bool, error) {
+			return true, h.ReportEvent(ctx, params.RoomID, params.EventID, params.Reason, params.Score)
+		})
+	case "get_ignored_users":
+		return h.GetIgnoredUsers(), nil
+	case "ignore_user":
+		return unmarshalAndCall(req.Data, func(params *userIDParams) (bool, error) {
+			return true, h.IgnoreUser(ctx, params.UserID)
+		})
+	case "unignore_user":
+		return unmarshalAndCall(req.Data, func(params *userIDParams) (bool, error) {
+			return true, h.UnignoreUser(ctx, params.UserID)
+		})
+	case "join_room":
+		return unmarshalAndCall(req.Data, func(params *joinRoomParams) (id.RoomID, error) {
+			return h.JoinRoom(ctx, params.RoomIDOrAlias, params.Via, params.Reason)
+		})
+	case "knock_room":
+		return unmarshalAndCall(req.Data, func(params *joinRoomParams) (id.RoomID, error) {
+			return h.KnockRoom(ctx, params.RoomIDOrAlias, params.Via, params.Reason)
+		})
+	case "leave_room":
+		return unmarshalAndCall(req.Data, func(params *leaveRoomParams) (bool, error) {
+			return true, h.LeaveRoom(ctx, params.RoomID, params.Reason, params.Forget)
+		})
+	case "forget_room":
+		return unmarshalAndCall(req.Data, func(params *roomIDParams) (bool, error) {
+			return true, h.ForgetRoom(ctx, params.RoomID)
+		})
+	case "purge_room_history":
+		return unmarshalAndCall(req.Data, func(params *purgeRoomHistoryParams) (bool, error) {
+			var before time.Time
+			if params.Before > 0 {
+				before = time.UnixMilli(params.Before)
+			}
+			return true, h.PurgeRoomHistory(ctx, params.RoomID, before)
+		})
+	case "export_room_keys":
+		return unmarshalAndCall(req.Data, func(params *exportRoomKeysParams) (string, error) {
+			data, err := h.ExportRoomKeys(ctx, params.Passphrase, params.RoomID)
+			return string(data), err
+		})
+	case "import_room_keys":
+		return unmarshalAndCall(req.Data, func(params *importRoomKeysParams) (*KeyImportResult, error) {
+			return h.ImportRoomKeys(ctx, []byte(params.Data), params.Passphrase)
+		})
+	case "get_own_devices":
+		return h.GetOwnDevices(ctx)
+	case "rename_device":
+		return unmarshalAndCall(req.Data, func(params *renameDeviceParams) (bool, error) {
+			return true, h.RenameDevice(ctx, params.DeviceID, params.Name)
+		})
+	case "delete_devices":
+		return unmarshalAndCall(req.Data, func(params *deleteDevicesParams) (bool, error) {
+			return true, h.DeleteDevices(ctx, params.DeviceIDs, params.Password)
+		})
+	case "start_device_verification":
+		return unmarshalAndCall(req.Data, func(params *startDeviceVerificationParams) (id.VerificationTransactionID, error) {
+			return h.StartDeviceVerification(ctx, params.DeviceID)
+		})
+	case "accept_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.AcceptVerification(ctx, params.TransactionID)
+		})
+	case "start_sas":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.StartSAS(ctx, params.TransactionID)
+		})
+	case "confirm_sas":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.ConfirmSAS(ctx, params.TransactionID)
+		})
+	case "respond_sas":
+		return unmarshalAndCall(req.Data, func(params *respondSASParams) (bool, error) {
+			return true, h.RespondSAS(ctx, params.Token)
+		})
+	case "cancel_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.CancelVerification(ctx, params.TransactionID, event.VerificationCancelCodeUser, params.Reason)
+		})
 	case "discover_homeserver":
 		return unmarshalAndCall(req.Data, func(params *discoverHomeserverParams) (*mautrix.ClientWellKnown, error) {
 			_, homeserver, err := params.UserID.Parse()
@@ -121,12 +482,83 @@ type sendMessageParams struct {
 	Mentions  *event.Mentions `json:"mentions"`
 }
 
+type editMessageParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Text    string     `json:"text"`
+}
+
+type reactionParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Key     string     `json:"key"`
+}
+
+type startPollParams struct {
+	RoomID        id.RoomID `json:"room_id"`
+	Question      string    `json:"question"`
+	Answers       []string  `json:"answers"`
+	MaxSelections int       `json:"max_selections"`
+	Disclosed     bool      `json:"disclosed"`
+}
+
+type votePollParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Answers []string   `json:"answers"`
+}
+
+type roomIDParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+type setPresenceParams struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg,omitempty"`
+}
+
+type getPresenceParams struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type redactEventParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Reason  string     `json:"reason"`
+}
+
 type sendEventParams struct {
 	RoomID    id.RoomID       `json:"room_id"`
 	EventType event.Type      `json:"type"`
 	Content   json.RawMessage `json:"content"`
 }
 
+type sendToDeviceParams struct {
+	EventType event.Type                  `json:"type"`
+	Targets   map[id.UserID][]id.DeviceID `json:"targets"`
+	Content   json.RawMessage             `json:"content"`
+	Encrypted bool                        `json:"encrypted"`
+}
+
+type sendQueueParams struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+type sendStickerParams struct {
+	RoomID  id.RoomID             `json:"room_id"`
+	Sticker *event.ImagePackImage `json:"sticker"`
+	ReplyTo id.EventID            `json:"reply_to"`
+}
+
+type getStickerPacksParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+type stickerPackParams struct {
+	RoomID   id.RoomID `json:"room_id"`
+	StateKey string    `json:"state_key"`
+}
+
 type markReadParams struct {
 	RoomID      id.RoomID         `json:"room_id"`
 	EventID     id.EventID        `json:"event_id"`
@@ -153,6 +585,48 @@ type getRoomStateParams struct {
 	FetchMembers bool      `json:"fetch_members"`
 }
 
+type getStateEventParams struct {
+	RoomID    id.RoomID  `json:"room_id"`
+	EventType event.Type `json:"type"`
+	StateKey  string     `json:"state_key"`
+}
+
+type getMemberListParams struct {
+	RoomID      id.RoomID          `json:"room_id"`
+	Memberships []event.Membership `json:"memberships"`
+	Query       string             `json:"query"`
+	Offset      int                `json:"offset"`
+	Limit       int                `json:"limit"`
+}
+
+type getEventNearTimestampParams struct {
+	RoomID    id.RoomID         `json:"room_id"`
+	Timestamp int64             `json:"timestamp"`
+	Direction mautrix.Direction `json:"direction"`
+}
+
+type getEventContextParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Limit   int        `json:"limit"`
+}
+
+type paginateFromTokenParams struct {
+	RoomID    id.RoomID         `json:"room_id"`
+	Token     string            `json:"token"`
+	Direction mautrix.Direction `json:"direction"`
+	Limit     int               `json:"limit"`
+}
+
+type requestInitialSyncParams struct {
+	BatchSize int `json:"batch_size"`
+}
+
+type paginateRoomStateParams struct {
+	RoomID        id.RoomID `json:"room_id"`
+	TimelineLimit int       `json:"timeline_limit"`
+}
+
 type ensureGroupSessionSharedParams struct {
 	RoomID id.RoomID `json:"room_id"`
 }
@@ -163,10 +637,190 @@ type loginParams struct {
 	Password      string `json:"password"`
 }
 
+type getLoginFlowsParams struct {
+	Homeserver string `json:"homeserver"`
+}
+
+type getSSORedirectURLParams struct {
+	IdentityProviderID string `json:"idp_id,omitempty"`
+	RedirectURL        string `json:"redirect_url"`
+}
+
+type finishSSOLoginParams struct {
+	CallbackURL string `json:"callback_url"`
+}
+
+type loginTokenParams struct {
+	Token string `json:"token"`
+}
+
+type logoutParams struct {
+	AllDevices bool `json:"all_devices"`
+}
+
 type verifyParams struct {
 	RecoveryKey string `json:"recovery_key"`
 }
 
+type getTrustGraphParams struct {
+	UserIDs []id.UserID `json:"user_ids"`
+}
+
+type getRoomListParams struct {
+	Order  RoomListOrder   `json:"order"`
+	Filter *RoomListFilter `json:"filter,omitempty"`
+}
+
+type getProfileParams struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type downloadMediaParams struct {
+	MXC           id.ContentURI             `json:"mxc"`
+	EncryptedFile *attachment.EncryptedFile `json:"encrypted_file,omitempty"`
+}
+
+type downloadMediaResponse struct {
+	Path     string `json:"path"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+type pinMediaParams struct {
+	MXC    id.ContentURI `json:"mxc"`
+	Pinned bool          `json:"pinned"`
+}
+
+type purgeMediaParams struct {
+	AccessedBefore int64 `json:"accessed_before"`
+}
+
+type purgeRoomHistoryParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Before int64     `json:"before,omitempty"`
+}
+
+type getURLPreviewParams struct {
+	URL string `json:"url"`
+}
+
+type setDisplayNameParams struct {
+	Displayname string `json:"displayname"`
+}
+
+type setAvatarURLParams struct {
+	AvatarURL id.ContentURIString `json:"avatar_url"`
+}
+
+type setAvatarFromFileParams struct {
+	Path string `json:"path"`
+}
+
+type createRoomParams struct {
+	Room      *mautrix.ReqCreateRoom `json:"room"`
+	Encrypted bool                   `json:"encrypted"`
+}
+
+type createDMParams struct {
+	UserID    id.UserID `json:"user_id"`
+	Encrypted bool      `json:"encrypted"`
+}
+
+type roomMemberParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	UserID id.UserID `json:"user_id"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type pinEventParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+}
+
+type roomSettingParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Value  string    `json:"value"`
+}
+
+type setUserPowerLevelParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	UserID id.UserID `json:"user_id"`
+	Level  int       `json:"level"`
+}
+
+type setSectionTagParams struct {
+	RoomID  id.RoomID `json:"room_id"`
+	Enabled bool      `json:"enabled"`
+}
+
+type moveRoomInTagParams struct {
+	RoomID      id.RoomID     `json:"room_id"`
+	Tag         event.RoomTag `json:"tag"`
+	AfterRoomID id.RoomID     `json:"after_room_id,omitempty"`
+}
+
+type settingsParams struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+type userIDParams struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type reportEventParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Reason  string     `json:"reason,omitempty"`
+	Score   int        `json:"score,omitempty"`
+}
+
+type joinRoomParams struct {
+	RoomIDOrAlias string   `json:"room_id_or_alias"`
+	Via           []string `json:"via,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+}
+
+type leaveRoomParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Reason string    `json:"reason,omitempty"`
+	Forget bool      `json:"forget,omitempty"`
+}
+
+type exportRoomKeysParams struct {
+	Passphrase string    `json:"passphrase"`
+	RoomID     id.RoomID `json:"room_id,omitempty"`
+}
+
+type importRoomKeysParams struct {
+	Data       string `json:"data"`
+	Passphrase string `json:"passphrase"`
+}
+
+type renameDeviceParams struct {
+	DeviceID id.DeviceID `json:"device_id"`
+	Name     string      `json:"name"`
+}
+
+type deleteDevicesParams struct {
+	DeviceIDs []id.DeviceID `json:"device_ids"`
+	Password  string        `json:"password"`
+}
+
+type startDeviceVerificationParams struct {
+	DeviceID id.DeviceID `json:"device_id"`
+}
+
+type verificationParams struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Reason        string                       `json:"reason,omitempty"`
+}
+
+type respondSASParams struct {
+	Token string `json:"token"`
+}
+
 type discoverHomeserverParams struct {
 	UserID id.UserID `json:"user_id"`
 }
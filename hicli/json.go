@@ -31,14 +31,60 @@ func (jeh JSONEventHandler) HandleEvent(evt any) {
 	switch evt.(type) {
 	case *SyncComplete:
 		command = "sync_complete"
+	case *InitComplete:
+		command = "init_complete"
 	case *EventsDecrypted:
 		command = "events_decrypted"
 	case *Typing:
 		command = "typing"
+	case *ReceiptsUpdated:
+		command = "receipts_updated"
+	case *PresenceUpdated:
+		command = "presence_updated"
 	case *SendComplete:
 		command = "send_complete"
+	case *EventConfirmed:
+		command = "event_confirmed"
+	case *ReactionsChanged:
+		command = "reactions_changed"
+	case *EventsRedacted:
+		command = "events_redacted"
+	case *EditQueued:
+		command = "edit_queued"
+	case *MediaUploadProgress:
+		command = "media_upload_progress"
+	case *PollUpdated:
+		command = "poll_updated"
+	case *SpacesChanged:
+		command = "spaces_changed"
 	case *ClientState:
 		command = "client_state"
+	case *LoggedOut:
+		command = "logged_out"
+	case *ProfileChanged:
+		command = "profile_changed"
+	case *RoomListChanged:
+		command = "room_list_changed"
+	case *RoomRemoved:
+		command = "room_removed"
+	case *HistoryPurged:
+		command = "history_purged"
+	case *URLPreviews:
+		command = "url_previews"
+	case *PinnedEventsChanged:
+		command = "pinned_events_changed"
+	case *SettingsChanged:
+		command = "settings_changed"
+	case *IntegrityCheckResult:
+		command = "integrity_check_result"
+	case *VerificationRequested:
+		command = "verification_requested"
+	case *VerificationSAS:
+		command = "verification_sas"
+	case *VerificationCancelled:
+		command = "verification_cancelled"
+	case *VerificationDone:
+		command = "verification_done"
 	default:
 		panic(fmt.Errorf("unknown event type %T", evt))
 	}
@@ -61,6 +107,7 @@ func (h *HiClient) State() *ClientState {
 		state.DeviceID = acc.DeviceID
 		state.HomeserverURL = acc.HomeserverURL
 		state.IsVerified = h.Verified
+		state.KeyBackupVersion, _ = h.getKeyBackup()
 	}
 	return state
 }
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetEventNearTimestamp finds the event closest to the given timestamp in the given direction
+// (forward = the first event at or after the timestamp, backward = the last event at or before it).
+//
+// The server's timestamp_to_event endpoint is used when available. If the request fails
+// (e.g. because the server doesn't support it), the local database is searched instead,
+// which only finds events that have already been fetched. A nil event is returned if nothing was found.
+func (h *HiClient) GetEventNearTimestamp(ctx context.Context, roomID id.RoomID, ts time.Time, dir mautrix.Direction) (*database.Event, error) {
+	resp, err := h.Client.TimestampToEvent(ctx, roomID, ts, dir)
+	if err == nil {
+		return h.GetEvent(ctx, roomID, resp.EventID)
+	} else if ctx.Err() != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Warn().Err(err).
+		Stringer("room_id", roomID).
+		Msg("Failed to find event by timestamp on server, falling back to local database")
+	evt, err := h.DB.Event.GetClosestByTimestamp(ctx, roomID, ts, dir == mautrix.DirectionForward)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find event by timestamp in database: %w", err)
+	}
+	return evt, nil
+}
+
+type EventContextResponse struct {
+	// Events contains the anchor event and the events around it in chronological order.
+	Events []*database.Event `json:"events"`
+	// AnchorRowID is the row ID of the event that the context was requested for.
+	AnchorRowID database.EventRowID `json:"anchor_rowid"`
+	// BeforeToken and AfterToken can be passed to [HiClient.PaginateFromToken] to load more events
+	// before the first event or after the last event respectively. They're empty if there's nothing more to load.
+	BeforeToken string `json:"before_token"`
+	AfterToken  string `json:"after_token"`
+}
+
+// GetEventContext fetches events around the given event from the server, so that a timeline can be
+// shown starting from an arbitrary point (e.g. one found with [HiClient.GetEventNearTimestamp]).
+//
+// The events are stored in the database, but they are not added to the room's local timeline,
+// because there may be a gap between them and the events that have been synced.
+func (h *HiClient) GetEventContext(ctx context.Context, roomID id.RoomID, eventID id.EventID, limit int) (*EventContextResponse, error) {
+	resp, err := h.Client.Context(ctx, roomID, eventID, nil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event context from server: %w", err)
+	} else if resp.Event == nil {
+		return nil, fmt.Errorf("server didn't return the requested event")
+	}
+	slices.Reverse(resp.EventsBefore)
+	evts := make([]*event.Event, 0, len(resp.EventsBefore)+1+len(resp.EventsAfter))
+	evts = append(evts, resp.EventsBefore...)
+	evts = append(evts, resp.Event)
+	evts = append(evts, resp.EventsAfter...)
+	dbEvts, err := h.processAnchoredEvents(ctx, roomID, evts)
+	if err != nil {
+		return nil, err
+	}
+	return &EventContextResponse{
+		Events:      dbEvts,
+		AnchorRowID: dbEvts[len(resp.EventsBefore)].RowID,
+		BeforeToken: resp.Start,
+		AfterToken:  resp.End,
+	}, nil
+}
+
+type AnchoredPaginationResponse struct {
+	// Events contains the fetched events in chronological order.
+	Events []*database.Event `json:"events"`
+	// NextToken can be used to continue paginating in the same direction.
+	// It's empty if there are no more events in that direction.
+	NextToken string `json:"next_token"`
+}
+
+// PaginateFromToken loads events in the given direction starting from a token returned by
+// [HiClient.GetEventContext] or a previous call to this method.
+//
+// Like GetEventContext, the events are not added to the room's local timeline.
+func (h *HiClient) PaginateFromToken(ctx context.Context, roomID id.RoomID, token string, dir mautrix.Direction, limit int) (*AnchoredPaginationResponse, error) {
+	resp, err := h.Client.Messages(ctx, roomID, token, "", dir, nil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages from server: %w", err)
+	}
+	if dir == mautrix.DirectionBackward {
+		slices.Reverse(resp.Chunk)
+	}
+	dbEvts, err := h.processAnchoredEvents(ctx, roomID, resp.Chunk)
+	if err != nil {
+		return nil, err
+	}
+	nextToken := resp.End
+	if len(resp.Chunk) == 0 {
+		nextToken = ""
+	}
+	return &AnchoredPaginationResponse{Events: dbEvts, NextToken: nextToken}, nil
+}
+
+func (h *HiClient) processAnchoredEvents(ctx context.Context, roomID id.RoomID, evts []*event.Event) ([]*database.Event, error) {
+	dbEvts := make([]*database.Event, len(evts))
+	if len(evts) == 0 {
+		return dbEvts, nil
+	}
+	wakeupSessionRequests := false
+	err := h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		decryptionQueue := make(map[id.SessionID]*database.SessionRequest)
+		for i, evt := range evts {
+			evt.RoomID = roomID
+			dbEvt, err := h.processEvent(ctx, evt, decryptionQueue, true)
+			if err != nil {
+				return err
+			}
+			dbEvts[i] = dbEvt
+		}
+		wakeupSessionRequests = len(decryptionQueue) > 0
+		for _, entry := range decryptionQueue {
+			err := h.DB.SessionRequest.Put(ctx, entry)
+			if err != nil {
+				return fmt.Errorf("failed to save session request for %s: %w", entry.SessionID, err)
+			}
+		}
+		err := h.DB.Event.FillLastEditRowIDs(ctx, roomID, dbEvts)
+		if err != nil {
+			return fmt.Errorf("failed to fill last edit row IDs: %w", err)
+		}
+		return h.DB.Event.FillReactionCounts(ctx, roomID, dbEvts)
+	})
+	if err == nil && wakeupSessionRequests {
+		h.WakeupRequestQueue()
+	}
+	return dbEvts, err
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrNoKeyBackup          = errors.New("key backup is not enabled")
+	ErrKeyBackupKeyMismatch = errors.New("key backup public key doesn't match the stored key")
+	ErrNotVerified          = errors.New("current device is not verified")
+)
+
+// KeyBackupUploadBatchSize is the maximum number of sessions to upload to the key backup in a single request.
+const KeyBackupUploadBatchSize = 100
+
+func keyBackupPublicKey(key *backup.MegolmBackupKey) id.Ed25519 {
+	return id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes()))
+}
+
+func (h *HiClient) getKeyBackup() (id.KeyBackupVersion, *backup.MegolmBackupKey) {
+	h.keyBackupLock.RLock()
+	defer h.keyBackupLock.RUnlock()
+	return h.KeyBackupVersion, h.KeyBackupKey
+}
+
+func (h *HiClient) setKeyBackup(version id.KeyBackupVersion, key *backup.MegolmBackupKey) {
+	h.keyBackupLock.Lock()
+	h.KeyBackupVersion = version
+	h.KeyBackupKey = key
+	h.keyBackupLock.Unlock()
+}
+
+// loadKeyBackup finds the latest key backup version on the server and enables the key backup
+// if the version is signed by a trusted key and its public key matches the given private key.
+// If there's no backup on the server, only the key is stored and the version is left empty.
+func (h *HiClient) loadKeyBackup(ctx context.Context, key *backup.MegolmBackupKey) error {
+	h.setKeyBackup("", key)
+	versionInfo, err := h.Crypto.GetAndVerifyLatestKeyBackupVersion(ctx)
+	if errors.Is(err, mautrix.MNotFound) {
+		zerolog.Ctx(ctx).Debug().Msg("No key backup found on server")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get latest key backup version: %w", err)
+	} else if versionInfo.AuthData.PublicKey != keyBackupPublicKey(key) {
+		return ErrKeyBackupKeyMismatch
+	}
+	h.setKeyBackup(versionInfo.Version, key)
+	zerolog.Ctx(ctx).Debug().Stringer("key_backup_version", versionInfo.Version).Msg("Loaded key backup version")
+	return nil
+}
+
+func (h *HiClient) storeKeyBackupKey(ctx context.Context, key *backup.MegolmBackupKey) error {
+	err := h.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key: %w", err)
+	}
+	return nil
+}
+
+// CreateKeyBackup creates a new key backup version on the server and uploads all stored megolm sessions to it.
+//
+// The backup is signed with the current device and the cross-signing master key, which means the device must
+// be verified first. The new backup key is stored in secret storage, encrypted with the given recovery key.
+func (h *HiClient) CreateKeyBackup(ctx context.Context, recoveryKey string) error {
+	if !h.Verified || h.Crypto.CrossSigningKeys == nil {
+		return ErrNotVerified
+	}
+	defer h.dispatchCurrentState()
+	keyID, keyData, err := h.Crypto.SSSS.GetDefaultKeyData(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get default SSSS key data: %w", err)
+	}
+	ssssKey, err := keyData.VerifyRecoveryKey(keyID, recoveryKey)
+	if err != nil {
+		return err
+	}
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate megolm backup key: %w", err)
+	}
+	authData := backup.MegolmAuthData{PublicKey: keyBackupPublicKey(key)}
+	masterKey := h.Crypto.CrossSigningKeys.MasterKey
+	masterSig, err := masterKey.SignJSON(authData)
+	if err != nil {
+		return fmt.Errorf("failed to sign key backup auth data with master key: %w", err)
+	}
+	deviceSig, err := h.Crypto.GetAccount().SignJSON(authData)
+	if err != nil {
+		return fmt.Errorf("failed to sign key backup auth data with device key: %w", err)
+	}
+	authData.Signatures = signatures.Signatures{
+		h.Account.UserID: {
+			id.NewKeyID(id.KeyAlgorithmEd25519, masterKey.PublicKey().String()): masterSig,
+			id.NewKeyID(id.KeyAlgorithmEd25519, h.Account.DeviceID.String()):    deviceSig,
+		},
+	}
+	resp, err := h.Client.CreateKeyBackupVersion(ctx, &mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData:  authData,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create key backup version: %w", err)
+	}
+	err = h.Crypto.SSSS.SetEncryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key.Bytes(), ssssKey)
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key in SSSS: %w", err)
+	}
+	err = h.storeKeyBackupKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	h.setKeyBackup(resp.Version, key)
+	zerolog.Ctx(ctx).Info().Stringer("key_backup_version", resp.Version).Msg("Created new key backup version")
+	h.WakeupKeyBackupUploader()
+	return nil
+}
+
+// RestoreKeyBackup downloads all sessions from the current key backup version and stores them locally.
+// Events that failed to decrypt are retried automatically as the sessions are imported.
+func (h *HiClient) RestoreKeyBackup(ctx context.Context) error {
+	version, key := h.getKeyBackup()
+	if version == "" || key == nil {
+		return ErrNoKeyBackup
+	}
+	return h.Crypto.GetAndStoreKeyBackup(ctx, version, key)
+}
+
+func (h *HiClient) WakeupKeyBackupUploader() {
+	select {
+	case h.keyBackupWakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (h *HiClient) RunKeyBackupUploader(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "key backup uploader").Logger()
+	ctx = log.WithContext(ctx)
+	log.Info().Msg("Starting key backup uploader")
+	defer func() {
+		log.Info().Msg("Stopping key backup uploader")
+	}()
+	for {
+		err := h.UploadKeyBackup(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("Failed to upload sessions to key backup")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.keyBackupWakeup:
+		}
+	}
+}
+
+// UploadKeyBackup uploads all megolm sessions that aren't in the current key backup version yet.
+// If key backup isn't enabled, this does nothing.
+func (h *HiClient) UploadKeyBackup(ctx context.Context) error {
+	version, key := h.getKeyBackup()
+	if version == "" || key == nil {
+		return nil
+	}
+	sessions, err := h.CryptoStore.GetGroupSessionsWithoutKeyBackupVersion(ctx, version).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to get sessions to back up: %w", err)
+	}
+	for len(sessions) > 0 {
+		batch := sessions[:min(len(sessions), KeyBackupUploadBatchSize)]
+		sessions = sessions[len(batch):]
+		err = h.uploadKeyBackupBatch(ctx, version, key, batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HiClient) uploadKeyBackupBatch(ctx context.Context, version id.KeyBackupVersion, key *backup.MegolmBackupKey, sessions []*crypto.InboundGroupSession) error {
+	log := zerolog.Ctx(ctx)
+	req := &mautrix.ReqKeyBackup{Rooms: make(map[id.RoomID]mautrix.ReqRoomKeyBackup)}
+	encrypted := make([]*crypto.InboundGroupSession, 0, len(sessions))
+	for _, sess := range sessions {
+		data, err := encryptSessionForBackup(key, sess)
+		if err != nil {
+			log.Err(err).Stringer("session_id", sess.ID()).Msg("Failed to encrypt session for key backup")
+			continue
+		}
+		room, ok := req.Rooms[sess.RoomID]
+		if !ok {
+			room = mautrix.ReqRoomKeyBackup{Sessions: make(map[id.SessionID]mautrix.ReqKeyBackupData)}
+			req.Rooms[sess.RoomID] = room
+		}
+		room.Sessions[sess.ID()] = *data
+		encrypted = append(encrypted, sess)
+	}
+	if len(encrypted) == 0 {
+		return nil
+	}
+	_, err := h.Client.PutKeysInBackup(ctx, version, req)
+	if err != nil {
+		return fmt.Errorf("failed to upload sessions to key backup: %w", err)
+	}
+	for _, sess := range encrypted {
+		sess.KeyBackupVersion = version
+		err = h.CryptoStore.PutGroupSession(ctx, sess)
+		if err != nil {
+			return fmt.Errorf("failed to mark session %s as backed up: %w", sess.ID(), err)
+		}
+	}
+	log.Debug().Int("session_count", len(encrypted)).Msg("Uploaded sessions to key backup")
+	return nil
+}
+
+func encryptSessionForBackup(key *backup.MegolmBackupKey, sess *crypto.InboundGroupSession) (*mautrix.ReqKeyBackupData, error) {
+	firstKnownIndex := sess.Internal.FirstKnownIndex()
+	sessionKey, err := sess.Internal.Export(firstKnownIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export session: %w", err)
+	}
+	forwardingChain := sess.ForwardingChains
+	if forwardingChain == nil {
+		forwardingChain = []string{}
+	}
+	encrypted, err := backup.EncryptSessionData(key, &backup.MegolmSessionData{
+		Algorithm:          id.AlgorithmMegolmV1,
+		ForwardingKeyChain: forwardingChain,
+		SenderClaimedKeys:  backup.SenderClaimedKeys{Ed25519: sess.SigningKey},
+		SenderKey:          sess.SenderKey,
+		SessionKey:         string(sessionKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt session: %w", err)
+	}
+	encryptedJSON, err := json.Marshal(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return &mautrix.ReqKeyBackupData{
+		FirstMessageIndex: int(firstKnownIndex),
+		ForwardedCount:    len(sess.ForwardingChains),
+		SessionData:       encryptedJSON,
+	}, nil
+}
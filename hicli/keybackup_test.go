@@ -0,0 +1,42 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/backup"
+)
+
+func TestHiClient_KeyBackup_ConcurrentAccess(t *testing.T) {
+	h, _ := newTestClient(t)
+	key, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.setKeyBackup("1", key)
+			h.setKeyBackup("", nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			version, loadedKey := h.getKeyBackup()
+			assert.Equal(t, version == "", loadedKey == nil, "version and key should be updated together")
+			_ = h.State()
+		}
+	}()
+	wg.Wait()
+}
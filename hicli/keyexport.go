@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrEmptyPassphrase = errors.New("passphrase must not be empty")
+
+// KeyImportResult contains the number of sessions that were imported by [HiClient.ImportRoomKeys].
+type KeyImportResult struct {
+	Imported int `json:"imported"`
+	Total    int `json:"total"`
+}
+
+// ExportRoomKeys exports Megolm sessions in the encrypted key export format specified in the Matrix spec,
+// which is also used by Element and other clients. If roomID is empty, the sessions of all rooms are exported.
+func (h *HiClient) ExportRoomKeys(ctx context.Context, passphrase string, roomID id.RoomID) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	var sessions []*crypto.InboundGroupSession
+	var err error
+	if roomID != "" {
+		sessions, err = h.CryptoStore.GetGroupSessionsForRoom(ctx, roomID).AsList()
+	} else {
+		sessions, err = h.CryptoStore.GetAllGroupSessions(ctx).AsList()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions to export: %w", err)
+	}
+	data, err := crypto.ExportKeys(passphrase, sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export sessions: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Int("session_count", len(sessions)).Msg("Exported room keys")
+	return data, nil
+}
+
+// ImportRoomKeys imports Megolm sessions from a key export file. Events that failed to decrypt are retried
+// automatically for each imported session.
+func (h *HiClient) ImportRoomKeys(ctx context.Context, data []byte, passphrase string) (*KeyImportResult, error) {
+	imported, total, err := h.Crypto.ImportKeys(ctx, passphrase, data)
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Int("imported_count", imported).
+		Int("total_count", total).
+		Msg("Imported room keys")
+	return &KeyImportResult{Imported: imported, Total: total}, nil
+}
@@ -47,15 +47,20 @@ func (h *HiClient) Login(ctx context.Context, req *mautrix.ReqLogin) error {
 		return err
 	}
 	defer h.dispatchCurrentState()
-	h.Account = &database.Account{
-		UserID:        resp.UserID,
-		DeviceID:      resp.DeviceID,
-		AccessToken:   resp.AccessToken,
-		HomeserverURL: h.Client.HomeserverURL.String(),
-	}
-	h.CryptoStore.AccountID = resp.UserID.String()
-	h.CryptoStore.DeviceID = resp.DeviceID
-	err = h.DB.Account.Put(ctx, h.Account)
+	return h.finishLogin(ctx, &database.Account{
+		UserID:      resp.UserID,
+		DeviceID:    resp.DeviceID,
+		AccessToken: resp.AccessToken,
+	})
+}
+
+// finishLogin stores the given account and sets up encryption. The homeserver URL is filled from the client.
+func (h *HiClient) finishLogin(ctx context.Context, account *database.Account) error {
+	account.HomeserverURL = h.Client.HomeserverURL.String()
+	h.Account = account
+	h.CryptoStore.AccountID = account.UserID.String()
+	h.CryptoStore.DeviceID = account.DeviceID
+	err := h.DB.Account.Put(ctx, h.Account)
 	if err != nil {
 		return err
 	}
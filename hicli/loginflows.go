@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix"
+)
+
+var (
+	ErrNoPendingSSOLogin    = errors.New("no SSO login in progress")
+	ErrSSOStateMismatch     = errors.New("SSO callback doesn't belong to the pending login")
+	ErrMissingSSOLoginToken = errors.New("SSO callback URL doesn't contain a login token")
+)
+
+const ssoStateParam = "hicli_sso_state"
+
+// LoginFlows describes the ways the user can log into a homeserver.
+type LoginFlows struct {
+	// HomeserverURL is the resolved client API URL of the homeserver.
+	HomeserverURL string `json:"homeserver_url"`
+
+	Password bool `json:"password"`
+	SSO      bool `json:"sso"`
+	Token    bool `json:"token"`
+	// IdentityProviders is the list of SSO identity providers. It may be empty even if SSO is supported,
+	// in which case the homeserver will let the user pick a provider after redirecting.
+	IdentityProviders []mautrix.IdentityProvider `json:"identity_providers"`
+
+	// Flows contains all flows returned by the server, including ones that don't have a dedicated field above.
+	Flows []mautrix.LoginFlow `json:"flows"`
+}
+
+// ResolveHomeserver sets the homeserver URL of the client. The input may either be a full URL
+// or a server name, in which case the URL is resolved using .well-known discovery.
+func (h *HiClient) ResolveHomeserver(ctx context.Context, homeserver string) error {
+	homeserverURL := homeserver
+	if !strings.Contains(homeserver, "://") {
+		wellKnown, err := mautrix.DiscoverClientAPI(ctx, homeserver)
+		if err != nil {
+			return fmt.Errorf("failed to resolve homeserver URL: %w", err)
+		} else if wellKnown != nil && wellKnown.Homeserver.BaseURL != "" {
+			homeserverURL = wellKnown.Homeserver.BaseURL
+		} else {
+			homeserverURL = "https://" + homeserver
+		}
+	}
+	parsedURL, err := mautrix.ParseAndNormalizeBaseURL(homeserverURL)
+	if err != nil {
+		return err
+	}
+	h.Client.HomeserverURL = parsedURL
+	return nil
+}
+
+// GetLoginFlows resolves the given homeserver and returns the login flows it supports.
+// The resolved homeserver URL is also set on the client for the actual login call.
+func (h *HiClient) GetLoginFlows(ctx context.Context, homeserver string) (*LoginFlows, error) {
+	err := h.ResolveHomeserver(ctx, homeserver)
+	if err != nil {
+		return nil, err
+	}
+	err = h.CheckServerVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.Client.GetLoginFlows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flows := &LoginFlows{
+		HomeserverURL:     h.Client.HomeserverURL.String(),
+		Password:          resp.HasFlow(mautrix.AuthTypePassword),
+		Token:             resp.HasFlow(mautrix.AuthTypeToken),
+		IdentityProviders: []mautrix.IdentityProvider{},
+		Flows:             resp.Flows,
+	}
+	if ssoFlow := resp.FirstFlowOfType(mautrix.AuthTypeSSO); ssoFlow != nil {
+		flows.SSO = true
+		if ssoFlow.IdentityProviders != nil {
+			flows.IdentityProviders = ssoFlow.IdentityProviders
+		}
+	}
+	return flows, nil
+}
+
+// GetSSORedirectURL returns the URL that the user should be sent to for logging in with SSO.
+// The homeserver must have been resolved first using [HiClient.GetLoginFlows] or [HiClient.ResolveHomeserver].
+//
+// After the user logs in, the homeserver redirects them to redirectURL. The full URL of that redirect
+// must be passed to [HiClient.FinishSSOLogin] to complete the login.
+func (h *HiClient) GetSSORedirectURL(idpID, redirectURL string) (string, error) {
+	if h.Client.HomeserverURL == nil || h.Client.HomeserverURL.Host == "" {
+		return "", fmt.Errorf("homeserver URL not set")
+	}
+	parsedRedirect, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect URL: %w", err)
+	}
+	state := random.String(32)
+	query := parsedRedirect.Query()
+	query.Set(ssoStateParam, state)
+	parsedRedirect.RawQuery = query.Encode()
+	h.loginLock.Lock()
+	h.pendingSSOState = state
+	h.loginLock.Unlock()
+	return h.Client.BuildSSORedirectURL(idpID, parsedRedirect.String()), nil
+}
+
+// FinishSSOLogin completes a login started with [HiClient.GetSSORedirectURL] using the URL
+// that the homeserver redirected the user to.
+func (h *HiClient) FinishSSOLogin(ctx context.Context, callbackURL string) error {
+	parsedCallback, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	query := parsedCallback.Query()
+	h.loginLock.Lock()
+	expectedState := h.pendingSSOState
+	if expectedState != "" && query.Get(ssoStateParam) == expectedState {
+		h.pendingSSOState = ""
+	}
+	h.loginLock.Unlock()
+	if expectedState == "" {
+		return ErrNoPendingSSOLogin
+	} else if query.Get(ssoStateParam) != expectedState {
+		return ErrSSOStateMismatch
+	}
+	loginToken := query.Get("loginToken")
+	if loginToken == "" {
+		return ErrMissingSSOLoginToken
+	}
+	return h.LoginToken(ctx, loginToken)
+}
+
+// LoginToken logs in using a m.login.token login token, e.g. one received from an SSO redirect.
+func (h *HiClient) LoginToken(ctx context.Context, token string) error {
+	return h.Login(ctx, &mautrix.ReqLogin{
+		Type:                     mautrix.AuthTypeToken,
+		Token:                    token,
+		InitialDeviceDisplayName: InitialDeviceDisplayName,
+	})
+}
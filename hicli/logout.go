@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+)
+
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// Logout invalidates the current access token on the server, wipes all local data
+// (including crypto keys) and stops the client. The client can't be used after this.
+func (h *HiClient) Logout(ctx context.Context) error {
+	return h.logout(ctx, false)
+}
+
+// LogoutAllDevices is like Logout, but invalidates the access tokens of all devices of the user.
+func (h *HiClient) LogoutAllDevices(ctx context.Context) error {
+	return h.logout(ctx, true)
+}
+
+func (h *HiClient) logout(ctx context.Context, allDevices bool) error {
+	if h.Account == nil {
+		return ErrNotLoggedIn
+	}
+	log := zerolog.Ctx(ctx)
+	userID := h.Account.UserID
+	h.stopSyncing()
+	var err error
+	if allDevices {
+		_, err = h.Client.LogoutAll(ctx)
+	} else {
+		_, err = h.Client.Logout(ctx)
+	}
+	if errors.Is(err, mautrix.MUnknownToken) {
+		log.Debug().Msg("Access token was already invalid, continuing with local logout")
+	} else if err != nil {
+		return fmt.Errorf("failed to log out on server: %w", err)
+	}
+	h.Client.ClearCredentials()
+	h.Account = nil
+	h.Verified = false
+	h.setKeyBackup("", nil)
+	h.PushRules.Store(nil)
+	h.RoomList.reset()
+	err = h.CryptoStore.DeleteAccount(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wipe crypto store: %w", err)
+	}
+	err = h.DB.Wipe(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wipe database: %w", err)
+	}
+	log.Info().Stringer("user_id", userID).Bool("all_devices", allDevices).Msg("Logged out and wiped local data")
+	h.dispatchCurrentState()
+	h.EventHandler(&LoggedOut{UserID: userID, AllDevices: allDevices})
+	err = h.DB.Close()
+	if err != nil {
+		log.Err(err).Msg("Failed to close database cleanly after logout")
+	}
+	return nil
+}
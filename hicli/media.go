@@ -0,0 +1,319 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+	"go.mau.fi/util/progress"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const thumbnailMaxSize = 800
+
+// UploadMedia uploads the given data to the media repository. If the room is encrypted,
+// the data is encrypted first and the returned EncryptedFileInfo should be used in the event.
+func (h *HiClient) UploadMedia(ctx context.Context, roomID id.RoomID, data []byte, fileName, mimeType string) (url id.ContentURIString, file *event.EncryptedFileInfo, err error) {
+	if roomID != "" {
+		roomMeta, err := h.DB.Room.Get(ctx, roomID)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get room metadata: %w", err)
+		} else if roomMeta == nil {
+			return "", nil, errors.New("unknown room")
+		} else if roomMeta.EncryptionEvent != nil {
+			file = &event.EncryptedFileInfo{
+				EncryptedFile: *attachment.NewEncryptedFile(),
+			}
+			// Don't modify the caller's buffer
+			data = file.Encrypt(data)
+			mimeType = "application/octet-stream"
+			fileName = ""
+		}
+	}
+	resp, err := h.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		ContentBytes: data,
+		ContentType:  mimeType,
+		FileName:     fileName,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload media: %w", err)
+	}
+	url = resp.ContentURI.CUString()
+	if file != nil {
+		file.URL = url
+		url = ""
+	}
+	return
+}
+
+// UploadFile uploads the file at the given path to the media repository and returns
+// message content that can be sent to the given room.
+//
+// The msgtype is chosen based on the mime type of the file. Thumbnails are generated for images
+// that are larger than 800x800 and for videos (if ffmpeg is available). If the room is encrypted,
+// the file and thumbnail are encrypted before uploading. [MediaUploadProgress] events are emitted
+// while the file is being uploaded.
+func (h *HiClient) UploadFile(ctx context.Context, roomID id.RoomID, path string) (*event.MessageEventContent, error) {
+	roomMeta, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room metadata: %w", err)
+	} else if roomMeta == nil {
+		return nil, errors.New("unknown room")
+	}
+	encrypted := roomMeta.EncryptionEvent != nil
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	} else if stat.IsDir() {
+		return nil, fmt.Errorf("can't upload directories")
+	} else if stat.Size() == 0 {
+		return nil, fmt.Errorf("can't upload empty files")
+	}
+	mimeType, err := detectMimeType(file, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect mime type: %w", err)
+	}
+	fileName := filepath.Base(path)
+	content := &event.MessageEventContent{
+		MsgType: msgTypeForMime(mimeType),
+		Body:    fileName,
+		Info: &event.FileInfo{
+			MimeType: mimeType,
+			Size:     int(stat.Size()),
+		},
+	}
+	log := zerolog.Ctx(ctx).With().Str("file_name", fileName).Str("mime_type", mimeType).Logger()
+	var thumbnail []byte
+	var thumbnailMime string
+	switch content.MsgType {
+	case event.MsgImage:
+		thumbnail, thumbnailMime, err = generateImageThumbnail(file, mimeType, content.Info)
+	case event.MsgVideo:
+		thumbnail, thumbnailMime, err = generateVideoThumbnail(ctx, path, content.Info)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to generate thumbnail")
+	} else if thumbnail != nil {
+		thumbInfo := &event.FileInfo{MimeType: thumbnailMime, Size: len(thumbnail)}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(thumbnail)); err == nil {
+			thumbInfo.Width, thumbInfo.Height = cfg.Width, cfg.Height
+		}
+		url, encFile, err := h.UploadMedia(ctx, roomID, thumbnail, "thumbnail", thumbnailMime)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to upload thumbnail")
+		} else {
+			content.Info.ThumbnailURL = url
+			content.Info.ThumbnailFile = encFile
+			content.Info.ThumbnailInfo = thumbInfo
+		}
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+	url, encFile, err := h.uploadReader(ctx, file, stat.Size(), fileName, mimeType, encrypted, func(uploaded int) {
+		h.EventHandler(&MediaUploadProgress{
+			RoomID:   roomID,
+			Path:     path,
+			Uploaded: int64(uploaded),
+			Total:    stat.Size(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	content.URL = url
+	content.File = encFile
+	return content, nil
+}
+
+func (h *HiClient) uploadReader(
+	ctx context.Context,
+	reader io.Reader,
+	size int64,
+	fileName, mimeType string,
+	encrypted bool,
+	progressFn func(uploaded int),
+) (id.ContentURIString, *event.EncryptedFileInfo, error) {
+	var encFile *event.EncryptedFileInfo
+	if encrypted {
+		encFile = &event.EncryptedFileInfo{
+			EncryptedFile: *attachment.NewEncryptedFile(),
+		}
+		// The file name and mime type would leak metadata, so they're only included in the encrypted event
+		reader = encFile.EncryptStream(reader)
+		fileName = ""
+		mimeType = "application/octet-stream"
+	}
+	if progressFn == nil {
+		progressFn = func(int) {}
+	}
+	body := progress.NewReader(reader, progressFn)
+	resp, err := h.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		Content:       body,
+		ContentLength: size,
+		ContentType:   mimeType,
+		FileName:      fileName,
+	})
+	// The client doesn't close readers to allow retries, but closing is required to finalize the hash of encrypted files
+	_ = body.Close()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload media: %w", err)
+	}
+	progressFn(int(size))
+	if encFile != nil {
+		encFile.URL = resp.ContentURI.CUString()
+		return "", encFile, nil
+	}
+	return resp.ContentURI.CUString(), nil, nil
+}
+
+func detectMimeType(file io.ReadSeeker, path string) (string, error) {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType, nil
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func msgTypeForMime(mimeType string) event.MessageType {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return event.MsgImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return event.MsgVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return event.MsgAudio
+	default:
+		return event.MsgFile
+	}
+}
+
+func generateImageThumbnail(file io.Reader, mimeType string, info *event.FileInfo) ([]byte, string, error) {
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	if info.Width <= thumbnailMaxSize && info.Height <= thumbnailMaxSize {
+		// Small images don't need thumbnails
+		return nil, "", nil
+	}
+	if mimeType == "image/png" || mimeType == "image/gif" {
+		// Keep transparency
+		return encodeThumbnail(scaleImage(img, thumbnailMaxSize), "image/png")
+	}
+	return encodeThumbnail(scaleImage(img, thumbnailMaxSize), "image/jpeg")
+}
+
+func generateVideoThumbnail(ctx context.Context, path string, info *event.FileInfo) ([]byte, string, error) {
+	if !ffmpeg.Supported() {
+		return nil, "", nil
+	}
+	tempFile, err := os.CreateTemp("", "hicli-thumbnail-*.png")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_ = tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	err = ffmpeg.ConvertPathWithDestination(ctx, path, tempFile.Name(), []string{"-y"}, []string{"-frames:v", "1", "-update", "1"}, false)
+	if err != nil {
+		return nil, "", err
+	}
+	frame, err := os.ReadFile(tempFile.Name())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read video frame: %w", err)
+	}
+	img, err := png.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode video frame: %w", err)
+	}
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return encodeThumbnail(scaleImage(img, thumbnailMaxSize), "image/jpeg")
+}
+
+func encodeThumbnail(img image.Image, mimeType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var err error
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+// scaleImage downscales the image so that neither dimension is larger than maxSize,
+// averaging the source pixels that map to each destination pixel.
+func scaleImage(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+	newWidth, newHeight := maxSize, maxSize
+	if width > height {
+		newHeight = max(height*maxSize/width, 1)
+	} else {
+		newWidth = max(width*maxSize/height, 1)
+	}
+	dst := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		srcY0 := bounds.Min.Y + y*height/newHeight
+		srcY1 := max(bounds.Min.Y+(y+1)*height/newHeight, srcY0+1)
+		for x := 0; x < newWidth; x++ {
+			srcX0 := bounds.Min.X + x*width/newWidth
+			srcX1 := max(bounds.Min.X+(x+1)*width/newWidth, srcX0+1)
+			var r, g, b, a, n uint64
+			for sy := srcY0; sy < srcY1; sy++ {
+				for sx := srcX0; sx < srcX1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
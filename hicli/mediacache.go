@@ -0,0 +1,284 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultMediaCacheMaxSize is the maximum size of the local media cache if [HiClient.MediaCacheMaxSize] is not set.
+const DefaultMediaCacheMaxSize = 1024 * 1024 * 1024
+
+const mediaEvictionBatchSize = 50
+
+var (
+	ErrMediaCacheNotConfigured = errors.New("media cache directory not configured")
+	ErrInvalidContentURI       = errors.New("invalid content URI")
+)
+
+func (h *HiClient) mediaCacheMaxSize() int64 {
+	if h.MediaCacheMaxSize > 0 {
+		return h.MediaCacheMaxSize
+	}
+	return DefaultMediaCacheMaxSize
+}
+
+// mediaCachePath returns the path where the file with the given plaintext hash is stored.
+// Files are stored by hash, so media that was uploaded multiple times is only stored once.
+func (h *HiClient) mediaCachePath(hash *[32]byte) string {
+	hashHex := hex.EncodeToString(hash[:])
+	return filepath.Join(h.MediaCacheDir, hashHex[:2], hashHex)
+}
+
+// DownloadMedia downloads the given media into the local cache and returns the path to the cached file.
+// If the file is already cached, it's returned directly without contacting the server.
+//
+// If encFile is set (or the media was seen in an encrypted event during sync), the file is decrypted and
+// the hash of the ciphertext is verified before it's stored. When the cache grows over [HiClient.MediaCacheMaxSize],
+// the least recently accessed unpinned files are evicted.
+func (h *HiClient) DownloadMedia(ctx context.Context, mxc id.ContentURI, encFile *attachment.EncryptedFile) (*database.CachedMedia, string, error) {
+	if h.MediaCacheDir == "" {
+		return nil, "", ErrMediaCacheNotConfigured
+	} else if !mxc.IsValid() {
+		return nil, "", ErrInvalidContentURI
+	}
+	cached, err := h.DB.CachedMedia.Get(ctx, mxc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get cached media entry: %w", err)
+	}
+	if cached != nil && cached.Hash != nil {
+		path := h.mediaCachePath(cached.Hash)
+		if _, err = os.Stat(path); err == nil {
+			err = h.DB.CachedMedia.MarkAccessed(ctx, mxc, time.Now())
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to update access time: %w", err)
+			}
+			return cached, path, nil
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Stringer("mxc", mxc).Msg("Cached media file is missing, redownloading")
+	} else if cached != nil && cached.Error.UseCache() {
+		if cached.Error.Matrix != nil {
+			return cached, "", *cached.Error.Matrix
+		}
+		return cached, "", fmt.Errorf("cached download error (HTTP %d)", cached.Error.StatusCode)
+	}
+	if cached == nil {
+		cached = &database.CachedMedia{MXC: mxc}
+	}
+	if encFile != nil {
+		cached.EncFile = encFile
+	}
+	path, err := h.downloadMediaToCache(ctx, cached)
+	if err != nil {
+		return nil, "", err
+	}
+	err = h.evictMediaCache(ctx, mxc)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to evict old files from media cache")
+	}
+	return cached, path, nil
+}
+
+func (h *HiClient) downloadMediaToCache(ctx context.Context, cm *database.CachedMedia) (string, error) {
+	resp, err := h.Client.Download(ctx, cm.MXC)
+	if err != nil {
+		h.saveMediaError(ctx, cm, err)
+		return "", fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+	err = os.MkdirAll(h.MediaCacheDir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create media cache directory: %w", err)
+	}
+	tempFile, err := os.CreateTemp(h.MediaCacheDir, ".download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	hasher := sha256.New()
+	var writer io.Writer = tempFile
+	if cm.EncFile == nil {
+		// Plaintext can be hashed while downloading, encrypted files are hashed after decryption
+		writer = io.MultiWriter(tempFile, hasher)
+	}
+	size, err := io.Copy(writer, resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if cm.EncFile != nil {
+		if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek temp file: %w", err)
+		} else if err = cm.EncFile.DecryptFile(tempFile); err != nil {
+			return "", fmt.Errorf("failed to decrypt media: %w", err)
+		} else if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek temp file: %w", err)
+		} else if _, err = io.Copy(hasher, tempFile); err != nil {
+			return "", fmt.Errorf("failed to hash decrypted media: %w", err)
+		}
+	}
+	if err = tempFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temp file: %w", err)
+	}
+	cm.Hash = (*[32]byte)(hasher.Sum(nil))
+	cm.Size = size
+	cm.Error = nil
+	if cm.MimeType == "" && cm.EncFile == nil {
+		cm.MimeType = resp.Header.Get("Content-Type")
+	}
+	path := h.mediaCachePath(cm.Hash)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create media cache subdirectory: %w", err)
+	} else if err = os.Rename(tempFile.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move downloaded file into cache: %w", err)
+	}
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.DB.CachedMedia.Put(ctx, cm)
+		if err != nil {
+			return err
+		}
+		return h.DB.CachedMedia.MarkAccessed(ctx, cm.MXC, time.Now())
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save cached media entry: %w", err)
+	}
+	return path, nil
+}
+
+// saveMediaError caches HTTP errors returned by the media repo, so that missing media isn't redownloaded
+// every time it's requested. Network errors are not cached.
+func (h *HiClient) saveMediaError(ctx context.Context, cm *database.CachedMedia, err error) {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Response == nil {
+		return
+	}
+	mediaErr := &database.MediaError{
+		Matrix:     httpErr.RespError,
+		StatusCode: httpErr.Response.StatusCode,
+		ReceivedAt: jsontime.UnixMilliNow(),
+		Attempts:   1,
+	}
+	if mediaErr.Matrix == nil {
+		mediaErr.Matrix = &mautrix.RespError{ErrCode: mautrix.MUnknown.ErrCode, Err: httpErr.Error()}
+	}
+	if cm.Error != nil {
+		mediaErr.Attempts = cm.Error.Attempts + 1
+	}
+	cm.Error = mediaErr
+	dbErr := h.DB.CachedMedia.Put(ctx, cm)
+	if dbErr != nil {
+		zerolog.Ctx(ctx).Err(dbErr).Stringer("mxc", cm.MXC).Msg("Failed to save media download error")
+	}
+}
+
+// removeCachedFile marks the given media as not cached and deletes the file from disk
+// if no other media entries refer to the same file. It returns true if the file was deleted.
+func (h *HiClient) removeCachedFile(ctx context.Context, cm *database.CachedMedia) (bool, error) {
+	err := h.DB.CachedMedia.ClearFile(ctx, cm.MXC)
+	if err != nil {
+		return false, fmt.Errorf("failed to clear cached file of %s: %w", cm.MXC, err)
+	}
+	refCount, err := h.DB.CachedMedia.CountWithHash(ctx, cm.Hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to count references to cached file: %w", err)
+	} else if refCount > 0 {
+		return false, nil
+	}
+	err = os.Remove(h.mediaCachePath(cm.Hash))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to delete cached file of %s: %w", cm.MXC, err)
+	}
+	return true, nil
+}
+
+// evictMediaCache deletes the least recently accessed unpinned files until the cache fits in the size limit.
+// The media with the keep URI is never evicted, so that a file that was just downloaded can be returned.
+func (h *HiClient) evictMediaCache(ctx context.Context, keep id.ContentURI) error {
+	h.mediaCacheLock.Lock()
+	defer h.mediaCacheLock.Unlock()
+	stats, err := h.DB.CachedMedia.GetStats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get media cache size: %w", err)
+	}
+	maxSize := h.mediaCacheMaxSize()
+	for stats.TotalSize > maxSize {
+		candidates, err := h.DB.CachedMedia.GetEvictionCandidates(ctx, mediaEvictionBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to get eviction candidates: %w", err)
+		}
+		evictedAny := false
+		for _, cm := range candidates {
+			if cm.MXC == keep {
+				continue
+			}
+			evictedAny = true
+			deleted, err := h.removeCachedFile(ctx, cm)
+			if err != nil {
+				return err
+			} else if deleted {
+				stats.TotalSize -= cm.Size
+				if stats.TotalSize <= maxSize {
+					break
+				}
+			}
+		}
+		if !evictedAny {
+			break
+		}
+	}
+	return nil
+}
+
+// ClearMediaCache deletes all unpinned files from the local media cache.
+func (h *HiClient) ClearMediaCache(ctx context.Context) error {
+	h.mediaCacheLock.Lock()
+	defer h.mediaCacheLock.Unlock()
+	cached, err := h.DB.CachedMedia.GetAllUnpinned(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cached media: %w", err)
+	}
+	for _, cm := range cached {
+		_, err = h.removeCachedFile(ctx, cm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMediaCacheStats returns the number and total size of files in the local media cache.
+func (h *HiClient) GetMediaCacheStats(ctx context.Context) (*database.MediaCacheStats, error) {
+	stats, err := h.DB.CachedMedia.GetStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.MaxSize = h.mediaCacheMaxSize()
+	return stats, nil
+}
+
+// PinMedia sets whether the given media is pinned in the local media cache. Pinned files are never evicted.
+func (h *HiClient) PinMedia(ctx context.Context, mxc id.ContentURI, pinned bool) error {
+	return h.DB.CachedMedia.SetPinned(ctx, mxc, pinned)
+}
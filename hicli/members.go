@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const defaultMemberListLimit = 50
+
+// RoomMember is a single entry in a member list returned by [HiClient.GetMemberList].
+type RoomMember struct {
+	UserID      id.UserID           `json:"user_id"`
+	Membership  event.Membership    `json:"membership"`
+	Displayname string              `json:"displayname,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	PowerLevel  int                 `json:"power_level"`
+	// FetchedProfile is true if the displayname and avatar were fetched from the profile API,
+	// because the member event didn't include a displayname.
+	FetchedProfile bool `json:"fetched_profile,omitempty"`
+}
+
+type MemberListResponse struct {
+	Members []*RoomMember `json:"members"`
+	// Total is the number of members matching the query, including ones not on this page.
+	Total   int  `json:"total"`
+	HasMore bool `json:"has_more"`
+}
+
+// GetMemberList returns a page of the room's member list from the local current state.
+//
+// If query is non-empty, only members whose displayname or user ID starts with the query are included.
+// Members are sorted by power level (highest first), then by displayname. If memberships is empty,
+// joined and invited members are included. Members on the returned page that don't have a displayname
+// in their member event have their global profile fetched from the server.
+func (h *HiClient) GetMemberList(ctx context.Context, roomID id.RoomID, memberships []event.Membership, query string, offset, limit int) (*MemberListResponse, error) {
+	if len(memberships) == 0 {
+		memberships = []event.Membership{event.MembershipJoin, event.MembershipInvite}
+	}
+	if limit <= 0 {
+		limit = defaultMemberListLimit
+	}
+	offset = max(offset, 0)
+	evts, err := h.DB.CurrentState.GetMembers(ctx, roomID, memberships, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members from database: %w", err)
+	}
+	var pls event.PowerLevelsEventContent
+	plEvt, err := h.DB.CurrentState.Get(ctx, roomID, event.StatePowerLevels, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels from database: %w", err)
+	} else if plEvt != nil {
+		err = json.Unmarshal(plEvt.Content, &pls)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("room_id", roomID).Msg("Failed to parse power levels")
+		}
+	}
+	members := make([]*RoomMember, 0, len(evts))
+	for _, evt := range evts {
+		var content event.MemberEventContent
+		err = json.Unmarshal(evt.Content, &content)
+		if err != nil || evt.StateKey == nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to parse member event")
+			continue
+		}
+		userID := id.UserID(*evt.StateKey)
+		members = append(members, &RoomMember{
+			UserID:      userID,
+			Membership:  content.Membership,
+			Displayname: content.Displayname,
+			AvatarURL:   content.AvatarURL,
+			PowerLevel:  pls.GetUserLevel(userID),
+		})
+	}
+	slices.SortFunc(members, func(a, b *RoomMember) int {
+		return cmp.Or(
+			cmp.Compare(b.PowerLevel, a.PowerLevel),
+			cmp.Compare(strings.ToLower(a.sortName()), strings.ToLower(b.sortName())),
+			cmp.Compare(a.UserID, b.UserID),
+		)
+	})
+	resp := &MemberListResponse{Total: len(members)}
+	if offset < len(members) {
+		resp.Members = members[offset:min(offset+limit, len(members))]
+	} else {
+		resp.Members = []*RoomMember{}
+	}
+	resp.HasMore = offset+limit < len(members)
+	for _, member := range resp.Members {
+		if member.Displayname == "" {
+			h.fillMemberProfile(ctx, member)
+		}
+	}
+	return resp, nil
+}
+
+func (rm *RoomMember) sortName() string {
+	if rm.Displayname != "" {
+		return rm.Displayname
+	}
+	return strings.TrimPrefix(rm.UserID.String(), "@")
+}
+
+func (h *HiClient) fillMemberProfile(ctx context.Context, member *RoomMember) {
+	profile, err := h.GetProfile(ctx, member.UserID)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Stringer("user_id", member.UserID).Msg("Failed to fetch profile of member without displayname")
+		return
+	}
+	member.Displayname = profile.Displayname
+	if member.AvatarURL == "" {
+		member.AvatarURL = profile.AvatarURL
+	}
+	member.FetchedProfile = true
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// CreateRoom creates a new room. If encrypted is true, an m.room.encryption event is added to the initial state,
+// unless the request already contains one.
+//
+// The room is added to the local database and room list immediately instead of waiting for it to come down sync.
+func (h *HiClient) CreateRoom(ctx context.Context, req *mautrix.ReqCreateRoom, encrypted bool) (id.RoomID, error) {
+	if req == nil {
+		req = &mautrix.ReqCreateRoom{}
+	}
+	var encryption *event.EncryptionEventContent
+	for _, evt := range req.InitialState {
+		if evt.Type == event.StateEncryption {
+			encrypted = false
+			_ = evt.Content.ParseRaw(evt.Type)
+			encryption = evt.Content.AsEncryption()
+		}
+	}
+	if encrypted {
+		encryption = &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type:    event.StateEncryption,
+			Content: event.Content{Parsed: encryption},
+		})
+	}
+	resp, err := h.Client.CreateRoom(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	room := &database.Room{
+		ID:              resp.RoomID,
+		EncryptionEvent: encryption,
+	}
+	if req.Name != "" {
+		room.Name = &req.Name
+		room.NameQuality = database.NameQualityExplicit
+	}
+	if req.Topic != "" {
+		room.Topic = &req.Topic
+	}
+	return resp.RoomID, h.updateLocalRoom(ctx, room)
+}
+
+// CreateDM creates a private chat with the given user, invites them and marks the room as a DM in m.direct.
+func (h *HiClient) CreateDM(ctx context.Context, userID id.UserID, encrypted bool) (id.RoomID, error) {
+	roomID, err := h.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		Invite:   []id.UserID{userID},
+		IsDirect: true,
+	}, encrypted)
+	if err != nil {
+		return roomID, err
+	}
+	err = h.markAsDM(ctx, roomID, userID)
+	if err != nil {
+		return roomID, fmt.Errorf("failed to mark room as direct chat: %w", err)
+	}
+	return roomID, nil
+}
+
+func (h *HiClient) markAsDM(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	evt, err := h.DB.AccountData.Get(ctx, h.Account.UserID, event.AccountDataDirectChats)
+	if err != nil {
+		return fmt.Errorf("failed to get current direct chat list: %w", err)
+	}
+	content := make(event.DirectChatsEventContent)
+	if evt != nil {
+		err = json.Unmarshal(evt.Content, &content)
+		if err != nil {
+			return fmt.Errorf("failed to parse current direct chat list: %w", err)
+		}
+	}
+	if slices.Contains(content[userID], roomID) {
+		return nil
+	}
+	content[userID] = append(content[userID], roomID)
+	err = h.Client.SetAccountData(ctx, event.AccountDataDirectChats.Type, content)
+	if err != nil {
+		return err
+	}
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	err = h.DB.AccountData.Put(ctx, h.Account.UserID, event.AccountDataDirectChats, rawContent)
+	if err != nil {
+		return fmt.Errorf("failed to save direct chat list: %w", err)
+	}
+	h.RoomList.update(nil, nil, content)
+	return nil
+}
+
+// InviteUser invites the given user to the room.
+func (h *HiClient) InviteUser(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := h.Client.InviteUser(ctx, roomID, &mautrix.ReqInviteUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// KickUser removes the given user from the room.
+func (h *HiClient) KickUser(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := h.Client.KickUser(ctx, roomID, &mautrix.ReqKickUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// BanUser bans the given user from the room.
+func (h *HiClient) BanUser(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := h.Client.BanUser(ctx, roomID, &mautrix.ReqBanUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// UnbanUser unbans the given user in the room.
+func (h *HiClient) UnbanUser(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := h.Client.UnbanUser(ctx, roomID, &mautrix.ReqUnbanUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// resolveVia finds servers to join or knock via if none were specified. Aliases are resolved to get the list
+// of servers in the room, while room IDs fall back to the server that created the room.
+func (h *HiClient) resolveVia(ctx context.Context, roomIDOrAlias string, via []string) []string {
+	if len(via) > 0 {
+		return via
+	}
+	if strings.HasPrefix(roomIDOrAlias, "#") {
+		resp, err := h.Client.ResolveAlias(ctx, id.RoomAlias(roomIDOrAlias))
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("room_alias", roomIDOrAlias).Msg("Failed to resolve alias for via servers")
+			return nil
+		}
+		return resp.Servers
+	}
+	_, server, found := strings.Cut(roomIDOrAlias, ":")
+	if found {
+		return []string{server}
+	}
+	return nil
+}
+
+// JoinRoom joins the given room ID or alias. If no via servers are specified, they're resolved automatically.
+//
+// The room is added to the local database and room list immediately instead of waiting for it to come down sync.
+func (h *HiClient) JoinRoom(ctx context.Context, roomIDOrAlias string, via []string, reason string) (id.RoomID, error) {
+	resp, err := h.Client.JoinRoomVia(ctx, roomIDOrAlias, &mautrix.ReqJoinRoom{
+		Via:    h.resolveVia(ctx, roomIDOrAlias, via),
+		Reason: reason,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.RoomID, h.updateLocalRoom(ctx, &database.Room{ID: resp.RoomID})
+}
+
+// KnockRoom asks to join the given room ID or alias. If no via servers are specified, they're resolved automatically.
+func (h *HiClient) KnockRoom(ctx context.Context, roomIDOrAlias string, via []string, reason string) (id.RoomID, error) {
+	resp, err := h.Client.KnockRoom(ctx, roomIDOrAlias, &mautrix.ReqKnockRoom{
+		Via:    h.resolveVia(ctx, roomIDOrAlias, via),
+		Reason: reason,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+// LeaveRoom leaves the given room. If forget is true, the room is also forgotten using [HiClient.ForgetRoom].
+// Otherwise, the local data of the room is deleted after [LeftRoomRetention] unless the room is rejoined.
+func (h *HiClient) LeaveRoom(ctx context.Context, roomID id.RoomID, reason string, forget bool) error {
+	_, err := h.Client.LeaveRoom(ctx, roomID, &mautrix.ReqLeave{Reason: reason})
+	if err != nil {
+		return err
+	}
+	if forget {
+		err = h.ForgetRoom(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("failed to forget room: %w", err)
+		}
+		return nil
+	}
+	// The leave event will also come down sync, but schedule the cleanup immediately in case it doesn't
+	return h.scheduleRoomCleanup(ctx, roomID, event.MembershipLeave)
+}
+
+// updateLocalRoom stores the given room data and bumps the sorting timestamp of the room, then dispatches
+// the room as if it had come down sync. The rest of the room data is filled in by the next sync.
+func (h *HiClient) updateLocalRoom(ctx context.Context, updatedRoom *database.Room) error {
+	room, err := h.DB.Room.Get(ctx, updatedRoom.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get room data: %w", err)
+	} else if room == nil {
+		err = h.DB.Room.CreateRow(ctx, updatedRoom.ID)
+		if err != nil {
+			return fmt.Errorf("failed to ensure room row exists: %w", err)
+		}
+		room = &database.Room{ID: updatedRoom.ID}
+	}
+	updatedRoom.SortingTimestamp = jsontime.UnixMilliNow()
+	if !updatedRoom.CheckChangesAndCopyInto(room) {
+		return nil
+	}
+	err = h.DB.Room.Upsert(ctx, updatedRoom)
+	if err != nil {
+		return fmt.Errorf("failed to save room data: %w", err)
+	}
+	h.EventHandler(&SyncComplete{Rooms: map[id.RoomID]*SyncRoom{
+		room.ID: {
+			Meta:     room,
+			Timeline: []database.TimelineRowTuple{},
+			State:    map[event.Type]map[string]database.EventRowID{},
+			Events:   []*database.Event{},
+		},
+	}})
+	h.RoomList.update([]*database.Room{room}, nil, nil)
+	return nil
+}
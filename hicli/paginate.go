@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/rs/zerolog"
 
@@ -79,6 +80,7 @@ func (h *HiClient) GetRoomState(ctx context.Context, roomID id.RoomID, fetchMemb
 		evts = resp.Chunk
 	}
 	if evts != nil {
+		var changedSpaces []id.RoomID
 		err := h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 			room, err := h.DB.Room.Get(ctx, roomID)
 			if err != nil {
@@ -103,6 +105,12 @@ func (h *HiClient) GetRoomState(ctx context.Context, roomID id.RoomID, fetchMemb
 					entries[i].Membership = event.Membership(evt.Content.Raw["membership"].(string))
 				} else {
 					processImportantEvent(ctx, evt, room, updatedRoom)
+					spaceID, err := h.processSpaceEdge(ctx, dbEvt)
+					if err != nil {
+						return err
+					} else if spaceID != "" && !slices.Contains(changedSpaces, spaceID) {
+						changedSpaces = append(changedSpaces, spaceID)
+					}
 				}
 			}
 			err = h.DB.CurrentState.AddMany(ctx, room.ID, refetch, entries)
@@ -121,6 +129,7 @@ func (h *HiClient) GetRoomState(ctx context.Context, roomID id.RoomID, fetchMemb
 		if err != nil {
 			return nil, err
 		}
+		h.emitSpaceChanges(ctx, changedSpaces)
 	}
 	return h.DB.CurrentState.GetAll(ctx, roomID)
 }
@@ -128,17 +137,42 @@ func (h *HiClient) GetRoomState(ctx context.Context, roomID id.RoomID, fetchMemb
 type PaginationResponse struct {
 	Events  []*database.Event `json:"events"`
 	HasMore bool              `json:"has_more"`
+	// The timeline row ID of the oldest event in the response, which can be passed to [HiClient.Paginate]
+	// as maxTimelineID to fetch the next batch. Zero if there are no events in the response.
+	NextCursor database.TimelineRowID `json:"next_cursor,omitempty"`
 }
 
+func (pr *PaginationResponse) fillCursor() *PaginationResponse {
+	if len(pr.Events) > 0 {
+		pr.NextCursor = pr.Events[len(pr.Events)-1].TimelineRowID
+	}
+	return pr
+}
+
+// Paginate returns up to limit events older than the given timeline row ID (or the newest events if it's zero),
+// sorted from newest to oldest.
+//
+// Events are served from the local database first. If there aren't enough events stored locally,
+// the rest are fetched from the server using [HiClient.PaginateServer]. The NextCursor field of the
+// response can be used as maxTimelineID for the next call.
 func (h *HiClient) Paginate(ctx context.Context, roomID id.RoomID, maxTimelineID database.TimelineRowID, limit int) (*PaginationResponse, error) {
 	evts, err := h.DB.Timeline.Get(ctx, roomID, limit, maxTimelineID)
 	if err != nil {
 		return nil, err
-	} else if len(evts) > 0 {
-		return &PaginationResponse{Events: evts, HasMore: true}, nil
-	} else {
-		return h.PaginateServer(ctx, roomID, limit)
+	} else if len(evts) >= limit {
+		return (&PaginationResponse{Events: evts, HasMore: true}).fillCursor(), nil
+	}
+	serverResp, err := h.PaginateServer(ctx, roomID, limit-len(evts))
+	if err != nil {
+		if len(evts) == 0 {
+			return nil, err
+		}
+		// Return the local events now, the server will be tried again when paginating further
+		zerolog.Ctx(ctx).Warn().Err(err).Stringer("room_id", roomID).Msg("Failed to paginate from server after local events")
+		return (&PaginationResponse{Events: evts, HasMore: true}).fillCursor(), nil
 	}
+	serverResp.Events = append(evts, serverResp.Events...)
+	return serverResp.fillCursor(), nil
 }
 
 func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit int) (*PaginationResponse, error) {
@@ -159,6 +193,8 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 	room, err := h.DB.Room.Get(ctx, roomID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("unknown room")
 	} else if room.PrevBatch == database.PrevBatchPaginationComplete {
 		return &PaginationResponse{Events: []*database.Event{}, HasMore: false}, nil
 	}
@@ -170,12 +206,13 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 	if resp.End == "" {
 		resp.End = database.PrevBatchPaginationComplete
 	}
-	if resp.End == database.PrevBatchPaginationComplete || len(resp.Chunk) == 0 {
+	hasMore := resp.End != database.PrevBatchPaginationComplete
+	if len(resp.Chunk) == 0 {
 		err = h.DB.Room.SetPrevBatch(ctx, room.ID, resp.End)
 		if err != nil {
 			return nil, fmt.Errorf("failed to set prev_batch: %w", err)
 		}
-		return &PaginationResponse{Events: events, HasMore: resp.End != ""}, nil
+		return &PaginationResponse{Events: events, HasMore: hasMore}, nil
 	}
 	wakeupSessionRequests := false
 	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
@@ -236,5 +273,8 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 	if err == nil && wakeupSessionRequests {
 		h.WakeupRequestQueue()
 	}
-	return &PaginationResponse{Events: events, HasMore: true}, err
+	if err != nil {
+		return nil, err
+	}
+	return (&PaginationResponse{Events: events, HasMore: hasMore}).fillCursor(), nil
 }
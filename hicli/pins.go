@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetPinnedEvents returns the IDs of the pinned events in the given room from the local state cache.
+func (h *HiClient) GetPinnedEvents(ctx context.Context, roomID id.RoomID) ([]id.EventID, error) {
+	evt, err := h.GetStateEvent(ctx, roomID, event.StatePinnedEvents, "")
+	if err != nil {
+		return nil, err
+	} else if evt == nil {
+		return []id.EventID{}, nil
+	}
+	pinned := evt.Content.AsPinnedEvents().Pinned
+	if pinned == nil {
+		pinned = []id.EventID{}
+	}
+	return pinned, nil
+}
+
+// PinEvent adds the given event to the pinned events of the room.
+func (h *HiClient) PinEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+	return h.updatePinnedEvents(ctx, roomID, func(pinned []id.EventID) []id.EventID {
+		if slices.Contains(pinned, eventID) {
+			return nil
+		}
+		return append(pinned, eventID)
+	})
+}
+
+// UnpinEvent removes the given event from the pinned events of the room.
+func (h *HiClient) UnpinEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+	return h.updatePinnedEvents(ctx, roomID, func(pinned []id.EventID) []id.EventID {
+		if !slices.Contains(pinned, eventID) {
+			return nil
+		}
+		return slices.DeleteFunc(pinned, func(pinnedID id.EventID) bool {
+			return pinnedID == eventID
+		})
+	})
+}
+
+func (h *HiClient) updatePinnedEvents(ctx context.Context, roomID id.RoomID, update func([]id.EventID) []id.EventID) error {
+	pinned, err := h.GetPinnedEvents(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get current pinned events: %w", err)
+	}
+	newPinned := update(pinned)
+	if newPinned == nil {
+		return nil
+	}
+	_, err = h.Client.SendStateEvent(ctx, roomID, event.StatePinnedEvents, "", &event.PinnedEventsEventContent{Pinned: newPinned})
+	return err
+}
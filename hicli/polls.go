@@ -0,0 +1,314 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const maxPollAnswers = 20
+
+var (
+	ErrNotAPoll          = errors.New("event is not a poll")
+	ErrPollEnded         = errors.New("poll has already ended")
+	ErrInvalidPollAnswer = errors.New("invalid poll answer")
+	ErrNotPollCreator    = errors.New("only the creator of the poll can end it")
+)
+
+// PollAnswerResult contains the votes for a single answer of a poll.
+type PollAnswerResult struct {
+	ID     string      `json:"id"`
+	Text   string      `json:"text"`
+	Votes  int         `json:"votes"`
+	Voters []id.UserID `json:"voters"`
+}
+
+// PollResults contains the locally aggregated state of a poll.
+type PollResults struct {
+	RoomID        id.RoomID           `json:"room_id"`
+	EventID       id.EventID          `json:"event_id"`
+	Question      string              `json:"question"`
+	Kind          string              `json:"kind"`
+	MaxSelections int                 `json:"max_selections"`
+	Answers       []*PollAnswerResult `json:"answers"`
+	TotalVoters   int                 `json:"total_voters"`
+	OwnVote       []string            `json:"own_vote,omitempty"`
+	Ended         bool                `json:"ended"`
+	EndEventID    id.EventID          `json:"end_event_id,omitempty"`
+	// Undecryptable is the number of responses that haven't been decrypted yet and are therefore not counted.
+	Undecryptable int `json:"undecryptable,omitempty"`
+}
+
+// TopAnswers returns the answers with the most votes. Nothing is returned if there are no votes.
+func (pr *PollResults) TopAnswers() []*PollAnswerResult {
+	var top []*PollAnswerResult
+	maxVotes := 1
+	for _, answer := range pr.Answers {
+		if answer.Votes > maxVotes {
+			maxVotes = answer.Votes
+			top = top[:0]
+		}
+		if answer.Votes == maxVotes {
+			top = append(top, answer)
+		}
+	}
+	return top
+}
+
+// StartPoll sends a new poll with the given question and answers. If disclosed is false,
+// other clients should hide the results until the poll has ended.
+func (h *HiClient) StartPoll(ctx context.Context, roomID id.RoomID, question string, answers []string, maxSelections int, disclosed bool) (*database.Event, error) {
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return nil, fmt.Errorf("poll question must not be empty")
+	} else if len(answers) == 0 || len(answers) > maxPollAnswers {
+		return nil, fmt.Errorf("polls must have between 1 and %d answers", maxPollAnswers)
+	}
+	content := &event.PollStartEventContent{}
+	content.PollStart.Kind = event.PollKindUndisclosed
+	if disclosed {
+		content.PollStart.Kind = event.PollKindDisclosed
+	}
+	content.PollStart.MaxSelections = min(max(maxSelections, 1), len(answers))
+	content.PollStart.Question.Text = question
+	fallback := []string{question}
+	for i, answer := range answers {
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return nil, fmt.Errorf("poll answers must not be empty")
+		}
+		content.PollStart.Answers = append(content.PollStart.Answers, event.PollAnswer{
+			ID:             random.String(16),
+			MSC1767Message: event.MSC1767Message{Text: answer},
+		})
+		fallback = append(fallback, fmt.Sprintf("%d. %s", i+1, answer))
+	}
+	content.Text = strings.Join(fallback, "\n")
+	return h.Send(ctx, roomID, event.EventUnstablePollStart, content)
+}
+
+// VotePoll sends a response to the given poll, replacing any previous vote of the current user.
+//
+// The results are recalculated immediately including the unsent vote and a [PollUpdated] event is emitted.
+func (h *HiClient) VotePoll(ctx context.Context, roomID id.RoomID, pollEventID id.EventID, answerIDs []string) (*database.Event, error) {
+	results, err := h.GetPollResults(ctx, roomID, pollEventID)
+	if err != nil {
+		return nil, err
+	} else if results.Ended {
+		return nil, ErrPollEnded
+	} else if len(answerIDs) == 0 || len(answerIDs) > results.MaxSelections {
+		return nil, fmt.Errorf("%w: must select between 1 and %d answers", ErrInvalidPollAnswer, results.MaxSelections)
+	}
+	for _, answerID := range answerIDs {
+		if !slices.ContainsFunc(results.Answers, func(answer *PollAnswerResult) bool {
+			return answer.ID == answerID
+		}) {
+			return nil, fmt.Errorf("%w: unknown answer ID %q", ErrInvalidPollAnswer, answerID)
+		}
+	}
+	content := &event.PollResponseEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelReference,
+			EventID: pollEventID,
+		},
+	}
+	content.Response.Answers = answerIDs
+	dbEvt, err := h.Send(ctx, roomID, event.EventUnstablePollResponse, content)
+	if err != nil {
+		return nil, err
+	}
+	h.updatePoll(ctx, roomID, pollEventID)
+	return dbEvt, nil
+}
+
+// EndPoll closes the given poll. Only polls created by the current user can be ended.
+//
+// The end event includes the winning answers as a fallback for clients that don't support polls.
+func (h *HiClient) EndPoll(ctx context.Context, roomID id.RoomID, pollEventID id.EventID) (*database.Event, error) {
+	results, err := h.GetPollResults(ctx, roomID, pollEventID)
+	if err != nil {
+		return nil, err
+	} else if results.Ended {
+		return nil, ErrPollEnded
+	}
+	poll, err := h.DB.Event.GetByID(ctx, pollEventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll event: %w", err)
+	} else if poll.Sender != h.Account.UserID {
+		return nil, ErrNotPollCreator
+	}
+	content := &event.PollEndEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelReference,
+			EventID: pollEventID,
+		},
+		Text: "The poll has ended.",
+	}
+	if top := results.TopAnswers(); len(top) > 0 {
+		topTexts := make([]string, len(top))
+		for i, answer := range top {
+			topTexts[i] = answer.Text
+		}
+		content.Text = fmt.Sprintf("The poll has ended. Top answer: %s", strings.Join(topTexts, ", "))
+	}
+	dbEvt, err := h.Send(ctx, roomID, event.EventUnstablePollEnd, content)
+	if err != nil {
+		return nil, err
+	}
+	h.updatePoll(ctx, roomID, pollEventID)
+	return dbEvt, nil
+}
+
+// GetPollResults calculates the results of the given poll from the responses stored locally.
+//
+// Only the latest response of each user sent before the poll ended is counted. Answer IDs that aren't
+// in the poll are ignored and responses with more answers than allowed are truncated. Responses that
+// haven't been decrypted are counted in [PollResults.Undecryptable].
+func (h *HiClient) GetPollResults(ctx context.Context, roomID id.RoomID, pollEventID id.EventID) (*PollResults, error) {
+	poll, err := h.DB.Event.GetByID(ctx, pollEventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll event: %w", err)
+	} else if poll == nil || poll.RoomID != roomID || poll.RedactedBy != "" {
+		return nil, ErrEventNotFound
+	}
+	evtType, rawContent := getDecryptedContent(poll)
+	if evtType != event.EventUnstablePollStart.Type {
+		return nil, ErrNotAPoll
+	}
+	var content event.PollStartEventContent
+	err = json.Unmarshal(rawContent, &content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse poll content: %w", err)
+	}
+	results := &PollResults{
+		RoomID:        roomID,
+		EventID:       pollEventID,
+		Question:      content.PollStart.Question.Text,
+		Kind:          content.PollStart.Kind,
+		MaxSelections: max(content.PollStart.MaxSelections, 1),
+		Answers:       make([]*PollAnswerResult, 0, len(content.PollStart.Answers)),
+	}
+	answersByID := make(map[string]*PollAnswerResult, len(content.PollStart.Answers))
+	for _, answer := range content.PollStart.Answers {
+		if _, duplicate := answersByID[answer.ID]; duplicate || answer.ID == "" {
+			continue
+		}
+		result := &PollAnswerResult{ID: answer.ID, Text: answer.Text, Voters: []id.UserID{}}
+		answersByID[answer.ID] = result
+		results.Answers = append(results.Answers, result)
+	}
+	related, err := h.DB.Event.GetRelated(ctx, roomID, pollEventID, event.RelReference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll responses: %w", err)
+	}
+	var endEvt *database.Event
+	votes := make(map[id.UserID][]string)
+	for _, evt := range related {
+		// Only the creator of the poll is allowed to end it
+		if evtType, _ := getDecryptedContent(evt); evtType == event.EventUnstablePollEnd.Type && evt.Sender == poll.Sender {
+			endEvt = evt
+			break
+		}
+	}
+	for _, evt := range related {
+		if endEvt != nil && evt.Timestamp.After(endEvt.Timestamp.Time) {
+			// Related events are sorted by timestamp, so none of the remaining responses can be counted either
+			break
+		}
+		evtType, rawContent := getDecryptedContent(evt)
+		if evtType == event.EventEncrypted.Type {
+			results.Undecryptable++
+			continue
+		} else if evtType != event.EventUnstablePollResponse.Type {
+			continue
+		}
+		var response event.PollResponseEventContent
+		err = json.Unmarshal(rawContent, &response)
+		if err != nil {
+			zerolog.Ctx(ctx).Debug().Err(err).Stringer("event_id", evt.ID).Msg("Failed to parse poll response")
+			continue
+		}
+		selected := make([]string, 0, results.MaxSelections)
+		for _, answerID := range response.Response.Answers {
+			if _, ok := answersByID[answerID]; ok && !slices.Contains(selected, answerID) && len(selected) < results.MaxSelections {
+				selected = append(selected, answerID)
+			}
+		}
+		if len(selected) == 0 {
+			// Responses without any valid answers are spoiled and cancel the user's previous vote
+			delete(votes, evt.Sender)
+		} else {
+			votes[evt.Sender] = selected
+		}
+	}
+	for userID, selected := range votes {
+		for _, answerID := range selected {
+			answersByID[answerID].Votes++
+			answersByID[answerID].Voters = append(answersByID[answerID].Voters, userID)
+		}
+	}
+	for _, answer := range results.Answers {
+		slices.Sort(answer.Voters)
+	}
+	results.TotalVoters = len(votes)
+	results.OwnVote = votes[h.Account.UserID]
+	if endEvt != nil {
+		results.Ended = true
+		results.EndEventID = endEvt.ID
+	}
+	return results, nil
+}
+
+// getDecryptedContent returns the type and content of the event, preferring the decrypted ones if available.
+func getDecryptedContent(evt *database.Event) (string, json.RawMessage) {
+	if evt.Decrypted != nil {
+		return evt.DecryptedType, evt.Decrypted
+	}
+	return evt.Type, evt.Content
+}
+
+// isPollRelation returns true if the given event is a response to or the end of a poll.
+// Encrypted references are included, as they may turn out to be poll events once decrypted.
+func isPollRelation(evt *database.Event) bool {
+	if evt.RelationType != event.RelReference || evt.RelatesTo == "" {
+		return false
+	}
+	evtType, _ := getDecryptedContent(evt)
+	return evtType == event.EventUnstablePollResponse.Type ||
+		evtType == event.EventUnstablePollEnd.Type ||
+		evtType == event.EventEncrypted.Type
+}
+
+// reconcilePoll recalculates the results of the poll that the given event relates to if it's a poll event.
+func (h *HiClient) reconcilePoll(ctx context.Context, evt *database.Event) {
+	if isPollRelation(evt) {
+		h.updatePoll(ctx, evt.RoomID, evt.RelatesTo)
+	}
+}
+
+func (h *HiClient) updatePoll(ctx context.Context, roomID id.RoomID, pollEventID id.EventID) {
+	results, err := h.GetPollResults(ctx, roomID, pollEventID)
+	if errors.Is(err, ErrNotAPoll) || errors.Is(err, ErrEventNotFound) {
+		return
+	} else if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("event_id", pollEventID).Msg("Failed to calculate poll results")
+		return
+	}
+	h.EventHandler(&PollUpdated{PollResults: results})
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// ProfileCacheTTL is how long profiles fetched by [HiClient.GetProfile] are cached before they're refetched.
+// Cached profiles are also invalidated when a member event changes the user's displayname or avatar.
+var ProfileCacheTTL = 24 * time.Hour
+
+// GetProfile returns the global profile of the given user, using the local cache if it's fresh enough.
+// If fetching the profile fails, the stale cached profile is returned if there is one.
+func (h *HiClient) GetProfile(ctx context.Context, userID id.UserID) (*database.Profile, error) {
+	cached, err := h.DB.Profile.Get(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached profile: %w", err)
+	} else if cached != nil && time.Since(cached.FetchedAt.Time) < ProfileCacheTTL {
+		return cached, nil
+	}
+	resp, err := h.Client.GetProfile(ctx, userID)
+	if err != nil {
+		if cached != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("user_id", userID).Msg("Failed to refetch profile, using stale cache")
+			return cached, nil
+		}
+		return nil, err
+	}
+	profile := &database.Profile{
+		UserID:      userID,
+		Displayname: resp.DisplayName,
+		FetchedAt:   jsontime.UnixMilliNow(),
+	}
+	if !resp.AvatarURL.IsEmpty() {
+		profile.AvatarURL = resp.AvatarURL.CUString()
+	}
+	err = h.DB.Profile.Put(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache profile: %w", err)
+	}
+	return profile, nil
+}
+
+// SetDisplayName changes the global displayname of the logged-in user.
+func (h *HiClient) SetDisplayName(ctx context.Context, name string) error {
+	err := h.Client.SetDisplayName(ctx, name)
+	if err != nil {
+		return err
+	}
+	return h.updateOwnProfile(ctx, func(profile *database.Profile) {
+		profile.Displayname = name
+	})
+}
+
+// SetAvatarURL changes the global avatar of the logged-in user. An empty URL removes the avatar.
+func (h *HiClient) SetAvatarURL(ctx context.Context, url id.ContentURIString) error {
+	parsed, err := url.Parse()
+	if err != nil && url != "" {
+		return fmt.Errorf("invalid avatar URL: %w", err)
+	}
+	err = h.Client.SetAvatarURL(ctx, parsed)
+	if err != nil {
+		return err
+	}
+	return h.updateOwnProfile(ctx, func(profile *database.Profile) {
+		profile.AvatarURL = url
+	})
+}
+
+// SetAvatarFromFile uploads the given local file and sets it as the avatar of the logged-in user.
+func (h *HiClient) SetAvatarFromFile(ctx context.Context, path string) (id.ContentURIString, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	mimeType, err := detectMimeType(file, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect mime type: %w", err)
+	}
+	url, _, err := h.uploadReader(ctx, file, stat.Size(), filepath.Base(path), mimeType, false, nil)
+	if err != nil {
+		return "", err
+	}
+	return url, h.SetAvatarURL(ctx, url)
+}
+
+func (h *HiClient) updateOwnProfile(ctx context.Context, update func(profile *database.Profile)) error {
+	userID := h.Account.UserID
+	profile, err := h.DB.Profile.Get(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get cached profile: %w", err)
+	} else if profile == nil {
+		// Fetch the rest of the profile, as only one field is known
+		profile, err = h.GetProfile(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	update(profile)
+	profile.FetchedAt = jsontime.UnixMilliNow()
+	err = h.DB.Profile.Put(ctx, profile)
+	if err != nil {
+		return fmt.Errorf("failed to cache profile: %w", err)
+	}
+	h.EventHandler(&ProfileChanged{UserID: userID, Profile: profile})
+	return nil
+}
+
+// invalidateProfileFromMemberEvent drops the cached profile of the member if the member event changed
+// their displayname or avatar, so that a [ProfileChanged] event is emitted after the sync.
+func (h *HiClient) invalidateProfileFromMemberEvent(ctx context.Context, evt *event.Event) error {
+	if evt.StateKey == nil || gjson.GetBytes(evt.Content.VeryRaw, "membership").Str != string(event.MembershipJoin) {
+		return nil
+	}
+	var prevContent []byte
+	if evt.Unsigned.PrevContent != nil {
+		prevContent = evt.Unsigned.PrevContent.VeryRaw
+	}
+	if len(prevContent) > 0 &&
+		gjson.GetBytes(evt.Content.VeryRaw, "displayname").Str == gjson.GetBytes(prevContent, "displayname").Str &&
+		gjson.GetBytes(evt.Content.VeryRaw, "avatar_url").Str == gjson.GetBytes(prevContent, "avatar_url").Str {
+		return nil
+	}
+	userID := id.UserID(*evt.StateKey)
+	h.Client.ResponseCache.InvalidateProfile(userID)
+	deleted, err := h.DB.Profile.Delete(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate cached profile of %s: %w", userID, err)
+	} else if deleted {
+		syncCtx := ctx.Value(syncContextKey).(*syncContext)
+		if syncCtx.invalidatedProfiles == nil {
+			syncCtx.invalidatedProfiles = make(map[id.UserID]struct{})
+		}
+		syncCtx.invalidatedProfiles[userID] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/qrlogin"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// LoginWithQR logs in by displaying a QR code that is scanned by another device of the user (MSC4108).
+//
+// If the other device sends the cross-signing private keys, this device is marked as verified,
+// the same way as after [HiClient.VerifyWithRecoveryKey].
+func (h *HiClient) LoginWithQR(ctx context.Context, homeserverURL string, params *qrlogin.NewDeviceParams) error {
+	var err error
+	h.Client.HomeserverURL, err = url.Parse(homeserverURL)
+	if err != nil {
+		return err
+	}
+	err = h.CheckServerVersions(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.dispatchCurrentState()
+	paramsCopy := *params
+	paramsCopy.OnLoggedIn = func(ctx context.Context, result *qrlogin.LoginResult) error {
+		err := h.finishLogin(ctx, &database.Account{
+			UserID:        result.UserID,
+			DeviceID:      result.DeviceID,
+			AccessToken:   result.AccessToken,
+			RefreshToken:  result.RefreshToken,
+			TokenEndpoint: result.TokenEndpoint,
+			OAuthClientID: result.ClientID,
+		})
+		if err == nil && params.OnLoggedIn != nil {
+			err = params.OnLoggedIn(ctx, result)
+		}
+		return err
+	}
+	result, err := qrlogin.LoginWithQR(ctx, h.Client, &paramsCopy)
+	if err != nil {
+		return err
+	}
+	if result.Secrets == nil || result.Secrets.CrossSigning == nil {
+		zerolog.Ctx(ctx).Debug().Msg("Other device didn't send cross-signing keys")
+		return nil
+	}
+	err = h.importQRLoginSecrets(ctx, result.Secrets)
+	if err != nil {
+		return err
+	}
+	h.Verified = true
+	if !h.IsSyncing() {
+		go h.Sync()
+	}
+	return nil
+}
+
+var ErrCrossSigningKeyMismatch = errors.New("received cross-signing keys don't match the published keys")
+
+func (h *HiClient) importQRLoginSecrets(ctx context.Context, secrets *qrlogin.Message) error {
+	var seeds crypto.CrossSigningSeeds
+	var err error
+	if seeds.MasterKey, err = qrlogin.DecodeSecret(secrets.CrossSigning.MasterKey); err != nil {
+		return fmt.Errorf("failed to decode master key: %w", err)
+	} else if seeds.SelfSigningKey, err = qrlogin.DecodeSecret(secrets.CrossSigning.SelfSigningKey); err != nil {
+		return fmt.Errorf("failed to decode self-signing key: %w", err)
+	} else if seeds.UserSigningKey, err = qrlogin.DecodeSecret(secrets.CrossSigning.UserSigningKey); err != nil {
+		return fmt.Errorf("failed to decode user signing key: %w", err)
+	}
+	err = h.checkCrossSigningSeeds(ctx, seeds)
+	if err != nil {
+		return err
+	}
+	err = h.Crypto.ImportCrossSigningKeys(seeds)
+	if err != nil {
+		return fmt.Errorf("failed to import cross-signing private keys: %w", err)
+	}
+	err = h.Crypto.SignOwnMasterKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	err = h.storeCrossSigningPrivateKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to store cross-signing private keys: %w", err)
+	}
+	if secrets.Backup == nil || secrets.Backup.Algorithm != id.KeyBackupAlgorithmMegolmBackupV1 {
+		zerolog.Ctx(ctx).Debug().Msg("Other device didn't send a supported key backup key")
+		return nil
+	}
+	keyData, err := qrlogin.DecodeSecret(secrets.Backup.Key)
+	if err != nil {
+		return fmt.Errorf("failed to decode megolm backup key: %w", err)
+	}
+	key, err := backup.MegolmBackupKeyFromBytes(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse megolm backup key: %w", err)
+	}
+	err = h.storeKeyBackupKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	return h.loadKeyBackup(ctx, key)
+}
+
+// checkCrossSigningSeeds ensures that the given private keys match the cross-signing public keys published on the server.
+func (h *HiClient) checkCrossSigningSeeds(ctx context.Context, seeds crypto.CrossSigningSeeds) error {
+	published, err := h.Crypto.GetCrossSigningPublicKeys(ctx, h.Account.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get published cross-signing keys: %w", err)
+	} else if published == nil {
+		return fmt.Errorf("%w: no keys are published", ErrCrossSigningKeyMismatch)
+	}
+	checks := []struct {
+		name      string
+		seed      []byte
+		published id.Ed25519
+	}{
+		{"master", seeds.MasterKey, published.MasterKey},
+		{"self-signing", seeds.SelfSigningKey, published.SelfSigningKey},
+		{"user signing", seeds.UserSigningKey, published.UserSigningKey},
+	}
+	for _, check := range checks {
+		key, err := olm.NewPKSigningFromSeed(check.seed)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s key: %w", check.name, err)
+		} else if key.PublicKey() != check.published {
+			return fmt.Errorf("%w: %s key is different", ErrCrossSigningKeyMismatch, check.name)
+		}
+	}
+	return nil
+}
+
+// GrantQRLogin logs in a new device by scanning the QR code displayed on it (MSC4108).
+// If this device is verified, the new device will be cross-signed and receives the cross-signing and key backup keys.
+func (h *HiClient) GrantQRLogin(ctx context.Context, qrData []byte, params *qrlogin.ExistingDeviceParams) (id.DeviceID, error) {
+	paramsCopy := *params
+	if paramsCopy.Mach == nil && h.Verified {
+		paramsCopy.Mach = h.Crypto
+		paramsCopy.BackupVersion, paramsCopy.BackupKey = h.getKeyBackup()
+	}
+	return qrlogin.GrantLogin(ctx, h.Client, qrData, &paramsCopy)
+}
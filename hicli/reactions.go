@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrAlreadyReacted   = errors.New("already reacted with that key")
+	ErrReactionNotFound = errors.New("reaction not found")
+)
+
+// SendReaction reacts to the given event with the given key.
+// Emoji keys are normalized to include variation selectors, so ❤ and ❤️ are treated as the same reaction.
+//
+// The reaction counts of the target event are updated in the database immediately
+// and a [ReactionsChanged] event is emitted. If the reaction fails to send, the counts are reverted.
+func (h *HiClient) SendReaction(ctx context.Context, roomID id.RoomID, targetEventID id.EventID, key string) (*database.Event, error) {
+	if strings.HasPrefix(string(targetEventID), "~") {
+		return nil, fmt.Errorf("can't react to events that haven't been sent yet")
+	} else if key == "" {
+		return nil, fmt.Errorf("reaction key must not be empty")
+	}
+	key = format.NormalizeReactionKey(key)
+	existing, err := h.DB.Event.GetOwnReaction(ctx, roomID, targetEventID, h.Account.UserID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing reactions: %w", err)
+	} else if existing != nil {
+		return nil, ErrAlreadyReacted
+	}
+	dbEvt, err := h.Send(ctx, roomID, event.EventReaction, &event.ReactionEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: targetEventID,
+			Key:     key,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.recountReactions(ctx, roomID, targetEventID)
+	return dbEvt, nil
+}
+
+// RemoveReaction removes the reaction with the given key that the current user has sent to the given event.
+//
+// Reactions that have already been sent are redacted using [HiClient.RedactEvent], which returns the
+// redaction event and tombstones the reaction locally until the redaction is sent. Reactions that are
+// still waiting in the send queue are cancelled instead, in which case the returned event is nil.
+// In both cases the reaction counts are updated immediately.
+func (h *HiClient) RemoveReaction(ctx context.Context, roomID id.RoomID, targetEventID id.EventID, key string) (*database.Event, error) {
+	key = format.NormalizeReactionKey(key)
+	reaction, err := h.DB.Event.GetOwnReaction(ctx, roomID, targetEventID, h.Account.UserID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reaction: %w", err)
+	} else if reaction == nil {
+		return nil, ErrReactionNotFound
+	}
+	if reaction.SendError != "" {
+		// CancelSend takes care of updating the counts
+		return nil, h.CancelSend(ctx, reaction.TransactionID)
+	}
+	// The local tombstone of the redaction takes care of updating the counts
+	return h.RedactEvent(ctx, roomID, reaction.ID, "")
+}
+
+// reconcileReactions recalculates the reaction counts of the target of the given event if it's a reaction.
+func (h *HiClient) reconcileReactions(ctx context.Context, evt *database.Event) {
+	if evt.Type == event.EventReaction.Type && evt.RelationType == event.RelAnnotation && evt.RelatesTo != "" {
+		h.recountReactions(ctx, evt.RoomID, evt.RelatesTo)
+	}
+}
+
+func (h *HiClient) recountReactions(ctx context.Context, roomID id.RoomID, eventID id.EventID) {
+	counts, err := h.DB.Event.RecountReactions(ctx, roomID, eventID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("event_id", eventID).Msg("Failed to recount reactions")
+	} else if counts != nil {
+		h.EventHandler(&ReactionsChanged{
+			RoomID:    roomID,
+			EventID:   eventID,
+			Reactions: counts,
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const testRoomID = id.RoomID("!room:example.com")
+
+var thumbsUp = format.NormalizeReactionKey("👍")
+
+func insertTestReactionTarget(t *testing.T, h *HiClient) *database.Event {
+	insertTestRoom(t, h, testRoomID)
+	return insertTestEvent(t, h, &event.Event{
+		RoomID:  testRoomID,
+		ID:      "$target",
+		Sender:  "@bob:example.com",
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+	})
+}
+
+func insertTestReaction(t *testing.T, h *HiClient, eventID id.EventID, key string) *database.Event {
+	return insertTestEvent(t, h, &event.Event{
+		RoomID: testRoomID,
+		ID:     eventID,
+		Sender: testUserID,
+		Type:   event.EventReaction,
+		Content: event.Content{Parsed: &event.ReactionEventContent{RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: "$target",
+			Key:     key,
+		}}},
+	})
+}
+
+func getReactionCounts(t *testing.T, h *HiClient) map[string]int {
+	counts, err := h.DB.Event.RecountReactions(context.Background(), testRoomID, "$target")
+	require.NoError(t, err)
+	return counts
+}
+
+func TestHiClient_RemoveReaction_Sent(t *testing.T) {
+	ctx := context.Background()
+	h, collector := newTestClient(t)
+	insertTestReactionTarget(t, h)
+	insertTestReaction(t, h, "$reaction", thumbsUp)
+	require.Equal(t, map[string]int{thumbsUp: 1}, getReactionCounts(t, h))
+
+	redaction, err := h.RemoveReaction(ctx, testRoomID, "$target", thumbsUp)
+	require.NoError(t, err)
+	require.NotNil(t, redaction)
+	assert.Equal(t, event.EventRedaction.Type, redaction.Type)
+
+	reaction, err := h.DB.Event.GetByID(ctx, "$reaction")
+	require.NoError(t, err)
+	assert.Equal(t, redaction.ID, reaction.RedactedBy, "reaction should be tombstoned by the unsent redaction")
+	assert.Empty(t, getReactionCounts(t, h))
+
+	var redacted *EventsRedacted
+	var changed *ReactionsChanged
+	for _, evt := range collector.get() {
+		switch typedEvt := evt.(type) {
+		case *EventsRedacted:
+			redacted = typedEvt
+		case *ReactionsChanged:
+			changed = typedEvt
+		}
+	}
+	require.NotNil(t, redacted)
+	require.Len(t, redacted.Events, 1)
+	assert.Equal(t, redaction.ID, redacted.Events[0].RedactedBy)
+	require.NotNil(t, changed)
+	assert.Empty(t, changed.Reactions)
+
+	_, err = h.RemoveReaction(ctx, testRoomID, "$target", thumbsUp)
+	assert.ErrorIs(t, err, ErrReactionNotFound)
+}
+
+func TestHiClient_RemoveReaction_Unsent(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	insertTestReactionTarget(t, h)
+
+	reaction, err := h.SendReaction(ctx, testRoomID, "$target", thumbsUp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{thumbsUp: 1}, getReactionCounts(t, h))
+	_, err = h.SendReaction(ctx, testRoomID, "$target", thumbsUp)
+	assert.ErrorIs(t, err, ErrAlreadyReacted)
+
+	// Pretend the send queue tried to send the reaction and is waiting to retry
+	require.NoError(t, h.DB.Event.UpdateSendError(ctx, reaction.RowID, "temporary error"))
+	redaction, err := h.RemoveReaction(ctx, testRoomID, "$target", thumbsUp)
+	require.NoError(t, err)
+	assert.Nil(t, redaction, "unsent reactions should be cancelled rather than redacted")
+	assert.Empty(t, getReactionCounts(t, h))
+	entry, err := h.DB.SendQueue.Get(ctx, reaction.RowID)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestHiClient_RemoveReaction_NotFound(t *testing.T) {
+	h, _ := newTestClient(t)
+	insertTestReactionTarget(t, h)
+	insertTestEvent(t, h, &event.Event{
+		RoomID: testRoomID,
+		ID:     "$other_reaction",
+		Sender: "@bob:example.com",
+		Type:   event.EventReaction,
+		Content: event.Content{Parsed: &event.ReactionEventContent{RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: "$target",
+			Key:     thumbsUp,
+		}}},
+	})
+	_, err := h.RemoveReaction(context.Background(), testRoomID, "$target", thumbsUp)
+	assert.ErrorIs(t, err, ErrReactionNotFound, "reactions of other users must not be removed")
+}
+
+func TestHiClient_RemoveReaction_UnnormalizedKey(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	insertTestReactionTarget(t, h)
+	// Reactions sent from other clients may not include variation selectors
+	insertTestReaction(t, h, "$reaction", "👍")
+	require.Equal(t, map[string]int{"👍": 1}, getReactionCounts(t, h))
+
+	_, err := h.SendReaction(ctx, testRoomID, "$target", "👍")
+	assert.ErrorIs(t, err, ErrAlreadyReacted)
+
+	redaction, err := h.RemoveReaction(ctx, testRoomID, "$target", thumbsUp)
+	require.NoError(t, err)
+	require.NotNil(t, redaction)
+	assert.Empty(t, getReactionCounts(t, h))
+}
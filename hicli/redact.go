@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/federation/pdu"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// RedactEvent sends a redaction for the given event.
+//
+// The redaction goes through the send queue like other events. The target event is tombstoned locally
+// (i.e. its redacted_by field is set to the unsent redaction) before the redaction is queued and an
+// [EventsRedacted] event is emitted, but the content is only cleared once the redaction comes down sync.
+// If the redaction fails to send, the tombstone is removed.
+func (h *HiClient) RedactEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) (*database.Event, error) {
+	if strings.HasPrefix(string(eventID), "~") {
+		return nil, fmt.Errorf("can't redact events that haven't been sent yet")
+	}
+	return h.Send(ctx, roomID, event.EventRedaction, &event.RedactionEventContent{
+		Redacts: eventID,
+		Reason:  reason,
+	})
+}
+
+func (h *HiClient) sendRedaction(ctx context.Context, dbEvt *database.Event) (*mautrix.RespSendEvent, error) {
+	var content event.RedactionEventContent
+	err := json.Unmarshal(dbEvt.Content, &content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redaction content: %w", err)
+	}
+	return h.Client.RedactEvent(ctx, dbEvt.RoomID, content.Redacts, mautrix.ReqRedact{
+		Reason: content.Reason,
+		TxnID:  dbEvt.TransactionID,
+	})
+}
+
+// setLocalRedaction adds or removes the local tombstone of the target of an unsent redaction.
+func (h *HiClient) setLocalRedaction(ctx context.Context, redaction *database.Event, apply bool) {
+	if redaction.Type != event.EventRedaction.Type {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Stringer("redaction_event_id", redaction.ID).Logger()
+	var content event.RedactionEventContent
+	err := json.Unmarshal(redaction.Content, &content)
+	if err != nil {
+		log.Err(err).Msg("Failed to parse redaction content")
+		return
+	}
+	target, err := h.DB.Event.GetByID(ctx, content.Redacts)
+	if err != nil {
+		log.Err(err).Msg("Failed to get redaction target")
+		return
+	} else if target == nil || target.RoomID != redaction.RoomID {
+		return
+	}
+	if apply && target.RedactedBy == redaction.ID {
+		// The tombstone was already applied by the event_update_redacted_by trigger when the redaction was inserted
+	} else if apply && target.RedactedBy == "" {
+		target.RedactedBy = redaction.ID
+		err = h.DB.Event.SetRedactedBy(ctx, target.RowID, target.RedactedBy)
+	} else if !apply && target.RedactedBy == redaction.ID {
+		target.RedactedBy = ""
+		err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+			err := h.DB.Event.SetRedactedBy(ctx, target.RowID, target.RedactedBy)
+			if err != nil || target.RelationType != event.RelReplace {
+				// Only edits affect the last edit of another event
+				return err
+			}
+			edits, err := h.DB.Event.GetEditRowIDs(ctx, target.RoomID, target.RelatesTo)
+			if rowIDs := edits[target.RelatesTo]; err == nil && len(rowIDs) > 0 {
+				err = h.DB.Event.SetLastEditRowID(ctx, target.RelatesTo, rowIDs[len(rowIDs)-1])
+			}
+			return err
+		})
+	} else {
+		return
+	}
+	if err != nil {
+		log.Err(err).Bool("apply", apply).Msg("Failed to update local redaction of target event")
+		return
+	}
+	h.reconcileReactions(ctx, target)
+	h.reconcilePoll(ctx, target)
+	if apply {
+		h.EventHandler(&EventsRedacted{
+			RoomID: target.RoomID,
+			Events: []*database.Event{target},
+		})
+	}
+}
+
+// redactedContent applies the redaction algorithm of the room to the content of the given event.
+func redactedContent(room *database.Room, evt *database.Event) json.RawMessage {
+	roomVersion := event.RoomV1
+	if room != nil && room.CreationContent != nil && room.CreationContent.RoomVersion != "" {
+		roomVersion = room.CreationContent.RoomVersion
+	}
+	evtJSON, err := json.Marshal(map[string]any{
+		"type":    evt.Type,
+		"content": evt.Content,
+	})
+	if err == nil {
+		var redacted json.RawMessage
+		redacted, err = pdu.Redact(roomVersion, evtJSON)
+		if err == nil {
+			var parsed struct {
+				Content json.RawMessage `json:"content"`
+			}
+			if err = json.Unmarshal(redacted, &parsed); err == nil && parsed.Content != nil {
+				return parsed.Content
+			}
+		}
+	}
+	if evt.StateKey != nil {
+		// Don't throw away state if the room version is unknown
+		return evt.Content
+	}
+	return json.RawMessage("{}")
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+const retentionPurgeInterval = time.Hour
+
+// PurgeRoomHistory deletes the local copies of events in the room that were sent before the given time.
+// Cached media of the purged events is kept until it's evicted normally.
+//
+// If before is zero, all events except the current room state are deleted and pagination of the room is marked
+// as complete, so the purged events won't be fetched from the server again. Otherwise, the timeline is trimmed
+// to start from the oldest remaining event, and pagination will continue from that event.
+func (h *HiClient) PurgeRoomHistory(ctx context.Context, roomID id.RoomID, before time.Time) error {
+	if before.IsZero() {
+		_, err := h.purgeRoomEvents(ctx, roomID, time.Now(), nil)
+		return err
+	}
+	anchor, err := h.DB.Timeline.GetOldestSince(ctx, roomID, before)
+	if err != nil {
+		return fmt.Errorf("failed to get oldest event to keep in %s: %w", roomID, err)
+	}
+	reset := &paginationReset{}
+	if anchor != nil {
+		resp, err := h.Client.Context(ctx, roomID, anchor.ID, nil, 0)
+		if err != nil {
+			return fmt.Errorf("failed to get pagination token for %s: %w", anchor.ID, err)
+		} else if resp.Start == "" {
+			return fmt.Errorf("server didn't return a pagination token for %s", anchor.ID)
+		}
+		reset.anchor = anchor.TimelineRowID
+		reset.prevBatch = resp.Start
+	}
+	_, err = h.purgeRoomEvents(ctx, roomID, before, reset)
+	return err
+}
+
+// paginationReset specifies where pagination should continue from after a partial purge.
+// If anchor is zero, the whole timeline is cleared and pagination continues from the latest events.
+type paginationReset struct {
+	anchor    database.TimelineRowID
+	prevBatch string
+}
+
+// purgeRoomEvents deletes events sent before the given time. If reset is nil, pagination of the room
+// is marked as complete. Otherwise, timeline entries before the anchor are removed and the given
+// pagination token is stored.
+func (h *HiClient) purgeRoomEvents(ctx context.Context, roomID id.RoomID, before time.Time, reset *paginationReset) (int64, error) {
+	var purged int64
+	err := h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		var err error
+		purged, err = h.DB.Event.Purge(ctx, roomID, before)
+		if err != nil {
+			return fmt.Errorf("failed to delete events: %w", err)
+		} else if purged == 0 {
+			return nil
+		}
+		if reset == nil {
+			err = h.DB.Room.SetPrevBatch(ctx, roomID, database.PrevBatchPaginationComplete)
+			if err != nil {
+				return fmt.Errorf("failed to mark pagination as complete: %w", err)
+			}
+			return nil
+		}
+		// Remove the remaining old events (e.g. current state) from the timeline,
+		// so that it's contiguous with the events that will be paginated next.
+		if reset.anchor != 0 {
+			err = h.DB.Timeline.DeleteBefore(ctx, roomID, reset.anchor)
+		} else {
+			err = h.DB.Timeline.Clear(ctx, roomID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to trim timeline: %w", err)
+		}
+		err = h.DB.Room.SetPrevBatch(ctx, roomID, reset.prevBatch)
+		if err != nil {
+			return fmt.Errorf("failed to reset pagination: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge history of %s: %w", roomID, err)
+	}
+	if purged > 0 {
+		zerolog.Ctx(ctx).Debug().
+			Stringer("room_id", roomID).
+			Time("before", before).
+			Int64("event_count", purged).
+			Msg("Purged local room history")
+		h.EventHandler(&HistoryPurged{RoomID: roomID, Before: jsontime.UM(before)})
+	}
+	return purged, nil
+}
+
+// PurgeMedia deletes all unpinned files from the local media cache that haven't been accessed since the given time.
+func (h *HiClient) PurgeMedia(ctx context.Context, accessedBefore time.Time) error {
+	h.mediaCacheLock.Lock()
+	defer h.mediaCacheLock.Unlock()
+	cached, err := h.DB.CachedMedia.GetUnpinnedAccessedBefore(ctx, accessedBefore)
+	if err != nil {
+		return fmt.Errorf("failed to get cached media: %w", err)
+	}
+	for _, cm := range cached {
+		_, err = h.removeCachedFile(ctx, cm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRetentionPolicy purges events and cached media that are older than
+// [HiClient.EventRetention] and [HiClient.MediaRetention] respectively.
+func (h *HiClient) applyRetentionPolicy(ctx context.Context) error {
+	if h.EventRetention > 0 {
+		before := time.Now().Add(-h.EventRetention)
+		roomIDs, err := h.DB.Event.GetRoomsWithEventsBefore(ctx, before)
+		if err != nil {
+			return fmt.Errorf("failed to get rooms with expired events: %w", err)
+		}
+		for _, roomID := range roomIDs {
+			// Expired events shouldn't be fetched again, so pagination is marked as complete like for full purges
+			_, err = h.purgeRoomEvents(ctx, roomID, before, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if h.MediaRetention > 0 {
+		err := h.PurgeMedia(ctx, time.Now().Add(-h.MediaRetention))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRedactedMedia deletes the cached media of redacted events from disk along with the media entries,
+// so that the local copies of redacted files don't outlive the events. Pinned files are deleted too.
+func (h *HiClient) removeRedactedMedia(ctx context.Context, redacted map[id.RoomID][]*database.Event) {
+	h.mediaCacheLock.Lock()
+	defer h.mediaCacheLock.Unlock()
+	for _, events := range redacted {
+		for _, evt := range events {
+			cached, err := h.DB.CachedMedia.GetByEventRowID(ctx, evt.RowID)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Stringer("event_id", evt.ID).Msg("Failed to get cached media of redacted event")
+				continue
+			}
+			for _, cm := range cached {
+				err = nil
+				if cm.Hash != nil {
+					_, err = h.removeCachedFile(ctx, cm)
+				}
+				if err == nil {
+					err = h.DB.CachedMedia.Delete(ctx, cm.MXC)
+				}
+				if err != nil {
+					zerolog.Ctx(ctx).Err(err).
+						Stringer("event_id", evt.ID).
+						Stringer("mxc", cm.MXC).
+						Msg("Failed to remove cached media of redacted event")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+func insertTestTimelineEvents(t *testing.T, h *HiClient, events ...*event.Event) {
+	t.Helper()
+	rowIDs := make([]database.EventRowID, len(events))
+	for i, evt := range events {
+		evt.RoomID = testRoomID
+		evt.Sender = testUserID
+		if evt.Content.Parsed == nil {
+			evt.Content.Parsed = &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}
+		}
+		rowIDs[i] = insertTestEvent(t, h, evt).RowID
+	}
+	_, err := h.DB.Timeline.Append(context.Background(), testRoomID, rowIDs)
+	require.NoError(t, err)
+}
+
+func getTestTimelineEventIDs(t *testing.T, h *HiClient) []id.EventID {
+	t.Helper()
+	evts, err := h.DB.Timeline.Get(context.Background(), testRoomID, 10, 0)
+	require.NoError(t, err)
+	eventIDs := make([]id.EventID, len(evts))
+	for i, evt := range evts {
+		eventIDs[i] = evt.ID
+	}
+	return eventIDs
+}
+
+func getTestPrevBatch(t *testing.T, h *HiClient) string {
+	t.Helper()
+	room, err := h.DB.Room.Get(context.Background(), testRoomID)
+	require.NoError(t, err)
+	return room.PrevBatch
+}
+
+func TestHiClient_PurgeRoomHistory_Partial(t *testing.T) {
+	ctx := context.Background()
+	h, collector := newTestClient(t)
+	var contextPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextPath = r.URL.Path
+		_, _ = w.Write([]byte(`{"start": "t_anchor"}`))
+	}))
+	t.Cleanup(server.Close)
+	h.Client.HomeserverURL, _ = url.Parse(server.URL)
+	insertTestRoom(t, h, testRoomID)
+	require.NoError(t, h.DB.Room.SetPrevBatch(ctx, testRoomID, "t_old"))
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	insertTestTimelineEvents(t, h,
+		&event.Event{ID: "$old", Type: event.EventMessage, Timestamp: old},
+		&event.Event{ID: "$new1", Type: event.EventMessage},
+		&event.Event{ID: "$new2", Type: event.EventMessage},
+	)
+
+	before := time.Now().Add(-time.Hour)
+	require.NoError(t, h.PurgeRoomHistory(ctx, testRoomID, before))
+	assert.True(t, strings.HasSuffix(contextPath, "/context/$new1"), "pagination token should be fetched for the oldest kept event")
+	assert.Equal(t, "t_anchor", getTestPrevBatch(t, h), "partial purge shouldn't mark pagination as complete")
+	assert.Equal(t, []id.EventID{"$new2", "$new1"}, getTestTimelineEventIDs(t, h))
+	evt, err := h.DB.Event.GetByID(ctx, "$old")
+	require.NoError(t, err)
+	assert.Nil(t, evt)
+	require.Len(t, collector.get(), 1)
+	assert.IsType(t, &HistoryPurged{}, collector.get()[0])
+}
+
+func TestHiClient_PurgeRoomHistory_All(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	insertTestRoom(t, h, testRoomID)
+	require.NoError(t, h.DB.Room.SetPrevBatch(ctx, testRoomID, "t_old"))
+	insertTestTimelineEvents(t, h, &event.Event{ID: "$msg", Type: event.EventMessage, Timestamp: time.Now().Add(-time.Minute).UnixMilli()})
+
+	require.NoError(t, h.PurgeRoomHistory(ctx, testRoomID, time.Time{}))
+	assert.Equal(t, database.PrevBatchPaginationComplete, getTestPrevBatch(t, h))
+	assert.Empty(t, getTestTimelineEventIDs(t, h))
+}
+
+func TestHiClient_ApplyRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	h.EventRetention = time.Hour
+	insertTestRoom(t, h, testRoomID)
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	stateKey := ""
+	insertTestTimelineEvents(t, h,
+		&event.Event{
+			ID:        "$oldstate",
+			Type:      event.StateTopic,
+			StateKey:  &stateKey,
+			Timestamp: old,
+			Content:   event.Content{Parsed: &event.TopicEventContent{Topic: "old topic"}},
+		},
+		&event.Event{ID: "$new", Type: event.EventMessage},
+	)
+
+	roomIDs, err := h.DB.Event.GetRoomsWithEventsBefore(ctx, time.Now().Add(-h.EventRetention))
+	require.NoError(t, err)
+	assert.Equal(t, []id.RoomID{testRoomID}, roomIDs, "old state events that aren't current state should be purged")
+
+	require.NoError(t, h.applyRetentionPolicy(ctx))
+	roomIDs, err = h.DB.Event.GetRoomsWithEventsBefore(ctx, time.Now().Add(-h.EventRetention))
+	require.NoError(t, err)
+	assert.Empty(t, roomIDs)
+	assert.Equal(t, database.PrevBatchPaginationComplete, getTestPrevBatch(t, h))
+	assert.Equal(t, []id.EventID{"$new"}, getTestTimelineEventIDs(t, h))
+}
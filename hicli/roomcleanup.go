@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	// LeftRoomRetention is how long the local data of left rooms is kept before it's deleted.
+	// Rejoining the room before that cancels the deletion.
+	LeftRoomRetention = 7 * 24 * time.Hour
+	// ForgottenRoomGracePeriod is how long the local data of forgotten rooms is kept before it's deleted.
+	// Forgotten rooms are removed from the room list immediately.
+	ForgottenRoomGracePeriod = 5 * time.Minute
+)
+
+const roomCleanupInterval = 5 * time.Minute
+
+// scheduleRoomCleanup schedules or cancels the deletion of the local data of a room
+// based on the membership of the current user.
+func (h *HiClient) scheduleRoomCleanup(ctx context.Context, roomID id.RoomID, membership event.Membership) error {
+	var err error
+	switch membership {
+	case event.MembershipLeave, event.MembershipBan:
+		err = h.DB.Room.MarkForCleanup(ctx, roomID, time.Now().Add(LeftRoomRetention))
+	case event.MembershipJoin:
+		err = h.DB.Room.UnmarkForCleanup(ctx, roomID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update cleanup time of %s: %w", roomID, err)
+	}
+	return nil
+}
+
+// ForgetRoom forgets a room that the user has already left. The room is removed from the room list immediately,
+// and the local data in it is deleted after [ForgottenRoomGracePeriod].
+func (h *HiClient) ForgetRoom(ctx context.Context, roomID id.RoomID) error {
+	_, err := h.Client.ForgetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.DB.Room.ClearSortingTimestamp(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("failed to remove room from room list: %w", err)
+		}
+		err = h.DB.Room.MarkForCleanup(ctx, roomID, time.Now().Add(ForgottenRoomGracePeriod))
+		if err != nil {
+			return fmt.Errorf("failed to schedule room cleanup: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.RoomList.remove(roomID)
+	return nil
+}
+
+// RunRoomCleanup periodically deletes the local data of rooms that were left or forgotten long enough ago.
+// It also applies the retention policy set in [HiClient.EventRetention] and [HiClient.MediaRetention].
+func (h *HiClient) RunRoomCleanup(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "room cleanup").Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(roomCleanupInterval)
+	defer ticker.Stop()
+	var lastRetentionPurge time.Time
+	for {
+		err := h.cleanupRooms(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to clean up left rooms")
+		}
+		if time.Since(lastRetentionPurge) >= retentionPurgeInterval {
+			lastRetentionPurge = time.Now()
+			err = h.applyRetentionPolicy(ctx)
+			if err != nil {
+				log.Err(err).Msg("Failed to apply retention policy")
+			}
+			err = h.pruneURLPreviewCache(ctx)
+			if err != nil {
+				log.Err(err).Msg("Failed to prune URL preview cache")
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *HiClient) cleanupRooms(ctx context.Context) error {
+	now := time.Now()
+	roomIDs, err := h.DB.Room.GetRoomsToCleanUp(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to get rooms to clean up: %w", err)
+	}
+	for _, roomID := range roomIDs {
+		deleted, err := h.DB.Room.DeleteIfCleanupDue(ctx, roomID, now)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", roomID, err)
+		} else if !deleted {
+			continue
+		}
+		zerolog.Ctx(ctx).Debug().Stringer("room_id", roomID).Msg("Deleted local data of left room")
+		h.ephemeralLock.Lock()
+		delete(h.typing, roomID)
+		delete(h.readReceipts, roomID)
+		h.ephemeralLock.Unlock()
+		h.RoomList.remove(roomID)
+		h.EventHandler(&RoomRemoved{RoomID: roomID})
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+func insertTestSpaceEdgeEvent(t *testing.T, h *HiClient, roomID id.RoomID, eventID id.EventID) database.EventRowID {
+	return insertTestEvent(t, h, &event.Event{
+		RoomID:  roomID,
+		ID:      eventID,
+		Sender:  testUserID,
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+	}).RowID
+}
+
+func TestHiClient_CleanupRooms(t *testing.T) {
+	ctx := context.Background()
+	h, collector := newTestClient(t)
+	const spaceID, otherSpaceID, leftRoomID, rejoinedRoomID = "!space:example.com", "!other:example.com", "!left:example.com", "!rejoined:example.com"
+	for _, roomID := range []id.RoomID{spaceID, otherSpaceID, leftRoomID, rejoinedRoomID} {
+		insertTestRoom(t, h, roomID)
+	}
+	childRowID := insertTestSpaceEdgeEvent(t, h, spaceID, "$child")
+	parentRowID := insertTestSpaceEdgeEvent(t, h, leftRoomID, "$parent")
+	require.NoError(t, h.DB.SpaceEdge.SetChild(ctx, &database.SpaceEdge{SpaceID: spaceID, ChildID: leftRoomID, ChildEventRowID: childRowID}))
+	require.NoError(t, h.DB.SpaceEdge.SetParent(ctx, &database.SpaceEdge{SpaceID: spaceID, ChildID: leftRoomID, ParentEventRowID: parentRowID, Canonical: true}))
+	require.NoError(t, h.DB.SpaceEdge.SetParent(ctx, &database.SpaceEdge{SpaceID: otherSpaceID, ChildID: leftRoomID, ParentEventRowID: parentRowID}))
+
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, h.DB.Room.MarkForCleanup(ctx, leftRoomID, past))
+	require.NoError(t, h.DB.Room.MarkForCleanup(ctx, rejoinedRoomID, past))
+	// Rejoining after the cleanup list was fetched must prevent the deletion
+	require.NoError(t, h.DB.Room.UnmarkForCleanup(ctx, rejoinedRoomID))
+	deleted, err := h.DB.Room.DeleteIfCleanupDue(ctx, rejoinedRoomID, time.Now())
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	require.NoError(t, h.cleanupRooms(ctx))
+	room, err := h.DB.Room.Get(ctx, leftRoomID)
+	require.NoError(t, err)
+	assert.Nil(t, room)
+	room, err = h.DB.Room.Get(ctx, rejoinedRoomID)
+	require.NoError(t, err)
+	assert.NotNil(t, room)
+	assert.Equal(t, []any{&RoomRemoved{RoomID: leftRoomID}}, collector.get())
+
+	parents, err := h.DB.SpaceEdge.GetParents(ctx, leftRoomID)
+	require.NoError(t, err)
+	require.Len(t, parents, 1, "only the edge declared by the parent space should be kept")
+	assert.Equal(t, id.RoomID(spaceID), parents[0].SpaceID)
+	assert.Equal(t, childRowID, parents[0].ChildEventRowID)
+	assert.False(t, parents[0].Canonical)
+}
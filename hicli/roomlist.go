@@ -0,0 +1,374 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+type RoomListOrder string
+
+const (
+	// RoomListOrderRecency sorts rooms by the timestamp of the latest message.
+	RoomListOrderRecency RoomListOrder = "recency"
+	// RoomListOrderUnread sorts rooms with notifications first, then rooms with unread messages,
+	// and finally all other rooms. Rooms in each group are sorted by recency.
+	RoomListOrderUnread RoomListOrder = "unread"
+)
+
+// RoomListEntry is a single room in the [RoomList].
+type RoomListEntry struct {
+	RoomID            id.RoomID           `json:"room_id"`
+	Name              string              `json:"name,omitempty"`
+	Avatar            *id.ContentURI      `json:"avatar,omitempty"`
+	PreviewEventRowID database.EventRowID `json:"preview_event_rowid,omitempty"`
+	SortingTimestamp  jsontime.UnixMilli  `json:"sorting_timestamp"`
+	database.UnreadCounts
+
+	IsDirect    bool       `json:"is_direct"`
+	Favourite   bool       `json:"favourite"`
+	LowPriority bool       `json:"low_priority"`
+	Tags        event.Tags `json:"tags,omitempty"`
+}
+
+func (rle *RoomListEntry) section() int {
+	switch {
+	case rle.Favourite:
+		return 0
+	case rle.LowPriority:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (rle *RoomListEntry) unreadRank() int {
+	switch {
+	case rle.UnreadHighlights > 0 || rle.UnreadNotifications > 0:
+		return 0
+	case rle.UnreadMessages > 0:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (rle *RoomListEntry) tagOrder() float64 {
+	tag, ok := rle.Tags[rle.sectionTag()]
+	if !ok || tag.Order == "" {
+		return 2
+	}
+	order, err := tag.Order.Float64()
+	if err != nil {
+		return 2
+	}
+	return order
+}
+
+func (rle *RoomListEntry) sectionTag() event.RoomTag {
+	if rle.Favourite {
+		return event.RoomTagFavourite
+	}
+	return event.RoomTagLowPriority
+}
+
+// RoomListFilter limits the rooms returned by [RoomList.Get]. Empty fields don't filter anything.
+type RoomListFilter struct {
+	DirectOnly bool          `json:"direct_only,omitempty"`
+	GroupOnly  bool          `json:"group_only,omitempty"`
+	UnreadOnly bool          `json:"unread_only,omitempty"`
+	Tag        event.RoomTag `json:"tag,omitempty"`
+}
+
+func (f *RoomListFilter) Match(entry *RoomListEntry) bool {
+	if f == nil {
+		return true
+	}
+	_, hasTag := entry.Tags[f.Tag]
+	return (!f.DirectOnly || entry.IsDirect) &&
+		(!f.GroupOnly || !entry.IsDirect) &&
+		(!f.UnreadOnly || entry.UnreadMessages > 0 || entry.UnreadNotifications > 0 || entry.UnreadHighlights > 0) &&
+		(f.Tag == "" || hasTag)
+}
+
+// RoomList maintains a sorted list of rooms. The list is loaded from the database when it's first requested,
+// after which it's kept up to date based on syncs and [RoomListChanged] events are emitted for any changes.
+type RoomList struct {
+	h      *HiClient
+	lock   sync.Mutex
+	loaded bool
+	order  RoomListOrder
+
+	entries map[id.RoomID]*RoomListEntry
+	sorted  []*RoomListEntry
+	tags    map[id.RoomID]event.Tags
+	direct  map[id.RoomID]struct{}
+}
+
+func newRoomList(h *HiClient) *RoomList {
+	return &RoomList{h: h, order: RoomListOrderRecency}
+}
+
+// Get returns the rooms that match the given filter in the given order.
+// If the order is different from the previous call, the order of later [RoomListChanged] events changes too.
+func (rl *RoomList) Get(ctx context.Context, order RoomListOrder, filter *RoomListFilter) ([]*RoomListEntry, error) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if !rl.loaded {
+		err := rl.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if order != "" && order != rl.order {
+		rl.order = order
+		rl.sort()
+	}
+	output := make([]*RoomListEntry, 0, len(rl.sorted))
+	for _, entry := range rl.sorted {
+		if filter.Match(entry) {
+			output = append(output, entry)
+		}
+	}
+	return output, nil
+}
+
+func (rl *RoomList) load(ctx context.Context) error {
+	rooms, err := rl.h.DB.Room.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get rooms: %w", err)
+	}
+	tags, err := rl.h.DB.AccountData.GetAllRooms(ctx, rl.h.Account.UserID, event.AccountDataRoomTags)
+	if err != nil {
+		return fmt.Errorf("failed to get room tags: %w", err)
+	}
+	directEvt, err := rl.h.DB.AccountData.Get(ctx, rl.h.Account.UserID, event.AccountDataDirectChats)
+	if err != nil {
+		return fmt.Errorf("failed to get direct chat list: %w", err)
+	}
+	rl.tags = make(map[id.RoomID]event.Tags, len(tags))
+	for _, evt := range tags {
+		var content event.TagEventContent
+		if err = json.Unmarshal(evt.Content, &content); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("room_id", evt.RoomID).Msg("Failed to parse room tags")
+			continue
+		}
+		rl.tags[evt.RoomID] = content.Tags
+	}
+	rl.direct = make(map[id.RoomID]struct{})
+	if directEvt != nil {
+		var content event.DirectChatsEventContent
+		if err = json.Unmarshal(directEvt.Content, &content); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to parse direct chat list")
+		}
+		rl.setDirect(content)
+	}
+	rl.entries = make(map[id.RoomID]*RoomListEntry, len(rooms))
+	rl.sorted = make([]*RoomListEntry, 0, len(rooms))
+	for _, room := range rooms {
+		entry := rl.makeEntry(room)
+		rl.entries[room.ID] = entry
+		rl.sorted = append(rl.sorted, entry)
+	}
+	rl.sort()
+	rl.loaded = true
+	return nil
+}
+
+func (rl *RoomList) setDirect(content event.DirectChatsEventContent) {
+	rl.direct = make(map[id.RoomID]struct{})
+	for _, rooms := range content {
+		for _, roomID := range rooms {
+			rl.direct[roomID] = struct{}{}
+		}
+	}
+}
+
+func (rl *RoomList) makeEntry(room *database.Room) *RoomListEntry {
+	entry := &RoomListEntry{
+		RoomID:            room.ID,
+		Avatar:            room.Avatar,
+		PreviewEventRowID: room.PreviewEventRowID,
+		SortingTimestamp:  room.SortingTimestamp,
+		UnreadCounts:      room.UnreadCounts,
+	}
+	if room.Name != nil {
+		entry.Name = *room.Name
+	}
+	return rl.withMetadata(entry)
+}
+
+// withMetadata returns a copy of the entry with the current tags and DM flag.
+func (rl *RoomList) withMetadata(entry *RoomListEntry) *RoomListEntry {
+	newEntry := *entry
+	newEntry.Tags = rl.tags[entry.RoomID]
+	_, newEntry.IsDirect = rl.direct[entry.RoomID]
+	_, newEntry.Favourite = newEntry.Tags[event.RoomTagFavourite]
+	_, newEntry.LowPriority = newEntry.Tags[event.RoomTagLowPriority]
+	return &newEntry
+}
+
+func (rl *RoomList) compare(a, b *RoomListEntry) int {
+	if c := cmp.Compare(a.section(), b.section()); c != 0 {
+		return c
+	}
+	if a.Favourite || a.LowPriority {
+		if c := cmp.Compare(a.tagOrder(), b.tagOrder()); c != 0 {
+			return c
+		}
+	}
+	if rl.order == RoomListOrderUnread {
+		if c := cmp.Compare(a.unreadRank(), b.unreadRank()); c != 0 {
+			return c
+		}
+	}
+	if c := b.SortingTimestamp.Compare(a.SortingTimestamp.Time); c != 0 {
+		return c
+	}
+	return strings.Compare(string(a.RoomID), string(b.RoomID))
+}
+
+func (rl *RoomList) sort() {
+	slices.SortFunc(rl.sorted, rl.compare)
+}
+
+func (rl *RoomList) orderIDs() []id.RoomID {
+	ids := make([]id.RoomID, len(rl.sorted))
+	for i, entry := range rl.sorted {
+		ids[i] = entry.RoomID
+	}
+	return ids
+}
+
+// update applies the given changes to the list and emits a [RoomListChanged] event if anything changed.
+// Rooms whose tags changed must be present in the tags map with their new tags (nil if all tags were removed).
+func (rl *RoomList) update(rooms []*database.Room, tags map[id.RoomID]event.Tags, direct event.DirectChatsEventContent) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if !rl.loaded || (len(rooms) == 0 && len(tags) == 0 && direct == nil) {
+		return
+	}
+	changed := make(map[id.RoomID]*RoomListEntry)
+	for roomID, roomTags := range tags {
+		rl.tags[roomID] = roomTags
+		if entry, ok := rl.entries[roomID]; ok {
+			changed[roomID] = rl.withMetadata(entry)
+		}
+	}
+	if direct != nil {
+		rl.setDirect(direct)
+		for roomID, entry := range rl.entries {
+			if _, isDirect := rl.direct[roomID]; isDirect != entry.IsDirect {
+				changed[roomID] = rl.withMetadata(entry)
+			}
+		}
+	}
+	for _, room := range rooms {
+		if room.SortingTimestamp.UnixMilli() <= 0 {
+			continue
+		}
+		changed[room.ID] = rl.makeEntry(room)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	oldOrder := rl.orderIDs()
+	evt := &RoomListChanged{Updated: make([]*RoomListEntry, 0, len(changed))}
+	for i, entry := range rl.sorted {
+		if newEntry, ok := changed[entry.RoomID]; ok {
+			rl.sorted[i] = newEntry
+		}
+	}
+	for roomID, entry := range changed {
+		if _, exists := rl.entries[roomID]; !exists {
+			rl.sorted = append(rl.sorted, entry)
+		}
+		rl.entries[roomID] = entry
+		evt.Updated = append(evt.Updated, entry)
+	}
+	rl.sort()
+	if newOrder := rl.orderIDs(); !slices.Equal(oldOrder, newOrder) {
+		evt.Order = newOrder
+	}
+	rl.h.EventHandler(evt)
+}
+
+// remove drops the given rooms from the list and emits a [RoomListChanged] event if any of them were in it.
+func (rl *RoomList) remove(roomIDs ...id.RoomID) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if !rl.loaded {
+		return
+	}
+	evt := &RoomListChanged{Updated: []*RoomListEntry{}}
+	for _, roomID := range roomIDs {
+		if _, ok := rl.entries[roomID]; ok {
+			delete(rl.entries, roomID)
+			evt.Removed = append(evt.Removed, roomID)
+		}
+	}
+	if len(evt.Removed) == 0 {
+		return
+	}
+	rl.sorted = slices.DeleteFunc(rl.sorted, func(entry *RoomListEntry) bool {
+		return slices.Contains(evt.Removed, entry.RoomID)
+	})
+	evt.Order = rl.orderIDs()
+	rl.h.EventHandler(evt)
+}
+
+// refreshRooms reloads the given rooms from the database, e.g. after their unread counts changed outside a sync.
+func (rl *RoomList) refreshRooms(ctx context.Context, roomIDs ...id.RoomID) {
+	rl.lock.Lock()
+	loaded := rl.loaded
+	rl.lock.Unlock()
+	if !loaded {
+		return
+	}
+	rooms := make([]*database.Room, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		room, err := rl.h.DB.Room.Get(ctx, roomID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to get room to update room list")
+		} else if room != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	rl.update(rooms, nil, nil)
+}
+
+func (h *HiClient) updateRoomList(syncCtx *syncContext) {
+	rooms := make([]*database.Room, 0, len(syncCtx.evt.Rooms))
+	for _, room := range syncCtx.evt.Rooms {
+		rooms = append(rooms, room.Meta)
+	}
+	h.RoomList.update(rooms, syncCtx.changedTags, syncCtx.directChats)
+}
+
+// reset clears the list, so that it's reloaded from the database when it's requested the next time.
+func (rl *RoomList) reset() {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.loaded = false
+	rl.entries = nil
+	rl.sorted = nil
+	rl.tags = nil
+	rl.direct = nil
+}
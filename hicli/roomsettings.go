@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrInsufficientPowerLevel = errors.New("insufficient power level")
+
+// SetRoomName changes the name of the room. An empty name removes the name.
+func (h *HiClient) SetRoomName(ctx context.Context, roomID id.RoomID, name string) error {
+	return updateStateContent(ctx, h, roomID, event.StateRoomName, func(content *event.RoomNameEventContent) bool {
+		if content.Name == name {
+			return false
+		}
+		content.Name = name
+		return true
+	})
+}
+
+// SetRoomTopic changes the topic of the room. An empty topic removes the topic.
+func (h *HiClient) SetRoomTopic(ctx context.Context, roomID id.RoomID, topic string) error {
+	return updateStateContent(ctx, h, roomID, event.StateTopic, func(content *event.TopicEventContent) bool {
+		if content.Topic == topic {
+			return false
+		}
+		content.Topic = topic
+		return true
+	})
+}
+
+// SetRoomAvatar changes the avatar of the room. An empty URL removes the avatar.
+func (h *HiClient) SetRoomAvatar(ctx context.Context, roomID id.RoomID, url id.ContentURIString) error {
+	return updateStateContent(ctx, h, roomID, event.StateRoomAvatar, func(content *event.RoomAvatarEventContent) bool {
+		if content.URL == url {
+			return false
+		}
+		*content = event.RoomAvatarEventContent{URL: url}
+		return true
+	})
+}
+
+// SetUserPowerLevel changes the power level of the given user in the room.
+//
+// Changing the power level of other users requires having a higher power level than their current level and
+// at least the level being given. The current user can always lower their own power level.
+func (h *HiClient) SetUserPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, level int) error {
+	var permErr error
+	err := updateStateContent(ctx, h, roomID, event.StatePowerLevels, func(content *event.PowerLevelsEventContent) bool {
+		if content.GetUserLevel(userID) == level {
+			return false
+		}
+		actor := h.Account.UserID
+		if userID == actor && level < content.GetUserLevel(actor) {
+			actor = ""
+		}
+		if !content.EnsureUserLevelAs(actor, userID, level) {
+			permErr = ErrInsufficientPowerLevel
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return permErr
+}
+
+// SetJoinRule changes the join rule of the room. The allow list is only kept for restricted join rules.
+func (h *HiClient) SetJoinRule(ctx context.Context, roomID id.RoomID, joinRule event.JoinRule) error {
+	return updateStateContent(ctx, h, roomID, event.StateJoinRules, func(content *event.JoinRulesEventContent) bool {
+		if content.JoinRule == joinRule {
+			return false
+		}
+		content.JoinRule = joinRule
+		if joinRule != event.JoinRuleRestricted && joinRule != event.JoinRuleKnockRestricted {
+			content.Allow = nil
+		}
+		return true
+	})
+}
+
+// SetHistoryVisibility changes the history visibility of the room.
+func (h *HiClient) SetHistoryVisibility(ctx context.Context, roomID id.RoomID, visibility event.HistoryVisibility) error {
+	return updateStateContent(ctx, h, roomID, event.StateHistoryVisibility, func(content *event.HistoryVisibilityEventContent) bool {
+		if content.HistoryVisibility == visibility {
+			return false
+		}
+		content.HistoryVisibility = visibility
+		return true
+	})
+}
+
+// updateStateContent reads the cached state event with an empty state key, lets the update function modify it
+// and sends the modified content to the room. If the update function returns false, nothing is sent.
+func updateStateContent[T any](
+	ctx context.Context,
+	h *HiClient,
+	roomID id.RoomID,
+	evtType event.Type,
+	update func(content *T) bool,
+) error {
+	evt, err := h.GetStateEvent(ctx, roomID, evtType, "")
+	if err != nil {
+		return fmt.Errorf("failed to get current %s event: %w", evtType.Type, err)
+	}
+	var content *T
+	if evt != nil {
+		content, _ = evt.Content.Parsed.(*T)
+	}
+	if content == nil {
+		content = new(T)
+	}
+	if !update(content) {
+		return nil
+	}
+	_, err = h.Client.SendStateEvent(ctx, roomID, evtType, "", content)
+	return err
+}
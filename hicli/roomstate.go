@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrUnknownRoom = errors.New("unknown room")
+
+// GetStateEvent returns the current state event with the given type and state key in the given room,
+// with the content already parsed. If the event doesn't exist, nil is returned.
+//
+// Member events are lazy-loaded, so if the room's member list hasn't been fetched yet and the requested
+// member isn't stored locally, it's fetched from the server using /members. If the room doesn't have
+// any state stored (e.g. rooms that haven't been synced fully), the full state is fetched using /state.
+func (h *HiClient) GetStateEvent(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey string) (*event.Event, error) {
+	dbEvt, err := h.DB.CurrentState.Get(ctx, roomID, evtType, stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state event from database: %w", err)
+	} else if dbEvt == nil {
+		var fetchMembers, refetch bool
+		fetchMembers, refetch, err = h.shouldLoadState(ctx, roomID, evtType)
+		if err != nil {
+			return nil, err
+		} else if !fetchMembers && !refetch {
+			return nil, nil
+		}
+		_, err = h.GetRoomState(ctx, roomID, fetchMembers, refetch)
+		if err != nil {
+			return nil, err
+		}
+		dbEvt, err = h.DB.CurrentState.Get(ctx, roomID, evtType, stateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get state event from database: %w", err)
+		} else if dbEvt == nil {
+			return nil, nil
+		}
+	}
+	evt := dbEvt.AsRawMautrix()
+	err = evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrUnsupportedContentType) {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("event_id", evt.ID).
+			Stringer("event_type", &evt.Type).
+			Msg("Failed to parse state event content")
+	}
+	return evt, nil
+}
+
+// GetAllMembers returns the member event content of every user in the room, regardless of membership.
+//
+// If the room's member list hasn't been fetched yet, it's fetched from the server using /members first.
+func (h *HiClient) GetAllMembers(ctx context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error) {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, ErrUnknownRoom
+	} else if !room.HasMemberList {
+		_, err = h.GetRoomState(ctx, roomID, true, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	evts, err := h.DB.CurrentState.GetAllOfType(ctx, roomID, event.StateMember)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members from database: %w", err)
+	}
+	members := make(map[id.UserID]*event.MemberEventContent, len(evts))
+	for _, dbEvt := range evts {
+		evt := dbEvt.AsRawMautrix()
+		err = evt.Content.ParseRaw(evt.Type)
+		if err != nil || evt.StateKey == nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to parse member event")
+			continue
+		}
+		members[id.UserID(*evt.StateKey)] = evt.Content.AsMember()
+	}
+	return members, nil
+}
+
+// shouldLoadState checks whether a missing state event of the given type may exist on the server,
+// and whether it should be fetched using /members or /state.
+func (h *HiClient) shouldLoadState(ctx context.Context, roomID id.RoomID, evtType event.Type) (fetchMembers, refetch bool, err error) {
+	var room *database.Room
+	room, err = h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		err = fmt.Errorf("failed to get room from database: %w", err)
+		return
+	} else if room == nil {
+		err = ErrUnknownRoom
+		return
+	}
+	createEvt, err := h.DB.CurrentState.Get(ctx, roomID, event.StateCreate, "")
+	if err != nil {
+		err = fmt.Errorf("failed to get create event from database: %w", err)
+		return
+	}
+	// Every room has a create event, so if it's missing, the state hasn't been loaded at all
+	refetch = createEvt == nil
+	fetchMembers = !refetch && evtType == event.StateMember && !room.HasMemberList
+	return
+}
@@ -47,6 +47,21 @@ func (h *HiClient) SendMessage(ctx context.Context, roomID id.RoomID, text, medi
 	} else {
 		content = format.RenderMarkdown(text, true, false)
 	}
+	if mediaPath != "" {
+		mediaContent, err := h.UploadFile(ctx, roomID, mediaPath)
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			// Use the text as a caption
+			mediaContent.FileName = mediaContent.Body
+			mediaContent.Body = content.Body
+			mediaContent.Format = content.Format
+			mediaContent.FormattedBody = content.FormattedBody
+		}
+		mediaContent.Mentions = content.Mentions
+		content = *mediaContent
+	}
 	if mentions != nil {
 		content.Mentions.Room = mentions.Room
 		for _, userID := range mentions.UserIDs {
@@ -58,9 +73,61 @@ func (h *HiClient) SendMessage(ctx context.Context, roomID id.RoomID, text, medi
 	if replyTo != "" {
 		content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(replyTo)
 	}
+	if mediaPath == "" {
+		h.addURLPreviews(ctx, roomID, &content)
+	}
 	return h.Send(ctx, roomID, event.EventMessage, &content)
 }
 
+// EditMessage sends an edit replacing the text of the given message. If the target is an edit itself,
+// the new edit will replace the original message instead.
+//
+// The edit is sent through the send queue like any other message. The last edit row ID of the target
+// is updated immediately and an [EditQueued] event is emitted, so that the edit can be rendered before it's sent.
+func (h *HiClient) EditMessage(ctx context.Context, roomID id.RoomID, targetEventID id.EventID, newText string) (*database.Event, error) {
+	target, err := h.DB.Event.GetByID(ctx, targetEventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target event: %w", err)
+	} else if target != nil && target.RelationType == event.RelReplace {
+		target, err = h.DB.Event.GetByID(ctx, target.RelatesTo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get original event of target edit: %w", err)
+		}
+	}
+	if target == nil || target.RoomID != roomID {
+		return nil, fmt.Errorf("target event not found")
+	} else if target.Sender != h.Account.UserID {
+		return nil, fmt.Errorf("can't edit messages sent by other users")
+	} else if target.RedactedBy != "" {
+		return nil, fmt.Errorf("can't edit redacted messages")
+	} else if target.SendError != "" || strings.HasPrefix(string(target.ID), "~") {
+		return nil, fmt.Errorf("can't edit messages that haven't been sent yet")
+	} else if target.Type != event.EventMessage.Type && target.DecryptedType != event.EventMessage.Type {
+		return nil, fmt.Errorf("can't edit %s events", target.Type)
+	}
+	content := format.RenderMarkdown(newText, true, false)
+	content.SetEdit(target.ID)
+	dbEvt, err := h.Send(ctx, roomID, event.EventMessage, &content)
+	if err != nil {
+		return nil, err
+	}
+	err = h.DB.Event.SetLastEditRowID(ctx, target.ID, dbEvt.RowID)
+	if err != nil {
+		// The edit has already been queued, so don't fail the whole call
+		zerolog.Ctx(ctx).Err(err).
+			Stringer("target_event_id", target.ID).
+			Msg("Failed to update last edit row ID of edit target")
+	} else {
+		target.LastEditRowID = ptr.Ptr(dbEvt.RowID)
+	}
+	h.EventHandler(&EditQueued{
+		RoomID: roomID,
+		Target: target,
+		Edit:   dbEvt,
+	})
+	return dbEvt, nil
+}
+
 func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, receiptType event.ReceiptType) error {
 	content := &mautrix.ReqSetReadMarkers{
 		FullyRead: eventID,
@@ -76,10 +143,12 @@ func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.Ev
 	if err != nil {
 		return fmt.Errorf("failed to mark event as read: %w", err)
 	}
+	h.markActive(ctx)
 	return nil
 }
 
 func (h *HiClient) SetTyping(ctx context.Context, roomID id.RoomID, timeout time.Duration) error {
+	h.markActive(ctx)
 	_, err := h.Client.UserTyping(ctx, roomID, timeout > 0, timeout)
 	return err
 }
@@ -94,7 +163,7 @@ func (h *HiClient) Send(ctx context.Context, roomID id.RoomID, evtType event.Typ
 	var decryptedType event.Type
 	var decryptedContent json.RawMessage
 	var megolmSessionID id.SessionID
-	if roomMeta.EncryptionEvent != nil && evtType != event.EventReaction {
+	if roomMeta.EncryptionEvent != nil && evtType != event.EventReaction && evtType != event.EventRedaction {
 		decryptedType = evtType
 		decryptedContent, err = json.Marshal(content)
 		if err != nil {
@@ -133,10 +202,31 @@ func (h *HiClient) Send(ctx context.Context, roomID id.RoomID, evtType event.Typ
 		Reactions:       map[string]int{},
 		LastEditRowID:   ptr.Ptr(database.EventRowID(0)),
 	}
-	_, err = h.DB.Event.Insert(ctx, dbEvt)
+	// Hold the send queue lock so that the event can't be sent (or fail) before the local redaction is applied
+	h.sendQueueLock.Lock()
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := h.DB.Event.Insert(ctx, dbEvt)
+		if err != nil {
+			return fmt.Errorf("failed to insert event into database: %w", err)
+		}
+		err = h.DB.SendQueue.Put(ctx, &database.SendQueueEntry{
+			EventRowID:  dbEvt.RowID,
+			RoomID:      roomID,
+			NextAttempt: dbEvt.Timestamp,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add event to send queue: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		h.setLocalRedaction(ctx, dbEvt, true)
+	}
+	h.sendQueueLock.Unlock()
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert event into database: %w", err)
+		return nil, err
 	}
+	h.wakeupSendQueue()
 	ctx = context.WithoutCancel(ctx)
 	go func() {
 		err := h.SetTyping(ctx, roomID, 0)
@@ -144,36 +234,6 @@ func (h *HiClient) Send(ctx context.Context, roomID id.RoomID, evtType event.Typ
 			zerolog.Ctx(ctx).Err(err).Msg("Failed to stop typing while sending message")
 		}
 	}()
-	go func() {
-		var err error
-		defer func() {
-			h.EventHandler(&SendComplete{
-				Event: dbEvt,
-				Error: err,
-			})
-		}()
-		var resp *mautrix.RespSendEvent
-		resp, err = h.Client.SendMessageEvent(ctx, roomID, evtType, content, mautrix.ReqSendEvent{
-			Timestamp:     dbEvt.Timestamp.UnixMilli(),
-			TransactionID: txnID,
-			DontEncrypt:   true,
-		})
-		if err != nil {
-			dbEvt.SendError = err.Error()
-			err = fmt.Errorf("failed to send event: %w", err)
-			err2 := h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
-			if err2 != nil {
-				zerolog.Ctx(ctx).Err(err2).AnErr("send_error", err).
-					Msg("Failed to update send error in database after sending failed")
-			}
-			return
-		}
-		dbEvt.ID = resp.EventID
-		err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
-		if err != nil {
-			err = fmt.Errorf("failed to update event ID in database: %w", err)
-		}
-	}()
 	return dbEvt, nil
 }
 
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func insertTestOwnMessage(t *testing.T, h *HiClient, eventID id.EventID) {
+	insertTestEvent(t, h, &event.Event{
+		RoomID:  testRoomID,
+		ID:      eventID,
+		Sender:  testUserID,
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}},
+	})
+}
+
+func TestHiClient_EditMessage_Unsent(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	insertTestRoom(t, h, testRoomID)
+	insertTestOwnMessage(t, h, "~txn")
+
+	_, err := h.EditMessage(ctx, testRoomID, "~txn", "hi")
+	assert.ErrorContains(t, err, "haven't been sent yet")
+	edits, err := h.DB.Event.GetRelated(ctx, testRoomID, "~txn", event.RelReplace)
+	require.NoError(t, err)
+	assert.Empty(t, edits, "no edit should be queued")
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+)
+
+const (
+	sendRetryInitialBackoff = 2 * time.Second
+	sendRetryMaxBackoff     = 5 * time.Minute
+)
+
+var (
+	ErrEventNotFound    = errors.New("event not found")
+	ErrEventAlreadySent = errors.New("event was already sent")
+	ErrSendInProgress   = errors.New("event is currently being sent")
+	ErrSendCancelled    = errors.New("sending was cancelled")
+)
+
+func sendRetryBackoff(attempts int) time.Duration {
+	backoff := sendRetryInitialBackoff
+	for i := 1; i < attempts && backoff < sendRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, sendRetryMaxBackoff)
+}
+
+// isRetryableSendError returns true if sending should be retried later,
+// i.e. the server couldn't be reached, rate limited the request or had a temporary failure.
+func isRetryableSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if errors.Is(err, context.Canceled) || !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		return true
+	}
+	return httpErr.Response.StatusCode == http.StatusTooManyRequests || httpErr.Response.StatusCode >= 500
+}
+
+func (h *HiClient) wakeupSendQueue() {
+	select {
+	case h.sendQueueWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// RunSendQueue sends queued outgoing events until the context is canceled.
+//
+// Events are sent one at a time in the order they were queued within each room.
+// If sending fails with a temporary error, the event is retried with exponential backoff,
+// and later events in the same room wait until it has been sent or cancelled.
+func (h *HiClient) RunSendQueue(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "send queue").Logger()
+	ctx = log.WithContext(ctx)
+	log.Info().Msg("Starting send queue")
+	defer func() {
+		log.Info().Msg("Stopping send queue")
+	}()
+	for {
+		entries, err := h.DB.SendQueue.GetDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("Failed to get queued events to send")
+		}
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			h.sendQueuedEvent(ctx, entry)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		var timer <-chan time.Time
+		next, err := h.DB.SendQueue.GetNextAttempt(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("Failed to get next send queue attempt time")
+			timer = time.After(sendRetryMaxBackoff)
+		} else if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.sendQueueWakeup:
+		case <-timer:
+		}
+	}
+}
+
+func (h *HiClient) sendQueuedEvent(ctx context.Context, entry *database.SendQueueEntry) {
+	log := zerolog.Ctx(ctx).With().Int64("event_rowid", int64(entry.EventRowID)).Logger()
+	h.sendQueueLock.Lock()
+	// Make sure the event wasn't cancelled after the queue was fetched
+	entry, err := h.DB.SendQueue.Get(ctx, entry.EventRowID)
+	if err != nil || entry == nil {
+		h.sendQueueLock.Unlock()
+		if err != nil {
+			log.Err(err).Msg("Failed to get send queue entry")
+		}
+		return
+	}
+	h.sendingRowID = entry.EventRowID
+	h.sendQueueLock.Unlock()
+	defer func() {
+		h.sendQueueLock.Lock()
+		h.sendingRowID = 0
+		h.sendQueueLock.Unlock()
+	}()
+
+	dbEvt, err := h.DB.Event.GetByRowID(ctx, entry.EventRowID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get queued event")
+		return
+	} else if dbEvt == nil {
+		log.Warn().Msg("Queued event not found, removing from queue")
+		err = h.DB.SendQueue.Delete(ctx, entry.EventRowID)
+		if err != nil {
+			log.Err(err).Msg("Failed to remove missing event from send queue")
+		}
+		return
+	}
+	var resp *mautrix.RespSendEvent
+	if dbEvt.Type == event.EventRedaction.Type {
+		resp, err = h.sendRedaction(ctx, dbEvt)
+	} else {
+		evtType := event.Type{Type: dbEvt.Type, Class: event.MessageEventType}
+		resp, err = h.Client.SendMessageEvent(ctx, dbEvt.RoomID, evtType, dbEvt.Content, mautrix.ReqSendEvent{
+			Timestamp:     dbEvt.Timestamp.UnixMilli(),
+			TransactionID: dbEvt.TransactionID,
+			DontEncrypt:   true,
+		})
+	}
+	if err != nil && ctx.Err() != nil {
+		// The queue is being stopped, the event will be sent again when it's restarted
+		return
+	} else if err != nil {
+		retry := isRetryableSendError(err)
+		dbEvt.SendError = err.Error()
+		log.Err(err).Bool("will_retry", retry).Int("attempts", entry.Attempts+1).Msg("Failed to send queued event")
+		err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+			if retry {
+				entry.Attempts++
+				entry.NextAttempt = jsontime.UM(time.Now().Add(sendRetryBackoff(entry.Attempts)))
+				err = h.DB.SendQueue.Put(ctx, entry)
+			} else {
+				err = h.DB.SendQueue.Delete(ctx, entry.EventRowID)
+			}
+			if err != nil {
+				return err
+			}
+			return h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
+		})
+		if err != nil {
+			log.Err(err).Msg("Failed to update send queue after sending failed")
+		}
+		if !retry {
+			h.setLocalRedaction(ctx, dbEvt, false)
+			h.reconcileReactions(ctx, dbEvt)
+			h.reconcilePoll(ctx, dbEvt)
+		}
+		h.EventHandler(&SendComplete{
+			Event:    dbEvt,
+			Error:    fmt.Errorf("failed to send event: %s", dbEvt.SendError),
+			Retrying: retry,
+		})
+		return
+	}
+	localID := dbEvt.ID
+	dbEvt.ID = resp.EventID
+	dbEvt.SendError = ""
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.DB.SendQueue.Delete(ctx, entry.EventRowID)
+		if err != nil {
+			return err
+		}
+		err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
+		if err != nil || dbEvt.Type != event.EventRedaction.Type {
+			return err
+		}
+		// Point the local tombstone of the redaction target to the real event ID
+		return h.DB.Event.ReplaceRedactedBy(ctx, localID, dbEvt.ID)
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to update event ID in database")
+		err = fmt.Errorf("failed to update event ID in database: %w", err)
+	}
+	h.EventHandler(&SendComplete{
+		Event: dbEvt,
+		Error: err,
+	})
+}
+
+func (h *HiClient) getUnsentEvent(ctx context.Context, txnID string) (*database.Event, error) {
+	dbEvt, err := h.DB.Event.GetByTransactionID(ctx, txnID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	} else if dbEvt == nil {
+		return nil, ErrEventNotFound
+	} else if dbEvt.SendError == "" {
+		return nil, ErrEventAlreadySent
+	}
+	return dbEvt, nil
+}
+
+// RetrySend queues an event that failed to send to be sent again immediately.
+// If the event is still in the queue waiting for a retry, the backoff is skipped.
+func (h *HiClient) RetrySend(ctx context.Context, txnID string) error {
+	h.sendQueueLock.Lock()
+	defer h.sendQueueLock.Unlock()
+	dbEvt, err := h.getUnsentEvent(ctx, txnID)
+	if err != nil {
+		return err
+	} else if h.sendingRowID == dbEvt.RowID {
+		return ErrSendInProgress
+	}
+	err = h.DB.SendQueue.Put(ctx, &database.SendQueueEntry{
+		EventRowID:  dbEvt.RowID,
+		RoomID:      dbEvt.RoomID,
+		NextAttempt: jsontime.UnixMilliNow(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue event: %w", err)
+	}
+	h.setLocalRedaction(ctx, dbEvt, true)
+	h.reconcileReactions(ctx, dbEvt)
+	h.reconcilePoll(ctx, dbEvt)
+	h.wakeupSendQueue()
+	return nil
+}
+
+// CancelSend removes an unsent event from the send queue. The event stays in the database
+// with a send error, so it can still be sent later using [HiClient.RetrySend].
+func (h *HiClient) CancelSend(ctx context.Context, txnID string) error {
+	h.sendQueueLock.Lock()
+	defer h.sendQueueLock.Unlock()
+	dbEvt, err := h.getUnsentEvent(ctx, txnID)
+	if err != nil {
+		return err
+	} else if h.sendingRowID == dbEvt.RowID {
+		return ErrSendInProgress
+	}
+	dbEvt.SendError = ErrSendCancelled.Error()
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.DB.SendQueue.Delete(ctx, dbEvt.RowID)
+		if err != nil {
+			return err
+		}
+		return h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove event from send queue: %w", err)
+	}
+	h.setLocalRedaction(ctx, dbEvt, false)
+	h.reconcileReactions(ctx, dbEvt)
+	h.reconcilePoll(ctx, dbEvt)
+	h.EventHandler(&SendComplete{
+		Event: dbEvt,
+		Error: ErrSendCancelled,
+	})
+	// Later events in the same room may have been waiting for this one
+	h.wakeupSendQueue()
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SettingsAccountDataPrefix is the prefix of the account data event types used to store client settings.
+// Each settings namespace is stored in its own account data event.
+const SettingsAccountDataPrefix = "fi.mau.hicli.settings."
+
+var ErrInvalidSettingsNamespace = errors.New("invalid settings namespace")
+
+// SettingValue is a single synced setting. Deleted settings are kept with a null value,
+// so that a concurrent write from another client with an older value doesn't resurrect them.
+type SettingValue struct {
+	Value     json.RawMessage    `json:"value"`
+	UpdatedAt jsontime.UnixMilli `json:"updated_at"`
+	UpdatedBy id.DeviceID        `json:"updated_by,omitempty"`
+}
+
+func (sv *SettingValue) isDeleted() bool {
+	return sv == nil || len(sv.Value) == 0 || bytes.Equal(sv.Value, []byte("null"))
+}
+
+// settingsContent is the content of a settings account data event.
+type settingsContent map[string]*SettingValue
+
+// mergeFrom merges the other settings into this one, keeping the newer value of each setting.
+// Entries that are null rather than a setting object are dropped from both.
+func (sc settingsContent) mergeFrom(other settingsContent) {
+	for key, val := range sc {
+		if val == nil {
+			delete(sc, key)
+		}
+	}
+	for key, otherVal := range other {
+		if otherVal == nil {
+			continue
+		} else if existing, ok := sc[key]; !ok || otherVal.UpdatedAt.After(existing.UpdatedAt.Time) {
+			sc[key] = otherVal
+		}
+	}
+}
+
+// values returns the current values of all settings that haven't been deleted. Null entries are treated as deleted.
+func (sc settingsContent) values() map[string]json.RawMessage {
+	values := make(map[string]json.RawMessage, len(sc))
+	for key, val := range sc {
+		if !val.isDeleted() {
+			values[key] = val.Value
+		}
+	}
+	return values
+}
+
+func settingsEventType(namespace string) (event.Type, error) {
+	if namespace == "" || strings.ContainsAny(namespace, " \t\n/") {
+		return event.Type{}, fmt.Errorf("%w %q", ErrInvalidSettingsNamespace, namespace)
+	}
+	return event.Type{Type: SettingsAccountDataPrefix + namespace, Class: event.AccountDataEventType}, nil
+}
+
+// GetSettings returns all settings in the given namespace from the local account data cache.
+func (h *HiClient) GetSettings(ctx context.Context, namespace string) (map[string]json.RawMessage, error) {
+	evtType, err := settingsEventType(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var content settingsContent
+	_, err = h.getAccountDataContent(ctx, evtType, &content)
+	if err != nil {
+		return nil, err
+	}
+	return content.values(), nil
+}
+
+// SetSetting changes a single setting in the given namespace. Setting the value to nil deletes the setting.
+//
+// The latest settings are fetched from the server before writing, and each setting keeps the value with the
+// newest timestamp, so clients changing different settings at the same time don't overwrite each other.
+// A [SettingsChanged] event is emitted after the change has been saved.
+func (h *HiClient) SetSetting(ctx context.Context, namespace, key string, value any) error {
+	evtType, err := settingsEventType(namespace)
+	if err != nil {
+		return err
+	}
+	var valueJSON json.RawMessage
+	if value != nil {
+		valueJSON, err = json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal setting value: %w", err)
+		}
+	}
+	h.settingsLock.Lock()
+	defer h.settingsLock.Unlock()
+	var content settingsContent
+	_, err = h.getAccountDataContent(ctx, evtType, &content)
+	if err != nil {
+		return err
+	}
+	var serverContent settingsContent
+	err = h.Client.GetAccountData(ctx, evtType.Type, &serverContent)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("failed to get latest settings from server: %w", err)
+	}
+	if content == nil {
+		content = make(settingsContent)
+	}
+	content.mergeFrom(serverContent)
+	content[key] = &SettingValue{
+		Value:     valueJSON,
+		UpdatedAt: jsontime.UnixMilliNow(),
+		UpdatedBy: h.Account.DeviceID,
+	}
+	err = h.setAccountData(ctx, evtType, content)
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	changed := &SettingsChanged{Namespace: namespace}
+	if valueJSON == nil {
+		changed.Removed = []string{key}
+	} else {
+		changed.Changed = map[string]json.RawMessage{key: valueJSON}
+	}
+	h.EventHandler(changed)
+	return nil
+}
+
+// diffSettings compares a settings account data event received in sync to the locally cached version.
+// It returns nil if nothing changed.
+func (h *HiClient) diffSettings(ctx context.Context, evt *event.Event) (*SettingsChanged, error) {
+	var oldContent, newContent settingsContent
+	_, err := h.getAccountDataContent(ctx, evt.Type, &oldContent)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(evt.Content.VeryRaw, &newContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %w", err)
+	}
+	oldValues := oldContent.values()
+	newValues := newContent.values()
+	changed := &SettingsChanged{
+		Namespace: strings.TrimPrefix(evt.Type.Type, SettingsAccountDataPrefix),
+		Changed:   make(map[string]json.RawMessage),
+	}
+	for key, newVal := range newValues {
+		if oldVal, ok := oldValues[key]; !ok || !bytes.Equal(oldVal, newVal) {
+			changed.Changed[key] = newVal
+		}
+	}
+	for key := range oldValues {
+		if _, ok := newValues[key]; !ok {
+			changed.Removed = append(changed.Removed, key)
+		}
+	}
+	if len(changed.Changed) == 0 && len(changed.Removed) == 0 {
+		return nil, nil
+	}
+	return changed, nil
+}
+
+func isSettingsEvent(evtType event.Type) bool {
+	return strings.HasPrefix(evtType.Type, SettingsAccountDataPrefix)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func parseTestSettings(t *testing.T, data string) settingsContent {
+	var content settingsContent
+	require.NoError(t, json.Unmarshal([]byte(data), &content))
+	return content
+}
+
+func TestSettingsContent_MergeFrom_Null(t *testing.T) {
+	local := parseTestSettings(t, `{
+		"a": {"value": 1, "updated_at": 100},
+		"b": null
+	}`)
+	server := parseTestSettings(t, `{
+		"a": null,
+		"b": {"value": 2, "updated_at": 100},
+		"c": null
+	}`)
+	local.mergeFrom(server)
+	assert.Equal(t, map[string]json.RawMessage{
+		"a": json.RawMessage("1"),
+		"b": json.RawMessage("2"),
+	}, local.values())
+	assert.NotContains(t, local, "c")
+}
+
+func TestSettingsContent_Values_Null(t *testing.T) {
+	content := parseTestSettings(t, `{
+		"a": {"value": true, "updated_at": 100},
+		"b": {"value": null, "updated_at": 100},
+		"c": null
+	}`)
+	assert.Equal(t, map[string]json.RawMessage{"a": json.RawMessage("true")}, content.values())
+}
+
+func TestHiClient_DiffSettings_Null(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	evtType, err := settingsEventType("test")
+	require.NoError(t, err)
+	require.NoError(t, h.DB.AccountData.Put(ctx, testUserID, evtType, json.RawMessage(`{
+		"a": {"value": 1, "updated_at": 100},
+		"b": {"value": 2, "updated_at": 100},
+		"c": null
+	}`)))
+	changed, err := h.diffSettings(ctx, &event.Event{
+		Type: evtType,
+		Content: event.Content{VeryRaw: json.RawMessage(`{
+			"a": null,
+			"b": {"value": 3, "updated_at": 200},
+			"c": null
+		}`)},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, changed)
+	assert.Equal(t, "test", changed.Namespace)
+	assert.Equal(t, map[string]json.RawMessage{"b": json.RawMessage("3")}, changed.Changed)
+	assert.Equal(t, []string{"a"}, changed.Removed)
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const slidingSyncListName = "all_rooms"
+
+var (
+	// SlidingSyncBatchSize is the number of rooms that the sliding sync room list range is expanded by
+	// in each request until it covers all rooms. The most recently active rooms are always synced first.
+	SlidingSyncBatchSize = 100
+	// SlidingSyncTimelineLimit is the maximum number of timeline events to request per room.
+	// Older events are fetched using pagination when needed.
+	SlidingSyncTimelineLimit = 20
+	// SlidingSyncTimeout is the long-polling timeout for sliding sync requests once all rooms are in range.
+	SlidingSyncTimeout = 30 * time.Second
+)
+
+// SlidingSyncRequiredState is the list of state events requested for each room when using sliding sync.
+// Other state is fetched on demand, e.g. when the member list is opened.
+var SlidingSyncRequiredState = []mautrix.SlidingSyncRequiredState{
+	{event.StateCreate.Type, ""},
+	{event.StateRoomName.Type, ""},
+	{event.StateRoomAvatar.Type, ""},
+	{event.StateTopic.Type, ""},
+	{event.StateCanonicalAlias.Type, ""},
+	{event.StateEncryption.Type, ""},
+	{event.StatePowerLevels.Type, ""},
+	{event.StateJoinRules.Type, ""},
+	{event.StateTombstone.Type, ""},
+	{event.StatePinnedEvents.Type, ""},
+	{event.StateSpaceChild.Type, mautrix.SlidingSyncStateKeyWildcard},
+	{event.StateSpaceParent.Type, mautrix.SlidingSyncStateKeyWildcard},
+	{event.StateMember.Type, mautrix.SlidingSyncStateKeyMe},
+	{event.StateMember.Type, mautrix.SlidingSyncStateKeyLazy},
+}
+
+// ShouldUseSlidingSync returns true if sliding sync is enabled and the server supports it.
+func (h *HiClient) ShouldUseSlidingSync() bool {
+	return h.UseSlidingSync && h.Client.SpecVersions.Supports(mautrix.FeatureSimplifiedSlidingSync)
+}
+
+func (h *HiClient) makeSlidingSyncRequest(rangeEnd int) *mautrix.ReqSlidingSync {
+	req := &mautrix.ReqSlidingSync{
+		Pos:         h.Account.SlidingSyncPos,
+		SetPresence: (*hiSyncer)(h).GetSyncPresence(),
+		Extensions: &mautrix.SlidingSyncRequestExtensions{
+			ToDevice: &mautrix.SlidingSyncExtensionToDevice{Enabled: true, Since: h.Account.ToDeviceSince},
+			E2EE:     &mautrix.SlidingSyncExtensionToggle{Enabled: true},
+		},
+	}
+	// Rooms are not synced until the device is verified, like with the /sync filter
+	if h.Verified {
+		req.Lists = map[string]*mautrix.SlidingSyncList{
+			slidingSyncListName: {
+				SlidingSyncRoomConfig: mautrix.SlidingSyncRoomConfig{
+					RequiredState: SlidingSyncRequiredState,
+					TimelineLimit: SlidingSyncTimelineLimit,
+				},
+				Ranges: []mautrix.SlidingSyncRange{{0, rangeEnd}},
+			},
+		}
+		req.Extensions.AccountData = &mautrix.SlidingSyncExtensionToggle{Enabled: true}
+		req.Extensions.Receipts = &mautrix.SlidingSyncExtensionToggle{Enabled: true}
+		req.Extensions.Typing = &mautrix.SlidingSyncExtensionToggle{Enabled: true}
+	}
+	return req
+}
+
+// runSlidingSync is the sliding sync equivalent of [mautrix.Client.SyncWithContext]. The room list range starts
+// from the most recently active rooms and grows until it covers all rooms, after which requests long-poll
+// for incremental updates.
+func (h *HiClient) runSlidingSync(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+	rangeEnd := SlidingSyncBatchSize - 1
+	fullyExpanded := false
+	for ctx.Err() == nil {
+		req := h.makeSlidingSyncRequest(rangeEnd)
+		if fullyExpanded && h.firstSyncReceived {
+			req.Timeout = int(SlidingSyncTimeout.Milliseconds())
+		}
+		resp, err := h.Client.SlidingSync(ctx, req)
+		if ctx.Err() != nil {
+			return nil
+		} else if errors.Is(err, mautrix.MUnknownPos) {
+			log.Warn().Str("pos", req.Pos).Msg("Sliding sync position expired, restarting from scratch")
+			h.Account.SlidingSyncPos = ""
+			rangeEnd = SlidingSyncBatchSize - 1
+			fullyExpanded = false
+			continue
+		} else if err != nil {
+			duration, err := (*hiSyncer)(h).OnFailedSync(nil, err)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-time.After(duration):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		err = h.processSlidingSyncResponse(ctx, resp, req.Pos)
+		if err != nil {
+			return fmt.Errorf("failed to process sliding sync response: %w", err)
+		}
+		if list, ok := resp.Lists[slidingSyncListName]; ok {
+			fullyExpanded = rangeEnd >= list.Count-1
+			if !fullyExpanded {
+				rangeEnd += SlidingSyncBatchSize
+				log.Debug().
+					Int("room_count", list.Count).
+					Int("range_end", rangeEnd).
+					Msg("Expanding sliding sync room list range")
+			}
+		} else if !h.Verified {
+			fullyExpanded = true
+		}
+	}
+	return nil
+}
+
+func (h *HiClient) processSlidingSyncResponse(ctx context.Context, resp *mautrix.RespSlidingSync, since string) error {
+	syncResp := h.slidingSyncToSyncResponse(resp)
+	return h.handleSyncResponse(ctx, syncResp, since, func(ctx context.Context) error {
+		toDeviceSince := h.Account.ToDeviceSince
+		if resp.Extensions.ToDevice != nil && resp.Extensions.ToDevice.NextBatch != "" {
+			toDeviceSince = resp.Extensions.ToDevice.NextBatch
+		}
+		err := h.DB.Account.PutSlidingSyncPos(ctx, h.Account.UserID, resp.Pos, toDeviceSince)
+		if err != nil {
+			return fmt.Errorf("failed to save sliding sync position: %w", err)
+		}
+		h.Account.SlidingSyncPos = resp.Pos
+		h.Account.ToDeviceSince = toDeviceSince
+		return nil
+	})
+}
+
+// slidingSyncToSyncResponse converts a sliding sync response into the /sync format,
+// so that it can be processed by the same pipeline as normal sync responses.
+func (h *HiClient) slidingSyncToSyncResponse(resp *mautrix.RespSlidingSync) *mautrix.RespSync {
+	syncResp := &mautrix.RespSync{
+		NextBatch: resp.Pos,
+		Rooms: mautrix.RespSyncRooms{
+			Join:  make(map[id.RoomID]*mautrix.SyncJoinedRoom, len(resp.Rooms)),
+			Leave: make(map[id.RoomID]*mautrix.SyncLeftRoom),
+		},
+	}
+	ext := resp.Extensions
+	if ext.ToDevice != nil {
+		syncResp.ToDevice.Events = ext.ToDevice.Events
+	}
+	if ext.E2EE != nil {
+		syncResp.DeviceLists = ext.E2EE.DeviceLists
+		syncResp.DeviceOTKCount = ext.E2EE.DeviceOneTimeKeysCount
+		syncResp.FallbackKeys = ext.E2EE.DeviceUnusedFallbackKeyTypes
+	}
+	getJoinedRoom := func(roomID id.RoomID) *mautrix.SyncJoinedRoom {
+		room, ok := syncResp.Rooms.Join[roomID]
+		if !ok {
+			room = &mautrix.SyncJoinedRoom{}
+			syncResp.Rooms.Join[roomID] = room
+		}
+		return room
+	}
+	for roomID, room := range resp.Rooms {
+		if len(room.InviteState) > 0 {
+			// Invites aren't handled by hicli yet
+			continue
+		}
+		summary := mautrix.LazyLoadSummary{
+			JoinedMemberCount:  room.JoinedCount,
+			InvitedMemberCount: room.InvitedCount,
+		}
+		for _, hero := range room.Heroes {
+			summary.Heroes = append(summary.Heroes, hero.UserID)
+		}
+		state := mautrix.SyncEventsList{Events: room.RequiredState}
+		timeline := mautrix.SyncTimeline{
+			SyncEventsList: mautrix.SyncEventsList{Events: room.Timeline},
+			Limited:        room.Limited,
+			PrevBatch:      room.PrevBatch,
+		}
+		switch h.getOwnMembership(room) {
+		case event.MembershipLeave, event.MembershipBan:
+			syncResp.Rooms.Leave[roomID] = &mautrix.SyncLeftRoom{
+				Summary:  summary,
+				State:    state,
+				Timeline: timeline,
+			}
+		default:
+			joinedRoom := getJoinedRoom(roomID)
+			joinedRoom.Summary = summary
+			joinedRoom.State = state
+			joinedRoom.Timeline = timeline
+			joinedRoom.UnreadNotifications = &mautrix.UnreadNotificationCounts{
+				NotificationCount: room.NotificationCount,
+				HighlightCount:    room.HighlightCount,
+			}
+		}
+	}
+	if ext.AccountData != nil {
+		syncResp.AccountData.Events = ext.AccountData.Global
+		for roomID, evts := range ext.AccountData.Rooms {
+			if _, isLeft := syncResp.Rooms.Leave[roomID]; !isLeft {
+				joinedRoom := getJoinedRoom(roomID)
+				joinedRoom.AccountData.Events = append(joinedRoom.AccountData.Events, evts...)
+			}
+		}
+	}
+	for _, ephemeral := range []*mautrix.SlidingSyncRoomEventsResponse{ext.Receipts, ext.Typing} {
+		if ephemeral == nil {
+			continue
+		}
+		for roomID, evt := range ephemeral.Rooms {
+			if _, isLeft := syncResp.Rooms.Leave[roomID]; !isLeft {
+				joinedRoom := getJoinedRoom(roomID)
+				joinedRoom.Ephemeral.Events = append(joinedRoom.Ephemeral.Events, evt)
+			}
+		}
+	}
+	return syncResp
+}
+
+// getOwnMembership finds the latest membership of the current user in the given sliding sync room data.
+func (h *HiClient) getOwnMembership(room *mautrix.SlidingSyncRoom) event.Membership {
+	var membership event.Membership
+	for _, evts := range [][]*event.Event{room.RequiredState, room.Timeline} {
+		for _, evt := range evts {
+			if evt.Type.Type == event.StateMember.Type && evt.StateKey != nil && id.UserID(*evt.StateKey) == h.Account.UserID {
+				if newMembership := gjson.GetBytes(evt.Content.VeryRaw, "membership").Str; newMembership != "" {
+					membership = event.Membership(newMembership)
+				}
+			}
+		}
+	}
+	return membership
+}
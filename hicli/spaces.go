@@ -0,0 +1,278 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxHierarchyPages limits how many pages are requested from /hierarchy for a single space.
+const maxHierarchyPages = 10
+
+// SpaceHierarchyRoom is a single room in a space hierarchy returned by [HiClient.GetSpaceHierarchy].
+type SpaceHierarchyRoom struct {
+	RoomID           id.RoomID           `json:"room_id"`
+	RoomType         event.RoomType      `json:"room_type,omitempty"`
+	Name             string              `json:"name,omitempty"`
+	Topic            string              `json:"topic,omitempty"`
+	AvatarURL        id.ContentURIString `json:"avatar_url,omitempty"`
+	CanonicalAlias   id.RoomAlias        `json:"canonical_alias,omitempty"`
+	JoinRule         event.JoinRule      `json:"join_rule,omitempty"`
+	NumJoinedMembers int                 `json:"num_joined_members,omitempty"`
+	WorldReadable    bool                `json:"world_readable,omitempty"`
+	GuestCanJoin     bool                `json:"guest_can_join,omitempty"`
+	// Known is true if the room is stored in the local database, i.e. the user is or has been in the room.
+	Known bool `json:"known"`
+	// Children contains the edges to the child rooms if the room is a space.
+	Children []*database.SpaceEdge `json:"children,omitempty"`
+}
+
+type SpaceHierarchy struct {
+	SpaceID id.RoomID `json:"space_id"`
+	// Rooms contains all rooms in the hierarchy in breadth-first order, starting with the space itself.
+	Rooms []*SpaceHierarchyRoom `json:"rooms"`
+	// LocalOnly is true if the hierarchy couldn't be fetched from the server and only contains locally known rooms.
+	LocalOnly bool `json:"local_only,omitempty"`
+}
+
+// GetSpaceHierarchy returns the rooms in the given space and its subspaces.
+//
+// The hierarchy is fetched from the server using /hierarchy, which includes rooms the user hasn't joined.
+// Metadata of rooms that are stored locally overrides the data from the server, and edges that are only
+// known locally (e.g. rooms which declare the space as a parent) are merged in. If the server request
+// fails, the hierarchy is built using only local data.
+func (h *HiClient) GetSpaceHierarchy(ctx context.Context, spaceID id.RoomID) (*SpaceHierarchy, error) {
+	hierarchy := &SpaceHierarchy{SpaceID: spaceID}
+	rooms := make(map[id.RoomID]*SpaceHierarchyRoom)
+	serverRooms, err := h.fetchSpaceHierarchy(ctx, spaceID)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("space_id", spaceID).
+			Msg("Failed to fetch space hierarchy from server, using local data only")
+		hierarchy.LocalOnly = true
+	}
+	for _, chunk := range serverRooms {
+		rooms[chunk.RoomID] = roomFromHierarchyChunk(chunk)
+	}
+	queue := []id.RoomID{spaceID}
+	visited := make(map[id.RoomID]struct{})
+	for len(queue) > 0 {
+		roomID := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[roomID]; ok {
+			continue
+		}
+		visited[roomID] = struct{}{}
+		room, err := h.mergeLocalSpaceRoom(ctx, roomID, rooms[roomID])
+		if err != nil {
+			return nil, err
+		} else if room == nil {
+			// Not known locally or by the server
+			continue
+		}
+		rooms[roomID] = room
+		hierarchy.Rooms = append(hierarchy.Rooms, room)
+		for _, child := range room.Children {
+			queue = append(queue, child.ChildID)
+		}
+	}
+	return hierarchy, nil
+}
+
+func (h *HiClient) fetchSpaceHierarchy(ctx context.Context, spaceID id.RoomID) ([]*mautrix.ChildRoomsChunk, error) {
+	var chunks []*mautrix.ChildRoomsChunk
+	req := &mautrix.ReqHierarchy{}
+	for i := 0; i < maxHierarchyPages; i++ {
+		resp, err := h.Client.Hierarchy(ctx, spaceID, req)
+		if err != nil {
+			return chunks, err
+		}
+		for j := range resp.Rooms {
+			chunks = append(chunks, &resp.Rooms[j])
+		}
+		if resp.NextBatch == "" {
+			break
+		}
+		req.From = resp.NextBatch
+	}
+	return chunks, nil
+}
+
+func roomFromHierarchyChunk(chunk *mautrix.ChildRoomsChunk) *SpaceHierarchyRoom {
+	room := &SpaceHierarchyRoom{
+		RoomID:           chunk.RoomID,
+		RoomType:         chunk.RoomType,
+		Name:             chunk.Name,
+		Topic:            chunk.Topic,
+		CanonicalAlias:   chunk.CanonicalAlias,
+		JoinRule:         chunk.JoinRule,
+		NumJoinedMembers: chunk.NumJoinedMembers,
+		WorldReadable:    chunk.WorldReadble,
+		GuestCanJoin:     chunk.GuestCanJoin,
+	}
+	if !chunk.AvatarURL.IsEmpty() {
+		room.AvatarURL = chunk.AvatarURL.CUString()
+	}
+	for _, state := range chunk.ChildrenState {
+		if state.Type != event.StateSpaceChild || !strings.HasPrefix(state.StateKey, "!") {
+			continue
+		}
+		var content event.SpaceChildEventContent
+		if err := json.Unmarshal(state.Content.VeryRaw, &content); err != nil || len(content.Via) == 0 {
+			continue
+		}
+		room.Children = append(room.Children, &database.SpaceEdge{
+			SpaceID:   chunk.RoomID,
+			ChildID:   id.RoomID(state.StateKey),
+			Order:     content.Order,
+			Suggested: content.Suggested,
+		})
+	}
+	return room
+}
+
+// mergeLocalSpaceRoom fills the given hierarchy room with locally stored metadata and space edges.
+// If the room isn't known by the server, a new hierarchy room is created from local data.
+func (h *HiClient) mergeLocalSpaceRoom(ctx context.Context, roomID id.RoomID, room *SpaceHierarchyRoom) (*SpaceHierarchyRoom, error) {
+	dbRoom, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room %s from database: %w", roomID, err)
+	}
+	localEdges, err := h.DB.SpaceEdge.GetChildren(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get children of %s from database: %w", roomID, err)
+	}
+	if room == nil {
+		if dbRoom == nil {
+			return nil, nil
+		}
+		room = &SpaceHierarchyRoom{RoomID: roomID}
+	}
+	if dbRoom != nil {
+		room.Known = true
+		if dbRoom.CreationContent != nil {
+			room.RoomType = dbRoom.CreationContent.Type
+		}
+		if dbRoom.Name != nil {
+			room.Name = *dbRoom.Name
+		}
+		if dbRoom.Topic != nil {
+			room.Topic = *dbRoom.Topic
+		}
+		if dbRoom.Avatar != nil && !dbRoom.Avatar.IsEmpty() {
+			room.AvatarURL = dbRoom.Avatar.CUString()
+		}
+		if dbRoom.CanonicalAlias != nil {
+			room.CanonicalAlias = *dbRoom.CanonicalAlias
+		}
+	}
+	// Prefer local edges, as they have the event row IDs and include edges declared by the child
+	serverEdges := room.Children
+	room.Children = localEdges
+	for _, serverEdge := range serverEdges {
+		found := false
+		for _, localEdge := range localEdges {
+			if localEdge.ChildID == serverEdge.ChildID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			room.Children = append(room.Children, serverEdge)
+		}
+	}
+	return room, nil
+}
+
+// processSpaceEdge updates the space graph based on a m.space.child or m.space.parent state event.
+// The ID of the affected space is returned, or an empty string if the event isn't a space edge.
+func (h *HiClient) processSpaceEdge(ctx context.Context, evt *database.Event) (id.RoomID, error) {
+	if evt.StateKey == nil || !strings.HasPrefix(*evt.StateKey, "!") {
+		return "", nil
+	}
+	otherRoomID := id.RoomID(*evt.StateKey)
+	var err error
+	var spaceID id.RoomID
+	switch evt.Type {
+	case event.StateSpaceChild.Type:
+		var content event.SpaceChildEventContent
+		_ = json.Unmarshal(evt.Content, &content)
+		spaceID = evt.RoomID
+		if len(content.Via) == 0 {
+			err = h.DB.SpaceEdge.RemoveChild(ctx, spaceID, otherRoomID)
+		} else {
+			err = h.DB.SpaceEdge.SetChild(ctx, &database.SpaceEdge{
+				SpaceID:         spaceID,
+				ChildID:         otherRoomID,
+				ChildEventRowID: evt.RowID,
+				Order:           content.Order,
+				Suggested:       content.Suggested,
+			})
+		}
+	case event.StateSpaceParent.Type:
+		var content event.SpaceParentEventContent
+		_ = json.Unmarshal(evt.Content, &content)
+		spaceID = otherRoomID
+		if len(content.Via) == 0 {
+			err = h.DB.SpaceEdge.RemoveParent(ctx, spaceID, evt.RoomID)
+		} else {
+			err = h.DB.SpaceEdge.SetParent(ctx, &database.SpaceEdge{
+				SpaceID:          spaceID,
+				ChildID:          evt.RoomID,
+				ParentEventRowID: evt.RowID,
+				Canonical:        content.Canonical,
+			})
+		}
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update space edge between %s and %s: %w", evt.RoomID, otherRoomID, err)
+	}
+	return spaceID, nil
+}
+
+// updateSpaceEdge processes a state event received in sync and marks the affected space as changed,
+// so that a [SpacesChanged] event is emitted after the sync has been processed.
+func (h *HiClient) updateSpaceEdge(ctx context.Context, evt *database.Event) error {
+	spaceID, err := h.processSpaceEdge(ctx, evt)
+	if err != nil || spaceID == "" {
+		return err
+	}
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
+	if !slices.Contains(syncCtx.changedSpaces, spaceID) {
+		syncCtx.changedSpaces = append(syncCtx.changedSpaces, spaceID)
+	}
+	return nil
+}
+
+func (h *HiClient) emitSpaceChanges(ctx context.Context, spaceIDs []id.RoomID) {
+	if len(spaceIDs) == 0 {
+		return
+	}
+	evt := &SpacesChanged{Spaces: make(map[id.RoomID][]*database.SpaceEdge, len(spaceIDs))}
+	for _, spaceID := range spaceIDs {
+		children, err := h.DB.SpaceEdge.GetChildren(ctx, spaceID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("space_id", spaceID).Msg("Failed to get space children")
+			continue
+		}
+		evt.Spaces[spaceID] = children
+	}
+	h.EventHandler(evt)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+type StickerPackSource string
+
+const (
+	// StickerPackSourceUser is the personal pack stored in the user's im.ponies.user_emotes account data.
+	StickerPackSourceUser StickerPackSource = "user"
+	// StickerPackSourceRoom is a pack defined in the state of the room the packs were requested for.
+	StickerPackSourceRoom StickerPackSource = "room"
+	// StickerPackSourceGlobal is a pack from another room that the user has enabled in im.ponies.emote_rooms.
+	StickerPackSourceGlobal StickerPackSource = "global"
+)
+
+type StickerPack struct {
+	Source   StickerPackSource            `json:"source"`
+	RoomID   id.RoomID                    `json:"room_id,omitempty"`
+	StateKey string                       `json:"state_key,omitempty"`
+	Content  *event.ImagePackEventContent `json:"content"`
+}
+
+func (h *HiClient) getAccountDataContent(ctx context.Context, evtType event.Type, into any) (bool, error) {
+	data, err := h.DB.AccountData.Get(ctx, h.Account.UserID, evtType)
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s account data: %w", evtType.Type, err)
+	} else if data == nil {
+		return false, nil
+	}
+	err = json.Unmarshal(data.Content, into)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s account data: %w", evtType.Type, err)
+	}
+	return true, nil
+}
+
+func (h *HiClient) setAccountData(ctx context.Context, evtType event.Type, content any) error {
+	err := h.Client.SetAccountData(ctx, evtType.Type, content)
+	if err != nil {
+		return err
+	}
+	// Store the new data locally immediately, sync will overwrite it with the same content later
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return h.DB.AccountData.Put(ctx, h.Account.UserID, evtType, contentJSON)
+}
+
+func parseImagePack(evt *database.Event) (*event.ImagePackEventContent, error) {
+	var pack event.ImagePackEventContent
+	err := json.Unmarshal(evt.Content, &pack)
+	if err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// GetStickerPacks returns all sticker packs that can be used in the given room:
+// the user's personal pack, packs defined in the room's state and packs the user has enabled globally.
+func (h *HiClient) GetStickerPacks(ctx context.Context, roomID id.RoomID) ([]*StickerPack, error) {
+	var packs []*StickerPack
+	var userPack event.ImagePackEventContent
+	if found, err := h.getAccountDataContent(ctx, event.AccountDataUnstableImagePack, &userPack); err != nil {
+		return nil, err
+	} else if found {
+		packs = append(packs, &StickerPack{Source: StickerPackSourceUser, Content: &userPack})
+	}
+	if roomID != "" {
+		roomPacks, err := h.DB.CurrentState.GetAllOfType(ctx, roomID, event.StateUnstableImagePack)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room sticker packs: %w", err)
+		}
+		for _, evt := range roomPacks {
+			pack, err := parseImagePack(evt)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to parse room image pack")
+				continue
+			}
+			packs = append(packs, &StickerPack{Source: StickerPackSourceRoom, RoomID: roomID, StateKey: *evt.StateKey, Content: pack})
+		}
+	}
+	var globalPacks event.ImagePackRoomsEventContent
+	if _, err := h.getAccountDataContent(ctx, event.AccountDataUnstableImagePackRooms, &globalPacks); err != nil {
+		return nil, err
+	}
+	for packRoomID, stateKeys := range globalPacks.Rooms {
+		if packRoomID == roomID {
+			// Already included above
+			continue
+		}
+		for stateKey := range stateKeys {
+			evt, err := h.DB.CurrentState.Get(ctx, packRoomID, event.StateUnstableImagePack, stateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get sticker pack %s in %s: %w", stateKey, packRoomID, err)
+			} else if evt == nil {
+				continue
+			}
+			pack, err := parseImagePack(evt)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to parse global image pack")
+				continue
+			}
+			packs = append(packs, &StickerPack{Source: StickerPackSourceGlobal, RoomID: packRoomID, StateKey: stateKey, Content: pack})
+		}
+	}
+	return packs, nil
+}
+
+// SetPersonalStickerPack replaces the user's personal image pack.
+func (h *HiClient) SetPersonalStickerPack(ctx context.Context, pack *event.ImagePackEventContent) error {
+	return h.setAccountData(ctx, event.AccountDataUnstableImagePack, pack)
+}
+
+// AddStickerPack enables the given room sticker pack for the user in all rooms.
+func (h *HiClient) AddStickerPack(ctx context.Context, roomID id.RoomID, stateKey string) error {
+	var content event.ImagePackRoomsEventContent
+	if _, err := h.getAccountDataContent(ctx, event.AccountDataUnstableImagePackRooms, &content); err != nil {
+		return err
+	}
+	content.Add(roomID, stateKey)
+	return h.setAccountData(ctx, event.AccountDataUnstableImagePackRooms, &content)
+}
+
+// RemoveStickerPack disables a sticker pack previously enabled with AddStickerPack.
+func (h *HiClient) RemoveStickerPack(ctx context.Context, roomID id.RoomID, stateKey string) error {
+	var content event.ImagePackRoomsEventContent
+	if found, err := h.getAccountDataContent(ctx, event.AccountDataUnstableImagePackRooms, &content); err != nil || !found {
+		return err
+	}
+	content.Remove(roomID, stateKey)
+	return h.setAccountData(ctx, event.AccountDataUnstableImagePackRooms, &content)
+}
+
+// SendSticker sends a sticker from an image pack to the given room.
+func (h *HiClient) SendSticker(ctx context.Context, roomID id.RoomID, sticker *event.ImagePackImage, replyTo id.EventID) (*database.Event, error) {
+	content := &event.MessageEventContent{
+		Body: sticker.Body,
+		URL:  sticker.URL,
+		Info: sticker.Info,
+	}
+	if content.Info == nil {
+		content.Info = &event.FileInfo{}
+	}
+	if replyTo != "" {
+		content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(replyTo)
+	}
+	return h.Send(ctx, roomID, event.EventSticker, content)
+}
+
+// SendCustomSticker uploads the given image and sends it as a sticker. The image is encrypted if the room is encrypted.
+func (h *HiClient) SendCustomSticker(ctx context.Context, roomID id.RoomID, data []byte, body string, info *event.FileInfo, replyTo id.EventID) (*database.Event, error) {
+	if info == nil {
+		info = &event.FileInfo{}
+	}
+	url, file, err := h.UploadMedia(ctx, roomID, data, body, info.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	info.Size = len(data)
+	content := &event.MessageEventContent{
+		Body: body,
+		URL:  url,
+		File: file,
+		Info: info,
+	}
+	if replyTo != "" {
+		content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(replyTo)
+	}
+	return h.Send(ctx, roomID, event.EventSticker, content)
+}
@@ -8,6 +8,7 @@ package hicli
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -31,7 +32,57 @@ import (
 type syncContext struct {
 	shouldWakeupRequestQueue bool
 
-	evt *SyncComplete
+	evt          *SyncComplete
+	redacted     map[id.RoomID][]*database.Event
+	changedPolls map[id.RoomID][]id.EventID
+
+	changedSpaces   []id.RoomID
+	changedTags     map[id.RoomID]event.Tags
+	changedPins     map[id.RoomID][]id.EventID
+	changedSettings []*SettingsChanged
+	directChats     event.DirectChatsEventContent
+
+	invalidatedProfiles map[id.UserID]struct{}
+	confirmedEvents     []*EventConfirmed
+
+	typing   map[id.RoomID][]id.UserID
+	receipts map[id.RoomID][]*database.Receipt
+	presence map[id.UserID]*event.PresenceEventContent
+
+	pushRooms map[id.RoomID]*pushRoom
+
+	urlPreviews []*urlPreviewRequest
+}
+
+// markPollChanged marks the poll that the given event relates to as changed, so that
+// a [PollUpdated] event is emitted after the sync has been processed.
+func (sc *syncContext) markPollChanged(evt *database.Event) {
+	if !isPollRelation(evt) {
+		return
+	}
+	if sc.changedPolls == nil {
+		sc.changedPolls = make(map[id.RoomID][]id.EventID)
+	}
+	if !slices.Contains(sc.changedPolls[evt.RoomID], evt.RelatesTo) {
+		sc.changedPolls[evt.RoomID] = append(sc.changedPolls[evt.RoomID], evt.RelatesTo)
+	}
+}
+
+// markPinsChanged stores the new pinned event list of the room, so that
+// a [PinnedEventsChanged] event is emitted after the sync has been processed.
+func (sc *syncContext) markPinsChanged(roomID id.RoomID, evt *event.Event) {
+	var content event.PinnedEventsEventContent
+	err := json.Unmarshal(evt.Content.VeryRaw, &content)
+	if err != nil {
+		return
+	}
+	if sc.changedPins == nil {
+		sc.changedPins = make(map[id.RoomID][]id.EventID)
+	}
+	if content.Pinned == nil {
+		content.Pinned = []id.EventID{}
+	}
+	sc.changedPins[roomID] = content.Pinned
 }
 
 func (h *HiClient) preProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) error {
@@ -64,15 +115,51 @@ func (h *HiClient) preProcessSyncResponse(ctx context.Context, resp *mautrix.Res
 
 func (h *HiClient) postProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) {
 	h.Crypto.HandleOTKCounts(ctx, &resp.DeviceOTKCount)
+	h.dispatchToDeviceHandlers(ctx, resp.ToDevice.Events)
 	go h.asyncPostProcessSyncResponse(ctx, resp, since)
 	syncCtx := ctx.Value(syncContextKey).(*syncContext)
 	if syncCtx.shouldWakeupRequestQueue {
 		h.WakeupRequestQueue()
 	}
 	h.firstSyncReceived = true
+	if pending := h.pendingIntegrityProblems.Swap(nil); pending != nil {
+		go h.finishStartupIntegrityCheck(ctx, *pending)
+	}
 	if !syncCtx.evt.IsEmpty() {
 		h.EventHandler(syncCtx.evt)
 	}
+	for roomID, events := range syncCtx.redacted {
+		h.EventHandler(&EventsRedacted{
+			RoomID: roomID,
+			Events: events,
+		})
+	}
+	if len(syncCtx.redacted) > 0 {
+		go h.removeRedactedMedia(ctx, syncCtx.redacted)
+	}
+	h.dispatchEphemeral(syncCtx)
+	h.emitSpaceChanges(ctx, syncCtx.changedSpaces)
+	h.updateRoomList(syncCtx)
+	for userID := range syncCtx.invalidatedProfiles {
+		h.EventHandler(&ProfileChanged{UserID: userID})
+	}
+	for _, evt := range syncCtx.confirmedEvents {
+		h.EventHandler(evt)
+	}
+	for roomID, pinned := range syncCtx.changedPins {
+		h.EventHandler(&PinnedEventsChanged{RoomID: roomID, Pinned: pinned})
+	}
+	for _, changed := range syncCtx.changedSettings {
+		h.EventHandler(changed)
+	}
+	for roomID, polls := range syncCtx.changedPolls {
+		for _, pollEventID := range polls {
+			h.updatePoll(ctx, roomID, pollEventID)
+		}
+	}
+	if len(syncCtx.urlPreviews) > 0 {
+		go h.fetchQueuedURLPreviews(ctx, syncCtx.urlPreviews)
+	}
 }
 
 func (h *HiClient) asyncPostProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) {
@@ -100,6 +187,15 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 
 	for _, evt := range resp.AccountData.Events {
 		evt.Type.Class = event.AccountDataEventType
+		if isSettingsEvent(evt.Type) {
+			changed, err := h.diffSettings(ctx, evt)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("event_type", evt.Type.Type).Msg("Failed to compare settings in sync")
+			} else if changed != nil {
+				syncCtx := ctx.Value(syncContextKey).(*syncContext)
+				syncCtx.changedSettings = append(syncCtx.changedSettings, changed)
+			}
+		}
 		err := h.DB.AccountData.Put(ctx, h.Account.UserID, evt.Type, evt.Content.VeryRaw)
 		if err != nil {
 			return fmt.Errorf("failed to save account data event %s: %w", evt.Type.Type, err)
@@ -112,8 +208,37 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 				h.PushRules.Store(pushRules.Ruleset)
 				zerolog.Ctx(ctx).Debug().Msg("Updated push rules from sync")
 			}
+		} else if evt.Type == event.AccountDataIgnoredUserList {
+			var content event.IgnoredUserListEventContent
+			err = json.Unmarshal(evt.Content.VeryRaw, &content)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to parse ignored user list in sync")
+			} else {
+				h.setIgnoredUsers(&content)
+			}
+		} else if evt.Type == event.AccountDataDirectChats {
+			var content event.DirectChatsEventContent
+			err = json.Unmarshal(evt.Content.VeryRaw, &content)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to parse direct chat list in sync")
+			} else {
+				ctx.Value(syncContextKey).(*syncContext).directChats = content
+			}
 		}
 	}
+	for _, evt := range resp.Presence.Events {
+		evt.Type.Class = event.EphemeralEventType
+		err := evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			zerolog.Ctx(ctx).Debug().Err(err).Stringer("user_id", evt.Sender).Msg("Failed to parse presence event content")
+			continue
+		}
+		syncCtx := ctx.Value(syncContextKey).(*syncContext)
+		if syncCtx.presence == nil {
+			syncCtx.presence = make(map[id.UserID]*event.PresenceEventContent)
+		}
+		syncCtx.presence[evt.Sender] = evt.Content.AsPresence()
+	}
 	for roomID, room := range resp.Rooms.Join {
 		err := h.processSyncJoinedRoom(ctx, roomID, room)
 		if err != nil {
@@ -126,11 +251,6 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 			return fmt.Errorf("failed to process left room %s: %w", roomID, err)
 		}
 	}
-	h.Account.NextBatch = resp.NextBatch
-	err := h.DB.Account.PutNextBatch(ctx, h.Account.UserID, resp.NextBatch)
-	if err != nil {
-		return fmt.Errorf("failed to save next_batch: %w", err)
-	}
 	return nil
 }
 
@@ -171,6 +291,18 @@ func (h *HiClient) processSyncJoinedRoom(ctx context.Context, roomID id.RoomID,
 		if err != nil {
 			return fmt.Errorf("failed to save account data event %s: %w", evt.Type.Type, err)
 		}
+		if evt.Type == event.AccountDataRoomTags {
+			var content event.TagEventContent
+			if err = json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Stringer("room_id", roomID).Msg("Failed to parse room tags in sync")
+				continue
+			}
+			syncCtx := ctx.Value(syncContextKey).(*syncContext)
+			if syncCtx.changedTags == nil {
+				syncCtx.changedTags = make(map[id.RoomID]event.Tags)
+			}
+			syncCtx.changedTags[roomID] = content.Tags
+		}
 	}
 	err = h.processStateAndTimeline(ctx, existingRoomData, &room.State, &room.Timeline, &room.Summary)
 	if err != nil {
@@ -183,22 +315,37 @@ func (h *HiClient) processSyncJoinedRoom(ctx context.Context, roomID id.RoomID,
 			zerolog.Ctx(ctx).Debug().Err(err).Msg("Failed to parse ephemeral event content")
 			continue
 		}
+		syncCtx := ctx.Value(syncContextKey).(*syncContext)
 		switch evt.Type {
 		case event.EphemeralEventReceipt:
-			err = h.DB.Receipt.PutMany(ctx, roomID, receiptsToList(evt.Content.AsReceipt())...)
+			receipts := receiptsToList(evt.Content.AsReceipt())
+			err = h.DB.Receipt.PutMany(ctx, roomID, receipts...)
 			if err != nil {
 				return fmt.Errorf("failed to save receipts: %w", err)
 			}
+			if syncCtx.receipts == nil {
+				syncCtx.receipts = make(map[id.RoomID][]*database.Receipt)
+			}
+			for _, receipt := range receipts {
+				receipt.RoomID = roomID
+			}
+			syncCtx.receipts[roomID] = append(syncCtx.receipts[roomID], receipts...)
 		case event.EphemeralEventTyping:
-			go h.EventHandler(&Typing{
-				RoomID:             roomID,
-				TypingEventContent: *evt.Content.AsTyping(),
-			})
+			if syncCtx.typing == nil {
+				syncCtx.typing = make(map[id.RoomID][]id.UserID)
+			}
+			syncCtx.typing[roomID] = evt.Content.AsTyping().UserIDs
 		}
 		if evt.Type != event.EphemeralEventReceipt {
 			continue
 		}
 	}
+	if len(room.Timeline.Events) > 0 || len(room.Ephemeral.Events) > 0 {
+		err = h.recalculateUnreadCounts(ctx, existingRoomData)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -209,9 +356,68 @@ func (h *HiClient) processSyncLeftRoom(ctx context.Context, roomID id.RoomID, ro
 	} else if existingRoomData == nil {
 		return nil
 	}
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
+	if syncCtx.typing == nil {
+		syncCtx.typing = make(map[id.RoomID][]id.UserID)
+	}
+	// Typing notifications stop coming for rooms that have been left, so clear them
+	syncCtx.typing[roomID] = []id.UserID{}
 	return h.processStateAndTimeline(ctx, existingRoomData, &room.State, &room.Timeline, &room.Summary)
 }
 
+// reconcileLocalEcho merges the remote echo of an event sent by this client into the local echo that was
+// inserted when the event was queued, so that the event keeps its row ID instead of being inserted twice.
+// If there's no local echo for the transaction ID, nil is returned and the event is processed normally.
+func (h *HiClient) reconcileLocalEcho(ctx context.Context, remoteEcho *database.Event) (*database.Event, error) {
+	localEcho, err := h.DB.Event.GetByTransactionID(ctx, remoteEcho.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local echo of %s: %w", remoteEcho.ID, err)
+	} else if localEcho == nil || localEcho.RoomID != remoteEcho.RoomID {
+		return nil, nil
+	}
+	localID := localEcho.ID
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.DB.Event.ConfirmLocalEcho(ctx, localEcho.RowID, remoteEcho)
+		if err != nil {
+			return err
+		}
+		// The event was sent successfully, even if the send queue didn't get the response
+		err = h.DB.SendQueue.Delete(ctx, localEcho.RowID)
+		if err != nil {
+			return err
+		}
+		if localEcho.Type == event.EventRedaction.Type && localID != remoteEcho.ID {
+			return h.DB.Event.ReplaceRedactedBy(ctx, localID, remoteEcho.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge remote echo %s into local echo: %w", remoteEcho.ID, err)
+	}
+	localEcho.ID = remoteEcho.ID
+	localEcho.Timestamp = remoteEcho.Timestamp
+	localEcho.Unsigned = remoteEcho.Unsigned
+	localEcho.SendError = ""
+	confirmed := &EventConfirmed{
+		RoomID:        localEcho.RoomID,
+		EventRowID:    localEcho.RowID,
+		EventID:       localEcho.ID,
+		TransactionID: localEcho.TransactionID,
+		Timestamp:     localEcho.Timestamp,
+	}
+	if syncCtx, ok := ctx.Value(syncContextKey).(*syncContext); ok {
+		syncCtx.confirmedEvents = append(syncCtx.confirmedEvents, confirmed)
+	} else {
+		h.EventHandler(confirmed)
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("event_id", remoteEcho.ID).
+		Str("transaction_id", remoteEcho.TransactionID).
+		Int64("event_rowid", int64(localEcho.RowID)).
+		Msg("Merged remote echo into local echo")
+	return localEcho, nil
+}
+
 func isDecryptionErrorRetryable(err error) bool {
 	return errors.Is(err, crypto.NoSessionFound) || errors.Is(err, olm.UnknownMessageIndex) || errors.Is(err, crypto.ErrGroupSessionWithheld)
 }
@@ -328,6 +534,14 @@ func (h *HiClient) processEvent(ctx context.Context, evt *event.Event, decryptio
 		}
 	}
 	dbEvt := database.MautrixToEvent(evt)
+	if dbEvt.TransactionID != "" {
+		localEcho, err := h.reconcileLocalEcho(ctx, dbEvt)
+		if err != nil {
+			return dbEvt, err
+		} else if localEcho != nil {
+			return localEcho, nil
+		}
+	}
 	contentWithoutFallback := removeReplyFallback(evt)
 	if contentWithoutFallback != nil {
 		dbEvt.Content = contentWithoutFallback
@@ -350,6 +564,11 @@ func (h *HiClient) processEvent(ctx context.Context, evt *event.Event, decryptio
 			evt.Redacts = id.EventID(gjson.GetBytes(evt.Content.VeryRaw, "redacts").Str)
 		}
 	}
+	if decryptedMautrixEvt != nil {
+		dbEvt.UnreadType = h.evaluateUnreadType(ctx, decryptedMautrixEvt, dbEvt)
+	} else {
+		dbEvt.UnreadType = h.evaluateUnreadType(ctx, evt, dbEvt)
+	}
 	_, err := h.DB.Event.Upsert(ctx, dbEvt)
 	if err != nil {
 		return dbEvt, fmt.Errorf("failed to save event %s: %w", evt.ID, err)
@@ -359,6 +578,7 @@ func (h *HiClient) processEvent(ctx context.Context, evt *event.Event, decryptio
 	} else {
 		h.cacheMedia(ctx, evt, dbEvt.RowID)
 	}
+	h.queueURLPreviews(ctx, dbEvt)
 	if decryptionErr != nil && isDecryptionErrorRetryable(decryptionErr) {
 		req, ok := decryptionQueue[dbEvt.MegolmSessionID]
 		if !ok {
@@ -428,6 +648,41 @@ func (h *HiClient) processStateAndTimeline(ctx context.Context, room *database.R
 		if dbEvt == nil {
 			return nil
 		}
+		syncCtx := ctx.Value(syncContextKey).(*syncContext)
+		// The type of the target is needed to detect poll votes, so check it before clearing the content
+		syncCtx.markPollChanged(dbEvt)
+		dbEvt.Content = redactedContent(room, dbEvt)
+		dbEvt.Decrypted = nil
+		dbEvt.DecryptedType = ""
+		dbEvt.DecryptionError = ""
+		dbEvt.RedactedBy = evt.ID
+		err = h.DB.Event.Redact(ctx, dbEvt.RowID, evt.ID, dbEvt.Content)
+		if err != nil {
+			return fmt.Errorf("failed to clear content of redaction target: %w", err)
+		}
+		if dbEvt.StateKey != nil && (dbEvt.Type == event.StateSpaceChild.Type || dbEvt.Type == event.StateSpaceParent.Type) {
+			currentEvt, err := h.DB.CurrentState.Get(ctx, room.ID, event.Type{Type: dbEvt.Type, Class: event.StateEventType}, *dbEvt.StateKey)
+			if err != nil {
+				return fmt.Errorf("failed to get current state of redacted space event: %w", err)
+			} else if currentEvt != nil && currentEvt.RowID == dbEvt.RowID {
+				// Redacted space edges have no via, so this removes the edge
+				err = h.updateSpaceEdge(ctx, dbEvt)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if syncCtx.redacted == nil {
+			syncCtx.redacted = make(map[id.RoomID][]*database.Event)
+		}
+		syncCtx.redacted[room.ID] = append(syncCtx.redacted[room.ID], dbEvt)
+		if dbEvt.RelationType == event.RelAnnotation {
+			// Reconcile any optimistic changes made to the counts while the redaction was being sent
+			_, err = h.DB.Event.RecountReactions(ctx, room.ID, dbEvt.RelatesTo)
+			if err != nil {
+				return fmt.Errorf("failed to recount reactions of relation target of redaction target: %w", err)
+			}
+		}
 		if dbEvt.RelationType == event.RelReplace || dbEvt.RelationType == event.RelAnnotation {
 			_, err = addOldEvent(0, dbEvt.RelatesTo)
 			if err != nil {
@@ -460,22 +715,46 @@ func (h *HiClient) processStateAndTimeline(ctx context.Context, room *database.R
 				if summary != nil && slices.Contains(summary.Heroes, id.UserID(*evt.StateKey)) {
 					heroesChanged = true
 				}
+				err = h.invalidateProfileFromMemberEvent(ctx, evt)
+				if err != nil {
+					return -1, err
+				}
+				if id.UserID(*evt.StateKey) == h.Account.UserID {
+					err = h.scheduleRoomCleanup(ctx, room.ID, membership)
+					if err != nil {
+						return -1, err
+					}
+				}
 			} else if evt.Type == event.StateElementFunctionalMembers {
 				heroesChanged = true
+			} else if evt.Type == event.StatePinnedEvents && *evt.StateKey == "" {
+				ctx.Value(syncContextKey).(*syncContext).markPinsChanged(room.ID, evt)
 			}
 			err = h.DB.CurrentState.Set(ctx, room.ID, evt.Type, *evt.StateKey, dbEvt.RowID, membership)
 			if err != nil {
 				return -1, fmt.Errorf("failed to save current state event ID %s for %s/%s: %w", evt.ID, evt.Type.Type, *evt.StateKey, err)
 			}
+			err = h.updateSpaceEdge(ctx, dbEvt)
+			if err != nil {
+				return -1, err
+			}
 			processImportantEvent(ctx, evt, room, updatedRoom)
 		}
 		allNewEvents = append(allNewEvents, dbEvt)
+		ctx.Value(syncContextKey).(*syncContext).markPollChanged(dbEvt)
 		if evt.Type == event.EventRedaction && evt.Redacts != "" {
 			err = processRedaction(evt)
 			if err != nil {
 				return -1, fmt.Errorf("failed to process redaction: %w", err)
 			}
 		} else if dbEvt.RelationType == event.RelReplace || dbEvt.RelationType == event.RelAnnotation {
+			if dbEvt.RelationType == event.RelAnnotation && dbEvt.TransactionID != "" {
+				// Reconcile the optimistic counts when the echo of our own reaction arrives
+				_, err = h.DB.Event.RecountReactions(ctx, room.ID, dbEvt.RelatesTo)
+				if err != nil {
+					return -1, fmt.Errorf("failed to recount reactions of relation target of event: %w", err)
+				}
+			}
 			_, err = addOldEvent(0, dbEvt.RelatesTo)
 			if err != nil {
 				return -1, fmt.Errorf("failed to get relation target of event: %w", err)
@@ -500,6 +779,18 @@ func (h *HiClient) processStateAndTimeline(ctx context.Context, room *database.R
 	}
 	var timelineRowTuples []database.TimelineRowTuple
 	var err error
+	var ignoredEvents []*event.Event
+	timeline.Events, ignoredEvents = h.splitIgnoredEvents(timeline.Events)
+	for _, evt := range ignoredEvents {
+		// Events from ignored users are still stored so that they aren't lost if the user is unignored,
+		// but they aren't added to the timeline or dispatched to the frontend.
+		evt.RoomID = room.ID
+		evt.Type.Class = event.MessageEventType
+		_, err = h.processEvent(ctx, evt, decryptionQueue, false)
+		if err != nil {
+			return err
+		}
+	}
 	if len(timeline.Events) > 0 {
 		timelineIDs := make([]database.EventRowID, len(timeline.Events))
 		for i, evt := range timeline.Events {
@@ -8,16 +8,27 @@ package hicli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/qrlogin"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
 type hiSyncer HiClient
 
 var _ mautrix.Syncer = (*hiSyncer)(nil)
+var _ mautrix.ExtensibleSyncer = (*hiSyncer)(nil)
+var _ mautrix.PresenceSettingSyncer = (*hiSyncer)(nil)
+
+// GetSyncPresence returns the presence that was last set by the presence manager,
+// so that sync requests don't override it.
+func (h *hiSyncer) GetSyncPresence() event.Presence {
+	return h.Presence.Current().Presence
+}
 
 type contextKey int
 
@@ -27,23 +38,93 @@ const (
 
 func (h *hiSyncer) ProcessResponse(ctx context.Context, resp *mautrix.RespSync, since string) error {
 	c := (*HiClient)(h)
+	return c.handleSyncResponse(ctx, resp, since, func(ctx context.Context) error {
+		c.Account.NextBatch = resp.NextBatch
+		err := c.DB.Account.PutNextBatch(ctx, c.Account.UserID, resp.NextBatch)
+		if err != nil {
+			return fmt.Errorf("failed to save next_batch: %w", err)
+		}
+		return nil
+	})
+}
+
+// handleSyncResponse runs a sync response through the processing pipeline. The saveToken function is called
+// inside the same database transaction as the rest of the processing to store the token for the next request.
+func (h *HiClient) handleSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string, saveToken func(ctx context.Context) error) error {
 	ctx = context.WithValue(ctx, syncContextKey, &syncContext{evt: &SyncComplete{Rooms: make(map[id.RoomID]*SyncRoom, len(resp.Rooms.Join))}})
-	err := c.preProcessSyncResponse(ctx, resp, since)
+	err := h.preProcessSyncResponse(ctx, resp, since)
 	if err != nil {
 		return err
 	}
-	err = c.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
-		return c.processSyncResponse(ctx, resp, since)
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := h.processSyncResponse(ctx, resp, since)
+		if err != nil {
+			return err
+		}
+		return saveToken(ctx)
 	})
 	if err != nil {
 		return err
 	}
-	c.postProcessSyncResponse(ctx, resp, since)
+	h.postProcessSyncResponse(ctx, resp, since)
+	for _, handler := range h.syncHandlers {
+		handler(ctx, resp, since)
+	}
+	if h.syncFailing.Swap(false) {
+		// The connection is back, so retry queued events immediately instead of waiting for the backoff
+		err = h.DB.SendQueue.ResetBackoff(ctx)
+		if err != nil {
+			h.Log.Err(err).Msg("Failed to reset send queue backoff after connection was restored")
+		}
+		h.wakeupSendQueue()
+	}
 	return nil
 }
 
+// OnSync registers a handler that is called after each sync response has been processed.
+// Handlers must be registered before syncing is started.
+func (h *hiSyncer) OnSync(callback mautrix.SyncHandler) {
+	h.syncHandlers = append(h.syncHandlers, callback)
+}
+
+// OnEvent registers a handler for all to-device events. Room events are processed by hicli itself and
+// are never passed to handlers. Handlers must be registered before syncing is started.
+func (h *hiSyncer) OnEvent(callback mautrix.EventHandler) {
+	h.globalToDeviceHandlers = append(h.globalToDeviceHandlers, callback)
+}
+
+// OnEventType registers a handler for to-device events of the given type. Like with [hiSyncer.OnEvent],
+// room events are never passed to handlers. Handlers must be registered before syncing is started.
+func (h *hiSyncer) OnEventType(eventType event.Type, callback mautrix.EventHandler) {
+	h.toDeviceHandlers[eventType] = append(h.toDeviceHandlers[eventType], callback)
+}
+
+func (h *HiClient) dispatchToDeviceHandlers(ctx context.Context, evts []*event.Event) {
+	for _, evt := range evts {
+		for _, handler := range h.globalToDeviceHandlers {
+			handler(ctx, evt)
+		}
+		for _, handler := range h.toDeviceHandlers[evt.Type] {
+			handler(ctx, evt)
+		}
+	}
+}
+
 func (h *hiSyncer) OnFailedSync(_ *mautrix.RespSync, err error) (time.Duration, error) {
+	if errors.Is(err, mautrix.MUnknownToken) && h.Account != nil && h.Account.RefreshToken != "" {
+		log := (*HiClient)(h).Log.With().Str("action", "refresh access token").Logger()
+		log.Debug().Err(err).Msg("Access token expired, refreshing")
+		refreshErr := (*HiClient)(h).RefreshAccessToken(log.WithContext(context.Background()))
+		if refreshErr == nil {
+			return 0, nil
+		} else if errors.Is(refreshErr, qrlogin.ErrRefreshTokenInvalid) {
+			log.Err(refreshErr).Msg("Refresh token is no longer valid")
+			return 0, err
+		}
+		log.Err(refreshErr).Msg("Failed to refresh access token, retrying in 1 second")
+	}
 	(*HiClient)(h).Log.Err(err).Msg("Sync failed, retrying in 1 second")
+	h.syncFailing.Store(true)
 	return 1 * time.Second, nil
 }
 
@@ -59,9 +140,6 @@ func (h *hiSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 		}
 	}
 	return &mautrix.Filter{
-		Presence: mautrix.FilterPart{
-			NotRooms: []id.RoomID{"*"},
-		},
 		Room: mautrix.RoomFilter{
 			State: mautrix.FilterPart{
 				LazyLoadMembers: true,
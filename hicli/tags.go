@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SetFavourite adds the room to the end of the favourites section or removes it from favourites.
+// Adding a room to favourites removes it from the low priority section.
+func (h *HiClient) SetFavourite(ctx context.Context, roomID id.RoomID, favourite bool) error {
+	return h.setSectionTag(ctx, roomID, event.RoomTagFavourite, favourite)
+}
+
+// SetLowPriority adds the room to the end of the low priority section or removes it from low priority.
+// Adding a room to low priority removes it from the favourites section.
+func (h *HiClient) SetLowPriority(ctx context.Context, roomID id.RoomID, lowPriority bool) error {
+	return h.setSectionTag(ctx, roomID, event.RoomTagLowPriority, lowPriority)
+}
+
+func (h *HiClient) setSectionTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag, add bool) error {
+	if !add {
+		return h.Client.RemoveTag(ctx, roomID, tag)
+	}
+	entries, err := h.getTagOrder(ctx, tag, roomID)
+	if err != nil {
+		return err
+	}
+	// If the last room doesn't have an order, the new room is left without one too, which sorts it last.
+	var order, lastOrder *float64
+	hasLastOrder := true
+	if len(entries) > 0 {
+		lastOrder, hasLastOrder = tagOrderPtr(entries[len(entries)-1].Tags, tag)
+	}
+	if newOrder, ok := event.TagOrderBetween(lastOrder, nil); hasLastOrder && ok {
+		order = &newOrder
+	}
+	if tag == event.RoomTagFavourite {
+		return h.Client.SetFavourite(ctx, roomID, true, order)
+	}
+	return h.Client.SetLowPriority(ctx, roomID, true, order)
+}
+
+// MoveRoomInTag adds the given tag to the room, ordered directly after afterRoomID.
+// If afterRoomID is empty, the room is moved to the start of the tag.
+//
+// If there's no space between the neighbouring orders, all rooms with the tag are renumbered.
+func (h *HiClient) MoveRoomInTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag, afterRoomID id.RoomID) error {
+	entries, err := h.getTagOrder(ctx, tag, roomID)
+	if err != nil {
+		return err
+	}
+	insertAt := 0
+	if afterRoomID != "" {
+		insertAt = slices.IndexFunc(entries, func(entry *RoomListEntry) bool {
+			return entry.RoomID == afterRoomID
+		}) + 1
+		if insertAt == 0 {
+			return fmt.Errorf("room %s doesn't have tag %s", afterRoomID, tag)
+		}
+	}
+	var prev, next *float64
+	ok := true
+	if insertAt > 0 {
+		prev, ok = tagOrderPtr(entries[insertAt-1].Tags, tag)
+	}
+	if ok && insertAt < len(entries) {
+		next, ok = tagOrderPtr(entries[insertAt].Tags, tag)
+	}
+	if ok {
+		var order float64
+		if order, ok = event.TagOrderBetween(prev, next); ok {
+			return h.Client.SetTag(ctx, roomID, tag, &order)
+		}
+	}
+	roomIDs := make([]id.RoomID, 0, len(entries)+1)
+	for _, entry := range entries[:insertAt] {
+		roomIDs = append(roomIDs, entry.RoomID)
+	}
+	roomIDs = append(roomIDs, roomID)
+	for _, entry := range entries[insertAt:] {
+		roomIDs = append(roomIDs, entry.RoomID)
+	}
+	for i, order := range event.EvenTagOrders(len(roomIDs)) {
+		err = h.Client.SetTag(ctx, roomIDs[i], tag, &order)
+		if err != nil {
+			return fmt.Errorf("failed to renumber tag order of %s: %w", roomIDs[i], err)
+		}
+	}
+	return nil
+}
+
+func tagOrderPtr(tags event.Tags, tag event.RoomTag) (*float64, bool) {
+	meta := tags[tag]
+	order, ok := meta.OrderFloat()
+	return &order, ok
+}
+
+// getTagOrder returns the rooms with the given tag sorted by the order of the tag, excluding the given room.
+// Rooms without an order are sorted last.
+func (h *HiClient) getTagOrder(ctx context.Context, tag event.RoomTag, excludeRoomID id.RoomID) ([]*RoomListEntry, error) {
+	entries, err := h.RoomList.Get(ctx, "", &RoomListFilter{Tag: tag})
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(entry *RoomListEntry) bool {
+		return entry.RoomID == excludeRoomID
+	})
+	slices.SortStableFunc(entries, func(a, b *RoomListEntry) int {
+		aMeta, bMeta := a.Tags[tag], b.Tags[tag]
+		aOrder, aOK := aMeta.OrderFloat()
+		bOrder, bOK := bMeta.OrderFloat()
+		if aOK != bOK {
+			if aOK {
+				return -1
+			}
+			return 1
+		} else if c := cmp.Compare(aOrder, bOrder); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.RoomID), string(b.RoomID))
+	})
+	return entries, nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// AllDevices can be used as a device ID in [HiClient.SendToDevice] to send the event to all devices of a user.
+const AllDevices id.DeviceID = "*"
+
+var ErrNoDevicesToSendTo = errors.New("no devices to send to-device event to")
+
+// SendToDevice sends a custom to-device event to the given devices. If the device list of a user is empty or
+// contains [AllDevices], the event is sent to all devices of that user.
+//
+// If encrypt is true, the content is encrypted separately for each device using Olm. Devices that don't have
+// encryption keys or that no Olm session could be established with are skipped, as is the current device.
+func (h *HiClient) SendToDevice(ctx context.Context, evtType event.Type, targets map[id.UserID][]id.DeviceID, content json.RawMessage, encrypt bool) (*mautrix.RespSendToDevice, error) {
+	evtType.Class = event.ToDeviceEventType
+	var req *mautrix.ReqSendToDevice
+	if encrypt {
+		devices, err := h.getToDeviceTargets(ctx, targets)
+		if err != nil {
+			return nil, err
+		}
+		req, err = h.Crypto.EncryptToDevices(ctx, evtType, event.Content{VeryRaw: content}, devices)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt to-device event: %w", err)
+		}
+		evtType = event.ToDeviceEncrypted
+	} else {
+		req = &mautrix.ReqSendToDevice{Messages: make(map[id.UserID]map[id.DeviceID]*event.Content, len(targets))}
+		for userID, deviceIDs := range targets {
+			if len(deviceIDs) == 0 {
+				deviceIDs = []id.DeviceID{AllDevices}
+			}
+			req.Messages[userID] = make(map[id.DeviceID]*event.Content, len(deviceIDs))
+			for _, deviceID := range deviceIDs {
+				req.Messages[userID][deviceID] = &event.Content{VeryRaw: content}
+			}
+		}
+	}
+	if len(req.Messages) == 0 {
+		return nil, ErrNoDevicesToSendTo
+	}
+	return h.Client.SendToDevice(ctx, evtType, req)
+}
+
+func (h *HiClient) getToDeviceTargets(ctx context.Context, targets map[id.UserID][]id.DeviceID) (map[id.UserID]map[id.DeviceID]*id.Device, error) {
+	log := zerolog.Ctx(ctx)
+	devices := make(map[id.UserID]map[id.DeviceID]*id.Device, len(targets))
+	for userID, deviceIDs := range targets {
+		var userDevices map[id.DeviceID]*id.Device
+		if len(deviceIDs) == 0 || (len(deviceIDs) == 1 && deviceIDs[0] == AllDevices) {
+			var err error
+			userDevices, err = h.Crypto.CryptoStore.GetDevices(ctx, userID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get devices of %s: %w", userID, err)
+			} else if len(userDevices) == 0 {
+				userDevices = h.Crypto.LoadDevices(ctx, userID)
+			}
+		} else {
+			userDevices = make(map[id.DeviceID]*id.Device, len(deviceIDs))
+			for _, deviceID := range deviceIDs {
+				device, err := h.Crypto.GetOrFetchDevice(ctx, userID, deviceID)
+				if err != nil {
+					log.Warn().Err(err).
+						Stringer("user_id", userID).
+						Stringer("device_id", deviceID).
+						Msg("Failed to get device keys, not sending to-device event to it")
+					continue
+				}
+				userDevices[deviceID] = device
+			}
+		}
+		for deviceID, device := range userDevices {
+			if userID == h.Account.UserID && deviceID == h.Account.DeviceID {
+				continue
+			}
+			if _, ok := devices[userID]; !ok {
+				devices[userID] = make(map[id.DeviceID]*id.Device, len(userDevices))
+			}
+			devices[userID][deviceID] = device
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/crypto/qrlogin"
+)
+
+var ErrNoRefreshToken = errors.New("account doesn't have a refresh token")
+
+// RefreshAccessToken gets a new access token using the OAuth refresh token of the account.
+// Only logins done via OAuth (e.g. [HiClient.LoginWithQR]) have refresh tokens.
+func (h *HiClient) RefreshAccessToken(ctx context.Context) error {
+	h.loginLock.Lock()
+	defer h.loginLock.Unlock()
+	account := h.Account
+	if account == nil {
+		return ErrNotLoggedIn
+	} else if account.RefreshToken == "" || account.TokenEndpoint == "" {
+		return ErrNoRefreshToken
+	}
+	accessToken, refreshToken, err := qrlogin.RefreshAccessToken(
+		ctx, h.Client, account.TokenEndpoint, account.OAuthClientID, account.RefreshToken,
+	)
+	if err != nil {
+		return err
+	} else if refreshToken == "" {
+		refreshToken = account.RefreshToken
+	}
+	err = h.DB.Account.PutTokens(ctx, account.UserID, accessToken, refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to save refreshed tokens: %w", err)
+	}
+	account.AccessToken = accessToken
+	account.RefreshToken = refreshToken
+	h.Client.AccessToken = accessToken
+	zerolog.Ctx(ctx).Debug().Msg("Refreshed access token")
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+// pushRoom implements [pushrules.Room] using the local database.
+// The values are loaded lazily, as most events are matched by rules that don't need them.
+type pushRoom struct {
+	ctx    context.Context
+	h      *HiClient
+	roomID id.RoomID
+
+	ownDisplayname *string
+	memberCount    *int
+}
+
+var _ pushrules.Room = (*pushRoom)(nil)
+
+func (pr *pushRoom) GetOwnDisplayname() string {
+	if pr.ownDisplayname == nil {
+		var displayname string
+		memberEvt, err := pr.h.DB.CurrentState.Get(pr.ctx, pr.roomID, event.StateMember, pr.h.Account.UserID.String())
+		if err != nil {
+			zerolog.Ctx(pr.ctx).Warn().Err(err).Msg("Failed to get own member event for push rule evaluation")
+		} else if memberEvt != nil {
+			displayname = gjson.GetBytes(memberEvt.Content, "displayname").Str
+		}
+		pr.ownDisplayname = &displayname
+	}
+	return *pr.ownDisplayname
+}
+
+func (pr *pushRoom) GetMemberCount() int {
+	if pr.memberCount == nil {
+		var count int
+		room, err := pr.h.DB.Room.Get(pr.ctx, pr.roomID)
+		if err != nil {
+			zerolog.Ctx(pr.ctx).Warn().Err(err).Msg("Failed to get room for push rule evaluation")
+		} else if room != nil && room.LazyLoadSummary != nil {
+			if room.LazyLoadSummary.JoinedMemberCount != nil {
+				count += *room.LazyLoadSummary.JoinedMemberCount
+			}
+			if room.LazyLoadSummary.InvitedMemberCount != nil {
+				count += *room.LazyLoadSummary.InvitedMemberCount
+			}
+		}
+		pr.memberCount = &count
+	}
+	return *pr.memberCount
+}
+
+func (h *HiClient) getPushRoom(ctx context.Context, roomID id.RoomID) *pushRoom {
+	syncCtx, ok := ctx.Value(syncContextKey).(*syncContext)
+	if !ok {
+		return &pushRoom{ctx: ctx, h: h, roomID: roomID}
+	}
+	room, ok := syncCtx.pushRooms[roomID]
+	if !ok {
+		if syncCtx.pushRooms == nil {
+			syncCtx.pushRooms = make(map[id.RoomID]*pushRoom)
+		}
+		room = &pushRoom{ctx: ctx, h: h, roomID: roomID}
+		syncCtx.pushRooms[roomID] = room
+	}
+	return room
+}
+
+// evaluateUnreadType decides how the given event affects unread counts. If the event was decrypted,
+// the decrypted event should be passed, so that push rules can match the real type and content.
+func (h *HiClient) evaluateUnreadType(ctx context.Context, evt *event.Event, dbEvt *database.Event) database.UnreadType {
+	if evt.Sender == h.Account.UserID || dbEvt.RedactedBy != "" || h.IsIgnored(evt.Sender) {
+		return database.UnreadTypeNone
+	}
+	unreadType := database.UnreadTypeNone
+	if dbEvt.BumpsSortingTimestamp() || evt.Type == event.EventUnstablePollStart {
+		unreadType |= database.UnreadTypeNormal
+	}
+	rules := h.PushRules.Load()
+	if rules == nil {
+		return unreadType
+	}
+	should := rules.GetActions(h.getPushRoom(ctx, evt.RoomID), evt).Should()
+	if should.Notify {
+		unreadType |= database.UnreadTypeNotify
+	}
+	if should.Highlight {
+		unreadType |= database.UnreadTypeHighlight
+	}
+	if should.PlaySound {
+		unreadType |= database.UnreadTypeSound
+	}
+	return unreadType
+}
+
+// recalculateUnreadCounts updates the stored unread counts of the room. If the counts changed,
+// the room metadata is included in the sync event, which means this must be called during a sync.
+func (h *HiClient) recalculateUnreadCounts(ctx context.Context, room *database.Room) error {
+	counts, err := h.DB.Room.RecalculateUnreadCounts(ctx, room.ID, h.Account.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to recalculate unread counts: %w", err)
+	} else if counts == room.UnreadCounts {
+		return nil
+	}
+	room.UnreadCounts = counts
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
+	if syncRoom, ok := syncCtx.evt.Rooms[room.ID]; ok {
+		syncRoom.Meta = room
+	} else {
+		syncCtx.evt.Rooms[room.ID] = &SyncRoom{
+			Meta:     room,
+			Timeline: make([]database.TimelineRowTuple, 0),
+			State:    make(map[event.Type]map[string]database.EventRowID),
+			Events:   make([]*database.Event, 0),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	// URLPreviewCacheTTL is how long successfully fetched URL previews are cached.
+	URLPreviewCacheTTL = 24 * time.Hour
+	// URLPreviewErrorCacheTTL is how long failures to fetch a URL preview are cached before retrying.
+	URLPreviewErrorCacheTTL = 1 * time.Hour
+	// URLPreviewTimeout is the maximum time to wait for a single URL preview.
+	URLPreviewTimeout = 10 * time.Second
+	// URLPreviewSendTimeout is the maximum time sending a message waits for the previews of its links.
+	// Previews that aren't ready by then are left out of the message.
+	URLPreviewSendTimeout = 3 * time.Second
+	// MaxURLPreviewsPerMessage is the maximum number of links in a single message that previews are fetched for.
+	MaxURLPreviewsPerMessage = 3
+)
+
+const beeperLinkPreviewsKey = "com\\.beeper\\.linkpreviews"
+
+var urlRegex = regexp.MustCompile(`https?://[^\s<>"'\[\]]+`)
+
+// findPreviewableURLs finds up to [MaxURLPreviewsPerMessage] unique http(s) links in the given message body.
+// Links to matrix.to are ignored, as they're rendered as pills rather than previews.
+func findPreviewableURLs(body string) []string {
+	var urls []string
+	for _, match := range urlRegex.FindAllString(body, -1) {
+		match = strings.TrimRight(match, ".,;:!?)")
+		parsed, err := url.Parse(match)
+		if err != nil || parsed.Host == "" || parsed.Host == "matrix.to" {
+			continue
+		}
+		duplicate := false
+		for _, existing := range urls {
+			if existing == match {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			urls = append(urls, match)
+			if len(urls) >= MaxURLPreviewsPerMessage {
+				break
+			}
+		}
+	}
+	return urls
+}
+
+// GetURLPreview returns a preview of the given URL, using the local cache if it's fresh enough.
+// Failures are cached too, so that broken links aren't refetched every time they're seen.
+func (h *HiClient) GetURLPreview(ctx context.Context, url string) (*event.LinkPreview, error) {
+	cached, err := h.DB.URLPreview.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached URL preview: %w", err)
+	} else if cached != nil {
+		if cached.Preview != nil && time.Since(cached.FetchedAt.Time) < URLPreviewCacheTTL {
+			return cached.Preview, nil
+		} else if cached.Error != "" && time.Since(cached.FetchedAt.Time) < URLPreviewErrorCacheTTL {
+			return nil, fmt.Errorf("cached error: %s", cached.Error)
+		}
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, URLPreviewTimeout)
+	preview, fetchErr := h.Client.GetURLPreview(fetchCtx, url)
+	cancel()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	newCached := &database.URLPreview{
+		URL:       url,
+		Preview:   preview,
+		FetchedAt: jsontime.UnixMilliNow(),
+	}
+	if fetchErr != nil {
+		newCached.Preview = nil
+		newCached.Error = fetchErr.Error()
+	}
+	err = h.DB.URLPreview.Put(ctx, newCached)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("url", url).Msg("Failed to cache URL preview")
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return preview, nil
+}
+
+// shouldFetchURLPreviews checks whether URL previews are allowed in a room based on the privacy options.
+func (h *HiClient) shouldFetchURLPreviews(encrypted bool) bool {
+	return !h.DisableURLPreviews && (!encrypted || h.URLPreviewsInEncryptedRooms)
+}
+
+// getURLPreviews fetches previews for the given URLs concurrently, skipping the ones that fail.
+func (h *HiClient) getURLPreviews(ctx context.Context, urls []string) []*event.BeeperLinkPreview {
+	results := make([]*event.BeeperLinkPreview, len(urls))
+	var wg sync.WaitGroup
+	wg.Add(len(urls))
+	for i, url := range urls {
+		go func() {
+			defer wg.Done()
+			preview, err := h.GetURLPreview(ctx, url)
+			if err != nil {
+				zerolog.Ctx(ctx).Debug().Err(err).Str("url", url).Msg("Failed to get URL preview")
+				return
+			}
+			results[i] = &event.BeeperLinkPreview{
+				LinkPreview: *preview,
+				MatchedURL:  url,
+			}
+		}()
+	}
+	wg.Wait()
+	previews := make([]*event.BeeperLinkPreview, 0, len(urls))
+	for _, preview := range results {
+		if preview != nil {
+			previews = append(previews, preview)
+		}
+	}
+	return previews
+}
+
+// pruneURLPreviewCache deletes cached previews that are too old to be used anymore.
+func (h *HiClient) pruneURLPreviewCache(ctx context.Context) error {
+	return h.DB.URLPreview.DeleteFetchedBefore(ctx, time.Now().Add(-max(URLPreviewCacheTTL, URLPreviewErrorCacheTTL)))
+}
+
+// addURLPreviews bundles previews of the links in an outgoing message into the content (MSC4095),
+// so that recipients don't have to fetch the previews themselves.
+func (h *HiClient) addURLPreviews(ctx context.Context, roomID id.RoomID, content *event.MessageEventContent) {
+	if content.BeeperLinkPreviews != nil || (content.MsgType != event.MsgText && content.MsgType != event.MsgNotice && content.MsgType != event.MsgEmote) {
+		return
+	}
+	urls := findPreviewableURLs(content.Body)
+	if len(urls) == 0 {
+		return
+	}
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil || room == nil || !h.shouldFetchURLPreviews(room.EncryptionEvent != nil) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, URLPreviewSendTimeout)
+	defer cancel()
+	content.BeeperLinkPreviews = h.getURLPreviews(ctx, urls)
+}
+
+type urlPreviewRequest struct {
+	roomID id.RoomID
+	rowID  database.EventRowID
+	urls   []string
+}
+
+// queueURLPreviews checks if previews should be fetched for a newly received message
+// and adds it to the sync context if so.
+func (h *HiClient) queueURLPreviews(ctx context.Context, dbEvt *database.Event) {
+	syncCtx, ok := ctx.Value(syncContextKey).(*syncContext)
+	if !ok || !h.firstSyncReceived || dbEvt.Sender == h.Account.UserID || dbEvt.RedactedBy != "" {
+		return
+	}
+	content := dbEvt.Content
+	evtType := dbEvt.Type
+	if dbEvt.DecryptedType != "" {
+		content = dbEvt.Decrypted
+		evtType = dbEvt.DecryptedType
+	}
+	if evtType != event.EventMessage.Type || dbEvt.RelationType == event.RelReplace || !h.shouldFetchURLPreviews(dbEvt.Type == event.EventEncrypted.Type) {
+		return
+	}
+	parsed := gjson.GetManyBytes(content, "msgtype", "body", beeperLinkPreviewsKey)
+	switch event.MessageType(parsed[0].Str) {
+	case event.MsgText, event.MsgNotice, event.MsgEmote:
+	default:
+		return
+	}
+	// If the sender bundled previews (even an empty list), don't fetch them separately
+	if parsed[2].Exists() {
+		return
+	}
+	urls := findPreviewableURLs(parsed[1].Str)
+	if len(urls) > 0 {
+		syncCtx.urlPreviews = append(syncCtx.urlPreviews, &urlPreviewRequest{
+			roomID: dbEvt.RoomID,
+			rowID:  dbEvt.RowID,
+			urls:   urls,
+		})
+	}
+}
+
+// fetchQueuedURLPreviews fetches the previews for messages received in a sync,
+// stores them with the events and emits them to the frontend.
+func (h *HiClient) fetchQueuedURLPreviews(ctx context.Context, reqs []*urlPreviewRequest) {
+	for _, req := range reqs {
+		if ctx.Err() != nil {
+			return
+		}
+		previews := h.getURLPreviews(ctx, req.urls)
+		if len(previews) > 0 {
+			err := h.DB.Event.SetURLPreviews(ctx, req.rowID, previews)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).
+					Int64("event_rowid", int64(req.rowID)).
+					Msg("Failed to save URL previews of event")
+			}
+			h.EventHandler(&URLPreviews{
+				RoomID:     req.roomID,
+				EventRowID: req.rowID,
+				Previews:   previews,
+			})
+		}
+	}
+}
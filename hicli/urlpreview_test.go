@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/hicli/database"
+)
+
+func setTestPreviewServer(t *testing.T, h *HiClient, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	h.Client.HomeserverURL, _ = url.Parse(server.URL)
+}
+
+func TestHiClient_FetchQueuedURLPreviews(t *testing.T) {
+	ctx := context.Background()
+	h, collector := newTestClient(t)
+	setTestPreviewServer(t, h, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"og:title": "Meow"}`))
+	})
+	insertTestRoom(t, h, testRoomID)
+	dbEvt := insertTestEvent(t, h, &event.Event{
+		RoomID:  testRoomID,
+		ID:      "$link",
+		Sender:  "@bob:example.com",
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "https://example.com"}},
+	})
+
+	h.fetchQueuedURLPreviews(ctx, []*urlPreviewRequest{{roomID: testRoomID, rowID: dbEvt.RowID, urls: []string{"https://example.com"}}})
+	require.Len(t, collector.get(), 1)
+	stored, err := h.DB.Event.GetByID(ctx, "$link")
+	require.NoError(t, err)
+	require.Len(t, stored.URLPreviews, 1, "previews should be persisted with the event")
+	assert.Equal(t, "Meow", stored.URLPreviews[0].Title)
+	assert.Equal(t, "https://example.com", stored.URLPreviews[0].MatchedURL)
+}
+
+func TestHiClient_AddURLPreviews_Timeout(t *testing.T) {
+	h, _ := newTestClient(t)
+	setTestPreviewServer(t, h, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	insertTestRoom(t, h, testRoomID)
+	origTimeout := URLPreviewSendTimeout
+	URLPreviewSendTimeout = 100 * time.Millisecond
+	t.Cleanup(func() {
+		URLPreviewSendTimeout = origTimeout
+	})
+
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "https://example.com https://example.org https://example.net"}
+	start := time.Now()
+	h.addURLPreviews(context.Background(), testRoomID, content)
+	assert.Less(t, time.Since(start), 2*time.Second, "sending shouldn't wait for each preview separately")
+	assert.Empty(t, content.BeeperLinkPreviews)
+}
+
+func TestHiClient_PruneURLPreviewCache(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestClient(t)
+	require.NoError(t, h.DB.URLPreview.Put(ctx, &database.URLPreview{
+		URL:       "https://old.example.com",
+		Preview:   &event.LinkPreview{Title: "Old"},
+		FetchedAt: jsontime.UM(time.Now().Add(-URLPreviewCacheTTL - time.Hour)),
+	}))
+	require.NoError(t, h.DB.URLPreview.Put(ctx, &database.URLPreview{
+		URL:       "https://new.example.com",
+		Preview:   &event.LinkPreview{Title: "New"},
+		FetchedAt: jsontime.UnixMilliNow(),
+	}))
+
+	require.NoError(t, h.pruneURLPreviewCache(ctx))
+	cached, err := h.DB.URLPreview.Get(ctx, "https://old.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+	cached, err = h.DB.URLPreview.Get(ctx, "https://new.example.com")
+	require.NoError(t, err)
+	assert.NotNil(t, cached)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// hiVerificationCallbacks implements the callbacks of [verificationhelper.VerificationHelper]
+// by emitting them as events for the frontend.
+type hiVerificationCallbacks HiClient
+
+var (
+	_ verificationhelper.RequiredCallbacks         = (*hiVerificationCallbacks)(nil)
+	_ verificationhelper.ShowLocalizedSASCallbacks = (*hiVerificationCallbacks)(nil)
+)
+
+func (h *hiVerificationCallbacks) VerificationRequested(ctx context.Context, txnID id.VerificationTransactionID, from id.UserID) {
+	h.EventHandler(&VerificationRequested{TransactionID: txnID, From: from})
+}
+
+func (h *hiVerificationCallbacks) VerificationCancelled(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	(*HiClient)(h).clearSASTokens(txnID)
+	h.EventHandler(&VerificationCancelled{TransactionID: txnID, Code: code, Reason: reason})
+}
+
+func (h *hiVerificationCallbacks) VerificationDone(ctx context.Context, txnID id.VerificationTransactionID) {
+	(*HiClient)(h).clearSASTokens(txnID)
+	h.EventHandler(&VerificationDone{TransactionID: txnID})
+}
+
+func (h *hiVerificationCallbacks) ShowLocalizedSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []verificationhelper.SASEmoji, decimals []int) {
+	evt := &VerificationSAS{
+		TransactionID: txnID,
+		Emojis:        make([]VerificationSASEmoji, len(emojis)),
+		Decimals:      decimals,
+	}
+	for i, emoji := range emojis {
+		evt.Emojis[i] = VerificationSASEmoji{
+			Emoji:       string(emoji.Emoji),
+			Description: emoji.Description,
+			Number:      emoji.Number,
+		}
+	}
+	evt.ConfirmToken, evt.RejectToken = (*HiClient)(h).makeSASTokens(txnID)
+	h.EventHandler(evt)
+}
+
+type sasResponse struct {
+	TransactionID id.VerificationTransactionID
+	Match         bool
+}
+
+var ErrUnknownSASToken = errors.New("unknown or expired SAS response token")
+
+func (h *HiClient) makeSASTokens(txnID id.VerificationTransactionID) (confirm, reject string) {
+	h.sasTokensLock.Lock()
+	defer h.sasTokensLock.Unlock()
+	if h.sasTokens == nil {
+		h.sasTokens = make(map[string]sasResponse)
+	}
+	confirm = random.String(32)
+	reject = random.String(32)
+	h.sasTokens[confirm] = sasResponse{TransactionID: txnID, Match: true}
+	h.sasTokens[reject] = sasResponse{TransactionID: txnID, Match: false}
+	return
+}
+
+func (h *HiClient) clearSASTokens(txnID id.VerificationTransactionID) {
+	h.sasTokensLock.Lock()
+	defer h.sasTokensLock.Unlock()
+	for token, resp := range h.sasTokens {
+		if resp.TransactionID == txnID {
+			delete(h.sasTokens, token)
+		}
+	}
+}
+
+// RespondSAS confirms or rejects the short authentication string of a verification using one of the tokens
+// from the [VerificationSAS] event. Rejecting cancels the verification with the m.mismatched_sas code.
+// Both tokens of the verification become invalid after either one is used.
+func (h *HiClient) RespondSAS(ctx context.Context, token string) error {
+	h.sasTokensLock.Lock()
+	resp, ok := h.sasTokens[token]
+	h.sasTokensLock.Unlock()
+	if !ok {
+		return ErrUnknownSASToken
+	}
+	h.clearSASTokens(resp.TransactionID)
+	if resp.Match {
+		return h.Verification.ConfirmSAS(ctx, resp.TransactionID)
+	}
+	return h.Verification.CancelVerification(ctx, resp.TransactionID, event.VerificationCancelCodeSASMismatch, "The short authentication strings didn't match")
+}
+
+// StartDeviceVerification starts interactive verification with the given device of our own user.
+// The progress of the verification is emitted as Verification* events.
+func (h *HiClient) StartDeviceVerification(ctx context.Context, deviceID id.DeviceID) (id.VerificationTransactionID, error) {
+	txnID, err := h.Verification.StartDeviceVerification(ctx, h.Account.UserID, deviceID)
+	if err != nil {
+		return "", err
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("device_id", deviceID).
+		Stringer("transaction_id", txnID).
+		Msg("Started device verification")
+	return txnID, nil
+}
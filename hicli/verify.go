@@ -9,10 +9,12 @@ package hicli
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"github.com/rs/zerolog"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
 	"maunium.net/go/mautrix/crypto/ssss"
@@ -33,25 +35,22 @@ func (h *HiClient) checkIsCurrentDeviceVerified(ctx context.Context) (bool, erro
 }
 
 func (h *HiClient) fetchKeyBackupKey(ctx context.Context, ssssKey *ssss.Key) error {
-	latestVersion, err := h.Client.GetKeyBackupLatestVersion(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get key backup latest version: %w", err)
-	}
-	h.KeyBackupVersion = latestVersion.Version
 	data, err := h.Crypto.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, ssssKey)
-	if err != nil {
+	if errors.Is(err, mautrix.MNotFound) {
+		zerolog.Ctx(ctx).Debug().Msg("No megolm backup key found in SSSS")
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("failed to get megolm backup key from SSSS: %w", err)
 	}
 	key, err := backup.MegolmBackupKeyFromBytes(data)
 	if err != nil {
 		return fmt.Errorf("failed to parse megolm backup key: %w", err)
 	}
-	err = h.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+	err = h.storeKeyBackupKey(ctx, key)
 	if err != nil {
-		return fmt.Errorf("failed to store megolm backup key: %w", err)
+		return err
 	}
-	h.KeyBackupKey = key
-	return nil
+	return h.loadKeyBackup(ctx, key)
 }
 
 func (h *HiClient) getAndDecodeSecret(ctx context.Context, secret id.Secret) ([]byte, error) {
@@ -92,17 +91,14 @@ func (h *HiClient) loadPrivateKeys(ctx context.Context) error {
 	keyBackupKey, err := h.getAndDecodeSecret(ctx, id.SecretMegolmBackupV1)
 	if err != nil {
 		return fmt.Errorf("failed to get megolm backup key: %w", err)
-	}
-	h.KeyBackupKey, err = backup.MegolmBackupKeyFromBytes(keyBackupKey)
-	if err != nil {
+	} else if len(keyBackupKey) == 0 {
+		zerolog.Ctx(ctx).Debug().Msg("No megolm backup key stored")
+	} else if key, err := backup.MegolmBackupKeyFromBytes(keyBackupKey); err != nil {
 		return fmt.Errorf("failed to parse megolm backup key: %w", err)
+	} else if err = h.loadKeyBackup(ctx, key); err != nil {
+		// Key backup being unavailable shouldn't prevent using the client
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to load key backup version")
 	}
-	zerolog.Ctx(ctx).Debug().Msg("Fetching key backup version")
-	latestVersion, err := h.Client.GetKeyBackupLatestVersion(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get key backup latest version: %w", err)
-	}
-	h.KeyBackupVersion = latestVersion.Version
 	zerolog.Ctx(ctx).Debug().Msg("Secrets loaded")
 	return nil
 }
@@ -157,6 +153,8 @@ func (h *HiClient) VerifyWithRecoveryKey(ctx context.Context, code string) error
 	h.Verified = true
 	if !h.IsSyncing() {
 		go h.Sync()
+	} else {
+		h.WakeupKeyBackupUploader()
 	}
 	return nil
 }
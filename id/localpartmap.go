@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrLocalpartCollision = errors.New("localpart is already mapped to a different identifier")
+	ErrUnknownTruncated   = errors.New("localpart is truncated and the original identifier is not known")
+)
+
+// truncatedMarker is appended to localparts that were too long and had to be truncated.
+// It's followed by a hash of the full identifier. Two equals signs can never occur in
+// the output of EncodeUserLocalpart, so the marker is unambiguous.
+const truncatedMarker = "=="
+
+const truncatedHashLength = 16
+
+// LocalpartStore persists lossy mappings of a [LocalpartMapper].
+type LocalpartStore interface {
+	// GetIdentifier returns the identifier that was previously stored for the localpart, or an empty string if there isn't one.
+	GetIdentifier(ctx context.Context, localpart string) (string, error)
+	// SetIdentifier stores the identifier that the localpart was generated from.
+	SetIdentifier(ctx context.Context, localpart, identifier string) error
+}
+
+// LocalpartMapper converts arbitrary remote identifiers (e.g. bridge ghost user IDs) into valid
+// Matrix user ID localparts and back.
+//
+// Identifiers are encoded with EncodeUserLocalpart, which escapes uppercase letters with
+// underscores so that the original case is preserved. Identifiers that fit in a user ID are
+// encoded exactly like EncodeUserLocalpart would. Identifiers that are too long are truncated
+// and suffixed with a hash of the full identifier.
+//
+// Lossy mappings (truncated or lowercased identifiers) are saved in the Store, which allows decoding them
+// later and reporting two different identifiers mapping to the same localpart as an error instead of
+// silently mixing up users. Without a store, truncated localparts can't be decoded at all.
+type LocalpartMapper struct {
+	// Homeserver is the server name that the user IDs will be on. It's used to calculate the maximum localpart length.
+	Homeserver string
+	// ReservedLength is the number of characters reserved for other parts of the localpart,
+	// e.g. the static parts of a bridge username template.
+	ReservedLength int
+	// CaseInsensitive makes the mapper lowercase identifiers before encoding them,
+	// which avoids the underscore escapes for networks where identifiers aren't case-sensitive.
+	CaseInsensitive bool
+	// Store is used to persist lossy mappings.
+	Store LocalpartStore
+}
+
+// MaxLength returns the maximum length of an encoded localpart produced by this mapper.
+func (lm *LocalpartMapper) MaxLength() int {
+	// @localpart:homeserver
+	return UserIDMaxLength - len(lm.Homeserver) - 2 - lm.ReservedLength
+}
+
+func (lm *LocalpartMapper) remember(ctx context.Context, localpart, identifier string) error {
+	if lm.Store == nil {
+		return nil
+	}
+	existing, err := lm.Store.GetIdentifier(ctx, localpart)
+	if err != nil {
+		return fmt.Errorf("failed to get stored identifier: %w", err)
+	} else if existing == identifier {
+		return nil
+	} else if existing != "" {
+		return fmt.Errorf("%w: %q and %q both map to %q", ErrLocalpartCollision, existing, identifier, localpart)
+	}
+	err = lm.Store.SetIdentifier(ctx, localpart, identifier)
+	if err != nil {
+		return fmt.Errorf("failed to store identifier: %w", err)
+	}
+	return nil
+}
+
+// Encode converts the given identifier into a valid localpart.
+//
+// An error is only returned if the identifier collides with a different identifier
+// that was previously encoded using a mapper with the same store, or if the store fails.
+// The localpart is returned even in that case.
+func (lm *LocalpartMapper) Encode(ctx context.Context, identifier string) (string, error) {
+	input := identifier
+	if lm.CaseInsensitive {
+		input = strings.ToLower(input)
+	}
+	localpart := EncodeUserLocalpart(input)
+	lossy := input != identifier
+	if maxLength := lm.MaxLength(); len(localpart) > maxLength {
+		hash := sha256.Sum256([]byte(identifier))
+		cutoff := maxLength - len(truncatedMarker) - truncatedHashLength
+		localpart = truncateEncodedLocalpart(localpart, cutoff) + truncatedMarker + hex.EncodeToString(hash[:])[:truncatedHashLength]
+		lossy = true
+	}
+	if lossy || lm.CaseInsensitive {
+		return localpart, lm.remember(ctx, localpart, identifier)
+	}
+	return localpart, nil
+}
+
+// truncateEncodedLocalpart cuts the localpart to at most maxLength bytes without splitting escape sequences.
+func truncateEncodedLocalpart(localpart string, maxLength int) string {
+	i := 0
+	for i < len(localpart) {
+		size := 1
+		switch localpart[i] {
+		case '=':
+			size = 3
+		case '_':
+			size = 2
+		}
+		if i+size > maxLength {
+			break
+		}
+		i += size
+	}
+	return localpart[:i]
+}
+
+// Decode converts a localpart produced by Encode back into the original identifier.
+//
+// If the mapper is case-insensitive, the returned identifier is the original one only if it
+// is found in the store. Otherwise, the lowercased identifier is returned.
+func (lm *LocalpartMapper) Decode(ctx context.Context, localpart string) (string, error) {
+	if lm.Store != nil && (lm.CaseInsensitive || strings.Contains(localpart, truncatedMarker)) {
+		identifier, err := lm.Store.GetIdentifier(ctx, localpart)
+		if err != nil {
+			return "", fmt.Errorf("failed to get stored identifier: %w", err)
+		} else if identifier != "" {
+			return identifier, nil
+		}
+	}
+	if strings.Contains(localpart, truncatedMarker) {
+		return "", ErrUnknownTruncated
+	}
+	return DecodeUserLocalpart(localpart)
+}
+
+// UserID encodes the identifier and returns a user ID on the mapper's homeserver.
+func (lm *LocalpartMapper) UserID(ctx context.Context, identifier string) (UserID, error) {
+	localpart, err := lm.Encode(ctx, identifier)
+	return NewUserID(localpart, lm.Homeserver), err
+}
+
+// ParseUserID parses the given user ID and decodes the localpart. The user ID must be on the mapper's homeserver.
+func (lm *LocalpartMapper) ParseUserID(ctx context.Context, userID UserID) (string, error) {
+	localpart, homeserver, err := userID.ParseAndValidate()
+	if err != nil {
+		return "", err
+	} else if homeserver != lm.Homeserver {
+		return "", fmt.Errorf("'%s' is not on %s", userID, lm.Homeserver)
+	}
+	return lm.Decode(ctx, localpart)
+}
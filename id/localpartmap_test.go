@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type mapLocalpartStore map[string]string
+
+func (m mapLocalpartStore) GetIdentifier(ctx context.Context, localpart string) (string, error) {
+	return m[localpart], nil
+}
+
+func (m mapLocalpartStore) SetIdentifier(ctx context.Context, localpart, identifier string) error {
+	m[localpart] = identifier
+	return nil
+}
+
+type failingLocalpartStore struct{}
+
+var errTestStoreFailed = errors.New("store failed")
+
+func (failingLocalpartStore) GetIdentifier(ctx context.Context, localpart string) (string, error) {
+	return "", errTestStoreFailed
+}
+
+func (failingLocalpartStore) SetIdentifier(ctx context.Context, localpart, identifier string) error {
+	return errTestStoreFailed
+}
+
+func TestLocalpartMapper_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	lm := &id.LocalpartMapper{Homeserver: "example.com"}
+	for _, input := range []string{"meow", "Alph@Bet_50up", "+123456789", "user with spaces 🐈"} {
+		localpart, err := lm.Encode(ctx, input)
+		require.NoError(t, err)
+		assert.NoError(t, id.ValidateUserLocalpart(localpart))
+		decoded, err := lm.Decode(ctx, localpart)
+		require.NoError(t, err)
+		assert.Equal(t, input, decoded)
+	}
+}
+
+func TestLocalpartMapper_Truncate(t *testing.T) {
+	ctx := context.Background()
+	store := mapLocalpartStore{}
+	lm := &id.LocalpartMapper{Homeserver: "example.com", ReservedLength: len("whatsapp_"), Store: store}
+	input := strings.Repeat("Ä", 200)
+	localpart, err := lm.Encode(ctx, input)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(localpart), lm.MaxLength())
+	assert.NoError(t, id.ValidateUserLocalpart(localpart))
+	userID := id.NewUserID("whatsapp_"+localpart, lm.Homeserver)
+	assert.LessOrEqual(t, len(userID), id.UserIDMaxLength)
+
+	decoded, err := lm.Decode(ctx, localpart)
+	require.NoError(t, err)
+	assert.Equal(t, input, decoded)
+
+	decoded, err = (&id.LocalpartMapper{Homeserver: "example.com", Store: store}).Decode(ctx, localpart)
+	require.NoError(t, err)
+	assert.Equal(t, input, decoded)
+	_, err = (&id.LocalpartMapper{Homeserver: "example.com"}).Decode(ctx, localpart)
+	assert.ErrorIs(t, err, id.ErrUnknownTruncated)
+
+	otherLocalpart, err := lm.Encode(ctx, input+"a")
+	require.NoError(t, err)
+	assert.NotEqual(t, localpart, otherLocalpart)
+}
+
+func TestLocalpartMapper_CaseInsensitive(t *testing.T) {
+	ctx := context.Background()
+	lm := &id.LocalpartMapper{Homeserver: "example.com", CaseInsensitive: true, Store: mapLocalpartStore{}}
+	localpart, err := lm.Encode(ctx, "MeOw")
+	require.NoError(t, err)
+	assert.Equal(t, "meow", localpart)
+	decoded, err := lm.Decode(ctx, localpart)
+	require.NoError(t, err)
+	assert.Equal(t, "MeOw", decoded)
+
+	_, err = lm.Encode(ctx, "meow")
+	assert.ErrorIs(t, err, id.ErrLocalpartCollision)
+}
+
+func TestLocalpartMapper_ShortUnchanged(t *testing.T) {
+	ctx := context.Background()
+	store := mapLocalpartStore{}
+	lm := &id.LocalpartMapper{Homeserver: "example.com", Store: store}
+	for _, input := range []string{"meow", "Alph@Bet_50up", "+123456789"} {
+		localpart, err := lm.Encode(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, id.EncodeUserLocalpart(input), localpart)
+	}
+	assert.Empty(t, store)
+}
+
+func TestLocalpartMapper_UserID(t *testing.T) {
+	ctx := context.Background()
+	lm := &id.LocalpartMapper{Homeserver: "example.com"}
+	userID, err := lm.UserID(ctx, "Meow")
+	require.NoError(t, err)
+	assert.Equal(t, id.UserID("@_meow:example.com"), userID)
+	decoded, err := lm.ParseUserID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Meow", decoded)
+	_, err = lm.ParseUserID(ctx, "@_meow:example.org")
+	assert.Error(t, err)
+}
+
+func TestLocalpartMapper_StoreError(t *testing.T) {
+	ctx := context.Background()
+	lm := &id.LocalpartMapper{Homeserver: "example.com", CaseInsensitive: true, Store: failingLocalpartStore{}}
+	localpart, err := lm.Encode(ctx, "MeOw")
+	assert.ErrorIs(t, err, errTestStoreFailed)
+	assert.Equal(t, "meow", localpart, "the localpart should be returned even if the store fails")
+	_, err = lm.Decode(ctx, localpart)
+	assert.ErrorIs(t, err, errTestStoreFailed)
+}
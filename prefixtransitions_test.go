@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+type sentTestEvent struct {
+	path    string
+	body    []byte
+	content map[string]any
+}
+
+func newPrefixTestClient(t *testing.T) (*mautrix.Client, func() []sentTestEvent) {
+	var lock sync.Mutex
+	var sent []sentTestEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var content map[string]any
+		require.NoError(t, json.Unmarshal(body, &content))
+		lock.Lock()
+		sent = append(sent, sentTestEvent{
+			path:    strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.com/"),
+			body:    body,
+			content: content,
+		})
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@alice:example.com", "token")
+	require.NoError(t, err)
+	cli.PrefixTransitions = event.NewPrefixTransitions().
+		AddType(event.StateUnstablePolicyUser, event.StatePolicyUser).
+		AddField("org.example.msc1234.field", "m.field")
+	return cli, func() []sentTestEvent {
+		lock.Lock()
+		defer lock.Unlock()
+		result := sent
+		sent = nil
+		return result
+	}
+}
+
+func TestClient_SendStateEvent_PrefixTransitions(t *testing.T) {
+	ctx := context.Background()
+	cli, getSent := newPrefixTestClient(t)
+	content := map[string]any{"entity": "@evil:example.com", "m.field": "meow"}
+
+	resp, err := cli.SendStateEvent(ctx, "!room:example.com", event.StatePolicyUser, "rule", content)
+	require.NoError(t, err)
+	assert.EqualValues(t, "$event", resp.EventID)
+	sent := getSent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, "state/m.policy.rule.user/rule", sent[0].path)
+	assert.Equal(t, "state/org.matrix.mjolnir.rule.user/rule", sent[1].path)
+	for _, evt := range sent {
+		assert.Equal(t, "meow", evt.content["m.field"])
+		assert.Equal(t, "meow", evt.content["org.example.msc1234.field"])
+	}
+	assert.NotContains(t, content, "org.example.msc1234.field", "input content shouldn't be modified")
+
+	cli.PrefixTransitions.SetWriteMode(event.WriteStablePrefix)
+	_, err = cli.SendStateEvent(ctx, "!room:example.com", event.StateUnstablePolicyUser, "rule", content)
+	require.NoError(t, err)
+	sent = getSent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "state/m.policy.rule.user/rule", sent[0].path)
+	assert.Equal(t, map[string]any{"entity": "@evil:example.com", "m.field": "meow"}, sent[0].content)
+}
+
+func TestClient_SendMessageEvent_PrefixTransitions(t *testing.T) {
+	ctx := context.Background()
+	cli, getSent := newPrefixTestClient(t)
+	unstableType := event.Type{Type: "org.example.msc1234.event", Class: event.MessageEventType}
+	stableType := event.Type{Type: "m.example", Class: event.MessageEventType}
+	cli.PrefixTransitions.AddType(unstableType, stableType)
+
+	_, err := cli.SendMessageEvent(ctx, "!room:example.com", stableType, map[string]any{"m.field": "meow"})
+	require.NoError(t, err)
+	sent := getSent()
+	require.Len(t, sent, 1, "message events should only be sent once")
+	assert.True(t, strings.HasPrefix(sent[0].path, "send/org.example.msc1234.event/"))
+	assert.Equal(t, map[string]any{"m.field": "meow", "org.example.msc1234.field": "meow"}, sent[0].content)
+
+	cli.PrefixTransitions.SetWriteMode(event.WriteStablePrefix)
+	_, err = cli.SendMessageEvent(ctx, "!room:example.com", unstableType, map[string]any{"org.example.msc1234.field": "meow"})
+	require.NoError(t, err)
+	sent = getSent()
+	require.Len(t, sent, 1)
+	assert.True(t, strings.HasPrefix(sent[0].path, "send/m.example/"))
+	assert.Equal(t, map[string]any{"m.field": "meow"}, sent[0].content)
+}
+
+func TestClient_SendMessageEvent_PrefixTransitions_LargeIntegers(t *testing.T) {
+	cli, getSent := newPrefixTestClient(t)
+	_, err := cli.SendMessageEvent(context.Background(), "!room:example.com", event.EventMessage, map[string]any{
+		"m.field":   "meow",
+		"custom_ts": int64(9007199254740993),
+	})
+	require.NoError(t, err)
+	sent := getSent()
+	require.Len(t, sent, 1)
+	assert.Contains(t, string(sent[0].body), `"custom_ts":9007199254740993`)
+}
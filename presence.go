@@ -0,0 +1,160 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// PresenceManager maintains the desired presence of a user. The user is online while the manager is running,
+// unavailable after being idle for [PresenceManager.IdleTimeout] and offline after the manager is stopped.
+//
+// Presence updates are only sent when the effective presence or status message changes.
+type PresenceManager struct {
+	// IdleTimeout is how long after the last [PresenceManager.MarkActive] call the presence is changed
+	// from online to unavailable. Idle transitions are disabled if this is zero.
+	IdleTimeout time.Duration
+	// OnChange is called after the effective presence has been changed successfully.
+	OnChange func(presence ReqPresence)
+
+	setPresence func(ctx context.Context, req ReqPresence) error
+
+	lock      sync.Mutex
+	ctx       context.Context
+	running   bool
+	idle      bool
+	idleTimer *time.Timer
+	desired   ReqPresence
+	sent      *ReqPresence
+}
+
+// NewPresenceManager creates a presence manager that sends presence updates using the given function.
+func NewPresenceManager(setPresence func(ctx context.Context, req ReqPresence) error) *PresenceManager {
+	return &PresenceManager{
+		setPresence: setPresence,
+		desired:     ReqPresence{Presence: event.PresenceOnline},
+	}
+}
+
+// NewPresenceManager creates a presence manager that sets the presence of this client's user.
+func (cli *Client) NewPresenceManager() *PresenceManager {
+	return NewPresenceManager(cli.SetPresenceWithStatus)
+}
+
+// Start marks the user as active and sends the desired presence.
+// The context is used for presence updates sent in the background by idle transitions.
+func (pm *PresenceManager) Start(ctx context.Context) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.ctx = ctx
+	pm.running = true
+	return pm.unlockedMarkActive(ctx)
+}
+
+// Stop stops idle transitions and sets the user offline.
+func (pm *PresenceManager) Stop(ctx context.Context) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if !pm.running {
+		return nil
+	}
+	pm.running = false
+	if pm.idleTimer != nil {
+		pm.idleTimer.Stop()
+		pm.idleTimer = nil
+	}
+	return pm.unlockedApply(ctx)
+}
+
+// SetDesired changes the presence and status message the user wants to have while they're active.
+func (pm *PresenceManager) SetDesired(ctx context.Context, presence event.Presence, statusMsg string) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.desired = ReqPresence{Presence: presence, StatusMsg: statusMsg}
+	return pm.unlockedApply(ctx)
+}
+
+// MarkActive resets the idle timer and restores the desired presence if the user was idle.
+func (pm *PresenceManager) MarkActive(ctx context.Context) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if !pm.running {
+		return nil
+	}
+	return pm.unlockedMarkActive(ctx)
+}
+
+// Current returns the presence that was last sent successfully.
+func (pm *PresenceManager) Current() ReqPresence {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if pm.sent == nil {
+		return ReqPresence{}
+	}
+	return *pm.sent
+}
+
+func (pm *PresenceManager) unlockedMarkActive(ctx context.Context) error {
+	pm.idle = false
+	if pm.IdleTimeout > 0 {
+		if pm.idleTimer == nil {
+			pm.idleTimer = time.AfterFunc(pm.IdleTimeout, pm.markIdle)
+		} else {
+			pm.idleTimer.Reset(pm.IdleTimeout)
+		}
+	}
+	return pm.unlockedApply(ctx)
+}
+
+func (pm *PresenceManager) markIdle() {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if !pm.running || pm.idle {
+		return
+	}
+	pm.idle = true
+	err := pm.unlockedApply(pm.ctx)
+	if err != nil {
+		zerolog.Ctx(pm.ctx).Err(err).Msg("Failed to set presence to unavailable after idle timeout")
+	}
+}
+
+func (pm *PresenceManager) effective() ReqPresence {
+	req := pm.desired
+	if !pm.running {
+		req.Presence = event.PresenceOffline
+	} else if pm.idle && req.Presence == event.PresenceOnline {
+		req.Presence = event.PresenceUnavailable
+	}
+	return req
+}
+
+func (pm *PresenceManager) unlockedApply(ctx context.Context) error {
+	if !pm.running && pm.sent == nil {
+		// Nothing has been sent yet, so there's no need to go offline either
+		return nil
+	}
+	req := pm.effective()
+	if pm.sent != nil && *pm.sent == req {
+		return nil
+	}
+	err := pm.setPresence(ctx, req)
+	if err != nil {
+		return err
+	}
+	pm.sent = &req
+	if pm.OnChange != nil {
+		pm.OnChange(req)
+	}
+	return nil
+}
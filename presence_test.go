@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestClient_SetPresenceWithStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/_matrix/client/v3/presence/@user:example.com/status", r.URL.Path)
+		var req mautrix.ReqPresence
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, event.PresenceUnavailable, req.Presence)
+		assert.Equal(t, "Lunch", req.StatusMsg)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	err = cli.SetPresenceWithStatus(context.Background(), mautrix.ReqPresence{Presence: event.PresenceUnavailable, StatusMsg: "Lunch"})
+	require.NoError(t, err)
+}
+
+type presenceRecorder struct {
+	lock sync.Mutex
+	sent []mautrix.ReqPresence
+}
+
+func (pr *presenceRecorder) set(_ context.Context, req mautrix.ReqPresence) error {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.sent = append(pr.sent, req)
+	return nil
+}
+
+func (pr *presenceRecorder) presences() []event.Presence {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	out := make([]event.Presence, len(pr.sent))
+	for i, req := range pr.sent {
+		out[i] = req.Presence
+	}
+	return out
+}
+
+func TestPresenceManager_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	var rec presenceRecorder
+	pm := mautrix.NewPresenceManager(rec.set)
+	require.NoError(t, pm.SetDesired(ctx, event.PresenceOnline, "Working"))
+	assert.Empty(t, rec.presences(), "nothing should be sent before starting")
+	require.NoError(t, pm.Start(ctx))
+	require.NoError(t, pm.MarkActive(ctx))
+	require.NoError(t, pm.SetDesired(ctx, event.PresenceOnline, "Working"))
+	assert.Equal(t, []event.Presence{event.PresenceOnline}, rec.presences(), "duplicate updates should be skipped")
+	assert.Equal(t, "Working", pm.Current().StatusMsg)
+	require.NoError(t, pm.Stop(ctx))
+	assert.Equal(t, []event.Presence{event.PresenceOnline, event.PresenceOffline}, rec.presences())
+}
+
+func TestPresenceManager_Idle(t *testing.T) {
+	ctx := context.Background()
+	var rec presenceRecorder
+	pm := mautrix.NewPresenceManager(rec.set)
+	pm.IdleTimeout = 20 * time.Millisecond
+	require.NoError(t, pm.Start(ctx))
+	assert.Eventually(t, func() bool {
+		return pm.Current().Presence == event.PresenceUnavailable
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, pm.MarkActive(ctx))
+	assert.Equal(t, event.PresenceOnline, pm.Current().Presence)
+	require.NoError(t, pm.Stop(ctx))
+	assert.Equal(t, []event.Presence{event.PresenceOnline, event.PresenceUnavailable, event.PresenceOnline, event.PresenceOffline}, rec.presences())
+}
@@ -132,36 +132,74 @@ func (rule *PushRule) GetActions() PushActionArray {
 }
 
 func (rule *PushRule) Match(room Room, evt *event.Event) bool {
-	if rule == nil || !rule.Enabled {
+	return rule.match(room, evt, nil)
+}
+
+// match checks if the rule matches the given event. If trace is non-nil, the reason for
+// the rule not matching and the results of individual conditions are stored in it.
+func (rule *PushRule) match(room Room, evt *event.Event, trace *RuleTrace) bool {
+	if rule == nil {
+		return false
+	} else if !rule.Enabled {
+		trace.skip(SkipReasonDisabled)
 		return false
 	}
 	if rule.RuleID == ".m.rule.contains_display_name" || rule.RuleID == ".m.rule.contains_user_name" || rule.RuleID == ".m.rule.roomnotif" {
 		if _, containsMentions := evt.Content.Raw["m.mentions"]; containsMentions {
 			// Disable legacy mention push rules when the event contains the new mentions key
+			trace.skip(SkipReasonLegacyMentionRule)
 			return false
 		}
 	}
+	var matched bool
 	switch rule.Type {
 	case OverrideRule, UnderrideRule:
-		return rule.matchConditions(room, evt)
+		matched = rule.matchConditions(room, evt, trace)
+		if !matched {
+			trace.skip(SkipReasonConditionNotMet)
+		}
 	case ContentRule:
-		return rule.matchPattern(room, evt)
+		matched = rule.matchPattern(room, evt)
+		if !matched {
+			trace.skip(SkipReasonPatternNotMatched)
+		}
 	case RoomRule:
-		return id.RoomID(rule.RuleID) == evt.RoomID
+		matched = id.RoomID(rule.RuleID) == evt.RoomID
+		if !matched {
+			trace.skip(SkipReasonDifferentRoom)
+		}
 	case SenderRule:
-		return id.UserID(rule.RuleID) == evt.Sender
+		matched = id.UserID(rule.RuleID) == evt.Sender
+		if !matched {
+			trace.skip(SkipReasonDifferentSender)
+		}
 	default:
-		return false
+		trace.skip(SkipReasonUnknownRuleType)
 	}
+	if matched && trace != nil {
+		trace.Matched = true
+	}
+	return matched
 }
 
-func (rule *PushRule) matchConditions(room Room, evt *event.Event) bool {
-	for _, cond := range rule.Conditions {
-		if !cond.Match(room, evt) {
-			return false
+func (rule *PushRule) matchConditions(room Room, evt *event.Event, trace *RuleTrace) bool {
+	if trace == nil {
+		for _, cond := range rule.Conditions {
+			if !cond.Match(room, evt) {
+				return false
+			}
 		}
+		return true
+	}
+	// When tracing, evaluate all conditions even after one fails, so that the trace shows the result of each one.
+	allMatched := true
+	trace.Conditions = make([]*ConditionTrace, len(rule.Conditions))
+	for i, cond := range rule.Conditions {
+		matched := cond.Match(room, evt)
+		trace.Conditions[i] = &ConditionTrace{Condition: cond, Matched: matched}
+		allMatched = allMatched && matched
 	}
-	return true
+	return allMatched
 }
 
 func (rule *PushRule) matchPattern(room Room, evt *event.Event) bool {
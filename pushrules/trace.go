@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"maunium.net/go/mautrix/event"
+)
+
+// SkipReason describes why a push rule didn't match an event.
+type SkipReason string
+
+const (
+	SkipReasonDisabled          SkipReason = "disabled"
+	SkipReasonLegacyMentionRule SkipReason = "legacy_mention_rule"
+	SkipReasonConditionNotMet   SkipReason = "condition_not_met"
+	SkipReasonPatternNotMatched SkipReason = "pattern_not_matched"
+	SkipReasonDifferentRoom     SkipReason = "different_room"
+	SkipReasonDifferentSender   SkipReason = "different_sender"
+	SkipReasonUnknownRuleType   SkipReason = "unknown_rule_type"
+)
+
+// ConditionTrace is the result of evaluating a single condition of a push rule.
+type ConditionTrace struct {
+	Condition *PushCondition `json:"condition"`
+	Matched   bool           `json:"matched"`
+}
+
+// RuleTrace is the result of evaluating a single push rule.
+type RuleTrace struct {
+	Rule    *PushRule `json:"rule"`
+	Matched bool      `json:"matched"`
+	// SkipReason is set if the rule didn't match.
+	SkipReason SkipReason `json:"skip_reason,omitempty"`
+	// Conditions contains the result of each condition for override and underride rules.
+	// All conditions are evaluated, even if an earlier one didn't match.
+	Conditions []*ConditionTrace `json:"conditions,omitempty"`
+}
+
+func (rt *RuleTrace) skip(reason SkipReason) {
+	if rt != nil {
+		rt.SkipReason = reason
+	}
+}
+
+// EvaluationTrace explains how a push ruleset was evaluated for an event.
+type EvaluationTrace struct {
+	// MatchedRule is the rule that matched the event, or nil if no rule matched.
+	MatchedRule *PushRule `json:"matched_rule,omitempty"`
+	// Actions are the actions that apply to the event, i.e. the same value that GetActions would return.
+	Actions PushActionArray `json:"actions"`
+	// Rules contains every rule that was evaluated, in evaluation order. The last entry is the matched rule,
+	// while all others were skipped. Rules after the matched rule are not evaluated and not included.
+	// For room and sender rules, only the rule with the event's room ID or sender is evaluated.
+	Rules []*RuleTrace `json:"rules"`
+}
+
+// Skipped returns the traces of all evaluated rules that didn't match.
+func (et *EvaluationTrace) Skipped() []*RuleTrace {
+	skipped := make([]*RuleTrace, 0, len(et.Rules))
+	for _, rule := range et.Rules {
+		if !rule.Matched {
+			skipped = append(skipped, rule)
+		}
+	}
+	return skipped
+}
+
+func (rules PushRuleArray) traceMatchingRule(room Room, evt *event.Event, trace *EvaluationTrace) *PushRule {
+	for _, rule := range rules {
+		ruleTrace := &RuleTrace{Rule: rule}
+		trace.Rules = append(trace.Rules, ruleTrace)
+		if rule.match(room, evt, ruleTrace) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (ruleMap PushRuleMap) traceMatchingRule(room Room, evt *event.Event, trace *EvaluationTrace) *PushRule {
+	var rule *PushRule
+	var found bool
+	switch ruleMap.Type {
+	case RoomRule:
+		rule, found = ruleMap.Map[string(evt.RoomID)]
+	case SenderRule:
+		rule, found = ruleMap.Map[string(evt.Sender)]
+	}
+	if !found {
+		return nil
+	}
+	ruleTrace := &RuleTrace{Rule: rule}
+	trace.Rules = append(trace.Rules, ruleTrace)
+	if rule.match(room, evt, ruleTrace) {
+		return rule
+	}
+	return nil
+}
+
+// EvaluateWithTrace evaluates the ruleset against the given event like GetActions,
+// but also returns which rule matched and why each evaluated rule before it didn't match.
+//
+// This is meant for debugging notification decisions, e.g. "why didn't I get notified?" UIs or logging.
+func (rs *PushRuleset) EvaluateWithTrace(room Room, evt *event.Event) *EvaluationTrace {
+	trace := &EvaluationTrace{Rules: []*RuleTrace{}}
+	arrays := []interface {
+		traceMatchingRule(Room, *event.Event, *EvaluationTrace) *PushRule
+	}{rs.Override, rs.Content, rs.Room, rs.Sender, rs.Underride}
+	for _, pra := range arrays {
+		if trace.MatchedRule = pra.traceMatchingRule(room, evt, trace); trace.MatchedRule != nil {
+			break
+		}
+	}
+	trace.Actions = trace.MatchedRule.GetActions()
+	if trace.Actions == nil {
+		trace.Actions = DefaultPushActions
+	}
+	return trace
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func TestPushRuleset_EvaluateWithTrace(t *testing.T) {
+	disabledRule := &pushrules.PushRule{
+		RuleID:     ".m.rule.disabled",
+		Enabled:    false,
+		Conditions: []*pushrules.PushCondition{newMatchPushCondition("content.msgtype", "m.emote")},
+		Actions:    pushrules.PushActionArray{{Action: pushrules.ActionNotify}},
+	}
+	failingRule := &pushrules.PushRule{
+		RuleID:  ".m.rule.failing",
+		Enabled: true,
+		Conditions: []*pushrules.PushCondition{
+			newMatchPushCondition("content.body", "no match"),
+			newMatchPushCondition("content.msgtype", "m.emote"),
+		},
+		Actions: pushrules.PushActionArray{{Action: pushrules.ActionNotify}},
+	}
+	contentRule := &pushrules.PushRule{
+		RuleID:  "keyword",
+		Enabled: true,
+		Pattern: "keyword",
+		Actions: pushrules.PushActionArray{{Action: pushrules.ActionNotify}},
+	}
+	roomActions := pushrules.PushActionArray{{Action: pushrules.ActionDontNotify}}
+	roomRule := &pushrules.PushRule{
+		RuleID:  "!fakeroom:maunium.net",
+		Enabled: true,
+		Actions: roomActions,
+	}
+	ruleset := &pushrules.PushRuleset{
+		Override:  pushrules.PushRuleArray{disabledRule, failingRule}.SetType(pushrules.OverrideRule),
+		Content:   pushrules.PushRuleArray{contentRule}.SetType(pushrules.ContentRule),
+		Room:      pushrules.PushRuleArray{roomRule}.SetTypeAndMap(pushrules.RoomRule),
+		Sender:    pushrules.PushRuleArray{}.SetTypeAndMap(pushrules.SenderRule),
+		Underride: pushrules.PushRuleArray{},
+	}
+
+	evt := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgEmote,
+		Body:    "is testing pushrules",
+	})
+	trace := ruleset.EvaluateWithTrace(blankTestRoom, evt)
+	assert.Equal(t, ruleset.GetActions(blankTestRoom, evt), trace.Actions)
+	assert.Equal(t, roomRule, trace.MatchedRule)
+	assert.Equal(t, roomActions, trace.Actions)
+
+	require.Len(t, trace.Rules, 4)
+	assert.Equal(t, pushrules.SkipReasonDisabled, trace.Rules[0].SkipReason)
+	assert.Empty(t, trace.Rules[0].Conditions)
+
+	assert.Equal(t, pushrules.SkipReasonConditionNotMet, trace.Rules[1].SkipReason)
+	require.Len(t, trace.Rules[1].Conditions, 2)
+	assert.False(t, trace.Rules[1].Conditions[0].Matched)
+	assert.True(t, trace.Rules[1].Conditions[1].Matched)
+
+	assert.Equal(t, pushrules.SkipReasonPatternNotMatched, trace.Rules[2].SkipReason)
+
+	assert.True(t, trace.Rules[3].Matched)
+	assert.Empty(t, trace.Rules[3].SkipReason)
+	assert.Len(t, trace.Skipped(), 3)
+}
+
+func TestPushRuleset_EvaluateWithTrace_NoMatch(t *testing.T) {
+	ruleset := &pushrules.PushRuleset{
+		Override: pushrules.PushRuleArray{{
+			RuleID:     ".m.rule.failing",
+			Enabled:    true,
+			Conditions: []*pushrules.PushCondition{newMatchPushCondition("content.body", "no match")},
+		}}.SetType(pushrules.OverrideRule),
+	}
+	evt := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    "hello",
+	})
+	trace := ruleset.EvaluateWithTrace(blankTestRoom, evt)
+	assert.Nil(t, trace.MatchedRule)
+	assert.Equal(t, pushrules.DefaultPushActions, trace.Actions)
+	require.Len(t, trace.Rules, 1)
+	assert.False(t, trace.Rules[0].Matched)
+}
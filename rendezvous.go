@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrRendezvousSessionExpired = errors.New("rendezvous session expired or was deleted")
+	ErrRendezvousTimeout        = errors.New("timed out waiting for rendezvous session to be updated")
+)
+
+// RendezvousSession is a session on a rendezvous server as specified in MSC4108.
+//
+// The rendezvous server only stores a single opaque payload, which both parties of the session take turns replacing.
+// Requests to the session are never authenticated, as the session URL may point at a different server.
+type RendezvousSession struct {
+	URL     string
+	Expires time.Time
+
+	// PollInterval is how often the session is checked for updates in [RendezvousSession.Receive].
+	PollInterval time.Duration
+
+	cli  *Client
+	etag string
+}
+
+type respCreateRendezvous struct {
+	URL string `json:"url"`
+}
+
+// unauthenticatedCopy returns a client that shares the HTTP client of this one, but doesn't send the access token.
+func (cli *Client) unauthenticatedCopy() *Client {
+	return &Client{
+		HomeserverURL: cli.HomeserverURL,
+		UserAgent:     cli.UserAgent,
+		Client:        cli.Client,
+		Log:           cli.Log,
+	}
+}
+
+// CreateRendezvousSession creates a new rendezvous session on the homeserver with the given initial payload.
+// See https://github.com/matrix-org/matrix-spec-proposals/pull/4108
+func (cli *Client) CreateRendezvousSession(ctx context.Context, data []byte) (*RendezvousSession, error) {
+	rs := &RendezvousSession{cli: cli.unauthenticatedCopy()}
+	var resp respCreateRendezvous
+	_, httpResp, err := rs.cli.MakeFullRequestWithResp(ctx, FullRequest{
+		Method:       http.MethodPost,
+		URL:          cli.BuildClientURL("unstable", "org.matrix.msc4108", "rendezvous"),
+		Headers:      http.Header{"Content-Type": {"text/plain"}},
+		RequestBytes: data,
+		ResponseJSON: &resp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs.URL = resp.URL
+	rs.updateFromResponse(httpResp)
+	return rs, nil
+}
+
+// JoinRendezvousSession returns a handle to an existing rendezvous session, e.g. one whose URL was received in a QR code.
+// Nothing is sent to the server until the session is used.
+func (cli *Client) JoinRendezvousSession(url string) *RendezvousSession {
+	return &RendezvousSession{URL: url, cli: cli.unauthenticatedCopy()}
+}
+
+func (rs *RendezvousSession) updateFromResponse(resp *http.Response) {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		rs.etag = etag
+	}
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		rs.Expires = expires
+	}
+}
+
+func wrapRendezvousError(err error) error {
+	var httpErr HTTPError
+	if errors.As(err, &httpErr) && (httpErr.IsStatus(http.StatusNotFound) || httpErr.IsStatus(http.StatusGone)) {
+		return ErrRendezvousSessionExpired
+	}
+	return err
+}
+
+// Send replaces the payload of the session. The payload must not have been changed by the other party
+// since it was last received, otherwise the server will reject the update.
+func (rs *RendezvousSession) Send(ctx context.Context, data []byte) error {
+	headers := http.Header{"Content-Type": {"text/plain"}}
+	if rs.etag != "" {
+		headers.Set("If-Match", rs.etag)
+	}
+	_, resp, err := rs.cli.MakeFullRequestWithResp(ctx, FullRequest{
+		Method:       http.MethodPut,
+		URL:          rs.URL,
+		Headers:      headers,
+		RequestBytes: data,
+	})
+	if err != nil {
+		return wrapRendezvousError(err)
+	}
+	rs.updateFromResponse(resp)
+	return nil
+}
+
+// Receive waits until the payload of the session is changed by the other party and returns the new payload.
+// If the session has never been read or written using this handle, the current payload is returned immediately.
+func (rs *RendezvousSession) Receive(ctx context.Context) ([]byte, error) {
+	interval := rs.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		headers := http.Header{}
+		if rs.etag != "" {
+			headers.Set("If-None-Match", rs.etag)
+		}
+		data, resp, err := rs.cli.MakeFullRequestWithResp(ctx, FullRequest{
+			Method:  http.MethodGet,
+			URL:     rs.URL,
+			Headers: headers,
+		})
+		var httpErr HTTPError
+		if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusNotModified) {
+			// Not changed yet, keep polling
+		} else if err != nil {
+			return nil, wrapRendezvousError(err)
+		} else {
+			rs.updateFromResponse(resp)
+			return data, nil
+		}
+		if !rs.Expires.IsZero() && time.Now().After(rs.Expires) {
+			return nil, ErrRendezvousTimeout
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Delete deletes the session from the rendezvous server.
+func (rs *RendezvousSession) Delete(ctx context.Context) error {
+	_, err := rs.cli.MakeFullRequest(ctx, FullRequest{
+		Method:       http.MethodDelete,
+		URL:          rs.URL,
+		RequestBytes: []byte{},
+	})
+	return wrapRendezvousError(err)
+}
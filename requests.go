@@ -58,6 +58,23 @@ type ReqRegister struct {
 	Type AuthType `json:"type,omitempty"`
 }
 
+// ReqRequestEmailToken is the JSON request for https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3registeremailrequesttoken
+// and https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3account3pidemailrequesttoken
+type ReqRequestEmailToken struct {
+	ClientSecret string `json:"client_secret"`
+	Email        string `json:"email"`
+	SendAttempt  int    `json:"send_attempt"`
+	NextLink     string `json:"next_link,omitempty"`
+}
+
+// ThreePIDCredentials is the threepid_creds object used in the m.login.email.identity and m.login.msisdn auth stages.
+type ThreePIDCredentials struct {
+	SID           string `json:"sid"`
+	ClientSecret  string `json:"client_secret"`
+	IDServer      string `json:"id_server,omitempty"`
+	IDAccessToken string `json:"id_access_token,omitempty"`
+}
+
 type BaseAuthData struct {
 	Type    AuthType `json:"type"`
 	Session string   `json:"session,omitempty"`
@@ -146,6 +163,18 @@ type ReqInvite3PID struct {
 	Address  string `json:"address"`
 }
 
+// ReqJoinRoom is the JSON request for https://spec.matrix.org/v1.12/client-server-api/#post_matrixclientv3joinroomidoralias
+type ReqJoinRoom struct {
+	Via    []string `json:"-"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// ReqKnockRoom is the JSON request for https://spec.matrix.org/v1.12/client-server-api/#post_matrixclientv3knockroomidoralias
+type ReqKnockRoom struct {
+	Via    []string `json:"-"`
+	Reason string   `json:"reason,omitempty"`
+}
+
 type ReqLeave struct {
 	Reason string `json:"reason,omitempty"`
 }
@@ -181,7 +210,8 @@ type ReqTyping struct {
 }
 
 type ReqPresence struct {
-	Presence event.Presence `json:"presence"`
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg,omitempty"`
 }
 
 type ReqAliasCreate struct {
@@ -225,8 +255,9 @@ func (otk *OneTimeKey) MarshalJSON() ([]byte, error) {
 }
 
 type ReqUploadKeys struct {
-	DeviceKeys  *DeviceKeys             `json:"device_keys,omitempty"`
-	OneTimeKeys map[id.KeyID]OneTimeKey `json:"one_time_keys,omitempty"`
+	DeviceKeys   *DeviceKeys             `json:"device_keys,omitempty"`
+	OneTimeKeys  map[id.KeyID]OneTimeKey `json:"one_time_keys,omitempty"`
+	FallbackKeys map[id.KeyID]OneTimeKey `json:"fallback_keys,omitempty"`
 }
 
 type ReqKeysSignatures struct {
@@ -419,6 +450,60 @@ func (req *ReqHierarchy) Query() map[string]string {
 	return query
 }
 
+// ReqGetRelations contains the parameters for https://spec.matrix.org/v1.10/client-server-api/#get_matrixclientv1roomsroomidrelationseventid
+//
+// As it's a GET method, there is no JSON body, so this is only query parameters and path suffixes.
+type ReqGetRelations struct {
+	// Only return events with this relation type. If empty, relations of all types are returned.
+	RelationType event.RelationType
+	// Only return events with this type. Can only be used together with RelationType.
+	EventType event.Type
+	// The direction to return events from. Defaults to backwards (newest first) if not specified.
+	Dir Direction
+	// A pagination token from a previous GetRelations call.
+	From string
+	// A pagination token to stop returning results at.
+	To string
+	// The maximum number of events to return per response.
+	// The server will apply a default value if a limit isn't provided.
+	Limit int
+	// Whether the server should also include events that relate to the given event indirectly,
+	// i.e. events that relate to events which relate to the given event (MSC3981).
+	Recurse bool
+}
+
+func (rgr *ReqGetRelations) PathSuffix() ClientURLPath {
+	if rgr == nil || rgr.RelationType == "" {
+		return ClientURLPath{}
+	} else if rgr.EventType.Type == "" {
+		return ClientURLPath{rgr.RelationType}
+	}
+	return ClientURLPath{rgr.RelationType, rgr.EventType.Type}
+}
+
+func (rgr *ReqGetRelations) Query() map[string]string {
+	query := map[string]string{}
+	if rgr == nil {
+		return query
+	}
+	if rgr.Dir != 0 {
+		query["dir"] = string(rgr.Dir)
+	}
+	if rgr.From != "" {
+		query["from"] = rgr.From
+	}
+	if rgr.To != "" {
+		query["to"] = rgr.To
+	}
+	if rgr.Limit > 0 {
+		query["limit"] = strconv.Itoa(rgr.Limit)
+	}
+	if rgr.Recurse {
+		query["recurse"] = "true"
+	}
+	return query
+}
+
 type ReqAppservicePing struct {
 	TxnID string `json:"transaction_id,omitempty"`
 }
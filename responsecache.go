@@ -0,0 +1,194 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// CachedEndpoint identifies an endpoint whose responses can be stored in a ResponseCache.
+type CachedEndpoint string
+
+const (
+	CachedEndpointProfile      CachedEndpoint = "profile"
+	CachedEndpointDisplayName  CachedEndpoint = "displayname"
+	CachedEndpointAvatarURL    CachedEndpoint = "avatar_url"
+	CachedEndpointMediaConfig  CachedEndpoint = "media_config"
+	CachedEndpointVersions     CachedEndpoint = "versions"
+	CachedEndpointCapabilities CachedEndpoint = "capabilities"
+)
+
+const DefaultResponseCacheMaxEntries = 10000
+
+type responseCacheKey struct {
+	endpoint CachedEndpoint
+	key      string
+}
+
+type responseCacheEntry struct {
+	data    json.RawMessage
+	expires time.Time
+}
+
+// ResponseCache is an in-memory cache for responses of endpoints that rarely change,
+// such as user profiles and the media repository config.
+//
+// Caching is opt-in: set [Client.ResponseCache] to enable it. A single cache can be shared between
+// multiple clients talking to the same homeserver (e.g. all appservice intents).
+//
+// Only successful responses are cached. The own profile is invalidated automatically when it's
+// changed using [Client.SetDisplayName] or [Client.SetAvatarURL], other changes (e.g. profile updates
+// received via sync) must be invalidated manually with [ResponseCache.InvalidateProfile].
+type ResponseCache struct {
+	// DefaultTTL is used for endpoints that don't have a specific TTL set.
+	DefaultTTL time.Duration
+	// MaxEntries is the maximum number of responses to keep in the cache.
+	// When the cache is full, expired entries are pruned and then arbitrary entries are evicted.
+	MaxEntries int
+
+	ttls    map[CachedEndpoint]time.Duration
+	entries map[responseCacheKey]*responseCacheEntry
+	lock    sync.RWMutex
+}
+
+// NewResponseCache creates a new response cache where all endpoints have the given TTL.
+func NewResponseCache(defaultTTL time.Duration) *ResponseCache {
+	return &ResponseCache{
+		DefaultTTL: defaultTTL,
+		MaxEntries: DefaultResponseCacheMaxEntries,
+
+		ttls:    make(map[CachedEndpoint]time.Duration),
+		entries: make(map[responseCacheKey]*responseCacheEntry),
+	}
+}
+
+// SetTTL overrides the TTL for a specific endpoint. A zero or negative TTL disables caching for the endpoint.
+func (rc *ResponseCache) SetTTL(endpoint CachedEndpoint, ttl time.Duration) {
+	rc.lock.Lock()
+	if rc.ttls == nil {
+		rc.ttls = make(map[CachedEndpoint]time.Duration)
+	}
+	rc.ttls[endpoint] = ttl
+	rc.lock.Unlock()
+}
+
+func (rc *ResponseCache) getTTL(endpoint CachedEndpoint) time.Duration {
+	ttl, ok := rc.ttls[endpoint]
+	if !ok {
+		return rc.DefaultTTL
+	}
+	return ttl
+}
+
+func (rc *ResponseCache) get(endpoint CachedEndpoint, key string) (json.RawMessage, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	rc.lock.RLock()
+	entry, ok := rc.entries[responseCacheKey{endpoint, key}]
+	rc.lock.RUnlock()
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func (rc *ResponseCache) set(endpoint CachedEndpoint, key string, data json.RawMessage) {
+	if rc == nil || data == nil {
+		return
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	ttl := rc.getTTL(endpoint)
+	if ttl <= 0 {
+		return
+	} else if rc.entries == nil {
+		rc.entries = make(map[responseCacheKey]*responseCacheEntry)
+	}
+	if rc.MaxEntries > 0 && len(rc.entries) >= rc.MaxEntries {
+		rc.pruneLocked()
+		for cacheKey := range rc.entries {
+			if len(rc.entries) < rc.MaxEntries {
+				break
+			}
+			delete(rc.entries, cacheKey)
+		}
+	}
+	rc.entries[responseCacheKey{endpoint, key}] = &responseCacheEntry{
+		data:    data,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// Invalidate removes a single cached response. The key is the user ID for profile endpoints
+// and an empty string for server-wide endpoints like the media config.
+func (rc *ResponseCache) Invalidate(endpoint CachedEndpoint, key string) {
+	if rc == nil {
+		return
+	}
+	rc.lock.Lock()
+	delete(rc.entries, responseCacheKey{endpoint, key})
+	rc.lock.Unlock()
+}
+
+// InvalidateProfile removes all cached profile responses of the given user.
+func (rc *ResponseCache) InvalidateProfile(userID id.UserID) {
+	if rc == nil {
+		return
+	}
+	rc.lock.Lock()
+	delete(rc.entries, responseCacheKey{CachedEndpointProfile, userID.String()})
+	delete(rc.entries, responseCacheKey{CachedEndpointDisplayName, userID.String()})
+	delete(rc.entries, responseCacheKey{CachedEndpointAvatarURL, userID.String()})
+	rc.lock.Unlock()
+}
+
+// Clear removes all cached responses.
+func (rc *ResponseCache) Clear() {
+	if rc == nil {
+		return
+	}
+	rc.lock.Lock()
+	clear(rc.entries)
+	rc.lock.Unlock()
+}
+
+// Prune removes all expired responses from the cache.
+func (rc *ResponseCache) Prune() {
+	if rc == nil {
+		return
+	}
+	rc.lock.Lock()
+	rc.pruneLocked()
+	rc.lock.Unlock()
+}
+
+func (rc *ResponseCache) pruneLocked() {
+	now := time.Now()
+	for cacheKey, entry := range rc.entries {
+		if now.After(entry.expires) {
+			delete(rc.entries, cacheKey)
+		}
+	}
+}
+
+func (cli *Client) makeCachedGetRequest(ctx context.Context, endpoint CachedEndpoint, key, urlPath string, resBody any) error {
+	if data, ok := cli.ResponseCache.get(endpoint, key); ok {
+		return json.Unmarshal(data, resBody)
+	}
+	data, err := cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, resBody)
+	if err == nil {
+		cli.ResponseCache.set(endpoint, key, data)
+	}
+	return err
+}
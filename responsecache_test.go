@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func newCachingTestClient(t *testing.T, requests *atomic.Int32) *mautrix.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/_matrix/client/v3/profile/@alice:example.com":
+			_, _ = w.Write([]byte(`{"displayname":"Alice","avatar_url":"mxc://example.com/abc"}`))
+		case "/_matrix/client/v3/profile/@alice:example.com/displayname":
+			_, _ = w.Write([]byte(`{}`))
+		case "/_matrix/client/v1/media/config":
+			_, _ = w.Write([]byte(`{"m.upload.size":1234}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Not found"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@alice:example.com", "token")
+	require.NoError(t, err)
+	cli.ResponseCache = mautrix.NewResponseCache(time.Minute)
+	return cli
+}
+
+func TestResponseCache_Profile(t *testing.T) {
+	var requests atomic.Int32
+	cli := newCachingTestClient(t, &requests)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		profile, err := cli.GetProfile(ctx, "@alice:example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", profile.DisplayName)
+		assert.Equal(t, id.MustParseContentURI("mxc://example.com/abc"), profile.AvatarURL)
+	}
+	assert.EqualValues(t, 1, requests.Load())
+
+	cli.ResponseCache.InvalidateProfile("@alice:example.com")
+	_, err := cli.GetProfile(ctx, "@alice:example.com")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, requests.Load())
+
+	require.NoError(t, cli.SetDisplayName(ctx, "Alice 2"))
+	_, err = cli.GetProfile(ctx, "@alice:example.com")
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, requests.Load())
+}
+
+func TestResponseCache_ErrorsNotCached(t *testing.T) {
+	var requests atomic.Int32
+	cli := newCachingTestClient(t, &requests)
+	ctx := context.Background()
+	_, err := cli.GetProfile(ctx, "@bob:example.com")
+	assert.ErrorIs(t, err, mautrix.MNotFound)
+	_, err = cli.GetProfile(ctx, "@bob:example.com")
+	assert.ErrorIs(t, err, mautrix.MNotFound)
+	assert.EqualValues(t, 2, requests.Load())
+}
+
+func TestResponseCache_TTL(t *testing.T) {
+	var requests atomic.Int32
+	cli := newCachingTestClient(t, &requests)
+	ctx := context.Background()
+	cli.ResponseCache.SetTTL(mautrix.CachedEndpointMediaConfig, 0)
+	for i := 0; i < 2; i++ {
+		resp, err := cli.GetMediaConfig(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1234, resp.UploadSize)
+	}
+	assert.EqualValues(t, 2, requests.Load())
+
+	cli.ResponseCache.SetTTL(mautrix.CachedEndpointMediaConfig, time.Millisecond)
+	_, err := cli.GetMediaConfig(ctx)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = cli.GetMediaConfig(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, requests.Load())
+}
@@ -32,6 +32,11 @@ type RespJoinRoom struct {
 	RoomID id.RoomID `json:"room_id"`
 }
 
+// RespKnockRoom is the JSON response for https://spec.matrix.org/v1.12/client-server-api/#post_matrixclientv3knockroomidoralias
+type RespKnockRoom struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
 // RespLeaveRoom is the JSON response for https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3roomsroomidleave
 type RespLeaveRoom struct{}
 
@@ -177,8 +182,26 @@ type RespRegister struct {
 	HomeServer string `json:"home_server,omitempty"`
 }
 
+// RespRequestToken is the JSON response for https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3registeremailrequesttoken
+type RespRequestToken struct {
+	SID       string `json:"sid"`
+	SubmitURL string `json:"submit_url,omitempty"`
+}
+
 type LoginFlow struct {
 	Type AuthType `json:"type"`
+	// IdentityProviders is the list of SSO identity providers for m.login.sso flows.
+	IdentityProviders []IdentityProvider `json:"identity_providers,omitempty"`
+	// GetLoginToken is set for m.login.token flows if the server allows generating login tokens (MSC3882).
+	GetLoginToken bool `json:"get_login_token,omitempty"`
+}
+
+// IdentityProvider is an SSO identity provider as specified in https://spec.matrix.org/v1.11/client-server-api/#client-login-via-sso
+type IdentityProvider struct {
+	ID    string              `json:"id"`
+	Name  string              `json:"name"`
+	Icon  id.ContentURIString `json:"icon,omitempty"`
+	Brand string              `json:"brand,omitempty"`
 }
 
 // RespLoginFlows is the JSON response for https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3login
@@ -558,6 +581,15 @@ type RespHierarchy struct {
 	Rooms     []ChildRoomsChunk `json:"rooms"`
 }
 
+// RespGetRelations is the JSON response for https://spec.matrix.org/v1.10/client-server-api/#get_matrixclientv1roomsroomidrelationseventid
+type RespGetRelations struct {
+	Chunk     []*event.Event `json:"chunk"`
+	NextBatch string         `json:"next_batch,omitempty"`
+	PrevBatch string         `json:"prev_batch,omitempty"`
+	// How deep the server recursed when the recurse flag was set (MSC3981).
+	RecursionDepth int `json:"recursion_depth,omitempty"`
+}
+
 type ChildRoomsChunk struct {
 	AvatarURL        id.ContentURI           `json:"avatar_url,omitempty"`
 	CanonicalAlias   id.RoomAlias            `json:"canonical_alias,omitempty"`
@@ -572,6 +604,29 @@ type ChildRoomsChunk struct {
 	WorldReadble     bool                    `json:"world_readable"`
 }
 
+// RespRoomSummary is the JSON response for the room summary API proposed in MSC3266.
+// See https://github.com/matrix-org/matrix-spec-proposals/pull/3266
+type RespRoomSummary struct {
+	RoomID           id.RoomID      `json:"room_id"`
+	AvatarURL        id.ContentURI  `json:"avatar_url,omitempty"`
+	CanonicalAlias   id.RoomAlias   `json:"canonical_alias,omitempty"`
+	GuestCanJoin     bool           `json:"guest_can_join"`
+	JoinRule         event.JoinRule `json:"join_rule,omitempty"`
+	Name             string         `json:"name,omitempty"`
+	NumJoinedMembers int            `json:"num_joined_members"`
+	RoomType         event.RoomType `json:"room_type,omitempty"`
+	Topic            string         `json:"topic,omitempty"`
+	WorldReadable    bool           `json:"world_readable"`
+
+	Membership     event.Membership  `json:"membership,omitempty"`
+	RoomVersion    event.RoomVersion `json:"room_version,omitempty"`
+	Encryption     id.Algorithm      `json:"encryption,omitempty"`
+	AllowedRoomIDs []id.RoomID       `json:"allowed_room_ids,omitempty"`
+
+	UnstableRoomVersion event.RoomVersion `json:"im.nheko.summary.room_version,omitempty"`
+	UnstableEncryption  id.Algorithm      `json:"im.nheko.summary.encryption,omitempty"`
+}
+
 type StrippedStateWithTime struct {
 	event.StrippedState
 	Timestamp jsontime.UnixMilli `json:"origin_server_ts"`
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrRoomPreviewUnavailable = errors.New("room preview is not available")
+
+// GetRoomPreview gets a summary of a room that can be shown to the user before joining.
+//
+// The MSC3266 summary endpoint is used if the server supports it. Otherwise, this falls back to
+// the space hierarchy API (which works for public and space-restricted rooms), and finally to
+// peeking at the room state (which works for world-readable rooms and rooms the user is already in).
+func (cli *Client) GetRoomPreview(ctx context.Context, roomIDOrAlias string, via ...string) (*RespRoomSummary, error) {
+	if cli.SpecVersions == nil || cli.SpecVersions.Supports(FeatureRoomSummary) {
+		resp, err := cli.GetRoomSummary(ctx, roomIDOrAlias, via...)
+		if err == nil || !errors.Is(err, MUnrecognized) {
+			return resp, err
+		}
+	}
+	var roomID id.RoomID
+	if strings.HasPrefix(roomIDOrAlias, "#") {
+		resolved, err := cli.ResolveAlias(ctx, id.RoomAlias(roomIDOrAlias))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve alias: %w", err)
+		}
+		roomID = resolved.RoomID
+	} else {
+		roomID = id.RoomID(roomIDOrAlias)
+	}
+	maxDepth := 0
+	hierarchy, hierarchyErr := cli.Hierarchy(ctx, roomID, &ReqHierarchy{Limit: 1, MaxDepth: &maxDepth})
+	if hierarchyErr == nil && len(hierarchy.Rooms) > 0 && hierarchy.Rooms[0].RoomID == roomID {
+		return roomSummaryFromHierarchy(&hierarchy.Rooms[0]), nil
+	}
+	state, peekErr := cli.StateAsArray(ctx, roomID)
+	if peekErr == nil {
+		return cli.roomSummaryFromState(roomID, state), nil
+	}
+	if hierarchyErr == nil {
+		hierarchyErr = errors.New("room not found in hierarchy")
+	}
+	return nil, fmt.Errorf("%w (hierarchy: %w, peeking: %w)", ErrRoomPreviewUnavailable, hierarchyErr, peekErr)
+}
+
+func roomSummaryFromHierarchy(chunk *ChildRoomsChunk) *RespRoomSummary {
+	return &RespRoomSummary{
+		RoomID:           chunk.RoomID,
+		AvatarURL:        chunk.AvatarURL,
+		CanonicalAlias:   chunk.CanonicalAlias,
+		GuestCanJoin:     chunk.GuestCanJoin,
+		JoinRule:         chunk.JoinRule,
+		Name:             chunk.Name,
+		NumJoinedMembers: chunk.NumJoinedMembers,
+		RoomType:         chunk.RoomType,
+		Topic:            chunk.Topic,
+		WorldReadable:    chunk.WorldReadble,
+	}
+}
+
+func (cli *Client) roomSummaryFromState(roomID id.RoomID, state []*event.Event) *RespRoomSummary {
+	summary := &RespRoomSummary{RoomID: roomID}
+	for _, evt := range state {
+		if evt.StateKey == nil {
+			continue
+		}
+		err := evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			continue
+		}
+		switch content := evt.Content.Parsed.(type) {
+		case *event.CreateEventContent:
+			summary.RoomType = content.Type
+			summary.RoomVersion = content.RoomVersion
+			if summary.RoomVersion == "" {
+				summary.RoomVersion = event.RoomV1
+			}
+		case *event.RoomNameEventContent:
+			summary.Name = content.Name
+		case *event.TopicEventContent:
+			summary.Topic = content.Topic
+		case *event.RoomAvatarEventContent:
+			summary.AvatarURL = content.URL.ParseOrIgnore()
+		case *event.CanonicalAliasEventContent:
+			summary.CanonicalAlias = content.Alias
+		case *event.JoinRulesEventContent:
+			summary.JoinRule = content.JoinRule
+			summary.AllowedRoomIDs = summary.AllowedRoomIDs[:0]
+			for _, allow := range content.Allow {
+				if allow.Type == event.JoinRuleAllowRoomMembership {
+					summary.AllowedRoomIDs = append(summary.AllowedRoomIDs, allow.RoomID)
+				}
+			}
+		case *event.GuestAccessEventContent:
+			summary.GuestCanJoin = content.GuestAccess == event.GuestAccessCanJoin
+		case *event.HistoryVisibilityEventContent:
+			summary.WorldReadable = content.HistoryVisibility == event.HistoryVisibilityWorldReadable
+		case *event.EncryptionEventContent:
+			summary.Encryption = content.Algorithm
+		case *event.MemberEventContent:
+			if content.Membership == event.MembershipJoin {
+				summary.NumJoinedMembers++
+			}
+			if id.UserID(*evt.StateKey) == cli.UserID {
+				summary.Membership = content.Membership
+			}
+		}
+	}
+	return summary
+}
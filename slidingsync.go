@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Special state keys that can be used in [SlidingSyncRoomConfig.RequiredState].
+const (
+	// SlidingSyncStateKeyMe matches the state key of the current user.
+	SlidingSyncStateKeyMe = "$ME"
+	// SlidingSyncStateKeyLazy requests lazy-loaded membership of the senders of the returned timeline events.
+	SlidingSyncStateKeyLazy = "$LAZY"
+	// SlidingSyncStateKeyWildcard matches all state keys of the given type.
+	SlidingSyncStateKeyWildcard = "*"
+)
+
+// SlidingSyncRequiredState is a tuple of event type and state key used to filter the state returned by sliding sync.
+type SlidingSyncRequiredState [2]string
+
+func (rs SlidingSyncRequiredState) EventType() string {
+	return rs[0]
+}
+
+func (rs SlidingSyncRequiredState) StateKey() string {
+	return rs[1]
+}
+
+// SlidingSyncRoomConfig is the common part of sliding sync lists and room subscriptions.
+type SlidingSyncRoomConfig struct {
+	RequiredState []SlidingSyncRequiredState `json:"required_state"`
+	TimelineLimit int                        `json:"timeline_limit"`
+}
+
+// SlidingSyncRange is an inclusive range of indexes in a sliding sync list.
+type SlidingSyncRange [2]int
+
+type SlidingSyncListFilters struct {
+	IsDM      *bool    `json:"is_dm,omitempty"`
+	IsInvite  *bool    `json:"is_invite,omitempty"`
+	RoomTypes []string `json:"room_types,omitempty"`
+	NotTypes  []string `json:"not_room_types,omitempty"`
+}
+
+type SlidingSyncList struct {
+	SlidingSyncRoomConfig
+	Ranges  []SlidingSyncRange      `json:"ranges,omitempty"`
+	Filters *SlidingSyncListFilters `json:"filters,omitempty"`
+}
+
+type SlidingSyncExtensionToDevice struct {
+	Enabled bool   `json:"enabled"`
+	Limit   int    `json:"limit,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+type SlidingSyncExtensionToggle struct {
+	Enabled bool `json:"enabled"`
+}
+
+type SlidingSyncRequestExtensions struct {
+	ToDevice    *SlidingSyncExtensionToDevice `json:"to_device,omitempty"`
+	E2EE        *SlidingSyncExtensionToggle   `json:"e2ee,omitempty"`
+	AccountData *SlidingSyncExtensionToggle   `json:"account_data,omitempty"`
+	Receipts    *SlidingSyncExtensionToggle   `json:"receipts,omitempty"`
+	Typing      *SlidingSyncExtensionToggle   `json:"typing,omitempty"`
+}
+
+// ReqSlidingSync is the request body for simplified sliding sync (MSC4186).
+type ReqSlidingSync struct {
+	Pos     string `json:"-"`
+	Timeout int    `json:"-"`
+
+	ConnID            string                               `json:"conn_id,omitempty"`
+	Lists             map[string]*SlidingSyncList          `json:"lists,omitempty"`
+	RoomSubscriptions map[id.RoomID]*SlidingSyncRoomConfig `json:"room_subscriptions,omitempty"`
+	Extensions        *SlidingSyncRequestExtensions        `json:"extensions,omitempty"`
+	SetPresence       event.Presence                       `json:"-"`
+	Client            *http.Client                         `json:"-"`
+}
+
+func (req *ReqSlidingSync) BuildQuery() map[string]string {
+	query := map[string]string{
+		"timeout": strconv.Itoa(req.Timeout),
+	}
+	if req.Pos != "" {
+		query["pos"] = req.Pos
+	}
+	if req.SetPresence != "" {
+		query["set_presence"] = string(req.SetPresence)
+	}
+	return query
+}
+
+type SlidingSyncHero struct {
+	UserID      id.UserID           `json:"user_id"`
+	Displayname string              `json:"displayname,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+}
+
+type SlidingSyncRoom struct {
+	Name    string              `json:"name,omitempty"`
+	Avatar  id.ContentURIString `json:"avatar,omitempty"`
+	Heroes  []SlidingSyncHero   `json:"heroes,omitempty"`
+	IsDM    bool                `json:"is_dm,omitempty"`
+	Initial bool                `json:"initial,omitempty"`
+
+	RequiredState []*event.Event `json:"required_state,omitempty"`
+	InviteState   []*event.Event `json:"invite_state,omitempty"`
+	Timeline      []*event.Event `json:"timeline,omitempty"`
+	PrevBatch     string         `json:"prev_batch,omitempty"`
+	Limited       bool           `json:"limited,omitempty"`
+	NumLive       int            `json:"num_live,omitempty"`
+	BumpStamp     int64          `json:"bump_stamp,omitempty"`
+
+	JoinedCount       *int `json:"joined_count,omitempty"`
+	InvitedCount      *int `json:"invited_count,omitempty"`
+	NotificationCount int  `json:"notification_count"`
+	HighlightCount    int  `json:"highlight_count"`
+}
+
+type SlidingSyncListResponse struct {
+	Count int `json:"count"`
+}
+
+type SlidingSyncToDeviceResponse struct {
+	NextBatch string         `json:"next_batch"`
+	Events    []*event.Event `json:"events,omitempty"`
+}
+
+type SlidingSyncE2EEResponse struct {
+	DeviceLists                  DeviceLists       `json:"device_lists"`
+	DeviceOneTimeKeysCount       OTKCount          `json:"device_one_time_keys_count"`
+	DeviceUnusedFallbackKeyTypes []id.KeyAlgorithm `json:"device_unused_fallback_key_types"`
+}
+
+type SlidingSyncAccountDataResponse struct {
+	Global []*event.Event               `json:"global,omitempty"`
+	Rooms  map[id.RoomID][]*event.Event `json:"rooms,omitempty"`
+}
+
+type SlidingSyncRoomEventsResponse struct {
+	Rooms map[id.RoomID]*event.Event `json:"rooms,omitempty"`
+}
+
+type SlidingSyncResponseExtensions struct {
+	ToDevice    *SlidingSyncToDeviceResponse    `json:"to_device,omitempty"`
+	E2EE        *SlidingSyncE2EEResponse        `json:"e2ee,omitempty"`
+	AccountData *SlidingSyncAccountDataResponse `json:"account_data,omitempty"`
+	Receipts    *SlidingSyncRoomEventsResponse  `json:"receipts,omitempty"`
+	Typing      *SlidingSyncRoomEventsResponse  `json:"typing,omitempty"`
+}
+
+// RespSlidingSync is the response for simplified sliding sync (MSC4186).
+type RespSlidingSync struct {
+	Pos        string                              `json:"pos"`
+	Lists      map[string]*SlidingSyncListResponse `json:"lists,omitempty"`
+	Rooms      map[id.RoomID]*SlidingSyncRoom      `json:"rooms,omitempty"`
+	Extensions SlidingSyncResponseExtensions       `json:"extensions"`
+}
+
+// SlidingSync makes a request to the simplified sliding sync endpoint (MSC4186).
+//
+// Sliding sync uses a sticky connection: list and extension parameters are remembered by the server for
+// the given pos, so the same request body should be sent each time unless the parameters change.
+func (cli *Client) SlidingSync(ctx context.Context, req *ReqSlidingSync) (resp *RespSlidingSync, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"unstable", "org.matrix.simplified_msc3575", "sync"}, req.BuildQuery())
+	_, err = cli.MakeFullRequest(ctx, FullRequest{
+		Method:       http.MethodPost,
+		URL:          urlPath,
+		RequestJSON:  req,
+		ResponseJSON: &resp,
+		Client:       req.Client,
+		// We don't want automatic retries for sync requests, the caller should handle those.
+		MaxAttempts: 1,
+	})
+	return
+}
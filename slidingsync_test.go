@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func newSlidingSyncTestClient(t *testing.T, handler http.HandlerFunc) *mautrix.Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestClient_SlidingSync(t *testing.T) {
+	cli := newSlidingSyncTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/_matrix/client/unstable/org.matrix.simplified_msc3575/sync", r.URL.Path)
+		assert.Equal(t, "abc", r.URL.Query().Get("pos"))
+		assert.Equal(t, "30000", r.URL.Query().Get("timeout"))
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.NotContains(t, req, "pos")
+		assert.Equal(t, []any{[]any{float64(0), float64(19)}}, req["lists"].(map[string]any)["all"].(map[string]any)["ranges"])
+		_, _ = w.Write([]byte(`{
+			"pos": "def",
+			"lists": {"all": {"count": 42}},
+			"rooms": {"!room:example.com": {
+				"initial": true,
+				"required_state": [{"type": "m.room.name", "state_key": "", "content": {"name": "Test"}}],
+				"timeline": [{"type": "m.room.message", "event_id": "$evt", "content": {"msgtype": "m.text", "body": "hi"}}],
+				"limited": true,
+				"prev_batch": "prev",
+				"joined_count": 2,
+				"heroes": [{"user_id": "@other:example.com"}]
+			}},
+			"extensions": {"to_device": {"next_batch": "td1", "events": []}}
+		}`))
+	})
+	resp, err := cli.SlidingSync(context.Background(), &mautrix.ReqSlidingSync{
+		Pos:     "abc",
+		Timeout: 30000,
+		Lists: map[string]*mautrix.SlidingSyncList{
+			"all": {Ranges: []mautrix.SlidingSyncRange{{0, 19}}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "def", resp.Pos)
+	assert.Equal(t, 42, resp.Lists["all"].Count)
+	room := resp.Rooms["!room:example.com"]
+	require.NotNil(t, room)
+	assert.True(t, room.Initial)
+	assert.True(t, room.Limited)
+	assert.Equal(t, "prev", room.PrevBatch)
+	assert.Equal(t, 2, *room.JoinedCount)
+	assert.Equal(t, id.UserID("@other:example.com"), room.Heroes[0].UserID)
+	assert.Len(t, room.RequiredState, 1)
+	assert.Len(t, room.Timeline, 1)
+	assert.Equal(t, "td1", resp.Extensions.ToDevice.NextBatch)
+}
+
+func TestClient_SlidingSync_UnknownPos(t *testing.T) {
+	cli := newSlidingSyncTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"errcode": "M_UNKNOWN_POS", "error": "Unknown position"}`))
+	})
+	_, err := cli.SlidingSync(context.Background(), &mautrix.ReqSlidingSync{Pos: "expired"})
+	assert.True(t, errors.Is(err, mautrix.MUnknownPos))
+}
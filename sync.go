@@ -40,6 +40,12 @@ type ExtensibleSyncer interface {
 	OnEventType(eventType event.Type, callback EventHandler)
 }
 
+// PresenceSettingSyncer is an optional interface for syncers to choose the set_presence parameter of each sync request.
+// If implemented, it's used instead of [Client.SyncPresence], which must not be changed while syncing.
+type PresenceSettingSyncer interface {
+	GetSyncPresence() event.Presence
+}
+
 type DispatchableSyncer interface {
 	Dispatch(ctx context.Context, evt *event.Event)
 }
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"net/http"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ThirdPartyFieldType describes a field used in third-party lookups.
+type ThirdPartyFieldType struct {
+	Regexp      string `json:"regexp"`
+	Placeholder string `json:"placeholder"`
+}
+
+// ThirdPartyProtocolInstance is a single instance of a third-party protocol, e.g. a specific server of an IRC bridge.
+type ThirdPartyProtocolInstance struct {
+	Desc      string            `json:"desc"`
+	Icon      string            `json:"icon,omitempty"`
+	Fields    map[string]string `json:"fields"`
+	NetworkID string            `json:"network_id"`
+	// InstanceID is set by the homeserver when returning protocols to clients.
+	InstanceID string `json:"instance_id,omitempty"`
+}
+
+// ThirdPartyProtocol is the metadata of a third-party protocol exposed by an appservice.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartyprotocolprotocol
+type ThirdPartyProtocol struct {
+	UserFields     []string                       `json:"user_fields"`
+	LocationFields []string                       `json:"location_fields"`
+	Icon           string                         `json:"icon"`
+	FieldTypes     map[string]ThirdPartyFieldType `json:"field_types"`
+	Instances      []ThirdPartyProtocolInstance   `json:"instances"`
+}
+
+// ThirdPartyLocation is a portal room to a location on a third-party network.
+type ThirdPartyLocation struct {
+	Alias    id.RoomAlias      `json:"alias"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// ThirdPartyUser is a Matrix user representing a user on a third-party network.
+type ThirdPartyUser struct {
+	UserID   id.UserID         `json:"userid"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// GetThirdPartyProtocols gets the third-party protocols that can be reached through the homeserver.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartyprotocols
+func (cli *Client) GetThirdPartyProtocols(ctx context.Context) (resp map[string]*ThirdPartyProtocol, err error) {
+	urlPath := cli.BuildClientURL("v3", "thirdparty", "protocols")
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// GetThirdPartyProtocol gets the metadata of a single third-party protocol.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartyprotocolprotocol
+func (cli *Client) GetThirdPartyProtocol(ctx context.Context, protocol string) (resp *ThirdPartyProtocol, err error) {
+	urlPath := cli.BuildClientURL("v3", "thirdparty", "protocol", protocol)
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// QueryThirdPartyLocations finds portal rooms to the third-party location matching the given protocol-specific fields.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartylocationprotocol
+func (cli *Client) QueryThirdPartyLocations(ctx context.Context, protocol string, fields map[string]string) (resp []*ThirdPartyLocation, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "thirdparty", "location", protocol}, fields)
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// QueryThirdPartyUsers finds Matrix users representing the third-party user matching the given protocol-specific fields.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartyuserprotocol
+func (cli *Client) QueryThirdPartyUsers(ctx context.Context, protocol string, fields map[string]string) (resp []*ThirdPartyUser, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "thirdparty", "user", protocol}, fields)
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// GetThirdPartyLocationsByAlias finds the third-party locations that the given portal room alias points to.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartylocation
+func (cli *Client) GetThirdPartyLocationsByAlias(ctx context.Context, alias id.RoomAlias) (resp []*ThirdPartyLocation, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "thirdparty", "location"}, map[string]string{"alias": alias.String()})
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// GetThirdPartyUsersByID finds the third-party users that the given Matrix user ID represents.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3thirdpartyuser
+func (cli *Client) GetThirdPartyUsersByID(ctx context.Context, userID id.UserID) (resp []*ThirdPartyUser, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "thirdparty", "user"}, map[string]string{"userid": userID.String()})
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var (
+	ErrNoSupportedUIAFlow = errors.New("server didn't offer any user-interactive auth flow with supported stages")
+	ErrUIAStageFailed     = errors.New("user-interactive auth stage failed")
+	ErrUIAPollTimeout     = errors.New("timed out waiting for user-interactive auth stage to be completed")
+)
+
+// UIAStageHandler produces the auth data for a single user-interactive auth stage.
+// The params are the parameters the server sent for the stage, or nil if there are none.
+// The type and session fields are added to the returned data automatically.
+type UIAStageHandler func(ctx context.Context, params any) (map[string]any, error)
+
+type uiaStage struct {
+	handler UIAStageHandler
+	poll    bool
+}
+
+// UIAHelper completes user-interactive authentication flows using handlers for each supported stage.
+// The first flow offered by the server where every remaining stage has a handler is used.
+//
+// Helpers created with [NewUIAHelper] support m.login.dummy by default.
+type UIAHelper struct {
+	// PollInterval is how often polling stages (like email validation) are resubmitted
+	// while waiting for the user to complete them outside the client.
+	PollInterval time.Duration
+	// PollTimeout is how long polling stages are resubmitted before giving up.
+	PollTimeout time.Duration
+
+	stages map[AuthType]uiaStage
+}
+
+// NewUIAHelper creates a new user-interactive auth helper that supports m.login.dummy.
+func NewUIAHelper() *UIAHelper {
+	h := &UIAHelper{
+		PollInterval: 5 * time.Second,
+		PollTimeout:  15 * time.Minute,
+		stages:       make(map[AuthType]uiaStage),
+	}
+	h.HandleStage(AuthTypeDummy, func(ctx context.Context, params any) (map[string]any, error) {
+		return map[string]any{}, nil
+	})
+	return h
+}
+
+// HandleStage registers a handler for the given stage. This can be used for custom stages that
+// the helper doesn't support natively. If the stage already has a handler, it's replaced.
+func (h *UIAHelper) HandleStage(stage AuthType, handler UIAStageHandler) {
+	h.stages[stage] = uiaStage{handler: handler}
+}
+
+// HandlePollingStage registers a handler for a stage that is completed outside the client, such as clicking
+// a link in an email. If the server doesn't mark the stage as completed, the same auth data is resubmitted
+// every [UIAHelper.PollInterval] until it succeeds or [UIAHelper.PollTimeout] is reached.
+func (h *UIAHelper) HandlePollingStage(stage AuthType, handler UIAStageHandler) {
+	h.stages[stage] = uiaStage{handler: handler, poll: true}
+}
+
+// HandlePassword adds support for the m.login.password stage using the given identifier and password.
+func (h *UIAHelper) HandlePassword(identifier UserIdentifier, password string) {
+	h.HandleStage(AuthTypePassword, func(ctx context.Context, params any) (map[string]any, error) {
+		return map[string]any{
+			"identifier": identifier,
+			"password":   password,
+		}, nil
+	})
+}
+
+// HandleReCAPTCHA adds support for the m.login.recaptcha stage. The solve function is called with the
+// reCAPTCHA site key provided by the server. It should display the CAPTCHA to the user and return the response token.
+func (h *UIAHelper) HandleReCAPTCHA(solve func(ctx context.Context, siteKey string) (string, error)) {
+	h.HandleStage(AuthTypeReCAPTCHA, func(ctx context.Context, params any) (map[string]any, error) {
+		paramMap, _ := params.(map[string]any)
+		siteKey, _ := paramMap["public_key"].(string)
+		if siteKey == "" {
+			return nil, fmt.Errorf("server didn't provide reCAPTCHA site key")
+		}
+		response, err := solve(ctx, siteKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"response": response}, nil
+	})
+}
+
+// HandleEmailIdentity adds support for the m.login.email.identity stage using credentials from
+// [Client.RequestRegisterEmailToken] or [Client.RequestAddEmailToken]. The stage is polled until
+// the user has clicked the link in the validation email.
+func (h *UIAHelper) HandleEmailIdentity(creds ThreePIDCredentials) {
+	h.HandlePollingStage(AuthTypeEmail, func(ctx context.Context, params any) (map[string]any, error) {
+		return map[string]any{"threepid_creds": creds}, nil
+	})
+}
+
+// NextStage finds the next stage to complete in the first flow where all remaining stages are supported.
+func (h *UIAHelper) NextStage(uia *RespUserInteractive) (AuthType, error) {
+	for _, flow := range uia.Flows {
+		var next AuthType
+		supported := true
+		for _, stage := range flow.Stages {
+			if slices.Contains(uia.Completed, string(stage)) {
+				continue
+			} else if _, ok := h.stages[stage]; !ok {
+				supported = false
+				break
+			} else if next == "" {
+				next = stage
+			}
+		}
+		if supported && next != "" {
+			return next, nil
+		}
+	}
+	return "", ErrNoSupportedUIAFlow
+}
+
+// NextAuth returns the auth data for the next stage to complete.
+func (h *UIAHelper) NextAuth(ctx context.Context, uia *RespUserInteractive) (AuthType, map[string]any, error) {
+	stage, err := h.NextStage(uia)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := h.stages[stage].handler(ctx, uia.Params[stage])
+	if err != nil {
+		return stage, nil, fmt.Errorf("failed to complete %s stage: %w", stage, err)
+	} else if data == nil {
+		data = make(map[string]any)
+	}
+	data["type"] = stage
+	if uia.Session != "" {
+		data["session"] = uia.Session
+	}
+	return stage, data, nil
+}
+
+// Callback returns a [UIACallback] for methods that only support a single round of user-interactive auth,
+// like [Client.UploadCrossSigningKeys]. Errors from stage handlers end the flow.
+func (h *UIAHelper) Callback(ctx context.Context) UIACallback {
+	return func(uia *RespUserInteractive) interface{} {
+		_, data, err := h.NextAuth(ctx, uia)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+}
+
+func parseUIAResponse(content []byte, err error) *RespUserInteractive {
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) || !httpErr.IsStatus(http.StatusUnauthorized) {
+		return nil
+	}
+	var uia RespUserInteractive
+	if json.Unmarshal(content, &uia) != nil || len(uia.Flows) == 0 {
+		return nil
+	}
+	return &uia
+}
+
+// Do calls the given request function until the server stops asking for user-interactive auth.
+// The function is first called with nil auth data (as an untyped nil), and must return the response body and error of the request.
+// The response body of the final request is returned.
+func (h *UIAHelper) Do(ctx context.Context, fn func(ctx context.Context, auth any) ([]byte, error)) ([]byte, error) {
+	var auth map[string]any
+	var prevStage AuthType
+	var pollStart time.Time
+	for {
+		var content []byte
+		var err error
+		if auth == nil {
+			content, err = fn(ctx, nil)
+		} else {
+			content, err = fn(ctx, auth)
+		}
+		uia := parseUIAResponse(content, err)
+		if uia == nil {
+			return content, err
+		}
+		stage, nextErr := h.NextStage(uia)
+		if nextErr != nil {
+			return nil, nextErr
+		} else if stage == prevStage && auth != nil {
+			if !h.stages[stage].poll {
+				return nil, fmt.Errorf("%w: %s: %w", ErrUIAStageFailed, stage, err)
+			} else if pollStart.IsZero() {
+				pollStart = time.Now()
+			} else if time.Since(pollStart) > h.PollTimeout {
+				return nil, fmt.Errorf("%w (%s)", ErrUIAPollTimeout, stage)
+			}
+			select {
+			case <-time.After(h.PollInterval):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			auth["session"] = uia.Session
+			continue
+		}
+		pollStart = time.Time{}
+		prevStage, auth, err = h.NextAuth(ctx, uia)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
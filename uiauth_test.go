@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+type uiaTestServer struct {
+	lock         sync.Mutex
+	flows        [][]mautrix.AuthType
+	completed    []string
+	emailPolls   int
+	emailClicked int
+	auths        []map[string]any
+}
+
+func (uts *uiaTestServer) writeUIA(w http.ResponseWriter, errcode string) {
+	flows := make([]mautrix.UIAFlow, len(uts.flows))
+	for i, stages := range uts.flows {
+		flows[i] = mautrix.UIAFlow{Stages: stages}
+	}
+	resp := map[string]any{
+		"flows":     flows,
+		"params":    map[string]any{"m.login.recaptcha": map[string]any{"public_key": "site-key"}},
+		"session":   "sess",
+		"completed": uts.completed,
+	}
+	if errcode != "" {
+		resp["errcode"] = errcode
+		resp["error"] = "Stage not completed"
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newUIATestClient(t *testing.T, uts *uiaTestServer) *mautrix.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uts.lock.Lock()
+		defer uts.lock.Unlock()
+		var req struct {
+			Auth map[string]any `json:"auth"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Auth == nil {
+			uts.writeUIA(w, "")
+			return
+		}
+		uts.auths = append(uts.auths, req.Auth)
+		stage, _ := req.Auth["type"].(string)
+		ok := false
+		switch stage {
+		case "m.login.recaptcha":
+			ok = req.Auth["response"] == "captcha-token"
+		case "m.login.email.identity":
+			creds, _ := req.Auth["threepid_creds"].(map[string]any)
+			uts.emailPolls++
+			ok = creds["sid"] == "sid1" && uts.emailPolls > uts.emailClicked
+		case "com.example.custom":
+			ok = req.Auth["answer"] == "42"
+		}
+		if ok && req.Auth["session"] == "sess" && !slices.Contains(uts.completed, stage) {
+			uts.completed = append(uts.completed, stage)
+		}
+		for _, stages := range uts.flows {
+			if !slices.ContainsFunc(stages, func(stage mautrix.AuthType) bool {
+				return !slices.Contains(uts.completed, string(stage))
+			}) {
+				_, _ = w.Write([]byte(`{"user_id":"@alice:example.com","access_token":"abc","device_id":"DEV"}`))
+				return
+			}
+		}
+		if ok {
+			uts.writeUIA(w, "")
+		} else {
+			uts.writeUIA(w, "M_UNAUTHORIZED")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "", "")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestClient_RegisterWithUIA(t *testing.T) {
+	uts := &uiaTestServer{
+		flows: [][]mautrix.AuthType{
+			{mautrix.AuthTypeMSISDN},
+			{mautrix.AuthTypeReCAPTCHA, mautrix.AuthTypeEmail, "com.example.custom"},
+		},
+		emailClicked: 2,
+	}
+	cli := newUIATestClient(t, uts)
+	helper := mautrix.NewUIAHelper()
+	helper.PollInterval = time.Millisecond
+	var gotSiteKey string
+	helper.HandleReCAPTCHA(func(ctx context.Context, siteKey string) (string, error) {
+		gotSiteKey = siteKey
+		return "captcha-token", nil
+	})
+	helper.HandleEmailIdentity(mautrix.ThreePIDCredentials{SID: "sid1", ClientSecret: "secret"})
+	helper.HandleStage("com.example.custom", func(ctx context.Context, params any) (map[string]any, error) {
+		return map[string]any{"answer": "42"}, nil
+	})
+	resp, err := cli.RegisterWithUIA(context.Background(), &mautrix.ReqRegister{Username: "alice", Password: "pass"}, helper)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", resp.AccessToken)
+	assert.Equal(t, "site-key", gotSiteKey)
+	assert.Equal(t, 3, uts.emailPolls)
+	assert.Len(t, uts.auths, 5)
+}
+
+func TestUIAHelper_UnsupportedFlow(t *testing.T) {
+	uts := &uiaTestServer{flows: [][]mautrix.AuthType{{mautrix.AuthTypeReCAPTCHA}}}
+	cli := newUIATestClient(t, uts)
+	_, err := cli.RegisterWithUIA(context.Background(), &mautrix.ReqRegister{Username: "alice"}, mautrix.NewUIAHelper())
+	assert.ErrorIs(t, err, mautrix.ErrNoSupportedUIAFlow)
+}
+
+func TestUIAHelper_StageFailed(t *testing.T) {
+	uts := &uiaTestServer{flows: [][]mautrix.AuthType{{mautrix.AuthTypeReCAPTCHA}}}
+	cli := newUIATestClient(t, uts)
+	helper := mautrix.NewUIAHelper()
+	helper.HandleReCAPTCHA(func(ctx context.Context, siteKey string) (string, error) {
+		return "wrong", nil
+	})
+	_, err := cli.RegisterWithUIA(context.Background(), &mautrix.ReqRegister{Username: "alice"}, helper)
+	assert.ErrorIs(t, err, mautrix.ErrUIAStageFailed)
+	assert.Len(t, uts.auths, 1)
+}
@@ -97,6 +97,16 @@ func (saup SynapseAdminURLPath) FullPath() []any {
 // BuildURLWithQuery builds a URL with query parameters in addition to the Client's homeserver
 // and appservice user ID set already.
 func (cli *Client) BuildURLWithQuery(urlPath PrefixableURLPath, urlQuery map[string]string) string {
+	return cli.BuildURLWithFullQuery(urlPath, func(query url.Values) {
+		for k, v := range urlQuery {
+			query.Set(k, v)
+		}
+	})
+}
+
+// BuildURLWithFullQuery builds a URL with the Client's homeserver and appservice user ID set already.
+// The given function is called with the query parameters, which allows adding multiple values for the same key.
+func (cli *Client) BuildURLWithFullQuery(urlPath PrefixableURLPath, fn func(q url.Values)) string {
 	hsURL := *BuildURL(cli.HomeserverURL, urlPath.FullPath()...)
 	query := hsURL.Query()
 	if cli.SetAppServiceUserID {
@@ -106,10 +116,8 @@ func (cli *Client) BuildURLWithQuery(urlPath PrefixableURLPath, urlQuery map[str
 		query.Set("device_id", string(cli.DeviceID))
 		query.Set("org.matrix.msc3202.device_id", string(cli.DeviceID))
 	}
-	if urlQuery != nil {
-		for k, v := range urlQuery {
-			query.Set(k, v)
-		}
+	if fn != nil {
+		fn(query)
 	}
 	hsURL.RawQuery = query.Encode()
 	return hsURL.String()
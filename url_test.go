@@ -7,6 +7,7 @@
 package mautrix_test
 
 import (
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -63,3 +64,12 @@ func TestClient_BuildURL_MissingSchemeWithPath(t *testing.T) {
 	built := cli.BuildClientURL("v3", "foo/bar%2F🐈 1", "hello", "world")
 	assert.Equal(t, "https://example.com/base/_matrix/client/v3/foo%2Fbar%252F%F0%9F%90%88%201/hello/world", built)
 }
+
+func TestClient_BuildURLWithFullQuery(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "", "")
+	assert.NoError(t, err)
+	built := cli.BuildURLWithFullQuery(mautrix.ClientURLPath{"v1", "summary", "#foo:example.com"}, func(q url.Values) {
+		q["via"] = []string{"example.com", "example.org"}
+	})
+	assert.Equal(t, "https://example.com/_matrix/client/v1/summary/%23foo:example.com?via=example.com&via=example.org", built)
+}
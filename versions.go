@@ -60,9 +60,13 @@ type UnstableFeature struct {
 }
 
 var (
-	FeatureAsyncUploads       = UnstableFeature{UnstableFlag: "fi.mau.msc2246.stable", SpecVersion: SpecV17}
-	FeatureAppservicePing     = UnstableFeature{UnstableFlag: "fi.mau.msc2659.stable", SpecVersion: SpecV17}
-	FeatureAuthenticatedMedia = UnstableFeature{UnstableFlag: "org.matrix.msc3916.stable", SpecVersion: SpecV111}
+	FeatureAsyncUploads          = UnstableFeature{UnstableFlag: "fi.mau.msc2246.stable", SpecVersion: SpecV17}
+	FeatureAppservicePing        = UnstableFeature{UnstableFlag: "fi.mau.msc2659.stable", SpecVersion: SpecV17}
+	FeatureAuthenticatedMedia    = UnstableFeature{UnstableFlag: "org.matrix.msc3916.stable", SpecVersion: SpecV111}
+	FeatureRoomSummary           = UnstableFeature{UnstableFlag: "im.nheko.summary"}
+	FeatureMassRedaction         = UnstableFeature{UnstableFlag: "org.matrix.msc2244"}
+	FeatureRelationRecursion     = UnstableFeature{UnstableFlag: "org.matrix.msc3981", SpecVersion: SpecV110}
+	FeatureSimplifiedSlidingSync = UnstableFeature{UnstableFlag: "org.matrix.simplified_msc3575"}
 
 	BeeperFeatureHungry               = UnstableFeature{UnstableFlag: "com.beeper.hungry"}
 	BeeperFeatureBatchSending         = UnstableFeature{UnstableFlag: "com.beeper.batch_sending"}